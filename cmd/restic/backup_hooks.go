@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/restic/restic/internal/archiver"
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/ui/backup"
+)
+
+// backupHookContext is passed as JSON on stdin to the backup hooks.
+type backupHookContext struct {
+	Hook       string             `json:"hook"` // "pre-hook", "post-hook" or "on-error-hook"
+	Targets    []string           `json:"targets"`
+	DryRun     bool               `json:"dry_run,omitempty"`
+	SnapshotID string             `json:"snapshot_id,omitempty"`
+	Summary    *backupHookSummary `json:"summary,omitempty"`
+	Error      string             `json:"error,omitempty"`
+}
+
+// backupHookSummary contains the statistics of the backup, the fields match
+// those of the JSON summary message.
+type backupHookSummary struct {
+	FilesNew            uint      `json:"files_new"`
+	FilesChanged        uint      `json:"files_changed"`
+	FilesUnmodified     uint      `json:"files_unmodified"`
+	DirsNew             uint      `json:"dirs_new"`
+	DirsChanged         uint      `json:"dirs_changed"`
+	DirsUnmodified      uint      `json:"dirs_unmodified"`
+	DataBlobs           int       `json:"data_blobs"`
+	TreeBlobs           int       `json:"tree_blobs"`
+	DataAdded           uint64    `json:"data_added"`
+	DataAddedPacked     uint64    `json:"data_added_packed"`
+	TotalFilesProcessed uint      `json:"total_files_processed"`
+	TotalBytesProcessed uint64    `json:"total_bytes_processed"`
+	BackupStart         time.Time `json:"backup_start"`
+	BackupEnd           time.Time `json:"backup_end"`
+}
+
+func newBackupHookSummary(summary *archiver.Summary) *backupHookSummary {
+	if summary == nil {
+		return nil
+	}
+	return &backupHookSummary{
+		FilesNew:            summary.Files.New,
+		FilesChanged:        summary.Files.Changed,
+		FilesUnmodified:     summary.Files.Unchanged,
+		DirsNew:             summary.Dirs.New,
+		DirsChanged:         summary.Dirs.Changed,
+		DirsUnmodified:      summary.Dirs.Unchanged,
+		DataBlobs:           summary.ItemStats.DataBlobs,
+		TreeBlobs:           summary.ItemStats.TreeBlobs,
+		DataAdded:           summary.ItemStats.DataSize + summary.ItemStats.TreeSize,
+		DataAddedPacked:     summary.ItemStats.DataSizeInRepo + summary.ItemStats.TreeSizeInRepo,
+		TotalFilesProcessed: summary.Files.New + summary.Files.Changed + summary.Files.Unchanged,
+		TotalBytesProcessed: summary.ProcessedBytes,
+		BackupStart:         summary.BackupStart,
+		BackupEnd:           summary.BackupEnd,
+	}
+}
+
+// runBackupHook runs the command of a hook and passes hookCtx as JSON on stdin.
+// The command is split into arguments like a shell would, but it is not run by
+// a shell. The output of the command is forwarded to printer, the lines printed
+// to stdout are returned.
+func runBackupHook(ctx context.Context, command string, hookCtx backupHookContext, printer backup.ProgressPrinter) ([]string, error) {
+	args, err := backend.SplitShellStrings(command)
+	if err != nil {
+		return nil, errors.Fatalf("invalid %v: %v", hookCtx.Hook, err)
+	}
+	if len(args) == 0 {
+		return nil, errors.Fatalf("%v is empty", hookCtx.Hook)
+	}
+
+	input, err := json.Marshal(hookCtx)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = bytes.NewReader(input)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, errors.Fatalf("failed to start %v: %v", hookCtx.Hook, err)
+	}
+
+	var wg sync.WaitGroup
+	var lines []string
+	forward := func(rd io.Reader, stream string, collect bool) {
+		defer wg.Done()
+		sc := bufio.NewScanner(rd)
+		for sc.Scan() {
+			printer.HookOutput(hookCtx.Hook, stream, sc.Text())
+			if collect {
+				lines = append(lines, sc.Text())
+			}
+		}
+	}
+	wg.Add(2)
+	go forward(stdout, "stdout", true)
+	go forward(stderr, "stderr", false)
+	// all output must be read before calling Wait
+	wg.Wait()
+
+	if err := cmd.Wait(); err != nil {
+		return lines, errors.Fatalf("%v failed: %v", hookCtx.Hook, err)
+	}
+	return lines, nil
+}
+
+// hookTags returns the non-empty lines as snapshot tags.
+func hookTags(lines []string) []string {
+	var tags []string
+	for _, line := range lines {
+		if tag := strings.TrimSpace(line); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// runPostBackupHooks runs the on-error hook if the backup failed and then the
+// post hook. The returned error is backupErr or the error of the post hook.
+func runPostBackupHooks(ctx context.Context, opts BackupOptions, targets []string, id restic.ID, summary *archiver.Summary, backupErr error, printer backup.ProgressPrinter) error {
+	// the hooks must also run if the backup was interrupted
+	ctx = context.WithoutCancel(ctx)
+
+	hookCtx := backupHookContext{
+		Targets: targets,
+		DryRun:  opts.DryRun,
+		Summary: newBackupHookSummary(summary),
+	}
+	if !id.IsNull() {
+		hookCtx.SnapshotID = id.String()
+	}
+	if backupErr != nil {
+		hookCtx.Error = backupErr.Error()
+	}
+
+	if backupErr != nil && opts.OnErrorHook != "" {
+		hookCtx.Hook = "on-error-hook"
+		if _, err := runBackupHook(ctx, opts.OnErrorHook, hookCtx, printer); err != nil {
+			printer.E("%v", err)
+		}
+	}
+
+	if opts.PostHook != "" {
+		hookCtx.Hook = "post-hook"
+		if _, err := runBackupHook(ctx, opts.PostHook, hookCtx, printer); err != nil {
+			if backupErr == nil {
+				return err
+			}
+			printer.E("%v", err)
+		}
+	}
+	return backupErr
+}
@@ -10,6 +10,7 @@ import (
 	"path"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -19,6 +20,7 @@ import (
 	"golang.org/x/sync/errgroup"
 
 	"github.com/restic/restic/internal/archiver"
+	"github.com/restic/restic/internal/backend/sftp"
 	"github.com/restic/restic/internal/data"
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/errors"
@@ -60,6 +62,12 @@ Exit status is 12 if the password is incorrect.
 				}
 				opts.ReadConcurrency = uint(n)
 			}
+			if opts.Host == "" && opts.Source != "" {
+				// snapshots of a remote source belong to the remote host
+				if cfg, err := parseSource(opts.Source); err == nil {
+					opts.Host = cfg.Host
+				}
+			}
 			if opts.Host == "" {
 				hostname, err := os.Hostname()
 				if err != nil {
@@ -85,31 +93,45 @@ Exit status is 12 if the password is incorrect.
 type BackupOptions struct {
 	filter.ExcludePatternOptions
 
-	Parent            string
-	GroupBy           data.SnapshotGroupByOptions
-	Force             bool
-	ExcludeOtherFS    bool
-	ExcludeIfPresent  []string
-	ExcludeCaches     bool
-	ExcludeLargerThan string
-	ExcludeCloudFiles bool
-	Stdin             bool
-	StdinFilename     string
-	StdinCommand      bool
-	Tags              data.TagLists
-	Host              string
-	FilesFrom         []string
-	FilesFromVerbatim []string
-	FilesFromRaw      []string
-	TimeStamp         string
-	WithAtime         bool
-	IgnoreInode       bool
-	IgnoreCtime       bool
-	UseFsSnapshot     bool
-	DryRun            bool
-	ReadConcurrency   uint
-	NoScan            bool
-	SkipIfUnchanged   bool
+	Parent             string
+	GroupBy            data.SnapshotGroupByOptions
+	Force              bool
+	ExcludeOtherFS     bool
+	ExcludeIfPresent   []string
+	ExcludeCaches      bool
+	ExcludeIgnoreFile  []string
+	ExcludeGitignore   bool
+	ExcludeLargerThan  string
+	ExcludeCloudFiles  bool
+	Stdin              bool
+	StdinFilename      string
+	StdinCommand       bool
+	StdinTar           bool
+	ArchiveFile        string
+	Source             string
+	CheckpointInterval time.Duration
+	NoCompressExt      []string
+	CompressExt        []string
+	OnFileChange       archiver.FileChangeMode
+	FileChangeRetries  uint
+	PreHook            string
+	PostHook           string
+	OnErrorHook        string
+	HookTags           bool
+	Tags               data.TagLists
+	Host               string
+	FilesFrom          []string
+	FilesFromVerbatim  []string
+	FilesFromRaw       []string
+	TimeStamp          string
+	WithAtime          bool
+	IgnoreInode        bool
+	IgnoreCtime        bool
+	UseFsSnapshot      bool
+	DryRun             bool
+	ReadConcurrency    uint
+	NoScan             bool
+	SkipIfUnchanged    bool
 
 	readConcurrencyFlag *pflag.Flag
 }
@@ -125,10 +147,15 @@ func (opts *BackupOptions) AddFlags(f *pflag.FlagSet) {
 	f.BoolVarP(&opts.ExcludeOtherFS, "one-file-system", "x", false, "exclude other file systems, don't cross filesystem boundaries and subvolumes")
 	f.StringArrayVar(&opts.ExcludeIfPresent, "exclude-if-present", nil, "takes `filename[:header]`, exclude contents of directories containing filename (except filename itself) if header of that file is as provided (can be specified multiple times)")
 	f.BoolVar(&opts.ExcludeCaches, "exclude-caches", false, `excludes cache directories that are marked with a CACHEDIR.TAG file. See https://bford.info/cachedir/ for the Cache Directory Tagging Standard`)
+	f.StringArrayVar(&opts.ExcludeIgnoreFile, "exclude-ignore-file", nil, "exclude items matching the patterns in ignore files with this `name`, evaluated per directory with .gitignore semantics (can be specified multiple times)")
+	f.BoolVar(&opts.ExcludeGitignore, "exclude-gitignore", false, "exclude items matched by .gitignore files (same as --exclude-ignore-file .gitignore)")
 	f.StringVar(&opts.ExcludeLargerThan, "exclude-larger-than", "", "max `size` of the files to be backed up (allowed suffixes: k/K, m/M, g/G, t/T)")
 	f.BoolVar(&opts.Stdin, "stdin", false, "read backup from stdin")
 	f.StringVar(&opts.StdinFilename, "stdin-filename", "stdin", "`filename` to use when reading from stdin")
 	f.BoolVar(&opts.StdinCommand, "stdin-from-command", false, "interpret arguments as command to execute and store its stdout")
+	f.BoolVar(&opts.StdinTar, "stdin-tar", false, "read a tar archive from stdin (or the command of --stdin-from-command) and store its entries as files below --stdin-filename")
+	f.StringVar(&opts.ArchiveFile, "archive-file", "", "store the entries of a tar or zip archive `file` instead of backing up files/dirs")
+	f.StringVar(&opts.Source, "source", "", "back up the directory at the remote `location` (sftp:user@host:/path) instead of local files/dirs (default hostname: the remote host)")
 	f.Var(&opts.Tags, "tag", "add `tags` for the new snapshot in the format `tag[,tag,...]` (can be specified multiple times)")
 	f.UintVar(&opts.ReadConcurrency, "read-concurrency", 0, "read `n` files concurrently (default: $RESTIC_READ_CONCURRENCY or 2)")
 	f.StringVarP(&opts.Host, "host", "H", "", "set the `hostname` for the snapshot manually (default: $RESTIC_HOST). To prevent an expensive rescan use the \"parent\" flag")
@@ -148,12 +175,24 @@ func (opts *BackupOptions) AddFlags(f *pflag.FlagSet) {
 	f.BoolVarP(&opts.DryRun, "dry-run", "n", false, "do not upload or write any data, just show what would be done")
 	f.BoolVar(&opts.NoScan, "no-scan", false, "do not run scanner to estimate size of backup")
 	if runtime.GOOS == "windows" {
-		f.BoolVar(&opts.UseFsSnapshot, "use-fs-snapshot", false, "use filesystem snapshot where possible (currently only Windows VSS)")
+		f.BoolVar(&opts.UseFsSnapshot, "use-fs-snapshot", false, "use filesystem snapshot where possible (Windows VSS)")
+	}
+	if runtime.GOOS == "linux" {
+		f.BoolVar(&opts.UseFsSnapshot, "use-fs-snapshot", false, "read files from filesystem snapshots (btrfs, LVM or a custom command, see -o fs-snapshot.*)")
 	}
 	if runtime.GOOS == "windows" || runtime.GOOS == "darwin" {
 		f.BoolVar(&opts.ExcludeCloudFiles, "exclude-cloud-files", false, "excludes online-only cloud files (such as OneDrive, iCloud drive, …)")
 	}
 	f.BoolVar(&opts.SkipIfUnchanged, "skip-if-unchanged", false, "skip snapshot creation if identical to parent snapshot")
+	f.StringArrayVar(&opts.NoCompressExt, "no-compress-ext", nil, "store the content of files with this `extension` (e.g. jpg) uncompressed (can be specified multiple times)")
+	f.StringArrayVar(&opts.CompressExt, "compress-ext", nil, "always compress the content of files with this `extension`, even if it appears to be incompressible (can be specified multiple times)")
+	f.Var(&opts.OnFileChange, "on-file-change", "how to handle files modified while reading them: read them again and store the last copy with a warning, only warn or fail (retry|warn|fail)")
+	f.UintVar(&opts.FileChangeRetries, "file-change-retries", 3, "read files modified while reading them at most `n` more times (for --on-file-change retry and fail)")
+	f.StringVar(&opts.PreHook, "pre-hook", "", "run `command` without a shell before reading any files, the backup is aborted if it fails")
+	f.StringVar(&opts.PostHook, "post-hook", "", "run `command` without a shell after the backup, also if the backup or the pre-hook failed")
+	f.StringVar(&opts.OnErrorHook, "on-error-hook", "", "run `command` without a shell if the backup failed, before the post-hook")
+	f.BoolVar(&opts.HookTags, "hook-tags", false, "add the lines printed to stdout by the pre-hook as tags to the snapshot")
+	f.DurationVar(&opts.CheckpointInterval, "checkpoint-interval", 0, "save a checkpoint snapshot of the completed part of the backup every `duration` (e.g. 30m), used as parent by the next backup if this one is interrupted")
 
 	opts.readConcurrencyFlag = f.Lookup("read-concurrency")
 
@@ -299,6 +338,37 @@ func (opts BackupOptions) Check(gopts global.Options, args []string) error {
 		}
 	}
 
+	if opts.ArchiveFile != "" {
+		if opts.Stdin || opts.StdinCommand || opts.StdinTar {
+			return errors.Fatal("--archive-file and --stdin cannot be used together")
+		}
+		if len(opts.FilesFrom) > 0 || len(opts.FilesFromVerbatim) > 0 || len(opts.FilesFromRaw) > 0 {
+			return errors.Fatal("--archive-file and --files-from cannot be used together")
+		}
+		if len(args) > 0 {
+			return errors.Fatal("--archive-file was specified and files/dirs were listed as arguments")
+		}
+	}
+
+	if opts.HookTags && opts.PreHook == "" {
+		return errors.Fatal("--hook-tags requires --pre-hook")
+	}
+
+	if opts.Source != "" {
+		if opts.Stdin || opts.StdinCommand || opts.ArchiveFile != "" {
+			return errors.Fatal("--source cannot be used together with --stdin or --archive-file")
+		}
+		if len(opts.FilesFrom) > 0 || len(opts.FilesFromVerbatim) > 0 || len(opts.FilesFromRaw) > 0 {
+			return errors.Fatal("--source and --files-from cannot be used together")
+		}
+		if len(args) > 0 {
+			return errors.Fatal("--source was specified and files/dirs were listed as arguments")
+		}
+		if _, err := parseSource(opts.Source); err != nil {
+			return err
+		}
+	}
+
 	if opts.Stdin || opts.StdinCommand {
 		if len(opts.FilesFrom) > 0 {
 			return errors.Fatal("--stdin and --files-from cannot be used together")
@@ -391,9 +461,100 @@ func collectRejectFuncs(opts BackupOptions, targets []string, fs fs.FS, warnf fu
 	return funcs, nil
 }
 
+// ignoreFiles returns the names of the ignore files which are evaluated while
+// walking the backup targets.
+func (opts BackupOptions) ignoreFiles() []string {
+	if (opts.Stdin || opts.StdinCommand) && !opts.StdinTar {
+		return nil
+	}
+	names := opts.ExcludeIgnoreFile
+	if opts.ExcludeGitignore && !slices.Contains(names, ".gitignore") {
+		names = append(slices.Clone(names), ".gitignore")
+	}
+	return names
+}
+
+// compressionHint returns a function which selects the compression hint for a
+// file based on its extension, or nil if no extensions are configured.
+func (opts BackupOptions) compressionHint() func(filename string) restic.CompressionHint {
+	if len(opts.NoCompressExt) == 0 && len(opts.CompressExt) == 0 {
+		return nil
+	}
+
+	hints := make(map[string]restic.CompressionHint)
+	normalize := func(ext string) string {
+		return strings.ToLower(strings.TrimPrefix(ext, "."))
+	}
+	for _, ext := range opts.CompressExt {
+		hints[normalize(ext)] = restic.CompressionHintForce
+	}
+	for _, ext := range opts.NoCompressExt {
+		hints[normalize(ext)] = restic.CompressionHintSkip
+	}
+
+	return func(filename string) restic.CompressionHint {
+		// the hint for unknown extensions is CompressionHintAuto
+		return hints[normalize(filepath.Ext(filename))]
+	}
+}
+
+// archiveRootName returns the name of the directory which contains the entries
+// of the archive file.
+func archiveRootName(filename string) string {
+	name := filepath.Base(filename)
+	for _, ext := range []string{".tar.gz", ".tgz", ".tar", ".zip"} {
+		if trimmed, ok := strings.CutSuffix(strings.ToLower(name), ext); ok && trimmed != "" {
+			return name[:len(trimmed)]
+		}
+	}
+	return name
+}
+
+// parseSource parses the location of a remote backup source. Only sftp
+// locations are supported.
+func parseSource(source string) (*sftp.Config, error) {
+	if !strings.HasPrefix(source, "sftp:") {
+		return nil, errors.Fatalf("invalid source %q, only sftp: locations are supported", source)
+	}
+	cfg, err := sftp.ParseConfig(source)
+	if err != nil {
+		return nil, errors.Fatalf("invalid source %q: %v", source, err)
+	}
+	return cfg, nil
+}
+
+// openSource connects to the remote backup source and returns the file system
+// and the absolute path of the source directory on the remote host. The
+// extended options for sftp (-o sftp.*) also apply to the source.
+func openSource(opts BackupOptions, gopts global.Options, errorLog func(string, ...interface{})) (*sftp.Session, *fs.SFTPFS, string, error) {
+	cfg, err := parseSource(opts.Source)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if err := gopts.Extended.Extract("sftp").Apply("sftp", cfg); err != nil {
+		return nil, nil, "", err
+	}
+
+	session, err := sftp.Dial(*cfg, errorLog)
+	if err != nil {
+		return nil, nil, "", errors.Fatalf("unable to connect to source %v: %v", opts.Source, err)
+	}
+	sourceFS, err := fs.NewSFTP(session.Client())
+	if err != nil {
+		_ = session.Close()
+		return nil, nil, "", err
+	}
+	target, err := sourceFS.Abs(cfg.Path)
+	if err != nil {
+		_ = session.Close()
+		return nil, nil, "", err
+	}
+	return session, sourceFS, target, nil
+}
+
 // collectTargets returns a list of target files/dirs from several sources.
 func collectTargets(opts BackupOptions, args []string, warnf func(msg string, args ...interface{}), stdin io.ReadCloser) (targets []string, err error) {
-	if opts.Stdin || opts.StdinCommand {
+	if opts.Stdin || opts.StdinCommand || opts.ArchiveFile != "" || opts.Source != "" {
 		return nil, nil
 	}
 
@@ -464,7 +625,8 @@ func findParentSnapshot(ctx context.Context, repo restic.ListerLoaderUnpacked, o
 	if snName == "" {
 		snName = "latest"
 	}
-	f := data.SnapshotFilter{TimestampLimit: timeStampLimit}
+	// the checkpoint of an interrupted backup is used to continue it
+	f := data.SnapshotFilter{TimestampLimit: timeStampLimit, IncludeCheckpoints: true}
 	if opts.GroupBy.Host {
 		f.Hosts = []string{opts.Host}
 	}
@@ -483,8 +645,9 @@ func findParentSnapshot(ctx context.Context, repo restic.ListerLoaderUnpacked, o
 	return sn, err
 }
 
-func runBackup(ctx context.Context, opts BackupOptions, gopts global.Options, term ui.Terminal, args []string) error {
+func runBackup(ctx context.Context, opts BackupOptions, gopts global.Options, term ui.Terminal, args []string) (retErr error) {
 	var vsscfg fs.VSSConfig
+	var fsSnapshotCfg fs.FSSnapshotConfig
 	var err error
 
 	var printer backup.ProgressPrinter
@@ -498,6 +661,16 @@ func runBackup(ctx context.Context, opts BackupOptions, gopts global.Options, te
 			return err
 		}
 	}
+	if runtime.GOOS == "linux" {
+		if fsSnapshotCfg, err = fs.ParseFSSnapshotConfig(gopts.Extended); err != nil {
+			return err
+		}
+	}
+
+	if opts.StdinTar && !opts.StdinCommand {
+		// the archive is read from stdin
+		opts.Stdin = true
+	}
 
 	err = opts.Check(gopts, args)
 	if err != nil {
@@ -514,6 +687,22 @@ func runBackup(ctx context.Context, opts BackupOptions, gopts global.Options, te
 		}
 	}
 
+	var sourceFS *fs.SFTPFS
+	if opts.Source != "" {
+		if gopts.Verbosity >= 2 && !gopts.JSON {
+			printer.P("connect to source %v", opts.Source)
+		}
+		session, remoteFS, target, err := openSource(opts, gopts, printer.E)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = session.Close()
+		}()
+		sourceFS = remoteFS
+		targets = []string{target}
+	}
+
 	timeStamp := time.Now()
 	backupStart := timeStamp
 	if opts.TimeStamp != "" {
@@ -536,6 +725,16 @@ func runBackup(ctx context.Context, opts BackupOptions, gopts global.Options, te
 	progressReporter := backup.NewProgress(printer, gopts.Quiet, gopts.JSON, term.CanUpdateStatus())
 	defer progressReporter.Done()
 
+	// the post-hook also runs if the backup fails before the pre-hook, for
+	// example while loading the index
+	var hookID restic.ID
+	var hookSummary *archiver.Summary
+	if opts.PreHook != "" || opts.PostHook != "" || opts.OnErrorHook != "" {
+		defer func() {
+			retErr = runPostBackupHooks(ctx, opts, targets, hookID, hookSummary, retErr, printer)
+		}()
+	}
+
 	// rejectByNameFuncs collect functions that can reject items from the backup based on path only
 	rejectByNameFuncs, err := collectRejectByNameFuncs(opts, repo, printer.E)
 	if err != nil {
@@ -543,7 +742,8 @@ func runBackup(ctx context.Context, opts BackupOptions, gopts global.Options, te
 	}
 
 	var parentSnapshot *data.Snapshot
-	if !opts.Stdin {
+	// the metadata of archive entries is not sufficient to detect changes
+	if !opts.Stdin && !opts.StdinTar && opts.ArchiveFile == "" {
 		parentSnapshot, err = findParentSnapshot(ctx, repo, opts, targets, timeStamp)
 		if err != nil {
 			return err
@@ -567,12 +767,22 @@ func runBackup(ctx context.Context, opts BackupOptions, gopts global.Options, te
 		return err
 	}
 
-	targetFS := fs.NewLocal()
-	if runtime.GOOS == "windows" && opts.UseFsSnapshot {
-		if err = fs.HasSufficientPrivilegesForVSS(); err != nil {
+	if opts.PreHook != "" {
+		lines, err := runBackupHook(ctx, opts.PreHook, backupHookContext{
+			Hook:    "pre-hook",
+			Targets: targets,
+			DryRun:  opts.DryRun,
+		}, printer)
+		if err != nil {
 			return err
 		}
+		if opts.HookTags {
+			opts.Tags = append(opts.Tags, hookTags(lines))
+		}
+	}
 
+	targetFS := fs.NewLocal()
+	if opts.UseFsSnapshot && (runtime.GOOS == "windows" || runtime.GOOS == "linux") {
 		errorHandler := func(item string, err error) {
 			_ = progressReporter.Error(item, err)
 		}
@@ -583,9 +793,21 @@ func runBackup(ctx context.Context, opts BackupOptions, gopts global.Options, te
 			}
 		}
 
-		localVss := fs.NewLocalVss(errorHandler, messageHandler, vsscfg)
-		defer localVss.DeleteSnapshots()
-		targetFS = localVss
+		var snapshotFS fs.SnapshotFS
+		if runtime.GOOS == "windows" {
+			if err = fs.HasSufficientPrivilegesForVSS(); err != nil {
+				return err
+			}
+			snapshotFS = fs.NewLocalVss(errorHandler, messageHandler, vsscfg)
+		} else {
+			provider, err := fs.NewSnapshotProvider(fsSnapshotCfg)
+			if err != nil {
+				return err
+			}
+			snapshotFS = fs.NewLocalSnapshot(provider, errorHandler, messageHandler)
+		}
+		defer snapshotFS.DeleteSnapshots()
+		targetFS = snapshotFS
 	}
 
 	if opts.Stdin || opts.StdinCommand {
@@ -600,16 +822,51 @@ func runBackup(ctx context.Context, opts BackupOptions, gopts global.Options, te
 				return err
 			}
 		}
-		targetFS, err = fs.NewReader(filename, source, fs.ReaderOptions{
-			ModTime: timeStamp,
-			Mode:    0644,
-		})
+		if opts.StdinTar {
+			archiveFS, err := fs.NewTarStream(source, fs.ArchiveOptions{Root: filename, ModTime: timeStamp})
+			cerr := source.Close()
+			if err == nil {
+				err = cerr
+			}
+			if err != nil {
+				return fmt.Errorf("failed to backup tar archive from stdin: %w", err)
+			}
+			defer func() {
+				_ = archiveFS.Close()
+			}()
+			targetFS = archiveFS
+		} else {
+			targetFS, err = fs.NewReader(filename, source, fs.ReaderOptions{
+				ModTime: timeStamp,
+				Mode:    0644,
+			})
+			if err != nil {
+				return fmt.Errorf("failed to backup from stdin: %w", err)
+			}
+		}
+		targets = []string{filename}
+	}
+
+	if opts.ArchiveFile != "" {
+		if !gopts.JSON {
+			printer.V("read archive %v", opts.ArchiveFile)
+		}
+		filename := path.Join("/", archiveRootName(opts.ArchiveFile))
+		archiveFS, err := fs.OpenArchive(opts.ArchiveFile, fs.ArchiveOptions{Root: filename, ModTime: timeStamp})
 		if err != nil {
-			return fmt.Errorf("failed to backup from stdin: %w", err)
+			return err
 		}
+		defer func() {
+			_ = archiveFS.Close()
+		}()
+		targetFS = archiveFS
 		targets = []string{filename}
 	}
 
+	if sourceFS != nil {
+		targetFS = sourceFS
+	}
+
 	if backupFSTestHook != nil {
 		targetFS = backupFSTestHook(targetFS)
 	}
@@ -631,6 +888,7 @@ func runBackup(ctx context.Context, opts BackupOptions, gopts global.Options, te
 		sc := archiver.NewScanner(targetFS)
 		sc.SelectByName = selectByNameFilter
 		sc.Select = selectFilter
+		sc.IgnoreFiles = opts.ignoreFiles()
 		sc.Error = printer.ScannerError
 		sc.Result = progressReporter.ReportTotal
 
@@ -643,7 +901,9 @@ func runBackup(ctx context.Context, opts BackupOptions, gopts global.Options, te
 	arch := archiver.New(repo, targetFS, archiver.Options{ReadConcurrency: opts.ReadConcurrency})
 	arch.SelectByName = selectByNameFilter
 	arch.Select = selectFilter
+	arch.IgnoreFiles = opts.ignoreFiles()
 	arch.WithAtime = opts.WithAtime
+	arch.CompressionHint = opts.compressionHint()
 
 	arch.Error = func(item string, err error) error {
 		success = false
@@ -658,6 +918,9 @@ func runBackup(ctx context.Context, opts BackupOptions, gopts global.Options, te
 	arch.CompleteItem = progressReporter.CompleteItem
 	arch.StartFile = progressReporter.StartFile
 	arch.CompleteBlob = progressReporter.CompleteBlob
+	arch.FileChanged = progressReporter.FileChanged
+	arch.OnFileChange = opts.OnFileChange
+	arch.FileChangeRetries = opts.FileChangeRetries
 
 	if opts.IgnoreInode {
 		// --ignore-inode implies --ignore-ctime: on FUSE, the ctime is not
@@ -679,10 +942,29 @@ func runBackup(ctx context.Context, opts BackupOptions, gopts global.Options, te
 		SkipIfUnchanged: opts.SkipIfUnchanged,
 	}
 
+	var lastCheckpoint *data.Snapshot
+	var lastCheckpointID restic.ID
+	if opts.CheckpointInterval > 0 && !opts.DryRun {
+		snapshotOpts.CheckpointInterval = opts.CheckpointInterval
+		arch.CompleteCheckpoint = func(sn *data.Snapshot, id restic.ID) {
+			if !gopts.JSON {
+				printer.V("saved checkpoint %v", id.Str())
+			}
+			// only keep the latest checkpoint of this backup
+			if lastCheckpoint != nil {
+				if err := repo.RemoveUnpacked(ctx, restic.WriteableSnapshotFile, lastCheckpointID); err != nil {
+					printer.E("unable to remove checkpoint %v: %v", lastCheckpointID.Str(), err)
+				}
+			}
+			lastCheckpoint, lastCheckpointID = sn, id
+		}
+	}
+
 	if !gopts.JSON {
 		printer.V("start backup on %v", targets)
 	}
-	_, id, summary, err := arch.Snapshot(ctx, targets, snapshotOpts)
+	sn, id, summary, err := arch.Snapshot(ctx, targets, snapshotOpts)
+	hookID, hookSummary = id, summary
 
 	// cleanly shutdown all running goroutines
 	cancel()
@@ -695,6 +977,37 @@ func runBackup(ctx context.Context, opts BackupOptions, gopts global.Options, te
 		return errors.Fatalf("unable to save snapshot: %v", err)
 	}
 
+	// the checkpoints of this backup and of the interrupted backup it continues
+	// are obsolete now. Older checkpoints are removed by forget and prune.
+	if sn != nil && !opts.DryRun {
+		checkpoints := make(map[*data.Snapshot]restic.ID)
+		if parentSnapshot != nil && parentSnapshot.IsCheckpoint() {
+			checkpoints[parentSnapshot] = *parentSnapshot.ID()
+		}
+		if lastCheckpoint != nil {
+			checkpoints[lastCheckpoint] = lastCheckpointID
+		}
+		candidates := data.Snapshots{sn}
+		for cp := range checkpoints {
+			candidates = append(candidates, cp)
+		}
+		obsolete := restic.NewIDSet()
+		for _, cp := range data.ObsoleteCheckpoints(candidates) {
+			obsolete.Insert(checkpoints[cp])
+		}
+		if err := repo.RecordSnapshotRemoval(ctx, obsolete); err != nil {
+			printer.E("unable to remove obsolete checkpoints: %v", err)
+			obsolete = nil
+		}
+		for cpID := range obsolete {
+			if err := repo.RemoveUnpacked(ctx, restic.WriteableSnapshotFile, cpID); err != nil {
+				printer.E("unable to remove checkpoint %v: %v", cpID.Str(), err)
+			} else if !gopts.JSON {
+				printer.V("removed obsolete checkpoint %v", cpID.Str())
+			}
+		}
+	}
+
 	// Report finished execution
 	progressReporter.Finish(id, summary, opts.DryRun)
 	if !success {
@@ -1,11 +1,17 @@
 package main
 
 import (
+	"archive/tar"
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"maps"
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
@@ -730,3 +736,179 @@ func TestBackupSkipIfUnchanged(t *testing.T) {
 
 	testRunCheck(t, env.gopts)
 }
+
+func readBackupHookContext(t testing.TB, filename string) backupHookContext {
+	buf, err := os.ReadFile(filename)
+	rtest.OK(t, err)
+	var hookCtx backupHookContext
+	rtest.OK(t, json.Unmarshal(buf, &hookCtx))
+	return hookCtx
+}
+
+func TestBackupHooks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hooks are run using sh")
+	}
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	hookDir := t.TempDir()
+	postFile := filepath.Join(hookDir, "post.json")
+	errorFile := filepath.Join(hookDir, "error.json")
+
+	opts := BackupOptions{
+		PreHook:     "sh -c 'cat > /dev/null && echo db-flushed'",
+		PostHook:    "sh -c 'cat > " + postFile + "'",
+		OnErrorHook: "sh -c 'cat > " + errorFile + "'",
+		HookTags:    true,
+	}
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, opts, env.gopts)
+	snapshotIDs := testListSnapshots(t, env.gopts, 1)
+	sn := testLoadSnapshot(t, env.gopts, snapshotIDs[0])
+	rtest.Equals(t, []string{"db-flushed"}, sn.Tags)
+
+	post := readBackupHookContext(t, postFile)
+	rtest.Equals(t, "post-hook", post.Hook)
+	rtest.Equals(t, []string{"testdata"}, post.Targets)
+	rtest.Equals(t, snapshotIDs[0].String(), post.SnapshotID)
+	rtest.Equals(t, "", post.Error)
+	rtest.Assert(t, post.Summary != nil && post.Summary.FilesNew > 0, "missing summary in %v", post)
+	_, err := os.Stat(errorFile)
+	rtest.Assert(t, errors.Is(err, os.ErrNotExist), "on-error hook was run for a successful backup")
+
+	// a failing pre-hook aborts the backup, but the other hooks still run
+	rtest.OK(t, os.Remove(postFile))
+	opts.PreHook = "sh -c 'exit 3'"
+	err = testRunBackupAssumeFailure(t, filepath.Dir(env.testdata), []string{"testdata"}, opts, env.gopts)
+	rtest.Assert(t, err != nil && strings.Contains(err.Error(), "pre-hook failed"), "unexpected error %v", err)
+	testListSnapshots(t, env.gopts, 1)
+
+	onError := readBackupHookContext(t, errorFile)
+	rtest.Equals(t, "on-error-hook", onError.Hook)
+	rtest.Assert(t, strings.Contains(onError.Error, "pre-hook failed"), "unexpected error %q", onError.Error)
+	post = readBackupHookContext(t, postFile)
+	rtest.Equals(t, "post-hook", post.Hook)
+	rtest.Equals(t, "", post.SnapshotID)
+
+	// a failing post-hook is reported after saving the snapshot
+	opts = BackupOptions{PostHook: "sh -c 'exit 1'"}
+	err = testRunBackupAssumeFailure(t, filepath.Dir(env.testdata), []string{"testdata"}, opts, env.gopts)
+	rtest.Assert(t, err != nil && strings.Contains(err.Error(), "post-hook failed"), "unexpected error %v", err)
+	testListSnapshots(t, env.gopts, 2)
+
+	// the post-hook also runs if the backup fails before running the pre-hook
+	rtest.OK(t, os.Remove(postFile))
+	opts = BackupOptions{Parent: "deadbeef", PostHook: "sh -c 'cat > " + postFile + "'"}
+	err = testRunBackupAssumeFailure(t, filepath.Dir(env.testdata), []string{"testdata"}, opts, env.gopts)
+	rtest.Assert(t, err != nil, "backup with missing parent snapshot succeeded")
+	post = readBackupHookContext(t, postFile)
+	rtest.Equals(t, "post-hook", post.Hook)
+	rtest.Assert(t, post.Error != "", "missing error in %v", post)
+}
+
+func writeTestTar(t testing.TB, filename string, files map[string]string) {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for _, name := range slices.Sorted(maps.Keys(files)) {
+		rtest.OK(t, tw.WriteHeader(&tar.Header{
+			Name:     name,
+			Typeflag: tar.TypeReg,
+			Mode:     0644,
+			Size:     int64(len(files[name])),
+			ModTime:  time.Now(),
+		}))
+		_, err := tw.Write([]byte(files[name]))
+		rtest.OK(t, err)
+	}
+	rtest.OK(t, tw.Close())
+	rtest.OK(t, os.WriteFile(filename, buf.Bytes(), 0644))
+}
+
+func TestBackupArchive(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+	testRunInit(t, env.gopts)
+
+	filename := filepath.Join(env.base, "artifacts.tar")
+	writeTestTar(t, filename, map[string]string{
+		"bin/app":          "binary",
+		"share/doc/README": "documentation",
+	})
+
+	testRunBackup(t, env.base, nil, BackupOptions{ArchiveFile: filename}, env.gopts)
+	opts := BackupOptions{
+		StdinTar:      true,
+		StdinCommand:  true,
+		StdinFilename: "stream",
+	}
+	testRunBackup(t, env.base, []string{"python", "-c", "import sys; sys.stdout.buffer.write(open(sys.argv[1], 'rb').read())", filename}, opts, env.gopts)
+
+	snapshots := testListSnapshots(t, env.gopts, 2)
+	for _, dir := range []string{"/artifacts", "/stream"} {
+		var files []string
+		for _, id := range snapshots {
+			files = append(files, testRunLs(t, env.gopts, id.String())...)
+		}
+		for _, name := range []string{dir + "/bin/app", dir + "/share/doc/README"} {
+			rtest.Assert(t, includes(files, name), "file %q missing from snapshots, got %v", name, files)
+		}
+	}
+
+	testRunCheck(t, env.gopts)
+}
+
+func findSFTPServerBinary() string {
+	for _, dir := range strings.Split(rtest.TestSFTPPath, ":") {
+		testpath := filepath.Join(dir, "sftp-server")
+		if _, err := os.Stat(testpath); err == nil {
+			return testpath
+		}
+	}
+	return ""
+}
+
+func TestBackupSFTPSource(t *testing.T) {
+	sftpServer := findSFTPServerBinary()
+	if sftpServer == "" {
+		t.Skip("sftp server binary not found")
+	}
+
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+	// SFTP cannot detect hardlinks and only provides timestamps with a
+	// resolution of one second, thus do not use testSetupBackupData
+	for name, content := range map[string]string{"file": "content", "dir/file": "more content", "dir/sub/empty": ""} {
+		filename := filepath.Join(env.testdata, filepath.FromSlash(name))
+		rtest.OK(t, os.MkdirAll(filepath.Dir(filename), 0755))
+		rtest.OK(t, os.WriteFile(filename, []byte(content), 0644))
+	}
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, name := range []string{"file", "dir/file", "dir/sub/empty", "dir/sub", "dir", ""} {
+		rtest.OK(t, os.Chtimes(filepath.Join(env.testdata, filepath.FromSlash(name)), modTime, modTime))
+	}
+	env.gopts.Extended["sftp.command"] = sftpServer
+	opts := BackupOptions{Source: "sftp:localhost:" + filepath.ToSlash(env.testdata)}
+
+	testRunBackup(t, "", nil, opts, env.gopts)
+	testRunBackup(t, "", nil, opts, env.gopts)
+	snapshotIDs := testListSnapshots(t, env.gopts, 2)
+
+	sn1 := testLoadSnapshot(t, env.gopts, snapshotIDs[0])
+	sn2 := testLoadSnapshot(t, env.gopts, snapshotIDs[1])
+	if sn1.Time.After(sn2.Time) {
+		sn1, sn2 = sn2, sn1
+	}
+	rtest.Equals(t, []string{filepath.ToSlash(env.testdata)}, sn2.Paths)
+	rtest.Assert(t, sn2.Parent != nil && sn2.Parent.Equal(*sn1.ID()), "second snapshot does not use the first one as parent")
+	rtest.Equals(t, sn1.Tree, sn2.Tree)
+
+	restoredir := filepath.Join(env.base, "restore")
+	testRunRestore(t, env.gopts, restoredir, snapshotIDs[0].String()+":"+toPathInSnapshot(filepath.Dir(env.testdata)))
+	diff := directoriesContentsDiff(t, env.testdata, filepath.Join(restoredir, "testdata"))
+	rtest.Assert(t, diff == "", "directories are not equal: %v", diff)
+
+	testRunCheck(t, env.gopts)
+}
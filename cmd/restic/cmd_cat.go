@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"strings"
@@ -11,6 +12,7 @@ import (
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/global"
 	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/repository/index"
 	"github.com/restic/restic/internal/restic"
 	"github.com/restic/restic/internal/ui"
 	"github.com/restic/restic/internal/ui/progress"
@@ -105,6 +107,19 @@ func runCat(ctx context.Context, gopts global.Options, args []string, term ui.Te
 			return err
 		}
 
+		if index.IsBinaryIndex(buf) {
+			// print the binary format in the same way as the JSON format
+			idx, err := index.DecodeIndex(buf, id)
+			if err != nil {
+				return err
+			}
+			var out bytes.Buffer
+			if err := idx.Dump(&out); err != nil {
+				return err
+			}
+			buf = out.Bytes()
+		}
+
 		printer.S(string(buf))
 		return nil
 	case "snapshot":
@@ -37,6 +37,11 @@ By default, check verifies the structural consistency and integrity of
 snapshots, trees and pack files. To also verify the integrity of the actual
 backed-up data, use the --read-data or --read-data-subset flags.
 
+Pack files which were read and verified successfully are recorded in the
+local cache. The --read-data-older-than flag only reads pack files which were
+not verified within the given duration. Combined with regular runs this
+ensures that all data is verified periodically.
+
 By default, check creates a new temporary cache directory to verify data.
 To reuse the existing cache, use the --with-cache flag.
 
@@ -73,16 +78,18 @@ Exit status is 12 if the password is incorrect.
 
 // CheckOptions bundles all options for the 'check' command.
 type CheckOptions struct {
-	ReadData       bool
-	ReadDataSubset string
-	CheckUnused    bool
-	WithCache      bool
+	ReadData          bool
+	ReadDataSubset    string
+	ReadDataOlderThan data.Duration
+	CheckUnused       bool
+	WithCache         bool
 	data.SnapshotFilter
 }
 
 func (opts *CheckOptions) AddFlags(f *pflag.FlagSet) {
 	f.BoolVar(&opts.ReadData, "read-data", false, "read all data blobs")
 	f.StringVar(&opts.ReadDataSubset, "read-data-subset", "", "read a `subset` of data packs, specified as 'n/t' for specific part, or either 'x%' or 'x.y%' or a size in bytes with suffixes k/K, m/M, g/G, t/T for a random subset")
+	f.Var(&opts.ReadDataOlderThan, "read-data-older-than", "read only data packs which were not verified within `duration`, e.g. 90d (requires the cache)")
 	var ignored bool
 	f.BoolVar(&ignored, "check-unused", false, "find unused blobs")
 	err := f.MarkDeprecated("check-unused", "`--check-unused` is deprecated and will be ignored")
@@ -98,6 +105,9 @@ func checkFlags(opts CheckOptions) error {
 	if opts.ReadData && opts.ReadDataSubset != "" {
 		return errors.Fatal("check flags --read-data and --read-data-subset cannot be used together")
 	}
+	if !opts.ReadDataOlderThan.Zero() && (opts.ReadData || opts.ReadDataSubset != "") {
+		return errors.Fatal("check flag --read-data-older-than cannot be used together with --read-data or --read-data-subset")
+	}
 	if opts.ReadDataSubset != "" {
 		dataSubset, err := stringToIntSlice(opts.ReadDataSubset)
 		argumentError := errors.Fatal("check flag --read-data-subset has invalid value, please see documentation")
@@ -236,8 +246,16 @@ func runCheck(ctx context.Context, opts CheckOptions, gopts global.Options, args
 		printer = newJSONErrorPrinter(term)
 	}
 
+	if !opts.ReadDataOlderThan.Zero() && gopts.NoCache {
+		return summary, errors.Fatal("check flag --read-data-older-than requires the verification state stored in the cache and cannot be used with --no-cache")
+	}
+
+	// the verification ledger is always stored in the regular cache
+	ledgerCacheDir, ledgerNoCache := gopts.CacheDir, gopts.NoCache
 	cleanup := prepareCheckCache(opts, &gopts, printer)
 	defer cleanup()
+	// the snapshot log is verified below using the regular cache
+	gopts.SkipSnapshotLogCheck = true
 
 	if !gopts.NoLock {
 		printer.P("create exclusive lock for repository\n")
@@ -371,6 +389,13 @@ func runCheck(ctx context.Context, opts CheckOptions, gopts global.Options, args
 		return summary, ctx.Err()
 	}
 
+	if ok := checkSnapshotLog(ctx, repo, chkr.Snapshots(), ledgerCacheDir, ledgerNoCache, &summary, printer); !ok {
+		errorsFound = true
+	}
+	if ctx.Err() != nil {
+		return summary, ctx.Err()
+	}
+
 	// the following block only used for tests
 	if opts.CheckUnused {
 		unused, err := chkr.UnusedBlobs(ctx)
@@ -383,7 +408,19 @@ func runCheck(ctx context.Context, opts CheckOptions, gopts global.Options, args
 		}
 	}
 
-	readDataFilter, err := buildPacksFilter(opts, printer, chkr.IsFiltered())
+	var ledger *repository.VerificationLedger
+	if (opts.ReadData || opts.ReadDataSubset != "" || !opts.ReadDataOlderThan.Zero()) && !ledgerNoCache {
+		ledger, err = openVerificationLedger(repo.Config().ID, ledgerCacheDir)
+		if err != nil {
+			if !opts.ReadDataOlderThan.Zero() {
+				return summary, err
+			}
+			printer.E("unable to load verification state, verified packs are not recorded: %v\n", err)
+			ledger = nil
+		}
+	}
+
+	readDataFilter, err := buildPacksFilter(opts, printer, chkr.IsFiltered(), ledger)
 	if err != nil {
 		return summary, err
 	}
@@ -392,7 +429,17 @@ func runCheck(ctx context.Context, opts CheckOptions, gopts global.Options, args
 		p := printer.NewCounter("packs")
 		errChan := make(chan error)
 
-		go chkr.ReadPacks(ctx, readDataFilter, p, errChan)
+		// remember the packs which were eligible for reading to report the coverage
+		var eligiblePacks map[restic.ID]int64
+		filter := func(packs map[restic.ID]int64) map[restic.ID]int64 {
+			eligiblePacks = packs
+			return readDataFilter(packs)
+		}
+		if ledger != nil {
+			chkr.SetVerificationLedger(ledger)
+		}
+
+		go chkr.ReadPacks(ctx, filter, p, errChan)
 
 		for err := range errChan {
 			errorsFound = true
@@ -403,6 +450,15 @@ func runCheck(ctx context.Context, opts CheckOptions, gopts global.Options, args
 			}
 		}
 		p.Done()
+
+		if ledger != nil {
+			if err := ledger.Save(); err != nil {
+				printer.E("unable to save verification state: %v\n", err)
+			}
+			if eligiblePacks != nil {
+				summary.VerificationCoverage = reportVerificationCoverage(ledger, eligiblePacks, printer)
+			}
+		}
 	}
 
 	if len(salvagePacks) > 0 {
@@ -411,6 +467,20 @@ func runCheck(ctx context.Context, opts CheckOptions, gopts global.Options, args
 			summary.BrokenPacks = append(summary.BrokenPacks, id.String())
 		}
 		printer.E("restic repair packs %v\nrestic repair snapshots --forget\n\n", strings.Join(summary.BrokenPacks, " "))
+
+		err := repo.List(ctx, restic.ParityFile, func(id restic.ID, _ int64) error {
+			if salvagePacks.Has(id) {
+				summary.RecoverablePacks = append(summary.RecoverablePacks, id.String())
+			}
+			return nil
+		})
+		if err != nil {
+			printer.E("unable to list parity files: %v\n", err)
+		}
+		if len(summary.RecoverablePacks) > 0 {
+			printer.E("Recovery data is available for %d of the damaged pack files. These can be reconstructed without losing data by running the following command instead:\n\n", len(summary.RecoverablePacks))
+			printer.E("restic repair packs --use-parity %v\n\n", strings.Join(summary.RecoverablePacks, " "))
+		}
 		printer.E("Damaged pack files can be caused by backend problems, hardware problems or bugs in restic. Please open an issue at https://github.com/restic/restic/issues/new/choose for further troubleshooting!\n")
 	}
 
@@ -428,8 +498,100 @@ func runCheck(ctx context.Context, opts CheckOptions, gopts global.Options, args
 	return summary, nil
 }
 
+// checkSnapshotLog verifies the snapshot log against the snapshots in the
+// repository and the state remembered in the cache in cacheDir. It returns
+// false if log entries or snapshots are missing.
+func checkSnapshotLog(ctx context.Context, repo *repository.Repository, snapshots restic.Lister, cacheDir string, noCache bool, summary *checkSummary, printer progress.Printer) bool {
+	var c *cache.Cache
+	if !noCache {
+		var err error
+		c, err = cache.New(repo.Config().ID, cacheDir)
+		if err != nil {
+			printer.E("unable to open cache, the snapshot log is verified without the remembered state: %v\n", err)
+			c = nil
+		}
+	}
+
+	status, err := repository.VerifySnapshotLog(ctx, repo, c, snapshots)
+	if err != nil {
+		summary.NumErrors++
+		printer.E("unable to verify snapshot log: %v\n", err)
+		return false
+	}
+	if !status.Active {
+		return true
+	}
+
+	printer.P("check snapshot log\n")
+	for _, id := range status.MissingEntries {
+		summary.NumErrors++
+		printer.E("snapshot log entry %v is missing\n", id)
+	}
+	for _, id := range status.MissingSnapshots {
+		summary.NumErrors++
+		printer.E("snapshot %v was recorded in the snapshot log but is missing\n", id)
+	}
+	if !status.OK() {
+		summary.HintRepairSnapshotLog = true
+		printer.E("\nThe snapshot log shows that the repository was modified without using restic, for example by rolling back the storage to an older state. After investigating the cause, run `restic repair snapshot-log` to accept the current state of the repository.\n\n")
+		return false
+	}
+
+	if len(status.UnrecordedSnapshots) > 0 {
+		summary.HintRepairSnapshotLog = true
+		printer.S("%d snapshots are not recorded in the snapshot log, for example because they were created by an older restic version. This is non-critical, you can run `restic repair snapshot-log` to record them.\n", len(status.UnrecordedSnapshots))
+	}
+	if err := repository.RememberSnapshotLog(c, status); err != nil {
+		printer.E("unable to save snapshot log state: %v\n", err)
+	}
+	return true
+}
+
+// openVerificationLedger loads the verification ledger from the cache of the
+// repository in cacheDir, or the default cache directory if it is empty.
+func openVerificationLedger(repoID string, cacheDir string) (*repository.VerificationLedger, error) {
+	c, err := cache.New(repoID, cacheDir)
+	if err != nil {
+		return nil, err
+	}
+	return repository.LoadVerificationLedger(c)
+}
+
+// verificationAgePercentiles are the percentiles reported for the age of the
+// last verification of pack files.
+var verificationAgePercentiles = []float64{50, 90, 99, 100}
+
+func reportVerificationCoverage(ledger *repository.VerificationLedger, packs map[restic.ID]int64, printer progress.Printer) *checkVerificationCoverage {
+	cov := ledger.Coverage(packs, time.Now(), verificationAgePercentiles)
+	summary := &checkVerificationCoverage{
+		Packs:           cov.Packs,
+		UnverifiedPacks: cov.Unverified,
+	}
+
+	printer.P("%d of %d packs were verified, %d were never verified\n", cov.Packs-cov.Unverified, cov.Packs, cov.Unverified)
+	if len(cov.Age) == 0 {
+		return summary
+	}
+	summary.AgeP50 = uint64(cov.Age[0] / time.Second)
+	summary.AgeP90 = uint64(cov.Age[1] / time.Second)
+	summary.AgeP99 = uint64(cov.Age[2] / time.Second)
+	summary.AgeMax = uint64(cov.Age[3] / time.Second)
+	printer.P("age of last verification: 50%% %v, 90%% %v, 99%% %v, max %v\n",
+		formatVerificationAge(cov.Age[0]), formatVerificationAge(cov.Age[1]),
+		formatVerificationAge(cov.Age[2]), formatVerificationAge(cov.Age[3]))
+	return summary
+}
+
+// formatVerificationAge formats d in days, or hours if d is less than a day.
+func formatVerificationAge(d time.Duration) string {
+	if d < 24*time.Hour {
+		return fmt.Sprintf("%dh", int(d/time.Hour))
+	}
+	return fmt.Sprintf("%dd", int(d/(24*time.Hour)))
+}
+
 func buildPacksFilter(opts CheckOptions, printer progress.Printer,
-	filteredStatus bool) (func(packs map[restic.ID]int64) map[restic.ID]int64, error) {
+	filteredStatus bool, ledger *repository.VerificationLedger) (func(packs map[restic.ID]int64) map[restic.ID]int64, error) {
 	typeData := ""
 	if filteredStatus {
 		typeData = "filtered "
@@ -440,6 +602,14 @@ func buildPacksFilter(opts CheckOptions, printer progress.Printer,
 			printer.P("read all %sdata", typeData)
 			return packs
 		}, nil
+	case !opts.ReadDataOlderThan.Zero():
+		d := opts.ReadDataOlderThan
+		cutoff := time.Now().AddDate(-d.Years, -d.Months, -d.Days).Add(time.Hour * time.Duration(-d.Hours))
+		return func(packs map[restic.ID]int64) map[restic.ID]int64 {
+			stale := ledger.Stale(packs, cutoff)
+			printer.P("read %d of %d %sdata packs not verified within %v\n", len(stale), len(packs), typeData, d)
+			return stale
+		}, nil
 	case opts.ReadDataSubset != "":
 		dataSubset, err := stringToIntSlice(opts.ReadDataSubset)
 		if err == nil {
@@ -531,11 +701,26 @@ func selectRandomPacksByFileSize(allPacks map[restic.ID]int64, subsetSize int64,
 }
 
 type checkSummary struct {
-	MessageType     string   `json:"message_type"` // "summary"
-	NumErrors       int      `json:"num_errors"`
-	BrokenPacks     []string `json:"broken_packs"`         // run "restic repair packs ID..." and "restic repair snapshots --forget" to remove damaged files
-	HintRepairIndex bool     `json:"suggest_repair_index"` // run "restic repair index"
-	HintPrune       bool     `json:"suggest_prune"`        // run "restic prune"
+	MessageType           string   `json:"message_type"` // "summary"
+	NumErrors             int      `json:"num_errors"`
+	BrokenPacks           []string `json:"broken_packs"`                // run "restic repair packs ID..." and "restic repair snapshots --forget" to remove damaged files
+	RecoverablePacks      []string `json:"recoverable_packs"`           // run "restic repair packs --use-parity ID..." to reconstruct damaged files
+	HintRepairIndex       bool     `json:"suggest_repair_index"`        // run "restic repair index"
+	HintPrune             bool     `json:"suggest_prune"`               // run "restic prune"
+	HintRepairSnapshotLog bool     `json:"suggest_repair_snapshot_log"` // run "restic repair snapshot-log"
+
+	VerificationCoverage *checkVerificationCoverage `json:"verification_coverage,omitempty"`
+}
+
+// checkVerificationCoverage reports the age of the last verification of the
+// pack files which were eligible for reading. Ages are in seconds.
+type checkVerificationCoverage struct {
+	Packs           int    `json:"packs"`
+	UnverifiedPacks int    `json:"unverified_packs"`
+	AgeP50          uint64 `json:"age_p50"`
+	AgeP90          uint64 `json:"age_p90"`
+	AgeP99          uint64 `json:"age_p99"`
+	AgeMax          uint64 `json:"age_max"`
 }
 
 type checkError struct {
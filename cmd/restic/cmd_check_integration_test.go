@@ -5,6 +5,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/restic/restic/internal/data"
 	"github.com/restic/restic/internal/global"
 	rtest "github.com/restic/restic/internal/test"
 )
@@ -99,3 +100,36 @@ func TestCheckWithSnaphotFilter(t *testing.T) {
 		rtest.Assert(t, hasOutput, `expected to find substring %q, but did not find it`, testCase.expectedOutput)
 	}
 }
+
+func TestCheckReadDataOlderThan(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	testRunBackup(t, env.testdata+"/0", []string{"0/9"}, BackupOptions{}, env.gopts)
+
+	var olderThan data.Duration
+	rtest.OK(t, olderThan.Set("1d"))
+	opts := CheckOptions{ReadDataOlderThan: olderThan}
+
+	// nothing was verified yet
+	output, err := testRunCheckOutputWithOpts(t, env.gopts, opts, nil)
+	rtest.OK(t, err)
+	rtest.Assert(t, strings.Contains(output, "read 2 of 2 data packs not verified within 1d"), "unexpected output %q", output)
+	rtest.Assert(t, strings.Contains(output, "2 of 2 packs were verified, 0 were never verified"), "unexpected output %q", output)
+
+	// all packs were verified by the previous run
+	output, err = testRunCheckOutputWithOpts(t, env.gopts, opts, nil)
+	rtest.OK(t, err)
+	rtest.Assert(t, strings.Contains(output, "read 0 of 2 data packs not verified within 1d"), "unexpected output %q", output)
+
+	// new packs are read
+	testRunBackup(t, env.testdata+"/0", []string{"for_cmd_ls"}, BackupOptions{}, env.gopts)
+	output, err = testRunCheckOutputWithOpts(t, env.gopts, opts, nil)
+	rtest.OK(t, err)
+	rtest.Assert(t, strings.Contains(output, "read 2 of 4 data packs not verified within 1d"), "unexpected output %q", output)
+
+	env.gopts.NoCache = true
+	_, err = testRunCheckOutputWithOpts(t, env.gopts, opts, nil)
+	rtest.Assert(t, err != nil && strings.Contains(err.Error(), "--no-cache"), "unexpected error %v", err)
+}
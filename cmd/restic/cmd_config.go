@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/spf13/cobra"
+	"go.yaml.in/yaml/v3"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/global"
+	"github.com/restic/restic/internal/ui"
+	"github.com/restic/restic/internal/ui/progress"
+)
+
+func newConfigCommand(globalOptions *global.Options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Manage configuration profiles",
+		Long: `
+The "config" command group allows to inspect the configuration profiles
+selected using --profile.
+`,
+		DisableAutoGenTag: true,
+		GroupID:           cmdGroupAdvanced,
+	}
+
+	cmd.AddCommand(
+		newConfigShowCommand(globalOptions),
+	)
+	return cmd
+}
+
+func newConfigShowCommand(globalOptions *global.Options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "show",
+		Short: "Print the effective configuration profile",
+		Long: `
+The "config show" command prints the configuration profile selected using
+--profile or $RESTIC_PROFILE, after all profiles it inherits from were merged
+into it. The output uses the format of the profiles file.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful.
+Exit status is 1 if there was any error.
+`,
+		DisableAutoGenTag: true,
+		Args:              cobra.NoArgs,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			return runConfigShow(*globalOptions, globalOptions.Term)
+		},
+	}
+	return cmd
+}
+
+func runConfigShow(gopts global.Options, term ui.Terminal) error {
+	printer := progress.NewTerminalPrinter(gopts.JSON, gopts.Verbosity, term)
+
+	if gopts.Profile == "" {
+		return errors.Fatal("no profile selected, please specify one using --profile or $RESTIC_PROFILE")
+	}
+
+	p, err := loadProfile(gopts.ProfilesFile, gopts.Profile)
+	if err != nil {
+		return err
+	}
+
+	if gopts.JSON {
+		type jsonProfile struct {
+			MessageType string                 `json:"message_type"` // profile
+			Name        string                 `json:"name"`
+			Config      map[string]interface{} `json:"config"`
+		}
+
+		return json.NewEncoder(term.OutputWriter()).Encode(jsonProfile{
+			MessageType: "profile",
+			Name:        gopts.Profile,
+			Config:      p,
+		})
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(p); err != nil {
+		return err
+	}
+	if err := enc.Close(); err != nil {
+		return err
+	}
+	printer.S("%s", buf.String())
+	return nil
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/global"
+	"github.com/restic/restic/internal/rechunker"
 	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
 	"github.com/restic/restic/internal/ui"
@@ -40,6 +41,13 @@ repository, /may occupy up to twice their space/ in the destination repository.
 This can be mitigated by the "--copy-chunker-params" option when initializing a
 new destination repository using the "init" command.
 
+For existing destination repositories, the "--rechunk" option reassembles the
+files of the copied snapshots and splits them again using the chunker
+parameters of the destination repository. The directory structure and all
+metadata are kept. Snapshots copied with "--rechunk" reference different data
+than the source snapshots, but are still recognized as copies when running the
+command again.
+
 EXIT STATUS
 ===========
 
@@ -65,11 +73,13 @@ Exit status is 12 if the password is incorrect.
 type CopyOptions struct {
 	global.SecondaryRepoOptions
 	data.SnapshotFilter
+	Rechunk bool
 }
 
 func (opts *CopyOptions) AddFlags(f *pflag.FlagSet) {
 	opts.SecondaryRepoOptions.AddFlags(f, "destination", "to copy snapshots from")
 	initMultiSnapshotFilter(f, &opts.SnapshotFilter, true)
+	f.BoolVar(&opts.Rechunk, "rechunk", false, "re-chunk files using the chunker parameters of the destination repository")
 }
 
 // collectAllSnapshots: select all snapshot trees to be copied
@@ -87,7 +97,7 @@ func collectAllSnapshots(ctx context.Context, opts CopyOptions,
 			if originalSns, ok := dstSnapshotByOriginal[srcOriginal]; ok {
 				isCopy := false
 				for _, originalSn := range originalSns {
-					if similarSnapshots(originalSn, sn) {
+					if similarSnapshots(originalSn, sn, !opts.Rechunk) {
 						printer.V("\n%v", sn)
 						printer.V("skipping source snapshot %s, was already copied to snapshot %s", sn.ID().Str(), originalSn.ID().Str())
 						isCopy = true
@@ -138,6 +148,11 @@ func runCopy(ctx context.Context, opts CopyOptions, gopts global.Options, args [
 		return err
 	}
 
+	if opts.Rechunk && srcRepo.Config().ChunkerPolynomial == dstRepo.Config().ChunkerPolynomial {
+		printer.P("source and destination repository use the same chunker parameters, copying without re-chunking")
+		opts.Rechunk = false
+	}
+
 	debug.Log("Loading source index")
 	if err := srcRepo.LoadIndex(ctx, printer); err != nil {
 		return err
@@ -161,16 +176,21 @@ func runCopy(ctx context.Context, opts CopyOptions, gopts global.Options, args [
 
 	selectedSnapshots := collectAllSnapshots(ctx, opts, srcSnapshotLister, srcRepo, dstSnapshotByOriginal, args, printer)
 
-	if err := copyTreeBatched(ctx, srcRepo, dstRepo, selectedSnapshots, printer); err != nil {
+	if err := copyTreeBatched(ctx, srcRepo, dstRepo, selectedSnapshots, opts.Rechunk, printer); err != nil {
 		return err
 	}
 
 	return ctx.Err()
 }
 
-func similarSnapshots(sna *data.Snapshot, snb *data.Snapshot) bool {
+// similarSnapshots checks whether snb is a copy of sna. If compareTree is false,
+// the trees may differ, as is the case for re-chunked copies.
+func similarSnapshots(sna *data.Snapshot, snb *data.Snapshot, compareTree bool) bool {
+	if compareTree && !sna.Tree.Equal(*snb.Tree) {
+		return false
+	}
 	// everything except Parent and Original must match
-	if !sna.Time.Equal(snb.Time) || !sna.Tree.Equal(*snb.Tree) || sna.Hostname != snb.Hostname ||
+	if !sna.Time.Equal(snb.Time) || sna.Hostname != snb.Hostname ||
 		sna.Username != snb.Username || sna.UID != snb.UID || sna.GID != snb.GID ||
 		len(sna.Paths) != len(snb.Paths) || len(sna.Excludes) != len(snb.Excludes) ||
 		len(sna.Tags) != len(snb.Tags) {
@@ -188,12 +208,17 @@ func similarSnapshots(sna *data.Snapshot, snb *data.Snapshot) bool {
 }
 
 // copyTreeBatched copies multiple snapshots in one go. Snapshots are written after
-// data equivalent to at least 10 packfiles was written.
+// data equivalent to at least 10 packfiles was written. If rechunk is set, the
+// files are split using the chunker parameters of the destination repository.
 func copyTreeBatched(ctx context.Context, srcRepo *repository.Repository, dstRepo restic.Repository,
-	selectedSnapshots iter.Seq[*data.Snapshot], printer progress.Printer) error {
+	selectedSnapshots iter.Seq[*data.Snapshot], rechunk bool, printer progress.Printer) error {
 
 	// remember already processed trees across all snapshots
 	visitedTrees := srcRepo.NewAssociatedBlobSet()
+	var rc *rechunker.Rechunker
+	if rechunk {
+		rc = rechunker.New(srcRepo, dstRepo.Config().ChunkerPolynomial)
+	}
 
 	targetSize := uint64(dstRepo.PackSize()) * 100
 	minDuration := 1 * time.Minute
@@ -219,6 +244,17 @@ func copyTreeBatched(ctx context.Context, srcRepo *repository.Repository, dstRep
 
 				printer.P("\n%v", sn)
 				printer.P("  copy started, this may take a while...")
+				if rc != nil {
+					newTree, stats, err := rc.RewriteTree(ctx, uploader, *sn.Tree)
+					if err != nil {
+						return err
+					}
+					printer.V("  re-chunked %d files with size %s\n", stats.Files, ui.FormatBytes(stats.Bytes))
+					sn.Tree = &newTree
+					batchSize += stats.Bytes
+					continue
+				}
+
 				sizeBlobs, err := copyTree(ctx, srcRepo, dstRepo, visitedTrees, *sn.Tree, printer, uploader)
 				if err != nil {
 					return err
@@ -13,16 +13,18 @@ import (
 )
 
 func testRunCopy(t testing.TB, srcGopts global.Options, dstGopts global.Options) {
+	testRunCopyWithOptions(t, srcGopts, dstGopts, CopyOptions{})
+}
+
+func testRunCopyWithOptions(t testing.TB, srcGopts global.Options, dstGopts global.Options, copyOpts CopyOptions) {
 	gopts := srcGopts
 	gopts.Repo = dstGopts.Repo
 	gopts.Password = dstGopts.Password
 	gopts.InsecureNoPassword = dstGopts.InsecureNoPassword
-	copyOpts := CopyOptions{
-		SecondaryRepoOptions: global.SecondaryRepoOptions{
-			Repo:               srcGopts.Repo,
-			Password:           srcGopts.Password,
-			InsecureNoPassword: srcGopts.InsecureNoPassword,
-		},
+	copyOpts.SecondaryRepoOptions = global.SecondaryRepoOptions{
+		Repo:               srcGopts.Repo,
+		Password:           srcGopts.Password,
+		InsecureNoPassword: srcGopts.InsecureNoPassword,
 	}
 
 	rtest.OK(t, withTermStatus(t, gopts, func(ctx context.Context, gopts global.Options) error {
@@ -161,6 +163,40 @@ func TestCopyIncremental(t *testing.T) {
 	testListSnapshots(t, env.gopts, 3)
 }
 
+func TestCopyRechunk(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+	env2, cleanup2 := withTestEnvironment(t)
+	defer cleanup2()
+
+	testSetupBackupData(t, env)
+	opts := BackupOptions{}
+	testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0", "9")}, opts, env.gopts)
+	testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0", "9", "2")}, opts, env.gopts)
+
+	// the destination uses different chunker parameters
+	testRunInit(t, env2.gopts)
+	testRunCopyWithOptions(t, env.gopts, env2.gopts, CopyOptions{Rechunk: true})
+	testRunCheck(t, env2.gopts)
+
+	snapshotIDs := testListSnapshots(t, env.gopts, 2)
+	copiedSnapshotIDs := testListSnapshots(t, env2.gopts, 2)
+	for i := range snapshotIDs {
+		copied := testLoadSnapshot(t, env2.gopts, copiedSnapshotIDs[i])
+		original := testLoadSnapshot(t, env.gopts, *copied.Original)
+
+		restoredir := filepath.Join(env.base, fmt.Sprintf("restore%d", i))
+		testRunRestore(t, env.gopts, restoredir, original.ID().String())
+		restoredir2 := filepath.Join(env2.base, fmt.Sprintf("restore%d", i))
+		testRunRestore(t, env2.gopts, restoredir2, copiedSnapshotIDs[i].String())
+		rtest.Equals(t, "", directoriesContentsDiff(t, restoredir, restoredir2))
+	}
+
+	// re-chunked snapshots are not copied again
+	testRunCopyWithOptions(t, env.gopts, env2.gopts, CopyOptions{Rechunk: true})
+	testListSnapshots(t, env2.gopts, 2)
+}
+
 func TestCopyUnstableJSON(t *testing.T) {
 	env, cleanup := withTestEnvironment(t)
 	defer cleanup()
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"slices"
 	"strconv"
 
 	"github.com/restic/restic/internal/data"
@@ -32,6 +33,10 @@ If there are not enough snapshots to keep one for each duration related
 "--keep-{within-,}*" option, the oldest snapshot in the group is kept
 additionally.
 
+Checkpoint snapshots created by "backup --checkpoint-interval" are not subject
+to the policy. They are removed once a newer complete snapshot of the same host
+and paths exists.
+
 Please note that this command really only deletes the snapshot object in the
 repository, which is a reference to data stored there. In order to remove the
 unreferenced data after "forget" was run successfully, see the "prune" command.
@@ -215,6 +220,27 @@ func runForget(ctx context.Context, opts ForgetOptions, pruneOptions PruneOption
 			removeSnIDs.Insert(*sn.ID())
 		}
 	} else {
+		// checkpoints are not subject to the policy, they are removed once
+		// a complete snapshot supersedes them
+		obsolete := data.ObsoleteCheckpoints(snapshots)
+		if len(obsolete) != 0 && !gopts.Quiet && !gopts.JSON {
+			printer.P("remove %d obsolete checkpoint snapshots:\n", len(obsolete))
+			if err := PrintSnapshots(gopts.Term.OutputWriter(), obsolete, nil, opts.Compact); err != nil {
+				return err
+			}
+			printer.P("\n")
+		}
+		for _, sn := range obsolete {
+			removeSnIDs.Insert(*sn.ID())
+		}
+		snapshots = slices.DeleteFunc(snapshots, (*data.Snapshot).IsCheckpoint)
+
+		checkpointGroups, err := obsoleteCheckpointGroups(obsolete)
+		if err != nil {
+			return err
+		}
+		jsonGroups = append(jsonGroups, checkpointGroups...)
+
 		snapshotGroups, _, err := data.GroupSnapshots(snapshots, opts.GroupBy)
 		if err != nil {
 			return err
@@ -312,6 +338,10 @@ func runForget(ctx context.Context, opts ForgetOptions, pruneOptions PruneOption
 	failedSnIDs := restic.NewIDSet()
 	if len(removeSnIDs) > 0 {
 		if !opts.DryRun {
+			// record all removals in a single entry of the snapshot log
+			if err := repo.RecordSnapshotRemoval(ctx, removeSnIDs); err != nil {
+				return err
+			}
 			bar := printer.NewCounter("files deleted")
 			err := restic.ParallelRemove(ctx, repo, removeSnIDs, restic.WriteableSnapshotFile, func(id restic.ID, err error) error {
 				if err != nil {
@@ -363,6 +393,33 @@ type ForgetGroup struct {
 	Keep    []Snapshot   `json:"keep"`
 	Remove  []Snapshot   `json:"remove"`
 	Reasons []KeepReason `json:"reasons"`
+	// Checkpoints is set for groups of obsolete checkpoint snapshots, which
+	// are removed independent of the policy.
+	Checkpoints bool `json:"checkpoints,omitempty"`
+}
+
+// obsoleteCheckpointGroups returns one ForgetGroup for the obsolete checkpoints
+// of each host and paths.
+func obsoleteCheckpointGroups(obsolete data.Snapshots) ([]*ForgetGroup, error) {
+	groups, _, err := data.GroupSnapshots(obsolete, data.SnapshotGroupByOptions{Host: true, Path: true})
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*ForgetGroup
+	for k, group := range groups {
+		var key data.SnapshotGroupKey
+		if err := json.Unmarshal([]byte(k), &key); err != nil {
+			return nil, err
+		}
+		result = append(result, &ForgetGroup{
+			Host:        key.Hostname,
+			Paths:       key.Paths,
+			Remove:      asJSONSnapshots(group),
+			Checkpoints: true,
+		})
+	}
+	return result, nil
 }
 
 func asJSONSnapshots(list data.Snapshots) []Snapshot {
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"path/filepath"
 	"strings"
 	"testing"
@@ -64,3 +65,76 @@ func TestRunForgetSafetyNet(t *testing.T) {
 	})
 	testListSnapshots(t, env.gopts, 0)
 }
+
+func TestForgetCheckpoints(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	target := []string{filepath.Join(env.testdata, "0", "0", "9")}
+	checkpointOpts := func(timeStamp string) BackupOptions {
+		return BackupOptions{
+			Tags:      data.TagLists{data.TagList{data.CheckpointTag}},
+			TimeStamp: timeStamp,
+		}
+	}
+
+	// a backup based on a checkpoint removes the checkpoint once it completes
+	testRunBackup(t, "", target, checkpointOpts("2020-01-01 10:00:00"), env.gopts)
+	testListSnapshots(t, env.gopts, 1)
+	testRunBackup(t, "", target, BackupOptions{}, env.gopts)
+	complete := testListSnapshots(t, env.gopts, 1)[0]
+	rtest.Assert(t, !testLoadSnapshot(t, env.gopts, complete).IsCheckpoint(), "checkpoint was not removed")
+
+	// forget removes obsolete checkpoints and ignores all others for the policy
+	testRunBackup(t, "", target, checkpointOpts("2020-01-02 10:00:00"), env.gopts)
+	testRunBackup(t, "", target, checkpointOpts("2099-01-01 10:00:00"), env.gopts)
+	testListSnapshots(t, env.gopts, 3)
+
+	// the JSON output reports the obsolete checkpoint in a separate group
+	buf, err := withCaptureStdout(t, env.gopts, func(ctx context.Context, gopts global.Options) error {
+		gopts.JSON = true
+		opts := ForgetOptions{DryRun: true, Last: 1, GroupBy: data.SnapshotGroupByOptions{Host: true, Path: true}}
+		return runForget(ctx, opts, PruneOptions{MaxUnused: "5%"}, gopts, gopts.Term, nil)
+	})
+	rtest.OK(t, err)
+	var groups []*ForgetGroup
+	rtest.OK(t, json.Unmarshal(buf.Bytes(), &groups))
+	rtest.Equals(t, 2, len(groups))
+	for _, group := range groups {
+		if group.Checkpoints {
+			rtest.Equals(t, 0, len(group.Keep))
+			rtest.Equals(t, 1, len(group.Remove))
+			rtest.Equals(t, 2020, group.Remove[0].Time.Year())
+		}
+	}
+	rtest.Assert(t, groups[0].Checkpoints != groups[1].Checkpoints, "expected one group of checkpoints, got %v", groups)
+
+	testRunForget(t, env.gopts, ForgetOptions{
+		Last:    1,
+		GroupBy: data.SnapshotGroupByOptions{Host: true, Path: true},
+	})
+
+	ids := testListSnapshots(t, env.gopts, 2)
+	var checkpoints int
+	for _, id := range ids {
+		sn := testLoadSnapshot(t, env.gopts, id)
+		if sn.IsCheckpoint() {
+			checkpoints++
+			rtest.Equals(t, 2099, sn.Time.Year())
+		} else {
+			rtest.Equals(t, complete, id)
+		}
+	}
+	rtest.Equals(t, 1, checkpoints)
+
+	// prune removes checkpoints superseded by a backup which did not use them
+	testRunForget(t, env.gopts, ForgetOptions{}, ids[0].String(), ids[1].String())
+	testRunBackup(t, "", target, checkpointOpts("2020-01-03 10:00:00"), env.gopts)
+	testRunBackup(t, "", target, BackupOptions{Force: true}, env.gopts)
+	testListSnapshots(t, env.gopts, 2)
+	testRunPrune(t, env.gopts, PruneOptions{MaxUnused: "0"})
+	complete = testListSnapshots(t, env.gopts, 1)[0]
+	rtest.Assert(t, !testLoadSnapshot(t, env.gopts, complete).IsCheckpoint(), "checkpoint was not removed")
+	testRunCheck(t, env.gopts)
+}
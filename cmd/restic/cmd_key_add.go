@@ -71,6 +71,10 @@ func runKeyAdd(ctx context.Context, gopts global.Options, opts KeyAddOptions, ar
 }
 
 func addKey(ctx context.Context, repo *repository.Repository, gopts global.Options, opts KeyAddOptions, printer progress.Printer) error {
+	if repo.RekeyPending() {
+		return errors.Fatal("a rekey is in progress, run `restic rekey` to complete it first")
+	}
+
 	pw, err := getNewPassword(ctx, gopts, opts.NewPasswordFile, opts.InsecureNoPassword)
 	if err != nil {
 		return err
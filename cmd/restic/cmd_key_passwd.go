@@ -66,6 +66,10 @@ func runKeyPasswd(ctx context.Context, gopts global.Options, opts KeyPasswdOptio
 }
 
 func changePassword(ctx context.Context, repo *repository.Repository, gopts global.Options, opts KeyPasswdOptions, printer progress.Printer) error {
+	if repo.RekeyPending() {
+		return errors.Fatal("a rekey is in progress, run `restic rekey` to complete it first")
+	}
+
 	pw, err := getNewPassword(ctx, gopts, opts.NewPasswordFile, opts.InsecureNoPassword)
 	if err != nil {
 		return err
@@ -15,7 +15,7 @@ import (
 )
 
 func newListCommand(globalOptions *global.Options) *cobra.Command {
-	var listAllowedArgs = []string{"blobs", "packs", "index", "snapshots", "keys", "locks"}
+	var listAllowedArgs = []string{"blobs", "packs", "index", "snapshots", "keys", "locks", "parity", "snapshotlog"}
 	var listAllowedArgsUseString = strings.Join(listAllowedArgs, "|")
 
 	cmd := &cobra.Command{
@@ -51,6 +51,8 @@ func runList(ctx context.Context, gopts global.Options, args []string, term ui.T
 		return errors.Fatal("type not specified")
 	}
 
+	// only list the files once
+	gopts.SkipSnapshotLogCheck = args[0] == "snapshotlog"
 	ctx, repo, unlock, err := openWithReadLock(ctx, gopts, gopts.NoLock || args[0] == "locks", printer)
 	if err != nil {
 		return err
@@ -69,6 +71,10 @@ func runList(ctx context.Context, gopts global.Options, args []string, term ui.T
 		t = restic.KeyFile
 	case "locks":
 		t = restic.LockFile
+	case "parity":
+		t = restic.ParityFile
+	case "snapshotlog":
+		t = restic.SnapshotLogFile
 	case "blobs":
 		for entry := range repository.AllIndexBlobs(ctx, repo, repo) {
 			if entry.Error != nil {
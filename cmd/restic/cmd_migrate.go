@@ -137,6 +137,8 @@ func applyMigrations(ctx context.Context, opts MigrateOptions, gopts global.Opti
 func runMigrate(ctx context.Context, opts MigrateOptions, gopts global.Options, args []string, term ui.Terminal) error {
 	printer := progress.NewTerminalPrinter(false, gopts.Verbosity, term)
 
+	// the parity_redundancy migration stores the specified value
+	gopts.SkipParityRedundancyCheck = true
 	ctx, repo, unlock, err := openWithExclusiveLock(ctx, gopts, false, printer)
 	if err != nil {
 		return err
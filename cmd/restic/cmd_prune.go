@@ -6,6 +6,7 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/restic/restic/internal/data"
 	"github.com/restic/restic/internal/debug"
@@ -28,7 +29,14 @@ func newPruneCommand(globalOptions *global.Options) *cobra.Command {
 		Short: "Remove unneeded data from the repository",
 		Long: `
 The "prune" command checks the repository and removes data that is not
-referenced and therefore not needed any more.
+referenced and therefore not needed any more. Checkpoint snapshots for which a
+newer complete snapshot of the same host and paths exists are removed first.
+
+With --delay-delete, prune only holds a non-exclusive lock such that backups
+can run concurrently. Pack files which are no longer needed are then only
+marked for deletion and removed by a later prune run, once all locks which
+existed at the time they were marked are gone. Only one prune with
+--delay-delete can run at a time.
 
 EXIT STATUS
 ===========
@@ -64,16 +72,23 @@ type PruneOptions struct {
 	MaxRepackBytes uint64
 
 	RepackCacheableOnly bool
+	RepackCold          bool
 	RepackUncompressed  bool
 
 	SmallPackSize  string
 	SmallPackBytes uint64
+
+	CostModel string
+	costModel *repository.CostModel
+
+	DelayDelete bool
 }
 
 func (opts *PruneOptions) AddFlags(f *pflag.FlagSet) {
 	opts.AddLimitedFlags(f)
 	f.BoolVarP(&opts.DryRun, "dry-run", "n", false, "do not modify the repository, just print what would be done")
 	f.StringVarP(&opts.UnsafeNoSpaceRecovery, "unsafe-recover-no-free-space", "", "", "UNSAFE, READ THE DOCUMENTATION BEFORE USING! Try to recover a repository stuck with no free space. Do not use without trying out 'prune --max-repack-size 0' first.")
+	f.BoolVar(&opts.DelayDelete, "delay-delete", false, "use a non-exclusive lock and only mark unneeded pack files for deletion by a later prune run")
 }
 
 func (opts *PruneOptions) AddLimitedFlags(f *pflag.FlagSet) {
@@ -81,9 +96,11 @@ func (opts *PruneOptions) AddLimitedFlags(f *pflag.FlagSet) {
 	f.StringVar(&opts.MaxUnused, "max-unused", "5%", "tolerate given `limit` of unused data (absolute value in bytes with suffixes k/K, m/M, g/G, t/T, a value in % or the word 'unlimited')")
 	f.StringVar(&opts.MaxRepackSize, "max-repack-size", "", "stop after repacking this much data in total (allowed suffixes for `size`: k/K, m/M, g/G, t/T)")
 	f.BoolVar(&opts.RepackCacheableOnly, "repack-cacheable-only", false, "only repack packs which are cacheable")
+	f.BoolVar(&opts.RepackCold, "repack-cold", false, "also repack data packs stored in the cold repository (see --cold-repo)")
 	f.BoolVar(&unused, "repack-small", false, "deprecated. Use --repack-smaller-than to specify a minimum size")
 	f.BoolVar(&opts.RepackUncompressed, "repack-uncompressed", false, "repack all uncompressed data")
 	f.StringVar(&opts.SmallPackSize, "repack-smaller-than", "", "pack `below-limit` packfiles (allowed suffixes: m/M)")
+	f.StringVar(&opts.CostModel, "cost-model", "", "only repack data packs if this saves costs according to the `model` (preset and/or key=value list, replaces --max-unused)")
 
 	err := f.MarkDeprecated("repack-small", "small files are automatically repacked. Use --repack-smaller-than to specify a minimum size")
 	if err != nil {
@@ -158,6 +175,14 @@ func verifyPruneOptions(opts *PruneOptions) error {
 		opts.SmallPackBytes = uint64(size)
 	}
 
+	if opts.CostModel != "" {
+		model, err := repository.ParseCostModel(opts.CostModel)
+		if err != nil {
+			return errors.Fatalf("invalid --cost-model: %v", err)
+		}
+		opts.costModel = &model
+	}
+
 	return nil
 }
 
@@ -175,8 +200,16 @@ func runPrune(ctx context.Context, opts PruneOptions, gopts global.Options, term
 		return errors.Fatal("--no-lock is only applicable in combination with --dry-run for prune command")
 	}
 
+	if opts.DelayDelete && opts.UnsafeNoSpaceRecovery != "" {
+		return errors.Fatal("--delay-delete and --unsafe-recover-no-free-space are mutually exclusive")
+	}
+
 	printer := progress.NewTerminalPrinter(gopts.JSON, gopts.Verbosity, term)
-	ctx, repo, unlock, err := openWithExclusiveLock(ctx, gopts, opts.DryRun && gopts.NoLock, printer)
+	openWithLock := openWithExclusiveLock
+	if opts.DelayDelete {
+		openWithLock = openWithDelayedPruneLock
+	}
+	ctx, repo, unlock, err := openWithLock(ctx, gopts, opts.DryRun && gopts.NoLock, printer)
 	if err != nil {
 		return err
 	}
@@ -197,13 +230,48 @@ func runPruneWithRepo(ctx context.Context, opts PruneOptions, gopts global.Optio
 	if repo.Cache() == nil && !gopts.JSON {
 		printer.S("warning: running prune without a cache, this may be very slow!")
 	}
+	if opts.costModel != nil {
+		printer.V("using cost model %v", opts.costModel)
+		if opts.costModel.MinStorageDays > 0 && !gopts.JSON {
+			printer.P("note: the cost model ignores early deletion fees for files younger than %v days", opts.costModel.MinStorageDays)
+		}
+	}
 
-	// loading the index before the snapshots is ok, as we use an exclusive lock here
+	var snapshotLister restic.Lister = repo
+	var deletePendingBefore time.Time
+	if opts.DelayDelete {
+		// Packs pending deletion may only be removed if all processes which could
+		// still use them have finished. Thus, the locks must be checked before
+		// listing the snapshots such that the snapshots of these processes are
+		// taken into account.
+		var err error
+		deletePendingBefore, err = repository.OldestLockCreated(ctx, repo)
+		if err != nil {
+			return err
+		}
+		debug.Log("deleting packs pending deletion marked before %v", deletePendingBefore)
+
+		// the snapshots must be listed before loading the index, as concurrent
+		// backups write their index before the snapshot
+		snapshotLister, err = restic.MemorizeList(ctx, repo, restic.SnapshotFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	// without --delay-delete, loading the index before the snapshots is ok, as we use an exclusive lock here
 	err := repo.LoadIndex(ctx, printer)
 	if err != nil {
 		return err
 	}
 
+	repackCacheableOnly := opts.RepackCacheableOnly
+	if repo.IsTiered() && !opts.RepackCold {
+		// repacking data packs would download them from cold storage
+		printer.V("only repacking packs in the hot repository, use --repack-cold to also repack data packs")
+		repackCacheableOnly = true
+	}
+
 	popts := repository.PruneOptions{
 		DryRun:         opts.DryRun,
 		UnsafeRecovery: opts.unsafeRecovery,
@@ -212,12 +280,18 @@ func runPruneWithRepo(ctx context.Context, opts PruneOptions, gopts global.Optio
 		MaxRepackBytes: opts.MaxRepackBytes,
 		SmallPackBytes: opts.SmallPackBytes,
 
-		RepackCacheableOnly: opts.RepackCacheableOnly,
+		RepackCacheableOnly: repackCacheableOnly,
 		RepackUncompressed:  opts.RepackUncompressed,
+		CostModel:           opts.costModel,
+
+		DelayDelete:         opts.DelayDelete,
+		DeletePendingBefore: deletePendingBefore,
 	}
 
+	// obsolete checkpoints would otherwise keep data of interrupted backups
+	obsoleteCheckpoints := restic.NewIDSet()
 	plan, err := repository.PlanPrune(ctx, popts, repo, func(ctx context.Context, repo restic.Repository, usedBlobs restic.FindBlobSet) error {
-		return getUsedBlobs(ctx, repo, usedBlobs, ignoreSnapshots, printer)
+		return getUsedBlobs(ctx, snapshotLister, repo, usedBlobs, ignoreSnapshots, obsoleteCheckpoints, printer)
 	}, printer)
 	if err != nil {
 		return err
@@ -242,7 +316,51 @@ func runPruneWithRepo(ctx context.Context, opts PruneOptions, gopts global.Optio
 	// Trigger GC to reset garbage collection threshold
 	runtime.GC()
 
-	return plan.Execute(ctx, printer)
+	// the checkpoints must be removed before the data they reference
+	err = removeCheckpoints(ctx, repo, obsoleteCheckpoints, opts.DryRun, printer)
+	if err != nil {
+		return err
+	}
+
+	err = plan.Execute(ctx, printer)
+	if err != nil {
+		return err
+	}
+
+	// with --delay-delete, other commands could modify the snapshot log
+	// concurrently
+	if !opts.DryRun && !opts.DelayDelete {
+		return repository.CompactSnapshotLog(ctx, repo, printer)
+	}
+	return nil
+}
+
+// removeCheckpoints removes the checkpoint snapshots in ids, in dry-run mode
+// they are only reported.
+func removeCheckpoints(ctx context.Context, repo *repository.Repository, ids restic.IDSet, dryRun bool, printer progress.Printer) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	if dryRun {
+		printer.P("would remove %d obsolete checkpoint snapshots", len(ids))
+		return nil
+	}
+
+	printer.P("removing %d obsolete checkpoint snapshots", len(ids))
+	if err := repo.RecordSnapshotRemoval(ctx, ids); err != nil {
+		return err
+	}
+	bar := printer.NewCounter("files deleted")
+	defer bar.Done()
+	// the data of the checkpoints is about to be deleted, thus abort if any of
+	// them still exists
+	return restic.ParallelRemove(ctx, repo, ids, restic.WriteableSnapshotFile, func(id restic.ID, err error) error {
+		if err != nil {
+			return errors.Fatalf("unable to remove checkpoint %v/%v from the repository: %v", restic.SnapshotFile, id, err)
+		}
+		printer.VV("removed %v/%v\n", restic.SnapshotFile, id)
+		return nil
+	}, bar)
 }
 
 // printPruneStats prints out the statistics
@@ -268,6 +386,12 @@ func printPruneStats(printer progress.Printer, stats repository.PruneStats) erro
 	printer.P("remaining:    %10d blobs / %s", stats.Blobs.Remain, ui.FormatBytes(stats.Size.Remain))
 	printer.P("unused size after prune: %s (%s of remaining size)",
 		ui.FormatBytes(stats.Size.RemainUnused), ui.FormatPercent(stats.Size.RemainUnused, stats.Size.Remain))
+	if stats.Cost != nil {
+		printer.P("\nestimated cost over %g months:", stats.Cost.HorizonMonths)
+		printer.P("without prune: %10.2f", stats.Cost.WithoutPrune)
+		printer.P("with prune:    %10.2f (including %.2f to download and delete data)", stats.Cost.WithPrune, stats.Cost.Prune)
+		printer.P("savings:       %10.2f", stats.Cost.Savings)
+	}
 	printer.P("")
 	printer.V("totally used packs: %10d", stats.Packs.Used)
 	printer.V("partly used packs:  %10d", stats.Packs.PartlyUsed)
@@ -279,26 +403,48 @@ func printPruneStats(printer progress.Printer, stats repository.PruneStats) erro
 	if stats.Packs.Unref > 0 {
 		printer.V("to delete:    %10d unreferenced packs\n\n", stats.Packs.Unref)
 	}
+	if stats.Packs.Resurrect > 0 {
+		printer.V("to restore:   %10d packs pending deletion", stats.Packs.Resurrect)
+	}
+	if stats.Packs.Pending > 0 {
+		printer.V("pending:      %10d packs wait for deletion", stats.Packs.Pending)
+	}
 	return nil
 }
 
-func getUsedBlobs(ctx context.Context, repo restic.Repository, usedBlobs restic.FindBlobSet, ignoreSnapshots restic.IDSet, printer progress.Printer) error {
-	var snapshotTrees restic.IDs
+func getUsedBlobs(ctx context.Context, snapshotLister restic.Lister, repo restic.Repository, usedBlobs restic.FindBlobSet, ignoreSnapshots restic.IDSet, obsoleteCheckpoints restic.IDSet, printer progress.Printer) error {
+	var snapshots data.Snapshots
+	snapshotIDs := make(map[*data.Snapshot]restic.ID)
 	printer.P("loading all snapshots...")
-	err := data.ForAllSnapshots(ctx, repo, repo, ignoreSnapshots,
+	err := data.ForAllSnapshots(ctx, snapshotLister, repo, ignoreSnapshots,
 		func(id restic.ID, sn *data.Snapshot, err error) error {
 			if err != nil {
 				debug.Log("failed to load snapshot %v (error %v)", id, err)
 				return err
 			}
-			debug.Log("add snapshot %v (tree %v)", id, *sn.Tree)
-			snapshotTrees = append(snapshotTrees, *sn.Tree)
+			snapshots = append(snapshots, sn)
+			snapshotIDs[sn] = id
 			return nil
 		})
 	if err != nil {
 		return errors.Fatalf("failed loading snapshot: %v", err)
 	}
 
+	for _, sn := range data.ObsoleteCheckpoints(snapshots) {
+		debug.Log("skip obsolete checkpoint %v", snapshotIDs[sn])
+		obsoleteCheckpoints.Insert(snapshotIDs[sn])
+	}
+
+	var snapshotTrees restic.IDs
+	for _, sn := range snapshots {
+		id := snapshotIDs[sn]
+		if obsoleteCheckpoints.Has(id) {
+			continue
+		}
+		debug.Log("add snapshot %v (tree %v)", id, *sn.Tree)
+		snapshotTrees = append(snapshotTrees, *sn.Tree)
+	}
+
 	printer.P("finding data that is still in use for %d snapshots", len(snapshotTrees))
 
 	bar := printer.NewCounter("snapshots")
@@ -3,12 +3,15 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"io/fs"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/restic/restic/internal/backend"
 	"github.com/restic/restic/internal/global"
 	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
 	rtest "github.com/restic/restic/internal/test"
 )
 
@@ -283,3 +286,133 @@ func TestPruneJSON(t *testing.T) {
 	rtest.Assert(t, stats.Blobs.Total > 0, "expected non-zero total blobs, got %v", stats.Blobs.Total)
 	rtest.Assert(t, stats.Packs.Total > 0, "expected non-zero total packs, got %v", stats.Packs.Total)
 }
+
+func TestPruneCostModel(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	createPrunableRepo(t, env)
+	packsBefore := listPackFiles(t, env.repo)
+
+	buf, err := withCaptureStdout(t, env.gopts, func(ctx context.Context, gopts global.Options) error {
+		gopts.BackendTestHook = func(r backend.Backend) (backend.Backend, error) { return newListOnceBackend(r), nil }
+		gopts.Verbosity = 1
+		opts := PruneOptions{MaxUnused: "5%", CostModel: "b2,horizon=6", DryRun: true}
+		return runPrune(ctx, opts, gopts, gopts.Term)
+	})
+	rtest.OK(t, err)
+	rtest.Assert(t, strings.Contains(buf.String(), "estimated cost over 6 months:"), "missing cost estimate in output: %s", buf.String())
+	rtest.Assert(t, strings.Contains(buf.String(), "savings:"), "missing savings in output: %s", buf.String())
+	rtest.Equals(t, packsBefore, listPackFiles(t, env.repo))
+
+	testRunPruneMustFail(t, env.gopts, PruneOptions{MaxUnused: "5%", CostModel: "unknown"})
+}
+
+func listPackFiles(t testing.TB, dir string) restic.IDSet {
+	packs := restic.NewIDSet()
+	rtest.OK(t, filepath.WalkDir(filepath.Join(dir, "data"), func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		id, err := restic.ParseID(d.Name())
+		if err == nil {
+			packs.Insert(id)
+		}
+		return nil
+	}))
+	return packs
+}
+
+func TestPruneTiered(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	env.gopts.ColdRepo = filepath.Join(env.base, "cold")
+	createPrunableRepo(t, env)
+
+	treePacks := listTreePacks(env.gopts, t)
+	rtest.Equals(t, treePacks, listPackFiles(t, env.repo))
+	rtest.Assert(t, len(listPackFiles(t, env.gopts.ColdRepo)) > 0, "no data packs stored in cold repository")
+
+	// data packs in the cold repository are only repacked with --repack-cold
+	coldPacks := listPackFiles(t, env.gopts.ColdRepo)
+	testRunPrune(t, env.gopts, PruneOptions{MaxUnused: "0%"})
+	for id := range listPackFiles(t, env.gopts.ColdRepo) {
+		rtest.Assert(t, coldPacks.Has(id), "unexpected new pack %v in cold repository", id)
+	}
+	testRunCheckTiered(t, env.gopts)
+
+	testRunPrune(t, env.gopts, PruneOptions{MaxUnused: "0%", RepackCold: true})
+	testRunCheckTiered(t, env.gopts)
+	rtest.Equals(t, listTreePacks(env.gopts, t), listPackFiles(t, env.repo))
+}
+
+func TestTieredRequiresColdRepo(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	env.gopts.ColdRepo = filepath.Join(env.base, "cold")
+	testRunInit(t, env.gopts)
+
+	runCheckWithColdRepo := func(coldRepo string) error {
+		gopts := env.gopts
+		gopts.ColdRepo = coldRepo
+		return withTermStatus(t, gopts, func(ctx context.Context, gopts global.Options) error {
+			_, err := runCheck(context.TODO(), CheckOptions{}, gopts, nil, gopts.Term)
+			return err
+		})
+	}
+
+	err := runCheckWithColdRepo("")
+	rtest.Assert(t, err != nil && strings.Contains(err.Error(), "--cold-repo"), "expected error about missing cold repository, got %v", err)
+	rtest.OK(t, runCheckWithColdRepo(env.gopts.ColdRepo))
+
+	// a repository without cold repository cannot be opened with one
+	env2, cleanup2 := withTestEnvironment(t)
+	defer cleanup2()
+	testRunInit(t, env2.gopts)
+	env2.gopts.ColdRepo = filepath.Join(env2.base, "cold")
+	err = withTermStatus(t, env2.gopts, func(ctx context.Context, gopts global.Options) error {
+		_, err := runCheck(context.TODO(), CheckOptions{}, gopts, nil, gopts.Term)
+		return err
+	})
+	rtest.Assert(t, err != nil && strings.Contains(err.Error(), "not initialized with a cold repository"), "expected error, got %v", err)
+
+	// older repository versions cannot use a cold repository
+	env3, cleanup3 := withTestEnvironment(t)
+	defer cleanup3()
+	env3.gopts.ColdRepo = filepath.Join(env3.base, "cold")
+	err = withTermStatus(t, env3.gopts, func(ctx context.Context, gopts global.Options) error {
+		return runInit(ctx, InitOptions{RepositoryVersion: "2"}, gopts, nil, gopts.Term)
+	})
+	rtest.Assert(t, err != nil && strings.Contains(err.Error(), "requires at least repository version 3"), "expected error, got %v", err)
+}
+
+func testRunCheckTiered(t testing.TB, gopts global.Options) {
+	rtest.OK(t, withTermStatus(t, gopts, func(ctx context.Context, gopts global.Options) error {
+		_, err := runCheck(context.TODO(), CheckOptions{ReadData: true}, gopts, nil, gopts.Term)
+		return err
+	}))
+}
+
+func TestPruneDelayDelete(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	createPrunableRepo(t, env)
+	packsBefore := listPackFiles(t, env.repo)
+	opts := PruneOptions{MaxUnused: "0%", DelayDelete: true}
+
+	// the first run only marks unneeded packs for deletion
+	testRunPrune(t, env.gopts, opts)
+	packsMarked := listPackFiles(t, env.repo)
+	rtest.Equals(t, 0, len(packsBefore.Sub(packsMarked)))
+	testRunCheck(t, env.gopts)
+
+	// the second run deletes them, as all locks are newer than the mark
+	testRunPrune(t, env.gopts, opts)
+	packsAfter := listPackFiles(t, env.repo)
+	rtest.Assert(t, len(packsMarked.Sub(packsAfter)) > 0, "no packs were deleted")
+	rtest.Equals(t, 0, len(packsAfter.Sub(packsMarked)))
+	testRunCheck(t, env.gopts)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/global"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/ui"
+	"github.com/restic/restic/internal/ui/progress"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+func newRecompressCommand(globalOptions *global.Options) *cobra.Command {
+	var opts RecompressOptions
+
+	cmd := &cobra.Command{
+		Use:   "recompress [flags]",
+		Short: "Compress existing data at a higher compression level",
+		Long: `
+The "recompress" command compresses the data in the repository again using the
+compression level given by --level. Only pack files containing data which was
+compressed at a lower level, or whose compression level is unknown, are
+examined. A pack file is rewritten if this reduces its size by at least the
+percentage given by --min-savings. Otherwise, the pack file is kept and
+recorded in the index as compressed at the given level, such that later runs
+skip it. Uncompressed data is not modified.
+
+Use --max-repack-size to split the work into several runs.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful.
+Exit status is 1 if there was any error.
+Exit status is 10 if the repository does not exist.
+Exit status is 11 if the repository is already locked.
+Exit status is 12 if the password is incorrect.
+`,
+		GroupID:           cmdGroupDefault,
+		DisableAutoGenTag: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runRecompress(cmd.Context(), opts, *globalOptions, args, globalOptions.Term)
+		},
+	}
+
+	opts.AddFlags(cmd.Flags())
+	return cmd
+}
+
+// RecompressOptions collects all options for the recompress command.
+type RecompressOptions struct {
+	Level         repository.CompressionMode
+	MinSavings    float64
+	MaxRepackSize string
+	DryRun        bool
+}
+
+func (opts *RecompressOptions) AddFlags(f *pflag.FlagSet) {
+	opts.Level = repository.CompressionMax
+	f.Var(&opts.Level, "level", "compression `level` to use, one of (auto|fastest|better|max)")
+	f.Float64Var(&opts.MinSavings, "min-savings", 5, "only rewrite pack files whose size is reduced by at least this `percentage`")
+	f.StringVar(&opts.MaxRepackSize, "max-repack-size", "", "stop after examining this much data in total (allowed suffixes for `size`: k/K, m/M, g/G, t/T)")
+	f.BoolVarP(&opts.DryRun, "dry-run", "n", false, "do not modify the repository, just print what would be done")
+}
+
+func runRecompress(ctx context.Context, opts RecompressOptions, gopts global.Options, args []string, term ui.Terminal) error {
+	if len(args) > 0 {
+		return errors.Fatal("the recompress command expects no arguments, only options - please see `restic help recompress` for usage and flags")
+	}
+	if opts.Level == repository.CompressionOff {
+		return errors.Fatal("--level off is not supported, recompress does not decompress data")
+	}
+	if gopts.NoLock && !opts.DryRun {
+		return errors.Fatal("--no-lock is only applicable in combination with --dry-run for recompress command")
+	}
+
+	repoOpts := repository.RecompressOptions{MinSavings: opts.MinSavings}
+	if len(opts.MaxRepackSize) > 0 {
+		size, err := ui.ParseBytes(opts.MaxRepackSize)
+		if err != nil {
+			return err
+		}
+		repoOpts.MaxRepackBytes = uint64(size)
+	}
+
+	// new pack files are compressed at the target level
+	gopts.Compression = opts.Level
+
+	printer := progress.NewTerminalPrinter(false, gopts.Verbosity, term)
+	ctx, repo, unlock, err := openWithExclusiveLock(ctx, gopts, opts.DryRun && gopts.NoLock, printer)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	plan, err := repository.PlanRecompress(ctx, repo, repoOpts, printer)
+	if err != nil {
+		return err
+	}
+
+	stats := plan.Stats()
+	printer.P("\npacks with data compressed at a lower level: %d / %s", stats.Packs, ui.FormatBytes(stats.PackSize))
+	printer.P("packs to examine in this run:                %d / %s\n", stats.Selected, ui.FormatBytes(stats.SelectedSize))
+	if opts.DryRun {
+		return nil
+	}
+
+	err = plan.Execute(ctx, printer)
+	if err != nil {
+		return err
+	}
+
+	stats = plan.Stats()
+	printer.P("rewrote %d packs, %s of data was reduced to %s", stats.Rewritten, ui.FormatBytes(stats.OldSize), ui.FormatBytes(stats.NewSize))
+	printer.P("kept %d packs which did not compress better", stats.Unchanged)
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/restic/restic/internal/global"
+	"github.com/restic/restic/internal/repository"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func testRunRecompress(t testing.TB, gopts global.Options, opts RecompressOptions) {
+	rtest.OK(t, withTermStatus(t, gopts, func(ctx context.Context, gopts global.Options) error {
+		return runRecompress(ctx, opts, gopts, []string{}, gopts.Term)
+	}))
+}
+
+func TestRecompress(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	datadir := filepath.Join(env.testdata, "data")
+	rtest.OK(t, os.MkdirAll(datadir, 0755))
+	for _, name := range []string{"a", "b", "c"} {
+		rtest.OK(t, appendRandomData(filepath.Join(datadir, name), 100*1024))
+	}
+	backupOpts := env.gopts
+	backupOpts.Compression = repository.CompressionFastest
+	testRunBackup(t, "", []string{datadir}, BackupOptions{}, backupOpts)
+	snapshotIDs := testListSnapshots(t, env.gopts, 1)
+
+	// a dry run does not modify the repository
+	oldPacks := listPacks(env.gopts, t)
+	testRunRecompress(t, env.gopts, RecompressOptions{Level: repository.CompressionMax, DryRun: true})
+	rtest.Equals(t, oldPacks, listPacks(env.gopts, t))
+
+	testRunRecompress(t, env.gopts, RecompressOptions{Level: repository.CompressionMax})
+	testRunCheck(t, env.gopts)
+
+	restoredir := filepath.Join(env.base, "restore")
+	testRunRestore(t, env.gopts, restoredir, snapshotIDs[0].String())
+	diff := directoriesContentsDiff(t, datadir, filepath.Join(restoredir, datadir))
+	rtest.Assert(t, diff == "", "directories are not equal: %v", diff)
+
+	// all packs were examined, a second run does not modify the repository
+	packs := listPacks(env.gopts, t)
+	testRunRecompress(t, env.gopts, RecompressOptions{Level: repository.CompressionMax})
+	rtest.Equals(t, packs, listPacks(env.gopts, t))
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/global"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/ui"
+	"github.com/restic/restic/internal/ui/progress"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+func newRekeyCommand(globalOptions *global.Options) *cobra.Command {
+	var opts RekeyOptions
+
+	cmd := &cobra.Command{
+		Use:   "rekey [flags]",
+		Short: "Re-encrypt the repository using a new master key",
+		Long: `
+The "rekey" command generates a new master key and re-encrypts all pack files,
+index files, snapshots and the config of the repository using it. Afterwards,
+a single new key (password) is added for the new master key and all other keys
+are removed. Use "key add" to add further passwords afterwards.
+
+As the IDs of snapshots are derived from their encrypted content, all snapshots
+get a new ID.
+
+The key for the new password is created when the rekey starts. The rekey can be
+interrupted at any time and is resumed by running the command again. While a
+rekey is pending, the repository can only be accessed using the new password.
+Use --dry-run to show how much data would be rewritten.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful.
+Exit status is 1 if there was any error.
+Exit status is 10 if the repository does not exist.
+Exit status is 11 if the repository is already locked.
+Exit status is 12 if the password is incorrect.
+`,
+		GroupID:           cmdGroupDefault,
+		DisableAutoGenTag: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runRekey(cmd.Context(), opts, *globalOptions, args, globalOptions.Term)
+		},
+	}
+
+	opts.AddFlags(cmd.Flags())
+	return cmd
+}
+
+// RekeyOptions collects all options for the rekey command.
+type RekeyOptions struct {
+	KeyAddOptions
+	DryRun bool
+}
+
+func (opts *RekeyOptions) AddFlags(f *pflag.FlagSet) {
+	opts.KeyAddOptions.Add(f)
+	f.BoolVarP(&opts.DryRun, "dry-run", "n", false, "do not modify the repository, just print what would be done")
+}
+
+func runRekey(ctx context.Context, opts RekeyOptions, gopts global.Options, args []string, term ui.Terminal) error {
+	if len(args) > 0 {
+		return errors.Fatal("the rekey command expects no arguments, only options - please see `restic help rekey` for usage and flags")
+	}
+	if gopts.NoLock && !opts.DryRun {
+		return errors.Fatal("--no-lock is only applicable in combination with --dry-run for rekey command")
+	}
+
+	printer := progress.NewTerminalPrinter(false, gopts.Verbosity, term)
+	ctx, repo, unlock, err := openWithExclusiveLock(ctx, gopts, opts.DryRun && gopts.NoLock, printer)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return rekeyRepository(ctx, repo, gopts, opts, printer)
+}
+
+func rekeyRepository(ctx context.Context, repo *repository.Repository, gopts global.Options, opts RekeyOptions, printer progress.Printer) error {
+	plan, err := repository.PlanRekey(ctx, repo, func(ctx context.Context, snapshotLister restic.Lister, repo restic.Repository, usedBlobs restic.FindBlobSet) error {
+		return getUsedBlobs(ctx, snapshotLister, repo, usedBlobs, restic.NewIDSet(), restic.NewIDSet(), printer)
+	}, printer)
+	if err != nil {
+		return err
+	}
+
+	stats := plan.Stats()
+	if plan.Resume() {
+		printer.P("\nfound an interrupted rekey, remaining data to re-encrypt:")
+	} else {
+		printer.P("\ndata to re-encrypt:")
+	}
+	printer.P("packs:          %10d / %s (%d packs pending deletion, %d unindexed packs)", stats.RepackPacks, ui.FormatBytes(stats.PackSize), stats.PendingPacks, stats.UnindexedPacks)
+	printer.P("indexes:        %10d / %s", stats.Indexes, ui.FormatBytes(stats.IndexSize))
+	printer.P("snapshots:      %10d / %s", stats.Snapshots, ui.FormatBytes(stats.SnapshotSize))
+	printer.P("snapshot log:   %10d / %s", stats.LogEntries, ui.FormatBytes(stats.LogEntrySize))
+	printer.P("total:          %10s\n", ui.FormatBytes(stats.TotalSize()))
+
+	if opts.DryRun {
+		return nil
+	}
+
+	rekeyOpts := repository.RekeyOptions{
+		Username: opts.Username,
+		Hostname: opts.Hostname,
+	}
+	// the key file for the new password is created when starting the rekey
+	if !plan.Resume() {
+		rekeyOpts.Password, err = getNewPassword(ctx, gopts, opts.NewPasswordFile, opts.InsecureNoPassword)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = plan.Execute(ctx, rekeyOpts, printer)
+	if err != nil {
+		return err
+	}
+
+	printer.P("rekey completed, all other keys were removed")
+	return nil
+}
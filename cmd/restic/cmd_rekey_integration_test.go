@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/restic/restic/internal/global"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func testRunRekey(t testing.TB, newPassword string, gopts global.Options, opts RekeyOptions) {
+	testKeyNewPassword = newPassword
+	defer func() {
+		testKeyNewPassword = ""
+	}()
+
+	rtest.OK(t, withTermStatus(t, gopts, func(ctx context.Context, gopts global.Options) error {
+		return runRekey(ctx, opts, gopts, []string{}, gopts.Term)
+	}))
+}
+
+func TestRekey(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	// must list keys and snapshots more than once
+	env.gopts.BackendTestHook = nil
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+	testRunKeyAddNewKey(t, "other password", env.gopts)
+
+	datadir := filepath.Join(env.testdata, "data")
+	rtest.OK(t, os.MkdirAll(datadir, 0755))
+	for _, name := range []string{"a", "b", "c"} {
+		rtest.OK(t, appendRandomData(filepath.Join(datadir, name), 100*1024))
+	}
+	testRunBackup(t, "", []string{datadir}, BackupOptions{}, env.gopts)
+	oldSnapshots := testListSnapshots(t, env.gopts, 1)
+	oldPacks := listPacks(env.gopts, t)
+
+	// a dry run does not modify the repository
+	testRunRekey(t, "", env.gopts, RekeyOptions{DryRun: true})
+	rtest.Equals(t, oldSnapshots, testListSnapshots(t, env.gopts, 1))
+
+	testRunRekey(t, "new password", env.gopts, RekeyOptions{})
+	env.gopts.Password = "new password"
+	rtest.Equals(t, 0, len(testRunKeyListOtherIDs(t, env.gopts)))
+
+	newSnapshots := testListSnapshots(t, env.gopts, 1)
+	rtest.Assert(t, newSnapshots[0] != oldSnapshots[0], "snapshot ID did not change")
+	rtest.Equals(t, 0, len(listPacks(env.gopts, t).Intersect(oldPacks)))
+	testRunCheck(t, env.gopts)
+
+	restoredir := filepath.Join(env.base, "restore")
+	testRunRestore(t, env.gopts, restoredir, newSnapshots[0].String())
+	diff := directoriesContentsDiff(t, datadir, filepath.Join(restoredir, datadir))
+	rtest.Assert(t, diff == "", "directories are not equal: %v", diff)
+
+	// the old passwords no longer work
+	env.gopts.Password = "other password"
+	testRunCheckMustFail(t, env.gopts)
+}
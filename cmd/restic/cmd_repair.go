@@ -11,7 +11,8 @@ func newRepairCommand(globalOptions *global.Options) *cobra.Command {
 		Short: "Repair the repository",
 		Long: `
 The "repair" command repairs damaged repositories. It provides subcommands to
-rebuild the index, salvage damaged pack files, and repair broken snapshots.
+rebuild the index, salvage damaged pack files, repair broken snapshots, and
+start or accept the state of the snapshot log.
 `,
 		GroupID:           cmdGroupDefault,
 		DisableAutoGenTag: true,
@@ -21,6 +22,7 @@ rebuild the index, salvage damaged pack files, and repair broken snapshots.
 		newRepairIndexCommand(globalOptions),
 		newRepairPacksCommand(globalOptions),
 		newRepairSnapshotsCommand(globalOptions),
+		newRepairSnapshotLogCommand(globalOptions),
 	)
 	return cmd
 }
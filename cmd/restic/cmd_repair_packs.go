@@ -13,9 +13,12 @@ import (
 	"github.com/restic/restic/internal/ui"
 	"github.com/restic/restic/internal/ui/progress"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 func newRepairPacksCommand(globalOptions *global.Options) *cobra.Command {
+	var opts RepairPacksOptions
+
 	cmd := &cobra.Command{
 		Use:   "packs [packIDs...]",
 		Short: "Salvage damaged pack files",
@@ -23,6 +26,12 @@ func newRepairPacksCommand(globalOptions *global.Options) *cobra.Command {
 The "repair packs" command extracts intact blobs from the specified pack files, rebuilds
 the index to remove the damaged pack files and removes the pack files from the repository.
 
+With --use-parity, the command first tries to reconstruct the damaged pack files using the
+recovery data stored for them, see the --parity-redundancy option. If the backend cannot
+atomically replace files, the blobs of a reconstructed pack file are stored in new pack
+files before the damaged one is removed. Only pack files which cannot be reconstructed are
+salvaged as described above.
+
 EXIT STATUS
 ===========
 
@@ -34,13 +43,24 @@ Exit status is 12 if the password is incorrect.
 `,
 		DisableAutoGenTag: true,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return runRepairPacks(cmd.Context(), *globalOptions, globalOptions.Term, args)
+			return runRepairPacks(cmd.Context(), opts, *globalOptions, globalOptions.Term, args)
 		},
 	}
+
+	opts.AddFlags(cmd.Flags())
 	return cmd
 }
 
-func runRepairPacks(ctx context.Context, gopts global.Options, term ui.Terminal, args []string) error {
+// RepairPacksOptions collects all options for the repair packs command.
+type RepairPacksOptions struct {
+	UseParity bool
+}
+
+func (opts *RepairPacksOptions) AddFlags(f *pflag.FlagSet) {
+	f.BoolVar(&opts.UseParity, "use-parity", false, "reconstruct damaged pack files using their parity files")
+}
+
+func runRepairPacks(ctx context.Context, opts RepairPacksOptions, gopts global.Options, term ui.Terminal, args []string) error {
 	ids := restic.NewIDSet()
 	for _, arg := range args {
 		id, err := restic.ParseID(arg)
@@ -87,6 +107,16 @@ func runRepairPacks(ctx context.Context, gopts global.Options, term ui.Terminal,
 		}
 	}
 
+	if opts.UseParity {
+		ids, err = repository.ReconstructPacks(ctx, repo, ids, printer)
+		if err != nil {
+			return errors.Fatalf("%s", err)
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+	}
+
 	err = repository.RepairPacks(ctx, repo, ids, printer)
 	if err != nil {
 		return errors.Fatalf("%s", err)
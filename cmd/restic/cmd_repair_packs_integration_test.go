@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/restic/restic/internal/global"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func testRunRepairPacks(t testing.TB, opts RepairPacksOptions, gopts global.Options, ids restic.IDs) {
+	t.Helper()
+	var args []string
+	for _, id := range ids {
+		args = append(args, id.String())
+	}
+	// repair packs stores backup copies of the pack files in the current directory
+	defer rtest.Chdir(t, rtest.TempDir(t))()
+	rtest.OK(t, withTermStatus(t, gopts, func(ctx context.Context, gopts global.Options) error {
+		return runRepairPacks(ctx, opts, gopts, gopts.Term, args)
+	}))
+}
+
+func TestRepairPacksUseParity(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	// the parity redundancy is stored in the config by init and used by backup
+	env.gopts.ParityRedundancy = 10
+	testSetupBackupData(t, env)
+	env.gopts.ParityRedundancy = 0
+	testRunBackup(t, "", []string{env.testdata}, BackupOptions{}, env.gopts)
+	testRunCheck(t, env.gopts)
+
+	packs := listPackFiles(t, env.repo)
+	id := packs.List()[0]
+	filename := filepath.Join(env.repo, "data", id.String()[:2], id.String())
+	buf, err := os.ReadFile(filename)
+	rtest.OK(t, err)
+	buf[len(buf)/3] ^= 0xff
+	// pack files are read-only
+	rtest.OK(t, os.Chmod(filename, 0o600))
+	rtest.OK(t, os.WriteFile(filename, buf, 0o600))
+
+	var summary checkSummary
+	rtest.Assert(t, withTermStatus(t, env.gopts, func(ctx context.Context, gopts global.Options) error {
+		summary, err = runCheck(ctx, CheckOptions{ReadData: true}, gopts, nil, gopts.Term)
+		return err
+	}) != nil, "expected check of damaged repository to fail")
+	rtest.Equals(t, []string{id.String()}, summary.BrokenPacks)
+	rtest.Equals(t, []string{id.String()}, summary.RecoverablePacks)
+
+	testRunRepairPacks(t, RepairPacksOptions{UseParity: true}, env.gopts, restic.IDs{id})
+	testRunCheck(t, env.gopts)
+	rtest.Equals(t, packs, listPackFiles(t, env.repo))
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/global"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/ui"
+	"github.com/restic/restic/internal/ui/progress"
+	"github.com/spf13/cobra"
+)
+
+func newRepairSnapshotLogCommand(globalOptions *global.Options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "snapshot-log",
+		Short: "Start the snapshot log or accept the current repository state",
+		Long: `
+The "repair snapshot-log" command records the current set of snapshots in the
+snapshot log. The snapshot log is an encrypted, hash-chained record of all added
+and removed snapshots, which allows detecting deleted snapshots and rollbacks of
+the repository to an older state.
+
+If the repository does not have a snapshot log yet, the command starts the log
+with all existing snapshots. Afterwards, all commands which add or remove
+snapshots record these changes in the log.
+
+If "check" reports missing snapshots or log entries, first investigate the cause.
+Then, this command accepts the current state of the repository such that these
+are no longer reported. Snapshots which are not recorded in the log, for example
+as they were created by an older restic version, are added to the log.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful.
+Exit status is 1 if there was any error.
+Exit status is 10 if the repository does not exist.
+Exit status is 11 if the repository is already locked.
+Exit status is 12 if the password is incorrect.
+`,
+		DisableAutoGenTag: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runRepairSnapshotLog(cmd.Context(), *globalOptions, globalOptions.Term, args)
+		},
+	}
+	return cmd
+}
+
+func runRepairSnapshotLog(ctx context.Context, gopts global.Options, term ui.Terminal, args []string) error {
+	if len(args) > 0 {
+		return errors.Fatal("the repair snapshot-log command expects no arguments")
+	}
+	printer := progress.NewTerminalPrinter(false, gopts.Verbosity, term)
+
+	// the state is verified below
+	gopts.SkipSnapshotLogCheck = true
+	ctx, repo, unlock, err := openWithExclusiveLock(ctx, gopts, false, printer)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	status, err := repository.VerifySnapshotLog(ctx, repo, repo.Cache(), repo)
+	if err != nil {
+		return err
+	}
+
+	if !status.Active {
+		printer.P("starting snapshot log with %d snapshots\n", len(status.UnrecordedSnapshots))
+	} else {
+		if len(status.MissingEntries) > 0 {
+			printer.P("accepting %d missing snapshot log entries\n", len(status.MissingEntries))
+		}
+		if len(status.MissingSnapshots) > 0 {
+			printer.P("recording %d missing snapshots as removed\n", len(status.MissingSnapshots))
+			for _, id := range status.MissingSnapshots {
+				printer.V("  %v\n", id)
+			}
+		}
+		if len(status.UnrecordedSnapshots) > 0 {
+			printer.P("recording %d unrecorded snapshots as added\n", len(status.UnrecordedSnapshots))
+			for _, id := range status.UnrecordedSnapshots {
+				printer.V("  %v\n", id)
+			}
+		}
+	}
+
+	id, err := repository.RepairSnapshotLog(ctx, repo, status)
+	if err != nil {
+		return errors.Fatalf("%s", err)
+	}
+	printer.V("saved snapshot log entry %v\n", id)
+
+	printer.P("done\n")
+	return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/restic/restic/internal/global"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func testRunRepairSnapshotLog(t testing.TB, gopts global.Options) {
+	t.Helper()
+	rtest.OK(t, withTermStatus(t, gopts, func(ctx context.Context, gopts global.Options) error {
+		return runRepairSnapshotLog(ctx, gopts, gopts.Term, nil)
+	}))
+}
+
+func TestRepairSnapshotLog(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	testRunBackup(t, "", []string{env.testdata}, BackupOptions{}, env.gopts)
+	testRunRepairSnapshotLog(t, env.gopts)
+	testRunBackup(t, "", []string{env.testdata}, BackupOptions{}, env.gopts)
+	testRunCheck(t, env.gopts)
+
+	// delete a snapshot without recording it in the log
+	snapshotIDs := testListSnapshots(t, env.gopts, 2)
+	rtest.OK(t, os.Remove(filepath.Join(env.repo, "snapshots", snapshotIDs[0].String())))
+
+	summary, err := testRunCheckSummary(t, env.gopts)
+	rtest.Assert(t, err != nil, "expected check to report the missing snapshot")
+	rtest.Assert(t, summary.HintRepairSnapshotLog, "expected hint to repair the snapshot log")
+
+	testRunRepairSnapshotLog(t, env.gopts)
+	summary, err = testRunCheckSummary(t, env.gopts)
+	rtest.OK(t, err)
+	rtest.Assert(t, !summary.HintRepairSnapshotLog, "unexpected hint to repair the snapshot log")
+
+	// forget records the removal of several snapshots in a single entry
+	testRunBackup(t, "", []string{env.testdata}, BackupOptions{}, env.gopts)
+	ids := testListSnapshots(t, env.gopts, 2)
+	entries := len(testRunList(t, env.gopts, "snapshotlog"))
+	testRunForget(t, env.gopts, ForgetOptions{}, ids[0].String(), ids[1].String())
+	rtest.Equals(t, entries+1, len(testRunList(t, env.gopts, "snapshotlog")))
+
+	// prune compacts the log
+	testRunPrune(t, env.gopts, PruneOptions{MaxUnused: "0"})
+	rtest.Equals(t, 1, len(testRunList(t, env.gopts, "snapshotlog")))
+	summary, err = testRunCheckSummary(t, env.gopts)
+	rtest.OK(t, err)
+	rtest.Assert(t, !summary.HintRepairSnapshotLog, "unexpected hint to repair the snapshot log")
+}
+
+func testRunCheckSummary(t testing.TB, gopts global.Options) (checkSummary, error) {
+	var summary checkSummary
+	err := withTermStatus(t, gopts, func(ctx context.Context, gopts global.Options) error {
+		var err error
+		summary, err = runCheck(ctx, CheckOptions{}, gopts, nil, gopts.Term)
+		return err
+	})
+	return summary, err
+}
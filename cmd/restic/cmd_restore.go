@@ -6,6 +6,7 @@ import (
 	"runtime"
 	"time"
 
+	"github.com/restic/restic/internal/backend"
 	"github.com/restic/restic/internal/data"
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/errors"
@@ -162,6 +163,19 @@ func runRestore(ctx context.Context, opts RestoreOptions, gopts global.Options,
 		return err
 	}
 
+	if backend.WarmupEnabled() {
+		// pending warmup requests are not repeated, the restore only waits for them
+		warmupState, err := repo.LoadWarmupState()
+		if err != nil {
+			printer.E("unable to load warmup state: %v", err)
+		}
+		if warmupState != nil && time.Now().Before(warmupState.ReadyBy) && !gopts.JSON {
+			printer.P("resuming warmup of %d pack files requested at %s, expected to be ready by %s",
+				len(warmupState.Packs), warmupState.Requested.Format(global.TimeFormat),
+				warmupState.ReadyBy.Format(global.TimeFormat))
+		}
+	}
+
 	sn.Tree, err = data.FindTreeDirectory(ctx, repo, sn.Tree, subfolder)
 	if err != nil {
 		return err
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/data"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/global"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/ui"
+	"github.com/restic/restic/internal/ui/progress"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+func newWarmupCommand(globalOptions *global.Options) *cobra.Command {
+	var opts WarmupOptions
+
+	cmd := &cobra.Command{
+		Use:   "warmup [flags] snapshotID",
+		Short: "Request pack files of a snapshot from cold storage",
+		Long: `
+The "warmup" command requests the backend to move all pack files required to
+restore a snapshot from cold storage into hot storage. This is only necessary
+for backends which store data in storage classes that are not immediately
+accessible, such as S3 Glacier or the Azure archive tier.
+
+The command reports how many pack files and bytes have to be warmed up and an
+estimate for the time until the data is available. Warmup requests are recorded
+in the local cache, such that a later "restore" command can continue once the
+data is available. Use --wait to block until all pack files are warm.
+
+The special snapshotID "latest" can be used to warm up the latest snapshot in
+the repository. To only warm up a specific subfolder, you can use the
+"snapshotID:subfolder" syntax.
+
+This command requires the "cold-storage-restore" feature flag and
+"-o s3.enable-restore=true" or "-o azure.enable-rehydrate=true".
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful.
+Exit status is 1 if there was any error.
+Exit status is 10 if the repository does not exist.
+Exit status is 11 if the repository is already locked.
+Exit status is 12 if the password is incorrect.
+`,
+		GroupID:           cmdGroupDefault,
+		DisableAutoGenTag: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			finalizeSnapshotFilter(&opts.SnapshotFilter)
+			return runWarmup(cmd.Context(), opts, *globalOptions, globalOptions.Term, args)
+		},
+	}
+
+	opts.AddFlags(cmd.Flags())
+	return cmd
+}
+
+// WarmupOptions collects all options for the warmup command.
+type WarmupOptions struct {
+	data.SnapshotFilter
+	Wait           bool
+	CostPerGiB     float64
+	CostPerRequest float64
+}
+
+func (opts *WarmupOptions) AddFlags(f *pflag.FlagSet) {
+	initSingleSnapshotFilter(f, &opts.SnapshotFilter)
+	f.BoolVar(&opts.Wait, "wait", false, "wait until all pack files are warm")
+	f.Float64Var(&opts.CostPerGiB, "cost-per-gib", 0, "retrieval `cost` per GiB used to estimate the cost of the warmup")
+	f.Float64Var(&opts.CostPerRequest, "cost-per-request", 0, "retrieval `cost` per request used to estimate the cost of the warmup")
+}
+
+type warmupSummary struct {
+	MessageType       string     `json:"message_type"` // "summary"
+	SnapshotID        string     `json:"snapshot_id"`
+	TotalPacks        int        `json:"total_packs"`
+	TotalBytes        uint64     `json:"total_bytes"`
+	WarmingUpPacks    int        `json:"warming_up_packs"`
+	WarmingUpBytes    uint64     `json:"warming_up_bytes"`
+	Requested         *time.Time `json:"requested,omitempty"`
+	ReadyBy           *time.Time `json:"ready_by,omitempty"`
+	EstimatedDuration float64    `json:"estimated_duration_seconds,omitempty"`
+	EstimatedCost     float64    `json:"estimated_cost,omitempty"`
+	WaitedForWarmup   bool       `json:"waited_for_warmup"`
+}
+
+func runWarmup(ctx context.Context, opts WarmupOptions, gopts global.Options, term ui.Terminal, args []string) error {
+	switch {
+	case len(args) == 0:
+		return errors.Fatal("no snapshot ID specified")
+	case len(args) > 1:
+		return errors.Fatalf("more than one snapshot ID specified: %v", args)
+	}
+	if !backend.WarmupEnabled() {
+		return errors.Fatal("the warmup command requires the cold-storage-restore feature flag")
+	}
+
+	printer := progress.NewTerminalPrinter(gopts.JSON, gopts.Verbosity, term)
+
+	ctx, repo, unlock, err := openWithReadLock(ctx, gopts, gopts.NoLock, printer)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	sn, subfolder, err := opts.SnapshotFilter.FindLatest(ctx, repo, repo, args[0])
+	if err != nil {
+		return errors.Fatalf("failed to find snapshot: %v", err)
+	}
+
+	if err = repo.LoadIndex(ctx, printer); err != nil {
+		return err
+	}
+
+	treeID, err := data.FindTreeDirectory(ctx, repo, sn.Tree, subfolder)
+	if err != nil {
+		return err
+	}
+
+	printer.P("collecting pack files of snapshot %v", sn.ID().Str())
+	packs, err := findSnapshotPacks(ctx, repo, *treeID, printer)
+	if err != nil {
+		return err
+	}
+
+	packSizes := make(map[restic.ID]int64, len(packs))
+	err = repo.List(ctx, restic.PackFile, func(id restic.ID, size int64) error {
+		if packs.Has(id) {
+			packSizes[id] = size
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	summary := warmupSummary{
+		MessageType: "summary",
+		SnapshotID:  sn.ID().String(),
+		TotalPacks:  len(packs),
+	}
+	for _, size := range packSizes {
+		summary.TotalBytes += uint64(size)
+	}
+
+	job, err := repo.StartWarmup(ctx, packs)
+	if err != nil {
+		return err
+	}
+	for id := range job.Packs() {
+		summary.WarmingUpPacks++
+		summary.WarmingUpBytes += uint64(packSizes[id])
+	}
+	summary.EstimatedCost = opts.CostPerGiB*float64(summary.WarmingUpBytes)/(1<<30) +
+		opts.CostPerRequest*float64(summary.WarmingUpPacks)
+
+	if summary.WarmingUpPacks > 0 {
+		estimate := repo.WarmupEstimate()
+		summary.EstimatedDuration = estimate.Seconds()
+
+		state, err := repo.LoadWarmupState()
+		if err != nil {
+			printer.E("unable to load warmup state: %v", err)
+		}
+		if state != nil {
+			summary.Requested = &state.Requested
+			summary.ReadyBy = &state.ReadyBy
+		}
+	}
+
+	if !gopts.JSON {
+		printWarmupSummary(printer, summary)
+	}
+
+	if opts.Wait && summary.WarmingUpPacks > 0 {
+		printer.P("waiting for %d pack files to be warm, this may take a while...", summary.WarmingUpPacks)
+		if err := job.Wait(ctx); err != nil {
+			return err
+		}
+		summary.WaitedForWarmup = true
+		printer.P("all pack files are warm")
+	}
+
+	if gopts.JSON {
+		term.Print(ui.ToJSONString(summary))
+	}
+	return nil
+}
+
+// findSnapshotPacks returns the pack files which contain blobs referenced by the tree.
+func findSnapshotPacks(ctx context.Context, repo *repository.Repository, treeID restic.ID, printer progress.Printer) (restic.IDSet, error) {
+	bar := printer.NewCounter("trees loaded")
+	blobs := repo.NewAssociatedBlobSet()
+	err := data.FindUsedBlobs(ctx, repo, restic.IDs{treeID}, blobs, bar)
+	bar.Done()
+	if err != nil {
+		return nil, err
+	}
+
+	packs := restic.NewIDSet()
+	for bh := range blobs.Keys() {
+		pbs := repo.LookupBlob(bh)
+		if len(pbs) == 0 {
+			return nil, errors.Fatalf("blob %v not found in index", bh)
+		}
+		packs.Insert(pbs[0].PackID())
+	}
+	return packs, nil
+}
+
+func printWarmupSummary(printer progress.Printer, summary warmupSummary) {
+	printer.P("%d pack files (%s) are required to restore the snapshot",
+		summary.TotalPacks, ui.FormatBytes(summary.TotalBytes))
+	if summary.WarmingUpPacks == 0 {
+		printer.P("all pack files are warm")
+		return
+	}
+
+	printer.P("%d pack files (%s) are warming up", summary.WarmingUpPacks, ui.FormatBytes(summary.WarmingUpBytes))
+	if summary.Requested != nil {
+		printer.P("warmup requested at %s", summary.Requested.Format(global.TimeFormat))
+	}
+	if summary.EstimatedDuration > 0 && summary.ReadyBy != nil {
+		printer.P("expected to be available by %s", summary.ReadyBy.Format(global.TimeFormat))
+	}
+	if summary.EstimatedCost > 0 {
+		printer.P("estimated retrieval cost: %.2f", summary.EstimatedCost)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/restic/restic/internal/feature"
+	"github.com/restic/restic/internal/global"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func testRunWarmup(t testing.TB, gopts global.Options, opts WarmupOptions, snapshotID string) warmupSummary {
+	buf, err := withCaptureStdout(t, gopts, func(ctx context.Context, gopts global.Options) error {
+		gopts.JSON = true
+		return runWarmup(ctx, opts, gopts, gopts.Term, []string{snapshotID})
+	})
+	rtest.OK(t, err)
+
+	var summary warmupSummary
+	rtest.OK(t, json.Unmarshal(buf.Bytes(), &summary))
+	return summary
+}
+
+func TestWarmup(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	testRunBackup(t, "", []string{env.testdata}, BackupOptions{}, env.gopts)
+
+	err := withTermStatus(t, env.gopts, func(ctx context.Context, gopts global.Options) error {
+		return runWarmup(ctx, WarmupOptions{}, gopts, gopts.Term, []string{"latest"})
+	})
+	rtest.Assert(t, err != nil, "expected warmup to fail without feature flag")
+
+	defer feature.TestSetFlag(t, feature.Flag, feature.ColdStorageRestore, true)()
+	summary := testRunWarmup(t, env.gopts, WarmupOptions{Wait: true}, "latest")
+	rtest.Equals(t, "summary", summary.MessageType)
+	rtest.Assert(t, summary.TotalPacks > 0, "expected pack files for snapshot")
+	rtest.Assert(t, summary.TotalBytes > 0, "expected non-zero size")
+	// the local backend has no cold storage
+	rtest.Equals(t, 0, summary.WarmingUpPacks)
+	rtest.Equals(t, false, summary.WaitedForWarmup)
+}
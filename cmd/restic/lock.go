@@ -2,13 +2,25 @@ package main
 
 import (
 	"context"
+	"time"
 
 	"github.com/restic/restic/internal/global"
 	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/ui/progress"
 )
 
-func internalOpenWithLocked(ctx context.Context, gopts global.Options, dryRun bool, exclusive bool, printer progress.Printer) (context.Context, *repository.Repository, func(), error) {
+// lockFunc acquires a lock for the repository.
+type lockFunc func(ctx context.Context, repo *repository.Repository, retryLock time.Duration, printRetry func(msg string), logger func(format string, args ...interface{})) (repository.Unlocker, context.Context, error)
+
+func lockShared(ctx context.Context, repo *repository.Repository, retryLock time.Duration, printRetry func(msg string), logger func(format string, args ...interface{})) (repository.Unlocker, context.Context, error) {
+	return repository.LockRepo(ctx, repo, false, retryLock, printRetry, logger)
+}
+
+func lockExclusive(ctx context.Context, repo *repository.Repository, retryLock time.Duration, printRetry func(msg string), logger func(format string, args ...interface{})) (repository.Unlocker, context.Context, error) {
+	return repository.LockRepo(ctx, repo, true, retryLock, printRetry, logger)
+}
+
+func internalOpenWithLocked(ctx context.Context, gopts global.Options, dryRun bool, lockRepo lockFunc, printer progress.Printer) (context.Context, *repository.Repository, func(), error) {
 	repo, err := global.OpenRepository(ctx, gopts, printer)
 	if err != nil {
 		return nil, nil, nil, err
@@ -18,7 +30,7 @@ func internalOpenWithLocked(ctx context.Context, gopts global.Options, dryRun bo
 	if !dryRun {
 		var lock repository.Unlocker
 
-		lock, ctx, err = repository.LockRepo(ctx, repo, exclusive, gopts.RetryLock, func(msg string) {
+		lock, ctx, err = lockRepo(ctx, repo, gopts.RetryLock, func(msg string) {
 			if !gopts.JSON {
 				printer.P("%s", msg)
 			}
@@ -39,14 +51,20 @@ func openWithReadLock(ctx context.Context, gopts global.Options, noLock bool, pr
 	// TODO enforce read-only operations once the locking code has moved to the repository
 	// As in-depth hardening, put the repository into read-only mode if noLock is true
 	// Not possible if the repository has to be locked.
-	return internalOpenWithLocked(ctx, gopts, noLock, false, printer)
+	return internalOpenWithLocked(ctx, gopts, noLock, lockShared, printer)
 }
 
 func openWithAppendLock(ctx context.Context, gopts global.Options, dryRun bool, printer progress.Printer) (context.Context, *repository.Repository, func(), error) {
 	// TODO enforce non-exclusive operations once the locking code has moved to the repository
-	return internalOpenWithLocked(ctx, gopts, dryRun, false, printer)
+	return internalOpenWithLocked(ctx, gopts, dryRun, lockShared, printer)
+}
+
+// openWithDelayedPruneLock uses a non-exclusive lock which cannot be held by
+// two prune runs with delayed deletion at the same time.
+func openWithDelayedPruneLock(ctx context.Context, gopts global.Options, dryRun bool, printer progress.Printer) (context.Context, *repository.Repository, func(), error) {
+	return internalOpenWithLocked(ctx, gopts, dryRun, repository.LockRepoDelayedPrune, printer)
 }
 
 func openWithExclusiveLock(ctx context.Context, gopts global.Options, dryRun bool, printer progress.Printer) (context.Context, *repository.Repository, func(), error) {
-	return internalOpenWithLocked(ctx, gopts, dryRun, true, printer)
+	return internalOpenWithLocked(ctx, gopts, dryRun, lockExclusive, printer)
 }
@@ -47,12 +47,15 @@ The full documentation can be found at https://restic.readthedocs.io/ .
 		SilenceUsage:      true,
 		DisableAutoGenTag: true,
 
-		PersistentPreRunE: func(c *cobra.Command, _ []string) error {
+		PersistentPreRunE: func(c *cobra.Command, args []string) error {
 			switch c.Name() {
 			case "__complete", "__completeNoDesc":
 				return nil
 			}
-			return globalOptions.PreRun(needsPassword(c.Name()))
+			if err := loadAndApplyProfile(c, args, globalOptions); err != nil {
+				return err
+			}
+			return globalOptions.PreRun(needsPassword(commandPath(c)))
 		},
 	}
 
@@ -78,6 +81,7 @@ The full documentation can be found at https://restic.readthedocs.io/ .
 		newCacheCommand(globalOptions),
 		newCatCommand(globalOptions),
 		newCheckCommand(globalOptions),
+		newConfigCommand(globalOptions),
 		newCopyCommand(globalOptions),
 		newDiffCommand(globalOptions),
 		newDumpCommand(globalOptions),
@@ -93,7 +97,9 @@ The full documentation can be found at https://restic.readthedocs.io/ .
 		newOptionsCommand(globalOptions),
 		newPruneCommand(globalOptions),
 		newRebuildIndexCommand(globalOptions),
+		newRecompressCommand(globalOptions),
 		newRecoverCommand(globalOptions),
+		newRekeyCommand(globalOptions),
 		newRepairCommand(globalOptions),
 		newRestoreCommand(globalOptions),
 		newRewriteCommand(globalOptions),
@@ -102,6 +108,7 @@ The full documentation can be found at https://restic.readthedocs.io/ .
 		newTagCommand(globalOptions),
 		newUnlockCommand(globalOptions),
 		newVersionCommand(globalOptions),
+		newWarmupCommand(globalOptions),
 	)
 
 	registerDebugCommand(cmd, globalOptions)
@@ -117,7 +124,7 @@ The full documentation can be found at https://restic.readthedocs.io/ .
 // user for authentication).
 func needsPassword(cmd string) bool {
 	switch cmd {
-	case "cache", "generate", "help", "options", "self-update", "version", "__complete", "__completeNoDesc":
+	case "cache", "config show", "generate", "help", "options", "self-update", "version", "__complete", "__completeNoDesc":
 		return false
 	default:
 		return true
@@ -193,6 +200,11 @@ func main() {
 			// ErrOK overwrites context cancellation errors
 			err = nil
 		}
+
+		// also write metrics for failed commands
+		if terr := globalOptions.FinishTrace(); terr != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "unable to write backend metrics: %v\n", terr)
+		}
 	}()
 
 	var exitMessage string
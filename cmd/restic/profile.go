@@ -0,0 +1,394 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"go.yaml.in/yaml/v3"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/global"
+)
+
+// profilesFile is the structure of the file containing the configuration
+// profiles, which is either in YAML or TOML format. A profile maps the names
+// of flags to their values. Nested maps whose key is the name of a command
+// contain the flags for that command.
+type profilesFile struct {
+	Profiles map[string]map[string]interface{} `yaml:"profiles" toml:"profiles"`
+}
+
+// defaultProfilesFiles are the names of the profiles file in the restic config
+// directory, the first one which exists is used.
+var defaultProfilesFiles = []string{"profiles.yaml", "profiles.toml"}
+
+// Keys with a special meaning in a profile, all other keys are flag names.
+const (
+	// profileInheritKey names the profiles whose settings are inherited.
+	profileInheritKey = "inherit"
+	// profileEnvKey contains environment variables to set.
+	profileEnvKey = "env"
+	// profileArgsKey contains the arguments used if a command is called
+	// without arguments.
+	profileArgsKey = "args"
+)
+
+// profileExclusiveFlags lists pairs of flags which must not be set at the same
+// time. If one of them is set, the value of the other from a profile or the
+// environment is ignored.
+var profileExclusiveFlags = map[string]string{
+	"repo":             "repository-file",
+	"repository-file":  "repo",
+	"password-file":    "password-command",
+	"password-command": "password-file",
+}
+
+// defaultProfilesFile returns the location of the profiles file if none was
+// specified.
+func defaultProfilesFile() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	for _, name := range defaultProfilesFiles {
+		filename := filepath.Join(dir, "restic", name)
+		if _, err := os.Stat(filename); err == nil {
+			return filename, nil
+		}
+	}
+	return filepath.Join(dir, "restic", defaultProfilesFiles[0]), nil
+}
+
+// parseProfilesFile decodes the profiles file, files with the extension
+// ".toml" are parsed as TOML, all others as YAML.
+func parseProfilesFile(filename string, buf []byte) (profilesFile, error) {
+	var f profilesFile
+	if strings.EqualFold(filepath.Ext(filename), ".toml") {
+		_, err := toml.Decode(string(buf), &f)
+		return f, err
+	}
+	err := yaml.Unmarshal(buf, &f)
+	return f, err
+}
+
+// loadProfile reads the profiles file and returns the profile name, into which
+// all inherited profiles are merged.
+func loadProfile(filename, name string) (map[string]interface{}, error) {
+	if filename == "" {
+		var err error
+		filename, err = defaultProfilesFile()
+		if err != nil {
+			return nil, errors.Fatalf("unable to determine location of the profiles file: %v", err)
+		}
+	}
+	debug.Log("loading profile %v from %v", name, filename)
+
+	buf, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Fatalf("unable to read profiles file: %v", err)
+	}
+
+	f, err := parseProfilesFile(filename, buf)
+	if err != nil {
+		return nil, errors.Fatalf("unable to parse profiles file %v: %v", filename, err)
+	}
+
+	return resolveProfile(f.Profiles, name, nil)
+}
+
+// resolveProfile returns the profile name with all profiles it inherits from
+// merged into it. Settings of a profile take precedence over inherited ones.
+func resolveProfile(profiles map[string]map[string]interface{}, name string, seen []string) (map[string]interface{}, error) {
+	if slices.Contains(seen, name) {
+		return nil, errors.Fatalf("profile %q inherits from itself", name)
+	}
+	p, ok := profiles[name]
+	if !ok {
+		return nil, errors.Fatalf("profile %q not found", name)
+	}
+	seen = append(seen, name)
+
+	parents, err := profileStrings(p[profileInheritKey])
+	if err != nil {
+		return nil, errors.Fatalf("invalid %v in profile %q: %v", profileInheritKey, name, err)
+	}
+
+	result := make(map[string]interface{})
+	for _, parent := range parents {
+		inherited, err := resolveProfile(profiles, parent, seen)
+		if err != nil {
+			return nil, err
+		}
+		mergeProfile(result, inherited)
+	}
+	mergeProfile(result, p)
+	delete(result, profileInheritKey)
+
+	return result, nil
+}
+
+// mergeProfile merges src into dst. Nested maps are merged recursively, all
+// other values of src replace those in dst. Maps are copied such that src is
+// never modified later on.
+func mergeProfile(dst, src map[string]interface{}) {
+	for key, value := range src {
+		if m, ok := value.(map[string]interface{}); ok {
+			merged := make(map[string]interface{})
+			if d, ok := dst[key].(map[string]interface{}); ok {
+				mergeProfile(merged, d)
+			}
+			mergeProfile(merged, m)
+			value = merged
+		}
+		dst[key] = value
+	}
+}
+
+// profileScalar converts a single value of a profile to a string.
+func profileScalar(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case bool, int, int64, uint64, float64:
+		return fmt.Sprint(v), nil
+	default:
+		return "", errors.Errorf("unsupported value %v", value)
+	}
+}
+
+// profileStrings converts a value of a profile to a list of strings. Lists are
+// converted element-wise, maps to a list of key=value pairs sorted by key.
+func profileStrings(value interface{}) ([]string, error) {
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case []interface{}:
+		list := make([]string, 0, len(v))
+		for _, item := range v {
+			s, err := profileScalar(item)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, s)
+		}
+		return list, nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		list := make([]string, 0, len(v))
+		for _, key := range keys {
+			s, err := profileScalar(v[key])
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, key+"="+s)
+		}
+		return list, nil
+	default:
+		s, err := profileScalar(v)
+		if err != nil {
+			return nil, err
+		}
+		return []string{s}, nil
+	}
+}
+
+// findSubcommand returns the direct subcommand of cmd with the given name.
+func findSubcommand(cmd *cobra.Command, name string) *cobra.Command {
+	for _, c := range cmd.Commands() {
+		if c.Name() == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// lookupFlag returns the flag with the given name which is either defined by
+// cmd or inherited from one of its parents.
+func lookupFlag(cmd *cobra.Command, name string) *pflag.Flag {
+	if f := cmd.LocalFlags().Lookup(name); f != nil {
+		return f
+	}
+	return cmd.InheritedFlags().Lookup(name)
+}
+
+// checkProfileSection verifies that all keys of the section of a profile for
+// cmd are either flags of the command or sections of a subcommand.
+func checkProfileSection(cmd *cobra.Command, section map[string]interface{}) error {
+	for key, value := range section {
+		switch key {
+		case profileEnvKey:
+			if _, ok := value.(map[string]interface{}); !ok {
+				return errors.Fatalf("%v in profile section %q must be a map", key, cmd.CommandPath())
+			}
+			continue
+		case profileArgsKey:
+			if !cmd.HasParent() {
+				return errors.Fatalf("%v are only allowed in the section of a command", key)
+			}
+			continue
+		}
+
+		if sub := findSubcommand(cmd, key); sub != nil {
+			subSection, ok := value.(map[string]interface{})
+			if !ok {
+				return errors.Fatalf("profile section %q must be a map", sub.CommandPath())
+			}
+			if err := checkProfileSection(sub, subSection); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if lookupFlag(cmd, key) == nil {
+			return errors.Fatalf("unknown flag %q in profile section %q", key, cmd.CommandPath())
+		}
+	}
+	return nil
+}
+
+// profileSection is the section of a profile for a command.
+type profileSection struct {
+	cmd    *cobra.Command
+	values map[string]interface{}
+}
+
+// profileSections returns the sections of profile p which apply to cmd,
+// starting with the top-level section of the root command.
+func profileSections(cmd *cobra.Command, p map[string]interface{}) []profileSection {
+	var path []*cobra.Command
+	for c := cmd; c.HasParent(); c = c.Parent() {
+		path = append([]*cobra.Command{c}, path...)
+	}
+
+	sections := []profileSection{{cmd: cmd.Root(), values: p}}
+	for _, c := range path {
+		sub, ok := sections[len(sections)-1].values[c.Name()].(map[string]interface{})
+		if !ok {
+			break
+		}
+		sections = append(sections, profileSection{cmd: c, values: sub})
+	}
+	return sections
+}
+
+// applyProfile sets the flags of cmd which were not specified on the command
+// line to the values from profile p. Values from the section of a command
+// override those of the sections of its parents. The environment variables
+// of the profile are set and its arguments for cmd are returned.
+func applyProfile(cmd *cobra.Command, p map[string]interface{}) (args []string, err error) {
+	if err := checkProfileSection(cmd.Root(), p); err != nil {
+		return nil, err
+	}
+
+	settings := make(map[string]interface{})
+	env := make(map[string]interface{})
+	for _, section := range profileSections(cmd, p) {
+		for key, value := range section.values {
+			switch {
+			case key == profileEnvKey:
+				mergeProfile(env, value.(map[string]interface{}))
+			case key == profileArgsKey:
+				if section.cmd != cmd {
+					continue
+				}
+				args, err = profileStrings(value)
+				if err != nil {
+					return nil, errors.Fatalf("invalid %v in profile: %v", key, err)
+				}
+			case findSubcommand(section.cmd, key) != nil:
+				// section of a subcommand
+			default:
+				settings[key] = value
+			}
+		}
+	}
+
+	for key, value := range env {
+		s, err := profileScalar(value)
+		if err != nil {
+			return nil, errors.Fatalf("invalid value for environment variable %v in profile: %v", key, err)
+		}
+		if err := os.Setenv(key, s); err != nil {
+			return nil, err
+		}
+	}
+
+	flags := cmd.Flags()
+	changed := make(map[string]bool)
+	flags.Visit(func(f *pflag.Flag) {
+		changed[f.Name] = true
+	})
+
+	for key, value := range settings {
+		if changed[key] || changed[profileExclusiveFlags[key]] {
+			// flags on the command line take precedence
+			continue
+		}
+
+		values, err := profileStrings(value)
+		if err != nil {
+			return nil, errors.Fatalf("invalid value for flag %q in profile: %v", key, err)
+		}
+		// a list is equivalent to specifying the flag multiple times
+		for _, v := range values {
+			if err := flags.Set(key, v); err != nil {
+				return nil, errors.Fatalf("invalid value %q for flag %q in profile: %v", v, key, err)
+			}
+		}
+
+		// ignore the value of a mutually exclusive flag from the environment
+		if other, ok := profileExclusiveFlags[key]; ok {
+			if _, isSet := settings[other]; !isSet {
+				if err := flags.Lookup(other).Value.Set(""); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	return args, nil
+}
+
+// loadAndApplyProfile applies the profile selected in gopts to cmd. If cmd is
+// called without arguments, the arguments from the profile are used instead.
+func loadAndApplyProfile(cmd *cobra.Command, cmdArgs []string, gopts *global.Options) error {
+	if gopts.Profile == "" {
+		return nil
+	}
+
+	p, err := loadProfile(gopts.ProfilesFile, gopts.Profile)
+	if err != nil {
+		return err
+	}
+	args, err := applyProfile(cmd, p)
+	if err != nil {
+		return err
+	}
+
+	if len(cmdArgs) == 0 && len(args) > 0 && cmd.RunE != nil {
+		debug.Log("using arguments %v from profile", args)
+		runE := cmd.RunE
+		cmd.RunE = func(c *cobra.Command, _ []string) error {
+			return runE(c, args)
+		}
+	}
+	return nil
+}
+
+// commandPath returns the path of cmd without the name of the root command.
+func commandPath(cmd *cobra.Command) string {
+	return strings.TrimPrefix(cmd.CommandPath(), cmd.Root().Name()+" ")
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/cobra"
+
+	"github.com/restic/restic/internal/global"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+const testProfiles = `
+profiles:
+  base:
+    repo: /srv/restic
+    password-file: /etc/restic/password
+    option:
+      sftp.connections: 5
+    env:
+      RESTIC_TEST_PROFILE_ENV: base
+    backup:
+      exclude:
+        - "*.tmp"
+      args: [/home]
+  home:
+    inherit: base
+    verbose: 2
+    backup:
+      one-file-system: true
+      args: [/home, /etc]
+    forget:
+      keep-daily: 7
+  loop:
+    inherit: [home, loop]
+`
+
+func writeTestProfiles(t *testing.T, content string) string {
+	filename := filepath.Join(t.TempDir(), "profiles.yaml")
+	rtest.OK(t, os.WriteFile(filename, []byte(content), 0o600))
+	return filename
+}
+
+func TestLoadProfile(t *testing.T) {
+	filename := writeTestProfiles(t, testProfiles)
+
+	p, err := loadProfile(filename, "home")
+	rtest.OK(t, err)
+	rtest.Equals(t, map[string]interface{}{
+		"repo":          "/srv/restic",
+		"password-file": "/etc/restic/password",
+		"verbose":       2,
+		"option":        map[string]interface{}{"sftp.connections": 5},
+		"env":           map[string]interface{}{"RESTIC_TEST_PROFILE_ENV": "base"},
+		"backup": map[string]interface{}{
+			"exclude":         []interface{}{"*.tmp"},
+			"one-file-system": true,
+			"args":            []interface{}{"/home", "/etc"},
+		},
+		"forget": map[string]interface{}{"keep-daily": 7},
+	}, p)
+
+	// loading a profile must not modify the profiles it inherits from
+	p, err = loadProfile(filename, "base")
+	rtest.OK(t, err)
+	rtest.Equals(t, interface{}(map[string]interface{}{
+		"exclude": []interface{}{"*.tmp"},
+		"args":    []interface{}{"/home"},
+	}), p["backup"])
+
+	_, err = loadProfile(filename, "loop")
+	rtest.Assert(t, err != nil, "expected error for recursive profile")
+	_, err = loadProfile(filename, "missing")
+	rtest.Assert(t, err != nil, "expected error for missing profile")
+	_, err = loadProfile(filepath.Join(t.TempDir(), "missing.yaml"), "home")
+	rtest.Assert(t, err != nil, "expected error for missing profiles file")
+}
+
+const testProfilesTOML = `
+[profiles.base]
+repo = "/srv/restic"
+option = { "sftp.connections" = 5 }
+
+[profiles.base.backup]
+exclude = ["*.tmp"]
+args = ["/home"]
+
+[profiles.home]
+inherit = "base"
+verbose = 2
+
+[profiles.home.backup]
+one-file-system = true
+args = ["/home", "/etc"]
+`
+
+func TestLoadProfileTOML(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "profiles.toml")
+	rtest.OK(t, os.WriteFile(filename, []byte(testProfilesTOML), 0o600))
+
+	p, err := loadProfile(filename, "home")
+	rtest.OK(t, err)
+	rtest.Equals(t, map[string]interface{}{
+		"repo":    "/srv/restic",
+		"verbose": int64(2),
+		"option":  map[string]interface{}{"sftp.connections": int64(5)},
+		"backup": map[string]interface{}{
+			"exclude":         []interface{}{"*.tmp"},
+			"one-file-system": true,
+			"args":            []interface{}{"/home", "/etc"},
+		},
+	}, p)
+
+	var gopts global.Options
+	cmd := prepareProfileCommand(t, &gopts, []string{"backup"})
+	args, err := applyProfile(cmd, p)
+	rtest.OK(t, err)
+	rtest.Equals(t, []string{"/home", "/etc"}, args)
+	rtest.Equals(t, "/srv/restic", gopts.Repo)
+	rtest.Equals(t, 2, gopts.Verbose)
+	rtest.Equals(t, []string{"sftp.connections=5"}, gopts.Options)
+}
+
+func prepareProfileCommand(t *testing.T, gopts *global.Options, cmdArgs []string) *cobra.Command {
+	root := newRootCommand(gopts)
+	cmd, args, err := root.Find(cmdArgs)
+	rtest.OK(t, err)
+	rtest.OK(t, cmd.ParseFlags(args))
+	return cmd
+}
+
+func TestApplyProfile(t *testing.T) {
+	filename := writeTestProfiles(t, testProfiles)
+	p, err := loadProfile(filename, "home")
+	rtest.OK(t, err)
+
+	t.Setenv("RESTIC_TEST_PROFILE_ENV", "")
+	// the profile replaces the environment, the command line replaces the profile
+	t.Setenv("RESTIC_REPOSITORY", "/srv/other")
+	t.Setenv("RESTIC_PASSWORD_COMMAND", "echo secret")
+	var gopts global.Options
+	cmd := prepareProfileCommand(t, &gopts, []string{"backup", "--verbose=1", "--exclude", "*.bak"})
+
+	args, err := applyProfile(cmd, p)
+	rtest.OK(t, err)
+	rtest.Equals(t, []string{"/home", "/etc"}, args)
+	rtest.Equals(t, "base", os.Getenv("RESTIC_TEST_PROFILE_ENV"))
+
+	rtest.Equals(t, "/srv/restic", gopts.Repo)
+	rtest.Equals(t, "/etc/restic/password", gopts.PasswordFile)
+	rtest.Equals(t, "", gopts.PasswordCommand)
+	rtest.Equals(t, 1, gopts.Verbose)
+	rtest.Equals(t, []string{"sftp.connections=5"}, gopts.Options)
+
+	excludes, err := cmd.Flags().GetStringArray("exclude")
+	rtest.OK(t, err)
+	rtest.Equals(t, []string{"*.bak"}, excludes)
+	oneFileSystem, err := cmd.Flags().GetBool("one-file-system")
+	rtest.OK(t, err)
+	rtest.Equals(t, true, oneFileSystem)
+
+	// sections of other commands and their arguments are ignored
+	gopts = global.Options{}
+	cmd = prepareProfileCommand(t, &gopts, []string{"forget"})
+	args, err = applyProfile(cmd, p)
+	rtest.OK(t, err)
+	rtest.Equals(t, 0, len(args))
+	rtest.Equals(t, "7", cmd.Flags().Lookup("keep-daily").Value.String())
+	rtest.Equals(t, 2, gopts.Verbose)
+}
+
+func TestApplyProfileErrors(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		profile map[string]interface{}
+	}{
+		{"unknown global flag", map[string]interface{}{"exclude": "*.tmp"}},
+		{"unknown command flag", map[string]interface{}{
+			"forget": map[string]interface{}{"exclude": "*.tmp"},
+		}},
+		{"invalid value", map[string]interface{}{"pack-size": "large"}},
+		{"args at top-level", map[string]interface{}{"args": []interface{}{"/home"}}},
+		{"invalid section", map[string]interface{}{"backup": "/home"}},
+		{"invalid env", map[string]interface{}{"env": "FOO=bar"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			cmd := prepareProfileCommand(t, &global.Options{}, []string{"backup"})
+			_, err := applyProfile(cmd, test.profile)
+			rtest.Assert(t, err != nil, "expected error")
+		})
+	}
+}
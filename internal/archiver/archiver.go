@@ -55,6 +55,13 @@ type Summary struct {
 	Files, Dirs    ChangeStats
 	ProcessedBytes uint64
 	ItemStats
+	// CompressionSkippedBytes is the size of the new data blobs which were
+	// stored uncompressed although compression is enabled, either because they
+	// appear to be incompressible or due to the CompressionHint.
+	CompressionSkippedBytes uint64
+	// IncompressibleBytes is the part of CompressionSkippedBytes which was
+	// detected to be incompressible.
+	IncompressibleBytes uint64
 }
 
 // Add adds other to the current ItemStats.
@@ -101,6 +108,9 @@ type Archiver struct {
 	mu        sync.Mutex
 	summary   *Summary
 
+	// checkpoints tracks the completed items, nil if no checkpoints are saved.
+	checkpoints *checkpointTree
+
 	// Error is called for all errors that occur during backup.
 	Error ErrorFunc
 
@@ -122,6 +132,15 @@ type Archiver struct {
 	// CompleteBlob is called for all saved blobs for files.
 	CompleteBlob func(bytes uint64)
 
+	// CompleteCheckpoint is called after a checkpoint snapshot has been
+	// saved, see SnapshotOptions.CheckpointInterval.
+	CompleteCheckpoint func(sn *data.Snapshot, id restic.ID)
+
+	// FileChanged is called when a file was modified while reading it.
+	// attempt is the number of times the file has been read, action is how
+	// the modification is handled.
+	FileChanged func(item string, attempt uint, action FileChangeMode)
+
 	// WithAtime configures if the access time for files and directories should
 	// be saved. Enabling it may result in much metadata, so it's off by
 	// default.
@@ -129,6 +148,21 @@ type Archiver struct {
 
 	// Flags controlling change detection. See doc/040_backup.rst for details.
 	ChangeIgnoreFlags uint
+
+	// OnFileChange configures how files are handled which are modified while
+	// reading them. Depending on the mode, such files are read again up to
+	// FileChangeRetries times.
+	OnFileChange      FileChangeMode
+	FileChangeRetries uint
+
+	// CompressionHint returns the compression hint for the content of a file.
+	// If nil, the repository detects incompressible data.
+	CompressionHint func(filename string) restic.CompressionHint
+
+	// IgnoreFiles contains the names of ignore files. Items matched by the
+	// rules of such a file are excluded from the backup. The rules apply to
+	// the directory containing the ignore file and all its subdirectories.
+	IgnoreFiles []string
 }
 
 // Flags for the ChangeIgnoreFlags bitfield.
@@ -183,6 +217,7 @@ func New(repo archiverRepo, filesystem fs.FS, opts Options) *Archiver {
 		CompleteItem: func(string, *data.Node, *data.Node, ItemStats, time.Duration) {},
 		StartFile:    func(string) {},
 		CompleteBlob: func(uint64) {},
+		FileChanged:  func(string, uint, FileChangeMode) {},
 	}
 
 	return arch
@@ -212,6 +247,9 @@ func (arch *Archiver) error(item string, err error) error {
 
 func (arch *Archiver) trackItem(item string, previous, current *data.Node, s ItemStats, d time.Duration) {
 	arch.CompleteItem(item, previous, current, s, d)
+	if current != nil {
+		arch.checkpoints.complete(item, current)
+	}
 
 	arch.mu.Lock()
 	defer arch.mu.Unlock()
@@ -306,14 +344,27 @@ func (arch *Archiver) wrapLoadTreeError(id restic.ID, err error) error {
 }
 
 // saveDir stores a directory in the repo and returns the node. snPath is the
-// path within the current snapshot.
-func (arch *Archiver) saveDir(ctx context.Context, snPath string, dir string, meta fs.File, previous data.TreeNodeIterator, complete fileCompleteFunc) (d futureNode, err error) {
+// path within the current snapshot. ignores contains the ignore files of the
+// parent directories.
+func (arch *Archiver) saveDir(ctx context.Context, snPath string, dir string, meta fs.File, previous data.TreeNodeIterator, ignores *ignoreStack, complete fileCompleteFunc) (d futureNode, err error) {
 	debug.Log("%v %v", snPath, dir)
 
 	treeNode, names, err := arch.dirToNodeAndEntries(snPath, dir, meta)
 	if err != nil {
 		return futureNode{}, err
 	}
+	arch.checkpoints.start(snPath, treeNode)
+
+	if len(arch.IgnoreFiles) > 0 {
+		absdir, err := arch.FS.Abs(dir)
+		if err != nil {
+			return futureNode{}, err
+		}
+		ignores, err = loadIgnoreFiles(arch.FS, absdir, names, arch.IgnoreFiles, ignores, arch.error)
+		if err != nil {
+			return futureNode{}, err
+		}
+	}
 
 	nodes := make([]futureNode, 0, len(names))
 
@@ -334,7 +385,7 @@ func (arch *Archiver) saveDir(ctx context.Context, snPath string, dir string, me
 			return futureNode{}, err
 		}
 		snItem := join(snPath, name)
-		fn, excluded, err := arch.save(ctx, snItem, pathname, oldNode, false)
+		fn, excluded, err := arch.save(ctx, snItem, pathname, oldNode, false, ignores)
 
 		// return error early if possible
 		if err != nil {
@@ -451,8 +502,9 @@ func (arch *Archiver) allBlobsPresent(previous *data.Node) bool {
 // snPath is the path within the current snapshot.
 //
 // explicit is true when this path was a backup target (tree leaf with Explicit
-// set); Excludes (`SelectByName` and `Select`) are skipped for that path only.
-func (arch *Archiver) save(ctx context.Context, snPath, target string, previous *data.Node, explicit bool) (fn futureNode, excluded bool, err error) {
+// set); Excludes (`SelectByName`, `Select` and ignore files) are skipped for
+// that path only. ignores contains the ignore files of the parent directories.
+func (arch *Archiver) save(ctx context.Context, snPath, target string, previous *data.Node, explicit bool, ignores *ignoreStack) (fn futureNode, excluded bool, err error) {
 	start := time.Now()
 
 	debug.Log("%v target %q, previous %v", snPath, target, previous)
@@ -507,6 +559,10 @@ func (arch *Archiver) save(ctx context.Context, snPath, target string, previous
 		debug.Log("%v is excluded", target)
 		return futureNode{}, true, nil
 	}
+	if !explicit && ignores.Excluded(abstarget, fi.Mode.IsDir()) {
+		debug.Log("%v is excluded by an ignore file", target)
+		return futureNode{}, true, nil
+	}
 
 	switch {
 	case fi.Mode.IsRegular():
@@ -587,7 +643,7 @@ func (arch *Archiver) save(ctx context.Context, snPath, target string, previous
 			return futureNode{}, false, err
 		}
 
-		fn, err = arch.saveDir(ctx, snPath, target, meta, oldSubtree,
+		fn, err = arch.saveDir(ctx, snPath, target, meta, oldSubtree, ignores,
 			func(node *data.Node, stats ItemStats) {
 				arch.trackItem(snItem, previous, node, stats, time.Since(start))
 			})
@@ -668,6 +724,7 @@ func (arch *Archiver) saveTree(ctx context.Context, snPath string, atree *tree,
 		if err != nil {
 			return futureNode{}, 0, err
 		}
+		arch.checkpoints.start(snPath, node)
 	} else {
 		// fake root node
 		node = &data.Node{}
@@ -680,6 +737,8 @@ func (arch *Archiver) saveTree(ctx context.Context, snPath string, atree *tree,
 	finder := data.NewTreeFinder(previous)
 	defer finder.Close()
 
+	ignores := make(targetIgnores)
+
 	// iterate over the nodes of atree in lexicographic (=deterministic) order
 	for _, name := range nodeNames {
 		subatree := atree.Nodes[name]
@@ -697,7 +756,16 @@ func (arch *Archiver) saveTree(ctx context.Context, snPath string, atree *tree,
 			if err != nil {
 				return futureNode{}, 0, err
 			}
-			fn, excluded, err := arch.save(ctx, pathname, subatree.Path, oldNode, subatree.Explicit)
+			var stack *ignoreStack
+			abstarget, err := arch.FS.Abs(subatree.Path)
+			if err == nil {
+				stack, err = ignores.get(arch.FS, abstarget, subatree.Explicit, arch.IgnoreFiles, arch.error)
+			}
+			if err != nil {
+				return futureNode{}, 0, err
+			}
+
+			fn, excluded, err := arch.save(ctx, pathname, subatree.Path, oldNode, subatree.Explicit, stack)
 
 			if err != nil {
 				err = arch.error(subatree.Path, err)
@@ -827,6 +895,10 @@ type SnapshotOptions struct {
 	ProgramVersion string
 	// SkipIfUnchanged omits the snapshot creation if it is identical to the parent snapshot.
 	SkipIfUnchanged bool
+	// CheckpointInterval is the interval in which checkpoint snapshots
+	// containing the items completed so far are saved. Zero disables
+	// checkpoints.
+	CheckpointInterval time.Duration
 }
 
 // loadParentTree loads a tree referenced by snapshot id. If id is null, nil is returned.
@@ -857,7 +929,28 @@ func (arch *Archiver) runWorkers(ctx context.Context, wg *errgroup.Group, upload
 		arch.Repo.Config().ChunkerPolynomial,
 		arch.Options.ReadConcurrency)
 	arch.fileSaver.CompleteBlob = arch.CompleteBlob
+	if arch.CompressionHint != nil {
+		arch.fileSaver.CompressionHint = arch.CompressionHint
+	}
+	arch.fileSaver.SkippedCompression = func(bytes uint64, incompressible bool) {
+		arch.mu.Lock()
+		defer arch.mu.Unlock()
+		if arch.summary != nil {
+			arch.summary.CompressionSkippedBytes += bytes
+			if incompressible {
+				arch.summary.IncompressibleBytes += bytes
+			}
+		}
+	}
 	arch.fileSaver.NodeFromFileInfo = arch.nodeFromFileInfo
+	arch.fileSaver.Lstat = arch.FS.Lstat
+	arch.fileSaver.Open = func(name string) (fs.File, error) {
+		return arch.FS.OpenFile(name, fs.O_NOFOLLOW, false)
+	}
+	arch.fileSaver.OnFileChange = arch.OnFileChange
+	arch.fileSaver.FileChangeRetries = arch.FileChangeRetries
+	arch.fileSaver.ChangeIgnoreFlags = arch.ChangeIgnoreFlags
+	arch.fileSaver.FileChanged = arch.FileChanged
 
 	arch.treeSaver = newTreeSaver(ctx, wg, arch.Options.SaveTreeConcurrency, uploader, arch.Error)
 }
@@ -891,7 +984,35 @@ func (arch *Archiver) Snapshot(ctx context.Context, targets []string, opts Snaps
 		wg, wgCtx := errgroup.WithContext(ctx)
 		start := time.Now()
 
+		// stops saving checkpoints once the snapshot tree is complete
+		treeDone := make(chan struct{})
+		if opts.CheckpointInterval > 0 {
+			checkpointer, ok := uploader.(restic.BlobCheckpointer)
+			if !ok {
+				return errors.New("repository does not support checkpoints")
+			}
+			arch.checkpoints = newCheckpointTree()
+			wg.Go(func() error {
+				ticker := time.NewTicker(opts.CheckpointInterval)
+				defer ticker.Stop()
+				for {
+					select {
+					case <-ticker.C:
+					case <-treeDone:
+						return nil
+					case <-wgCtx.Done():
+						return nil
+					}
+					err := arch.saveCheckpoint(wgCtx, uploader, checkpointer, targets, opts)
+					if err != nil {
+						return fmt.Errorf("saving checkpoint failed: %w", err)
+					}
+				}
+			})
+		}
+
 		wg.Go(func() error {
+			defer close(treeDone)
 			arch.runWorkers(wgCtx, wg, uploader)
 
 			debug.Log("starting snapshot")
@@ -933,7 +1054,8 @@ func (arch *Archiver) Snapshot(ctx context.Context, targets []string, opts Snaps
 		return nil, restic.ID{}, nil, err
 	}
 
-	if opts.ParentSnapshot != nil && opts.SkipIfUnchanged {
+	// a checkpoint is no replacement for a complete snapshot
+	if opts.ParentSnapshot != nil && opts.SkipIfUnchanged && !opts.ParentSnapshot.IsCheckpoint() {
 		ps := opts.ParentSnapshot
 		if ps.Tree != nil && rootTreeID.Equal(*ps.Tree) {
 			arch.summary.BackupEnd = time.Now()
@@ -948,9 +1070,7 @@ func (arch *Archiver) Snapshot(ctx context.Context, targets []string, opts Snaps
 
 	sn.ProgramVersion = opts.ProgramVersion
 	sn.Excludes = opts.Excludes
-	if opts.ParentSnapshot != nil {
-		sn.Parent = opts.ParentSnapshot.ID()
-	}
+	sn.Parent = parentID(opts.ParentSnapshot)
 	sn.Tree = &rootTreeID
 	arch.summary.BackupEnd = time.Now()
 	sn.Summary = &data.SnapshotSummary{
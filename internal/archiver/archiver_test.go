@@ -223,7 +223,7 @@ func TestArchiverSave(t *testing.T) {
 				wg, ctx := errgroup.WithContext(ctx)
 				arch.runWorkers(ctx, wg, uploader)
 
-				node, excluded, err := arch.save(ctx, "/", filepath.Join(tempdir, "file"), nil, false)
+				node, excluded, err := arch.save(ctx, "/", filepath.Join(tempdir, "file"), nil, false, nil)
 				if err != nil {
 					t.Fatal(err)
 				}
@@ -300,7 +300,7 @@ func TestArchiverSaveReaderFS(t *testing.T) {
 				wg, ctx := errgroup.WithContext(ctx)
 				arch.runWorkers(ctx, wg, uploader)
 
-				node, excluded, err := arch.save(ctx, "/", filename, nil, false)
+				node, excluded, err := arch.save(ctx, "/", filename, nil, false, nil)
 				t.Logf("Save returned %v %v", node, err)
 				if err != nil {
 					t.Fatal(err)
@@ -855,7 +855,7 @@ func TestArchiverSaveDir(t *testing.T) {
 				arch.runWorkers(ctx, wg, uploader)
 				meta, err := testFS.OpenFile(test.target, fs.O_NOFOLLOW, true)
 				rtest.OK(t, err)
-				ft, err := arch.saveDir(ctx, "/", test.target, meta, nil, nil)
+				ft, err := arch.saveDir(ctx, "/", test.target, meta, nil, nil, nil)
 				rtest.OK(t, err)
 				rtest.OK(t, meta.Close())
 
@@ -917,7 +917,7 @@ func TestArchiverSaveDirIncremental(t *testing.T) {
 			arch.runWorkers(ctx, wg, uploader)
 			meta, err := testFS.OpenFile(tempdir, fs.O_NOFOLLOW, true)
 			rtest.OK(t, err)
-			ft, err := arch.saveDir(ctx, "/", tempdir, meta, nil, nil)
+			ft, err := arch.saveDir(ctx, "/", tempdir, meta, nil, nil, nil)
 			rtest.OK(t, err)
 			rtest.OK(t, meta.Close())
 
@@ -2554,7 +2554,7 @@ func TestRacyFileTypeSwap(t *testing.T) {
 				arch.runWorkers(ctx, wg, uploader)
 
 				// fs.Track will panic if the file was not closed
-				_, excluded, err := arch.save(ctx, "/", tempfile, nil, false)
+				_, excluded, err := arch.save(ctx, "/", tempfile, nil, false, nil)
 				rtest.Assert(t, err != nil && strings.Contains(err.Error(), "changed type, refusing to archive"), "save() returned wrong error: %v", err)
 				tpe := "file"
 				if dirError {
@@ -2643,7 +2643,7 @@ func TestIrregularFile(t *testing.T) {
 	defer cancel()
 
 	arch := New(repo, fs.Track{FS: override}, Options{})
-	_, excluded, err := arch.save(ctx, "/", tempfile, nil, false)
+	_, excluded, err := arch.save(ctx, "/", tempfile, nil, false, nil)
 	if err == nil {
 		t.Fatalf("Save() should have failed")
 	}
@@ -2693,8 +2693,39 @@ func TestDisappearedFile(t *testing.T) {
 	// the subsequent file.Stat() call. Thus test both cases.
 	for _, errorOnOpen := range []bool{false, true} {
 		arch := New(repo, fs.Track{FS: &missingFS{FS: fs.NewLocal(), errorOnOpen: errorOnOpen}}, Options{})
-		_, excluded, err := arch.save(ctx, "/", filepath.Join(tempdir, "testdir"), nil, false)
+		_, excluded, err := arch.save(ctx, "/", filepath.Join(tempdir, "testdir"), nil, false, nil)
 		rtest.OK(t, err)
 		rtest.Assert(t, excluded, "testfile should have been excluded")
 	}
 }
+
+func TestArchiverCompressionSkipped(t *testing.T) {
+	random := string(rtest.Random(23, 300*1024))
+	text := strings.Repeat("some compressible text\n", 10000)
+	src := TestDir{
+		"random.bin": TestFile{Content: random},
+		"text.txt":   TestFile{Content: text},
+		"force.jpg":  TestFile{Content: string(rtest.Random(42, 300*1024))},
+		"skip.log":   TestFile{Content: strings.Repeat("a log line\n", 10000)},
+	}
+
+	tempdir, repo := prepareTempdirRepoSrc(t, src)
+	back := rtest.Chdir(t, tempdir)
+	defer back()
+
+	arch := New(repo, fs.Track{FS: fs.NewLocal()}, Options{})
+	arch.CompressionHint = func(filename string) restic.CompressionHint {
+		switch filepath.Ext(filename) {
+		case ".jpg":
+			return restic.CompressionHintForce
+		case ".log":
+			return restic.CompressionHintSkip
+		}
+		return restic.CompressionHintAuto
+	}
+
+	_, _, summary, err := arch.Snapshot(context.TODO(), []string{"."}, SnapshotOptions{Time: time.Now()})
+	rtest.OK(t, err)
+	rtest.Equals(t, uint64(len(random)+len(src["skip.log"].(TestFile).Content)), summary.CompressionSkippedBytes)
+	rtest.Equals(t, uint64(len(random)), summary.IncompressibleBytes)
+}
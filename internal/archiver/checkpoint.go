@@ -0,0 +1,215 @@
+package archiver
+
+import (
+	"context"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/restic/restic/internal/data"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/restic"
+)
+
+// checkpointTree keeps track of the items completed during a backup, such
+// that the part of the snapshot which is already finished can be saved as a
+// checkpoint. Only completed items and directories which are still in
+// progress are kept, the entries of a directory are dropped once the
+// directory is complete.
+type checkpointTree struct {
+	mu   sync.Mutex
+	root *checkpointDir
+}
+
+type checkpointDir struct {
+	// node contains the metadata of the directory, nil if unknown.
+	node *data.Node
+	// nodes contains the completed entries of the directory.
+	nodes map[string]*data.Node
+	// dirs contains the subdirectories which are in progress.
+	dirs map[string]*checkpointDir
+}
+
+func newCheckpointTree() *checkpointTree {
+	return &checkpointTree{root: newCheckpointDir()}
+}
+
+func newCheckpointDir() *checkpointDir {
+	return &checkpointDir{
+		nodes: make(map[string]*data.Node),
+		dirs:  make(map[string]*checkpointDir),
+	}
+}
+
+// splitSnPath returns the elements of a path within the snapshot.
+func splitSnPath(snPath string) []string {
+	snPath = strings.Trim(snPath, "/")
+	if snPath == "" {
+		return nil
+	}
+	return strings.Split(snPath, "/")
+}
+
+// dir returns the directory for the path elements, creating it if necessary.
+func (t *checkpointTree) dir(elems []string) *checkpointDir {
+	dir := t.root
+	for _, name := range elems {
+		sub, ok := dir.dirs[name]
+		if !ok {
+			sub = newCheckpointDir()
+			dir.dirs[name] = sub
+		}
+		dir = sub
+	}
+	return dir
+}
+
+// start records that processing the directory at snPath has started.
+func (t *checkpointTree) start(snPath string, node *data.Node) {
+	if t == nil {
+		return
+	}
+	// copy the node, the original is modified once the tree is saved
+	n := *node
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.dir(splitSnPath(snPath)).node = &n
+}
+
+// complete records that the item at snPath has been saved.
+func (t *checkpointTree) complete(snPath string, node *data.Node) {
+	elems := splitSnPath(snPath)
+	if t == nil || len(elems) == 0 {
+		return
+	}
+	name := elems[len(elems)-1]
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	parent := t.dir(elems[:len(elems)-1])
+	delete(parent.dirs, name)
+	parent.nodes[name] = node
+}
+
+// clone returns a copy of dir and all directories in progress below it.
+func (dir *checkpointDir) clone() *checkpointDir {
+	c := &checkpointDir{
+		node:  dir.node,
+		nodes: make(map[string]*data.Node, len(dir.nodes)),
+		dirs:  make(map[string]*checkpointDir, len(dir.dirs)),
+	}
+	for name, node := range dir.nodes {
+		c.nodes[name] = node
+	}
+	for name, sub := range dir.dirs {
+		c.dirs[name] = sub.clone()
+	}
+	return c
+}
+
+// save stores the trees of all directories which are in progress and
+// returns the ID of the root tree. If no item has been completed so far, ok
+// is false.
+func (t *checkpointTree) save(ctx context.Context, saver restic.BlobSaver) (id restic.ID, ok bool, err error) {
+	t.mu.Lock()
+	root := t.root.clone()
+	t.mu.Unlock()
+
+	if len(root.nodes) == 0 && len(root.dirs) == 0 {
+		return restic.ID{}, false, nil
+	}
+	id, err = root.save(ctx, saver)
+	return id, err == nil, err
+}
+
+func (dir *checkpointDir) save(ctx context.Context, saver restic.BlobSaver) (restic.ID, error) {
+	names := make([]string, 0, len(dir.nodes)+len(dir.dirs))
+	for name := range dir.nodes {
+		names = append(names, name)
+	}
+	for name := range dir.dirs {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	builder := data.NewTreeJSONBuilder()
+	for _, name := range names {
+		node, ok := dir.nodes[name]
+		if !ok {
+			sub := dir.dirs[name]
+			subtreeID, err := sub.save(ctx, saver)
+			if err != nil {
+				return restic.ID{}, err
+			}
+
+			n := data.Node{Type: data.NodeTypeDir, Mode: os.ModeDir | 0700}
+			if sub.node != nil {
+				n = *sub.node
+			}
+			n.Name = name
+			n.Subtree = &subtreeID
+			node = &n
+		}
+
+		if err := builder.AddNode(node); err != nil {
+			return restic.ID{}, err
+		}
+	}
+
+	buf, err := builder.Finalize()
+	if err != nil {
+		return restic.ID{}, err
+	}
+	id, _, _, err := saver.SaveBlob(ctx, restic.TreeBlob, buf, restic.ID{}, false)
+	return id, err
+}
+
+// parentID returns the ID recorded as parent of a snapshot which was created
+// using parent. A checkpoint is removed once the backup is complete, thus the
+// parent of the checkpoint is recorded instead.
+func parentID(parent *data.Snapshot) *restic.ID {
+	if parent == nil {
+		return nil
+	}
+	if parent.IsCheckpoint() {
+		return parent.Parent
+	}
+	return parent.ID()
+}
+
+// saveCheckpoint saves a checkpoint snapshot containing the items completed
+// so far. The blobs referenced by the snapshot are uploaded first.
+func (arch *Archiver) saveCheckpoint(ctx context.Context, uploader restic.BlobSaver, checkpointer restic.BlobCheckpointer, targets []string, opts SnapshotOptions) error {
+	treeID, ok, err := arch.checkpoints.save(ctx, uploader)
+	if err != nil || !ok {
+		return err
+	}
+
+	err = checkpointer.Checkpoint(ctx)
+	if err != nil {
+		return err
+	}
+
+	tags := append(slices.Clone(opts.Tags), data.CheckpointTag)
+	sn, err := data.NewSnapshot(targets, tags, opts.Hostname, opts.Time)
+	if err != nil {
+		return err
+	}
+	sn.ProgramVersion = opts.ProgramVersion
+	sn.Excludes = opts.Excludes
+	sn.Parent = parentID(opts.ParentSnapshot)
+	sn.Tree = &treeID
+
+	id, err := data.SaveSnapshot(ctx, arch.Repo, sn)
+	if err != nil {
+		return err
+	}
+	debug.Log("saved checkpoint %v", id)
+
+	if arch.CompleteCheckpoint != nil {
+		arch.CompleteCheckpoint(sn, id)
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package archiver
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/data"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func loadTreeNodes(t testing.TB, repo restic.BlobLoader, id restic.ID) map[string]*data.Node {
+	tree, err := data.LoadTree(context.TODO(), repo, id)
+	rtest.OK(t, err)
+	nodes := make(map[string]*data.Node)
+	for item := range tree {
+		rtest.OK(t, item.Error)
+		nodes[item.Node.Name] = item.Node
+	}
+	return nodes
+}
+
+func TestCheckpointTree(t *testing.T) {
+	repo := repository.TestRepository(t)
+
+	file := func(name string) *data.Node {
+		return &data.Node{Name: name, Type: data.NodeTypeFile, Mode: 0644}
+	}
+	dir := func(name string) *data.Node {
+		return &data.Node{Name: name, Type: data.NodeTypeDir, Mode: 0755}
+	}
+
+	var nilTree *checkpointTree
+	nilTree.start("/foo", dir("foo"))
+	nilTree.complete("/foo", dir("foo"))
+
+	tree := newCheckpointTree()
+	tree.start("/done", dir("done"))
+	tree.complete("/done/a", file("a"))
+	subtreeID := restic.NewRandomID()
+	done := dir("done")
+	done.Subtree = &subtreeID
+	tree.complete("/done", done)
+
+	tree.start("/partial", dir("partial"))
+	tree.complete("/partial/b", file("b"))
+	tree.start("/partial/sub", dir("sub"))
+	tree.complete("/partial/sub/c", file("c"))
+	// no metadata is known for intermediate directories of targets
+	tree.complete("/unknown/d", file("d"))
+
+	var rootID restic.ID
+	rtest.OK(t, repo.WithBlobUploader(context.TODO(), func(ctx context.Context, uploader restic.BlobSaverWithAsync) error {
+		var ok bool
+		var err error
+		rootID, ok, err = tree.save(ctx, uploader)
+		rtest.Assert(t, ok, "checkpoint tree not saved")
+		return err
+	}))
+
+	root := loadTreeNodes(t, repo, rootID)
+	rtest.Equals(t, 3, len(root))
+	rtest.Equals(t, subtreeID, *root["done"].Subtree)
+
+	partial := loadTreeNodes(t, repo, *root["partial"].Subtree)
+	rtest.Equals(t, 2, len(partial))
+	rtest.Assert(t, partial["b"] != nil, "missing completed file b")
+	sub := loadTreeNodes(t, repo, *partial["sub"].Subtree)
+	rtest.Equals(t, 1, len(sub))
+	rtest.Assert(t, sub["c"] != nil, "missing completed file c")
+
+	unknown := root["unknown"]
+	rtest.Equals(t, data.NodeTypeDir, unknown.Type)
+	rtest.Equals(t, 1, len(loadTreeNodes(t, repo, *unknown.Subtree)))
+
+	// an empty tree is not saved
+	rtest.OK(t, repo.WithBlobUploader(context.TODO(), func(ctx context.Context, uploader restic.BlobSaverWithAsync) error {
+		_, ok, err := newCheckpointTree().save(ctx, uploader)
+		rtest.Assert(t, !ok, "empty checkpoint tree saved")
+		return err
+	}))
+}
+
+func TestArchiverCheckpoints(t *testing.T) {
+	src := TestDir{}
+	for _, name := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		src[name] = TestDir{
+			"file1": TestFile{Content: string(rtest.Random(len(name), 1000))},
+			"file2": TestFile{Content: string(rtest.Random(len(name)+1, 2000))},
+		}
+	}
+
+	tempdir, repo := prepareTempdirRepoSrc(t, src)
+	back := rtest.Chdir(t, tempdir)
+	defer back()
+
+	arch := New(repo, fs.Track{FS: fs.NewLocal()}, Options{})
+	arch.CompleteItem = func(string, *data.Node, *data.Node, ItemStats, time.Duration) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	var mu sync.Mutex
+	var checkpoints []*data.Snapshot
+	arch.CompleteCheckpoint = func(sn *data.Snapshot, _ restic.ID) {
+		mu.Lock()
+		defer mu.Unlock()
+		checkpoints = append(checkpoints, sn)
+	}
+
+	sn, id, _, err := arch.Snapshot(context.TODO(), []string{"."}, SnapshotOptions{
+		Time:               time.Now(),
+		Tags:               data.TagList{"foo"},
+		CheckpointInterval: time.Millisecond,
+	})
+	rtest.OK(t, err)
+	rtest.Assert(t, !sn.IsCheckpoint(), "final snapshot marked as checkpoint")
+
+	mu.Lock()
+	rtest.Assert(t, len(checkpoints) > 0, "no checkpoint was saved")
+	for _, cp := range checkpoints {
+		rtest.Assert(t, cp.IsCheckpoint(), "checkpoint %v lacks the checkpoint tag", cp.Tags)
+		rtest.Assert(t, cp.HasTags([]string{"foo"}), "checkpoint %v lacks the backup tags", cp.Tags)
+	}
+	mu.Unlock()
+
+	// a backup continuing from a checkpoint records the parent of the
+	// checkpoint, as the checkpoint is removed afterwards
+	checkpoint := *sn
+	checkpoint.Tags = data.TagList{data.CheckpointTag}
+	checkpoint.Parent = &id
+	data.TestSetSnapshotID(t, &checkpoint, restic.NewRandomID())
+	checkpoints = nil
+	sn, _, _, err = arch.Snapshot(context.TODO(), []string{"."}, SnapshotOptions{
+		Time:               time.Now(),
+		ParentSnapshot:     &checkpoint,
+		CheckpointInterval: time.Millisecond,
+	})
+	rtest.OK(t, err)
+	rtest.Equals(t, id, *sn.Parent)
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, cp := range checkpoints {
+		rtest.Equals(t, id, *cp.Parent)
+	}
+	repository.TestCheckRepo(t, repo)
+}
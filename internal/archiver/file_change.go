@@ -0,0 +1,73 @@
+package archiver
+
+import (
+	"fmt"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+)
+
+// FileChangeMode configures how files are handled which are modified while
+// they are read.
+type FileChangeMode uint
+
+// Constants for the different modes.
+const (
+	// FileChangeRetry reads the file again and stores it with a warning in
+	// Node.Error if it still changes.
+	FileChangeRetry FileChangeMode = iota
+	// FileChangeWarn stores the file with a warning in Node.Error.
+	FileChangeWarn
+	// FileChangeFail reads the file again and reports an error for the file
+	// if it still changes. The file is not included in the snapshot.
+	FileChangeFail
+)
+
+// Set implements the method needed for pflag command flag parsing.
+func (m *FileChangeMode) Set(s string) error {
+	switch s {
+	case "retry":
+		*m = FileChangeRetry
+	case "warn":
+		*m = FileChangeWarn
+	case "fail":
+		*m = FileChangeFail
+	default:
+		return fmt.Errorf("invalid file change mode %q, must be one of (retry|warn|fail)", s)
+	}
+	return nil
+}
+
+func (m *FileChangeMode) String() string {
+	switch *m {
+	case FileChangeRetry:
+		return "retry"
+	case FileChangeWarn:
+		return "warn"
+	case FileChangeFail:
+		return "fail"
+	default:
+		return "invalid"
+	}
+}
+
+func (m *FileChangeMode) Type() string {
+	return "mode"
+}
+
+// errFileChanged is recorded for files which were modified while reading them.
+var errFileChanged = errors.New("file was modified while reading it")
+
+// statChanged returns true if the size, modification or change time of a file
+// differ between before and after.
+func statChanged(before, after *fs.ExtendedFileInfo, ignoreFlags uint) bool {
+	switch {
+	case before.Size != after.Size:
+		return true
+	case !before.ModTime.Equal(after.ModTime):
+		return true
+	case ignoreFlags&ChangeIgnoreCtime == 0 && !before.ChangeTime.Equal(after.ChangeTime):
+		return true
+	}
+	return false
+}
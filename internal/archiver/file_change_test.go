@@ -0,0 +1,182 @@
+package archiver
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// changingFS appends data to a file when it is read for the first time after
+// opening it, until changes is zero.
+type changingFS struct {
+	fs.FS
+
+	m       sync.Mutex
+	changes int
+}
+
+func (c *changingFS) OpenFile(name string, flag int, metadataOnly bool) (fs.File, error) {
+	f, err := c.FS.OpenFile(name, flag, metadataOnly)
+	if err != nil {
+		return f, err
+	}
+	return &changingFile{File: f, fs: c, filename: name}, nil
+}
+
+type changingFile struct {
+	fs.File
+	filename string
+	read     bool
+
+	fs *changingFS
+}
+
+func (f *changingFile) Read(p []byte) (int, error) {
+	if !f.read {
+		f.read = true
+		f.fs.m.Lock()
+		change := f.fs.changes > 0
+		f.fs.changes--
+		f.fs.m.Unlock()
+
+		if change {
+			wr, err := os.OpenFile(f.filename, os.O_APPEND|os.O_WRONLY, 0)
+			if err != nil {
+				return 0, err
+			}
+			_, err = wr.Write([]byte("appended while reading\n"))
+			if err != nil {
+				_ = wr.Close()
+				return 0, err
+			}
+			if err := wr.Close(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return f.File.Read(p)
+}
+
+type fileChangeEvent struct {
+	attempt uint
+	action  FileChangeMode
+}
+
+func TestArchiverFileChanged(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		mode    FileChangeMode
+		retries uint
+		changes int
+
+		events    []fileChangeEvent
+		wantError bool
+		failed    bool
+	}{
+		{
+			name: "retry", mode: FileChangeRetry, retries: 3, changes: 1,
+			events: []fileChangeEvent{{1, FileChangeRetry}},
+		},
+		{
+			name: "retries-exhausted", mode: FileChangeRetry, retries: 2, changes: 10,
+			events:    []fileChangeEvent{{1, FileChangeRetry}, {2, FileChangeRetry}, {3, FileChangeWarn}},
+			wantError: true,
+		},
+		{
+			name: "warn", mode: FileChangeWarn, retries: 3, changes: 10,
+			events:    []fileChangeEvent{{1, FileChangeWarn}},
+			wantError: true,
+		},
+		{
+			name: "fail-after-retry", mode: FileChangeFail, retries: 3, changes: 1,
+			events: []fileChangeEvent{{1, FileChangeRetry}},
+		},
+		{
+			name: "fail", mode: FileChangeFail, retries: 1, changes: 10,
+			events: []fileChangeEvent{{1, FileChangeRetry}, {2, FileChangeFail}},
+			failed: true,
+		},
+		{
+			name: "unchanged", mode: FileChangeRetry, retries: 3, changes: 0,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			tempdir, repo := prepareTempdirRepoSrc(t, TestDir{
+				"file": TestFile{Content: string(rtest.Random(23, 3*1024*1024))},
+			})
+			back := rtest.Chdir(t, tempdir)
+			defer back()
+
+			arch := New(repo, &changingFS{FS: fs.NewLocal(), changes: test.changes}, Options{})
+			arch.OnFileChange = test.mode
+			arch.FileChangeRetries = test.retries
+
+			var m sync.Mutex
+			var events []fileChangeEvent
+			arch.FileChanged = func(item string, attempt uint, action FileChangeMode) {
+				m.Lock()
+				defer m.Unlock()
+				rtest.Equals(t, "/file", item)
+				events = append(events, fileChangeEvent{attempt, action})
+			}
+			var errs []error
+			arch.Error = func(_ string, err error) error {
+				m.Lock()
+				defer m.Unlock()
+				errs = append(errs, err)
+				return nil
+			}
+			var progress uint64
+			arch.CompleteBlob = func(bytes uint64) {
+				m.Lock()
+				defer m.Unlock()
+				progress += bytes
+			}
+
+			sn, _, summary, err := arch.Snapshot(context.TODO(), []string{"."}, SnapshotOptions{Time: time.Now()})
+			rtest.OK(t, err)
+
+			m.Lock()
+			defer m.Unlock()
+			rtest.Equals(t, test.events, events)
+
+			nodes := loadTreeNodes(t, repo, *sn.Tree)
+			node := nodes["file"]
+			if test.failed {
+				rtest.Equals(t, 1, len(errs))
+				rtest.Assert(t, node == nil, "file modified while reading it was stored")
+				return
+			}
+			rtest.Equals(t, 0, len(errs))
+			rtest.Assert(t, node != nil, "file not found in snapshot")
+			rtest.Equals(t, test.wantError, node.Error != "")
+
+			if !test.wantError {
+				// the stored content matches the file after all modifications
+				want, err := os.ReadFile(filepath.Join(tempdir, "file"))
+				rtest.OK(t, err)
+				var content []byte
+				for _, id := range node.Content {
+					buf, err := repo.LoadBlob(context.TODO(), restic.BlobHandle{Type: restic.DataBlob, ID: id}, nil)
+					rtest.OK(t, err)
+					content = append(content, buf...)
+				}
+				rtest.Equals(t, uint64(len(want)), node.Size)
+				rtest.Assert(t, bytes.Equal(want, content), "stored content differs from the file")
+
+				// bytes read again are reported only once, the blobs saved by
+				// abandoned attempts are still counted
+				rtest.Equals(t, uint64(len(want)), progress)
+				rtest.Assert(t, summary.DataSize >= uint64(len(want)), "data size %v is smaller than the file size %v", summary.DataSize, len(want))
+			}
+		})
+	}
+}
@@ -26,7 +26,31 @@ type fileSaver struct {
 
 	CompleteBlob func(bytes uint64)
 
+	// CompressionHint returns the compression hint for the data blobs of a
+	// file, it is only used if the uploader supports compression hints.
+	CompressionHint func(target string) restic.CompressionHint
+
+	// SkippedCompression is called for new data blobs which were stored
+	// uncompressed although compression is enabled. incompressible is false if
+	// the CompressionHint skipped the compression without checking the data.
+	SkippedCompression func(bytes uint64, incompressible bool)
+
 	NodeFromFileInfo func(snPath, filename string, meta toNoder, ignoreXattrListError bool) (*data.Node, error)
+
+	// Lstat is used to detect files which are modified while reading them. If
+	// it is nil, files are not checked for modifications.
+	Lstat func(name string) (*fs.ExtendedFileInfo, error)
+	// Open opens a file again to read it once more after a modification.
+	Open func(name string) (fs.File, error)
+
+	OnFileChange      FileChangeMode
+	FileChangeRetries uint
+	ChangeIgnoreFlags uint
+
+	// FileChanged is called when a file was modified while reading it. attempt
+	// is the number of times the file has been read, action describes how the
+	// modification is handled.
+	FileChanged func(snPath string, attempt uint, action FileChangeMode)
 }
 
 // newFileSaver returns a new file saver. A worker pool with fileWorkers is
@@ -41,7 +65,10 @@ func newFileSaver(ctx context.Context, wg *errgroup.Group, uploader restic.BlobS
 		pol:          pol,
 		ch:           ch,
 
-		CompleteBlob: func(uint64) {},
+		CompleteBlob:       func(uint64) {},
+		CompressionHint:    func(string) restic.CompressionHint { return restic.CompressionHintAuto },
+		SkippedCompression: func(uint64, bool) {},
+		FileChanged:        func(string, uint, FileChangeMode) {},
 	}
 
 	for i := uint(0); i < fileWorkers; i++ {
@@ -100,22 +127,49 @@ type saveFileJob struct {
 	complete        fileCompleteFunc
 }
 
-// saveFile stores the file f in the repo, then closes it.
+// saveFile stores the file f in the repo, then closes it. Depending on
+// OnFileChange, the file is read again if it is modified while reading it.
 func (s *fileSaver) saveFile(ctx context.Context, chnker *chunker.Chunker, snPath string, target string, f fs.File, start func(), finishReading func(), finish func(res futureNodeResult)) {
 	start()
 
+	var prev previousAttempts
+	for attempt := uint(1); f != nil; attempt++ {
+		f = s.saveFileAttempt(ctx, chnker, snPath, target, f, attempt, &prev, finishReading, finish)
+	}
+}
+
+// previousAttempts is carried over between the attempts to read a file.
+type previousAttempts struct {
+	// stats contains the blobs saved by the abandoned attempts
+	stats ItemStats
+	// reported is the number of bytes already passed to CompleteBlob
+	reported uint64
+}
+
+// saveFileAttempt reads the file f once and closes it. If the file must be
+// read again, the reopened file is returned. The node of an abandoned attempt
+// is discarded, its statistics and the saved blobs are reused by the next
+// attempt.
+func (s *fileSaver) saveFileAttempt(ctx context.Context, chnker *chunker.Chunker, snPath string, target string, f fs.File, attempt uint, prev *previousAttempts, finishReading func(), finish func(res futureNodeResult)) fs.File {
 	fnr := futureNodeResult{
 		snPath: snPath,
 		target: target,
+		stats:  prev.stats,
 	}
 	var lock sync.Mutex
+	// pending tracks the blobs of this attempt which are not yet saved
+	var pending sync.WaitGroup
 	remaining := 0
 	isCompleted := false
+	abandoned := false
 
 	completeBlob := func() {
 		lock.Lock()
 		defer lock.Unlock()
 
+		if abandoned {
+			return
+		}
 		remaining--
 		if remaining == 0 && fnr.err == nil {
 			if isCompleted {
@@ -134,7 +188,7 @@ func (s *fileSaver) saveFile(ctx context.Context, chnker *chunker.Chunker, snPat
 		lock.Lock()
 		defer lock.Unlock()
 
-		if fnr.err == nil {
+		if fnr.err == nil && !abandoned {
 			if isCompleted {
 				panic("completed twice")
 			}
@@ -146,61 +200,74 @@ func (s *fileSaver) saveFile(ctx context.Context, chnker *chunker.Chunker, snPat
 		}
 	}
 
-	debug.Log("%v", snPath)
+	debug.Log("%v, attempt %d", snPath, attempt)
+
+	before, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		completeError(err)
+		return nil
+	}
 
 	node, err := s.NodeFromFileInfo(snPath, target, f, false)
 	if err != nil {
 		_ = f.Close()
 		completeError(err)
-		return
+		return nil
 	}
 
 	if node.Type != data.NodeTypeFile {
 		_ = f.Close()
 		completeError(errors.Errorf("node type %q is wrong", node.Type))
-		return
+		return nil
 	}
 
 	// reuse the chunker
 	chnker.Reset(f, s.pol)
+	holes := newHoleFinder(f)
 
 	node.Content = []restic.ID{}
 	node.Size = 0
-	var idx int
-	for {
-		buf := s.saveFilePool.Get()
-		chunk, err := chnker.Next(buf.Data)
-		if err == io.EOF {
-			buf.Release()
-			break
+	var idx, saved int
+	var offset int64
+
+	// only report the bytes which were not already read by a previous attempt
+	completeBytes := func() {
+		if uint64(offset) > prev.reported {
+			s.CompleteBlob(uint64(offset) - prev.reported)
+			prev.reported = uint64(offset)
 		}
-		if err != nil {
-			buf.Release()
-			_ = f.Close()
-			completeError(err)
-			return
-		}
-
-		buf.Data = chunk.Data
-		node.Size += uint64(chunk.Length)
+	}
 
-		// test if the context has been cancelled, return the error
-		if ctx.Err() != nil {
-			buf.Release()
-			_ = f.Close()
-			completeError(ctx.Err())
-			return
+	saveBlob := s.uploader.SaveBlobAsync
+	if hintSaver, ok := s.uploader.(restic.BlobSaverWithHint); ok {
+		hint := s.CompressionHint(target)
+		saveBlob = func(ctx context.Context, t restic.BlobType, buf []byte, id restic.ID, storeDuplicate bool, cb func(newID restic.ID, known bool, sizeInRepo int, err error)) {
+			hintSaver.SaveBlobAsyncWithHint(ctx, t, buf, id, storeDuplicate, hint, func(newID restic.ID, known bool, sizeInRepo int, skippedCompression bool, err error) {
+				if err == nil && !known && skippedCompression {
+					s.SkippedCompression(uint64(len(buf)), hint == restic.CompressionHintAuto)
+				}
+				cb(newID, known, sizeInRepo, err)
+			})
 		}
+	}
 
+	// saveChunk saves the chunk buf which occurs count times in a row in the
+	// file. release is called once the chunk has been saved.
+	saveChunk := func(buf []byte, id restic.ID, count int64, release func()) {
 		// add a place to store the saveBlob result
 		pos := idx
 
 		lock.Lock()
-		node.Content = append(node.Content, restic.ID{})
+		for i := int64(0); i < count; i++ {
+			node.Content = append(node.Content, id)
+		}
 		lock.Unlock()
 
-		s.uploader.SaveBlobAsync(ctx, restic.DataBlob, buf.Data, restic.ID{}, false, func(newID restic.ID, known bool, sizeInRepo int, err error) {
-			defer buf.Release()
+		pending.Add(1)
+		saveBlob(ctx, restic.DataBlob, buf, id, false, func(newID restic.ID, known bool, sizeInRepo int, err error) {
+			defer pending.Done()
+			defer release()
 			if err != nil {
 				completeError(err)
 				return
@@ -209,40 +276,143 @@ func (s *fileSaver) saveFile(ctx context.Context, chnker *chunker.Chunker, snPat
 			lock.Lock()
 			if !known {
 				fnr.stats.DataBlobs++
-				fnr.stats.DataSize += uint64(len(buf.Data))
+				fnr.stats.DataSize += uint64(len(buf))
 				fnr.stats.DataSizeInRepo += uint64(sizeInRepo)
 			}
-			node.Content[pos] = newID
+			for i := int64(0); i < count; i++ {
+				node.Content[pos+int(i)] = newID
+			}
 			lock.Unlock()
 
 			completeBlob()
 		})
-		idx++
+		idx += int(count)
+		saved++
+	}
+
+	for {
+		// store the zero chunks within holes without reading them, the
+		// chunker restarts with the same boundaries after the skipped chunks
+		if n := holes.zeroChunks(offset); n > 0 {
+			zeros, zeroID := zeroChunk()
+			saveChunk(zeros, zeroID, n, func() {})
+
+			skipped := n * int64(len(zeros))
+			node.Size += uint64(skipped)
+			offset += skipped
+			if _, err := holes.f.Seek(offset, io.SeekStart); err != nil {
+				_ = f.Close()
+				completeError(err)
+				return nil
+			}
+			chnker.Reset(f, s.pol)
+			completeBytes()
+		}
+
+		buf := s.saveFilePool.Get()
+		chunk, err := chnker.Next(buf.Data)
+		if err == io.EOF {
+			buf.Release()
+			break
+		}
+		if err != nil {
+			buf.Release()
+			_ = f.Close()
+			completeError(err)
+			return nil
+		}
+
+		buf.Data = chunk.Data
+		node.Size += uint64(chunk.Length)
+		offset += int64(chunk.Length)
 
 		// test if the context has been cancelled, return the error
 		if ctx.Err() != nil {
+			buf.Release()
 			_ = f.Close()
 			completeError(ctx.Err())
-			return
+			return nil
 		}
 
-		s.CompleteBlob(uint64(len(chunk.Data)))
+		saveChunk(buf.Data, restic.ID{}, 1, buf.Release)
+
+		// test if the context has been cancelled, return the error
+		if ctx.Err() != nil {
+			_ = f.Close()
+			completeError(ctx.Err())
+			return nil
+		}
+
+		completeBytes()
 	}
 
 	err = f.Close()
 	if err != nil {
 		completeError(err)
-		return
+		return nil
+	}
+
+	if s.Lstat != nil {
+		after, err := s.Lstat(target)
+		if err != nil {
+			completeError(err)
+			return nil
+		}
+
+		if statChanged(before, after, s.ChangeIgnoreFlags) {
+			action := s.OnFileChange
+			switch {
+			case action != FileChangeWarn && attempt <= s.FileChangeRetries:
+				action = FileChangeRetry
+			case action == FileChangeRetry:
+				// no retries left, keep the last copy
+				action = FileChangeWarn
+			}
+			debug.Log("%v was modified while reading it, action %v", target, &action)
+			s.FileChanged(snPath, attempt, action)
+
+			switch action {
+			case FileChangeRetry:
+				newF, err := s.Open(target)
+				if err != nil {
+					completeError(err)
+					return nil
+				}
+
+				lock.Lock()
+				failed := fnr.err != nil
+				abandoned = true
+				lock.Unlock()
+				if failed {
+					_ = newF.Close()
+					return nil
+				}
+
+				// the blobs saved so far are known to the next attempt, thus
+				// take over their statistics once all of them are saved
+				pending.Wait()
+				lock.Lock()
+				prev.stats = fnr.stats
+				lock.Unlock()
+				return newF
+			case FileChangeFail:
+				completeError(errFileChanged)
+				return nil
+			default:
+				node.Error = errFileChanged.Error()
+			}
+		}
 	}
 
 	fnr.node = node
 	lock.Lock()
 	// require one additional completeFuture() call to ensure that the future only completes
 	// after reaching the end of this method
-	remaining += idx + 1
+	remaining += saved + 1
 	lock.Unlock()
 	finishReading()
 	completeBlob()
+	return nil
 }
 
 func (s *fileSaver) worker(ctx context.Context, jobs <-chan saveFileJob) {
@@ -0,0 +1,110 @@
+package archiver
+
+import (
+	"io"
+	"slices"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/filter"
+	"github.com/restic/restic/internal/fs"
+)
+
+// ignoreStack holds the ignore files found in the directories from the backup
+// target down to the currently processed directory. The innermost ignore file
+// is at the top of the stack. A nil stack does not exclude anything.
+type ignoreStack struct {
+	parent *ignoreStack
+	file   *filter.IgnoreFile
+}
+
+// Excluded reports whether item is excluded by one of the ignore files. Rules
+// of ignore files in deeper directories take precedence.
+func (s *ignoreStack) Excluded(item string, isDir bool) bool {
+	for ; s != nil; s = s.parent {
+		if matched, excluded := s.file.Match(item, isDir); matched {
+			return excluded
+		}
+	}
+	return false
+}
+
+// loadIgnoreFiles returns the ignore stack for the directory dir, which must be
+// an absolute path. names contains the directory entries, only ignore files
+// listed in ignoreNames are read. Errors are reported via errFn, the affected
+// ignore file is then skipped.
+func loadIgnoreFiles(filesystem fs.FS, dir string, names []string, ignoreNames []string, parent *ignoreStack, errFn ErrorFunc) (*ignoreStack, error) {
+	stack := parent
+	for _, name := range ignoreNames {
+		if !slices.Contains(names, name) {
+			continue
+		}
+
+		filename := filesystem.Join(dir, name)
+		file, err := readIgnoreFile(filesystem, dir, filename)
+		if err != nil {
+			err = errFn(filename, err)
+			if err != nil {
+				return nil, err
+			}
+			// a partially parsed ignore file is still used
+			if file == nil {
+				continue
+			}
+		}
+
+		debug.Log("using ignore file %v", filename)
+		stack = &ignoreStack{parent: stack, file: file}
+	}
+	return stack, nil
+}
+
+func readIgnoreFile(filesystem fs.FS, dir, filename string) (*filter.IgnoreFile, error) {
+	f, err := filesystem.OpenFile(filename, fs.O_RDONLY|fs.O_NOFOLLOW, false)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(f)
+	cerr := f.Close()
+	if err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "read ignore file")
+	}
+
+	file, err := filter.ParseIgnoreFile(dir, data)
+	if err != nil {
+		return file, errors.Wrapf(err, "parse ignore file")
+	}
+	return file, nil
+}
+
+// targetIgnores caches the ignore stacks for backup targets which were
+// created by expanding a directory, e.g. ".". The ignore files of such a
+// directory apply to the resulting targets. abstarget must be absolute.
+type targetIgnores map[string]*ignoreStack
+
+func (c targetIgnores) get(filesystem fs.FS, abstarget string, explicit bool, ignoreNames []string, errFn ErrorFunc) (*ignoreStack, error) {
+	if explicit || len(ignoreNames) == 0 {
+		return nil, nil
+	}
+
+	dir := filesystem.Dir(abstarget)
+	if stack, ok := c[dir]; ok {
+		return stack, nil
+	}
+
+	var names []string
+	for _, name := range ignoreNames {
+		if _, err := filesystem.Lstat(filesystem.Join(dir, name)); err == nil {
+			names = append(names, name)
+		}
+	}
+	stack, err := loadIgnoreFiles(filesystem, dir, names, ignoreNames, nil, errFn)
+	if err != nil {
+		return nil, err
+	}
+	c[dir] = stack
+	return stack, nil
+}
@@ -0,0 +1,69 @@
+package archiver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/fs"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestArchiverIgnoreFiles(t *testing.T) {
+	src := TestDir{
+		".resticignore": TestFile{Content: "*.log\n/tmp/\n"},
+		"app.log":       TestFile{Content: "log"},
+		"main.go":       TestFile{Content: "package main"},
+		"tmp": TestDir{
+			"file": TestFile{Content: "temporary"},
+		},
+		"sub": TestDir{
+			".resticignore": TestFile{Content: "!keep.log\nsecret\n"},
+			"keep.log":      TestFile{Content: "keep"},
+			"other.log":     TestFile{Content: "other"},
+			"secret":        TestFile{Content: "secret"},
+			"tmp": TestDir{
+				"file": TestFile{Content: "not anchored"},
+			},
+		},
+		"other": TestDir{
+			"secret": TestFile{Content: "not excluded here"},
+		},
+	}
+	want := TestDir{
+		".resticignore": TestFile{Content: "*.log\n/tmp/\n"},
+		"main.go":       TestFile{Content: "package main"},
+		"sub": TestDir{
+			".resticignore": TestFile{Content: "!keep.log\nsecret\n"},
+			"keep.log":      TestFile{Content: "keep"},
+			"tmp": TestDir{
+				"file": TestFile{Content: "not anchored"},
+			},
+		},
+		"other": TestDir{
+			"secret": TestFile{Content: "not excluded here"},
+		},
+	}
+
+	tempdir, repo := prepareTempdirRepoSrc(t, src)
+	back := rtest.Chdir(t, tempdir)
+	defer back()
+
+	sc := NewScanner(fs.Track{FS: fs.NewLocal()})
+	sc.IgnoreFiles = []string{".resticignore"}
+	var total ScanStats
+	sc.Result = func(item string, s ScanStats) {
+		if item == "" {
+			total = s
+		}
+	}
+	rtest.OK(t, sc.Scan(context.TODO(), []string{"."}))
+	rtest.Equals(t, uint(6), total.Files)
+
+	arch := New(repo, fs.Track{FS: fs.NewLocal()}, Options{})
+	arch.IgnoreFiles = []string{".resticignore"}
+	_, snapshotID, _, err := arch.Snapshot(context.TODO(), []string{"."}, SnapshotOptions{Time: time.Now()})
+	rtest.OK(t, err)
+
+	TestEnsureSnapshot(t, repo, snapshotID, want)
+}
@@ -17,6 +17,9 @@ type Scanner struct {
 	Select       SelectFunc
 	Error        ErrorFunc
 	Result       func(item string, s ScanStats)
+
+	// IgnoreFiles contains the names of ignore files, see Archiver.IgnoreFiles.
+	IgnoreFiles []string
 }
 
 // NewScanner initializes a new Scanner.
@@ -36,7 +39,7 @@ type ScanStats struct {
 	Bytes               uint64
 }
 
-func (s *Scanner) scanTree(ctx context.Context, stats ScanStats, tree tree) (ScanStats, error) {
+func (s *Scanner) scanTree(ctx context.Context, stats ScanStats, tree tree, ignores targetIgnores) (ScanStats, error) {
 	// traverse the path in the file system for all leaf nodes
 	if tree.Leaf() {
 		abstarget, err := s.FS.Abs(tree.Path)
@@ -44,7 +47,12 @@ func (s *Scanner) scanTree(ctx context.Context, stats ScanStats, tree tree) (Sca
 			return ScanStats{}, err
 		}
 
-		stats, err = s.scan(ctx, stats, abstarget, tree.Explicit)
+		stack, err := ignores.get(s.FS, abstarget, tree.Explicit, s.IgnoreFiles, s.Error)
+		if err != nil {
+			return ScanStats{}, err
+		}
+
+		stats, err = s.scan(ctx, stats, abstarget, tree.Explicit, stack)
 		if err != nil {
 			return ScanStats{}, err
 		}
@@ -55,7 +63,7 @@ func (s *Scanner) scanTree(ctx context.Context, stats ScanStats, tree tree) (Sca
 	// otherwise recurse into the nodes in a deterministic order
 	for _, name := range tree.NodeNames() {
 		var err error
-		stats, err = s.scanTree(ctx, stats, tree.Nodes[name])
+		stats, err = s.scanTree(ctx, stats, tree.Nodes[name], ignores)
 		if err != nil {
 			return ScanStats{}, err
 		}
@@ -86,7 +94,7 @@ func (s *Scanner) Scan(ctx context.Context, targets []string) error {
 		return err
 	}
 
-	stats, err := s.scanTree(ctx, ScanStats{}, *tree)
+	stats, err := s.scanTree(ctx, ScanStats{}, *tree, make(targetIgnores))
 	if err != nil {
 		return err
 	}
@@ -97,7 +105,8 @@ func (s *Scanner) Scan(ctx context.Context, targets []string) error {
 }
 
 // explicit is true when this path was an explicit backup target (same meaning as tree.Explicit on a leaf).
-func (s *Scanner) scan(ctx context.Context, stats ScanStats, target string, explicit bool) (ScanStats, error) {
+// ignores contains the ignore files of the parent directories.
+func (s *Scanner) scan(ctx context.Context, stats ScanStats, target string, explicit bool, ignores *ignoreStack) (ScanStats, error) {
 	if ctx.Err() != nil {
 		return stats, nil
 	}
@@ -117,6 +126,9 @@ func (s *Scanner) scan(ctx context.Context, stats ScanStats, target string, expl
 	if !explicit && !s.Select(target, fi, s.FS) {
 		return stats, nil
 	}
+	if !explicit && ignores.Excluded(target, fi.Mode.IsDir()) {
+		return stats, nil
+	}
 
 	switch {
 	case fi.Mode.IsRegular():
@@ -129,8 +141,15 @@ func (s *Scanner) scan(ctx context.Context, stats ScanStats, target string, expl
 		}
 		sort.Strings(names)
 
+		if len(s.IgnoreFiles) > 0 {
+			ignores, err = loadIgnoreFiles(s.FS, target, names, s.IgnoreFiles, ignores, s.Error)
+			if err != nil {
+				return stats, err
+			}
+		}
+
 		for _, name := range names {
-			stats, err = s.scan(ctx, stats, s.FS.Join(target, name), false)
+			stats, err = s.scan(ctx, stats, s.FS.Join(target, name), false, ignores)
 			if err != nil {
 				return stats, err
 			}
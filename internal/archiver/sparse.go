@@ -0,0 +1,62 @@
+package archiver
+
+import (
+	"sync"
+
+	"github.com/restic/chunker"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+)
+
+// zeroChunk returns an all-zero chunk of minimal size and its ID. The chunker
+// always cuts such a chunk if it starts at the beginning of a sufficiently
+// long run of zeros, as the rolling hash of an all-zero window is zero.
+var zeroChunk = sync.OnceValues(func() ([]byte, restic.ID) {
+	buf := make([]byte, chunker.MinSize)
+	return buf, restic.Hash(buf)
+})
+
+// holeFinder locates the holes of a sparse file, such that the zero chunks
+// within them can be stored without reading them.
+type holeFinder struct {
+	f          fs.SparseFile
+	start, end int64
+	done       bool
+}
+
+func newHoleFinder(f fs.File) *holeFinder {
+	sf, ok := fs.AsSparseFile(f)
+	if !ok {
+		return nil
+	}
+	return &holeFinder{f: sf}
+}
+
+// zeroChunks returns the number of zero chunks of minimal size which are
+// contained in a hole starting at offset.
+func (h *holeFinder) zeroChunks(offset int64) int64 {
+	if h == nil || h.done {
+		return 0
+	}
+
+	if offset >= h.end {
+		start, end, ok, err := h.f.NextHole(offset)
+		if err != nil {
+			// not all filesystems support locating holes, just read the file
+			debug.Log("locating holes failed: %v", err)
+			h.done = true
+			return 0
+		}
+		if !ok {
+			h.done = true
+			return 0
+		}
+		h.start, h.end = start, end
+	}
+
+	if offset < h.start {
+		return 0
+	}
+	return (h.end - offset) / chunker.MinSize
+}
@@ -0,0 +1,121 @@
+package archiver
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/restic/chunker"
+	"github.com/restic/restic/internal/data"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// unwrapMockFS returns files which expose the underlying file, such that holes
+// can be located while still counting the bytes read.
+type unwrapMockFS struct {
+	*MockFS
+}
+
+func (m unwrapMockFS) OpenFile(name string, flag int, metadataOnly bool) (fs.File, error) {
+	f, err := m.MockFS.OpenFile(name, flag, metadataOnly)
+	if err != nil {
+		return f, err
+	}
+	return unwrapMockFile{f.(MockFile)}, nil
+}
+
+type unwrapMockFile struct {
+	MockFile
+}
+
+func (f unwrapMockFile) Unwrap() fs.File {
+	return f.File
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+func TestArchiverSparseFile(t *testing.T) {
+	tempdir, repo := prepareTempdirRepoSrc(t, TestDir{})
+	filename := filepath.Join(tempdir, "sparse")
+
+	// data, hole, data, hole at the end of the file
+	data1 := rtest.Random(23, 1000*1000)
+	data2 := rtest.Random(42, 700*1000)
+	const hole1, hole2 = 20 * 1024 * 1024, 30*1024*1024 + 123
+	f, err := os.Create(filename)
+	rtest.OK(t, err)
+	_, err = f.Write(data1)
+	rtest.OK(t, err)
+	_, err = f.WriteAt(data2, int64(len(data1)+hole1))
+	rtest.OK(t, err)
+	size := int64(len(data1) + hole1 + len(data2) + hole2)
+	rtest.OK(t, f.Truncate(size))
+
+	sf, ok := fs.AsSparseFile(mustOpenLocal(t, filename))
+	rtest.Assert(t, ok, "local files should support locating holes")
+	_, _, hasHoles, err := sf.NextHole(0)
+	rtest.OK(t, err)
+	rtest.OK(t, sf.Close())
+	rtest.OK(t, f.Close())
+	if !hasHoles {
+		t.Skip("filesystem does not support sparse files")
+	}
+
+	// the content must be chunked exactly like reading the whole file
+	var want restic.IDs
+	chnker := chunker.New(io.MultiReader(
+		bytes.NewReader(data1),
+		io.LimitReader(zeroReader{}, hole1),
+		bytes.NewReader(data2),
+		io.LimitReader(zeroReader{}, hole2),
+	), repo.Config().ChunkerPolynomial)
+	buf := make([]byte, chunker.MaxSize)
+	for {
+		chunk, err := chnker.Next(buf)
+		if err == io.EOF {
+			break
+		}
+		rtest.OK(t, err)
+		want = append(want, restic.Hash(chunk.Data))
+	}
+
+	testFS := unwrapMockFS{&MockFS{
+		FS:        fs.Track{FS: fs.NewLocal()},
+		bytesRead: make(map[string]int),
+	}}
+	back := rtest.Chdir(t, tempdir)
+	defer back()
+	arch := New(repo, testFS, Options{})
+	sn, _, _, err := arch.Snapshot(context.TODO(), []string{"sparse"}, SnapshotOptions{Time: time.Now()})
+	rtest.OK(t, err)
+
+	tree, err := data.LoadTree(context.TODO(), repo, *sn.Tree)
+	rtest.OK(t, err)
+	finder := data.NewTreeFinder(tree)
+	defer finder.Close()
+	node, err := finder.Find("sparse")
+	rtest.OK(t, err)
+	rtest.Assert(t, node != nil, "sparse file not found in snapshot")
+
+	rtest.Equals(t, uint64(size), node.Size)
+	rtest.Equals(t, want, restic.IDs(node.Content))
+	read := testFS.bytesRead["sparse"]
+	rtest.Assert(t, read < int(size)/4, "read %d bytes of sparse file with %d bytes data", read, len(data1)+len(data2))
+}
+
+func mustOpenLocal(t testing.TB, filename string) fs.File {
+	f, err := fs.NewLocal().OpenFile(filename, fs.O_NOFOLLOW, false)
+	rtest.OK(t, err)
+	return f
+}
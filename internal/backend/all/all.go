@@ -4,6 +4,7 @@ import (
 	"github.com/restic/restic/internal/backend/azure"
 	"github.com/restic/restic/internal/backend/b2"
 	"github.com/restic/restic/internal/backend/gs"
+	"github.com/restic/restic/internal/backend/image"
 	"github.com/restic/restic/internal/backend/local"
 	"github.com/restic/restic/internal/backend/location"
 	"github.com/restic/restic/internal/backend/rclone"
@@ -18,6 +19,7 @@ func Backends() *location.Registry {
 	backends.Register(azure.NewFactory())
 	backends.Register(b2.NewFactory())
 	backends.Register(gs.NewFactory())
+	backends.Register(image.NewFactory())
 	backends.Register(local.NewFactory())
 	backends.Register(rclone.NewFactory())
 	backends.Register(rest.NewFactory())
@@ -11,6 +11,7 @@ import (
 	"net/http"
 	"path"
 	"strings"
+	"time"
 
 	"github.com/restic/restic/internal/backend"
 	"github.com/restic/restic/internal/backend/layout"
@@ -27,6 +28,7 @@ import (
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
 	azContainer "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/cenkalti/backoff/v4"
 )
 
 // Backend stores data on an azure endpoint.
@@ -37,6 +39,9 @@ type Backend struct {
 	layout.Layout
 
 	accessTier blob.AccessTier
+
+	rehydrateTier     blob.AccessTier
+	rehydratePriority blob.RehydratePriority
 }
 
 const singleUploadMaxSize = 256 * 1024 * 1024
@@ -45,6 +50,7 @@ const defaultListMaxItems = 5000
 
 // make sure that *Backend implements backend.Backend
 var _ backend.Backend = &Backend{}
+var _ backend.WarmupEstimator = &Backend{}
 
 func NewFactory() location.Factory {
 	return location.NewHTTPBackendFactory("azure", ParseConfig, location.NoPassword, Create, Open)
@@ -150,6 +156,30 @@ func open(cfg Config, rt http.RoundTripper) (*Backend, error) {
 		accessTier:  accessTier,
 	}
 
+	if cfg.EnableRehydrate {
+		if !backend.WarmupEnabled() {
+			return nil, errors.New("feature flag `cold-storage-restore` is required to use `-o azure.enable-rehydrate=true`")
+		}
+
+		for _, tier := range []blob.AccessTier{blob.AccessTierHot, blob.AccessTierCool, blob.AccessTierCold} {
+			if strings.EqualFold(string(tier), cfg.RehydrateTier) {
+				be.rehydrateTier = tier
+			}
+		}
+		if be.rehydrateTier == "" {
+			return nil, errors.Errorf("invalid rehydrate tier %q", cfg.RehydrateTier)
+		}
+
+		for _, prio := range blob.PossibleRehydratePriorityValues() {
+			if strings.EqualFold(string(prio), cfg.RehydratePriority) {
+				be.rehydratePriority = prio
+			}
+		}
+		if be.rehydratePriority == "" {
+			return nil, errors.Errorf("invalid rehydrate priority %q", cfg.RehydratePriority)
+		}
+	}
+
 	return be, nil
 }
 
@@ -461,8 +491,126 @@ func (be *Backend) Delete(ctx context.Context) error {
 // Close does nothing
 func (be *Backend) Close() error { return nil }
 
-// Warmup not implemented
-func (be *Backend) Warmup(_ context.Context, _ []backend.Handle) ([]backend.Handle, error) {
-	return []backend.Handle{}, nil
+// Warmup requests the rehydration of blobs stored in the archive access tier.
+func (be *Backend) Warmup(ctx context.Context, handles []backend.Handle) ([]backend.Handle, error) {
+	handlesWarmingUp := []backend.Handle{}
+
+	if be.cfg.EnableRehydrate {
+		for _, h := range handles {
+			objName := be.Filename(h)
+			isWarmingUp, err := be.requestRehydrate(ctx, objName)
+			if err != nil {
+				return handlesWarmingUp, err
+			}
+			if isWarmingUp {
+				debug.Log("azure blob is being rehydrated: %s", objName)
+				handlesWarmingUp = append(handlesWarmingUp, h)
+			}
+		}
+	}
+
+	return handlesWarmingUp, nil
+}
+
+// getArchiveStatus returns whether the blob is archived and whether a
+// rehydration is already in progress.
+func (be *Backend) getArchiveStatus(ctx context.Context, objName string) (archived bool, rehydrating bool, err error) {
+	props, err := be.container.NewBlobClient(objName).GetProperties(ctx, nil)
+	if err != nil {
+		return false, false, errors.Wrap(err, "blob.GetProperties")
+	}
+
+	if props.AccessTier == nil || !strings.EqualFold(*props.AccessTier, string(blob.AccessTierArchive)) {
+		return false, false, nil
+	}
+	rehydrating = props.ArchiveStatus != nil && strings.HasPrefix(*props.ArchiveStatus, "rehydrate-pending")
+	return true, rehydrating, nil
+}
+
+// requestRehydrate moves an archived blob to the configured rehydration tier.
+func (be *Backend) requestRehydrate(ctx context.Context, objName string) (bool, error) {
+	archived, rehydrating, err := be.getArchiveStatus(ctx, objName)
+	if err != nil || !archived {
+		return false, err
+	}
+	if rehydrating {
+		return true, nil
+	}
+
+	_, err = be.container.NewBlobClient(objName).SetTier(ctx, be.rehydrateTier, &blob.SetTierOptions{
+		RehydratePriority: &be.rehydratePriority,
+	})
+	if bloberror.HasCode(err, bloberror.BlobBeingRehydrated) {
+		return true, nil
+	}
+	if err != nil {
+		return false, errors.Wrap(err, "blob.SetTier")
+	}
+	return true, nil
+}
+
+// WarmupWait waits until all handles have left the archive access tier.
+func (be *Backend) WarmupWait(ctx context.Context, handles []backend.Handle) error {
+	timeoutCtx, timeoutCtxCancel := context.WithTimeout(ctx, be.cfg.RehydrateTimeout)
+	defer timeoutCtxCancel()
+
+	if be.cfg.EnableRehydrate {
+		for _, h := range handles {
+			objName := be.Filename(h)
+			err := be.waitForRehydrate(timeoutCtx, objName)
+			if err != nil {
+				return err
+			}
+			debug.Log("azure blob is rehydrated: %s", objName)
+		}
+	}
+
+	return nil
+}
+
+// waitForRehydrate waits until the given blob has been rehydrated.
+func (be *Backend) waitForRehydrate(ctx context.Context, objName string) error {
+	for {
+		var archived, rehydrating bool
+
+		// Rehydration can take up to 15 hours, therefore network may fail
+		// temporarily. We don't need to die in such event.
+		b := backoff.WithMaxRetries(backoff.NewExponentialBackOff(), 10)
+		b = backoff.WithContext(b, ctx)
+		err := backoff.Retry(
+			func() (err error) {
+				archived, rehydrating, err = be.getArchiveStatus(ctx, objName)
+				return
+			},
+			b,
+		)
+		if err != nil {
+			return err
+		}
+
+		if !archived {
+			return nil
+		}
+		if !rehydrating {
+			return errors.New("waiting on azure blob that is not being rehydrated")
+		}
+
+		select {
+		case <-time.After(1 * time.Minute):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// WarmupEstimate returns the maximum time a rehydration from the archive
+// access tier takes using the configured priority.
+func (be *Backend) WarmupEstimate() time.Duration {
+	if !be.cfg.EnableRehydrate {
+		return 0
+	}
+	if be.rehydratePriority == blob.RehydratePriorityHigh {
+		return time.Hour
+	}
+	return 15 * time.Hour
 }
-func (be *Backend) WarmupWait(_ context.Context, _ []backend.Handle) error { return nil }
@@ -5,6 +5,7 @@ import (
 	"path"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/restic/restic/internal/backend"
 	"github.com/restic/restic/internal/errors"
@@ -24,12 +25,20 @@ type Config struct {
 
 	Connections uint   `option:"connections" help:"set a limit for the number of concurrent connections (default: 5)"`
 	AccessTier  string `option:"access-tier" help:"set the access tier for the blob storage (default: inferred from the storage account defaults)"`
+
+	EnableRehydrate   bool          `option:"enable-rehydrate" help:"rehydrate blobs from the archive access tier (default: false, requires \"cold-storage-restore\" feature flag)"`
+	RehydrateTier     string        `option:"rehydrate-tier" help:"access tier to which archived blobs are rehydrated (Hot, Cool or Cold) (default: Cool)"`
+	RehydratePriority string        `option:"rehydrate-priority" help:"priority with which blobs are rehydrated (Standard or High) (default: Standard)"`
+	RehydrateTimeout  time.Duration `option:"rehydrate-timeout" help:"maximum time to wait for blobs to be rehydrated (default: 24h)"`
 }
 
 // NewConfig returns a new Config with the default values filled in.
 func NewConfig() Config {
 	return Config{
-		Connections: 5,
+		Connections:       5,
+		RehydrateTier:     "Cool",
+		RehydratePriority: "Standard",
+		RehydrateTimeout:  24 * time.Hour,
 	}
 }
 
@@ -2,26 +2,32 @@ package azure
 
 import (
 	"testing"
+	"time"
 
 	"github.com/restic/restic/internal/backend/test"
 )
 
+func newTestConfig(cfg Config) Config {
+	cfg.Connections = 5
+	cfg.RehydrateTier = "Cool"
+	cfg.RehydratePriority = "Standard"
+	cfg.RehydrateTimeout = 24 * time.Hour
+	return cfg
+}
+
 var configTests = []test.ConfigTestData[Config]{
-	{S: "azure:container-name:/", Cfg: Config{
-		Container:   "container-name",
-		Prefix:      "",
-		Connections: 5,
-	}},
-	{S: "azure:container-name:/prefix/directory", Cfg: Config{
-		Container:   "container-name",
-		Prefix:      "prefix/directory",
-		Connections: 5,
-	}},
-	{S: "azure:container-name:/prefix/directory/", Cfg: Config{
-		Container:   "container-name",
-		Prefix:      "prefix/directory",
-		Connections: 5,
-	}},
+	{S: "azure:container-name:/", Cfg: newTestConfig(Config{
+		Container: "container-name",
+		Prefix:    "",
+	})},
+	{S: "azure:container-name:/prefix/directory", Cfg: newTestConfig(Config{
+		Container: "container-name",
+		Prefix:    "prefix/directory",
+	})},
+	{S: "azure:container-name:/prefix/directory/", Cfg: newTestConfig(Config{
+		Container: "container-name",
+		Prefix:    "prefix/directory",
+	})},
 }
 
 func TestParseConfig(t *testing.T) {
@@ -131,6 +131,14 @@ type FreezeBackend interface {
 	Unfreeze()
 }
 
+// Compacter is implemented by backends which do not immediately release the
+// space of removed files.
+type Compacter interface {
+	Backend
+	// Compact releases the space occupied by removed files.
+	Compact(ctx context.Context) error
+}
+
 // FileInfo is contains information about a file in the backend.
 type FileInfo struct {
 	Size int64
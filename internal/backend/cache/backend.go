@@ -48,7 +48,7 @@ func (b *cacheBackend) Remove(ctx context.Context, h backend.Handle) error {
 
 func autoCacheTypes(h backend.Handle) bool {
 	switch h.Type {
-	case backend.IndexFile, backend.SnapshotFile:
+	case backend.IndexFile, backend.SnapshotFile, backend.SnapshotLogFile:
 		return true
 	case backend.PackFile:
 		return h.IsMetadata
@@ -43,9 +43,10 @@ func readVersion(dir string) (v uint, err error) {
 const cacheVersion = 1
 
 var cacheLayoutPaths = map[backend.FileType]string{
-	backend.PackFile:     "data",
-	backend.SnapshotFile: "snapshots",
-	backend.IndexFile:    "index",
+	backend.PackFile:        "data",
+	backend.SnapshotFile:    "snapshots",
+	backend.IndexFile:       "index",
+	backend.SnapshotLogFile: "snapshotlog",
 }
 
 const cachedirTagSignature = "Signature: 8a477f597d28d172789f06886806bc55\n"
@@ -0,0 +1,73 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/restic/restic/internal/debug"
+)
+
+// stateDir is the subdirectory of the repository cache directory which stores
+// local state that is not a copy of a repository file.
+const stateDir = "state"
+
+func (c *Cache) stateFilename(name string) string {
+	return filepath.Join(c.path, stateDir, name)
+}
+
+// StateFilename returns the path of the state file name. This allows storing
+// state which is not read or written as a whole.
+func (c *Cache) StateFilename(name string) string {
+	return c.stateFilename(name)
+}
+
+// LoadState returns the content of the state file name. If the file does not
+// exist, an error matching os.ErrNotExist is returned.
+func (c *Cache) LoadState(name string) ([]byte, error) {
+	buf, err := os.ReadFile(c.stateFilename(name))
+	return buf, errors.WithStack(err)
+}
+
+// SaveState atomically replaces the state file name with buf.
+func (c *Cache) SaveState(name string, buf []byte) error {
+	debug.Log("save state %v", name)
+	finalname := c.stateFilename(name)
+	dir := filepath.Dir(finalname)
+	if err := os.MkdirAll(dir, dirMode); err != nil {
+		return errors.WithStack(err)
+	}
+
+	f, err := os.CreateTemp(dir, "tmp-")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if _, err = f.Write(buf); err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return errors.WithStack(err)
+	}
+
+	// Close, then rename. Windows doesn't like the reverse order.
+	if err = f.Close(); err != nil {
+		_ = os.Remove(f.Name())
+		return errors.WithStack(err)
+	}
+
+	err = os.Rename(f.Name(), finalname)
+	if err != nil {
+		_ = os.Remove(f.Name())
+	}
+	return errors.WithStack(err)
+}
+
+// RemoveState deletes the state file name. Removing a state file that does not
+// exist is not an error.
+func (c *Cache) RemoveState(name string) error {
+	err := os.Remove(c.stateFilename(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return errors.WithStack(err)
+}
@@ -0,0 +1,28 @@
+package cache
+
+import (
+	"os"
+	"testing"
+
+	"github.com/restic/restic/internal/errors"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestStateFiles(t *testing.T) {
+	c := TestNewCache(t)
+
+	_, err := c.LoadState("foo")
+	rtest.Assert(t, errors.Is(err, os.ErrNotExist), "expected ErrNotExist, got %v", err)
+
+	rtest.OK(t, c.SaveState("foo", []byte("first")))
+	rtest.OK(t, c.SaveState("foo", []byte("second")))
+	buf, err := c.LoadState("foo")
+	rtest.OK(t, err)
+	rtest.Equals(t, "second", string(buf))
+
+	rtest.OK(t, c.RemoveState("foo"))
+	_, err = c.LoadState("foo")
+	rtest.Assert(t, errors.Is(err, os.ErrNotExist), "expected ErrNotExist, got %v", err)
+	// removing a missing state file is fine
+	rtest.OK(t, c.RemoveState("foo"))
+}
@@ -17,6 +17,9 @@ const (
 	SnapshotFile
 	IndexFile
 	ConfigFile
+	ParityFile
+	SnapshotLogFile
+	RekeyFile
 )
 
 func (t FileType) String() string {
@@ -35,6 +38,12 @@ func (t FileType) String() string {
 		s = "index"
 	case ConfigFile:
 		s = "config"
+	case ParityFile:
+		s = "parity"
+	case SnapshotLogFile:
+		s = "snapshotlog"
+	case RekeyFile:
+		s = "rekey"
 	}
 	return s
 }
@@ -63,6 +72,9 @@ func (h Handle) Valid() error {
 	case SnapshotFile:
 	case IndexFile:
 	case ConfigFile:
+	case ParityFile:
+	case SnapshotLogFile:
+	case RekeyFile:
 	default:
 		return errors.Errorf("invalid Type %d", h.Type)
 	}
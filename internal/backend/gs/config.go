@@ -20,6 +20,8 @@ type Config struct {
 
 	Connections uint   `option:"connections" help:"set a limit for the number of concurrent connections (default: 5)"`
 	Region      string `option:"region" help:"region to create the bucket in (default: us)"`
+
+	StorageClass string `option:"storage-class" help:"set the storage class for data files (STANDARD, NEARLINE, COLDLINE or ARCHIVE) (default: bucket default)"`
 }
 
 // NewConfig returns a new Config with the default values filled in.
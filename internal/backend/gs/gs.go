@@ -42,6 +42,8 @@ type gs struct {
 	region      string
 	bucket      *storage.BucketHandle
 	layout.Layout
+
+	storageClass string
 }
 
 // Ensure that *Backend implements backend.Backend.
@@ -95,6 +97,13 @@ func (be *gs) bucketExists(ctx context.Context, bucket *storage.BucketHandle) (b
 func open(cfg Config, rt http.RoundTripper) (*gs, error) {
 	debug.Log("open, config %#v", cfg)
 
+	storageClass := strings.ToUpper(cfg.StorageClass)
+	switch storageClass {
+	case "", "STANDARD", "NEARLINE", "COLDLINE", "ARCHIVE":
+	default:
+		return nil, errors.Errorf("invalid storage class %q", cfg.StorageClass)
+	}
+
 	gcsClient, err := getStorageClient(rt)
 	if err != nil {
 		return nil, errors.Wrap(err, "getStorageClient")
@@ -108,6 +117,8 @@ func open(cfg Config, rt http.RoundTripper) (*gs, error) {
 		region:      cfg.Region,
 		bucket:      gcsClient.Bucket(cfg.Bucket),
 		Layout:      layout.NewDefaultLayout(cfg.Prefix, path.Join),
+
+		storageClass: storageClass,
 	}
 
 	return be, nil
@@ -187,6 +198,17 @@ func (be *gs) Hasher() hash.Hash {
 	return md5.New()
 }
 
+// useStorageClass returns whether the file should be saved in the configured
+// storage class. For storage classes other than STANDARD, only data files use
+// that class as metadata is read frequently and would cause retrieval fees.
+func (be *gs) useStorageClass(h backend.Handle) bool {
+	if be.storageClass == "" {
+		return false
+	}
+	isDataFile := h.Type == backend.PackFile && !h.IsMetadata
+	return be.storageClass == "STANDARD" || isDataFile
+}
+
 // Save stores data in the backend at the handle.
 func (be *gs) Save(ctx context.Context, h backend.Handle, rd backend.RewindReader) error {
 	objName := be.Filename(h)
@@ -219,6 +241,9 @@ func (be *gs) Save(ctx context.Context, h backend.Handle, rd backend.RewindReade
 	w := be.bucket.Object(objName).NewWriter(ctx)
 	w.ChunkSize = 0
 	w.MD5 = rd.Hash()
+	if be.useStorageClass(h) {
+		w.StorageClass = be.storageClass
+	}
 	wbytes, err := io.Copy(w, rd)
 	cerr := w.Close()
 	if err == nil {
@@ -346,7 +371,8 @@ func (be *gs) Delete(ctx context.Context) error {
 // Close does nothing.
 func (be *gs) Close() error { return nil }
 
-// Warmup not implemented
+// Warmup is not necessary. Objects in the NEARLINE, COLDLINE and ARCHIVE
+// storage classes can be read immediately, albeit with retrieval fees.
 func (be *gs) Warmup(_ context.Context, _ []backend.Handle) ([]backend.Handle, error) {
 	return []backend.Handle{}, nil
 }
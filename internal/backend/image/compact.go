@@ -0,0 +1,186 @@
+package image
+
+import (
+	"cmp"
+	"context"
+	"encoding/binary"
+	"slices"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+)
+
+// copyBufferSize is the size of the buffer used to move records.
+const copyBufferSize = 1024 * 1024
+
+// Compact moves all file records to the start of the image, thereby releasing
+// the space of removed files. Regular image files are truncated afterwards.
+//
+// Compaction is crash-safe: a checkpoint is written before records are moved,
+// such that the overwritten space is no longer part of the replayed log. Each
+// moved record is committed individually using a relocation record. Other
+// operations can continue between moving two records.
+func (be *Image) Compact(ctx context.Context) error {
+	be.writeMu.Lock()
+	err := be.writeCheckpoint()
+	logEnd := be.sb.LogEnd
+	be.writeMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	type item struct {
+		key    fileKey
+		offset int64
+	}
+	be.dirMu.Lock()
+	items := make([]item, 0, len(be.dir))
+	for key, e := range be.dir {
+		items = append(items, item{key: key, offset: e.offset})
+	}
+	be.dirMu.Unlock()
+	slices.SortFunc(items, func(a, b item) int {
+		return cmp.Compare(a.offset, b.offset)
+	})
+
+	cursor := int64(dataStart)
+	for _, it := range items {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		cursor, err = be.compactRecord(it.key, it.offset, cursor)
+		if err != nil {
+			return err
+		}
+	}
+
+	be.writeMu.Lock()
+	defer be.writeMu.Unlock()
+	be.layoutMu.Lock()
+	defer be.layoutMu.Unlock()
+
+	if err := be.moveCheckpoint(cursor); err != nil {
+		return err
+	}
+	debug.Log("compacted image from %d to %d bytes", logEnd, be.sb.LogEnd)
+
+	if be.regular {
+		size := max(be.sb.LogEnd, int64(be.Config.Size)*1024*1024)
+		return errors.WithStack(be.f.Truncate(size))
+	}
+	return nil
+}
+
+// compactRecord moves the file record at offset to cursor and returns the
+// offset after the moved record.
+func (be *Image) compactRecord(key fileKey, offset int64, cursor int64) (int64, error) {
+	be.writeMu.Lock()
+	defer be.writeMu.Unlock()
+	be.layoutMu.Lock()
+	defer be.layoutMu.Unlock()
+
+	be.dirMu.Lock()
+	e, ok := be.dir[key]
+	be.dirMu.Unlock()
+	if !ok || e.offset != offset {
+		// the file was removed or replaced in the meantime
+		return cursor, nil
+	}
+
+	size := e.recordSize()
+	if e.offset == cursor {
+		return cursor + size, nil
+	}
+
+	if cursor+size > e.offset {
+		// the record overlaps its destination. Copy it to the end of the log
+		// first, which is equivalent to saving the file again.
+		tmp := be.sb.LogEnd
+		if be.capacity > 0 && tmp+size > be.capacity {
+			return 0, errImageFull
+		}
+		if err := be.copyRange(e.offset, tmp, size); err != nil {
+			return 0, err
+		}
+		be.sb.LogEnd = tmp + size
+		if err := be.commit(); err != nil {
+			be.sb.LogEnd = tmp
+			return 0, err
+		}
+		e.offset = tmp
+		be.setEntry(key, e)
+	}
+
+	if err := be.copyRange(e.offset, cursor, size); err != nil {
+		return 0, err
+	}
+	buf := binary.LittleEndian.AppendUint64(nil, uint64(cursor))
+	hdr := recordHeader{Kind: recordRelocate, Type: key.Type, Name: key.Name, DataLen: int64(len(buf))}
+	if _, err := be.appendAndCommit(hdr, backend.NewByteReader(buf, nil)); err != nil {
+		return 0, err
+	}
+	e.offset = cursor
+	be.setEntry(key, e)
+
+	return cursor + size, nil
+}
+
+func (be *Image) setEntry(key fileKey, e entry) {
+	be.dirMu.Lock()
+	be.dir[key] = e
+	be.dirMu.Unlock()
+}
+
+// moveCheckpoint writes a new checkpoint and, if no file record is stored
+// after cursor, moves it to cursor such that the log ends right after it.
+// The caller must hold writeMu and layoutMu.
+func (be *Image) moveCheckpoint(cursor int64) error {
+	if err := be.writeCheckpoint(); err != nil {
+		return err
+	}
+
+	be.dirMu.Lock()
+	var end int64
+	for _, e := range be.dir {
+		end = max(end, e.offset+e.recordSize())
+	}
+	be.dirMu.Unlock()
+
+	size := be.sb.LogEnd - be.sb.Checkpoint
+	if end > cursor || cursor+size > be.sb.Checkpoint {
+		// files were saved during the compaction or the checkpoint would
+		// overwrite itself, keep it at the end of the log
+		return nil
+	}
+
+	if err := be.copyRange(be.sb.Checkpoint, cursor, size); err != nil {
+		return err
+	}
+	sb := be.sb
+	be.sb.Checkpoint = cursor
+	be.sb.LogEnd = cursor + size
+	if err := be.commit(); err != nil {
+		be.sb = sb
+		return err
+	}
+	return nil
+}
+
+// copyRange copies n bytes from src to dst. Both ranges must not overlap.
+func (be *Image) copyRange(src, dst, n int64) error {
+	buf := make([]byte, min(n, copyBufferSize))
+	for n > 0 {
+		chunk := buf[:min(n, int64(len(buf)))]
+		if m, err := be.f.ReadAt(chunk, src); m < len(chunk) {
+			return errors.WithStack(err)
+		}
+		if _, err := be.f.WriteAt(chunk, dst); err != nil {
+			return errors.WithStack(err)
+		}
+		src += int64(len(chunk))
+		dst += int64(len(chunk))
+		n -= int64(len(chunk))
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package image
+
+import (
+	"strings"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/options"
+)
+
+// Config holds all information needed to open an image repository.
+type Config struct {
+	Path string
+
+	Connections uint `option:"connections" help:"set a limit for the number of concurrent operations (default: 2)"`
+	Size        uint `option:"size" help:"preallocate a new image file with this size in MiB (default: grow as needed)"`
+}
+
+// NewConfig returns a new config with default options applied.
+func NewConfig() Config {
+	return Config{
+		Connections: 2,
+	}
+}
+
+func init() {
+	options.Register("image", Config{})
+}
+
+// ParseConfig parses an image backend config.
+func ParseConfig(s string) (*Config, error) {
+	if !strings.HasPrefix(s, "image:") {
+		return nil, errors.New(`invalid format, prefix "image" not found`)
+	}
+
+	cfg := NewConfig()
+	cfg.Path = s[6:]
+	if cfg.Path == "" {
+		return nil, errors.New("image: path is empty")
+	}
+	return &cfg, nil
+}
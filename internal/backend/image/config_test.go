@@ -0,0 +1,26 @@
+package image
+
+import (
+	"testing"
+
+	"github.com/restic/restic/internal/backend/test"
+)
+
+var configTests = []test.ConfigTestData[Config]{
+	{S: "image:/some/file.img", Cfg: Config{
+		Path:        "/some/file.img",
+		Connections: 2,
+	}},
+	{S: "image:/dev/sdb1", Cfg: Config{
+		Path:        "/dev/sdb1",
+		Connections: 2,
+	}},
+	{S: "image:dir/repo.img", Cfg: Config{
+		Path:        "dir/repo.img",
+		Connections: 2,
+	}},
+}
+
+func TestParseConfig(t *testing.T) {
+	test.ParseConfigTester(t, ParseConfig, configTests)
+}
@@ -0,0 +1,11 @@
+// Package image implements repository storage inside a single file or block
+// device.
+//
+// The image starts with two superblock slots, followed by an append-only log
+// of records. A record either stores a file, removes a file, relocates a file
+// or contains a checkpoint of the whole directory table. The superblocks
+// record the end of the committed log and the latest directory checkpoint.
+// They are written alternately, such that a crash while writing a superblock
+// leaves the previous one intact. Records beyond the committed end of the log
+// are ignored when opening the image.
+package image
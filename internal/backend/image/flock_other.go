@@ -0,0 +1,11 @@
+//go:build !unix
+
+package image
+
+import "os"
+
+// lockFile is a no-op on this platform. Users must ensure that only a single
+// restic process accesses the image at a time.
+func lockFile(_ *os.File) (unlock func() error, err error) {
+	return func() error { return nil }, nil
+}
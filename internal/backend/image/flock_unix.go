@@ -0,0 +1,21 @@
+//go:build unix
+
+package image
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// lockFile acquires an exclusive lock for the image, which prevents other
+// restic processes from modifying it concurrently.
+func lockFile(f *os.File) (unlock func() error, err error) {
+	err = unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+	if err != nil {
+		return nil, err
+	}
+	return func() error {
+		return unix.Flock(int(f.Fd()), unix.LOCK_UN)
+	}, nil
+}
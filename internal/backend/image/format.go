@@ -0,0 +1,236 @@
+package image
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/errors"
+)
+
+const (
+	superblockSize = 4096
+	// dataStart is the offset of the first record, after both superblock slots.
+	dataStart = 2 * superblockSize
+
+	formatVersion = 1
+
+	recordHeaderSize = 32
+	recordMagic      = 0x52524543 // "RREC"
+)
+
+var superblockMagic = [8]byte{'r', 'e', 's', 't', 'i', 'c', 'i', 'm'}
+
+// errCorrupted is returned if the image contains invalid data.
+var errCorrupted = errors.New("image is corrupted")
+
+// superblock describes the committed state of the image.
+type superblock struct {
+	Seq uint64
+	// LogEnd is the offset after the last committed record.
+	LogEnd int64
+	// Checkpoint is the offset of the latest directory checkpoint record, or
+	// zero if there is none. Only records after the checkpoint are replayed.
+	Checkpoint int64
+}
+
+func (sb superblock) marshal() []byte {
+	buf := make([]byte, superblockSize)
+	copy(buf, superblockMagic[:])
+	binary.LittleEndian.PutUint32(buf[8:], formatVersion)
+	binary.LittleEndian.PutUint64(buf[12:], sb.Seq)
+	binary.LittleEndian.PutUint64(buf[20:], uint64(sb.LogEnd))
+	binary.LittleEndian.PutUint64(buf[28:], uint64(sb.Checkpoint))
+	binary.LittleEndian.PutUint32(buf[36:], crc32.ChecksumIEEE(buf[:36]))
+	return buf
+}
+
+func unmarshalSuperblock(buf []byte) (superblock, bool) {
+	if len(buf) < 40 || !bytes.Equal(buf[:8], superblockMagic[:]) {
+		return superblock{}, false
+	}
+	if crc32.ChecksumIEEE(buf[:36]) != binary.LittleEndian.Uint32(buf[36:]) {
+		return superblock{}, false
+	}
+	if binary.LittleEndian.Uint32(buf[8:]) != formatVersion {
+		return superblock{}, false
+	}
+	return superblock{
+		Seq:        binary.LittleEndian.Uint64(buf[12:]),
+		LogEnd:     int64(binary.LittleEndian.Uint64(buf[20:])),
+		Checkpoint: int64(binary.LittleEndian.Uint64(buf[28:])),
+	}, true
+}
+
+// readSuperblock returns the valid superblock with the highest sequence
+// number. The boolean is false if the image contains no valid superblock.
+func readSuperblock(rd io.ReaderAt) (superblock, bool, error) {
+	var best superblock
+	found := false
+	buf := make([]byte, superblockSize)
+	for slot := int64(0); slot < 2; slot++ {
+		n, err := rd.ReadAt(buf, slot*superblockSize)
+		if err != nil && err != io.EOF {
+			return superblock{}, false, errors.WithStack(err)
+		}
+		sb, ok := unmarshalSuperblock(buf[:n])
+		if ok && (!found || sb.Seq > best.Seq) {
+			best = sb
+			found = true
+		}
+	}
+	return best, found, nil
+}
+
+type recordKind uint8
+
+const (
+	// recordFile stores the content of a file.
+	recordFile recordKind = iota + 1
+	// recordRemove removes a file.
+	recordRemove
+	// recordRelocate moves a file record to the offset stored as data.
+	recordRelocate
+	// recordCheckpoint contains the complete directory table.
+	recordCheckpoint
+)
+
+// recordHeader is stored at the start of each record and followed by the
+// name and the data.
+type recordHeader struct {
+	Kind    recordKind
+	Type    backend.FileType
+	Name    string
+	DataLen int64
+}
+
+func (h recordHeader) size() int64 {
+	return recordHeaderSize + int64(len(h.Name)) + h.DataLen
+}
+
+func (h recordHeader) dataOffset(offset int64) int64 {
+	return offset + recordHeaderSize + int64(len(h.Name))
+}
+
+func (h recordHeader) marshal() []byte {
+	buf := make([]byte, recordHeaderSize+len(h.Name))
+	binary.LittleEndian.PutUint32(buf[0:], recordMagic)
+	buf[4] = byte(h.Kind)
+	buf[5] = byte(h.Type)
+	binary.LittleEndian.PutUint16(buf[6:], uint16(len(h.Name)))
+	binary.LittleEndian.PutUint64(buf[8:], uint64(h.DataLen))
+	copy(buf[recordHeaderSize:], h.Name)
+
+	crc := crc32.NewIEEE()
+	_, _ = crc.Write(buf[:28])
+	_, _ = crc.Write(buf[recordHeaderSize:])
+	binary.LittleEndian.PutUint32(buf[28:], crc.Sum32())
+	return buf
+}
+
+// readRecordHeader reads and verifies the record header at offset.
+func readRecordHeader(rd io.ReaderAt, offset int64) (recordHeader, error) {
+	buf := make([]byte, recordHeaderSize)
+	if _, err := rd.ReadAt(buf, offset); err != nil {
+		return recordHeader{}, errors.Wrapf(errCorrupted, "reading record at %d: %v", offset, err)
+	}
+	if binary.LittleEndian.Uint32(buf[0:]) != recordMagic {
+		return recordHeader{}, errors.Wrapf(errCorrupted, "invalid record at %d", offset)
+	}
+
+	name := make([]byte, binary.LittleEndian.Uint16(buf[6:]))
+	if _, err := rd.ReadAt(name, offset+recordHeaderSize); err != nil {
+		return recordHeader{}, errors.Wrapf(errCorrupted, "reading record at %d: %v", offset, err)
+	}
+
+	crc := crc32.NewIEEE()
+	_, _ = crc.Write(buf[:28])
+	_, _ = crc.Write(name)
+	if crc.Sum32() != binary.LittleEndian.Uint32(buf[28:]) {
+		return recordHeader{}, errors.Wrapf(errCorrupted, "invalid checksum for record at %d", offset)
+	}
+
+	return recordHeader{
+		Kind:    recordKind(buf[4]),
+		Type:    backend.FileType(buf[5]),
+		Name:    string(name),
+		DataLen: int64(binary.LittleEndian.Uint64(buf[8:])),
+	}, nil
+}
+
+// entry is an entry of the directory table.
+type entry struct {
+	// offset of the file record
+	offset int64
+	// size of the file content
+	size int64
+	// nameLen is the length of the name stored in the record header
+	nameLen int
+}
+
+func (e entry) dataOffset() int64 {
+	return e.offset + recordHeaderSize + int64(e.nameLen)
+}
+
+func (e entry) recordSize() int64 {
+	return recordHeaderSize + int64(e.nameLen) + e.size
+}
+
+type fileKey struct {
+	Type backend.FileType
+	Name string
+}
+
+// handleKey returns the directory key for h. There is only a single config
+// file, its name is ignored.
+func handleKey(h backend.Handle) fileKey {
+	if h.Type == backend.ConfigFile {
+		return fileKey{Type: h.Type}
+	}
+	return fileKey{Type: h.Type, Name: h.Name}
+}
+
+// marshalDirectory serializes the directory table for a checkpoint record.
+func marshalDirectory(dir map[fileKey]entry) []byte {
+	buf := binary.LittleEndian.AppendUint64(nil, uint64(len(dir)))
+	for key, e := range dir {
+		buf = append(buf, byte(key.Type))
+		buf = binary.LittleEndian.AppendUint16(buf, uint16(len(key.Name)))
+		buf = append(buf, key.Name...)
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(e.offset))
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(e.size))
+	}
+	return buf
+}
+
+func unmarshalDirectory(buf []byte) (map[fileKey]entry, error) {
+	if len(buf) < 8 {
+		return nil, errors.Wrap(errCorrupted, "checkpoint too short")
+	}
+	count := binary.LittleEndian.Uint64(buf)
+	buf = buf[8:]
+
+	dir := make(map[fileKey]entry)
+	for i := uint64(0); i < count; i++ {
+		if len(buf) < 3 {
+			return nil, errors.Wrap(errCorrupted, "checkpoint too short")
+		}
+		t := backend.FileType(buf[0])
+		nameLen := int(binary.LittleEndian.Uint16(buf[1:]))
+		buf = buf[3:]
+		if len(buf) < nameLen+16 {
+			return nil, errors.Wrap(errCorrupted, "checkpoint too short")
+		}
+		key := fileKey{Type: t, Name: string(buf[:nameLen])}
+		buf = buf[nameLen:]
+		dir[key] = entry{
+			offset:  int64(binary.LittleEndian.Uint64(buf)),
+			size:    int64(binary.LittleEndian.Uint64(buf[8:])),
+			nameLen: nameLen,
+		}
+		buf = buf[16:]
+	}
+	return dir, nil
+}
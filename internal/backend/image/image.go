@@ -0,0 +1,570 @@
+package image
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/backend/limiter"
+	"github.com/restic/restic/internal/backend/location"
+	"github.com/restic/restic/internal/backend/util"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+
+	"github.com/cenkalti/backoff/v4"
+)
+
+// checkpointInterval is the number of records after which a new directory
+// checkpoint is written. This bounds the number of records which must be
+// replayed when opening the image.
+const checkpointInterval = 1000
+
+// Image is a backend which stores all files in a single file or block device.
+type Image struct {
+	Config
+
+	f *os.File
+	// regular is true if the image is a regular file which can be truncated.
+	regular bool
+	// capacity is the size of a block device, zero if the image can grow.
+	capacity int64
+	unlock   func() error
+
+	// writeMu serializes all modifications of the image.
+	writeMu sync.Mutex
+	// layoutMu is held by readers and locked exclusively while records are
+	// moved by Compact.
+	layoutMu sync.RWMutex
+	// dirMu protects dir.
+	dirMu sync.Mutex
+	dir   map[fileKey]entry
+
+	// the following fields are protected by writeMu
+	sb              superblock
+	sinceCheckpoint int
+	closed          bool
+}
+
+// ensure statically that *Image implements backend.Backend and backend.Compacter.
+var _ backend.Backend = &Image{}
+var _ backend.Compacter = &Image{}
+
+var errTooShort = fmt.Errorf("file is too short")
+var errImageFull = fmt.Errorf("image is full")
+
+// unusedCheckSize is the number of bytes at the start of a block device which
+// must be zero before it is used for a new image.
+const unusedCheckSize = 1024 * 1024
+
+func NewFactory() location.Factory {
+	return location.NewLimitedBackendFactory("image", ParseConfig, location.NoPassword, limiter.WrapBackendConstructor(Create), limiter.WrapBackendConstructor(Open))
+}
+
+func open(cfg Config, flags int) (*Image, error) {
+	f, err := os.OpenFile(cfg.Path, os.O_RDWR|flags, 0600)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	be := &Image{
+		Config: cfg,
+		f:      f,
+		dir:    make(map[fileKey]entry),
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, errors.WithStack(err)
+	}
+	be.regular = fi.Mode().IsRegular()
+	if !be.regular {
+		// the size of block devices is only reported by seeking to the end
+		be.capacity, err = f.Seek(0, io.SeekEnd)
+		if err != nil {
+			_ = f.Close()
+			return nil, errors.WithStack(err)
+		}
+		debug.Log("image %v is a device with capacity %d", cfg.Path, be.capacity)
+	}
+
+	be.unlock, err = lockFile(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, errors.Errorf("image %v is in use by another process: %v", cfg.Path, err)
+	}
+	return be, nil
+}
+
+// Open opens the image backend as specified by config.
+func Open(_ context.Context, cfg Config, _ func(string, ...interface{})) (*Image, error) {
+	debug.Log("open image backend at %v", cfg.Path)
+	be, err := open(cfg, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %v", backend.ErrNoRepository, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sb, ok, err := readSuperblock(be.f)
+	if err == nil && !ok {
+		err = errors.Errorf("%v does not contain a repository image", cfg.Path)
+	}
+	if err == nil {
+		be.sb = sb
+		err = be.replay()
+	}
+	if err != nil {
+		_ = be.Close()
+		return nil, err
+	}
+	return be, nil
+}
+
+// Create creates a new image. An existing image is only reused if it does not
+// contain any files. Other existing files must be empty and block devices must
+// start with zeros, such that a wrong path cannot overwrite unrelated data.
+// Afterwards a new config blob should be created.
+func Create(_ context.Context, cfg Config, _ func(string, ...interface{})) (*Image, error) {
+	debug.Log("create image backend at %v", cfg.Path)
+	be, err := open(cfg, os.O_CREATE)
+	if err != nil {
+		return nil, err
+	}
+
+	sb, ok, err := readSuperblock(be.f)
+	if err == nil && ok {
+		be.sb = sb
+		err = be.replay()
+		if err == nil && len(be.dir) > 0 {
+			err = errors.New("config file already exists")
+		}
+	} else if err == nil {
+		err = be.checkUnused()
+	}
+	if err != nil {
+		_ = be.Close()
+		return nil, err
+	}
+
+	if be.regular && cfg.Size > 0 {
+		if err := fs.PreallocateFile(be.f, int64(cfg.Size)*1024*1024); err != nil {
+			debug.Log("failed to preallocate image: %v", err)
+		}
+	}
+
+	be.sb = superblock{Seq: sb.Seq, LogEnd: dataStart}
+	be.dir = make(map[fileKey]entry)
+	// write both superblock slots to invalidate any previous content
+	for i := 0; i < 2; i++ {
+		if err := be.commit(); err != nil {
+			_ = be.Close()
+			return nil, err
+		}
+	}
+	return be, nil
+}
+
+// checkUnused returns an error if the image contains data although it has no
+// valid superblock.
+func (be *Image) checkUnused() error {
+	if be.regular {
+		fi, err := be.f.Stat()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if fi.Size() == 0 {
+			return nil
+		}
+	} else {
+		buf := make([]byte, unusedCheckSize)
+		n, err := be.f.ReadAt(buf, 0)
+		if err != nil && err != io.EOF {
+			return errors.WithStack(err)
+		}
+		if bytes.Count(buf[:n], []byte{0}) == n {
+			return nil
+		}
+	}
+	return errors.Errorf("%v is not empty and does not contain a repository image, refusing to overwrite it", be.Path)
+}
+
+// replay loads the latest checkpoint and applies all records after it.
+func (be *Image) replay() error {
+	dir := make(map[fileKey]entry)
+	pos := int64(dataStart)
+	if be.sb.Checkpoint != 0 {
+		hdr, err := readRecordHeader(be.f, be.sb.Checkpoint)
+		if err != nil {
+			return err
+		}
+		if hdr.Kind != recordCheckpoint {
+			return errors.Wrapf(errCorrupted, "no checkpoint at %d", be.sb.Checkpoint)
+		}
+		dir, err = be.loadCheckpoint(be.sb.Checkpoint, hdr)
+		if err != nil {
+			return err
+		}
+		pos = be.sb.Checkpoint + hdr.size()
+	}
+
+	records := 0
+	for pos < be.sb.LogEnd {
+		hdr, err := readRecordHeader(be.f, pos)
+		if err != nil {
+			return err
+		}
+		key := fileKey{Type: hdr.Type, Name: hdr.Name}
+
+		switch hdr.Kind {
+		case recordFile:
+			dir[key] = entry{offset: pos, size: hdr.DataLen, nameLen: len(hdr.Name)}
+		case recordRemove:
+			delete(dir, key)
+		case recordRelocate:
+			buf := make([]byte, 8)
+			if _, err := be.f.ReadAt(buf, hdr.dataOffset(pos)); err != nil {
+				return errors.Wrapf(errCorrupted, "reading record at %d: %v", pos, err)
+			}
+			if e, ok := dir[key]; ok {
+				e.offset = int64(binary.LittleEndian.Uint64(buf))
+				dir[key] = e
+			}
+		case recordCheckpoint:
+			dir, err = be.loadCheckpoint(pos, hdr)
+			if err != nil {
+				return err
+			}
+		default:
+			return errors.Wrapf(errCorrupted, "unknown record type %d at %d", hdr.Kind, pos)
+		}
+
+		pos += hdr.size()
+		records++
+	}
+	if pos != be.sb.LogEnd {
+		return errors.Wrapf(errCorrupted, "last record ends at %d instead of %d", pos, be.sb.LogEnd)
+	}
+
+	debug.Log("replayed %d records, %d files in image", records, len(dir))
+	be.dir = dir
+	be.sinceCheckpoint = records
+	return nil
+}
+
+func (be *Image) loadCheckpoint(offset int64, hdr recordHeader) (map[fileKey]entry, error) {
+	buf := make([]byte, hdr.DataLen)
+	if _, err := be.f.ReadAt(buf, hdr.dataOffset(offset)); err != nil {
+		return nil, errors.Wrapf(errCorrupted, "reading checkpoint at %d: %v", offset, err)
+	}
+	return unmarshalDirectory(buf)
+}
+
+// sync flushes all written data to the storage device.
+func (be *Image) sync() error {
+	err := be.f.Sync()
+	if err != nil && (errors.Is(err, syscall.ENOTSUP) || errors.Is(err, syscall.EINVAL)) {
+		// ignore if the file system does not support fsync
+		return nil
+	}
+	return errors.WithStack(err)
+}
+
+// commit writes the superblock, which makes all records up to sb.LogEnd
+// visible. The caller must hold writeMu.
+func (be *Image) commit() error {
+	if err := be.sync(); err != nil {
+		return err
+	}
+	be.sb.Seq++
+	slot := int64(be.sb.Seq % 2)
+	if _, err := be.f.WriteAt(be.sb.marshal(), slot*superblockSize); err != nil {
+		return errors.WithStack(err)
+	}
+	return be.sync()
+}
+
+// appendRecord writes a record after the end of the committed log. The record
+// only becomes visible after the next commit. The caller must hold writeMu.
+func (be *Image) appendRecord(hdr recordHeader, rd io.Reader) (int64, error) {
+	offset := be.sb.LogEnd
+	if be.capacity > 0 && offset+hdr.size() > be.capacity {
+		return 0, backoff.Permanent(errImageFull)
+	}
+
+	if _, err := be.f.WriteAt(hdr.marshal(), offset); err != nil {
+		return 0, errors.WithStack(err)
+	}
+	n, err := io.Copy(io.NewOffsetWriter(be.f, hdr.dataOffset(offset)), rd)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	if n != hdr.DataLen {
+		return 0, errors.Errorf("wrote %d bytes instead of the expected %d bytes", n, hdr.DataLen)
+	}
+
+	be.sb.LogEnd = offset + hdr.size()
+	return offset, nil
+}
+
+// appendAndCommit appends a record and commits it. The caller must hold writeMu.
+func (be *Image) appendAndCommit(hdr recordHeader, rd io.Reader) (int64, error) {
+	logEnd := be.sb.LogEnd
+	offset, err := be.appendRecord(hdr, rd)
+	if err == nil {
+		err = be.commit()
+	}
+	if err != nil {
+		be.sb.LogEnd = logEnd
+		return 0, err
+	}
+	be.sinceCheckpoint++
+	return offset, nil
+}
+
+// writeCheckpoint appends a checkpoint of the directory table. The caller
+// must hold writeMu.
+func (be *Image) writeCheckpoint() error {
+	be.dirMu.Lock()
+	buf := marshalDirectory(be.dir)
+	be.dirMu.Unlock()
+
+	logEnd := be.sb.LogEnd
+	checkpoint := be.sb.Checkpoint
+	offset, err := be.appendRecord(recordHeader{Kind: recordCheckpoint, DataLen: int64(len(buf))}, backend.NewByteReader(buf, nil))
+	if err == nil {
+		be.sb.Checkpoint = offset
+		err = be.commit()
+	}
+	if err != nil {
+		be.sb.LogEnd = logEnd
+		be.sb.Checkpoint = checkpoint
+		return err
+	}
+	be.sinceCheckpoint = 0
+	return nil
+}
+
+func (be *Image) maybeCheckpoint() {
+	if be.sinceCheckpoint < checkpointInterval {
+		return
+	}
+	if err := be.writeCheckpoint(); err != nil {
+		// the records are committed anyways, retry with the next record
+		debug.Log("writing checkpoint failed: %v", err)
+	}
+}
+
+func (be *Image) lookup(h backend.Handle) (entry, error) {
+	be.dirMu.Lock()
+	e, ok := be.dir[handleKey(h)]
+	be.dirMu.Unlock()
+	if !ok {
+		return entry{}, errors.WithStack(&os.PathError{Op: "open", Path: h.String(), Err: os.ErrNotExist})
+	}
+	return e, nil
+}
+
+func (be *Image) Properties() backend.Properties {
+	return backend.Properties{
+		Connections:      be.Config.Connections,
+		HasAtomicReplace: true,
+	}
+}
+
+// Hasher may return a hash function for calculating a content hash for the backend
+func (be *Image) Hasher() hash.Hash {
+	return nil
+}
+
+// IsNotExist returns true if the error is caused by a non existing file.
+func (be *Image) IsNotExist(err error) bool {
+	return errors.Is(err, os.ErrNotExist)
+}
+
+func (be *Image) IsPermanentError(err error) bool {
+	return be.IsNotExist(err) || errors.Is(err, errTooShort) || errors.Is(err, errImageFull) ||
+		errors.Is(err, errCorrupted) || errors.Is(err, os.ErrPermission)
+}
+
+// Save stores data in the backend at the handle.
+func (be *Image) Save(_ context.Context, h backend.Handle, rd backend.RewindReader) (err error) {
+	defer func() {
+		// Mark non-retriable errors as such
+		if errors.Is(err, syscall.ENOSPC) || os.IsPermission(err) {
+			err = backoff.Permanent(err)
+		}
+	}()
+
+	be.writeMu.Lock()
+	defer be.writeMu.Unlock()
+
+	key := handleKey(h)
+	hdr := recordHeader{Kind: recordFile, Type: key.Type, Name: key.Name, DataLen: rd.Length()}
+	offset, err := be.appendAndCommit(hdr, rd)
+	if err != nil {
+		return err
+	}
+
+	be.dirMu.Lock()
+	be.dir[key] = entry{offset: offset, size: hdr.DataLen, nameLen: len(key.Name)}
+	be.dirMu.Unlock()
+
+	be.maybeCheckpoint()
+	return nil
+}
+
+// Load runs fn with a reader that yields the contents of the file at h at the
+// given offset.
+func (be *Image) Load(ctx context.Context, h backend.Handle, length int, offset int64, fn func(rd io.Reader) error) error {
+	return util.DefaultLoad(ctx, h, length, offset, be.openReader, fn)
+}
+
+// unlockReader releases the layout lock once the reader is closed.
+type unlockReader struct {
+	io.Reader
+	once   sync.Once
+	unlock func()
+}
+
+func (rd *unlockReader) Close() error {
+	rd.once.Do(rd.unlock)
+	return nil
+}
+
+func (be *Image) openReader(_ context.Context, h backend.Handle, length int, offset int64) (io.ReadCloser, error) {
+	be.layoutMu.RLock()
+	e, err := be.lookup(h)
+	if err != nil {
+		be.layoutMu.RUnlock()
+		return nil, err
+	}
+
+	if e.size < offset+int64(length) {
+		be.layoutMu.RUnlock()
+		return nil, errTooShort
+	}
+
+	n := e.size - offset
+	if length > 0 {
+		n = int64(length)
+	}
+	return &unlockReader{
+		Reader: io.NewSectionReader(be.f, e.dataOffset()+offset, n),
+		unlock: be.layoutMu.RUnlock,
+	}, nil
+}
+
+// Stat returns information about a file.
+func (be *Image) Stat(_ context.Context, h backend.Handle) (backend.FileInfo, error) {
+	e, err := be.lookup(h)
+	if err != nil {
+		return backend.FileInfo{}, err
+	}
+	return backend.FileInfo{Size: e.size, Name: h.Name}, nil
+}
+
+// Remove removes the file with the given name and type.
+func (be *Image) Remove(_ context.Context, h backend.Handle) error {
+	be.writeMu.Lock()
+	defer be.writeMu.Unlock()
+
+	if _, err := be.lookup(h); err != nil {
+		return err
+	}
+
+	key := handleKey(h)
+	_, err := be.appendAndCommit(recordHeader{Kind: recordRemove, Type: key.Type, Name: key.Name}, bytes.NewReader(nil))
+	if err != nil {
+		return err
+	}
+
+	be.dirMu.Lock()
+	delete(be.dir, key)
+	be.dirMu.Unlock()
+
+	be.maybeCheckpoint()
+	return nil
+}
+
+// List runs fn for each file in the backend which has the type t. When an
+// error occurs (or fn returns an error), List stops and returns it.
+func (be *Image) List(ctx context.Context, t backend.FileType, fn func(backend.FileInfo) error) error {
+	var files []backend.FileInfo
+	be.dirMu.Lock()
+	for key, e := range be.dir {
+		if key.Type == t {
+			files = append(files, backend.FileInfo{Name: key.Name, Size: e.size})
+		}
+	}
+	be.dirMu.Unlock()
+
+	for _, fi := range files {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err := fn(fi); err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+// Delete removes all files from the image.
+func (be *Image) Delete(_ context.Context) error {
+	be.writeMu.Lock()
+	defer be.writeMu.Unlock()
+	be.layoutMu.Lock()
+	defer be.layoutMu.Unlock()
+
+	be.sb = superblock{Seq: be.sb.Seq, LogEnd: dataStart}
+	if err := be.commit(); err != nil {
+		return err
+	}
+	be.dirMu.Lock()
+	be.dir = make(map[fileKey]entry)
+	be.dirMu.Unlock()
+	be.sinceCheckpoint = 0
+
+	if be.regular {
+		return errors.WithStack(be.f.Truncate(dataStart))
+	}
+	return nil
+}
+
+// Close writes a final checkpoint and closes the image.
+func (be *Image) Close() error {
+	be.writeMu.Lock()
+	defer be.writeMu.Unlock()
+	if be.closed {
+		return nil
+	}
+	be.closed = true
+
+	if be.sinceCheckpoint > 0 && be.sb.LogEnd > 0 {
+		if err := be.writeCheckpoint(); err != nil {
+			debug.Log("writing checkpoint failed: %v", err)
+		}
+	}
+
+	err := be.unlock()
+	if cerr := be.f.Close(); err == nil {
+		err = cerr
+	}
+	return errors.WithStack(err)
+}
+
+// Warmup not implemented
+func (be *Image) Warmup(_ context.Context, _ []backend.Handle) ([]backend.Handle, error) {
+	return []backend.Handle{}, nil
+}
+func (be *Image) WarmupWait(_ context.Context, _ []backend.Handle) error { return nil }
@@ -0,0 +1,175 @@
+package image_test
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/backend/image"
+	"github.com/restic/restic/internal/backend/test"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func newTestSuite(t testing.TB) *test.Suite[image.Config] {
+	return &test.Suite[image.Config]{
+		// NewConfig returns a config for a new temporary backend that will be used in tests.
+		NewConfig: func() (*image.Config, error) {
+			path := filepath.Join(rtest.TempDir(t), "repo.img")
+			t.Logf("create new backend at %v", path)
+
+			cfg := image.NewConfig()
+			cfg.Path = path
+			return &cfg, nil
+		},
+
+		Factory: image.NewFactory(),
+	}
+}
+
+func TestBackend(t *testing.T) {
+	newTestSuite(t).RunTests(t)
+}
+
+func BenchmarkBackend(t *testing.B) {
+	newTestSuite(t).RunBenchmarks(t)
+}
+
+func save(t testing.TB, be backend.Backend, h backend.Handle, data []byte) {
+	rtest.OK(t, be.Save(context.TODO(), h, backend.NewByteReader(data, be.Hasher())))
+}
+
+func load(t testing.TB, be backend.Backend, h backend.Handle) []byte {
+	var buf []byte
+	rtest.OK(t, be.Load(context.TODO(), h, 0, 0, func(rd io.Reader) (err error) {
+		buf, err = io.ReadAll(rd)
+		return err
+	}))
+	return buf
+}
+
+func fileSize(t testing.TB, path string) int64 {
+	fi, err := os.Stat(path)
+	rtest.OK(t, err)
+	return fi.Size()
+}
+
+func TestCompact(t *testing.T) {
+	ctx := context.TODO()
+	cfg := image.NewConfig()
+	cfg.Path = filepath.Join(rtest.TempDir(t), "repo.img")
+
+	be, err := image.Create(ctx, cfg, t.Logf)
+	rtest.OK(t, err)
+
+	files := make(map[backend.Handle][]byte)
+	for i := 0; i < 20; i++ {
+		h := backend.Handle{Type: backend.PackFile, Name: restic.NewRandomID().String()}
+		files[h] = rtest.Random(i, 64*1024)
+		save(t, be, h, files[h])
+	}
+	for h := range files {
+		if h.Name[0]%2 == 0 {
+			rtest.OK(t, be.Remove(ctx, h))
+			delete(files, h)
+		}
+	}
+	before := fileSize(t, cfg.Path)
+
+	rtest.OK(t, be.Compact(ctx))
+	rtest.Assert(t, fileSize(t, cfg.Path) < before, "image was not truncated")
+
+	// the image must remain usable after compaction
+	h := backend.Handle{Type: backend.SnapshotFile, Name: "new"}
+	files[h] = []byte("new snapshot")
+	save(t, be, h, files[h])
+	rtest.OK(t, be.Close())
+
+	be, err = image.Open(ctx, cfg, t.Logf)
+	rtest.OK(t, err)
+	defer func() {
+		rtest.OK(t, be.Close())
+	}()
+
+	count := 0
+	for _, t2 := range []backend.FileType{backend.PackFile, backend.SnapshotFile} {
+		rtest.OK(t, be.List(ctx, t2, func(backend.FileInfo) error {
+			count++
+			return nil
+		}))
+	}
+	rtest.Equals(t, len(files), count)
+	for h, data := range files {
+		rtest.Equals(t, data, load(t, be, h))
+	}
+}
+
+func TestReopenWithoutClose(t *testing.T) {
+	ctx := context.TODO()
+	cfg := image.NewConfig()
+	cfg.Path = filepath.Join(rtest.TempDir(t), "repo.img")
+
+	be, err := image.Create(ctx, cfg, t.Logf)
+	rtest.OK(t, err)
+	defer func() {
+		rtest.OK(t, be.Close())
+	}()
+	index := backend.Handle{Type: backend.IndexFile, Name: "index"}
+	removed := backend.Handle{Type: backend.SnapshotFile, Name: "removed"}
+	save(t, be, index, []byte("index data"))
+	save(t, be, removed, []byte("snapshot"))
+	rtest.OK(t, be.Remove(ctx, removed))
+
+	// simulate a crash: copy the image while it is still open, such that no
+	// final checkpoint is written. An incomplete record after the end of the
+	// committed log must be ignored.
+	buf, err := os.ReadFile(cfg.Path)
+	rtest.OK(t, err)
+	buf = append(buf, []byte("incomplete record")...)
+	crashed := cfg
+	crashed.Path = filepath.Join(rtest.TempDir(t), "crashed.img")
+	rtest.OK(t, os.WriteFile(crashed.Path, buf, 0600))
+
+	be2, err := image.Open(ctx, crashed, t.Logf)
+	rtest.OK(t, err)
+	rtest.Equals(t, []byte("index data"), load(t, be2, index))
+	_, err = be2.Stat(ctx, removed)
+	rtest.Assert(t, be2.IsNotExist(err), "removed file still exists: %v", err)
+
+	// new records overwrite the incomplete one
+	snapshot := backend.Handle{Type: backend.SnapshotFile, Name: "new"}
+	save(t, be2, snapshot, []byte("new snapshot"))
+	rtest.OK(t, be2.Close())
+
+	be2, err = image.Open(ctx, crashed, t.Logf)
+	rtest.OK(t, err)
+	rtest.Equals(t, []byte("index data"), load(t, be2, index))
+	rtest.Equals(t, []byte("new snapshot"), load(t, be2, snapshot))
+	rtest.OK(t, be2.Close())
+
+	_, err = image.Create(ctx, crashed, t.Logf)
+	rtest.Assert(t, err != nil, "creating an image over an existing repository should fail")
+}
+
+func TestCreateKeepsExistingFile(t *testing.T) {
+	ctx := context.TODO()
+	cfg := image.NewConfig()
+	cfg.Path = filepath.Join(rtest.TempDir(t), "data")
+	data := rtest.Random(23, 64*1024)
+	rtest.OK(t, os.WriteFile(cfg.Path, data, 0600))
+
+	_, err := image.Create(ctx, cfg, t.Logf)
+	rtest.Assert(t, err != nil, "creating an image over an existing file should fail")
+	buf, err := os.ReadFile(cfg.Path)
+	rtest.OK(t, err)
+	rtest.Equals(t, data, buf)
+
+	// an empty file can be used
+	rtest.OK(t, os.WriteFile(cfg.Path, nil, 0600))
+	be, err := image.Create(ctx, cfg, t.Logf)
+	rtest.OK(t, err)
+	rtest.OK(t, be.Close())
+}
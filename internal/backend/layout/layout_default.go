@@ -16,11 +16,14 @@ type DefaultLayout struct {
 }
 
 var defaultLayoutPaths = map[backend.FileType]string{
-	backend.PackFile:     "data",
-	backend.SnapshotFile: "snapshots",
-	backend.IndexFile:    "index",
-	backend.LockFile:     "locks",
-	backend.KeyFile:      "keys",
+	backend.PackFile:        "data",
+	backend.SnapshotFile:    "snapshots",
+	backend.IndexFile:       "index",
+	backend.LockFile:        "locks",
+	backend.KeyFile:         "keys",
+	backend.ParityFile:      "parity",
+	backend.SnapshotLogFile: "snapshotlog",
+	backend.RekeyFile:       "rekey",
 }
 
 func NewDefaultLayout(path string, join func(...string) string) *DefaultLayout {
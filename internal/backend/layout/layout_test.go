@@ -58,6 +58,24 @@ func TestDefaultLayout(t *testing.T) {
 			backend.Handle{Type: backend.KeyFile, Name: "123456"},
 			filepath.Join(tempdir, "keys", "123456"),
 		},
+		{
+			tempdir,
+			filepath.Join,
+			backend.Handle{Type: backend.ParityFile, Name: "0123456"},
+			filepath.Join(tempdir, "parity", "0123456"),
+		},
+		{
+			tempdir,
+			filepath.Join,
+			backend.Handle{Type: backend.SnapshotLogFile, Name: "123456"},
+			filepath.Join(tempdir, "snapshotlog", "123456"),
+		},
+		{
+			tempdir,
+			filepath.Join,
+			backend.Handle{Type: backend.RekeyFile, Name: "123456"},
+			filepath.Join(tempdir, "rekey", "123456"),
+		},
 		{
 			"",
 			path.Join,
@@ -110,6 +128,9 @@ func TestDefaultLayout(t *testing.T) {
 			filepath.Join(tempdir, "index"),
 			filepath.Join(tempdir, "locks"),
 			filepath.Join(tempdir, "keys"),
+			filepath.Join(tempdir, "parity"),
+			filepath.Join(tempdir, "snapshotlog"),
+			filepath.Join(tempdir, "rekey"),
 		}
 
 		for i := 0; i < 256; i++ {
@@ -170,6 +191,18 @@ func TestRESTLayout(t *testing.T) {
 			backend.Handle{Type: backend.KeyFile, Name: "123456"},
 			strings.Join([]string{url, "keys", "123456"}, "/"),
 		},
+		{
+			backend.Handle{Type: backend.ParityFile, Name: "0123456"},
+			strings.Join([]string{url, "parity", "0123456"}, "/"),
+		},
+		{
+			backend.Handle{Type: backend.SnapshotLogFile, Name: "123456"},
+			strings.Join([]string{url, "snapshotlog", "123456"}, "/"),
+		},
+		{
+			backend.Handle{Type: backend.RekeyFile, Name: "123456"},
+			strings.Join([]string{url, "rekey", "123456"}, "/"),
+		},
 	}
 
 	l := &RESTLayout{
@@ -185,6 +218,9 @@ func TestRESTLayout(t *testing.T) {
 			strings.Join([]string{url, "index"}, "/"),
 			strings.Join([]string{url, "locks"}, "/"),
 			strings.Join([]string{url, "keys"}, "/"),
+			strings.Join([]string{url, "parity"}, "/"),
+			strings.Join([]string{url, "snapshotlog"}, "/"),
+			strings.Join([]string{url, "rekey"}, "/"),
 		}
 
 		sort.Strings(want)
@@ -0,0 +1,364 @@
+package limiter
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// scheduleCheckInterval is the minimum time between two evaluations of the
+// schedule. Rate changes therefore take effect with at most this delay.
+const scheduleCheckInterval = time.Second
+
+// Schedule is a list of rules that specify upload and download limits for
+// certain time windows. For each direction, the first rule that matches the
+// current time and sets a limit for that direction is used.
+type Schedule []ScheduleRule
+
+// ScheduleRule sets the upload and/or download limit during a time window.
+type ScheduleRule struct {
+	// Days contains the weekdays on which the rule is active.
+	Days [7]bool
+	// Start and End are the offsets since midnight of the time window. If End
+	// is before Start, then the window extends past midnight. The window
+	// covers the whole day if both are zero.
+	Start, End time.Duration
+
+	// UploadKb and DownloadKb are nil if the rule does not apply to that
+	// direction. Zero means unlimited.
+	UploadKb   *int
+	DownloadKb *int
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// ParseSchedule parses a schedule such as
+// "Mon-Fri 08:00-18:00 up=2M down=10M; * up=0". Rules are separated by
+// semicolons. Each rule consists of a day specification ("*", "Sat", "Mon-Fri"
+// or a comma separated list thereof), an optional time window "HH:MM-HH:MM"
+// and at least one of "up=<rate>" or "down=<rate>". Rates are specified in
+// KiB/s, optionally using one of the suffixes K, M or G. A rate of zero
+// means unlimited.
+func ParseSchedule(s string) (Schedule, error) {
+	var sched Schedule
+	for _, part := range strings.Split(s, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		rule, err := parseScheduleRule(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule rule %q: %w", part, err)
+		}
+		sched = append(sched, rule)
+	}
+
+	if len(sched) == 0 {
+		return nil, fmt.Errorf("empty schedule")
+	}
+	return sched, nil
+}
+
+func parseScheduleRule(s string) (ScheduleRule, error) {
+	var rule ScheduleRule
+
+	fields := strings.Fields(s)
+	days, err := parseDays(fields[0])
+	if err != nil {
+		return rule, err
+	}
+	rule.Days = days
+	fields = fields[1:]
+
+	if len(fields) > 0 && !strings.Contains(fields[0], "=") {
+		rule.Start, rule.End, err = parseTimeWindow(fields[0])
+		if err != nil {
+			return rule, err
+		}
+		fields = fields[1:]
+	}
+
+	for _, field := range fields {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return rule, fmt.Errorf("unexpected %q, expected up=<rate> or down=<rate>", field)
+		}
+
+		kb, err := parseRate(value)
+		if err != nil {
+			return rule, err
+		}
+
+		switch strings.ToLower(key) {
+		case "up":
+			rule.UploadKb = &kb
+		case "down":
+			rule.DownloadKb = &kb
+		default:
+			return rule, fmt.Errorf("unknown direction %q", key)
+		}
+	}
+
+	if rule.UploadKb == nil && rule.DownloadKb == nil {
+		return rule, fmt.Errorf("neither up nor down limit specified")
+	}
+	return rule, nil
+}
+
+func parseDays(s string) ([7]bool, error) {
+	var days [7]bool
+	if s == "*" {
+		for i := range days {
+			days[i] = true
+		}
+		return days, nil
+	}
+
+	for _, item := range strings.Split(s, ",") {
+		first, last, isRange := strings.Cut(item, "-")
+		start, ok := weekdayNames[strings.ToLower(first)]
+		if !ok {
+			return days, fmt.Errorf("invalid weekday %q", first)
+		}
+		end := start
+		if isRange {
+			end, ok = weekdayNames[strings.ToLower(last)]
+			if !ok {
+				return days, fmt.Errorf("invalid weekday %q", last)
+			}
+		}
+
+		// ranges such as Sat-Mon wrap around the end of the week
+		for d := start; ; d = (d + 1) % 7 {
+			days[d] = true
+			if d == end {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+func parseTimeWindow(s string) (start, end time.Duration, err error) {
+	first, last, ok := strings.Cut(s, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid time window %q, expected HH:MM-HH:MM", s)
+	}
+	start, err = parseTimeOfDay(first)
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err = parseTimeOfDay(last)
+	if err != nil {
+		return 0, 0, err
+	}
+	if start == end {
+		return 0, 0, fmt.Errorf("empty time window %q", s)
+	}
+	return start, end, nil
+}
+
+func parseTimeOfDay(s string) (time.Duration, error) {
+	if s == "24:00" {
+		return 24 * time.Hour, nil
+	}
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func parseRate(s string) (int, error) {
+	factor := 1
+	switch {
+	case strings.HasSuffix(s, "K"), strings.HasSuffix(s, "k"):
+		s = s[:len(s)-1]
+	case strings.HasSuffix(s, "M"), strings.HasSuffix(s, "m"):
+		factor = 1024
+		s = s[:len(s)-1]
+	case strings.HasSuffix(s, "G"), strings.HasSuffix(s, "g"):
+		factor = 1024 * 1024
+		s = s[:len(s)-1]
+	}
+
+	val, err := strconv.Atoi(s)
+	if err != nil || val < 0 {
+		return 0, fmt.Errorf("invalid rate %q", s)
+	}
+	return val * factor, nil
+}
+
+// matches returns whether the rule is active at time t.
+func (r ScheduleRule) matches(t time.Time) bool {
+	day := t.Weekday()
+	offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+
+	switch {
+	case r.Start == 0 && r.End == 0:
+		return r.Days[day]
+	case r.Start < r.End:
+		return r.Days[day] && offset >= r.Start && offset < r.End
+	default:
+		// the window extends past midnight, the part after midnight
+		// belongs to the day on which the window started
+		if offset >= r.Start {
+			return r.Days[day]
+		}
+		return offset < r.End && r.Days[(day+6)%7]
+	}
+}
+
+// Limits returns the limits active at time t. Directions which are not set
+// by any matching rule use the limits from def.
+func (s Schedule) Limits(t time.Time, def Limits) Limits {
+	l := def
+	var upSet, downSet bool
+	for _, rule := range s {
+		if !rule.matches(t) {
+			continue
+		}
+		if rule.UploadKb != nil && !upSet {
+			l.UploadKb = *rule.UploadKb
+			upSet = true
+		}
+		if rule.DownloadKb != nil && !downSet {
+			l.DownloadKb = *rule.DownloadKb
+			downSet = true
+		}
+	}
+	return l
+}
+
+type scheduleLimiter struct {
+	schedule Schedule
+	defaults Limits
+	now      func() time.Time
+
+	m         sync.Mutex
+	lastCheck time.Time
+	current   Limits
+
+	upstream   *rate.Limiter
+	downstream *rate.Limiter
+}
+
+// NewScheduleLimiter constructs a Limiter whose upload and download rate
+// caps follow the schedule. The limits are reevaluated while data is
+// transferred, such that long running operations switch rates without a
+// restart. The defaults apply whenever no rule of the schedule matches.
+func NewScheduleLimiter(schedule Schedule, defaults Limits) Limiter {
+	return newScheduleLimiter(schedule, defaults, time.Now)
+}
+
+func newScheduleLimiter(schedule Schedule, defaults Limits, now func() time.Time) *scheduleLimiter {
+	l := &scheduleLimiter{
+		schedule:   schedule,
+		defaults:   defaults,
+		now:        now,
+		upstream:   rate.NewLimiter(rate.Inf, 0),
+		downstream: rate.NewLimiter(rate.Inf, 0),
+	}
+	l.update(true)
+	return l
+}
+
+// update applies the limits which are active according to the schedule.
+// Unless force is set, the schedule is evaluated at most once per
+// scheduleCheckInterval.
+func (l *scheduleLimiter) update(force bool) {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	now := l.now()
+	if !force && now.Sub(l.lastCheck) < scheduleCheckInterval {
+		return
+	}
+	l.lastCheck = now
+
+	limits := l.schedule.Limits(now, l.defaults)
+	if !force && limits == l.current {
+		return
+	}
+	l.current = limits
+	setBucketRate(l.upstream, limits.UploadKb, now)
+	setBucketRate(l.downstream, limits.DownloadKb, now)
+}
+
+func setBucketRate(bucket *rate.Limiter, kb int, now time.Time) {
+	if kb <= 0 {
+		bucket.SetLimitAt(now, rate.Inf)
+		// consumeTokens requires a positive burst size
+		bucket.SetBurstAt(now, 1)
+		return
+	}
+	bucket.SetLimitAt(now, rate.Limit(toByteRate(kb)))
+	bucket.SetBurstAt(now, int(toByteRate(kb)))
+}
+
+func (l *scheduleLimiter) Upstream(r io.Reader) io.Reader {
+	return &scheduledReader{r, l, l.upstream}
+}
+
+func (l *scheduleLimiter) UpstreamWriter(w io.Writer) io.Writer {
+	return &scheduledWriter{w, l, l.upstream}
+}
+
+func (l *scheduleLimiter) Downstream(r io.Reader) io.Reader {
+	return &scheduledReader{r, l, l.downstream}
+}
+
+func (l *scheduleLimiter) DownstreamWriter(w io.Writer) io.Writer {
+	return &scheduledWriter{w, l, l.downstream}
+}
+
+// Transport returns an HTTP transport limited with the limiter l.
+func (l *scheduleLimiter) Transport(rt http.RoundTripper) http.RoundTripper {
+	return roundTripper(func(req *http.Request) (*http.Response, error) {
+		return limitRoundTrip(l, rt, req)
+	})
+}
+
+type scheduledReader struct {
+	reader  io.Reader
+	limiter *scheduleLimiter
+	bucket  *rate.Limiter
+}
+
+func (r *scheduledReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.limiter.update(false)
+	if err := consumeTokens(n, r.bucket); err != nil {
+		return n, err
+	}
+	return n, err
+}
+
+type scheduledWriter struct {
+	writer  io.Writer
+	limiter *scheduleLimiter
+	bucket  *rate.Limiter
+}
+
+func (w *scheduledWriter) Write(buf []byte) (int, error) {
+	w.limiter.update(false)
+	if err := consumeTokens(len(buf), w.bucket); err != nil {
+		return 0, err
+	}
+	return w.writer.Write(buf)
+}
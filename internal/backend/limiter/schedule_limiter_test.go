@@ -0,0 +1,120 @@
+package limiter
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/test"
+	"golang.org/x/time/rate"
+)
+
+func TestParseSchedule(t *testing.T) {
+	for _, s := range []string{
+		"* up=0",
+		"Mon-Fri 08:00-18:00 up=2M down=10M; * up=0",
+		"sat,sun down=512",
+		"Fri-Mon 22:00-06:00 up=1G",
+		"Mon 00:00-24:00 down=100k",
+	} {
+		_, err := ParseSchedule(s)
+		test.OK(t, err)
+	}
+
+	for _, s := range []string{
+		"",
+		";",
+		"Mon-Fri",
+		"Mon-Fri 08:00-18:00",
+		"Foo up=1",
+		"Mon 08:00 up=1",
+		"Mon 08:00-08:00 up=1",
+		"Mon 25:00-26:00 up=1",
+		"Mon up=-1",
+		"Mon up=1X",
+		"Mon sideways=1",
+	} {
+		_, err := ParseSchedule(s)
+		test.Assert(t, err != nil, "expected error for schedule %q", s)
+	}
+}
+
+func TestScheduleLimits(t *testing.T) {
+	sched, err := ParseSchedule("Mon-Fri 08:00-18:00 up=2M down=10M; Fri 22:00-06:00 down=5; * up=0")
+	test.OK(t, err)
+	defaults := Limits{UploadKb: 100, DownloadKb: 200}
+
+	// 2024-01-01 was a Monday
+	at := func(day int, clock string) time.Time {
+		ts, err := time.Parse("15:04", clock)
+		test.OK(t, err)
+		return time.Date(2024, 1, day, ts.Hour(), ts.Minute(), 0, 0, time.Local)
+	}
+
+	for _, tc := range []struct {
+		t        time.Time
+		expected Limits
+	}{
+		{at(1, "07:59"), Limits{UploadKb: 0, DownloadKb: 200}},
+		{at(1, "08:00"), Limits{UploadKb: 2048, DownloadKb: 10240}},
+		{at(3, "17:59"), Limits{UploadKb: 2048, DownloadKb: 10240}},
+		{at(3, "18:00"), Limits{UploadKb: 0, DownloadKb: 200}},
+		{at(5, "23:00"), Limits{UploadKb: 0, DownloadKb: 5}},
+		// the window starting on Friday extends into Saturday
+		{at(6, "05:59"), Limits{UploadKb: 0, DownloadKb: 5}},
+		{at(6, "06:00"), Limits{UploadKb: 0, DownloadKb: 200}},
+		// but the window does not start on Thursday
+		{at(5, "01:00"), Limits{UploadKb: 0, DownloadKb: 200}},
+	} {
+		test.Equals(t, tc.expected, sched.Limits(tc.t, defaults))
+	}
+}
+
+func TestScheduleLimiterSwitchesRates(t *testing.T) {
+	sched, err := ParseSchedule("* 08:00-18:00 up=42 down=0")
+	test.OK(t, err)
+
+	now := time.Date(2024, 1, 1, 7, 0, 0, 0, time.Local)
+	lim := newScheduleLimiter(sched, Limits{DownloadKb: 21}, func() time.Time { return now })
+
+	test.Equals(t, rate.Inf, lim.upstream.Limit())
+	test.Equals(t, rate.Limit(21*1024), lim.downstream.Limit())
+
+	now = now.Add(2 * time.Hour)
+	_, err = lim.Upstream(bytes.NewReader(make([]byte, 10))).Read(make([]byte, 10))
+	test.OK(t, err)
+	test.Equals(t, rate.Limit(42*1024), lim.upstream.Limit())
+	test.Equals(t, rate.Inf, lim.downstream.Limit())
+
+	// leaving the time window restores the defaults
+	now = now.Add(10 * time.Hour)
+	lim.update(false)
+	test.Equals(t, rate.Inf, lim.upstream.Limit())
+
+	// transfers in the unlimited state must not block
+	n, err := lim.UpstreamWriter(new(bytes.Buffer)).Write(make([]byte, 1<<20))
+	test.OK(t, err)
+	test.Equals(t, 1<<20, n)
+}
+
+func TestConsumeTokensBurstChange(t *testing.T) {
+	bucket := rate.NewLimiter(rate.Limit(1<<40), 1<<20)
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			// lower and raise the burst like a schedule switching rates
+			bucket.SetBurst(1 << 20 >> (i % 2 * 10))
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		test.OK(t, consumeTokens(10<<20, bucket))
+	}
+	close(done)
+}
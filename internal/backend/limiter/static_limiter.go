@@ -64,7 +64,9 @@ func (rt roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
 	return rt(req)
 }
 
-func (l staticLimiter) roundTripper(rt http.RoundTripper, req *http.Request) (*http.Response, error) {
+// limitRoundTrip executes the request using rt and limits the request and
+// response bodies using l.
+func limitRoundTrip(l Limiter, rt http.RoundTripper, req *http.Request) (*http.Response, error) {
 	type readCloser struct {
 		io.Reader
 		io.Closer
@@ -92,7 +94,7 @@ func (l staticLimiter) roundTripper(rt http.RoundTripper, req *http.Request) (*h
 // Transport returns an HTTP transport limited with the limiter l.
 func (l staticLimiter) Transport(rt http.RoundTripper) http.RoundTripper {
 	return roundTripper(func(req *http.Request) (*http.Response, error) {
-		return l.roundTripper(rt, req)
+		return limitRoundTrip(l, rt, req)
 	})
 }
 
@@ -136,15 +138,23 @@ func (w *rateLimitedWriter) Write(buf []byte) (int, error) {
 }
 
 func consumeTokens(tokens int, bucket *rate.Limiter) error {
-	// bucket allows waiting for at most Burst() tokens at once
-	maxWait := bucket.Burst()
-	for tokens > maxWait {
-		if err := bucket.WaitN(context.Background(), maxWait); err != nil {
+	for tokens > 0 {
+		if bucket.Limit() == rate.Inf {
+			return nil
+		}
+		// bucket allows waiting for at most Burst() tokens at once
+		n := min(tokens, bucket.Burst())
+		if err := bucket.WaitN(context.Background(), n); err != nil {
+			// a schedule may have lowered the burst after it was read, retry
+			// using the new value
+			if n > bucket.Burst() {
+				continue
+			}
 			return err
 		}
-		tokens -= maxWait
+		tokens -= n
 	}
-	return bucket.WaitN(context.Background(), tokens)
+	return nil
 }
 
 func toByteRate(val int) float64 {
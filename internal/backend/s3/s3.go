@@ -34,6 +34,7 @@ type s3 struct {
 
 // make sure that *Backend implements backend.Backend
 var _ backend.Backend = &s3{}
+var _ backend.WarmupEstimator = &s3{}
 
 var archiveClasses = []string{"GLACIER", "DEEP_ARCHIVE"}
 
@@ -53,8 +54,8 @@ func NewFactory() location.Factory {
 func open(cfg Config, rt http.RoundTripper) (*s3, error) {
 	debug.Log("open, config %#v", cfg)
 
-	if cfg.EnableRestore && !feature.Flag.Enabled(feature.S3Restore) {
-		return nil, fmt.Errorf("feature flag `s3-restore` is required to use `-o s3.enable-restore=true`")
+	if cfg.EnableRestore && !backend.WarmupEnabled() {
+		return nil, fmt.Errorf("feature flag `cold-storage-restore` is required to use `-o s3.enable-restore=true`")
 	}
 
 	if cfg.MaxRetries > 0 {
@@ -558,6 +559,22 @@ func (be *s3) WarmupWait(ctx context.Context, handles []backend.Handle) error {
 	return nil
 }
 
+// WarmupEstimate returns the maximum time a restore from Glacier or Glacier
+// Deep Archive takes using the configured retrieval tier.
+func (be *s3) WarmupEstimate() time.Duration {
+	if !be.cfg.EnableRestore {
+		return 0
+	}
+	switch strings.ToLower(be.cfg.RestoreTier) {
+	case "expedited":
+		return 5 * time.Minute
+	case "bulk":
+		return 48 * time.Hour
+	default:
+		return 12 * time.Hour
+	}
+}
+
 // waitForRestore waits for a given file to be restored.
 func (be *s3) waitForRestore(ctx context.Context, filename string) error {
 	for {
@@ -0,0 +1,32 @@
+package sftp
+
+import (
+	"github.com/pkg/sftp"
+)
+
+// Session is an SFTP connection to a remote host which is not tied to a
+// repository. It is used to read arbitrary files from the remote host.
+type Session struct {
+	be *SFTP
+}
+
+// Dial starts the ssh command for cfg and opens an SFTP session on top of it.
+// Only the connection settings of cfg are used, cfg.Path is ignored. Messages
+// written to stderr by the ssh command are passed to errorLog.
+func Dial(cfg Config, errorLog func(string, ...interface{})) (*Session, error) {
+	be, err := startClient(cfg, errorLog)
+	if err != nil {
+		return nil, err
+	}
+	return &Session{be: be}, nil
+}
+
+// Client returns the SFTP client of the session.
+func (s *Session) Client() *sftp.Client {
+	return s.be.c
+}
+
+// Close closes the SFTP session and terminates the ssh command.
+func (s *Session) Close() error {
+	return s.be.Close()
+}
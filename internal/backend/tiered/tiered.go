@@ -0,0 +1,373 @@
+package tiered
+
+import (
+	"context"
+	"hash"
+	"io"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+)
+
+type tier int
+
+const (
+	unknownTier tier = iota
+	hotTier
+	coldTier
+)
+
+// Backend stores data pack files in a cold backend and all other files,
+// including tree pack files, in a hot backend.
+type Backend struct {
+	hot  backend.Backend
+	cold backend.Backend
+
+	m         sync.Mutex
+	locations map[string]tier
+}
+
+// statically ensure that Backend implements the optional backend interfaces.
+var _ backend.FreezeBackend = &Backend{}
+var _ backend.WarmupEstimator = &Backend{}
+
+// New returns a backend which routes data pack files to cold and everything
+// else to hot.
+func New(hot, cold backend.Backend) *Backend {
+	debug.Log("created new tiered backend")
+	return &Backend{
+		hot:       hot,
+		cold:      cold,
+		locations: make(map[string]tier),
+	}
+}
+
+// Hot returns the backend which stores all files except data pack files.
+func (be *Backend) Hot() backend.Backend {
+	return be.hot
+}
+
+// Cold returns the backend which stores data pack files.
+func (be *Backend) Cold() backend.Backend {
+	return be.cold
+}
+
+func (be *Backend) tierBackend(t tier) backend.Backend {
+	if t == coldTier {
+		return be.cold
+	}
+	return be.hot
+}
+
+func (be *Backend) setLocation(h backend.Handle, t tier) {
+	if h.Type != backend.PackFile {
+		return
+	}
+	be.m.Lock()
+	defer be.m.Unlock()
+	if t == unknownTier {
+		delete(be.locations, h.Name)
+	} else {
+		be.locations[h.Name] = t
+	}
+}
+
+// locate returns the tier which most likely stores the file. The boolean is
+// false if the location of the file is only a guess.
+func (be *Backend) locate(h backend.Handle) (tier, bool) {
+	if h.Type != backend.PackFile {
+		return hotTier, true
+	}
+	be.m.Lock()
+	t, ok := be.locations[h.Name]
+	be.m.Unlock()
+	if ok {
+		return t, true
+	}
+	if h.IsMetadata {
+		return hotTier, false
+	}
+	return coldTier, false
+}
+
+// saveTier returns the tier new files are stored in.
+func saveTier(h backend.Handle) tier {
+	if h.Type == backend.PackFile && !h.IsMetadata {
+		return coldTier
+	}
+	return hotTier
+}
+
+func otherTier(t tier) tier {
+	if t == coldTier {
+		return hotTier
+	}
+	return coldTier
+}
+
+// withFallback runs fn for the tier which most likely stores the file. If
+// the location is not known for sure and the file does not exist there, fn is
+// retried for the other tier.
+func (be *Backend) withFallback(h backend.Handle, fn func(be backend.Backend) error) error {
+	t, known := be.locate(h)
+	err := fn(be.tierBackend(t))
+	if known || err == nil || !be.tierBackend(t).IsNotExist(err) {
+		if err == nil {
+			be.setLocation(h, t)
+		}
+		return err
+	}
+
+	t = otherTier(t)
+	err2 := fn(be.tierBackend(t))
+	if err2 != nil {
+		// report the original error, the other tier is only a fallback
+		return err
+	}
+	be.setLocation(h, t)
+	return nil
+}
+
+// Properties returns information about the backend.
+func (be *Backend) Properties() backend.Properties {
+	hot := be.hot.Properties()
+	cold := be.cold.Properties()
+	return backend.Properties{
+		Connections:      hot.Connections + cold.Connections,
+		HasAtomicReplace: hot.HasAtomicReplace && cold.HasAtomicReplace,
+		HasFlakyErrors:   hot.HasFlakyErrors || cold.HasFlakyErrors,
+	}
+}
+
+// Hasher returns a hash function which is compatible with both backends. If
+// the backends use different hash functions, Save computes the hash
+// required by the target backend on its own.
+func (be *Backend) Hasher() hash.Hash {
+	hot := be.hot.Hasher()
+	cold := be.cold.Hasher()
+	switch {
+	case hot == nil:
+		return cold
+	case cold == nil:
+		return hot
+	case reflect.TypeOf(hot) == reflect.TypeOf(cold):
+		return hot
+	}
+	return nil
+}
+
+// hashedReader replaces the hash of a RewindReader.
+type hashedReader struct {
+	backend.RewindReader
+	hash []byte
+}
+
+func (rd *hashedReader) Hash() []byte {
+	return rd.hash
+}
+
+// withHash returns a reader which provides the content hash expected by be.
+func withHash(be backend.Backend, rd backend.RewindReader, common hash.Hash) (backend.RewindReader, error) {
+	hasher := be.Hasher()
+	if hasher == nil || (common != nil && reflect.TypeOf(hasher) == reflect.TypeOf(common)) {
+		return rd, nil
+	}
+
+	if _, err := io.Copy(hasher, rd); err != nil {
+		return nil, err
+	}
+	if err := rd.Rewind(); err != nil {
+		return nil, err
+	}
+	return &hashedReader{RewindReader: rd, hash: hasher.Sum(nil)}, nil
+}
+
+// Save stores data pack files in the cold backend and all other files in the
+// hot backend.
+func (be *Backend) Save(ctx context.Context, h backend.Handle, rd backend.RewindReader) error {
+	t := saveTier(h)
+	target := be.tierBackend(t)
+
+	var err error
+	if rd.Hash() == nil {
+		rd, err = withHash(target, rd, nil)
+	} else {
+		rd, err = withHash(target, rd, be.Hasher())
+	}
+	if err != nil {
+		return errors.Wrap(err, "hash")
+	}
+
+	err = target.Save(ctx, h, rd)
+	if err == nil {
+		be.setLocation(h, t)
+	}
+	return err
+}
+
+// Load runs fn with a reader that yields the contents of the file at h at the
+// given offset.
+func (be *Backend) Load(ctx context.Context, h backend.Handle, length int, offset int64, fn func(rd io.Reader) error) error {
+	return be.withFallback(h, func(tbe backend.Backend) error {
+		return tbe.Load(ctx, h, length, offset, fn)
+	})
+}
+
+// Stat returns information about a file in the backend.
+func (be *Backend) Stat(ctx context.Context, h backend.Handle) (backend.FileInfo, error) {
+	var fi backend.FileInfo
+	err := be.withFallback(h, func(tbe backend.Backend) error {
+		var err error
+		fi, err = tbe.Stat(ctx, h)
+		return err
+	})
+	return fi, err
+}
+
+// Remove deletes a file from the backend.
+func (be *Backend) Remove(ctx context.Context, h backend.Handle) error {
+	err := be.withFallback(h, func(tbe backend.Backend) error {
+		return tbe.Remove(ctx, h)
+	})
+	if err == nil {
+		be.setLocation(h, unknownTier)
+	}
+	return err
+}
+
+// List runs fn for each file in the backend which has the type t. Pack files
+// are listed from both backends.
+func (be *Backend) List(ctx context.Context, t backend.FileType, fn func(backend.FileInfo) error) error {
+	if t != backend.PackFile {
+		return be.hot.List(ctx, t, fn)
+	}
+
+	hotPacks := make(map[string]struct{})
+	err := be.hot.List(ctx, t, func(fi backend.FileInfo) error {
+		hotPacks[fi.Name] = struct{}{}
+		be.setLocation(backend.Handle{Type: t, Name: fi.Name}, hotTier)
+		return fn(fi)
+	})
+	if err != nil {
+		return err
+	}
+
+	return be.cold.List(ctx, t, func(fi backend.FileInfo) error {
+		if _, ok := hotPacks[fi.Name]; ok {
+			// a leftover copy of a pack file that also exists in the hot backend
+			debug.Log("pack %v exists in both tiers", fi.Name)
+			return nil
+		}
+		be.setLocation(backend.Handle{Type: t, Name: fi.Name}, coldTier)
+		return fn(fi)
+	})
+}
+
+// IsNotExist returns true if the error was caused by a non-existing file.
+func (be *Backend) IsNotExist(err error) bool {
+	return be.hot.IsNotExist(err) || be.cold.IsNotExist(err)
+}
+
+// IsPermanentError returns true if the error can very likely not be resolved
+// by retrying the operation.
+func (be *Backend) IsPermanentError(err error) bool {
+	return be.hot.IsPermanentError(err) || be.cold.IsPermanentError(err)
+}
+
+// Delete removes all data in both backends.
+func (be *Backend) Delete(ctx context.Context) error {
+	if err := be.cold.Delete(ctx); err != nil {
+		return err
+	}
+	return be.hot.Delete(ctx)
+}
+
+// Close closes both backends.
+func (be *Backend) Close() error {
+	err := be.hot.Close()
+	if cerr := be.cold.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// splitHandles sorts the handles by the tier which stores them.
+func (be *Backend) splitHandles(handles []backend.Handle) (hot, cold []backend.Handle) {
+	for _, h := range handles {
+		if t, _ := be.locate(h); t == coldTier {
+			cold = append(cold, h)
+		} else {
+			hot = append(hot, h)
+		}
+	}
+	return hot, cold
+}
+
+// Warmup forwards the warmup request for each handle to the backend which
+// stores it.
+func (be *Backend) Warmup(ctx context.Context, handles []backend.Handle) ([]backend.Handle, error) {
+	hot, cold := be.splitHandles(handles)
+	var warmingUp []backend.Handle
+	if len(hot) > 0 {
+		hs, err := be.hot.Warmup(ctx, hot)
+		warmingUp = append(warmingUp, hs...)
+		if err != nil {
+			return warmingUp, err
+		}
+	}
+	if len(cold) > 0 {
+		hs, err := be.cold.Warmup(ctx, cold)
+		warmingUp = append(warmingUp, hs...)
+		if err != nil {
+			return warmingUp, err
+		}
+	}
+	return warmingUp, nil
+}
+
+// WarmupWait waits until all given handles are warm.
+func (be *Backend) WarmupWait(ctx context.Context, handles []backend.Handle) error {
+	hot, cold := be.splitHandles(handles)
+	if len(hot) > 0 {
+		if err := be.hot.WarmupWait(ctx, hot); err != nil {
+			return err
+		}
+	}
+	if len(cold) > 0 {
+		return be.cold.WarmupWait(ctx, cold)
+	}
+	return nil
+}
+
+// WarmupEstimate returns the larger warmup estimate of both backends.
+func (be *Backend) WarmupEstimate() time.Duration {
+	var estimate time.Duration
+	for _, b := range []backend.Backend{be.hot, be.cold} {
+		if est := backend.AsBackend[backend.WarmupEstimator](b); est != nil {
+			estimate = max(estimate, est.WarmupEstimate())
+		}
+	}
+	return estimate
+}
+
+// Freeze blocks all backend operations except those on lock files.
+func (be *Backend) Freeze() {
+	for _, b := range []backend.Backend{be.hot, be.cold} {
+		if freeze := backend.AsBackend[backend.FreezeBackend](b); freeze != nil {
+			freeze.Freeze()
+		}
+	}
+}
+
+// Unfreeze allows all backend operations to continue.
+func (be *Backend) Unfreeze() {
+	for _, b := range []backend.Backend{be.cold, be.hot} {
+		if freeze := backend.AsBackend[backend.FreezeBackend](b); freeze != nil {
+			freeze.Unfreeze()
+		}
+	}
+}
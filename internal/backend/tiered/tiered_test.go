@@ -0,0 +1,95 @@
+package tiered_test
+
+import (
+	"context"
+	"io"
+	"sort"
+	"testing"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/backend/mem"
+	"github.com/restic/restic/internal/backend/tiered"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func save(t *testing.T, be backend.Backend, h backend.Handle, data string) {
+	t.Helper()
+	rtest.OK(t, be.Save(context.TODO(), h, backend.NewByteReader([]byte(data), be.Hasher())))
+}
+
+func load(t *testing.T, be backend.Backend, h backend.Handle) string {
+	t.Helper()
+	var data []byte
+	rtest.OK(t, be.Load(context.TODO(), h, 0, 0, func(rd io.Reader) error {
+		var err error
+		data, err = io.ReadAll(rd)
+		return err
+	}))
+	return string(data)
+}
+
+func list(t *testing.T, be backend.Backend, tpe backend.FileType) []string {
+	t.Helper()
+	var names []string
+	rtest.OK(t, be.List(context.TODO(), tpe, func(fi backend.FileInfo) error {
+		names = append(names, fi.Name)
+		return nil
+	}))
+	sort.Strings(names)
+	return names
+}
+
+func TestTieredRouting(t *testing.T) {
+	hot, cold := mem.New(), mem.New()
+	be := tiered.New(hot, cold)
+
+	dataPack := backend.Handle{Type: backend.PackFile, Name: "data"}
+	treePack := backend.Handle{Type: backend.PackFile, Name: "tree", IsMetadata: true}
+	index := backend.Handle{Type: backend.IndexFile, Name: "index"}
+
+	save(t, be, dataPack, "data")
+	save(t, be, treePack, "tree")
+	save(t, be, index, "index")
+
+	rtest.Equals(t, []string{"tree"}, list(t, hot, backend.PackFile))
+	rtest.Equals(t, []string{"data"}, list(t, cold, backend.PackFile))
+	rtest.Equals(t, []string{"index"}, list(t, hot, backend.IndexFile))
+	rtest.Equals(t, []string(nil), list(t, cold, backend.IndexFile))
+
+	rtest.Equals(t, []string{"data", "tree"}, list(t, be, backend.PackFile))
+	rtest.Equals(t, []string{"index"}, list(t, be, backend.IndexFile))
+}
+
+func TestTieredLocateUnknownPacks(t *testing.T) {
+	hot, cold := mem.New(), mem.New()
+	save(t, tiered.New(hot, cold), backend.Handle{Type: backend.PackFile, Name: "data"}, "data")
+	save(t, tiered.New(hot, cold), backend.Handle{Type: backend.PackFile, Name: "tree", IsMetadata: true}, "tree")
+
+	// a fresh backend does not know where the pack files are stored. Stat, Load
+	// and Remove must find them even without the metadata hint.
+	be := tiered.New(hot, cold)
+	for _, name := range []string{"data", "tree"} {
+		h := backend.Handle{Type: backend.PackFile, Name: name}
+		fi, err := be.Stat(context.TODO(), h)
+		rtest.OK(t, err)
+		rtest.Equals(t, int64(len(name)), fi.Size)
+		rtest.Equals(t, name, load(t, be, h))
+	}
+
+	be = tiered.New(hot, cold)
+	rtest.OK(t, be.Remove(context.TODO(), backend.Handle{Type: backend.PackFile, Name: "tree"}))
+	rtest.OK(t, be.Remove(context.TODO(), backend.Handle{Type: backend.PackFile, Name: "data", IsMetadata: true}))
+	rtest.Equals(t, []string(nil), list(t, be, backend.PackFile))
+
+	_, err := be.Stat(context.TODO(), backend.Handle{Type: backend.PackFile, Name: "data"})
+	rtest.Assert(t, be.IsNotExist(err), "expected not found error, got %v", err)
+}
+
+func TestTieredListDuplicates(t *testing.T) {
+	hot, cold := mem.New(), mem.New()
+	h := backend.Handle{Type: backend.PackFile, Name: "pack"}
+	save(t, hot, h, "pack")
+	save(t, cold, h, "pack")
+
+	rtest.Equals(t, []string{"pack"}, list(t, tiered.New(hot, cold), backend.PackFile))
+}
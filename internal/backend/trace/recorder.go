@@ -0,0 +1,189 @@
+package trace
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+)
+
+// Event describes a single backend operation. It is written as one line of
+// JSON to the trace file.
+type Event struct {
+	Time       time.Time `json:"time"`
+	Operation  string    `json:"operation"`
+	Type       string    `json:"type"`
+	Name       string    `json:"name,omitempty"`
+	Offset     int64     `json:"offset,omitempty"`
+	Length     int       `json:"length,omitempty"`
+	Size       int64     `json:"size"`
+	Files      int64     `json:"files,omitempty"`
+	Duration   float64   `json:"duration_seconds"`
+	Retries    int       `json:"retries"`
+	ErrorClass string    `json:"error_class,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// Error classes reported for failed operations.
+const (
+	ErrorNotExist  = "not_exist"
+	ErrorPermanent = "permanent"
+	ErrorCanceled  = "canceled"
+	ErrorTimeout   = "timeout"
+	ErrorTransient = "transient"
+)
+
+// classifyError returns the error class of err. Errors which are neither
+// caused by a missing file, nor are permanent or caused by the context, are
+// considered transient.
+func classifyError(be backend.Backend, err error) string {
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, context.Canceled):
+		return ErrorCanceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrorTimeout
+	case be.IsNotExist(err):
+		return ErrorNotExist
+	case be.IsPermanentError(err):
+		return ErrorPermanent
+	}
+	return ErrorTransient
+}
+
+type metricKey struct {
+	operation string
+	fileType  string
+}
+
+type metric struct {
+	requests uint64
+	retries  uint64
+	bytes    int64
+	duration float64
+	errors   map[string]uint64
+}
+
+// Recorder collects the events of all traced backends. Events are written to
+// the trace writer, if any, and aggregated to metrics, see WriteMetrics.
+type Recorder struct {
+	m       sync.Mutex
+	enc     *json.Encoder
+	metrics map[metricKey]*metric
+	started time.Time
+}
+
+// NewRecorder returns a Recorder which writes events as JSON lines to w. If w
+// is nil, only metrics are collected.
+func NewRecorder(w io.Writer) *Recorder {
+	r := &Recorder{
+		metrics: make(map[metricKey]*metric),
+		started: time.Now(),
+	}
+	if w != nil {
+		r.enc = json.NewEncoder(w)
+	}
+	return r
+}
+
+// Record adds an event.
+func (r *Recorder) Record(ev Event) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.enc != nil {
+		if err := r.enc.Encode(ev); err != nil {
+			debug.Log("writing trace event failed: %v", err)
+		}
+	}
+
+	key := metricKey{operation: ev.Operation, fileType: ev.Type}
+	m, ok := r.metrics[key]
+	if !ok {
+		m = &metric{errors: make(map[string]uint64)}
+		r.metrics[key] = m
+	}
+	m.requests++
+	if ev.Retries > 0 {
+		m.retries++
+	}
+	m.bytes += ev.Size
+	m.duration += ev.Duration
+	if ev.ErrorClass != "" {
+		m.errors[ev.ErrorClass]++
+	}
+}
+
+// WriteMetrics writes the aggregated metrics in the Prometheus text format,
+// which is understood by the textfile collector of the node exporter.
+func (r *Recorder) WriteMetrics(w io.Writer, now time.Time) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	keys := make([]metricKey, 0, len(r.metrics))
+	for key := range r.metrics {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].operation != keys[j].operation {
+			return keys[i].operation < keys[j].operation
+		}
+		return keys[i].fileType < keys[j].fileType
+	})
+
+	var err error
+	printf := func(format string, args ...interface{}) {
+		if err == nil {
+			_, err = fmt.Fprintf(w, format, args...)
+		}
+	}
+	labels := func(key metricKey) string {
+		return fmt.Sprintf("operation=%q,type=%q", key.operation, key.fileType)
+	}
+	counter := func(name, help string, value func(m *metric) string) {
+		printf("# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+		for _, key := range keys {
+			printf("%s{%s} %s\n", name, labels(key), value(r.metrics[key]))
+		}
+	}
+
+	counter("restic_backend_requests_total", "Number of backend requests.", func(m *metric) string {
+		return fmt.Sprint(m.requests)
+	})
+	counter("restic_backend_retries_total", "Number of backend requests which repeated a failed request.", func(m *metric) string {
+		return fmt.Sprint(m.retries)
+	})
+	counter("restic_backend_bytes_total", "Number of bytes transferred.", func(m *metric) string {
+		return fmt.Sprint(m.bytes)
+	})
+	counter("restic_backend_duration_seconds_total", "Time spent in backend requests.", func(m *metric) string {
+		return fmt.Sprint(m.duration)
+	})
+
+	printf("# HELP restic_backend_errors_total Number of failed backend requests.\n# TYPE restic_backend_errors_total counter\n")
+	for _, key := range keys {
+		m := r.metrics[key]
+		classes := make([]string, 0, len(m.errors))
+		for class := range m.errors {
+			classes = append(classes, class)
+		}
+		sort.Strings(classes)
+		for _, class := range classes {
+			printf("restic_backend_errors_total{%s,class=%q} %d\n", labels(key), class, m.errors[class])
+		}
+	}
+
+	printf("# HELP restic_backend_run_duration_seconds Duration of the restic run.\n# TYPE restic_backend_run_duration_seconds gauge\n")
+	printf("restic_backend_run_duration_seconds %v\n", now.Sub(r.started).Seconds())
+	printf("# HELP restic_backend_run_timestamp_seconds Time at which the metrics were written.\n# TYPE restic_backend_run_timestamp_seconds gauge\n")
+	printf("restic_backend_run_timestamp_seconds %d\n", now.Unix())
+	return err
+}
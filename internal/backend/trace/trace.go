@@ -0,0 +1,180 @@
+package trace
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/restic/restic/internal/backend"
+)
+
+// Backend records all operations of the wrapped backend. It should be
+// wrapped in the retry backend, such that each attempt is recorded.
+type Backend struct {
+	backend.Backend
+	rec *Recorder
+
+	m sync.Mutex
+	// failures counts the failed attempts of an operation which is still
+	// being retried.
+	failures map[string]int
+}
+
+// statically ensure that Backend implements backend.Backend.
+var _ backend.Backend = &Backend{}
+
+// New returns a backend which records all operations using rec.
+func New(be backend.Backend, rec *Recorder) *Backend {
+	return &Backend{
+		Backend:  be,
+		rec:      rec,
+		failures: make(map[string]int),
+	}
+}
+
+func fileType(h backend.Handle) string {
+	if h.Type == backend.PackFile && h.IsMetadata {
+		return "tree"
+	}
+	return h.Type.String()
+}
+
+func operationKey(operation, fileType, name string, offset int64, length int) string {
+	return fmt.Sprintf("%s %s/%s %d %d", operation, fileType, name, offset, length)
+}
+
+// record reports an attempt of an operation. Retries counts the previous
+// failed attempts of the same operation.
+func (be *Backend) record(ev Event, start time.Time, err error) {
+	key := operationKey(ev.Operation, ev.Type, ev.Name, ev.Offset, ev.Length)
+	be.m.Lock()
+	ev.Retries = be.failures[key]
+	if err != nil {
+		be.failures[key]++
+	} else {
+		delete(be.failures, key)
+	}
+	be.m.Unlock()
+
+	ev.Time = start
+	ev.Duration = time.Since(start).Seconds()
+	if err != nil {
+		ev.ErrorClass = classifyError(be.Backend, err)
+		ev.Error = err.Error()
+	}
+	be.rec.Record(ev)
+}
+
+// Save adds new Data to the backend.
+func (be *Backend) Save(ctx context.Context, h backend.Handle, rd backend.RewindReader) error {
+	start := time.Now()
+	err := be.Backend.Save(ctx, h, rd)
+	be.record(Event{Operation: "save", Type: fileType(h), Name: h.Name, Size: rd.Length()}, start, err)
+	return err
+}
+
+// Remove deletes a file from the backend.
+func (be *Backend) Remove(ctx context.Context, h backend.Handle) error {
+	start := time.Now()
+	err := be.Backend.Remove(ctx, h)
+	be.record(Event{Operation: "remove", Type: fileType(h), Name: h.Name}, start, err)
+	return err
+}
+
+// countingReader counts the bytes read from the underlying reader.
+type countingReader struct {
+	io.Reader
+	n int64
+}
+
+func (rd *countingReader) Read(p []byte) (int, error) {
+	n, err := rd.Reader.Read(p)
+	rd.n += int64(n)
+	return n, err
+}
+
+// Load runs fn with a reader that yields the contents of the file at h at the
+// given offset.
+func (be *Backend) Load(ctx context.Context, h backend.Handle, length int, offset int64, fn func(io.Reader) error) error {
+	start := time.Now()
+	var size int64
+	err := be.Backend.Load(ctx, h, length, offset, func(rd io.Reader) error {
+		crd := &countingReader{Reader: rd}
+		err := fn(crd)
+		size += crd.n
+		return err
+	})
+	be.record(Event{Operation: "load", Type: fileType(h), Name: h.Name, Offset: offset, Length: length, Size: size}, start, err)
+	return err
+}
+
+// Stat returns information about the File identified by h.
+func (be *Backend) Stat(ctx context.Context, h backend.Handle) (backend.FileInfo, error) {
+	start := time.Now()
+	fi, err := be.Backend.Stat(ctx, h)
+	be.record(Event{Operation: "stat", Type: fileType(h), Name: h.Name}, start, err)
+	return fi, err
+}
+
+// List runs fn for each file in the backend which has the type t.
+func (be *Backend) List(ctx context.Context, t backend.FileType, fn func(backend.FileInfo) error) error {
+	start := time.Now()
+	var count int64
+	err := be.Backend.List(ctx, t, func(fi backend.FileInfo) error {
+		count++
+		return fn(fi)
+	})
+	be.record(Event{Operation: "list", Type: t.String(), Files: count}, start, err)
+	return err
+}
+
+func (be *Backend) Unwrap() backend.Backend { return be.Backend }
+
+// finish forgets the failed attempts of an operation.
+func (be *Backend) finish(key string) {
+	be.m.Lock()
+	delete(be.failures, key)
+	be.m.Unlock()
+}
+
+// WrapRetries returns a backend that wraps outer, which must contain the retry
+// backend wrapping be. Once an operation of outer returns, including when the
+// retry backend gives up, later attempts of the same operation are no longer
+// counted as retries.
+func (be *Backend) WrapRetries(outer backend.Backend) backend.Backend {
+	return &retriesBackend{Backend: outer, traced: be}
+}
+
+type retriesBackend struct {
+	backend.Backend
+	traced *Backend
+}
+
+func (be *retriesBackend) Save(ctx context.Context, h backend.Handle, rd backend.RewindReader) error {
+	defer be.traced.finish(operationKey("save", fileType(h), h.Name, 0, 0))
+	return be.Backend.Save(ctx, h, rd)
+}
+
+func (be *retriesBackend) Remove(ctx context.Context, h backend.Handle) error {
+	defer be.traced.finish(operationKey("remove", fileType(h), h.Name, 0, 0))
+	return be.Backend.Remove(ctx, h)
+}
+
+func (be *retriesBackend) Load(ctx context.Context, h backend.Handle, length int, offset int64, fn func(io.Reader) error) error {
+	defer be.traced.finish(operationKey("load", fileType(h), h.Name, offset, length))
+	return be.Backend.Load(ctx, h, length, offset, fn)
+}
+
+func (be *retriesBackend) Stat(ctx context.Context, h backend.Handle) (backend.FileInfo, error) {
+	defer be.traced.finish(operationKey("stat", fileType(h), h.Name, 0, 0))
+	return be.Backend.Stat(ctx, h)
+}
+
+func (be *retriesBackend) List(ctx context.Context, t backend.FileType, fn func(backend.FileInfo) error) error {
+	defer be.traced.finish(operationKey("list", t.String(), "", 0, 0))
+	return be.Backend.List(ctx, t, fn)
+}
+
+func (be *retriesBackend) Unwrap() backend.Backend { return be.Backend }
@@ -0,0 +1,130 @@
+package trace_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/backend/mem"
+	"github.com/restic/restic/internal/backend/mock"
+	"github.com/restic/restic/internal/backend/trace"
+	"github.com/restic/restic/internal/errors"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func readEvents(t *testing.T, buf *bytes.Buffer) []trace.Event {
+	var events []trace.Event
+	sc := bufio.NewScanner(buf)
+	for sc.Scan() {
+		var ev trace.Event
+		rtest.OK(t, json.Unmarshal(sc.Bytes(), &ev))
+		events = append(events, ev)
+	}
+	rtest.OK(t, sc.Err())
+	return events
+}
+
+func TestTraceEvents(t *testing.T) {
+	buf := &bytes.Buffer{}
+	rec := trace.NewRecorder(buf)
+	be := trace.New(mem.New(), rec)
+	ctx := context.TODO()
+
+	h := backend.Handle{Type: backend.PackFile, Name: "foo", IsMetadata: true}
+	rtest.OK(t, be.Save(ctx, h, backend.NewByteReader([]byte("foobar"), be.Hasher())))
+	rtest.OK(t, be.Load(ctx, h, 3, 1, func(rd io.Reader) error {
+		_, err := io.ReadAll(rd)
+		return err
+	}))
+	rtest.OK(t, be.List(ctx, backend.PackFile, func(backend.FileInfo) error { return nil }))
+	_, err := be.Stat(ctx, backend.Handle{Type: backend.SnapshotFile, Name: "missing"})
+	rtest.Assert(t, be.IsNotExist(err), "expected not found error, got %v", err)
+
+	events := readEvents(t, buf)
+	rtest.Equals(t, 4, len(events))
+
+	rtest.Equals(t, "save", events[0].Operation)
+	rtest.Equals(t, "tree", events[0].Type)
+	rtest.Equals(t, "foo", events[0].Name)
+	rtest.Equals(t, int64(6), events[0].Size)
+
+	rtest.Equals(t, "load", events[1].Operation)
+	rtest.Equals(t, int64(1), events[1].Offset)
+	rtest.Equals(t, 3, events[1].Length)
+	rtest.Equals(t, int64(3), events[1].Size)
+
+	rtest.Equals(t, "list", events[2].Operation)
+	rtest.Equals(t, "data", events[2].Type)
+	rtest.Equals(t, int64(1), events[2].Files)
+
+	rtest.Equals(t, "stat", events[3].Operation)
+	rtest.Equals(t, trace.ErrorNotExist, events[3].ErrorClass)
+}
+
+func TestTraceRetries(t *testing.T) {
+	failures := 2
+	m := mock.NewBackend()
+	m.RemoveFn = func(ctx context.Context, h backend.Handle) error {
+		if failures > 0 {
+			failures--
+			return errors.New("transient failure")
+		}
+		return nil
+	}
+
+	buf := &bytes.Buffer{}
+	rec := trace.NewRecorder(buf)
+	be := trace.New(m, rec)
+	h := backend.Handle{Type: backend.LockFile, Name: "lock"}
+	for i := 0; i < 3; i++ {
+		_ = be.Remove(context.TODO(), h)
+	}
+
+	events := readEvents(t, buf)
+	rtest.Equals(t, 3, len(events))
+	for i, ev := range events {
+		rtest.Equals(t, i, ev.Retries)
+	}
+	rtest.Equals(t, trace.ErrorTransient, events[0].ErrorClass)
+	rtest.Equals(t, "", events[2].ErrorClass)
+
+	metrics := &bytes.Buffer{}
+	rtest.OK(t, rec.WriteMetrics(metrics, time.Unix(1700000000, 0)))
+	out := metrics.String()
+	for _, line := range []string{
+		`restic_backend_requests_total{operation="remove",type="lock"} 3`,
+		`restic_backend_retries_total{operation="remove",type="lock"} 2`,
+		`restic_backend_errors_total{operation="remove",type="lock",class="transient"} 2`,
+		`restic_backend_run_timestamp_seconds 1700000000`,
+	} {
+		rtest.Assert(t, strings.Contains(out, line+"\n"), "metrics output is missing %q:\n%s", line, out)
+	}
+}
+
+func TestTraceRetriesGiveUp(t *testing.T) {
+	m := mock.NewBackend()
+	m.RemoveFn = func(ctx context.Context, h backend.Handle) error {
+		return errors.New("transient failure")
+	}
+
+	buf := &bytes.Buffer{}
+	traced := trace.New(m, trace.NewRecorder(buf))
+	// without a retry backend, each operation gives up after the first attempt
+	be := traced.WrapRetries(traced)
+	h := backend.Handle{Type: backend.LockFile, Name: "lock"}
+	for i := 0; i < 2; i++ {
+		rtest.Assert(t, be.Remove(context.TODO(), h) != nil, "expected error")
+	}
+
+	events := readEvents(t, buf)
+	rtest.Equals(t, 2, len(events))
+	for _, ev := range events {
+		rtest.Equals(t, 0, ev.Retries)
+	}
+}
@@ -31,7 +31,10 @@ func DefaultDelete(ctx context.Context, be backend.Backend) error {
 		backend.KeyFile,
 		backend.LockFile,
 		backend.SnapshotFile,
-		backend.IndexFile}
+		backend.IndexFile,
+		backend.ParityFile,
+		backend.SnapshotLogFile,
+		backend.RekeyFile}
 
 	for _, t := range alltypes {
 		err := be.List(ctx, t, func(fi backend.FileInfo) error {
@@ -0,0 +1,24 @@
+package backend
+
+import (
+	"time"
+
+	"github.com/restic/restic/internal/feature"
+)
+
+// WarmupEnabled returns whether files may be warmed up from cold storage
+// before they are read. This is the case if either the generic
+// cold-storage-restore or the older s3-restore feature flag is enabled.
+func WarmupEnabled() bool {
+	return feature.Flag.Enabled(feature.ColdStorageRestore) || feature.Flag.Enabled(feature.S3Restore)
+}
+
+// WarmupEstimator is implemented by backends that can estimate how long it
+// takes until a warmup requested via Warmup completes.
+type WarmupEstimator interface {
+	Backend
+
+	// WarmupEstimate returns an upper bound for the time it takes to warm up
+	// files that are currently in cold storage.
+	WarmupEstimate() time.Duration
+}
@@ -60,6 +60,11 @@ func (c *Checker) LoadSnapshots(ctx context.Context, snapshotFilter *data.Snapsh
 	return err
 }
 
+// Snapshots returns the list of snapshots loaded by LoadSnapshots.
+func (c *Checker) Snapshots() restic.Lister {
+	return c.snapshots
+}
+
 // IsFiltered returns true if snapshot filtering is active
 func (c *Checker) IsFiltered() bool {
 	return len(c.args) != 0 || !c.snapshotFilter.Empty()
@@ -0,0 +1,50 @@
+package data
+
+import (
+	"slices"
+	"strings"
+	"time"
+)
+
+// CheckpointTag marks snapshots which contain the part of a backup that was
+// completed when the checkpoint was created. Checkpoint snapshots are
+// obsolete once a complete snapshot of the same host and paths exists.
+const CheckpointTag = "restic-checkpoint"
+
+// IsCheckpoint returns true if the snapshot is a checkpoint of a backup.
+func (sn *Snapshot) IsCheckpoint() bool {
+	return sn.hasTag(CheckpointTag)
+}
+
+// checkpointKey identifies the backups a checkpoint belongs to.
+func checkpointKey(sn *Snapshot) string {
+	paths := slices.Clone(sn.Paths)
+	slices.Sort(paths)
+	return sn.Hostname + "\x00" + strings.Join(paths, "\x00")
+}
+
+// ObsoleteCheckpoints returns the checkpoint snapshots for which a newer
+// complete snapshot of the same host and paths exists.
+func ObsoleteCheckpoints(snapshots Snapshots) Snapshots {
+	latest := make(map[string]time.Time)
+	for _, sn := range snapshots {
+		if sn.IsCheckpoint() {
+			continue
+		}
+		key := checkpointKey(sn)
+		if t, ok := latest[key]; !ok || sn.Time.After(t) {
+			latest[key] = sn.Time
+		}
+	}
+
+	var obsolete Snapshots
+	for _, sn := range snapshots {
+		if !sn.IsCheckpoint() {
+			continue
+		}
+		if t, ok := latest[checkpointKey(sn)]; ok && !t.Before(sn.Time) {
+			obsolete = append(obsolete, sn)
+		}
+	}
+	return obsolete
+}
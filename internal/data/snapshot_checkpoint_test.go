@@ -0,0 +1,37 @@
+package data_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/data"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestObsoleteCheckpoints(t *testing.T) {
+	base := time.Date(2024, 3, 4, 5, 6, 7, 0, time.UTC)
+	snapshot := func(hours int, host string, checkpoint bool, paths ...string) *data.Snapshot {
+		sn := &data.Snapshot{Time: base.Add(time.Duration(hours) * time.Hour), Hostname: host, Paths: paths}
+		if checkpoint {
+			sn.Tags = []string{"foo", data.CheckpointTag}
+		}
+		return sn
+	}
+
+	superseded := snapshot(1, "host", true, "/home", "/etc")
+	running := snapshot(3, "host", true, "/home", "/etc")
+	otherHost := snapshot(1, "other", true, "/home", "/etc")
+	otherPaths := snapshot(1, "host", true, "/home")
+	snapshots := data.Snapshots{
+		snapshot(0, "host", false, "/etc", "/home"),
+		superseded,
+		snapshot(2, "host", false, "/etc", "/home"),
+		running,
+		otherHost,
+		otherPaths,
+	}
+
+	rtest.Assert(t, superseded.IsCheckpoint(), "checkpoint not detected")
+	rtest.Assert(t, !snapshots[0].IsCheckpoint(), "regular snapshot detected as checkpoint")
+	rtest.Equals(t, data.Snapshots{superseded}, data.ObsoleteCheckpoints(snapshots))
+}
@@ -23,6 +23,9 @@ type SnapshotFilter struct {
 	Paths []string
 	// Match snapshots from before this timestamp. Zero for no limit.
 	TimestampLimit time.Time
+	// IncludeCheckpoints allows "latest" to select a checkpoint snapshot, see
+	// Snapshot.IsCheckpoint.
+	IncludeCheckpoints bool
 }
 
 func (f *SnapshotFilter) Empty() bool {
@@ -61,6 +64,10 @@ func (f *SnapshotFilter) findLatest(ctx context.Context, be restic.Lister, loade
 			return nil
 		}
 
+		if snapshot.IsCheckpoint() && !f.IncludeCheckpoints {
+			return nil
+		}
+
 		if latest != nil && snapshot.Time.Before(latest.Time) {
 			return nil
 		}
@@ -45,6 +45,25 @@ func TestFindLatestSnapshotWithMaxTimestamp(t *testing.T) {
 	}
 }
 
+func TestFindLatestSkipsCheckpoints(t *testing.T) {
+	repo := repository.TestRepository(t)
+	complete := data.TestCreateSnapshot(t, repo, parseTimeUTC("2017-07-07 07:07:07"), 1)
+
+	checkpoint, err := data.NewSnapshot([]string{"/foo"}, []string{data.CheckpointTag}, "foo", parseTimeUTC("2019-09-09 09:09:09"))
+	test.OK(t, err)
+	checkpoint.Tree = complete.Tree
+	checkpointID, err := data.SaveSnapshot(context.TODO(), repo, checkpoint)
+	test.OK(t, err)
+
+	sn, _, err := (&data.SnapshotFilter{}).FindLatest(context.TODO(), repo, repo, "latest")
+	test.OK(t, err)
+	test.Equals(t, *complete.ID(), *sn.ID())
+
+	sn, _, err = (&data.SnapshotFilter{IncludeCheckpoints: true}).FindLatest(context.TODO(), repo, repo, "latest")
+	test.OK(t, err)
+	test.Equals(t, checkpointID, *sn.ID())
+}
+
 func TestFindLatestWithSubpath(t *testing.T) {
 	repo := repository.TestRepository(t)
 	data.TestCreateSnapshot(t, repo, parseTimeUTC("2015-05-05 05:05:05"), 1)
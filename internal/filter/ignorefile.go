@@ -0,0 +1,138 @@
+package filter
+
+import (
+	"bufio"
+	"bytes"
+	"path/filepath"
+	"strings"
+
+	"github.com/restic/restic/internal/debug"
+)
+
+// IgnoreFile contains the rules of an ignore file such as .gitignore. The
+// rules use gitignore semantics and are evaluated relative to the directory
+// containing the ignore file.
+type IgnoreFile struct {
+	dir   string
+	rules []ignoreRule
+}
+
+type ignoreRule struct {
+	pattern Pattern
+	negate  bool
+	dirOnly bool
+}
+
+// ParseIgnoreFile parses the content of an ignore file located in dir. Lines
+// containing invalid patterns are skipped and reported in the returned
+// InvalidPatternError, the remaining rules are still usable.
+//
+// The following subset of the gitignore syntax is supported:
+//   - empty lines and lines starting with "#" are ignored
+//   - a leading "!" re-includes previously excluded items
+//   - a trailing "/" only matches directories
+//   - patterns containing a "/" are anchored to dir, other patterns match
+//     items in dir and all its subdirectories
+//   - "*", "?", "[...]" and "**" wildcards
+//   - "\#", "\!" and "\ " escape special characters
+func ParseIgnoreFile(dir string, data []byte) (*IgnoreFile, error) {
+	f := &IgnoreFile{dir: dir}
+	var invalid []string
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		line = trimTrailingSpaces(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		rule, ok := parseIgnoreRule(line)
+		if !ok {
+			continue
+		}
+		if err := ValidatePatterns([]string{rule.pattern.original}); err != nil {
+			invalid = append(invalid, line)
+			continue
+		}
+		f.rules = append(f.rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(invalid) > 0 {
+		return f, &InvalidPatternError{InvalidPatterns: invalid}
+	}
+	return f, nil
+}
+
+// trimTrailingSpaces removes trailing spaces unless they are escaped.
+func trimTrailingSpaces(line string) string {
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+		line = line[:len(line)-1]
+	}
+	return line
+}
+
+func parseIgnoreRule(line string) (ignoreRule, bool) {
+	var rule ignoreRule
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return ignoreRule{}, false
+	}
+
+	// a slash at the beginning or in the middle anchors the pattern to the
+	// directory of the ignore file
+	if strings.Contains(line, "/") {
+		line = "/" + strings.TrimLeft(line, "/")
+	}
+
+	rule.pattern = preparePattern(line)
+	return rule, true
+}
+
+// Dir returns the directory containing the ignore file.
+func (f *IgnoreFile) Dir() string {
+	return f.dir
+}
+
+// Match reports whether one of the rules matches item. If so, excluded
+// reports whether the last matching rule excludes item. The item must be
+// located below the directory of the ignore file.
+func (f *IgnoreFile) Match(item string, isDir bool) (matched, excluded bool) {
+	rel, err := filepath.Rel(f.dir, item)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return false, false
+	}
+	strs, err := prepareStr("/" + filepath.ToSlash(rel))
+	if err != nil {
+		return false, false
+	}
+
+	for i := len(f.rules) - 1; i >= 0; i-- {
+		rule := f.rules[i]
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		m, err := match(rule.pattern, strs)
+		if err != nil {
+			debug.Log("error matching %q: %v", rule.pattern.original, err)
+			continue
+		}
+		if m {
+			return true, !rule.negate
+		}
+	}
+	return false, false
+}
@@ -0,0 +1,73 @@
+package filter_test
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/restic/restic/internal/filter"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestIgnoreFile(t *testing.T) {
+	data := []byte(`# comment
+*.log
+!important.log
+/build/
+docs/*.tmp
+cache/
+\#literal
+trailing   
+**/deep/file
+`)
+	dir := filepath.FromSlash("/home/user/project")
+	f, err := filter.ParseIgnoreFile(dir, data)
+	rtest.OK(t, err)
+
+	var tests = []struct {
+		path     string
+		isDir    bool
+		matched  bool
+		excluded bool
+	}{
+		{"app.log", false, true, true},
+		{"sub/dir/app.log", false, true, true},
+		{"important.log", false, true, false},
+		{"sub/important.log", false, true, false},
+		{"build", true, true, true},
+		{"build", false, false, false},
+		{"sub/build", true, false, false},
+		{"docs/a.tmp", false, true, true},
+		{"sub/docs/a.tmp", false, false, false},
+		{"cache", true, true, true},
+		{"sub/cache", true, true, true},
+		{"cache", false, false, false},
+		{"#literal", false, true, true},
+		{"trailing", false, true, true},
+		{"a/b/deep/file", false, true, true},
+		{"deep/file", false, true, true},
+		{"main.go", false, false, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			matched, excluded := f.Match(filepath.Join(dir, filepath.FromSlash(test.path)), test.isDir)
+			rtest.Equals(t, test.matched, matched)
+			rtest.Equals(t, test.excluded, excluded)
+		})
+	}
+
+	// items outside of the directory are never matched
+	matched, _ := f.Match(filepath.FromSlash("/home/user/app.log"), false)
+	rtest.Assert(t, !matched, "item outside of the ignore file directory matched")
+}
+
+func TestIgnoreFileInvalidPattern(t *testing.T) {
+	f, err := filter.ParseIgnoreFile("/", []byte("*.log\n[invalid\n"))
+	var invalid *filter.InvalidPatternError
+	rtest.Assert(t, errors.As(err, &invalid), "expected InvalidPatternError, got %v", err)
+	rtest.Equals(t, []string{"[invalid"}, invalid.InvalidPatterns)
+
+	matched, excluded := f.Match(filepath.FromSlash("/foo.log"), false)
+	rtest.Assert(t, matched && excluded, "valid rule was not used")
+}
@@ -0,0 +1,649 @@
+package fs
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/restic/restic/internal/data"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+)
+
+// ArchiveFS is a read-only FS which provides the content of a tar or zip
+// archive below a single root directory. All paths are absolute and use "/"
+// as separator.
+type ArchiveFS struct {
+	rootDir string
+	items   map[string]*archiveItem
+	// links contains the items of each hardlinked inode.
+	links map[uint64][]*archiveItem
+	// spool stores the content of files which cannot be read from the
+	// archive directly, for example when the archive is read from a stream.
+	spool *os.File
+	// archive is the archive file, if it was opened by OpenArchive.
+	archive *os.File
+
+	modTime   time.Time
+	nextInode uint64
+}
+
+// statically ensure that ArchiveFS implements FS.
+var _ FS = &ArchiveFS{}
+
+type archiveItem struct {
+	fi   *ExtendedFileInfo
+	node data.Node
+	open func() (io.ReadCloser, error)
+
+	children []string
+}
+
+// ArchiveOptions configures the archive FS.
+type ArchiveOptions struct {
+	// Root is the directory which contains the archive entries.
+	Root string
+	// ModTime is used for directories which are not contained in the
+	// archive, such as the root directory.
+	ModTime time.Time
+}
+
+// archiveDeviceID is the device ID used for all archive entries. Hardlinks
+// are represented by entries which share the same inode number.
+const archiveDeviceID = 1
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zipMagic  = []byte("PK\x03\x04")
+)
+
+func newArchiveFS(opts ArchiveOptions) (*ArchiveFS, error) {
+	root := readerCleanPath(opts.Root)
+	if root == "/" {
+		return nil, fmt.Errorf("invalid root directory specified")
+	}
+
+	fs := &ArchiveFS{
+		rootDir: root,
+		items:   make(map[string]*archiveItem),
+		links:   make(map[uint64][]*archiveItem),
+		modTime: opts.ModTime,
+	}
+	fs.dir(root)
+	return fs, nil
+}
+
+// NewTarStream returns an FS which contains the entries of the tar archive
+// read from rd. The archive may be compressed using gzip. As the archiver
+// reads files in a different order than stored in the stream, the content of
+// all files is stored in a temporary file, which is removed by Close.
+func NewTarStream(rd io.Reader, opts ArchiveOptions) (*ArchiveFS, error) {
+	fs, err := newArchiveFS(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReader(rd)
+	var src io.Reader = br
+	if magic, _ := br.Peek(len(gzipMagic)); bytes.Equal(magic, gzipMagic) {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, errors.Wrap(err, "gzip")
+		}
+		src = gz
+	}
+
+	err = fs.readTar(tar.NewReader(src), nil)
+	if err == nil {
+		// read the remaining padding, such that errors of the source (for
+		// example a failed command) are detected
+		_, err = io.Copy(io.Discard, src)
+	}
+	if err != nil {
+		_ = fs.Close()
+		return nil, err
+	}
+	return fs, nil
+}
+
+// OpenArchive returns an FS which contains the entries of the tar or zip
+// archive stored in filename. Uncompressed tar and zip archives are read
+// directly from the file, the content of gzip-compressed tar archives is
+// stored in a temporary file like for NewTarStream. The FS must be closed
+// after use.
+func OpenArchive(filename string, opts ArchiveOptions) (*ArchiveFS, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	magic := make([]byte, len(zipMagic))
+	n, err := f.ReadAt(magic, 0)
+	if err != nil && err != io.EOF {
+		_ = f.Close()
+		return nil, err
+	}
+	magic = magic[:n]
+
+	var fs *ArchiveFS
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		fs, err = NewTarStream(f, opts)
+		_ = f.Close()
+		if err != nil {
+			return nil, errors.Wrapf(err, "read archive %v", filename)
+		}
+		return fs, nil
+
+	case bytes.HasPrefix(magic, zipMagic):
+		fs, err = newArchiveFS(opts)
+		if err == nil {
+			fs.archive = f
+			err = fs.readZip(f, fi.Size())
+		}
+
+	default:
+		fs, err = newArchiveFS(opts)
+		if err == nil {
+			fs.archive = f
+			rd := io.NewSectionReader(f, 0, fi.Size())
+			err = fs.readTar(tar.NewReader(rd), rd)
+		}
+	}
+
+	if err != nil {
+		if fs != nil {
+			_ = fs.Close()
+		} else {
+			_ = f.Close()
+		}
+		return nil, errors.Wrapf(err, "read archive %v", filename)
+	}
+	return fs, nil
+}
+
+// Close removes the temporary file and closes the archive.
+func (fs *ArchiveFS) Close() error {
+	var firstErr error
+	if fs.spool != nil {
+		firstErr = fs.spool.Close()
+		if err := os.Remove(fs.spool.Name()); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		fs.spool = nil
+	}
+	if fs.archive != nil {
+		if err := fs.archive.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		fs.archive = nil
+	}
+	return firstErr
+}
+
+// dir returns the directory item for name, creating it and all parent
+// directories if necessary.
+func (fs *ArchiveFS) dir(name string) *archiveItem {
+	if item, ok := fs.items[name]; ok {
+		return item
+	}
+
+	fi := &ExtendedFileInfo{
+		Name:    path.Base(name),
+		Mode:    os.ModeDir | 0755,
+		ModTime: fs.modTime,
+	}
+	item := &archiveItem{fi: fi}
+	fs.fillNode(item, uint32(os.Getuid()), uint32(os.Getgid()))
+	fs.items[name] = item
+
+	if parent := path.Dir(name); parent != name {
+		p := fs.dir(parent)
+		p.children = append(p.children, path.Base(name))
+	}
+	return item
+}
+
+// fillNode sets the inode number and all node attributes derived from fi.
+func (fs *ArchiveFS) fillNode(item *archiveItem, uid, gid uint32) {
+	fs.nextInode++
+	fi := item.fi
+	fi.UID = uid
+	fi.GID = gid
+	fi.Inode = fs.nextInode
+	fi.DeviceID = archiveDeviceID
+	fi.Links = 1
+	if fi.AccessTime.IsZero() {
+		fi.AccessTime = fi.ModTime
+	}
+	if fi.ChangeTime.IsZero() {
+		fi.ChangeTime = fi.ModTime
+	}
+
+	node := buildBasicNode("", fi)
+	node.UID = uid
+	node.GID = gid
+	node.AccessTime = fi.AccessTime
+	node.ChangeTime = fi.ChangeTime
+	node.Inode = fi.Inode
+	node.DeviceID = fi.DeviceID
+	node.Device = fi.Device
+	if node.Type != data.NodeTypeDir {
+		node.Links = 1
+	}
+	item.node = *node
+}
+
+// add stores item at name, replacing a previous entry with the same name.
+// The children of a replaced directory are kept if item is a directory.
+func (fs *ArchiveFS) add(name string, item *archiveItem) {
+	if old, ok := fs.items[name]; ok {
+		if old.fi.Mode.IsDir() && item.fi.Mode.IsDir() {
+			item.children = old.children
+		}
+		fs.items[name] = item
+		return
+	}
+
+	fs.items[name] = item
+	p := fs.dir(path.Dir(name))
+	p.children = append(p.children, path.Base(name))
+}
+
+// entryPath returns the path of an archive entry within the FS.
+func (fs *ArchiveFS) entryPath(root, name string) string {
+	return path.Join(root, readerCleanPath(name))
+}
+
+// spoolFile copies the content of rd to the temporary file and returns a
+// function which opens the stored content.
+func (fs *ArchiveFS) spoolFile(rd io.Reader, size int64) (func() (io.ReadCloser, error), error) {
+	if fs.spool == nil {
+		f, err := os.CreateTemp("", "restic-archive-")
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		fs.spool = f
+	}
+
+	offset, err := fs.spool.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	n, err := io.Copy(fs.spool, rd)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if n != size {
+		return nil, errors.Errorf("short read: got %d bytes, expected %d", n, size)
+	}
+
+	spool := fs.spool
+	return sectionOpener(spool, offset, size), nil
+}
+
+func sectionOpener(ra io.ReaderAt, offset, size int64) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		return io.NopCloser(io.NewSectionReader(ra, offset, size)), nil
+	}
+}
+
+func isSparse(hdr *tar.Header) bool {
+	if hdr.Typeflag == tar.TypeGNUSparse {
+		return true
+	}
+	for key := range hdr.PAXRecords {
+		if strings.HasPrefix(key, "GNU.sparse.") {
+			return true
+		}
+	}
+	return false
+}
+
+// readTar reads all entries from tr. If rs is not nil, it must be the
+// underlying reader of tr and the content of regular files is read from it
+// directly instead of being copied to the temporary file.
+func (fs *ArchiveFS) readTar(tr *tar.Reader, rs io.ReadSeeker) error {
+	root := fs.rootDir
+	var ra io.ReaderAt
+	if rs != nil {
+		ra, _ = rs.(io.ReaderAt)
+	}
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrap(err, "tar")
+		}
+
+		name := fs.entryPath(root, hdr.Name)
+		if name == root {
+			// metadata for the root directory
+			if hdr.Typeflag == tar.TypeDir {
+				item := fs.tarItem(name, hdr)
+				item.children = fs.items[root].children
+				fs.items[root] = item
+			}
+			continue
+		}
+		debug.Log("tar entry %v, type %c, size %d", name, hdr.Typeflag, hdr.Size)
+
+		switch hdr.Typeflag {
+		case tar.TypeLink:
+			target := fs.entryPath(root, hdr.Linkname)
+			orig, ok := fs.items[target]
+			if !ok || orig.fi.Mode.IsDir() {
+				return errors.Errorf("hardlink %v refers to invalid target %v", hdr.Name, hdr.Linkname)
+			}
+			item := fs.hardlink(orig)
+			item.fi.Name = path.Base(name)
+			fs.add(name, item)
+			continue
+
+		case tar.TypeXGlobalHeader:
+			continue
+		}
+
+		item := fs.tarItem(name, hdr)
+		if item.fi.Mode.IsRegular() {
+			if ra != nil && !isSparse(hdr) {
+				offset, err := rs.Seek(0, io.SeekCurrent)
+				if err != nil {
+					return errors.WithStack(err)
+				}
+				item.open = sectionOpener(ra, offset, hdr.Size)
+			} else {
+				item.open, err = fs.spoolFile(tr, hdr.Size)
+				if err != nil {
+					return errors.Wrapf(err, "read %v", hdr.Name)
+				}
+			}
+		}
+		fs.add(name, item)
+	}
+	return nil
+}
+
+// tarItem returns the item for a tar header stored at name.
+func (fs *ArchiveFS) tarItem(name string, hdr *tar.Header) *archiveItem {
+	fi := &ExtendedFileInfo{
+		Name:       path.Base(name),
+		Mode:       hdr.FileInfo().Mode(),
+		Size:       hdr.Size,
+		ModTime:    hdr.ModTime,
+		AccessTime: hdr.AccessTime,
+		ChangeTime: hdr.ChangeTime,
+	}
+	if hdr.Typeflag == tar.TypeChar || hdr.Typeflag == tar.TypeBlock {
+		fi.Device = mkdev(uint32(hdr.Devmajor), uint32(hdr.Devminor))
+	}
+	if !fi.Mode.IsRegular() {
+		fi.Size = 0
+	}
+
+	item := &archiveItem{fi: fi}
+	fs.fillNode(item, uint32(hdr.Uid), uint32(hdr.Gid))
+	item.node.User = hdr.Uname
+	item.node.Group = hdr.Gname
+	if hdr.Typeflag == tar.TypeSymlink {
+		item.node.LinkTarget = hdr.Linkname
+	}
+
+	const xattrPrefix = "SCHILY.xattr."
+	for key, value := range hdr.PAXRecords {
+		if name, ok := strings.CutPrefix(key, xattrPrefix); ok {
+			item.node.ExtendedAttributes = append(item.node.ExtendedAttributes, data.ExtendedAttribute{
+				Name:  name,
+				Value: []byte(value),
+			})
+		}
+	}
+	sort.Slice(item.node.ExtendedAttributes, func(i, j int) bool {
+		return item.node.ExtendedAttributes[i].Name < item.node.ExtendedAttributes[j].Name
+	})
+	return item
+}
+
+// hardlink returns a new item which shares the inode with orig and increments
+// the link count of all items with that inode.
+func (fs *ArchiveFS) hardlink(orig *archiveItem) *archiveItem {
+	group := fs.links[orig.fi.Inode]
+	if group == nil {
+		group = []*archiveItem{orig}
+	}
+
+	fi := *orig.fi
+	item := &archiveItem{
+		fi:   &fi,
+		node: orig.node,
+		open: orig.open,
+	}
+	item.node.ExtendedAttributes = slices.Clone(orig.node.ExtendedAttributes)
+	group = append(group, item)
+	fs.links[orig.fi.Inode] = group
+
+	for _, other := range group {
+		other.fi.Links = uint64(len(group))
+	}
+	return item
+}
+
+// readZip reads all entries of a zip archive.
+func (fs *ArchiveFS) readZip(ra io.ReaderAt, size int64) error {
+	zr, err := zip.NewReader(ra, size)
+	if err != nil {
+		return errors.Wrap(err, "zip")
+	}
+
+	root := fs.rootDir
+	for _, f := range zr.File {
+		name := fs.entryPath(root, f.Name)
+		if name == root {
+			continue
+		}
+		debug.Log("zip entry %v, size %d", name, f.UncompressedSize64)
+
+		fi := &ExtendedFileInfo{
+			Name:    path.Base(name),
+			Mode:    f.Mode(),
+			Size:    int64(f.UncompressedSize64),
+			ModTime: f.Modified,
+		}
+		if !fi.Mode.IsRegular() {
+			fi.Size = 0
+		}
+		item := &archiveItem{fi: fi}
+		fs.fillNode(item, uint32(os.Getuid()), uint32(os.Getgid()))
+
+		switch {
+		case fi.Mode.IsRegular():
+			item.open = f.Open
+		case fi.Mode&os.ModeSymlink != 0:
+			target, err := readZipSymlink(f)
+			if err != nil {
+				return errors.Wrapf(err, "read symlink %v", f.Name)
+			}
+			item.node.LinkTarget = target
+		}
+		fs.add(name, item)
+	}
+	return nil
+}
+
+func readZipSymlink(f *zip.File) (string, error) {
+	rd, err := f.Open()
+	if err != nil {
+		return "", err
+	}
+	buf, err := io.ReadAll(io.LimitReader(rd, 64*1024))
+	cerr := rd.Close()
+	if err == nil {
+		err = cerr
+	}
+	return string(buf), err
+}
+
+// mkdev encodes a device number like the Linux makedev macro.
+func mkdev(major, minor uint32) uint64 {
+	return uint64(major&0xfffff000)<<32 | uint64(major&0xfff)<<8 |
+		uint64(minor&0xffffff00)<<12 | uint64(minor&0xff)
+}
+
+// VolumeName returns leading volume name, for the archive file system it's
+// always the empty string.
+func (fs *ArchiveFS) VolumeName(_ string) string {
+	return ""
+}
+
+// OpenFile opens a file or directory of the archive.
+func (fs *ArchiveFS) OpenFile(name string, flag int, metadataOnly bool) (File, error) {
+	if flag & ^(O_RDONLY|O_NOFOLLOW|O_DIRECTORY) != 0 {
+		return nil, pathError("open", name,
+			fmt.Errorf("invalid combination of flags 0x%x", flag))
+	}
+
+	name = readerCleanPath(name)
+	item, ok := fs.items[name]
+	if !ok {
+		return nil, pathError("open", name, syscall.ENOENT)
+	}
+	if flag&O_DIRECTORY != 0 && !item.fi.Mode.IsDir() {
+		return nil, pathError("open", name, syscall.ENOTDIR)
+	}
+
+	f := &archiveFile{name: name, item: item}
+	if !metadataOnly {
+		if err := f.MakeReadable(); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// Lstat returns the FileInfo structure describing the named file.
+// If there is an error, it will be of type *os.PathError.
+func (fs *ArchiveFS) Lstat(name string) (*ExtendedFileInfo, error) {
+	name = readerCleanPath(name)
+	item, ok := fs.items[name]
+	if !ok {
+		return nil, pathError("lstat", name, os.ErrNotExist)
+	}
+	return item.fi, nil
+}
+
+// Join joins any number of path elements into a single path.
+func (fs *ArchiveFS) Join(elem ...string) string {
+	return path.Join(elem...)
+}
+
+// Separator returns the separator for dirs/subdirs/files.
+func (fs *ArchiveFS) Separator() string {
+	return "/"
+}
+
+// IsAbs reports whether the path is absolute. For the archive, this is always the case.
+func (fs *ArchiveFS) IsAbs(_ string) bool {
+	return true
+}
+
+// Abs returns an absolute representation of path. For the archive, all paths
+// are absolute.
+func (fs *ArchiveFS) Abs(p string) (string, error) {
+	return readerCleanPath(p), nil
+}
+
+// Clean returns the cleaned path.
+func (fs *ArchiveFS) Clean(p string) string {
+	return path.Clean(p)
+}
+
+// Base returns the last element of p.
+func (fs *ArchiveFS) Base(p string) string {
+	return path.Base(p)
+}
+
+// Dir returns p without the last element.
+func (fs *ArchiveFS) Dir(p string) string {
+	return path.Dir(p)
+}
+
+// archiveFile is an open file or directory of an ArchiveFS.
+type archiveFile struct {
+	name string
+	item *archiveItem
+	rc   io.ReadCloser
+}
+
+// ensure that archiveFile implements File
+var _ File = &archiveFile{}
+
+func (f *archiveFile) MakeReadable() error {
+	if f.rc != nil || f.item.open == nil {
+		return nil
+	}
+	rc, err := f.item.open()
+	if err != nil {
+		return pathError("open", f.name, err)
+	}
+	f.rc = rc
+	return nil
+}
+
+func (f *archiveFile) Read(p []byte) (int, error) {
+	if f.rc == nil {
+		return 0, pathError("read", f.name, os.ErrInvalid)
+	}
+	return f.rc.Read(p)
+}
+
+func (f *archiveFile) Close() error {
+	if f.rc == nil {
+		return nil
+	}
+	return f.rc.Close()
+}
+
+func (f *archiveFile) Readdirnames(n int) ([]string, error) {
+	if !f.item.fi.Mode.IsDir() {
+		return nil, pathError("readdirnames", f.name, syscall.ENOTDIR)
+	}
+	if n > 0 {
+		return nil, pathError("readdirnames", f.name, errors.New("not implemented"))
+	}
+	return slices.Clone(f.item.children), nil
+}
+
+func (f *archiveFile) Stat() (*ExtendedFileInfo, error) {
+	return f.item.fi, nil
+}
+
+func (f *archiveFile) ToNode(_ bool, _ func(format string, args ...any)) (*data.Node, error) {
+	node := f.item.node
+	node.Name = f.item.fi.Name
+	node.Path = f.name
+	node.Links = f.item.fi.Links
+	if node.Type == data.NodeTypeDir {
+		node.Links = 0
+	}
+	node.ExtendedAttributes = slices.Clone(node.ExtendedAttributes)
+	return &node, nil
+}
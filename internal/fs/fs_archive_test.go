@@ -0,0 +1,170 @@
+package fs
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/data"
+	"github.com/restic/restic/internal/test"
+)
+
+var archiveModTime = time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+
+func buildTestTar(t testing.TB) []byte {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	entries := []struct {
+		hdr     tar.Header
+		content string
+	}{
+		{hdr: tar.Header{Name: "./dir/", Typeflag: tar.TypeDir, Mode: 0750, Uid: 1000, Gid: 100, Uname: "user", Gname: "users"}},
+		{hdr: tar.Header{Name: "./dir/file", Typeflag: tar.TypeReg, Mode: 0640, Uid: 1000, Gid: 100, Uname: "user", Gname: "users",
+			PAXRecords: map[string]string{"SCHILY.xattr.user.foo": "bar"}}, content: "file content"},
+		{hdr: tar.Header{Name: "./dir/link", Typeflag: tar.TypeSymlink, Linkname: "file", Mode: 0777}},
+		{hdr: tar.Header{Name: "./hardlink", Typeflag: tar.TypeLink, Linkname: "./dir/file"}},
+		{hdr: tar.Header{Name: "./implicit/sub/other", Typeflag: tar.TypeReg, Mode: 0600}, content: "other"},
+		{hdr: tar.Header{Name: "./dev", Typeflag: tar.TypeChar, Mode: 0600, Devmajor: 1, Devminor: 3}},
+	}
+	for _, e := range entries {
+		hdr := e.hdr
+		hdr.ModTime = archiveModTime
+		hdr.Size = int64(len(e.content))
+		hdr.Format = tar.FormatPAX
+		test.OK(t, tw.WriteHeader(&hdr))
+		_, err := tw.Write([]byte(e.content))
+		test.OK(t, err)
+	}
+	test.OK(t, tw.Close())
+	return buf.Bytes()
+}
+
+func toNode(t testing.TB, fs FS, name string) *data.Node {
+	f, err := fs.OpenFile(name, O_NOFOLLOW, true)
+	test.OK(t, err)
+	node, err := f.ToNode(false, t.Logf)
+	test.OK(t, err)
+	test.OK(t, f.Close())
+	return node
+}
+
+func checkTarFS(t *testing.T, fs *ArchiveFS) {
+	verifyDirectoryContents(t, fs, "/", []string{"archive"})
+	verifyDirectoryContents(t, fs, "/archive", []string{"dev", "dir", "hardlink", "implicit"})
+	verifyDirectoryContents(t, fs, "/archive/dir", []string{"file", "link"})
+	verifyDirectoryContents(t, fs, "/archive/implicit/sub", []string{"other"})
+	verifyFileContentOpenFile(t, fs, "/archive/dir/file", []byte("file content"))
+	verifyFileContentOpenFile(t, fs, "/archive/hardlink", []byte("file content"))
+	verifyFileContentOpenFile(t, fs, "/archive/implicit/sub/other", []byte("other"))
+
+	dir := toNode(t, fs, "/archive/dir")
+	test.Equals(t, data.NodeTypeDir, dir.Type)
+	test.Equals(t, os.ModeDir|0750, dir.Mode)
+	test.Equals(t, "user", dir.User)
+
+	file := toNode(t, fs, "/archive/dir/file")
+	test.Equals(t, data.NodeTypeFile, file.Type)
+	test.Equals(t, os.FileMode(0640), file.Mode)
+	test.Equals(t, uint32(1000), file.UID)
+	test.Equals(t, uint32(100), file.GID)
+	test.Equals(t, "users", file.Group)
+	test.Equals(t, uint64(12), file.Size)
+	test.Equals(t, uint64(2), file.Links)
+	test.Assert(t, file.ModTime.Equal(archiveModTime), "unexpected mtime %v", file.ModTime)
+	test.Equals(t, []data.ExtendedAttribute{{Name: "user.foo", Value: []byte("bar")}}, file.ExtendedAttributes)
+
+	hardlink := toNode(t, fs, "/archive/hardlink")
+	test.Equals(t, "hardlink", hardlink.Name)
+	test.Equals(t, file.Inode, hardlink.Inode)
+	test.Equals(t, file.DeviceID, hardlink.DeviceID)
+	test.Equals(t, uint64(2), hardlink.Links)
+
+	link := toNode(t, fs, "/archive/dir/link")
+	test.Equals(t, data.NodeTypeSymlink, link.Type)
+	test.Equals(t, "file", link.LinkTarget)
+
+	dev := toNode(t, fs, "/archive/dev")
+	test.Equals(t, data.NodeTypeCharDev, dev.Type)
+	test.Equals(t, uint64(0x103), dev.Device)
+
+	fi, err := fs.Lstat("/archive/implicit")
+	test.OK(t, err)
+	test.Assert(t, fi.Mode.IsDir(), "implicit parent is not a directory")
+
+	_, err = fs.Lstat("/archive/missing")
+	test.Assert(t, os.IsNotExist(err), "unexpected error %v", err)
+}
+
+func TestArchiveTarStream(t *testing.T) {
+	fs, err := NewTarStream(bytes.NewReader(buildTestTar(t)), ArchiveOptions{Root: "archive", ModTime: archiveModTime})
+	test.OK(t, err)
+	defer func() {
+		test.OK(t, fs.Close())
+	}()
+	checkTarFS(t, fs)
+}
+
+func TestArchiveTarGzipStream(t *testing.T) {
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	_, err := gz.Write(buildTestTar(t))
+	test.OK(t, err)
+	test.OK(t, gz.Close())
+
+	fs, err := NewTarStream(buf, ArchiveOptions{Root: "archive", ModTime: archiveModTime})
+	test.OK(t, err)
+	defer func() {
+		test.OK(t, fs.Close())
+	}()
+	checkTarFS(t, fs)
+}
+
+func TestArchiveTarFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.tar")
+	test.OK(t, os.WriteFile(filename, buildTestTar(t), 0600))
+
+	fs, err := OpenArchive(filename, ArchiveOptions{Root: "archive", ModTime: archiveModTime})
+	test.OK(t, err)
+	defer func() {
+		test.OK(t, fs.Close())
+	}()
+	test.Assert(t, fs.spool == nil, "uncompressed tar file should be read directly")
+	checkTarFS(t, fs)
+}
+
+func TestArchiveZipFile(t *testing.T) {
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: "dir/file", Modified: archiveModTime, Method: zip.Deflate})
+	test.OK(t, err)
+	_, err = w.Write([]byte("zip content"))
+	test.OK(t, err)
+
+	hdr := &zip.FileHeader{Name: "dir/link", Modified: archiveModTime}
+	hdr.SetMode(os.ModeSymlink | 0777)
+	w, err = zw.CreateHeader(hdr)
+	test.OK(t, err)
+	_, err = w.Write([]byte("file"))
+	test.OK(t, err)
+	test.OK(t, zw.Close())
+
+	filename := filepath.Join(t.TempDir(), "test.zip")
+	test.OK(t, os.WriteFile(filename, buf.Bytes(), 0600))
+
+	fs, err := OpenArchive(filename, ArchiveOptions{Root: "archive", ModTime: archiveModTime})
+	test.OK(t, err)
+	defer func() {
+		test.OK(t, fs.Close())
+	}()
+
+	verifyDirectoryContents(t, fs, "/archive/dir", []string{"file", "link"})
+	verifyFileContentOpenFile(t, fs, "/archive/dir/file", []byte("zip content"))
+	link := toNode(t, fs, "/archive/dir/link")
+	test.Equals(t, data.NodeTypeSymlink, link.Type)
+	test.Equals(t, "file", link.LinkTarget)
+}
@@ -0,0 +1,231 @@
+package fs
+
+import (
+	"path/filepath"
+	"sync"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+)
+
+// SnapshotFS is a file system which transparently reads from filesystem
+// snapshots created during a backup.
+type SnapshotFS interface {
+	FS
+
+	// DeleteSnapshots deletes all snapshots that were created automatically.
+	DeleteSnapshots()
+}
+
+// statically ensure that LocalVss and LocalSnapshot implement SnapshotFS.
+var _ SnapshotFS = &LocalVss{}
+var _ SnapshotFS = &LocalSnapshot{}
+
+// SnapshotProvider creates read-only snapshots of filesystems.
+type SnapshotProvider interface {
+	// Snapshot creates a snapshot of the filesystem or subvolume whose root
+	// directory is origin.
+	Snapshot(origin string) (FilesystemSnapshot, error)
+}
+
+// FilesystemSnapshot is a snapshot created by a SnapshotProvider.
+type FilesystemSnapshot interface {
+	// Path returns the directory at which the origin of the snapshot can be
+	// accessed.
+	Path() string
+	// Delete deletes the snapshot.
+	Delete() error
+}
+
+// LocalSnapshot is a wrapper around the local file system which reads files
+// from filesystem snapshots. A snapshot is created for each filesystem or
+// subvolume once it is accessed for the first time. Paths are mapped to
+// the corresponding path within the snapshot, such that the original paths
+// are recorded in the backup.
+type LocalSnapshot struct {
+	FS
+	provider   SnapshotProvider
+	msgError   ErrorHandler
+	msgMessage MessageHandler
+
+	mutex sync.Mutex
+	// mountPoints contains the mount points of the local file system, a
+	// snapshot is never created across a mount point.
+	mountPoints map[string]struct{}
+	// origins maps directories to the filesystem or subvolume containing
+	// them.
+	origins         map[string]snapshotOrigin
+	snapshots       map[string]FilesystemSnapshot
+	failedSnapshots map[string]struct{}
+}
+
+// NewLocalSnapshot creates a new wrapper around the local file system which
+// uses provider to create snapshots.
+func NewLocalSnapshot(provider SnapshotProvider, msgError ErrorHandler, msgMessage MessageHandler) *LocalSnapshot {
+	mounts, err := readMountInfo()
+	if err != nil {
+		msgError("/", errors.Errorf("failed to list mount points: %s", err))
+	}
+	mountPoints := make(map[string]struct{})
+	for _, m := range mounts {
+		mountPoints[m.MountPoint] = struct{}{}
+	}
+
+	return &LocalSnapshot{
+		FS:              NewLocal(),
+		provider:        provider,
+		msgError:        msgError,
+		msgMessage:      msgMessage,
+		mountPoints:     mountPoints,
+		origins:         make(map[string]snapshotOrigin),
+		snapshots:       make(map[string]FilesystemSnapshot),
+		failedSnapshots: make(map[string]struct{}),
+	}
+}
+
+// DeleteSnapshots deletes all snapshots that were created automatically.
+func (fs *LocalSnapshot) DeleteSnapshots() {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	activeSnapshots := make(map[string]FilesystemSnapshot)
+
+	for origin, snapshot := range fs.snapshots {
+		if err := snapshot.Delete(); err != nil {
+			fs.msgError(origin, errors.Errorf("failed to delete snapshot: %s", err))
+			activeSnapshots[origin] = snapshot
+		}
+	}
+
+	fs.snapshots = activeSnapshots
+}
+
+// OpenFile wraps the OpenFile method of the underlying file system.
+func (fs *LocalSnapshot) OpenFile(name string, flag int, metadataOnly bool) (File, error) {
+	return fs.FS.OpenFile(fs.snapshotPath(name), flag, metadataOnly)
+}
+
+// Lstat wraps the Lstat method of the underlying file system.
+func (fs *LocalSnapshot) Lstat(name string) (*ExtendedFileInfo, error) {
+	return fs.FS.Lstat(fs.snapshotPath(name))
+}
+
+// snapshotOrigin is the filesystem or subvolume which contains a directory.
+type snapshotOrigin struct {
+	// root is the root directory of the filesystem or subvolume.
+	root string
+	// deviceID is the device ID of the filesystem or subvolume.
+	deviceID uint64
+}
+
+// origin returns the filesystem or subvolume which contains the directory
+// dir. Its root is the topmost directory which has the same device ID as dir,
+// without crossing a mount point. A directory which does not exist on the live
+// filesystem, for example because it was deleted after the snapshot was
+// created, belongs to the origin of its parent. fs.mutex must be held.
+func (fs *LocalSnapshot) origin(dir string) snapshotOrigin {
+	if o, ok := fs.origins[dir]; ok {
+		return o
+	}
+
+	parent := filepath.Dir(dir)
+	_, isMountPoint := fs.mountPoints[dir]
+	var o snapshotOrigin
+	fi, err := fs.FS.Lstat(dir)
+	switch {
+	case err != nil && parent != dir:
+		o = fs.origin(parent)
+	case err != nil:
+		o = snapshotOrigin{root: dir}
+	case isMountPoint || parent == dir:
+		o = snapshotOrigin{root: dir, deviceID: fi.DeviceID}
+	default:
+		o = fs.origin(parent)
+		if o.deviceID != fi.DeviceID {
+			o = snapshotOrigin{root: dir, deviceID: fi.DeviceID}
+		}
+	}
+
+	fs.origins[dir] = o
+	return o
+}
+
+// snapshot returns the snapshot of the filesystem or subvolume whose root
+// directory is origin. The snapshot is created when it is requested for the
+// first time. If creation of the snapshot fails, nil is returned. fs.mutex
+// must be held.
+func (fs *LocalSnapshot) snapshot(origin string) FilesystemSnapshot {
+	if snapshot, ok := fs.snapshots[origin]; ok {
+		return snapshot
+	}
+	if _, failed := fs.failedSnapshots[origin]; failed {
+		return nil
+	}
+
+	fs.msgMessage("creating snapshot for [%s]\n", origin)
+	snapshot, err := fs.provider.Snapshot(origin)
+	if err != nil {
+		fs.msgError(origin, errors.Errorf("failed to create snapshot for [%s]: %s", origin, err))
+		fs.failedSnapshots[origin] = struct{}{}
+		return nil
+	}
+	fs.snapshots[origin] = snapshot
+	fs.msgMessage("successfully created snapshot for [%s] at [%s]\n", origin, snapshot.Path())
+	return snapshot
+}
+
+// pathInSnapshot returns the path of absName within snapshot, whose origin
+// is a parent directory of absName.
+func pathInSnapshot(snapshot FilesystemSnapshot, origin, absName string) string {
+	rel, err := filepath.Rel(origin, absName)
+	if err != nil {
+		// cannot happen, origin is a parent directory of absName
+		debug.Log("unable to determine path of %v relative to %v: %v", absName, origin, err)
+		return filepath.Join(snapshot.Path(), absName)
+	}
+	return filepath.Join(snapshot.Path(), rel)
+}
+
+// snapshotPath returns the path inside a snapshot if it already exists. If
+// the path is not yet available as a snapshot, a snapshot is created. If
+// creation of a snapshot fails the file's original path is returned as a
+// fallback.
+//
+// A path is resolved through its parent directory within the snapshot, such
+// that files which were changed or deleted on the live filesystem are still
+// found. The live filesystem is only accessed once for each directory to
+// detect nested filesystems and subvolumes, and for the first path within a
+// filesystem that has no snapshot yet.
+func (fs *LocalSnapshot) snapshotPath(name string) string {
+	absName, err := filepath.Abs(name)
+	if err != nil {
+		return name
+	}
+
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	o, ok := fs.origins[absName]
+	if !ok {
+		o = fs.origin(filepath.Dir(absName))
+		// look up absName in the snapshot of its parent directory if that
+		// already exists. Otherwise use the live filesystem to avoid creating
+		// a snapshot of the parent filesystem if absName is the root of
+		// another one.
+		lookup := absName
+		if snapshot, ok := fs.snapshots[o.root]; ok {
+			lookup = pathInSnapshot(snapshot, o.root, absName)
+		}
+		if _, isMountPoint := fs.mountPoints[absName]; isMountPoint {
+			o = fs.origin(absName)
+		} else if fi, err := fs.FS.Lstat(lookup); err == nil && fi.Mode.IsDir() {
+			o = fs.origin(absName)
+		}
+	}
+
+	snapshot := fs.snapshot(o.root)
+	if snapshot == nil {
+		return name
+	}
+	return pathInSnapshot(snapshot, o.root, absName)
+}
@@ -0,0 +1,114 @@
+package fs
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/restic/restic/internal/errors"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// copyProvider "snapshots" a directory by creating a modified copy of it in a
+// temporary directory.
+type copyProvider struct {
+	t       *testing.T
+	dir     string
+	err     error
+	origins []string
+	deleted int
+}
+
+func (p *copyProvider) Snapshot(origin string) (FilesystemSnapshot, error) {
+	p.origins = append(p.origins, origin)
+	if p.err != nil {
+		return nil, p.err
+	}
+
+	root := p.t.TempDir()
+	rel, err := filepath.Rel(origin, p.dir)
+	rtest.OK(p.t, err)
+	dir := filepath.Join(root, rel)
+	rtest.OK(p.t, os.MkdirAll(filepath.Join(dir, "sub"), 0o700))
+	rtest.OK(p.t, os.WriteFile(filepath.Join(dir, "sub", "file"), []byte("snapshot"), 0o600))
+	return &copySnapshot{p: p, path: root}, nil
+}
+
+type copySnapshot struct {
+	p    *copyProvider
+	path string
+}
+
+func (s *copySnapshot) Path() string {
+	return s.path
+}
+
+func (s *copySnapshot) Delete() error {
+	s.p.deleted++
+	return nil
+}
+
+func readLocalSnapshotFile(t *testing.T, fs FS, name string) string {
+	f, err := fs.OpenFile(name, O_NOFOLLOW, false)
+	rtest.OK(t, err)
+	buf, err := io.ReadAll(f)
+	rtest.OK(t, err)
+	rtest.OK(t, f.Close())
+	return string(buf)
+}
+
+func TestLocalSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	rtest.OK(t, os.Mkdir(filepath.Join(dir, "sub"), 0o700))
+	filename := filepath.Join(dir, "sub", "file")
+	rtest.OK(t, os.WriteFile(filename, []byte("original"), 0o600))
+
+	var errs []error
+	provider := &copyProvider{t: t, dir: dir}
+	fs := NewLocalSnapshot(provider, func(_ string, err error) {
+		errs = append(errs, err)
+	}, func(string, ...interface{}) {})
+
+	rtest.Equals(t, "snapshot", readLocalSnapshotFile(t, fs, filename))
+	fi, err := fs.Lstat(filepath.Join(dir, "sub"))
+	rtest.OK(t, err)
+	rtest.Assert(t, fi.Mode.IsDir(), "expected a directory")
+	rtest.Equals(t, "snapshot", readLocalSnapshotFile(t, fs, filename))
+
+	// files deleted from the live filesystem are still read from the snapshot
+	rtest.OK(t, os.RemoveAll(filepath.Join(dir, "sub")))
+	fi, err = fs.Lstat(filepath.Join(dir, "sub"))
+	rtest.OK(t, err)
+	rtest.Assert(t, fi.Mode.IsDir(), "expected a directory")
+	rtest.Equals(t, "snapshot", readLocalSnapshotFile(t, fs, filename))
+
+	// the snapshot is only created once
+	rtest.Equals(t, 1, len(provider.origins))
+	rtest.Assert(t, HasPathPrefix(provider.origins[0], dir), "origin %v does not contain %v", provider.origins[0], dir)
+	rtest.Equals(t, 0, len(errs))
+
+	fs.DeleteSnapshots()
+	rtest.Equals(t, 1, provider.deleted)
+}
+
+func TestLocalSnapshotFailed(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "file")
+	rtest.OK(t, os.WriteFile(filename, []byte("original"), 0o600))
+
+	var errs []error
+	provider := &copyProvider{t: t, dir: dir, err: errors.New("snapshot failed")}
+	fs := NewLocalSnapshot(provider, func(_ string, err error) {
+		errs = append(errs, err)
+	}, func(string, ...interface{}) {})
+
+	// the original file is read if no snapshot could be created
+	rtest.Equals(t, "original", readLocalSnapshotFile(t, fs, filename))
+	rtest.Equals(t, "original", readLocalSnapshotFile(t, fs, filename))
+	rtest.Equals(t, 1, len(provider.origins))
+	rtest.Equals(t, 1, len(errs))
+
+	fs.DeleteSnapshots()
+	rtest.Equals(t, 0, provider.deleted)
+}
@@ -0,0 +1,301 @@
+package fs
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/restic/restic/internal/data"
+	"github.com/restic/restic/internal/debug"
+)
+
+// SFTPFS is a read-only FS which reads files from a remote host via SFTP. All
+// paths use "/" as separator, relative paths are interpreted relative to the
+// working directory of the SFTP session.
+//
+// SFTP does not provide inode numbers, device IDs or change times. Therefore,
+// the inode and device ID of all files are zero and the change time is equal
+// to the modification time.
+type SFTPFS struct {
+	client *sftp.Client
+	wd     string
+
+	ownersOnce sync.Once
+	users      map[uint32]string
+	groups     map[uint32]string
+}
+
+// statically ensure that SFTPFS implements FS.
+var _ FS = &SFTPFS{}
+
+// NewSFTP returns an FS which reads files using client.
+func NewSFTP(client *sftp.Client) (*SFTPFS, error) {
+	wd, err := client.Getwd()
+	if err != nil {
+		return nil, fmt.Errorf("unable to determine remote working directory: %w", err)
+	}
+	return &SFTPFS{client: client, wd: path.Clean(wd)}, nil
+}
+
+// loadOwners reads the user and group names from /etc/passwd and /etc/group
+// on the remote host. Missing or unreadable files are ignored, the names of
+// the affected owners are then left empty.
+func (fs *SFTPFS) loadOwners() {
+	fs.ownersOnce.Do(func() {
+		fs.users = fs.readOwnerFile("/etc/passwd")
+		fs.groups = fs.readOwnerFile("/etc/group")
+	})
+}
+
+// readOwnerFile parses a file in the format of /etc/passwd or /etc/group and
+// returns a map from ID to name.
+func (fs *SFTPFS) readOwnerFile(filename string) map[uint32]string {
+	names := make(map[uint32]string)
+
+	f, err := fs.client.Open(filename)
+	if err != nil {
+		debug.Log("unable to open remote %v: %v", filename, err)
+		return names
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Split(sc.Text(), ":")
+		if len(fields) < 3 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		id, err := strconv.ParseUint(fields[2], 10, 32)
+		if err != nil {
+			continue
+		}
+		// the first entry for an ID wins, like for getpwuid
+		if _, ok := names[uint32(id)]; !ok {
+			names[uint32(id)] = fields[0]
+		}
+	}
+	if err := sc.Err(); err != nil {
+		debug.Log("unable to read remote %v: %v", filename, err)
+	}
+	return names
+}
+
+func (fs *SFTPFS) owners(uid, gid uint32) (user, group string) {
+	fs.loadOwners()
+	return fs.users[uid], fs.groups[gid]
+}
+
+// lstat returns the file information for name. Errors are not wrapped in an
+// *os.PathError.
+func (fs *SFTPFS) lstat(name string) (*ExtendedFileInfo, error) {
+	fi, err := fs.client.Lstat(name)
+	if err != nil {
+		return nil, err
+	}
+
+	stat, ok := fi.Sys().(*sftp.FileStat)
+	if !ok {
+		return nil, fmt.Errorf("unexpected file info type %T", fi.Sys())
+	}
+
+	mtime := time.Unix(int64(stat.Mtime), 0)
+	return &ExtendedFileInfo{
+		Name:       path.Base(name),
+		Mode:       fi.Mode(),
+		Links:      1,
+		UID:        stat.UID,
+		GID:        stat.GID,
+		Size:       int64(stat.Size),
+		AccessTime: time.Unix(int64(stat.Atime), 0),
+		ModTime:    mtime,
+		ChangeTime: mtime,
+		sys:        stat,
+	}, nil
+}
+
+// VolumeName returns leading volume name, for the remote file system it's
+// always the empty string.
+func (fs *SFTPFS) VolumeName(_ string) string {
+	return ""
+}
+
+// OpenFile opens a file or directory on the remote host. Directories are
+// listed via their path, only regular files are opened for reading.
+func (fs *SFTPFS) OpenFile(name string, flag int, metadataOnly bool) (File, error) {
+	if flag & ^(O_RDONLY|O_NOFOLLOW|O_DIRECTORY) != 0 {
+		return nil, pathError("open", name,
+			fmt.Errorf("invalid combination of flags 0x%x", flag))
+	}
+
+	name, err := fs.Abs(name)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := fs.lstat(name)
+	if err != nil {
+		return nil, pathError("open", name, err)
+	}
+	if flag&O_DIRECTORY != 0 && !fi.Mode.IsDir() {
+		return nil, pathError("open", name, syscall.ENOTDIR)
+	}
+
+	f := &sftpFile{fs: fs, name: name, fi: fi}
+	if !metadataOnly {
+		if err := f.MakeReadable(); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// Lstat returns the FileInfo structure describing the named file.
+// If there is an error, it will be of type *os.PathError.
+func (fs *SFTPFS) Lstat(name string) (*ExtendedFileInfo, error) {
+	name, err := fs.Abs(name)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := fs.lstat(name)
+	if err != nil {
+		return nil, pathError("lstat", name, err)
+	}
+	return fi, nil
+}
+
+// Join joins any number of path elements into a single path.
+func (fs *SFTPFS) Join(elem ...string) string {
+	return path.Join(elem...)
+}
+
+// Separator returns the separator for dirs/subdirs/files.
+func (fs *SFTPFS) Separator() string {
+	return "/"
+}
+
+// IsAbs reports whether the path is absolute.
+func (fs *SFTPFS) IsAbs(p string) bool {
+	return path.IsAbs(p)
+}
+
+// Abs returns an absolute representation of path. Relative paths are
+// resolved against the remote working directory.
+func (fs *SFTPFS) Abs(p string) (string, error) {
+	if !path.IsAbs(p) {
+		p = path.Join(fs.wd, p)
+	}
+	return path.Clean(p), nil
+}
+
+// Clean returns the cleaned path.
+func (fs *SFTPFS) Clean(p string) string {
+	return path.Clean(p)
+}
+
+// Base returns the last element of p.
+func (fs *SFTPFS) Base(p string) string {
+	return path.Base(p)
+}
+
+// Dir returns p without the last element.
+func (fs *SFTPFS) Dir(p string) string {
+	return path.Dir(p)
+}
+
+// sftpFile is a file or directory on the remote host.
+type sftpFile struct {
+	fs   *SFTPFS
+	name string
+	fi   *ExtendedFileInfo
+
+	f *sftp.File
+}
+
+// ensure that sftpFile implements File
+var _ File = &sftpFile{}
+
+func (f *sftpFile) MakeReadable() error {
+	if f.f != nil || !f.fi.Mode.IsRegular() {
+		return nil
+	}
+	file, err := f.fs.client.Open(f.name)
+	if err != nil {
+		return pathError("open", f.name, err)
+	}
+	f.f = file
+	return nil
+}
+
+func (f *sftpFile) Read(p []byte) (int, error) {
+	if f.f == nil {
+		return 0, pathError("read", f.name, os.ErrInvalid)
+	}
+	n, err := f.f.Read(p)
+	if err != nil && err != io.EOF {
+		err = pathError("read", f.name, err)
+	}
+	return n, err
+}
+
+func (f *sftpFile) Close() error {
+	if f.f == nil {
+		return nil
+	}
+	return f.f.Close()
+}
+
+func (f *sftpFile) Readdirnames(n int) ([]string, error) {
+	if !f.fi.Mode.IsDir() {
+		return nil, pathError("readdirnames", f.name, syscall.ENOTDIR)
+	}
+	if n > 0 {
+		return nil, pathError("readdirnames", f.name, fmt.Errorf("not implemented"))
+	}
+
+	entries, err := f.fs.client.ReadDir(f.name)
+	if err != nil {
+		return nil, pathError("readdirnames", f.name, err)
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names, nil
+}
+
+func (f *sftpFile) Stat() (*ExtendedFileInfo, error) {
+	return f.fi, nil
+}
+
+// ToNode returns the node for the remote file. The extended attributes of the
+// SFTP protocol are vendor-specific extension data rather than extended
+// attributes of the file and are therefore not stored.
+func (f *sftpFile) ToNode(_ bool, _ func(format string, args ...any)) (*data.Node, error) {
+	node := buildBasicNode(f.name, f.fi)
+	node.UID = f.fi.UID
+	node.GID = f.fi.GID
+	node.User, node.Group = f.fs.owners(f.fi.UID, f.fi.GID)
+	node.AccessTime = f.fi.AccessTime
+	node.ChangeTime = f.fi.ChangeTime
+	if node.Type != data.NodeTypeDir {
+		node.Links = f.fi.Links
+	}
+
+	if node.Type == data.NodeTypeSymlink {
+		target, err := f.fs.client.ReadLink(f.name)
+		if err != nil {
+			return node, pathError("readlink", f.name, err)
+		}
+		node.LinkTarget = target
+	}
+	return node, nil
+}
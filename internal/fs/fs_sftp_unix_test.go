@@ -0,0 +1,119 @@
+//go:build unix
+
+package fs
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"github.com/restic/restic/internal/data"
+	"github.com/restic/restic/internal/test"
+)
+
+type pipeConn struct {
+	io.Reader
+	io.WriteCloser
+}
+
+// newTestSFTP returns an SFTPFS which accesses the local file system via an
+// in-process SFTP server using dir as working directory.
+func newTestSFTP(t testing.TB, dir string) *SFTPFS {
+	serverRd, clientWr := io.Pipe()
+	clientRd, serverWr := io.Pipe()
+
+	server, err := sftp.NewServer(pipeConn{serverRd, serverWr}, sftp.ReadOnly(), sftp.WithServerWorkingDirectory(dir))
+	test.OK(t, err)
+	go func() {
+		_ = server.Serve()
+	}()
+
+	client, err := sftp.NewClientPipe(clientRd, clientWr)
+	test.OK(t, err)
+	t.Cleanup(func() {
+		_ = server.Close()
+		_ = client.Close()
+	})
+
+	fs, err := NewSFTP(client)
+	test.OK(t, err)
+	return fs
+}
+
+func TestSFTPFS(t *testing.T) {
+	tempdir := t.TempDir()
+	test.OK(t, os.MkdirAll(filepath.Join(tempdir, "dir", "sub"), 0755))
+	test.OK(t, os.WriteFile(filepath.Join(tempdir, "dir", "file"), []byte("file content"), 0640))
+	test.OK(t, os.Symlink("file", filepath.Join(tempdir, "dir", "link")))
+
+	fs := newTestSFTP(t, tempdir)
+	dir := filepath.ToSlash(filepath.Join(tempdir, "dir"))
+
+	abs, err := fs.Abs("dir")
+	test.OK(t, err)
+	test.Equals(t, dir, abs)
+
+	f, err := fs.OpenFile(dir, O_RDONLY|O_NOFOLLOW|O_DIRECTORY, false)
+	test.OK(t, err)
+	names, err := f.Readdirnames(-1)
+	test.OK(t, err)
+	slices.Sort(names)
+	test.Equals(t, []string{"file", "link", "sub"}, names)
+	node, err := f.ToNode(false, t.Logf)
+	test.OK(t, err)
+	test.Equals(t, data.NodeTypeDir, node.Type)
+	test.OK(t, f.Close())
+
+	_, err = fs.OpenFile(dir+"/file", O_RDONLY|O_DIRECTORY, false)
+	test.Assert(t, err != nil, "expected error opening file as directory")
+
+	fi, err := fs.Lstat(dir + "/file")
+	test.OK(t, err)
+	test.Equals(t, int64(12), fi.Size)
+	test.Equals(t, uint32(os.Getuid()), fi.UID)
+	test.Equals(t, uint32(os.Getgid()), fi.GID)
+
+	f, err = fs.OpenFile(dir+"/file", O_RDONLY|O_NOFOLLOW, false)
+	test.OK(t, err)
+	buf, err := io.ReadAll(f)
+	test.OK(t, err)
+	test.Equals(t, "file content", string(buf))
+	node, err = f.ToNode(false, t.Logf)
+	test.OK(t, err)
+	test.OK(t, f.Close())
+	test.Equals(t, data.NodeTypeFile, node.Type)
+	test.Equals(t, "file", node.Name)
+	test.Equals(t, uint64(12), node.Size)
+	test.Equals(t, os.FileMode(0640), node.Mode.Perm())
+	test.Equals(t, fi.ModTime, node.ModTime)
+	test.Equals(t, 0, len(node.ExtendedAttributes))
+
+	f, err = fs.OpenFile(dir+"/link", O_RDONLY|O_NOFOLLOW, true)
+	test.OK(t, err)
+	node, err = f.ToNode(false, t.Logf)
+	test.OK(t, err)
+	test.OK(t, f.Close())
+	test.Equals(t, data.NodeTypeSymlink, node.Type)
+	test.Equals(t, "file", node.LinkTarget)
+
+	_, err = fs.Lstat(dir + "/missing")
+	test.Assert(t, os.IsNotExist(err), "unexpected error %v", err)
+}
+
+func TestSFTPFSOwners(t *testing.T) {
+	fs := newTestSFTP(t, t.TempDir())
+	fs.users = map[uint32]string{1000: "user"}
+	fs.groups = map[uint32]string{100: "users"}
+	fs.ownersOnce.Do(func() {})
+
+	user, group := fs.owners(1000, 100)
+	test.Equals(t, "user", user)
+	test.Equals(t, "users", group)
+
+	user, group = fs.owners(2000, 200)
+	test.Equals(t, "", user)
+	test.Equals(t, "", group)
+}
@@ -0,0 +1,143 @@
+package fs
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/options"
+)
+
+// FSSnapshotConfig holds extended options of filesystem snapshots on Linux.
+type FSSnapshotConfig struct {
+	Provider        string `option:"provider" help:"snapshot provider: auto, btrfs, lvm or command (default: auto)"`
+	BtrfsDir        string `option:"btrfs-dir" help:"directory for btrfs snapshots, must be on the same filesystem (default: the snapshotted subvolume)"`
+	LVMSize         string `option:"lvm-size" help:"size of LVM snapshots, absolute (ex. '5G') or relative (default: 10%ORIGIN)"`
+	LVMMountOptions string `option:"lvm-mount-options" help:"additional mount options for LVM snapshots"`
+	CreateCommand   string `option:"create-command" help:"command which creates a snapshot of $RESTIC_SNAPSHOT_ORIGIN and prints its path"`
+	DeleteCommand   string `option:"delete-command" help:"command which deletes the snapshot at $RESTIC_SNAPSHOT_PATH"`
+}
+
+func init() {
+	if runtime.GOOS == "linux" {
+		options.Register("fs-snapshot", FSSnapshotConfig{})
+	}
+}
+
+// ParseFSSnapshotConfig parses the extended options for filesystem snapshots
+// to a FSSnapshotConfig struct.
+func ParseFSSnapshotConfig(o options.Options) (FSSnapshotConfig, error) {
+	cfg := FSSnapshotConfig{
+		Provider: "auto",
+		LVMSize:  "10%ORIGIN",
+	}
+	o = o.Extract("fs-snapshot")
+	if err := o.Apply("fs-snapshot", &cfg); err != nil {
+		return FSSnapshotConfig{}, err
+	}
+
+	return cfg, nil
+}
+
+// runSnapshotCommand runs the command args with the additional environment
+// variables env and returns its trimmed standard output.
+func runSnapshotCommand(args []string, env ...string) (string, error) {
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Env = append(os.Environ(), env...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			return "", errors.Errorf("%v failed: %v", args[0], err)
+		}
+		return "", errors.Errorf("%v failed: %v: %v", args[0], err, msg)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// snapshotName returns a unique name for a snapshot.
+func snapshotName(prefix string) string {
+	var buf [4]byte
+	_, _ = rand.Read(buf[:])
+	return prefix + "restic-snapshot-" + hex.EncodeToString(buf[:])
+}
+
+// commandProvider creates snapshots using user-supplied commands.
+type commandProvider struct {
+	createArgs []string
+	deleteArgs []string
+}
+
+func newCommandProvider(createCommand, deleteCommand string) (*commandProvider, error) {
+	if createCommand == "" {
+		return nil, errors.New("fs-snapshot.create-command is not set")
+	}
+
+	var p commandProvider
+	var err error
+	p.createArgs, err = backend.SplitShellStrings(createCommand)
+	if err != nil {
+		return nil, errors.Errorf("invalid fs-snapshot.create-command: %v", err)
+	}
+	if len(p.createArgs) == 0 {
+		return nil, errors.New("fs-snapshot.create-command is empty")
+	}
+	if deleteCommand != "" {
+		p.deleteArgs, err = backend.SplitShellStrings(deleteCommand)
+		if err != nil {
+			return nil, errors.Errorf("invalid fs-snapshot.delete-command: %v", err)
+		}
+	}
+	return &p, nil
+}
+
+// Snapshot runs the create command, which must print the path of the
+// snapshot as the last line of its output.
+func (p *commandProvider) Snapshot(origin string) (FilesystemSnapshot, error) {
+	out, err := runSnapshotCommand(p.createArgs, "RESTIC_SNAPSHOT_ORIGIN="+origin)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(out, "\n")
+	path := strings.TrimSpace(lines[len(lines)-1])
+	if path == "" {
+		return nil, errors.New("create command did not print the snapshot path")
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, errors.Errorf("snapshot path %v is not a directory", path)
+	}
+	return &commandSnapshot{origin: origin, path: path, deleteArgs: p.deleteArgs}, nil
+}
+
+type commandSnapshot struct {
+	origin     string
+	path       string
+	deleteArgs []string
+}
+
+func (s *commandSnapshot) Path() string {
+	return s.path
+}
+
+// Delete runs the delete command, if any.
+func (s *commandSnapshot) Delete() error {
+	if len(s.deleteArgs) == 0 {
+		return nil
+	}
+	_, err := runSnapshotCommand(s.deleteArgs, "RESTIC_SNAPSHOT_ORIGIN="+s.origin, "RESTIC_SNAPSHOT_PATH="+s.path)
+	return err
+}
@@ -0,0 +1,174 @@
+package fs
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+// NewSnapshotProvider returns the snapshot provider selected in cfg.
+func NewSnapshotProvider(cfg FSSnapshotConfig) (SnapshotProvider, error) {
+	btrfs := &btrfsProvider{dir: cfg.BtrfsDir}
+	lvm := &lvmProvider{size: cfg.LVMSize, mountOptions: cfg.LVMMountOptions}
+
+	switch cfg.Provider {
+	case "auto", "":
+		if cfg.CreateCommand != "" {
+			return newCommandProvider(cfg.CreateCommand, cfg.DeleteCommand)
+		}
+		return &autoProvider{btrfs: btrfs, lvm: lvm}, nil
+	case "btrfs":
+		return btrfs, nil
+	case "lvm":
+		return lvm, nil
+	case "command":
+		return newCommandProvider(cfg.CreateCommand, cfg.DeleteCommand)
+	default:
+		return nil, errors.Errorf("invalid snapshot provider %q, must be one of (auto|btrfs|lvm|command)", cfg.Provider)
+	}
+}
+
+// autoProvider selects the snapshot provider based on the mounted filesystem.
+type autoProvider struct {
+	btrfs, lvm SnapshotProvider
+}
+
+func (p *autoProvider) Snapshot(origin string) (FilesystemSnapshot, error) {
+	m, err := findMount(origin)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case m.FSType == "btrfs":
+		return p.btrfs.Snapshot(origin)
+	case strings.HasPrefix(m.Source, "/dev/mapper/") || strings.HasPrefix(m.Source, "/dev/dm-"):
+		return p.lvm.Snapshot(origin)
+	default:
+		return nil, errors.Errorf("no snapshot provider for filesystem %v (type %v)", m.Source, m.FSType)
+	}
+}
+
+// btrfsProvider creates read-only snapshots of btrfs subvolumes.
+type btrfsProvider struct {
+	// dir is the directory in which the snapshots are created. If empty, the
+	// snapshot is created within the subvolume. The snapshot of a subvolume
+	// does not contain snapshots created within it.
+	dir string
+}
+
+func (p *btrfsProvider) Snapshot(origin string) (FilesystemSnapshot, error) {
+	dir := p.dir
+	if dir == "" {
+		dir = origin
+	}
+	path := filepath.Join(dir, snapshotName("."))
+
+	_, err := runSnapshotCommand([]string{"btrfs", "subvolume", "snapshot", "-r", origin, path})
+	if err != nil {
+		return nil, err
+	}
+	return &btrfsSnapshot{path: path}, nil
+}
+
+type btrfsSnapshot struct {
+	path string
+}
+
+func (s *btrfsSnapshot) Path() string {
+	return s.path
+}
+
+func (s *btrfsSnapshot) Delete() error {
+	_, err := runSnapshotCommand([]string{"btrfs", "subvolume", "delete", s.path})
+	return err
+}
+
+// lvmProvider creates snapshots of LVM logical volumes and mounts them
+// read-only in a temporary directory.
+type lvmProvider struct {
+	size         string
+	mountOptions string
+}
+
+func (p *lvmProvider) Snapshot(origin string) (FilesystemSnapshot, error) {
+	m, err := findMount(origin)
+	if err != nil {
+		return nil, err
+	}
+	if m.MountPoint != origin {
+		return nil, errors.Errorf("%v is not the mount point of a logical volume", origin)
+	}
+
+	out, err := runSnapshotCommand([]string{"lvs", "--noheadings", "--options", "vg_name,lv_name", m.Source})
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(out)
+	if len(fields) != 2 {
+		return nil, errors.Errorf("%v is not a logical volume", m.Source)
+	}
+	vg, name := fields[0], snapshotName(fields[1]+"-")
+
+	sizeFlag := "--size"
+	if strings.Contains(p.size, "%") {
+		sizeFlag = "--extents"
+	}
+	_, err = runSnapshotCommand([]string{"lvcreate", "--snapshot", sizeFlag, p.size, "--name", name, vg + "/" + fields[1]})
+	if err != nil {
+		return nil, err
+	}
+
+	s := &lvmSnapshot{volume: vg + "/" + name, root: m.Root}
+	s.mountDir, err = os.MkdirTemp("", "restic-lvm-")
+	if err != nil {
+		return nil, errors.Join(err, s.Delete())
+	}
+
+	mountOptions := "ro"
+	if m.FSType == "xfs" {
+		// the snapshot has the same UUID as the mounted origin
+		mountOptions += ",nouuid"
+	}
+	if p.mountOptions != "" {
+		mountOptions += "," + p.mountOptions
+	}
+	_, err = runSnapshotCommand([]string{"mount", "-o", mountOptions, "/dev/" + s.volume, s.mountDir})
+	if err != nil {
+		return nil, errors.Join(err, s.Delete())
+	}
+	s.mounted = true
+
+	return s, nil
+}
+
+type lvmSnapshot struct {
+	volume   string
+	mountDir string
+	mounted  bool
+	// root is the directory of the filesystem mounted at the origin
+	root string
+}
+
+func (s *lvmSnapshot) Path() string {
+	return filepath.Join(s.mountDir, s.root)
+}
+
+func (s *lvmSnapshot) Delete() error {
+	if s.mounted {
+		if _, err := runSnapshotCommand([]string{"umount", s.mountDir}); err != nil {
+			return err
+		}
+		s.mounted = false
+	}
+	if s.mountDir != "" {
+		if err := os.Remove(s.mountDir); err != nil {
+			return err
+		}
+		s.mountDir = ""
+	}
+	_, err := runSnapshotCommand([]string{"lvremove", "--yes", s.volume})
+	return err
+}
@@ -0,0 +1,63 @@
+package fs
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestParseMountInfo(t *testing.T) {
+	input := `22 1 0:21 / / rw,relatime shared:1 - ext4 /dev/sda1 rw
+36 22 253:1 /@home /home rw,relatime shared:2 master:1 - btrfs /dev/mapper/vg-home rw,subvol=/@home
+40 22 0:35 / /mnt/with\040space rw - tmpfs tmpfs rw
+`
+	mounts, err := parseMountInfo(strings.NewReader(input))
+	rtest.OK(t, err)
+	rtest.Equals(t, []mountInfo{
+		{Root: "/", MountPoint: "/", FSType: "ext4", Source: "/dev/sda1"},
+		{Root: "/@home", MountPoint: "/home", FSType: "btrfs", Source: "/dev/mapper/vg-home"},
+		{Root: "/", MountPoint: "/mnt/with space", FSType: "tmpfs", Source: "tmpfs"},
+	}, mounts)
+
+	_, err = parseMountInfo(strings.NewReader("22 1 0:21 / / rw\n"))
+	rtest.Assert(t, err != nil, "missing error for invalid line")
+}
+
+func TestCommandProvider(t *testing.T) {
+	dir := t.TempDir()
+	snapshotDir := filepath.Join(dir, "snapshot")
+	marker := filepath.Join(dir, "deleted")
+
+	p, err := NewSnapshotProvider(FSSnapshotConfig{
+		Provider:      "command",
+		CreateCommand: "sh -c 'mkdir " + snapshotDir + " && echo creating $RESTIC_SNAPSHOT_ORIGIN && echo " + snapshotDir + "'",
+		DeleteCommand: "sh -c 'echo $RESTIC_SNAPSHOT_ORIGIN $RESTIC_SNAPSHOT_PATH > " + marker + "'",
+	})
+	rtest.OK(t, err)
+
+	s, err := p.Snapshot("/origin")
+	rtest.OK(t, err)
+	rtest.Equals(t, snapshotDir, s.Path())
+
+	rtest.OK(t, s.Delete())
+	buf, err := os.ReadFile(marker)
+	rtest.OK(t, err)
+	rtest.Equals(t, "/origin "+snapshotDir+"\n", string(buf))
+
+	// errors of the create command are reported
+	_, err = p.Snapshot("/origin")
+	rtest.Assert(t, err != nil, "missing error for failed create command")
+}
+
+func TestNewSnapshotProvider(t *testing.T) {
+	for _, cfg := range []FSSnapshotConfig{
+		{Provider: "invalid"},
+		{Provider: "command"},
+	} {
+		_, err := NewSnapshotProvider(cfg)
+		rtest.Assert(t, err != nil, "missing error for %v", cfg)
+	}
+}
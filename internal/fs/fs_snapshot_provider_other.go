@@ -0,0 +1,10 @@
+//go:build !linux
+
+package fs
+
+import "github.com/restic/restic/internal/errors"
+
+// NewSnapshotProvider returns the snapshot provider selected in cfg.
+func NewSnapshotProvider(_ FSSnapshotConfig) (SnapshotProvider, error) {
+	return nil, errors.New("filesystem snapshots are only supported on Linux and Windows")
+}
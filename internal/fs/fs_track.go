@@ -42,3 +42,8 @@ func (f *trackFile) Close() error {
 	runtime.SetFinalizer(f, nil)
 	return f.File.Close()
 }
+
+// Unwrap returns the underlying file.
+func (f *trackFile) Unwrap() File {
+	return f.File
+}
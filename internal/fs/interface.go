@@ -50,3 +50,30 @@ type File interface {
 	// returned by consecutive calls to Stat() and ToNode() must match.
 	ToNode(ignoreXattrListError bool, warnf func(format string, args ...any)) (*data.Node, error)
 }
+
+// SparseFile is implemented by files which can locate the holes of sparse
+// files, such that reading them can be skipped.
+type SparseFile interface {
+	File
+	io.Seeker
+
+	// NextHole returns the start and end offset of the first hole which ends
+	// after offset. If there is no such hole, ok is false. The current offset
+	// of the file is not changed.
+	NextHole(offset int64) (start, end int64, ok bool, err error)
+}
+
+// AsSparseFile returns f as SparseFile if the underlying file supports
+// locating holes.
+func AsSparseFile(f File) (SparseFile, bool) {
+	for {
+		if sf, ok := f.(SparseFile); ok {
+			return sf, true
+		}
+		wrapper, ok := f.(interface{ Unwrap() File })
+		if !ok {
+			return nil, false
+		}
+		f = wrapper.Unwrap()
+	}
+}
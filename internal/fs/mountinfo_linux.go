@@ -0,0 +1,105 @@
+package fs
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+// mountInfo describes a mounted filesystem.
+type mountInfo struct {
+	// Root is the directory of the filesystem which is mounted at MountPoint.
+	Root       string
+	MountPoint string
+	FSType     string
+	Source     string
+}
+
+// readMountInfo returns the mounted filesystems of the current process.
+func readMountInfo() ([]mountInfo, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	return parseMountInfo(f)
+}
+
+// parseMountInfo parses the mountinfo format described in proc(5).
+func parseMountInfo(rd io.Reader) ([]mountInfo, error) {
+	var mounts []mountInfo
+	sc := bufio.NewScanner(rd)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		// the optional fields are terminated by a single hyphen
+		sep := -1
+		for i := 6; i < len(fields); i++ {
+			if fields[i] == "-" {
+				sep = i
+				break
+			}
+		}
+		if sep < 0 || len(fields) < sep+3 {
+			return nil, errors.Errorf("invalid mountinfo line %q", sc.Text())
+		}
+
+		mounts = append(mounts, mountInfo{
+			Root:       unescapeMountInfo(fields[3]),
+			MountPoint: unescapeMountInfo(fields[4]),
+			FSType:     fields[sep+1],
+			Source:     unescapeMountInfo(fields[sep+2]),
+		})
+	}
+	return mounts, sc.Err()
+}
+
+// unescapeMountInfo replaces the octal escape sequences used for whitespace
+// and backslashes in mountinfo.
+func unescapeMountInfo(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if c, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				sb.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		sb.WriteByte(s[i])
+	}
+	return sb.String()
+}
+
+// findMount returns the mount containing path.
+func findMount(path string) (mountInfo, error) {
+	mounts, err := readMountInfo()
+	if err != nil {
+		return mountInfo{}, err
+	}
+
+	var found *mountInfo
+	// later entries shadow earlier mounts on the same mount point
+	for i := range mounts {
+		m := &mounts[i]
+		if !HasPathPrefix(m.MountPoint, path) {
+			continue
+		}
+		if found == nil || len(m.MountPoint) >= len(found.MountPoint) {
+			found = m
+		}
+	}
+	if found == nil {
+		return mountInfo{}, errors.Errorf("no mount found for %v", path)
+	}
+	return *found, nil
+}
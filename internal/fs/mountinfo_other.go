@@ -0,0 +1,13 @@
+//go:build !linux
+
+package fs
+
+// mountInfo describes a mounted filesystem.
+type mountInfo struct {
+	MountPoint string
+}
+
+// readMountInfo is only supported on Linux, no mount points are returned.
+func readMountInfo() ([]mountInfo, error) {
+	return nil, nil
+}
@@ -0,0 +1,48 @@
+package fs
+
+import (
+	"errors"
+	"io"
+
+	"golang.org/x/sys/unix"
+)
+
+// See the SparseFile interface for a description of each method
+var _ SparseFile = &localFile{}
+
+func (f *localFile) Seek(offset int64, whence int) (int64, error) {
+	return f.f.Seek(offset, whence)
+}
+
+func (f *localFile) NextHole(offset int64) (start, end int64, ok bool, err error) {
+	cur, err := f.f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	defer func() {
+		_, serr := f.f.Seek(cur, io.SeekStart)
+		if err == nil {
+			err = serr
+		}
+	}()
+
+	start, err = f.f.Seek(offset, unix.SEEK_HOLE)
+	if errors.Is(err, unix.ENXIO) {
+		// offset is at or beyond the end of the file
+		return 0, 0, false, nil
+	}
+	if err != nil {
+		return 0, 0, false, err
+	}
+
+	end, err = f.f.Seek(start, unix.SEEK_DATA)
+	if errors.Is(err, unix.ENXIO) {
+		// the hole extends to the end of the file
+		end, err = f.f.Seek(0, io.SeekEnd)
+	}
+	if err != nil {
+		return 0, 0, false, err
+	}
+	// every file ends with an implicit hole of length zero
+	return start, end, end > start, nil
+}
@@ -3,6 +3,7 @@ package global
 import (
 	"context"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"os/exec"
@@ -19,6 +20,8 @@ import (
 	"github.com/restic/restic/internal/backend/logger"
 	"github.com/restic/restic/internal/backend/retry"
 	"github.com/restic/restic/internal/backend/sema"
+	"github.com/restic/restic/internal/backend/tiered"
+	"github.com/restic/restic/internal/backend/trace"
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/options"
 	"github.com/restic/restic/internal/repository"
@@ -46,6 +49,7 @@ type BackendWrapper func(r backend.Backend) (backend.Backend, error)
 type Options struct {
 	Repo               string
 	RepositoryFile     string
+	ColdRepo           string
 	PasswordFile       string
 	PasswordCommand    string
 	KeyHint            string
@@ -59,11 +63,19 @@ type Options struct {
 	CleanupCache       bool
 	Compression        repository.CompressionMode
 	PackSize           uint
+	ParityRedundancy   uint
 	NoExtraVerify      bool
+	IndexMode          repository.IndexMode
 	InsecureNoPassword bool
+	Profile            string
+	ProfilesFile       string
 
 	backend.TransportOptions
 	limiter.Limits
+	LimitSchedule string
+
+	TraceBackend string
+	MetricsFile  string
 
 	Password string
 	Term     ui.Terminal
@@ -71,6 +83,14 @@ type Options struct {
 	Backends                              *location.Registry
 	BackendTestHook, BackendInnerTestHook BackendWrapper
 
+	// SkipSnapshotLogCheck disables verifying the snapshot log when opening
+	// the repository, for commands which verify it themselves.
+	SkipSnapshotLogCheck bool
+
+	// SkipParityRedundancyCheck disables the warning about a parity redundancy
+	// which differs from the repository config, for commands which change it.
+	SkipParityRedundancyCheck bool
+
 	// Verbosity is set as follows:
 	//  0 means: don't print any messages except errors, this is used when --quiet is specified
 	//  1 is the default: print essential messages
@@ -84,13 +104,22 @@ type Options struct {
 
 	// packSizeFlag and compressionFlag detect if the corresponding CLI flag was set (CLI overrides env).
 	// Lookup cannot return nil as the flags are added to the same FlagSet just above.
-	packSizeFlag    *pflag.Flag
-	compressionFlag *pflag.Flag
+	packSizeFlag         *pflag.Flag
+	compressionFlag      *pflag.Flag
+	parityRedundancyFlag *pflag.Flag
+	// parityRedundancySet is set if the parity redundancy was specified using
+	// the flag or the environment.
+	parityRedundancySet bool
+
+	// traceRecorder is set if backend operations are traced or metrics are collected.
+	traceRecorder *trace.Recorder
+	traceFile     *os.File
 }
 
 func (opts *Options) AddFlags(f *pflag.FlagSet) {
 	f.StringVarP(&opts.Repo, "repo", "r", "", "`repository` to backup to or restore from (default: $RESTIC_REPOSITORY)")
 	f.StringVarP(&opts.RepositoryFile, "repository-file", "", "", "`file` to read the repository location from (default: $RESTIC_REPOSITORY_FILE)")
+	f.StringVar(&opts.ColdRepo, "cold-repo", "", "`repository` location to store data pack files in, all other files stay in --repo (default: $RESTIC_COLD_REPOSITORY)")
 	f.StringVarP(&opts.PasswordFile, "password-file", "p", "", "`file` to read the repository password from (default: $RESTIC_PASSWORD_FILE)")
 	f.StringVarP(&opts.KeyHint, "key-hint", "", "", "`key` ID of key to try decrypting first (default: $RESTIC_KEY_HINT)")
 	f.StringVarP(&opts.PasswordCommand, "password-command", "", "", "shell `command` to obtain the repository password from (default: $RESTIC_PASSWORD_COMMAND)")
@@ -110,16 +139,25 @@ func (opts *Options) AddFlags(f *pflag.FlagSet) {
 	const compressionFlag = "compression"
 	f.Var(&opts.Compression, compressionFlag, "compression mode (only available for repository format version 2), one of (auto|off|fastest|better|max) (default: $RESTIC_COMPRESSION)")
 	f.BoolVar(&opts.NoExtraVerify, "no-extra-verify", false, "skip additional verification of data before upload (see documentation)")
+	f.Var(&opts.IndexMode, "index-mode", "keep the index in memory or in a memory-mapped file in the cache, one of (memory|mmap)")
 	f.IntVar(&opts.Limits.UploadKb, "limit-upload", 0, "limits uploads to a maximum `rate` in KiB/s. (default: unlimited)")
 	f.IntVar(&opts.Limits.DownloadKb, "limit-download", 0, "limits downloads to a maximum `rate` in KiB/s. (default: unlimited)")
+	f.StringVar(&opts.LimitSchedule, "limit-schedule", "", "time dependent upload and download limits `schedule`, for example \"Mon-Fri 08:00-18:00 up=2M down=10M\" (default: $RESTIC_LIMIT_SCHEDULE)")
+	f.StringVar(&opts.TraceBackend, "trace-backend", "", "write a JSON line for every backend operation to `file` (default: $RESTIC_TRACE_BACKEND)")
+	f.StringVar(&opts.MetricsFile, "metrics-file", "", "write backend metrics in Prometheus text format to `file` at exit (default: $RESTIC_METRICS_FILE)")
 	const packSizeFlag = "pack-size"
 	f.UintVar(&opts.PackSize, packSizeFlag, 0, "set target pack `size` in MiB, created pack files may be larger (default: $RESTIC_PACK_SIZE)")
+	const parityRedundancyFlag = "parity-redundancy"
+	f.UintVar(&opts.ParityRedundancy, parityRedundancyFlag, 0, "store recovery data for new pack files with the given size in `percent` of the pack size, only used by init and the parity_redundancy migration (default: $RESTIC_PARITY_REDUNDANCY)")
 	f.StringSliceVarP(&opts.Options, "option", "o", []string{}, "set extended option (`key=value`, can be specified multiple times)")
 	f.StringVar(&opts.HTTPUserAgent, "http-user-agent", "", "set a http user agent for outgoing http requests")
+	f.StringVar(&opts.Profile, "profile", "", "load options from the configuration `profile` with this name (default: $RESTIC_PROFILE)")
+	f.StringVar(&opts.ProfilesFile, "profiles-file", "", "`file` to load configuration profiles from (default: $RESTIC_PROFILES_FILE or profiles.yaml/profiles.toml in the restic config directory)")
 	f.DurationVar(&opts.StuckRequestTimeout, "stuck-request-timeout", 5*time.Minute, "`duration` after which to retry stuck requests")
 
 	opts.Repo = os.Getenv("RESTIC_REPOSITORY")
 	opts.RepositoryFile = os.Getenv("RESTIC_REPOSITORY_FILE")
+	opts.ColdRepo = os.Getenv("RESTIC_COLD_REPOSITORY")
 	opts.PasswordFile = os.Getenv("RESTIC_PASSWORD_FILE")
 	opts.KeyHint = os.Getenv("RESTIC_KEY_HINT")
 	opts.PasswordCommand = os.Getenv("RESTIC_PASSWORD_COMMAND")
@@ -127,8 +165,14 @@ func (opts *Options) AddFlags(f *pflag.FlagSet) {
 		opts.RootCertFilenames = strings.Split(os.Getenv("RESTIC_CACERT"), ",")
 	}
 	opts.TLSClientCertKeyFilename = os.Getenv("RESTIC_TLS_CLIENT_CERT")
+	opts.LimitSchedule = os.Getenv("RESTIC_LIMIT_SCHEDULE")
+	opts.TraceBackend = os.Getenv("RESTIC_TRACE_BACKEND")
+	opts.MetricsFile = os.Getenv("RESTIC_METRICS_FILE")
+	opts.Profile = os.Getenv("RESTIC_PROFILE")
+	opts.ProfilesFile = os.Getenv("RESTIC_PROFILES_FILE")
 	opts.packSizeFlag = f.Lookup(packSizeFlag)
 	opts.compressionFlag = f.Lookup(compressionFlag)
+	opts.parityRedundancyFlag = f.Lookup(parityRedundancyFlag)
 
 	if os.Getenv("RESTIC_HTTP_USER_AGENT") != "" {
 		opts.HTTPUserAgent = os.Getenv("RESTIC_HTTP_USER_AGENT")
@@ -144,6 +188,17 @@ func (opts *Options) PreRun(needsPassword bool) error {
 		}
 		opts.PackSize = uint(targetPackSize)
 	}
+	if envVal := os.Getenv("RESTIC_PARITY_REDUNDANCY"); envVal != "" && !opts.parityRedundancyFlag.Changed {
+		redundancy, err := strconv.ParseUint(envVal, 10, 32)
+		if err != nil {
+			return errors.Fatalf("invalid value for RESTIC_PARITY_REDUNDANCY %q: %v", envVal, err)
+		}
+		opts.ParityRedundancy = uint(redundancy)
+		opts.parityRedundancySet = true
+	}
+	if opts.parityRedundancyFlag.Changed {
+		opts.parityRedundancySet = true
+	}
 	if envVal := os.Getenv("RESTIC_COMPRESSION"); envVal != "" && !opts.compressionFlag.Changed {
 		if err := opts.Compression.Set(envVal); err != nil {
 			return errors.Fatalf("invalid value for RESTIC_COMPRESSION %q: %v", envVal, err)
@@ -165,12 +220,20 @@ func (opts *Options) PreRun(needsPassword bool) error {
 		opts.Verbosity = 0
 	}
 
+	if opts.IndexMode == repository.IndexModeMmap && opts.NoCache {
+		return errors.Fatal("--index-mode=mmap stores the index in the cache and cannot be used with --no-cache")
+	}
+
 	// parse extended options
 	extendedOpts, err := options.Parse(opts.Options)
 	if err != nil {
 		return err
 	}
 	opts.Extended = extendedOpts
+
+	if err := opts.setupTrace(); err != nil {
+		return err
+	}
 	if !needsPassword {
 		return nil
 	}
@@ -182,6 +245,69 @@ func (opts *Options) PreRun(needsPassword bool) error {
 	return nil
 }
 
+// setupTrace opens the backend trace file and prepares the collection of
+// backend metrics.
+func (opts *Options) setupTrace() error {
+	if opts.TraceBackend == "" && opts.MetricsFile == "" {
+		return nil
+	}
+
+	var w io.Writer
+	if opts.TraceBackend != "" {
+		f, err := os.OpenFile(opts.TraceBackend, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return errors.Fatalf("unable to open backend trace file: %v", err)
+		}
+		opts.traceFile = f
+		w = f
+	}
+	opts.traceRecorder = trace.NewRecorder(w)
+	return nil
+}
+
+// FinishTrace closes the backend trace file and writes the metrics file.
+func (opts *Options) FinishTrace() error {
+	if opts.traceRecorder == nil {
+		return nil
+	}
+
+	var err error
+	if opts.MetricsFile != "" {
+		err = writeMetricsFile(opts.MetricsFile, opts.traceRecorder)
+	}
+	if opts.traceFile != nil {
+		if cerr := opts.traceFile.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// writeMetricsFile atomically replaces the metrics file, such that collectors
+// never read a partially written file.
+func writeMetricsFile(filename string, rec *trace.Recorder) error {
+	f, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".tmp-")
+	if err != nil {
+		return errors.Wrap(err, "metrics file")
+	}
+
+	err = rec.WriteMetrics(f, time.Now())
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), filename)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return errors.Wrap(err, "metrics file")
+	}
+	return nil
+}
+
 // resolvePassword determines the password to be used for opening the repository.
 func resolvePassword(opts *Options, envStr string) (string, error) {
 	if opts.PasswordFile != "" && opts.PasswordCommand != "" {
@@ -304,7 +430,7 @@ func OpenRepository(ctx context.Context, gopts Options, printer progress.Printer
 		return nil, err
 	}
 
-	be, err := innerOpenBackend(ctx, repo, gopts, gopts.Extended, false, printer)
+	be, err := openTieredBackend(ctx, repo, gopts, false, printer)
 	if err != nil {
 		return nil, err
 	}
@@ -318,25 +444,81 @@ func OpenRepository(ctx context.Context, gopts Options, printer progress.Printer
 	if err != nil {
 		return nil, err
 	}
+	s.SetWarnf(printer.E)
 
 	err = decryptRepository(ctx, s, &gopts, printer)
 	if err != nil {
 		return nil, err
 	}
 
+	err = checkTieredStorage(s)
+	if err != nil {
+		return nil, err
+	}
+
+	if gopts.parityRedundancySet && !gopts.SkipParityRedundancyCheck && gopts.ParityRedundancy != s.Config().ParityRedundancy {
+		printer.E("Warning: ignoring --parity-redundancy %v, the repository config specifies %v%%. Use `restic migrate parity_redundancy` to change it.", gopts.ParityRedundancy, s.Config().ParityRedundancy)
+	}
+
 	printRepositoryInfo(s, gopts, printer)
 
-	if gopts.NoCache {
-		return s, nil
+	if !gopts.NoCache {
+		err = setupCache(s, gopts, printer)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	err = setupCache(s, gopts, printer)
-	if err != nil {
-		return nil, err
+	if !gopts.SkipSnapshotLogCheck {
+		err = checkSnapshotLog(ctx, s, printer)
+		if err != nil {
+			return nil, err
+		}
 	}
 	return s, nil
 }
 
+// checkTieredStorage verifies that a cold repository is specified if and only if
+// the repository was initialized with one. Otherwise, all data pack files would
+// appear to be missing or new data would be stored in the wrong location.
+func checkTieredStorage(s *repository.Repository) error {
+	switch {
+	case s.Config().Tiered && !s.IsTiered():
+		return errors.Fatal("the repository stores data pack files in a cold repository, please specify it using --cold-repo or $RESTIC_COLD_REPOSITORY")
+	case !s.Config().Tiered && s.IsTiered():
+		return errors.Fatal("the repository was not initialized with a cold repository, --cold-repo cannot be used")
+	}
+	return nil
+}
+
+// checkSnapshotLog warns if entries of the snapshot log are missing, which
+// indicates that the repository was rolled back to an older state. Missing
+// snapshots are reported by the repository once the command lists the
+// snapshots, as listing them here would list them twice. Only a canceled
+// context is returned as error.
+func checkSnapshotLog(ctx context.Context, s *repository.Repository, printer progress.Printer) error {
+	status, err := repository.CheckSnapshotLog(ctx, s, s.Cache())
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		printer.E("unable to verify snapshot log: %v", err)
+		return nil
+	}
+
+	if len(status.MissingEntries) > 0 {
+		printer.E("Warning: %d entries of the snapshot log are missing, the repository may have been rolled back to an older state", len(status.MissingEntries))
+	}
+	if !status.OK() {
+		printer.E("Run `restic check` for details and `restic repair snapshot-log` to accept the current state of the repository.")
+	}
+
+	if err := repository.RememberSnapshotLog(s.Cache(), status); err != nil {
+		printer.E("unable to save snapshot log state: %v", err)
+	}
+	return nil
+}
+
 // hasRepositoryConfig checks if the repository config file exists and is not empty.
 func hasRepositoryConfig(ctx context.Context, be backend.Backend, repo string, gopts Options) error {
 	fi, err := be.Stat(ctx, backend.Handle{Type: restic.ConfigFile})
@@ -358,9 +540,11 @@ func hasRepositoryConfig(ctx context.Context, be backend.Backend, repo string, g
 // createRepositoryInstance creates a new repository instance with the given options.
 func createRepositoryInstance(be backend.Backend, gopts Options) (*repository.Repository, error) {
 	s, err := repository.New(be, repository.Options{
-		Compression:   gopts.Compression,
-		PackSize:      gopts.PackSize * 1024 * 1024,
-		NoExtraVerify: gopts.NoExtraVerify,
+		Compression:      gopts.Compression,
+		PackSize:         gopts.PackSize * 1024 * 1024,
+		NoExtraVerify:    gopts.NoExtraVerify,
+		IndexMode:        gopts.IndexMode,
+		ParityRedundancy: gopts.ParityRedundancy,
 	})
 	if err != nil {
 		return nil, errors.Fatalf("%s", err)
@@ -465,6 +649,9 @@ func CreateRepository(ctx context.Context, gopts Options, version uint, chunkerP
 	if version < restic.MinRepoVersion || version > restic.MaxRepoVersion {
 		return nil, errors.Fatalf("only repository versions between %v and %v are allowed", restic.MinRepoVersion, restic.MaxRepoVersion)
 	}
+	if gopts.ColdRepo != "" && version < restic.MinTieredRepoVersion {
+		return nil, errors.Fatalf("--cold-repo requires at least repository version %v", restic.MinTieredRepoVersion)
+	}
 
 	repo, err := readRepo(gopts)
 	if err != nil {
@@ -478,7 +665,7 @@ func CreateRepository(ctx context.Context, gopts Options, version uint, chunkerP
 		return nil, err
 	}
 
-	be, err := innerOpenBackend(ctx, repo, gopts, gopts.Extended, true, printer)
+	be, err := openTieredBackend(ctx, repo, gopts, true, printer)
 	if err != nil {
 		return nil, errors.Fatalf("create repository at %s failed: %v", location.StripPassword(gopts.Backends, repo), err)
 	}
@@ -496,6 +683,28 @@ func CreateRepository(ctx context.Context, gopts Options, version uint, chunkerP
 	return s, nil
 }
 
+// openTieredBackend opens the backend for the repository. If a cold repository
+// is configured, data pack files are stored there while all other files are
+// stored in the main repository.
+func openTieredBackend(ctx context.Context, repo string, gopts Options, create bool, printer progress.Printer) (backend.Backend, error) {
+	be, err := innerOpenBackend(ctx, repo, gopts, gopts.Extended, create, printer)
+	if err != nil || gopts.ColdRepo == "" {
+		return be, err
+	}
+
+	if gopts.ColdRepo == repo {
+		_ = be.Close()
+		return nil, errors.Fatal("--cold-repo must differ from the repository location")
+	}
+
+	cold, err := innerOpenBackend(ctx, gopts.ColdRepo, gopts, gopts.Extended, create, printer)
+	if err != nil {
+		_ = be.Close()
+		return nil, err
+	}
+	return tiered.New(be, cold), nil
+}
+
 func innerOpenBackend(ctx context.Context, s string, gopts Options, opts options.Options, create bool, printer progress.Printer) (backend.Backend, error) {
 	debug.Log("parsing location %v", location.StripPassword(gopts.Backends, s))
 
@@ -553,6 +762,13 @@ func setupTransport(gopts Options) (http.RoundTripper, limiter.Limiter, error) {
 
 	// wrap the transport so that the throughput via HTTP is limited
 	lim := limiter.NewStaticLimiter(gopts.Limits)
+	if gopts.LimitSchedule != "" {
+		schedule, err := limiter.ParseSchedule(gopts.LimitSchedule)
+		if err != nil {
+			return nil, nil, errors.Fatalf("invalid --limit-schedule: %v", err)
+		}
+		lim = limiter.NewScheduleLimiter(schedule, gopts.Limits)
+	}
 	rt = lim.Transport(rt)
 
 	return rt, lim, nil
@@ -593,6 +809,13 @@ func wrapBackend(be backend.Backend, gopts Options, printer progress.Printer) (b
 	// wrap with debug logging and connection limiting
 	be = logger.New(sema.NewBackend(be))
 
+	// record all operations including each retry attempt
+	var traced *trace.Backend
+	if gopts.traceRecorder != nil {
+		traced = trace.New(be, gopts.traceRecorder)
+		be = traced
+	}
+
 	// wrap backend if a test specified an inner hook
 	if gopts.BackendInnerTestHook != nil {
 		var err error
@@ -613,6 +836,9 @@ func wrapBackend(be backend.Backend, gopts Options, printer progress.Printer) (b
 		printer.E("%v operation successful after %d retries", msg, retries)
 	}
 	be = retry.New(be, 15*time.Minute, report, success)
+	if traced != nil {
+		be = traced.WrapRetries(be)
+	}
 
 	// wrap backend if a test specified a hook
 	if gopts.BackendTestHook != nil {
@@ -128,3 +128,27 @@ func TestCompressionEnvIgnoredWhenFlagSet(t *testing.T) {
 	rtest.OK(t, err)
 	rtest.Equals(t, "off", gopts.Compression.String())
 }
+
+func TestIndexModeMmapRequiresCache(t *testing.T) {
+	var gopts Options
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	gopts.AddFlags(fs)
+
+	rtest.OK(t, fs.Set("index-mode", "mmap"))
+	rtest.OK(t, fs.Set("no-cache", "true"))
+
+	err := gopts.PreRun(false)
+	rtest.Assert(t, err != nil && errors.IsFatal(err), "expected fatal error, got %v", err)
+	rtest.Assert(t, strings.Contains(err.Error(), "--no-cache"), "error should mention --no-cache, got %v", err)
+}
+
+func TestParityRedundancyEnvApplied(t *testing.T) {
+	t.Setenv("RESTIC_PARITY_REDUNDANCY", "10")
+
+	var gopts Options
+	gopts.AddFlags(pflag.NewFlagSet("test", pflag.ContinueOnError))
+
+	err := gopts.PreRun(false)
+	rtest.OK(t, err)
+	rtest.Equals(t, uint(10), gopts.ParityRedundancy)
+}
@@ -17,6 +17,7 @@ type SecondaryRepoOptions struct {
 	PasswordCommand    string
 	KeyHint            string
 	InsecureNoPassword bool
+	ColdRepo           string
 	// repo2 options
 	LegacyRepo            string
 	LegacyRepositoryFile  string
@@ -51,12 +52,14 @@ func (opts *SecondaryRepoOptions) AddFlags(f *pflag.FlagSet, repoPrefix string,
 	f.StringVarP(&opts.KeyHint, "from-key-hint", "", "", "key ID of key to try decrypting the source repository first (default: $RESTIC_FROM_KEY_HINT)")
 	f.StringVarP(&opts.PasswordCommand, "from-password-command", "", "", "shell `command` to obtain the source repository password from (default: $RESTIC_FROM_PASSWORD_COMMAND)")
 	f.BoolVar(&opts.InsecureNoPassword, "from-insecure-no-password", false, "use an empty password for the source repository (insecure)")
+	f.StringVarP(&opts.ColdRepo, "from-cold-repo", "", "", "`repository` location storing the data pack files of the source repository (default: $RESTIC_FROM_COLD_REPOSITORY)")
 
 	opts.Repo = os.Getenv("RESTIC_FROM_REPOSITORY")
 	opts.RepositoryFile = os.Getenv("RESTIC_FROM_REPOSITORY_FILE")
 	opts.PasswordFile = os.Getenv("RESTIC_FROM_PASSWORD_FILE")
 	opts.KeyHint = os.Getenv("RESTIC_FROM_KEY_HINT")
 	opts.PasswordCommand = os.Getenv("RESTIC_FROM_PASSWORD_COMMAND")
+	opts.ColdRepo = os.Getenv("RESTIC_FROM_COLD_REPOSITORY")
 }
 
 func (opts *SecondaryRepoOptions) FillGlobalOpts(ctx context.Context, gopts Options, repoPrefix string) (Options, bool, error) {
@@ -65,7 +68,7 @@ func (opts *SecondaryRepoOptions) FillGlobalOpts(ctx context.Context, gopts Opti
 	}
 
 	hasFromRepo := opts.Repo != "" || opts.RepositoryFile != "" || opts.PasswordFile != "" ||
-		opts.KeyHint != "" || opts.PasswordCommand != "" || opts.InsecureNoPassword
+		opts.KeyHint != "" || opts.PasswordCommand != "" || opts.InsecureNoPassword || opts.ColdRepo != ""
 	hasRepo2 := opts.LegacyRepo != "" || opts.LegacyRepositoryFile != "" || opts.LegacyPasswordFile != "" ||
 		opts.LegacyKeyHint != "" || opts.LegacyPasswordCommand != ""
 
@@ -88,6 +91,7 @@ func (opts *SecondaryRepoOptions) FillGlobalOpts(ctx context.Context, gopts Opti
 		dstGopts.PasswordCommand = opts.PasswordCommand
 		dstGopts.KeyHint = opts.KeyHint
 		dstGopts.InsecureNoPassword = opts.InsecureNoPassword
+		dstGopts.ColdRepo = opts.ColdRepo
 
 		pwdEnv = "RESTIC_FROM_PASSWORD"
 		repoPrefix = "source"
@@ -103,6 +107,7 @@ func (opts *SecondaryRepoOptions) FillGlobalOpts(ctx context.Context, gopts Opti
 		dstGopts.KeyHint = opts.LegacyKeyHint
 		// keep existing behavior for legacy options
 		dstGopts.InsecureNoPassword = false
+		dstGopts.ColdRepo = ""
 
 		pwdEnv = "RESTIC_PASSWORD2"
 	}
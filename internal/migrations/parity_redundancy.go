@@ -0,0 +1,46 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+)
+
+func init() {
+	register(&ParityRedundancy{})
+}
+
+// ParityRedundancy stores the value of --parity-redundancy in the repository
+// config, which determines the recovery data stored for new pack files.
+type ParityRedundancy struct{}
+
+func (*ParityRedundancy) Name() string {
+	return "parity_redundancy"
+}
+
+func (*ParityRedundancy) Desc() string {
+	return "store the value of --parity-redundancy in the repository config"
+}
+
+func (*ParityRedundancy) Check(_ context.Context, repo restic.Repository) (bool, string, error) {
+	stored := repo.Config().ParityRedundancy
+	requested := repo.(*repository.Repository).RequestedParityRedundancy()
+	switch {
+	case requested == 0:
+		// avoid disabling the recovery data by accident, this requires --force
+		return false, "specify the new value using --parity-redundancy", nil
+	case requested == stored:
+		return false, fmt.Sprintf("parity redundancy is already set to %v%%", stored), nil
+	}
+	return true, "", nil
+}
+
+func (*ParityRedundancy) RepoCheck() bool {
+	return false
+}
+
+func (*ParityRedundancy) Apply(ctx context.Context, repo restic.Repository) error {
+	return repository.UpdateParityRedundancy(ctx, repo.(*repository.Repository))
+}
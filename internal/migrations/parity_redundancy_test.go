@@ -0,0 +1,33 @@
+package migrations
+
+import (
+	"context"
+	"testing"
+
+	"github.com/restic/restic/internal/repository"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestParityRedundancy(t *testing.T) {
+	_, be := repository.TestRepositoryWithBackend(t, nil, 0, repository.Options{})
+
+	repo, err := repository.New(be, repository.Options{ParityRedundancy: 10})
+	rtest.OK(t, err)
+	rtest.OK(t, repo.SearchKey(context.TODO(), rtest.TestPassword, 10, ""))
+	rtest.Equals(t, uint(0), repo.Config().ParityRedundancy)
+
+	m := &ParityRedundancy{}
+	ok, _, err := m.Check(context.TODO(), repo)
+	rtest.OK(t, err)
+	rtest.Assert(t, ok, "migration check returned false")
+	rtest.OK(t, m.Apply(context.TODO(), repo))
+	rtest.Equals(t, uint(10), repo.Config().ParityRedundancy)
+
+	ok, _, err = m.Check(context.TODO(), repo)
+	rtest.OK(t, err)
+	rtest.Assert(t, !ok, "migration check returned true after applying the migration")
+
+	// the value is stored in the config
+	repo = repository.TestOpenBackend(t, be)
+	rtest.Equals(t, uint(10), repo.Config().ParityRedundancy)
+}
@@ -0,0 +1,44 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/ui/progress"
+)
+
+func init() {
+	register(&UpgradeRepoV3{})
+}
+
+type UpgradeRepoV3 struct{}
+
+func (*UpgradeRepoV3) Name() string {
+	return "upgrade_repo_v3"
+}
+
+func (*UpgradeRepoV3) Desc() string {
+	return "upgrade a repository to version 3 and rewrite the index in the binary format"
+}
+
+func (*UpgradeRepoV3) Check(_ context.Context, repo restic.Repository) (bool, string, error) {
+	version := repo.Config().Version
+	reason := ""
+	switch {
+	case version < 2:
+		reason = "repository must be upgraded to version 2 first using upgrade_repo_v2"
+	case version > 2:
+		reason = fmt.Sprintf("repository is already upgraded to version %v", version)
+	}
+	return version == 2, reason, nil
+}
+
+func (*UpgradeRepoV3) RepoCheck() bool {
+	return true
+}
+
+func (m *UpgradeRepoV3) Apply(ctx context.Context, repo restic.Repository) error {
+	return repository.UpgradeRepoV3(ctx, repo.(*repository.Repository), progress.NewNoopPrinter())
+}
@@ -0,0 +1,40 @@
+package migrations
+
+import (
+	"context"
+	"testing"
+
+	"github.com/restic/restic/internal/repository"
+)
+
+func TestUpgradeRepoV3(t *testing.T) {
+	repo, _, _ := repository.TestRepositoryWithVersion(t, 2)
+	if repo.Config().Version != 2 {
+		t.Fatal("test repo has wrong version")
+	}
+
+	m := &UpgradeRepoV3{}
+
+	ok, _, err := m.Check(context.Background(), repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !ok {
+		t.Fatal("migration check returned false")
+	}
+
+	err = m.Apply(context.Background(), repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ok, _, err = m.Check(context.Background(), repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ok {
+		t.Fatal("migration check returned true for upgraded repository")
+	}
+}
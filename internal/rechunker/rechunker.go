@@ -0,0 +1,399 @@
+// Package rechunker copies trees between repositories which use different
+// chunker parameters.
+package rechunker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+
+	"github.com/restic/chunker"
+	"github.com/restic/restic/internal/data"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/walker"
+	"golang.org/x/sync/errgroup"
+)
+
+// Rechunker copies trees from a source repository to a destination
+// repository. The contents of files are reassembled and split again using the
+// chunker polynomial of the destination. All other metadata and the tree
+// structure are kept. A Rechunker remembers the files and trees it has already
+// processed, such that copying several similar snapshots only processes new
+// files once.
+type Rechunker struct {
+	src Loader
+	pol chunker.Pol
+
+	rewriter *walker.TreeRewriter
+
+	m sync.Mutex
+	// contents maps the hash of the content of a file in the source
+	// repository to the new content
+	contents map[restic.ID]restic.IDs
+	// visited contains the trees for which all files were rechunked
+	visited restic.IDSet
+}
+
+// Stats contains the amount of data processed by RewriteTree.
+type Stats struct {
+	Files uint
+	Bytes uint64
+}
+
+// Loader loads trees and the blobs of files from the source repository. The
+// blobs of files are streamed from their pack files.
+type Loader interface {
+	restic.Loader
+	LookupBlob(bh restic.BlobHandle) []restic.PackBlob
+	LoadBlobsFromPack(ctx context.Context, packID restic.ID, blobs []restic.BlobHandle, handleBlobFn func(blob restic.BlobHandle, buf []byte, err error) error) error
+}
+
+const (
+	// maxSegmentSize limits the amount of data of a file which is loaded from a
+	// pack file at once.
+	maxSegmentSize = chunker.MaxSize / 2
+	// readAhead is the number of segments of a file which are loaded while the
+	// chunker processes the current one.
+	readAhead = 2
+)
+
+// New returns a Rechunker which loads trees and data from src and splits files
+// using pol.
+func New(src Loader, pol chunker.Pol) *Rechunker {
+	rc := &Rechunker{
+		src:      src,
+		pol:      pol,
+		contents: make(map[restic.ID]restic.IDs),
+		visited:  restic.NewIDSet(),
+	}
+	rc.rewriter = walker.NewTreeRewriter(walker.RewriteOpts{
+		RewriteNode: rc.rewriteNode,
+	})
+	return rc
+}
+
+// contentKey returns the key used to look up the new content of a file.
+func contentKey(content restic.IDs) restic.ID {
+	buf := make([]byte, 0, len(content)*len(restic.ID{}))
+	for _, id := range content {
+		buf = append(buf, id[:]...)
+	}
+	return restic.Hash(buf)
+}
+
+func (rc *Rechunker) rewriteNode(node *data.Node, _ string) *data.Node {
+	if node.Type != data.NodeTypeFile || len(node.Content) == 0 {
+		return node
+	}
+
+	rc.m.Lock()
+	content, ok := rc.contents[contentKey(node.Content)]
+	rc.m.Unlock()
+	if !ok {
+		// all files are rechunked before rewriting the trees
+		panic(fmt.Sprintf("internal error: file %v was not rechunked", node.Name))
+	}
+	node.Content = content
+	return node
+}
+
+// RewriteTree rechunks the files contained in the tree root and saves them
+// along with the modified trees using uploader. It returns the ID of the new
+// root tree.
+func (rc *Rechunker) RewriteTree(ctx context.Context, uploader restic.BlobSaver, root restic.ID) (restic.ID, Stats, error) {
+	stats, err := rc.rechunkFiles(ctx, uploader, root)
+	if err != nil {
+		return restic.ID{}, Stats{}, err
+	}
+
+	newRoot, err := rc.rewriter.RewriteTree(ctx, rc.src, uploader, "/", root)
+	if err != nil {
+		return restic.ID{}, Stats{}, err
+	}
+	return newRoot, stats, nil
+}
+
+// rechunkFiles rechunks all files of the tree root which were not processed
+// before. The files are processed in parallel.
+func (rc *Rechunker) rechunkFiles(ctx context.Context, uploader restic.BlobSaver, root restic.ID) (Stats, error) {
+	wg, wgCtx := errgroup.WithContext(ctx)
+	jobs := make(chan restic.IDs)
+
+	var stats Stats
+	var statsMutex sync.Mutex
+	// files which are queued or processed at the moment
+	queued := restic.NewIDSet()
+
+	// the blobs of each file are loaded ahead of the chunker, but small files
+	// only consist of a single segment. Use enough workers to keep both the
+	// network and the CPU busy
+	workers := int(rc.src.Connections()) + runtime.GOMAXPROCS(0)
+	for i := 0; i < workers; i++ {
+		wg.Go(func() error {
+			chnker := chunker.New(nil, rc.pol)
+			buf := make([]byte, chunker.MaxSize)
+			for content := range jobs {
+				newContent, size, err := rc.rechunkFile(wgCtx, uploader, chnker, buf, content)
+				if err != nil {
+					return err
+				}
+
+				rc.m.Lock()
+				rc.contents[contentKey(content)] = newContent
+				rc.m.Unlock()
+
+				statsMutex.Lock()
+				stats.Files++
+				stats.Bytes += size
+				statsMutex.Unlock()
+			}
+			return nil
+		})
+	}
+
+	wg.Go(func() error {
+		defer close(jobs)
+
+		return data.StreamTrees(wgCtx, rc.src, restic.IDs{root}, restic.NoopCounter, func(treeID restic.ID) bool {
+			rc.m.Lock()
+			defer rc.m.Unlock()
+			visited := rc.visited.Has(treeID)
+			rc.visited.Insert(treeID)
+			return visited
+		}, func(treeID restic.ID, err error, nodes data.TreeNodeIterator) error {
+			if err != nil {
+				return fmt.Errorf("LoadTree(%v) returned error %v", treeID.Str(), err)
+			}
+
+			for item := range nodes {
+				if item.Error != nil {
+					return item.Error
+				}
+				node := item.Node
+				if node.Type != data.NodeTypeFile || len(node.Content) == 0 {
+					continue
+				}
+
+				key := contentKey(node.Content)
+				rc.m.Lock()
+				_, done := rc.contents[key]
+				skip := done || queued.Has(key)
+				queued.Insert(key)
+				rc.m.Unlock()
+				if skip {
+					continue
+				}
+
+				select {
+				case jobs <- node.Content:
+				case <-wgCtx.Done():
+					return wgCtx.Err()
+				}
+			}
+			return nil
+		})
+	})
+
+	err := wg.Wait()
+	if err != nil {
+		// trees may have been marked as visited without rechunking their files
+		rc.m.Lock()
+		rc.visited = restic.NewIDSet()
+		rc.m.Unlock()
+	}
+	return stats, err
+}
+
+// rechunkFile loads the blobs in content from the source repository, splits
+// the data using the chunker polynomial of the destination and saves the new
+// blobs. It returns the IDs of the new blobs and the size of the file.
+func (rc *Rechunker) rechunkFile(ctx context.Context, uploader restic.BlobSaver, chnker *chunker.Chunker, buf []byte, content restic.IDs) (restic.IDs, uint64, error) {
+	segments, err := rc.splitSegments(content)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	loadCtx, cancel := context.WithCancel(ctx)
+	loaded := rc.loadSegments(loadCtx, segments)
+	defer func() {
+		// wait for outstanding loads
+		cancel()
+		for res := range loaded {
+			<-res
+		}
+	}()
+
+	rd := &contentReader{ctx: loadCtx, segments: loaded}
+	chnker.Reset(rd, rc.pol)
+
+	var newContent restic.IDs
+	var size uint64
+	for {
+		chunk, err := chnker.Next(buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+
+		id, _, _, err := uploader.SaveBlob(ctx, restic.DataBlob, chunk.Data, restic.ID{}, false)
+		if err != nil {
+			return nil, 0, err
+		}
+		newContent = append(newContent, id)
+		size += uint64(chunk.Length)
+	}
+
+	debug.Log("rechunked %d blobs into %d blobs", len(content), len(newContent))
+	return newContent, size, nil
+}
+
+// segment is a sequence of consecutive blobs of a file which are stored in the
+// same pack file.
+type segment struct {
+	packID restic.ID
+	ids    restic.IDs
+	size   uint
+}
+
+// loadedSegment contains the data of the blobs of a segment.
+type loadedSegment struct {
+	ids   restic.IDs
+	blobs map[restic.ID][]byte
+	err   error
+}
+
+// splitSegments splits content into segments of at most maxSegmentSize bytes.
+// A blob stored in several pack files is preferably loaded from the pack file
+// of the previous blob.
+func (rc *Rechunker) splitSegments(content restic.IDs) ([]segment, error) {
+	var segments []segment
+	for _, id := range content {
+		pbs := rc.src.LookupBlob(restic.BlobHandle{Type: restic.DataBlob, ID: id})
+		if len(pbs) == 0 {
+			return nil, fmt.Errorf("blob %v not found in index", id.Str())
+		}
+
+		pb := pbs[0]
+		if len(segments) > 0 {
+			cur := &segments[len(segments)-1]
+			for _, candidate := range pbs {
+				if candidate.PackID() == cur.packID {
+					pb = candidate
+					break
+				}
+			}
+			if pb.PackID() == cur.packID && cur.size+pb.PlaintextLength() <= maxSegmentSize {
+				cur.ids = append(cur.ids, id)
+				cur.size += pb.PlaintextLength()
+				continue
+			}
+		}
+		segments = append(segments, segment{packID: pb.PackID(), ids: restic.IDs{id}, size: pb.PlaintextLength()})
+	}
+	return segments, nil
+}
+
+// loadSegments loads the segments in the background and returns their data in
+// order. At most readAhead segments are loaded before they are consumed. The
+// returned channel is closed once all segments were passed on or ctx is
+// cancelled.
+func (rc *Rechunker) loadSegments(ctx context.Context, segments []segment) <-chan chan loadedSegment {
+	loaded := make(chan chan loadedSegment, readAhead)
+	go func() {
+		defer close(loaded)
+		for _, seg := range segments {
+			res := make(chan loadedSegment, 1)
+			select {
+			case loaded <- res:
+			case <-ctx.Done():
+				return
+			}
+			go func() {
+				res <- rc.loadSegment(ctx, seg)
+			}()
+		}
+	}()
+	return loaded
+}
+
+// loadSegment loads all blobs of seg using a single request to the pack file
+// where possible. Blobs which cannot be loaded from the pack file are loaded
+// using LoadBlob, which also tries other copies of the blob.
+func (rc *Rechunker) loadSegment(ctx context.Context, seg segment) loadedSegment {
+	blobs := make(map[restic.ID][]byte, len(seg.ids))
+	handles := make([]restic.BlobHandle, 0, len(seg.ids))
+	seen := restic.NewIDSet()
+	for _, id := range seg.ids {
+		if !seen.Has(id) {
+			seen.Insert(id)
+			handles = append(handles, restic.BlobHandle{Type: restic.DataBlob, ID: id})
+		}
+	}
+
+	err := rc.src.LoadBlobsFromPack(ctx, seg.packID, handles, func(blob restic.BlobHandle, buf []byte, err error) error {
+		if err != nil {
+			debug.Log("loading blob %v from pack %v failed: %v", blob, seg.packID, err)
+			return nil
+		}
+		blobs[blob.ID] = bytes.Clone(buf)
+		return nil
+	})
+	if err != nil {
+		return loadedSegment{err: err}
+	}
+
+	for _, h := range handles {
+		if _, ok := blobs[h.ID]; ok {
+			continue
+		}
+		buf, err := rc.src.LoadBlob(ctx, h, nil)
+		if err != nil {
+			return loadedSegment{err: err}
+		}
+		blobs[h.ID] = buf
+	}
+	return loadedSegment{ids: seg.ids, blobs: blobs}
+}
+
+// contentReader reads the data of the blobs of the loaded segments in order.
+type contentReader struct {
+	ctx      context.Context
+	segments <-chan chan loadedSegment
+
+	ids   restic.IDs
+	blobs map[restic.ID][]byte
+	buf   []byte
+}
+
+func (rd *contentReader) Read(p []byte) (int, error) {
+	for len(rd.buf) == 0 {
+		if len(rd.ids) == 0 {
+			res, ok := <-rd.segments
+			if !ok {
+				// the segments are incomplete if the context was cancelled
+				if err := rd.ctx.Err(); err != nil {
+					return 0, err
+				}
+				return 0, io.EOF
+			}
+			seg := <-res
+			if seg.err != nil {
+				return 0, seg.err
+			}
+			rd.ids, rd.blobs = seg.ids, seg.blobs
+			continue
+		}
+		rd.buf = rd.blobs[rd.ids[0]]
+		rd.ids = rd.ids[1:]
+	}
+
+	n := copy(p, rd.buf)
+	rd.buf = rd.buf[n:]
+	return n, nil
+}
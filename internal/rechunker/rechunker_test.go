@@ -0,0 +1,189 @@
+package rechunker
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/restic/chunker"
+	"github.com/restic/restic/internal/data"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+	"github.com/restic/restic/internal/walker"
+)
+
+// chunkData splits buf using pol and returns the chunks.
+func chunkData(t *testing.T, buf []byte, pol chunker.Pol) [][]byte {
+	var chunks [][]byte
+	chnker := chunker.New(bytes.NewReader(buf), pol)
+	for {
+		chunk, err := chnker.Next(nil)
+		if err == io.EOF {
+			break
+		}
+		rtest.OK(t, err)
+		chunks = append(chunks, chunk.Data)
+	}
+	return chunks
+}
+
+func chunkIDs(t *testing.T, buf []byte, pol chunker.Pol) restic.IDs {
+	ids := restic.IDs{}
+	for _, chunk := range chunkData(t, buf, pol) {
+		ids = append(ids, restic.Hash(chunk))
+	}
+	return ids
+}
+
+// createTestTree saves a tree with the files in files and a subdirectory
+// containing the same files.
+func createTestTree(t *testing.T, repo restic.Repository, files map[string][]byte) restic.ID {
+	var root restic.ID
+	rtest.OK(t, repo.WithBlobUploader(context.TODO(), func(ctx context.Context, uploader restic.BlobSaverWithAsync) error {
+		var nodes []*data.Node
+		for name, buf := range files {
+			node := &data.Node{Name: name, Type: data.NodeTypeFile, Mode: 0644, Size: uint64(len(buf)), Content: restic.IDs{}}
+			for _, chunk := range chunkData(t, buf, repo.Config().ChunkerPolynomial) {
+				id, _, _, err := uploader.SaveBlob(ctx, restic.DataBlob, chunk, restic.ID{}, false)
+				rtest.OK(t, err)
+				node.Content = append(node.Content, id)
+			}
+			nodes = append(nodes, node)
+		}
+		subtree := data.TestSaveNodes(t, ctx, uploader, nodes)
+		nodes = append(nodes, &data.Node{Name: "subdir", Type: data.NodeTypeDir, Mode: 0755, Subtree: &subtree})
+		root = data.TestSaveNodes(t, ctx, uploader, nodes)
+		return nil
+	}))
+	return root
+}
+
+// checkTree verifies that the files in the tree root of repo are split using
+// pol and contain the expected data.
+func checkTree(t *testing.T, repo restic.Repository, root restic.ID, files map[string][]byte, pol chunker.Pol) {
+	found := 0
+	rtest.OK(t, walker.Walk(context.TODO(), repo, root, walker.WalkVisitor{
+		ProcessNode: func(_ restic.ID, _ string, node *data.Node, err error) error {
+			if err != nil {
+				return err
+			}
+			if node == nil || node.Type != data.NodeTypeFile {
+				return nil
+			}
+			found++
+			expected := files[node.Name]
+			rtest.Equals(t, uint64(len(expected)), node.Size)
+			rtest.Equals(t, chunkIDs(t, expected, pol), node.Content)
+
+			var content []byte
+			for _, id := range node.Content {
+				buf, err := repo.LoadBlob(context.TODO(), restic.BlobHandle{Type: restic.DataBlob, ID: id}, nil)
+				rtest.OK(t, err)
+				content = append(content, buf...)
+			}
+			rtest.Assert(t, bytes.Equal(expected, content), "content of %v differs", node.Name)
+			return nil
+		},
+	}))
+	rtest.Equals(t, 2*len(files), found)
+}
+
+// countingLoader counts the requests to load blobs.
+type countingLoader struct {
+	Loader
+	m             sync.Mutex
+	loadBlob      int
+	loadFromPack  int
+	requestedBlob int
+}
+
+func (l *countingLoader) LoadBlob(ctx context.Context, bh restic.BlobHandle, buf []byte) ([]byte, error) {
+	if bh.Type == restic.DataBlob {
+		l.m.Lock()
+		l.loadBlob++
+		l.m.Unlock()
+	}
+	return l.Loader.LoadBlob(ctx, bh, buf)
+}
+
+func (l *countingLoader) LoadBlobsFromPack(ctx context.Context, packID restic.ID, blobs []restic.BlobHandle, handleBlobFn func(blob restic.BlobHandle, buf []byte, err error) error) error {
+	l.m.Lock()
+	l.loadFromPack++
+	l.requestedBlob += len(blobs)
+	l.m.Unlock()
+	return l.Loader.LoadBlobsFromPack(ctx, packID, blobs, handleBlobFn)
+}
+
+func TestRechunkStreamsPacks(t *testing.T) {
+	random := rand.New(rand.NewSource(23))
+	large := make([]byte, 20*1024*1024)
+	random.Read(large)
+	files := map[string][]byte{"large": large}
+
+	src := repository.TestRepository(t)
+	root := createTestTree(t, src, files)
+	blobs := len(chunkIDs(t, large, src.Config().ChunkerPolynomial))
+
+	dst := repository.TestRepository(t)
+	pol, err := chunker.RandomPolynomial()
+	rtest.OK(t, err)
+	loader := &countingLoader{Loader: src}
+	rc := New(loader, pol)
+
+	var newRoot restic.ID
+	rtest.OK(t, dst.WithBlobUploader(context.TODO(), func(ctx context.Context, uploader restic.BlobSaverWithAsync) error {
+		newRoot, _, err = rc.RewriteTree(ctx, uploader, root)
+		return err
+	}))
+	checkTree(t, dst, newRoot, files, pol)
+
+	// the data blobs are loaded from their pack files in a few requests
+	rtest.Equals(t, 0, loader.loadBlob)
+	rtest.Equals(t, blobs, loader.requestedBlob)
+	rtest.Assert(t, loader.loadFromPack < blobs, "expected fewer requests than %d blobs, got %d", blobs, loader.loadFromPack)
+}
+
+func TestRechunk(t *testing.T) {
+	random := rand.New(rand.NewSource(42))
+	large := make([]byte, 5*1024*1024)
+	random.Read(large)
+	files := map[string][]byte{
+		"large":  large,
+		"copy":   large,
+		"suffix": large[1000:],
+		"small":  large[:1000],
+		"empty":  nil,
+	}
+
+	src := repository.TestRepository(t)
+	root := createTestTree(t, src, files)
+
+	dst := repository.TestRepository(t)
+	pol, err := chunker.RandomPolynomial()
+	rtest.OK(t, err)
+	rc := New(src, pol)
+
+	var newRoot restic.ID
+	var stats Stats
+	rtest.OK(t, dst.WithBlobUploader(context.TODO(), func(ctx context.Context, uploader restic.BlobSaverWithAsync) error {
+		newRoot, stats, err = rc.RewriteTree(ctx, uploader, root)
+		return err
+	}))
+	// files with identical content are only rechunked once
+	rtest.Equals(t, Stats{Files: 3, Bytes: uint64(len(files["large"]) + len(files["suffix"]) + len(files["small"]))}, stats)
+	checkTree(t, dst, newRoot, files, pol)
+	repository.TestCheckRepo(t, dst)
+
+	// rewriting the tree again does not rechunk any files
+	rtest.OK(t, dst.WithBlobUploader(context.TODO(), func(ctx context.Context, uploader restic.BlobSaverWithAsync) error {
+		var root2 restic.ID
+		root2, stats, err = rc.RewriteTree(ctx, uploader, root)
+		rtest.Equals(t, newRoot, root2)
+		return err
+	}))
+	rtest.Equals(t, Stats{}, stats)
+}
@@ -7,6 +7,7 @@ import (
 	"crypto/sha256"
 	"fmt"
 	"io"
+	"time"
 
 	"github.com/klauspost/compress/zstd"
 	"github.com/restic/restic/internal/backend"
@@ -75,7 +76,8 @@ func (e *ErrPackData) Error() string {
 
 // Checker handles index-related operations for repository checking.
 type Checker struct {
-	repo *Repository
+	repo   *Repository
+	ledger *VerificationLedger
 }
 
 // newChecker creates a new Checker.
@@ -233,7 +235,13 @@ func (c *Checker) Packs(ctx context.Context, errChan chan<- error) {
 	}
 
 	// orphaned: present in the repo but not in c.packs
+	pending := c.repo.idx.PendingDelete()
 	for orphanID := range repoPacks {
+		if _, ok := pending[orphanID]; ok {
+			// will be removed by a later prune run
+			debug.Log("pack %v is pending deletion", orphanID)
+			continue
+		}
 		select {
 		case <-ctx.Done():
 			return
@@ -242,6 +250,31 @@ func (c *Checker) Packs(ctx context.Context, errChan chan<- error) {
 	}
 }
 
+// warmupPacks requests the backend to warm up the pack files and waits until
+// all of them can be read.
+func (c *Checker) warmupPacks(ctx context.Context, packs map[restic.ID]int64) error {
+	packSet := restic.NewIDSet()
+	for id := range packs {
+		packSet.Insert(id)
+	}
+
+	job, err := c.repo.StartWarmup(ctx, packSet)
+	if err != nil {
+		return err
+	}
+	if job.HandleCount() != 0 {
+		debug.Log("waiting for %d packs to warm up", job.HandleCount())
+		return job.Wait(ctx)
+	}
+	return nil
+}
+
+// SetVerificationLedger configures ReadPacks to record successfully verified
+// pack files in ledger. Pack files which no longer exist are removed from it.
+func (c *Checker) SetVerificationLedger(ledger *VerificationLedger) {
+	c.ledger = ledger
+}
+
 // ReadPacks loads data from specified packs and checks the integrity.
 func (c *Checker) ReadPacks(ctx context.Context, filter func(packs map[restic.ID]int64) map[restic.ID]int64, p restic.Counter, errChan chan<- error) {
 	defer close(errChan)
@@ -252,9 +285,19 @@ func (c *Checker) ReadPacks(ctx context.Context, filter func(packs map[restic.ID
 		errChan <- err
 		return
 	}
+	if c.ledger != nil {
+		c.ledger.Retain(packs)
+	}
 	packs = filter(packs)
 	p.SetMax(uint64(len(packs)))
 
+	if backend.WarmupEnabled() {
+		if err := c.warmupPacks(ctx, packs); err != nil {
+			errChan <- err
+			return
+		}
+	}
+
 	g, ctx := errgroup.WithContext(ctx)
 	type checkTask struct {
 		id    restic.ID
@@ -290,6 +333,9 @@ func (c *Checker) ReadPacks(ctx context.Context, filter func(packs map[restic.ID
 				err := checkPack(ctx, c.repo, ps.id, ps.blobs, ps.size, bufRd, dec)
 				p.Add(1)
 				if err == nil {
+					if c.ledger != nil {
+						c.ledger.Record(ps.id, time.Now())
+					}
 					continue
 				}
 
@@ -391,7 +437,7 @@ func checkPackInner(ctx context.Context, r *Repository, id restic.ID, blobs pack
 		// reset blob errors for each retry
 		blobErrors = nil
 
-		it := newPackBlobIterator(id, newBufReader(bufRd), 0, blobs, r.Key(), dec)
+		it := newPackBlobIterator(id, newBufReader(bufRd), 0, blobs, r.key, r.fallbackKey(), dec)
 		for {
 			if ctx.Err() != nil {
 				return ctx.Err()
@@ -451,7 +497,7 @@ func checkPackInner(ctx context.Context, r *Repository, id restic.ID, blobs pack
 		return &ErrPackData{PackID: id, errs: append(errs, errors.Errorf("unexpected pack id %v", hash))}
 	}
 
-	blobs, hdrSize, err := pack.List(r.Key(), bytes.NewReader(hdrBuf), int64(len(hdrBuf)))
+	blobs, hdrSize, err := listPackWithFallback(r.key, r.fallbackKey(), bytes.NewReader(hdrBuf), int64(len(hdrBuf)))
 	if err != nil {
 		return &ErrPackData{PackID: id, errs: append(errs, err)}
 	}
@@ -465,6 +511,8 @@ func checkPackInner(ctx context.Context, r *Repository, id restic.ID, blobs pack
 		// Check if blob is contained in index and position is correct
 		idxHas := false
 		for _, pb := range r.idx.Lookup(blob.BlobHandle) {
+			// the compression level is not stored in the pack header
+			blob.CompressionLevel = pb.Blob.CompressionLevel
 			if pb.PackID().Equal(id) && pb.Blob == blob {
 				idxHas = true
 				break
@@ -0,0 +1,111 @@
+package repository
+
+import (
+	"math"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/restic/restic/internal/restic"
+)
+
+const (
+	// incompressibleMinSize is the minimal size of a blob for which the
+	// compressibility is checked. Smaller blobs are always compressed.
+	incompressibleMinSize = 16 * 1024
+
+	// entropySamples samples of entropySampleSize bytes, evenly spread over
+	// the blob, are used to estimate the entropy.
+	entropySamples    = 8
+	entropySampleSize = 4 * 1024
+
+	// minIncompressibleEntropy is the entropy in bits per byte above which the
+	// compression ratio of a prefix of the blob is checked.
+	minIncompressibleEntropy = 7.5
+
+	// ratioPrefixSize bytes at the start of a blob are compressed to check
+	// whether compression is worthwhile.
+	ratioPrefixSize = 64 * 1024
+
+	// maxIncompressibleRatio is the compressed to uncompressed size ratio of
+	// the prefix above which a blob is considered incompressible.
+	maxIncompressibleRatio = 0.97
+)
+
+// sampleEntropy estimates the Shannon entropy of data in bits per byte from
+// samples spread over data.
+func sampleEntropy(data []byte) float64 {
+	var counts [256]uint64
+	var total uint64
+
+	step := len(data) / entropySamples
+	for i := 0; i < entropySamples; i++ {
+		sample := data[i*step:]
+		if len(sample) > entropySampleSize {
+			sample = sample[:entropySampleSize]
+		}
+		for _, b := range sample {
+			counts[b]++
+		}
+		total += uint64(len(sample))
+	}
+
+	var entropy float64
+	for _, c := range counts {
+		if c == 0 {
+			continue
+		}
+		p := float64(c) / float64(total)
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
+
+// incompressible returns true if compressing data with enc is unlikely to
+// reduce its size noticeably. The entropy of the data is estimated first, only
+// for high entropy data the compression ratio of a prefix is checked.
+func incompressible(enc *zstd.Encoder, data []byte) bool {
+	if len(data) < incompressibleMinSize {
+		return false
+	}
+	if sampleEntropy(data) < minIncompressibleEntropy {
+		return false
+	}
+
+	prefix := data
+	if len(prefix) > ratioPrefixSize {
+		prefix = prefix[:ratioPrefixSize]
+	}
+	compressed := enc.EncodeAll(prefix, make([]byte, 0, len(prefix)))
+	return float64(len(compressed)) > maxIncompressibleRatio*float64(len(prefix))
+}
+
+// compressBlob returns whether the blob data of type t is compressed. skipped
+// is true if compression is enabled but the blob is stored uncompressed.
+func (r *Repository) compressBlob(t restic.BlobType, data []byte, hint restic.CompressionHint) (compress bool, skipped bool) {
+	// uncompressedLength != 0 is used to indicate compressed data. Thus, a zero-sized blob
+	// cannot be compressed. This special case is only relevant for tests, normal operation does not
+	// generate zero-sized blobs.
+	if r.cfg.Version < 2 || len(data) == 0 {
+		return false, false
+	}
+	// if the user opts to not compress, we won't compress any data, but
+	// everything else is compressed.
+	if t != restic.DataBlob {
+		return true, false
+	}
+	if r.opts.Compression == CompressionOff {
+		return false, false
+	}
+
+	switch hint {
+	case restic.CompressionHintSkip:
+		return false, true
+	case restic.CompressionHintForce:
+		return true, false
+	}
+	// only the auto mode detects incompressible data, the other modes
+	// compress everything as requested
+	if r.opts.Compression == CompressionAuto && incompressible(r.getZstdEncoder(), data) {
+		return false, true
+	}
+	return true, false
+}
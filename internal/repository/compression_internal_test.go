@@ -0,0 +1,84 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestIncompressible(t *testing.T) {
+	enc, err := zstd.NewWriter(nil)
+	rtest.OK(t, err)
+	defer func() { _ = enc.Close() }()
+
+	random := rtest.Random(23, 512*1024)
+	// high entropy, but repetitions within the checked prefix
+	repeated := bytes.Repeat(random[:8*1024], 64)
+
+	for _, test := range []struct {
+		name string
+		data []byte
+		want bool
+	}{
+		{"random", random, true},
+		{"small-random", random[:1024], false},
+		{"zeros", make([]byte, 512*1024), false},
+		{"text", bytes.Repeat([]byte("the quick brown fox jumps over the lazy dog\n"), 10000), false},
+		{"repeated-random", repeated, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			rtest.Equals(t, test.want, incompressible(enc, test.data))
+		})
+	}
+}
+
+func TestSaveBlobCompressionHint(t *testing.T) {
+	random := rtest.Random(42, 512*1024)
+	text := bytes.Repeat([]byte("some compressible text\n"), 20000)
+
+	for _, test := range []struct {
+		mode           CompressionMode
+		data           []byte
+		hint           restic.CompressionHint
+		wantCompressed bool
+		wantSkipped    bool
+	}{
+		{CompressionAuto, random, restic.CompressionHintAuto, false, true},
+		{CompressionAuto, text, restic.CompressionHintAuto, true, false},
+		{CompressionAuto, random, restic.CompressionHintForce, true, false},
+		{CompressionAuto, text, restic.CompressionHintSkip, false, true},
+		{CompressionMax, random, restic.CompressionHintAuto, true, false},
+		{CompressionOff, text, restic.CompressionHintForce, false, false},
+	} {
+		t.Run("", func(t *testing.T) {
+			repo, _ := TestRepositoryWithBackend(t, nil, 2, Options{Compression: test.mode})
+
+			var id restic.ID
+			var skipped bool
+			rtest.OK(t, repo.WithBlobUploader(context.TODO(), func(ctx context.Context, uploader restic.BlobSaverWithAsync) error {
+				hintSaver, ok := uploader.(restic.BlobSaverWithHint)
+				rtest.Assert(t, ok, "uploader does not support compression hints")
+
+				done := make(chan error, 1)
+				hintSaver.SaveBlobAsyncWithHint(ctx, restic.DataBlob, test.data, restic.ID{}, false, test.hint, func(newID restic.ID, _ bool, _ int, skippedCompression bool, err error) {
+					id, skipped = newID, skippedCompression
+					done <- err
+				})
+				return <-done
+			}))
+
+			blobs := repo.LookupBlob(restic.BlobHandle{Type: restic.DataBlob, ID: id})
+			rtest.Equals(t, 1, len(blobs))
+			rtest.Equals(t, test.wantCompressed, blobs[0].IsCompressed())
+			rtest.Equals(t, test.wantSkipped, skipped)
+
+			buf, err := repo.LoadBlob(context.TODO(), restic.BlobHandle{Type: restic.DataBlob, ID: id}, nil)
+			rtest.OK(t, err)
+			rtest.Assert(t, bytes.Equal(test.data, buf), "loaded blob differs from saved data")
+		})
+	}
+}
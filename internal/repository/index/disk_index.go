@@ -0,0 +1,601 @@
+package index
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"container/heap"
+	"context"
+	"encoding/binary"
+	"iter"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// An on-disk index uses the binary index format without delta encoded IDs,
+// such that the entries can be accessed directly. If diskIndexFlagIndexIDs is
+// set, the entries are followed by the list of the IDs of the index files
+// contained in the on-disk index:
+//
+//	numIDs  uint32
+//	ids     [numIDs][32]byte, sorted
+//
+// The compression levels are stored as in the binary index format if
+// binaryIndexFlagCompressionLevels is set.
+//
+// On-disk indexes are only stored locally and never uploaded to a repository.
+const diskIndexFlagIndexIDs = 1 << 1
+
+// diskIndexRunBlobs is the maximum number of blobs which are collected in
+// memory while building an on-disk index before they are written to a
+// temporary file.
+var diskIndexRunBlobs = uint(1 << 20)
+
+const idSize = len(restic.ID{})
+
+// diskIndex is a read-only index stored in a memory-mapped file. As the
+// entries are sorted by blob ID, they are found using a binary search. Only
+// the accessed parts of the file are loaded into memory, which the operating
+// system can reclaim at any time.
+type diskIndex struct {
+	unmap  func() error
+	packs  []byte
+	byType [restic.NumBlobTypes][]byte
+	levels [restic.NumBlobTypes][]byte
+	ids    restic.IDs
+}
+
+// openDiskIndex maps the on-disk index stored in filename into memory.
+func openDiskIndex(filename string) (*diskIndex, error) {
+	buf, unmap, err := mmapFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := parseDiskIndex(buf)
+	if err != nil {
+		_ = unmap()
+		return nil, errors.Wrapf(err, "invalid on-disk index %v", filename)
+	}
+	d.unmap = unmap
+	return d, nil
+}
+
+func parseDiskIndex(buf []byte) (*diskIndex, error) {
+	if len(buf) < binaryIndexHeaderSize+4 || !IsBinaryIndex(buf) {
+		return nil, errors.New("invalid binary index header")
+	}
+	if buf[4] != binaryIndexVersion {
+		return nil, errors.Errorf("unsupported binary index version %d", buf[4])
+	}
+	flags := buf[5]
+	if flags&^(diskIndexFlagIndexIDs|binaryIndexFlagCompressionLevels) != 0 || buf[6] != 0 || buf[7] != 0 {
+		return nil, errors.Errorf("unsupported binary index flags %#x", buf[5:8])
+	}
+	buf = buf[binaryIndexHeaderSize:]
+
+	// section returns the next section containing a count followed by
+	// that many elements of the given size.
+	section := func(elemSize int) ([]byte, error) {
+		if len(buf) < 4 {
+			return nil, errors.New("binary index truncated")
+		}
+		size := uint64(binary.LittleEndian.Uint32(buf)) * uint64(elemSize)
+		buf = buf[4:]
+		if uint64(len(buf)) < size {
+			return nil, errors.New("binary index truncated")
+		}
+		s := buf[:size:size]
+		buf = buf[size:]
+		return s, nil
+	}
+
+	d := &diskIndex{}
+	var err error
+	d.packs, err = section(idSize)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range binaryIndexBlobTypes {
+		d.byType[t], err = section(binaryIndexEntrySize)
+		if err != nil {
+			return nil, err
+		}
+		if flags&binaryIndexFlagCompressionLevels != 0 {
+			n := len(d.byType[t]) / binaryIndexEntrySize
+			if len(buf) < n {
+				return nil, errors.New("binary index truncated")
+			}
+			d.levels[t] = buf[:n:n]
+			buf = buf[n:]
+		}
+	}
+
+	if flags&diskIndexFlagIndexIDs != 0 {
+		ids, err := section(idSize)
+		if err != nil {
+			return nil, err
+		}
+		d.ids = make(restic.IDs, len(ids)/idSize)
+		for i := range d.ids {
+			copy(d.ids[i][:], ids[i*idSize:])
+		}
+	}
+
+	if len(buf) != 0 {
+		return nil, errors.Errorf("binary index contains %d trailing bytes", len(buf))
+	}
+	return d, nil
+}
+
+// Close unmaps the on-disk index. It must not be used afterwards.
+func (d *diskIndex) Close() error {
+	d.packs = nil
+	d.byType = [restic.NumBlobTypes][]byte{}
+	d.levels = [restic.NumBlobTypes][]byte{}
+	return d.unmap()
+}
+
+func (d *diskIndex) numPacks() int {
+	return len(d.packs) / idSize
+}
+
+func (d *diskIndex) packID(i int) restic.ID {
+	if i < 0 || i >= d.numPacks() {
+		panic("on-disk index contains invalid pack index")
+	}
+	var id restic.ID
+	copy(id[:], d.packs[i*idSize:])
+	return id
+}
+
+func (d *diskIndex) len(t restic.BlobType) uint {
+	return uint(len(d.byType[t]) / binaryIndexEntrySize)
+}
+
+func (d *diskIndex) entryID(t restic.BlobType, i int) []byte {
+	pos := i * binaryIndexEntrySize
+	return d.byType[t][pos : pos+idSize]
+}
+
+// entry decodes the i-th entry of blob type t.
+func (d *diskIndex) entry(t restic.BlobType, i int) *indexEntry {
+	buf := d.byType[t][i*binaryIndexEntrySize:]
+	e := &indexEntry{
+		packIndex:          int(binary.LittleEndian.Uint32(buf[32:])),
+		offset:             binary.LittleEndian.Uint32(buf[36:]),
+		length:             binary.LittleEndian.Uint32(buf[40:]),
+		uncompressedLength: binary.LittleEndian.Uint32(buf[44:]),
+	}
+	copy(e.id[:], buf)
+	if d.levels[t] != nil {
+		e.compressionLevel = d.levels[t][i]
+	}
+	return e
+}
+
+// search returns the position of the first entry of blob type t with the
+// given id or -1 if there is none.
+func (d *diskIndex) search(t restic.BlobType, id restic.ID) int {
+	n := int(d.len(t))
+	i := sort.Search(n, func(i int) bool {
+		return bytes.Compare(d.entryID(t, i), id[:]) >= 0
+	})
+	if i < n && bytes.Equal(d.entryID(t, i), id[:]) {
+		return i
+	}
+	return -1
+}
+
+// values returns an iterator over all entries of blob type t.
+func (d *diskIndex) values(t restic.BlobType) iter.Seq[*indexEntry] {
+	return func(yield func(*indexEntry) bool) {
+		n := int(d.len(t))
+		for i := 0; i < n; i++ {
+			if !yield(d.entry(t, i)) {
+				return
+			}
+		}
+	}
+}
+
+// valuesWithID returns an iterator over all entries of blob type t with the
+// given id.
+func (d *diskIndex) valuesWithID(t restic.BlobType, id restic.ID) iter.Seq[*indexEntry] {
+	return func(yield func(*indexEntry) bool) {
+		i := d.search(t, id)
+		if i < 0 {
+			return
+		}
+		n := int(d.len(t))
+		for ; i < n && bytes.Equal(d.entryID(t, i), id[:]); i++ {
+			if !yield(d.entry(t, i)) {
+				return
+			}
+		}
+	}
+}
+
+// get returns the first entry of blob type t with the given id.
+func (d *diskIndex) get(t restic.BlobType, id restic.ID) *indexEntry {
+	i := d.search(t, id)
+	if i < 0 {
+		return nil
+	}
+	return d.entry(t, i)
+}
+
+// firstIndex returns the index of the first entry for ID id. Like for
+// indexMap, the first entry has index 1.
+func (d *diskIndex) firstIndex(t restic.BlobType, id restic.ID) int {
+	i := d.search(t, id)
+	if i < 0 {
+		return -1
+	}
+	return i + 1
+}
+
+// mergeCursor points to the current element of one of the inputs of mergeSorted.
+type mergeCursor struct {
+	input, pos, end int
+}
+
+type mergeHeap struct {
+	cursors []mergeCursor
+	less    func(a, b mergeCursor) bool
+}
+
+func (h *mergeHeap) Len() int           { return len(h.cursors) }
+func (h *mergeHeap) Less(i, j int) bool { return h.less(h.cursors[i], h.cursors[j]) }
+func (h *mergeHeap) Swap(i, j int)      { h.cursors[i], h.cursors[j] = h.cursors[j], h.cursors[i] }
+func (h *mergeHeap) Push(x any)         { h.cursors = append(h.cursors, x.(mergeCursor)) }
+func (h *mergeHeap) Pop() any {
+	c := h.cursors[len(h.cursors)-1]
+	h.cursors = h.cursors[:len(h.cursors)-1]
+	return c
+}
+
+// mergeSorted calls fn for all elements of the inputs in sorted order. Input i
+// contains counts[i] elements, which must be sorted according to less.
+func mergeSorted(counts []int, less func(a, b mergeCursor) bool, fn func(input, pos int) error) error {
+	h := &mergeHeap{less: less}
+	for i, n := range counts {
+		if n > 0 {
+			h.cursors = append(h.cursors, mergeCursor{input: i, end: n})
+		}
+	}
+	heap.Init(h)
+
+	for h.Len() > 0 {
+		c := h.cursors[0]
+		if err := fn(c.input, c.pos); err != nil {
+			return err
+		}
+		c.pos++
+		if c.pos < c.end {
+			h.cursors[0] = c
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+		}
+	}
+	return nil
+}
+
+// writeDiskIndex merges the on-disk indexes in inputs into a new temporary
+// file in dir, which contains the index IDs ids. Exact duplicates are removed.
+// The name of the file is returned.
+func writeDiskIndex(dir string, inputs []*diskIndex, ids restic.IDs) (filename string, err error) {
+	f, err := os.CreateTemp(dir, "tmp-index-")
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	defer func() {
+		if err != nil {
+			_ = f.Close()
+			_ = os.Remove(f.Name())
+		}
+	}()
+
+	wr := bufio.NewWriterSize(f, 1<<20)
+	var pos int64
+	write := func(buf []byte) error {
+		n, err := wr.Write(buf)
+		pos += int64(n)
+		return err
+	}
+	// the number of elements in a section is only known after writing it
+	var counts []struct {
+		pos   int64
+		count uint32
+	}
+	startSection := func() error {
+		counts = append(counts, struct {
+			pos   int64
+			count uint32
+		}{pos: pos})
+		return write(make([]byte, 4))
+	}
+
+	var flags byte = diskIndexFlagIndexIDs
+	for _, d := range inputs {
+		for _, t := range binaryIndexBlobTypes {
+			if d.levels[t] != nil {
+				flags |= binaryIndexFlagCompressionLevels
+			}
+		}
+	}
+	hasLevels := flags&binaryIndexFlagCompressionLevels != 0
+
+	header := append([]byte{}, binaryIndexMagic...)
+	header = append(header, binaryIndexVersion, flags, 0, 0)
+	if err := write(header); err != nil {
+		return "", err
+	}
+
+	// merge the pack lists, packIndex maps the packs of the inputs to the new list
+	if err := startSection(); err != nil {
+		return "", err
+	}
+	packIndex := make([][]uint32, len(inputs))
+	packCounts := make([]int, len(inputs))
+	for i, d := range inputs {
+		packCounts[i] = d.numPacks()
+		packIndex[i] = make([]uint32, packCounts[i])
+	}
+	var lastPack []byte
+	err = mergeSorted(packCounts, func(a, b mergeCursor) bool {
+		return bytes.Compare(inputs[a.input].packs[a.pos*idSize:(a.pos+1)*idSize], inputs[b.input].packs[b.pos*idSize:(b.pos+1)*idSize]) < 0
+	}, func(input, i int) error {
+		id := inputs[input].packs[i*idSize : (i+1)*idSize]
+		if !bytes.Equal(id, lastPack) {
+			lastPack = id
+			counts[0].count++
+			if err := write(id); err != nil {
+				return err
+			}
+		}
+		packIndex[input][i] = counts[0].count - 1
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	for _, t := range binaryIndexBlobTypes {
+		if err := startSection(); err != nil {
+			return "", err
+		}
+		section := &counts[len(counts)-1]
+
+		blobCounts := make([]int, len(inputs))
+		for i, d := range inputs {
+			blobCounts[i] = int(d.len(t))
+		}
+		raw := func(c mergeCursor) []byte {
+			pos := c.pos * binaryIndexEntrySize
+			return inputs[c.input].byType[t][pos : pos+binaryIndexEntrySize]
+		}
+		newPackIndex := func(c mergeCursor) uint32 {
+			return packIndex[c.input][binary.LittleEndian.Uint32(raw(c)[idSize:])]
+		}
+
+		level := func(c mergeCursor) byte {
+			if levels := inputs[c.input].levels[t]; levels != nil {
+				return levels[c.pos]
+			}
+			return 0
+		}
+
+		// the compression levels follow all entries of the blob type and are
+		// therefore collected in memory
+		var buf, last, levels []byte
+		err = mergeSorted(blobCounts, func(a, b mergeCursor) bool {
+			ea, eb := raw(a), raw(b)
+			if c := bytes.Compare(ea[:idSize], eb[:idSize]); c != 0 {
+				return c < 0
+			}
+			// the mapping of pack indexes preserves their order
+			if c := cmp.Compare(newPackIndex(a), newPackIndex(b)); c != 0 {
+				return c < 0
+			}
+			return binary.LittleEndian.Uint32(ea[idSize+4:]) < binary.LittleEndian.Uint32(eb[idSize+4:])
+		}, func(input, i int) error {
+			c := mergeCursor{input: input, pos: i}
+			buf = append(buf[:0], raw(c)...)
+			binary.LittleEndian.PutUint32(buf[idSize:], newPackIndex(c))
+			buf = append(buf, level(c))
+			if bytes.Equal(buf, last) {
+				return nil
+			}
+			buf, last = last, buf
+			section.count++
+			if hasLevels {
+				levels = append(levels, last[binaryIndexEntrySize])
+			}
+			return write(last[:binaryIndexEntrySize])
+		})
+		if err != nil {
+			return "", err
+		}
+		if err := write(levels); err != nil {
+			return "", err
+		}
+	}
+
+	ids = append(restic.IDs{}, ids...)
+	sort.Sort(ids)
+	if err := write(binary.LittleEndian.AppendUint32(nil, uint32(len(ids)))); err != nil {
+		return "", err
+	}
+	for _, id := range ids {
+		if err := write(id[:]); err != nil {
+			return "", err
+		}
+	}
+
+	if err := wr.Flush(); err != nil {
+		return "", errors.WithStack(err)
+	}
+	for _, c := range counts {
+		if _, err := f.WriteAt(binary.LittleEndian.AppendUint32(nil, c.count), c.pos); err != nil {
+			return "", errors.WithStack(err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		return "", errors.WithStack(err)
+	}
+	return f.Name(), nil
+}
+
+// idSetLister only lists the files whose ID is contained in ids.
+type idSetLister struct {
+	lister restic.Lister
+	ids    restic.IDSet
+}
+
+func (l *idSetLister) List(ctx context.Context, t restic.FileType, fn func(restic.ID, int64) error) error {
+	return l.lister.List(ctx, t, func(id restic.ID, size int64) error {
+		if !l.ids.Has(id) {
+			return nil
+		}
+		return fn(id, size)
+	})
+}
+
+// buildDiskIndex stores the on-disk index base merged with the index files
+// with the given ids in filename and returns the new on-disk index. base is
+// closed unless it is returned unmodified. To keep the memory usage bounded,
+// the index files are collected in batches of at most diskIndexRunBlobs blobs,
+// which are written to temporary files before merging them.
+//
+// Index files which contain packs pending deletion are not added to the
+// on-disk index, but returned instead. They are loaded again each time.
+func buildDiskIndex(ctx context.Context, lister restic.Lister, repo restic.LoaderUnpacked, filename string, base *diskIndex, ids restic.IDSet,
+	p restic.Counter, cb func(id restic.ID, idx *Index, err error) error) (result *diskIndex, pendingDelete []*Index, err error) {
+
+	dir := filepath.Dir(filename)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		if base != nil {
+			_ = base.Close()
+		}
+		return nil, nil, errors.WithStack(err)
+	}
+
+	var inputs []*diskIndex
+	covered := restic.NewIDSet()
+	if base != nil {
+		inputs = append(inputs, base)
+		for _, id := range base.ids {
+			covered.Insert(id)
+		}
+	}
+	var tempFiles []string
+	defer func() {
+		for _, d := range inputs {
+			if cerr := d.Close(); cerr != nil && err == nil {
+				err = cerr
+			}
+		}
+		for _, name := range tempFiles {
+			_ = os.Remove(name)
+		}
+	}()
+
+	run := NewIndex()
+	writeRun := func() error {
+		if run.len(restic.DataBlob)+run.len(restic.TreeBlob) == 0 {
+			return nil
+		}
+		debug.Log("writing run with %d data and %d tree blobs", run.len(restic.DataBlob), run.len(restic.TreeBlob))
+
+		f, err := os.CreateTemp(dir, "tmp-index-run-")
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		tempFiles = append(tempFiles, f.Name())
+		wr := bufio.NewWriterSize(f, 1<<20)
+		err = run.encodeBinary(wr, false)
+		if err == nil {
+			err = wr.Flush()
+		}
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		d, err := openDiskIndex(f.Name())
+		if err != nil {
+			return err
+		}
+		inputs = append(inputs, d)
+		run = NewIndex()
+		return nil
+	}
+
+	err = ForAllIndexes(ctx, &idSetLister{lister: lister, ids: ids}, repo, func(id restic.ID, idx *Index, err error) error {
+		p.Add(1)
+		if cb != nil {
+			err = cb(id, idx, err)
+		}
+		if err != nil {
+			return err
+		}
+		// special case to allow check to ignore index loading errors
+		if idx == nil {
+			return nil
+		}
+		if idx.hasPendingDelete() {
+			pendingDelete = append(pendingDelete, idx)
+			return nil
+		}
+
+		if err := run.merge(idx); err != nil {
+			return err
+		}
+		covered.Insert(id)
+		if run.len(restic.DataBlob)+run.len(restic.TreeBlob) >= diskIndexRunBlobs {
+			return writeRun()
+		}
+		return nil
+	})
+	if err == nil {
+		err = writeRun()
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if base != nil && len(inputs) == 1 && len(covered) == len(base.ids) {
+		// only index files with packs pending deletion were loaded
+		inputs = nil
+		return base, pendingDelete, nil
+	}
+
+	tempFile, err := writeDiskIndex(dir, inputs, covered.List())
+	if err != nil {
+		return nil, nil, err
+	}
+	tempFiles = append(tempFiles, tempFile)
+
+	// the inputs include the old on-disk index, which must be closed before replacing it
+	for _, d := range inputs {
+		if err := d.Close(); err != nil {
+			return nil, nil, err
+		}
+	}
+	inputs = nil
+	if err := os.Rename(tempFile, filename); err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+
+	result, err = openDiskIndex(filename)
+	return result, pendingDelete, err
+}
@@ -0,0 +1,234 @@
+package index
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository/pack"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+	"github.com/restic/restic/internal/ui/progress"
+)
+
+// memIndexRepo stores index files in memory.
+type memIndexRepo struct {
+	files map[restic.ID][]byte
+}
+
+func (r *memIndexRepo) Connections() uint {
+	return 2
+}
+
+func (r *memIndexRepo) LoadUnpacked(_ context.Context, _ restic.FileType, id restic.ID) ([]byte, error) {
+	buf, ok := r.files[id]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return buf, nil
+}
+
+func (r *memIndexRepo) List(_ context.Context, _ restic.FileType, fn func(restic.ID, int64) error) error {
+	for id, buf := range r.files {
+		if err := fn(id, int64(len(buf))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *memIndexRepo) SaveUnpacked(_ context.Context, _ restic.FileType, buf []byte) (restic.ID, error) {
+	id := restic.Hash(buf)
+	r.files[id] = buf
+	return id, nil
+}
+
+func (r *memIndexRepo) add(t testing.TB, idx *Index) restic.ID {
+	var buf bytes.Buffer
+	rtest.OK(t, idx.EncodeBinary(&buf))
+	id := restic.Hash(buf.Bytes())
+	r.files[id] = buf.Bytes()
+	return id
+}
+
+func collectIndex(idx *MasterIndex) map[pack.PackedBlob]int {
+	m := make(map[pack.PackedBlob]int)
+	for pb := range idx.Values() {
+		m[*pb]++
+	}
+	return m
+}
+
+// lookupValues returns the sorted results of a lookup.
+func lookupValues(mi *MasterIndex, bh restic.BlobHandle) []pack.PackedBlob {
+	var list []pack.PackedBlob
+	for _, pb := range mi.Lookup(bh) {
+		list = append(list, *pb)
+	}
+	slices.SortFunc(list, func(a, b pack.PackedBlob) int {
+		return bytes.Compare(a.Pack[:], b.Pack[:])
+	})
+	return list
+}
+
+// loadMasterIndex loads the index and returns the number of loaded index files.
+func loadMasterIndex(t *testing.T, repo *memIndexRepo, filename string) (*MasterIndex, uint64) {
+	mi := NewMasterIndex()
+	if filename != "" {
+		mi.SetDiskIndexFile(filename)
+	}
+	p := progress.NewCounter(0, 0, nil)
+	rtest.OK(t, mi.Load(context.TODO(), repo, p, nil))
+	loaded, _ := p.Get()
+	return mi, loaded
+}
+
+func TestDiskIndex(t *testing.T) {
+	defer func(n uint) {
+		diskIndexRunBlobs = n
+	}(diskIndexRunBlobs)
+	// force writing multiple runs
+	diskIndexRunBlobs = 100
+
+	repo := &memIndexRepo{files: make(map[restic.ID][]byte)}
+	first := createBinaryTestIndex()
+	repo.add(t, first)
+	repo.add(t, createBinaryTestIndex())
+	// a duplicate index file only contains exact duplicates
+	var buf bytes.Buffer
+	rtest.OK(t, first.Encode(&buf))
+	repo.files[restic.Hash(buf.Bytes())] = buf.Bytes()
+
+	filename := filepath.Join(t.TempDir(), "index.bin")
+	mem, _ := loadMasterIndex(t, repo, "")
+	disk, loaded := loadMasterIndex(t, repo, filename)
+	rtest.Equals(t, uint64(3), loaded)
+	rtest.Assert(t, disk.idx[0].disk != nil, "on-disk index not used")
+	rtest.Equals(t, mem.IDs(), disk.IDs())
+	rtest.Equals(t, mem.Packs(nil), disk.Packs(nil))
+
+	expected := collectIndex(mem)
+	rtest.Equals(t, expected, collectIndex(disk))
+	for pb := range expected {
+		bh := pb.Handle()
+		rtest.Equals(t, lookupValues(mem, bh), lookupValues(disk, bh))
+		size, found := disk.LookupSize(bh)
+		rtest.Assert(t, found, "blob %v not found", bh)
+		memSize, _ := mem.LookupSize(bh)
+		rtest.Equals(t, memSize, size)
+		rtest.Assert(t, disk.blobIndex(bh) > 0, "blob %v has no index", bh)
+	}
+	unknown := restic.NewRandomBlobHandle()
+	rtest.Equals(t, 0, len(disk.Lookup(unknown)))
+	rtest.Assert(t, disk.AddPending(unknown, 42), "unknown blob not added as pending")
+	rtest.Equals(t, -1, disk.blobIndex(unknown))
+	for _, typ := range []restic.BlobType{restic.DataBlob, restic.TreeBlob} {
+		rtest.Equals(t, mem.stableLen(typ), disk.stableLen(typ))
+	}
+
+	// new indexes are merged into the second index
+	newIdx := NewIndex()
+	newIdx.StorePack(restic.NewRandomID(), pack.Blobs{{BlobHandle: unknown, Length: 42}})
+	newIdx.Finalize()
+	rtest.OK(t, newIdx.SetID(restic.NewRandomID()))
+	disk.Insert(newIdx)
+	rtest.OK(t, disk.MergeFinalIndexes())
+	rtest.Equals(t, 2, len(disk.idx))
+	rtest.Equals(t, 1, len(disk.Lookup(unknown)))
+
+	// reloading uses the existing on-disk index and only loads new index files
+	repo.add(t, newIdx)
+	disk, loaded = loadMasterIndex(t, repo, filename)
+	rtest.Equals(t, uint64(1), loaded)
+	mem, _ = loadMasterIndex(t, repo, "")
+	rtest.Equals(t, mem.IDs(), disk.IDs())
+	rtest.Equals(t, collectIndex(mem), collectIndex(disk))
+	rtest.Equals(t, 1, len(disk.Lookup(unknown)))
+	_, loaded = loadMasterIndex(t, repo, filename)
+	rtest.Equals(t, uint64(0), loaded)
+
+	// the callback used by check requires loading all index files
+	disk = NewMasterIndex()
+	disk.SetDiskIndexFile(filename)
+	loaded = 0
+	rtest.OK(t, disk.Load(context.TODO(), repo, restic.NoopCounter, func(_ restic.ID, _ *Index, err error) error {
+		loaded++
+		return err
+	}))
+	rtest.Equals(t, uint64(4), loaded)
+
+	// removing an index file requires a rebuild
+	delete(repo.files, restic.Hash(buf.Bytes()))
+	disk, loaded = loadMasterIndex(t, repo, filename)
+	rtest.Equals(t, uint64(3), loaded)
+	mem, _ = loadMasterIndex(t, repo, "")
+	rtest.Equals(t, mem.IDs(), disk.IDs())
+	rtest.Equals(t, collectIndex(mem), collectIndex(disk))
+
+	// no temporary files are left behind
+	files, err := os.ReadDir(filepath.Dir(filename))
+	rtest.OK(t, err)
+	rtest.Equals(t, 1, len(files))
+}
+
+func TestDiskIndexInvalid(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "index.bin")
+
+	var buf bytes.Buffer
+	rtest.OK(t, createBinaryTestIndex().EncodeBinary(&buf))
+	rtest.OK(t, os.WriteFile(filename, buf.Bytes(), 0o600))
+	_, err := openDiskIndex(filename)
+	rtest.Assert(t, err != nil && strings.Contains(err.Error(), "unsupported binary index flags"), "unexpected error %v", err)
+
+	truncated := slices.Clone(buf.Bytes()[:100])
+	truncated[5] = 0
+	rtest.OK(t, os.WriteFile(filename, truncated, 0o600))
+	_, err = openDiskIndex(filename)
+	rtest.Assert(t, err != nil && strings.Contains(err.Error(), "truncated"), "unexpected error %v", err)
+
+	// an invalid on-disk index is replaced
+	repo := &memIndexRepo{files: make(map[restic.ID][]byte)}
+	repo.add(t, createBinaryTestIndex())
+	_, loaded := loadMasterIndex(t, repo, filename)
+	rtest.Equals(t, uint64(1), loaded)
+	d, err := openDiskIndex(filename)
+	rtest.OK(t, err)
+	rtest.Equals(t, 1, len(d.ids))
+	rtest.OK(t, d.Close())
+}
+
+func TestDiskIndexPendingDelete(t *testing.T) {
+	repo := &memIndexRepo{files: make(map[restic.ID][]byte)}
+	repo.add(t, createBinaryTestIndex())
+
+	pending := NewIndex()
+	pending.AddPendingDelete(PendingDeletePack{PackID: restic.NewRandomID()})
+	pending.Finalize()
+	var buf bytes.Buffer
+	rtest.OK(t, pending.Encode(&buf))
+	repo.files[restic.Hash(buf.Bytes())] = buf.Bytes()
+
+	filename := filepath.Join(t.TempDir(), "index.bin")
+	disk, loaded := loadMasterIndex(t, repo, filename)
+	rtest.Equals(t, uint64(2), loaded)
+	mem, _ := loadMasterIndex(t, repo, "")
+	rtest.Equals(t, mem.IDs(), disk.IDs())
+	rtest.Equals(t, mem.PendingDelete(), disk.PendingDelete())
+	rtest.Equals(t, 1, len(disk.PendingDelete()))
+	rtest.Equals(t, collectIndex(mem), collectIndex(disk))
+
+	// index files with packs pending deletion are not stored in the on-disk index
+	d, err := openDiskIndex(filename)
+	rtest.OK(t, err)
+	rtest.Equals(t, 1, len(d.ids))
+	rtest.OK(t, d.Close())
+
+	disk, loaded = loadMasterIndex(t, repo, filename)
+	rtest.Equals(t, uint64(1), loaded)
+	rtest.Equals(t, 1, len(disk.PendingDelete()))
+}
@@ -57,6 +57,12 @@ type Index struct {
 	final   bool       // set to true for all indexes read from the backend ("finalized")
 	ids     restic.IDs // set to the IDs of the contained finalized indexes
 	created time.Time
+
+	// disk is set for a read-only index which is stored on disk. byType and
+	// packs are empty in this case.
+	disk *diskIndex
+
+	pendingDelete []PendingDeletePack
 }
 
 // NewIndex returns a new index.
@@ -80,7 +86,7 @@ func (idx *Index) store(packIndex int, blob pack.Blob) {
 	}
 
 	m := &idx.byType[blob.Type]
-	m.add(blob.ID, packIndex, uint32(blob.Offset), uint32(blob.Length), uint32(blob.UncompressedLength))
+	m.add(blob.ID, packIndex, uint32(blob.Offset), uint32(blob.Length), uint32(blob.UncompressedLength), blob.CompressionLevel)
 }
 
 // Final returns true iff the index is already written to the repository, it is
@@ -162,9 +168,41 @@ func (idx *Index) StorePack(id restic.ID, blobs pack.Blobs) {
 	}
 }
 
+// entries returns an iterator over all entries of blob type t.
+func (idx *Index) entries(t restic.BlobType) iter.Seq[*indexEntry] {
+	if idx.disk != nil {
+		return idx.disk.values(t)
+	}
+	return idx.byType[t].values()
+}
+
+// entriesWithID returns an iterator over all entries of blob type t with the given id.
+func (idx *Index) entriesWithID(t restic.BlobType, id restic.ID) iter.Seq[*indexEntry] {
+	if idx.disk != nil {
+		return idx.disk.valuesWithID(t, id)
+	}
+	return idx.byType[t].valuesWithID(id)
+}
+
+// firstEntry returns the first entry of blob type t with the given id.
+func (idx *Index) firstEntry(t restic.BlobType, id restic.ID) *indexEntry {
+	if idx.disk != nil {
+		return idx.disk.get(t, id)
+	}
+	return idx.byType[t].get(id)
+}
+
+// packID returns the ID of the pack referenced by an indexEntry.
+func (idx *Index) packID(packIndex int) restic.ID {
+	if idx.disk != nil {
+		return idx.disk.packID(packIndex)
+	}
+	return idx.packs[packIndex]
+}
+
 func (idx *Index) toPackedBlob(e *indexEntry, t restic.BlobType) *pack.PackedBlob {
 	return &pack.PackedBlob{
-		Pack: idx.packs[e.packIndex],
+		Pack: idx.packID(e.packIndex),
 		Blob: pack.Blob{
 			BlobHandle: restic.BlobHandle{
 				ID:   e.id,
@@ -173,6 +211,7 @@ func (idx *Index) toPackedBlob(e *indexEntry, t restic.BlobType) *pack.PackedBlo
 			Length:             uint(e.length),
 			Offset:             uint(e.offset),
 			UncompressedLength: uint(e.uncompressedLength),
+			CompressionLevel:   e.compressionLevel,
 		},
 	}
 }
@@ -183,7 +222,7 @@ func (idx *Index) Lookup(bh restic.BlobHandle, pbs []*pack.PackedBlob) []*pack.P
 	idx.m.RLock()
 	defer idx.m.RUnlock()
 
-	for e := range idx.byType[bh.Type].valuesWithID(bh.ID) {
+	for e := range idx.entriesWithID(bh.Type, bh.ID) {
 		pbs = append(pbs, idx.toPackedBlob(e, bh.Type))
 	}
 
@@ -195,7 +234,7 @@ func (idx *Index) Has(bh restic.BlobHandle) bool {
 	idx.m.RLock()
 	defer idx.m.RUnlock()
 
-	return idx.byType[bh.Type].get(bh.ID) != nil
+	return idx.firstEntry(bh.Type, bh.ID) != nil
 }
 
 // LookupSize returns the length of the plaintext content of the blob with the
@@ -204,7 +243,7 @@ func (idx *Index) LookupSize(bh restic.BlobHandle) (plaintextLength uint, found
 	idx.m.RLock()
 	defer idx.m.RUnlock()
 
-	e := idx.byType[bh.Type].get(bh.ID)
+	e := idx.firstEntry(bh.Type, bh.ID)
 	if e == nil {
 		return 0, false
 	}
@@ -222,8 +261,7 @@ func (idx *Index) Values() iter.Seq[*pack.PackedBlob] {
 		defer idx.m.RUnlock()
 
 		for typ := range idx.byType {
-			m := &idx.byType[typ]
-			for e := range m.values() {
+			for e := range idx.entries(restic.BlobType(typ)) {
 				if !yield(idx.toPackedBlob(e, restic.BlobType(typ))) {
 					return
 				}
@@ -257,9 +295,8 @@ func (idx *Index) EachByPack(ctx context.Context, packBlacklist restic.IDSet) <-
 		byPack := make(map[restic.ID][restic.NumBlobTypes][]*indexEntry)
 
 		for typ := range idx.byType {
-			m := &idx.byType[typ]
-			for e := range m.values() {
-				packID := idx.packs[e.packIndex]
+			for e := range idx.entries(restic.BlobType(typ)) {
+				packID := idx.packID(e.packIndex)
 				if !idx.final || !packBlacklist.Has(packID) {
 					v := byPack[packID]
 					v[typ] = append(v[typ], e)
@@ -295,6 +332,11 @@ func (idx *Index) Packs() restic.IDSet {
 	defer idx.m.RUnlock()
 
 	packs := restic.NewIDSet()
+	if idx.disk != nil {
+		for i := 0; i < idx.disk.numPacks(); i++ {
+			packs.Insert(idx.disk.packID(i))
+		}
+	}
 	for _, packID := range idx.packs {
 		packs.Insert(packID)
 	}
@@ -313,6 +355,7 @@ type blobJSON struct {
 	Offset             uint            `json:"offset"`
 	Length             uint            `json:"length"`
 	UncompressedLength uint            `json:"uncompressed_length,omitempty"`
+	CompressionLevel   uint8           `json:"compression_level,omitempty"`
 }
 
 // generatePackList returns a list of packs.
@@ -321,9 +364,8 @@ func (idx *Index) generatePackList() ([]packJSON, error) {
 	packs := make(map[restic.ID]int, len(list)) // Maps to index in list.
 
 	for typ := range idx.byType {
-		m := &idx.byType[typ]
-		for e := range m.values() {
-			packID := idx.packs[e.packIndex]
+		for e := range idx.entries(restic.BlobType(typ)) {
+			packID := idx.packID(e.packIndex)
 			if packID.IsNull() {
 				panic("null pack id")
 			}
@@ -343,6 +385,7 @@ func (idx *Index) generatePackList() ([]packJSON, error) {
 				Offset:             uint(e.offset),
 				Length:             uint(e.length),
 				UncompressedLength: uint(e.uncompressedLength),
+				CompressionLevel:   e.compressionLevel,
 			})
 		}
 	}
@@ -352,7 +395,8 @@ func (idx *Index) generatePackList() ([]packJSON, error) {
 
 type jsonIndex struct {
 	// removed: Supersedes restic.IDs `json:"supersedes,omitempty"`
-	Packs []packJSON `json:"packs"`
+	Packs         []packJSON          `json:"packs"`
+	PendingDelete []pendingDeleteJSON `json:"pending_delete,omitempty"`
 }
 
 // Encode writes the JSON serialization of the index to the writer w.
@@ -368,16 +412,23 @@ func (idx *Index) Encode(w io.Writer) error {
 
 	enc := json.NewEncoder(w)
 	idxJSON := jsonIndex{
-		Packs: list,
+		Packs:         list,
+		PendingDelete: idx.generatePendingDeleteList(),
 	}
 	return enc.Encode(idxJSON)
 }
 
-// SaveIndex saves an index in the repository.
-func (idx *Index) SaveIndex(ctx context.Context, repo restic.SaverUnpacked[restic.FileType]) (restic.ID, error) {
+// SaveIndex saves an index in the repository using the given format. Indexes
+// with pack files pending deletion are always saved in the JSON format.
+func (idx *Index) SaveIndex(ctx context.Context, repo restic.SaverUnpacked[restic.FileType], format Format) (restic.ID, error) {
 	buf := bytes.NewBuffer(nil)
 
-	err := idx.Encode(buf)
+	var err error
+	if format == FormatBinary && !idx.hasPendingDelete() {
+		err = idx.EncodeBinary(buf)
+	} else {
+		err = idx.Encode(buf)
+	}
 	if err != nil {
 		return restic.ID{}, err
 	}
@@ -391,6 +442,21 @@ func (idx *Index) SaveIndex(ctx context.Context, repo restic.SaverUnpacked[resti
 	return id, err
 }
 
+// closeDiskIndex releases the on-disk index. The index must not be used
+// afterwards.
+func (idx *Index) closeDiskIndex() {
+	idx.m.Lock()
+	defer idx.m.Unlock()
+
+	if idx.disk == nil {
+		return
+	}
+	if err := idx.disk.Close(); err != nil {
+		debug.Log("unable to close on-disk index: %v", err)
+	}
+	idx.disk = nil
+}
+
 // Finalize sets the index to final.
 func (idx *Index) Finalize() {
 	debug.Log("finalizing index")
@@ -445,7 +511,8 @@ func (idx *Index) Dump(w io.Writer) error {
 	}
 
 	outer := jsonIndex{
-		Packs: list,
+		Packs:         list,
+		PendingDelete: idx.generatePendingDeleteList(),
 	}
 
 	buf, err := json.MarshalIndent(outer, "", "  ")
@@ -474,6 +541,9 @@ func (idx *Index) merge(idx2 *Index) error {
 	if !idx2.final {
 		return errors.New("index to merge is not final")
 	}
+	if idx.disk != nil || idx2.disk != nil {
+		return errors.New("on-disk indexes cannot be merged")
+	}
 
 	packlen := len(idx.packs)
 	// first append packs as they might be accessed when looking for duplicates below
@@ -500,28 +570,51 @@ func (idx *Index) merge(idx2 *Index) error {
 		for e2 := range m2.values() {
 			if !hasIdenticalEntry(e2) {
 				// packIndex needs to be changed as idx2.pack was appended to idx.pack, see above
-				m.add(e2.id, e2.packIndex+packlen, e2.offset, e2.length, e2.uncompressedLength)
+				m.add(e2.id, e2.packIndex+packlen, e2.offset, e2.length, e2.uncompressedLength, e2.compressionLevel)
 			}
 		}
 	}
 
 	idx.ids = append(idx.ids, idx2.ids...)
+	idx.pendingDelete = append(idx.pendingDelete, idx2.pendingDelete...)
 
 	return nil
 }
 
-// DecodeIndex unserializes an index from buf.
+// DecodeIndex unserializes an index from buf, which can use either the JSON
+// or the binary format.
 func DecodeIndex(buf []byte, id restic.ID) (idx *Index, err error) {
 	debug.Log("Start decoding index")
+	if IsBinaryIndex(buf) {
+		idx, err = decodeBinaryIndex(buf)
+		if err != nil {
+			debug.Log("Error %v", err)
+			return nil, errors.Wrap(err, "DecodeIndex")
+		}
+	} else {
+		idx, err = decodeJSONIndex(buf)
+		if err != nil {
+			return nil, err
+		}
+	}
+	idx.ids = append(idx.ids, id)
+	idx.final = true
+
+	debug.Log("done")
+	return idx, nil
+}
+
+// decodeJSONIndex unserializes an index in the JSON format from buf.
+func decodeJSONIndex(buf []byte) (*Index, error) {
 	idxJSON := &jsonIndex{}
 
-	err = json.Unmarshal(buf, idxJSON)
+	err := json.Unmarshal(buf, idxJSON)
 	if err != nil {
 		debug.Log("Error %v", err)
 		return nil, errors.Wrap(err, "DecodeIndex")
 	}
 
-	idx = NewIndex()
+	idx := NewIndex()
 	for _, p := range idxJSON.Packs {
 		packID := idx.addToPacks(p.ID)
 
@@ -533,13 +626,11 @@ func DecodeIndex(buf []byte, id restic.ID) (idx *Index, err error) {
 				Offset:             blob.Offset,
 				Length:             blob.Length,
 				UncompressedLength: blob.UncompressedLength,
+				CompressionLevel:   blob.CompressionLevel,
 			})
 		}
 	}
-	idx.ids = append(idx.ids, id)
-	idx.final = true
-
-	debug.Log("done")
+	idx.pendingDelete = decodePendingDeleteList(idxJSON.PendingDelete)
 	return idx, nil
 }
 
@@ -547,6 +638,9 @@ func (idx *Index) BlobIndex(bh restic.BlobHandle) int {
 	idx.m.RLock()
 	defer idx.m.RUnlock()
 
+	if idx.disk != nil {
+		return idx.disk.firstIndex(bh.Type, bh.ID)
+	}
 	return idx.byType[bh.Type].firstIndex(bh.ID)
 }
 
@@ -554,6 +648,13 @@ func (idx *Index) Len(t restic.BlobType) uint {
 	idx.m.RLock()
 	defer idx.m.RUnlock()
 
+	return idx.len(t)
+}
+
+func (idx *Index) len(t restic.BlobType) uint {
+	if idx.disk != nil {
+		return idx.disk.len(t)
+	}
 	return idx.byType[t].len()
 }
 
@@ -0,0 +1,314 @@
+package index
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"io"
+	"slices"
+	"sort"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository/pack"
+	"github.com/restic/restic/internal/restic"
+)
+
+// Format is the encoding used for index files.
+type Format uint8
+
+const (
+	// FormatJSON encodes index files as JSON, used by repository versions 1 and 2.
+	FormatJSON Format = iota
+	// FormatBinary encodes index files in a compact binary format, used by
+	// repository version 3.
+	FormatBinary
+)
+
+// FormatForVersion returns the index format used by a repository version.
+func FormatForVersion(version uint) Format {
+	if version >= 3 {
+		return FormatBinary
+	}
+	return FormatJSON
+}
+
+// The binary index format starts with a header followed by the list of pack
+// IDs and the entries of the data and tree blobs. All integers are stored in
+// little endian byte order.
+//
+//	magic      [4]byte  "RIDX"
+//	version    uint8    binaryIndexVersion
+//	flags      uint8    combination of the binaryIndexFlag* values
+//	reserved   [2]byte  zero
+//	numPacks   uint32
+//	packIDs    [numPacks][32]byte, sorted
+//	for the data blobs, then for the tree blobs:
+//	  numBlobs uint32
+//	  entries  [numBlobs]entry, sorted by blob ID
+//	  levels   [numBlobs]uint8, only if binaryIndexFlagCompressionLevels is set
+//
+// Each entry has a fixed size of 48 bytes:
+//
+//	id                  [32]byte
+//	packIndex           uint32  index into packIDs
+//	offset              uint32
+//	length              uint32
+//	uncompressedLength  uint32
+//
+// If binaryIndexFlagDeltaIDs is set, each blob ID except the first one of a
+// blob type is stored as the difference to the previous ID, interpreted as 256
+// bit big endian integers. As the IDs are sorted, the differences start with
+// zero bytes, which are removed by the compression of the index file.
+//
+// If binaryIndexFlagCompressionLevels is set, the entries of each blob type
+// are followed by the compression level of each blob in the same order. The
+// flag is only set if at least one blob has a known compression level.
+var binaryIndexMagic = []byte("RIDX")
+
+const (
+	binaryIndexVersion               = 1
+	binaryIndexFlagDeltaIDs          = 1 << 0
+	binaryIndexFlagCompressionLevels = 1 << 2
+
+	binaryIndexHeaderSize = 8
+	binaryIndexEntrySize  = len(restic.ID{}) + 4*4
+)
+
+// binaryIndexBlobTypes are the blob types in the order they are stored.
+var binaryIndexBlobTypes = []restic.BlobType{restic.DataBlob, restic.TreeBlob}
+
+// IsBinaryIndex returns true if buf contains an index in the binary format.
+func IsBinaryIndex(buf []byte) bool {
+	return bytes.HasPrefix(buf, binaryIndexMagic)
+}
+
+// subID sets delta to a - b.
+func subID(delta *restic.ID, a, b restic.ID) {
+	borrow := 0
+	for i := len(a) - 1; i >= 0; i-- {
+		v := int(a[i]) - int(b[i]) - borrow
+		borrow = 0
+		if v < 0 {
+			v += 256
+			borrow = 1
+		}
+		delta[i] = byte(v)
+	}
+}
+
+// addID sets sum to a + b.
+func addID(sum *restic.ID, a, b restic.ID) {
+	carry := 0
+	for i := len(a) - 1; i >= 0; i-- {
+		v := int(a[i]) + int(b[i]) + carry
+		sum[i] = byte(v)
+		carry = v >> 8
+	}
+}
+
+type binaryIndexEntry struct {
+	id                 restic.ID
+	packIndex          uint32
+	offset             uint32
+	length             uint32
+	uncompressedLength uint32
+	compressionLevel   uint8
+}
+
+// EncodeBinary writes the binary serialization of the index to the writer w.
+func (idx *Index) EncodeBinary(w io.Writer) error {
+	return idx.encodeBinary(w, true)
+}
+
+func (idx *Index) encodeBinary(w io.Writer, deltaIDs bool) error {
+	debug.Log("encoding index in binary format")
+	idx.m.RLock()
+	defer idx.m.RUnlock()
+
+	if idx.len(restic.InvalidBlob) > 0 {
+		return errors.New("index contains blobs with invalid type")
+	}
+	if len(idx.pendingDelete) > 0 {
+		return errors.New("binary index format cannot store packs pending deletion")
+	}
+
+	// only store packs which contain blobs, merged indexes may list a pack twice
+	packSet := restic.NewIDSet()
+	for _, t := range binaryIndexBlobTypes {
+		for e := range idx.entries(t) {
+			packID := idx.packID(e.packIndex)
+			if packID.IsNull() {
+				panic("null pack id")
+			}
+			packSet.Insert(packID)
+		}
+	}
+	packs := packSet.List()
+	sort.Sort(packs)
+	packIndex := make(map[restic.ID]uint32, len(packs))
+	for i, id := range packs {
+		packIndex[id] = uint32(i)
+	}
+
+	var flags byte
+	if deltaIDs {
+		flags |= binaryIndexFlagDeltaIDs
+	}
+
+	var byType [restic.NumBlobTypes][]binaryIndexEntry
+	for _, t := range binaryIndexBlobTypes {
+		entries := make([]binaryIndexEntry, 0, idx.len(t))
+		for e := range idx.entries(t) {
+			entries = append(entries, binaryIndexEntry{
+				id:                 e.id,
+				packIndex:          packIndex[idx.packID(e.packIndex)],
+				offset:             e.offset,
+				length:             e.length,
+				uncompressedLength: e.uncompressedLength,
+				compressionLevel:   e.compressionLevel,
+			})
+			if e.compressionLevel != 0 {
+				flags |= binaryIndexFlagCompressionLevels
+			}
+		}
+		slices.SortFunc(entries, func(a, b binaryIndexEntry) int {
+			if c := bytes.Compare(a.id[:], b.id[:]); c != 0 {
+				return c
+			}
+			if c := cmp.Compare(a.packIndex, b.packIndex); c != 0 {
+				return c
+			}
+			return cmp.Compare(a.offset, b.offset)
+		})
+		byType[t] = entries
+	}
+
+	buf := make([]byte, 0, binaryIndexHeaderSize+4+len(packs)*len(restic.ID{}))
+	buf = append(buf, binaryIndexMagic...)
+	buf = append(buf, binaryIndexVersion, flags, 0, 0)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(packs)))
+	for _, id := range packs {
+		buf = append(buf, id[:]...)
+	}
+	if _, err := w.Write(buf); err != nil {
+		return err
+	}
+
+	for _, t := range binaryIndexBlobTypes {
+		entries := byType[t]
+		buf = make([]byte, 0, 4+len(entries)*(binaryIndexEntrySize+1))
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(entries)))
+		var prev restic.ID
+		for i, e := range entries {
+			id := e.id
+			if deltaIDs && i > 0 {
+				subID(&id, e.id, prev)
+			}
+			prev = e.id
+
+			buf = append(buf, id[:]...)
+			buf = binary.LittleEndian.AppendUint32(buf, e.packIndex)
+			buf = binary.LittleEndian.AppendUint32(buf, e.offset)
+			buf = binary.LittleEndian.AppendUint32(buf, e.length)
+			buf = binary.LittleEndian.AppendUint32(buf, e.uncompressedLength)
+		}
+		if flags&binaryIndexFlagCompressionLevels != 0 {
+			for _, e := range entries {
+				buf = append(buf, e.compressionLevel)
+			}
+		}
+		if _, err := w.Write(buf); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// decodeBinaryIndex unserializes an index in the binary format from buf.
+func decodeBinaryIndex(buf []byte) (*Index, error) {
+	if len(buf) < binaryIndexHeaderSize+4 || !IsBinaryIndex(buf) {
+		return nil, errors.New("invalid binary index header")
+	}
+	if buf[4] != binaryIndexVersion {
+		return nil, errors.Errorf("unsupported binary index version %d", buf[4])
+	}
+	flags := buf[5]
+	if flags&^(binaryIndexFlagDeltaIDs|binaryIndexFlagCompressionLevels) != 0 || buf[6] != 0 || buf[7] != 0 {
+		return nil, errors.Errorf("unsupported binary index flags %#x", buf[5:8])
+	}
+	deltaIDs := flags&binaryIndexFlagDeltaIDs != 0
+	hasLevels := flags&binaryIndexFlagCompressionLevels != 0
+	buf = buf[binaryIndexHeaderSize:]
+
+	idx := NewIndex()
+
+	numPacks := uint64(binary.LittleEndian.Uint32(buf))
+	buf = buf[4:]
+	if uint64(len(buf)) < numPacks*uint64(len(restic.ID{})) {
+		return nil, errors.New("binary index truncated")
+	}
+	idx.packs = make(restic.IDs, numPacks)
+	for i := range idx.packs {
+		buf = buf[copy(idx.packs[i][:], buf):]
+	}
+
+	for _, t := range binaryIndexBlobTypes {
+		if len(buf) < 4 {
+			return nil, errors.New("binary index truncated")
+		}
+		numBlobs := uint64(binary.LittleEndian.Uint32(buf))
+		buf = buf[4:]
+		size := numBlobs * uint64(binaryIndexEntrySize)
+		if hasLevels {
+			size += numBlobs
+		}
+		if uint64(len(buf)) < size {
+			return nil, errors.New("binary index truncated")
+		}
+		var levels []byte
+		if hasLevels {
+			levels = buf[numBlobs*uint64(binaryIndexEntrySize) : size]
+		}
+
+		m := &idx.byType[t]
+		m.preallocate(int(numBlobs))
+		var prev restic.ID
+		for i := uint64(0); i < numBlobs; i++ {
+			var id restic.ID
+			copy(id[:], buf)
+			if deltaIDs && i > 0 {
+				addID(&id, prev, id)
+			}
+			prev = id
+
+			packIndex := binary.LittleEndian.Uint32(buf[32:])
+			if uint64(packIndex) >= numPacks {
+				return nil, errors.Errorf("invalid pack index %d for blob %v", packIndex, id.Str())
+			}
+			offset := binary.LittleEndian.Uint32(buf[36:])
+			length := binary.LittleEndian.Uint32(buf[40:])
+			uncompressedLength := binary.LittleEndian.Uint32(buf[44:])
+			buf = buf[binaryIndexEntrySize:]
+
+			var level uint8
+			if hasLevels {
+				level = levels[i]
+			}
+			idx.store(int(packIndex), pack.Blob{
+				BlobHandle:         restic.BlobHandle{Type: t, ID: id},
+				Offset:             uint(offset),
+				Length:             uint(length),
+				UncompressedLength: uint(uncompressedLength),
+				CompressionLevel:   level,
+			})
+		}
+		buf = buf[len(levels):]
+	}
+
+	if len(buf) != 0 {
+		return nil, errors.Errorf("binary index contains %d trailing bytes", len(buf))
+	}
+	return idx, nil
+}
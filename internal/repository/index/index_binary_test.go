@@ -0,0 +1,198 @@
+package index
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/restic/restic/internal/repository/pack"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func createBinaryTestIndex() *Index {
+	idx := NewIndex()
+	duplicate := restic.NewRandomBlobHandle()
+	for i := 0; i < 20; i++ {
+		var blobs pack.Blobs
+		for j := 0; j < 30; j++ {
+			h := restic.NewRandomBlobHandle()
+			if j%3 == 0 {
+				h.Type = restic.TreeBlob
+			}
+			blob := pack.Blob{
+				BlobHandle: h,
+				Offset:     uint(j * 1000),
+				Length:     uint(100 + j),
+			}
+			if i%2 == 0 {
+				blob.UncompressedLength = 2 * blob.Length
+				if i%4 == 0 {
+					blob.CompressionLevel = uint8(1 + j%4)
+				}
+			}
+			blobs = append(blobs, blob)
+		}
+		if i < 2 {
+			// the same blob is stored in two packs
+			blobs = append(blobs, pack.Blob{BlobHandle: duplicate, Offset: 30000, Length: 42})
+		}
+		idx.StorePack(restic.NewRandomID(), blobs)
+	}
+	// a pack without blobs is not stored
+	idx.StorePack(restic.NewRandomID(), nil)
+	idx.Finalize()
+	return idx
+}
+
+func sortedIndexEntries(idx *Index) []pack.PackedBlob {
+	var list []pack.PackedBlob
+	for pb := range idx.Values() {
+		list = append(list, *pb)
+	}
+	slices.SortFunc(list, func(a, b pack.PackedBlob) int {
+		if c := bytes.Compare(a.Pack[:], b.Pack[:]); c != 0 {
+			return c
+		}
+		return bytes.Compare(a.Blob.ID[:], b.Blob.ID[:])
+	})
+	return list
+}
+
+func TestBinaryIndexSerialize(t *testing.T) {
+	idx := createBinaryTestIndex()
+
+	for _, deltaIDs := range []bool{false, true} {
+		var buf bytes.Buffer
+		rtest.OK(t, idx.encodeBinary(&buf, deltaIDs))
+		rtest.Assert(t, IsBinaryIndex(buf.Bytes()), "encoded index not detected as binary index")
+
+		id := restic.NewRandomID()
+		idx2, err := DecodeIndex(buf.Bytes(), id)
+		rtest.OK(t, err)
+		ids, err := idx2.IDs()
+		rtest.OK(t, err)
+		rtest.Equals(t, restic.IDs{id}, ids)
+		rtest.Equals(t, sortedIndexEntries(idx), sortedIndexEntries(idx2))
+		rtest.Equals(t, 20, len(idx2.Packs()))
+
+		// the encoding is deterministic
+		var buf2 bytes.Buffer
+		rtest.OK(t, idx2.encodeBinary(&buf2, deltaIDs))
+		rtest.Equals(t, buf.Bytes(), buf2.Bytes())
+	}
+
+	// both formats contain the same entries
+	var jsonBuf bytes.Buffer
+	rtest.OK(t, idx.Encode(&jsonBuf))
+	rtest.Assert(t, !IsBinaryIndex(jsonBuf.Bytes()), "JSON index detected as binary index")
+	idx3, err := DecodeIndex(jsonBuf.Bytes(), restic.NewRandomID())
+	rtest.OK(t, err)
+	rtest.Equals(t, sortedIndexEntries(idx), sortedIndexEntries(idx3))
+}
+
+func TestBinaryIndexEmpty(t *testing.T) {
+	idx := NewIndex()
+	idx.Finalize()
+
+	var buf bytes.Buffer
+	rtest.OK(t, idx.EncodeBinary(&buf))
+	idx2, err := DecodeIndex(buf.Bytes(), restic.NewRandomID())
+	rtest.OK(t, err)
+	rtest.Equals(t, 0, len(sortedIndexEntries(idx2)))
+}
+
+func TestBinaryIndexCompressionLevels(t *testing.T) {
+	blob := pack.Blob{BlobHandle: restic.NewRandomBlobHandle(), Length: 42, UncompressedLength: 100}
+	for _, level := range []uint8{0, 3} {
+		blob.CompressionLevel = level
+		idx := NewIndex()
+		idx.StorePack(restic.NewRandomID(), pack.Blobs{blob})
+		idx.Finalize()
+
+		var buf bytes.Buffer
+		rtest.OK(t, idx.EncodeBinary(&buf))
+		// the levels are only stored if known
+		rtest.Equals(t, level != 0, buf.Bytes()[5]&binaryIndexFlagCompressionLevels != 0)
+
+		idx2, err := DecodeIndex(buf.Bytes(), restic.NewRandomID())
+		rtest.OK(t, err)
+		rtest.Equals(t, sortedIndexEntries(idx), sortedIndexEntries(idx2))
+	}
+}
+
+func TestBinaryIndexDeltaIDs(t *testing.T) {
+	for _, test := range []struct{ a, b string }{
+		{"00", "00"},
+		{"00ff", "0100"},
+		{"ffffffff", "ffffffff"},
+		{"0000ffffffffffff", "0001000000000000"},
+		{"0123456789abcdef", "fedcba9876543210"},
+	} {
+		a := restic.TestParseID(strings.Repeat("0", 64-len(test.a)) + test.a)
+		b := restic.TestParseID(strings.Repeat("0", 64-len(test.b)) + test.b)
+
+		var delta, sum restic.ID
+		subID(&delta, b, a)
+		addID(&sum, a, delta)
+		rtest.Equals(t, b, sum)
+	}
+}
+
+func TestBinaryIndexInvalid(t *testing.T) {
+	var buf bytes.Buffer
+	rtest.OK(t, createBinaryTestIndex().EncodeBinary(&buf))
+	valid := buf.Bytes()
+
+	modify := func(fn func(buf []byte) []byte) []byte {
+		return fn(slices.Clone(valid))
+	}
+
+	for _, test := range []struct {
+		name string
+		buf  []byte
+		err  string
+	}{
+		{"header", valid[:10], "invalid binary index header"},
+		{"version", modify(func(buf []byte) []byte {
+			buf[4] = 2
+			return buf
+		}), "unsupported binary index version"},
+		{"flags", modify(func(buf []byte) []byte {
+			buf[5] |= 0x80
+			return buf
+		}), "unsupported binary index flags"},
+		{"truncated", valid[:len(valid)-1], "binary index truncated"},
+		{"trailing", append(slices.Clone(valid), 0), "trailing bytes"},
+		{"pack index", modify(func(buf []byte) []byte {
+			numPacks := binary.LittleEndian.Uint32(buf[binaryIndexHeaderSize:])
+			pos := binaryIndexHeaderSize + 4 + int(numPacks)*len(restic.ID{}) + 4 + len(restic.ID{})
+			binary.LittleEndian.PutUint32(buf[pos:], numPacks)
+			return buf
+		}), "invalid pack index"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := DecodeIndex(test.buf, restic.NewRandomID())
+			rtest.Assert(t, err != nil && strings.Contains(err.Error(), test.err), "expected error %q, got %v", test.err, err)
+		})
+	}
+}
+
+func TestBinaryIndexPendingDelete(t *testing.T) {
+	idx := NewIndex()
+	idx.AddPendingDelete(PendingDeletePack{PackID: restic.NewRandomID()})
+	idx.Finalize()
+	rtest.Assert(t, idx.EncodeBinary(io.Discard) != nil, "binary index stored packs pending deletion")
+
+	// SaveIndex falls back to the JSON format
+	repo := &memIndexRepo{files: make(map[restic.ID][]byte)}
+	id, err := idx.SaveIndex(context.TODO(), repo, FormatBinary)
+	rtest.OK(t, err)
+	decoded, err := DecodeIndex(repo.files[id], id)
+	rtest.OK(t, err)
+	rtest.Equals(t, idx.PendingDelete(), decoded.PendingDelete())
+}
@@ -42,7 +42,7 @@ const (
 
 // add inserts an indexEntry for the given arguments into the map,
 // using id as the key.
-func (m *indexMap) add(id restic.ID, packIdx int, offset, length uint32, uncompressedLength uint32) {
+func (m *indexMap) add(id restic.ID, packIdx int, offset, length uint32, uncompressedLength uint32, compressionLevel uint8) {
 	// Make sure there is enough space for the new entry.
 	m.preallocate(int(m.numentries) + 1)
 
@@ -54,6 +54,7 @@ func (m *indexMap) add(id restic.ID, packIdx int, offset, length uint32, uncompr
 	e.offset = offset
 	e.length = length
 	e.uncompressedLength = uncompressedLength
+	e.compressionLevel = compressionLevel
 
 	m.buckets[h] = bloomInsertID(idx, e.next, id)
 	m.numentries++
@@ -250,6 +251,7 @@ type indexEntry struct {
 	offset             uint32
 	length             uint32
 	uncompressedLength uint32
+	compressionLevel   uint8
 }
 
 type hashedArrayTree struct {
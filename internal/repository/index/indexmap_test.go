@@ -22,7 +22,7 @@ func TestIndexMapBasic(t *testing.T) {
 		r.Read(id[:])
 		rtest.Assert(t, m.get(id) == nil, "%v retrieved but not added", id)
 
-		m.add(id, 0, 0, 0, 0)
+		m.add(id, 0, 0, 0, 0, 0)
 		rtest.Assert(t, m.get(id) != nil, "%v added but not retrieved", id)
 		rtest.Equals(t, uint(i), m.len())
 	}
@@ -44,7 +44,7 @@ func TestIndexMapForeach(t *testing.T) {
 	for i := 0; i < N; i++ {
 		var id restic.ID
 		id[0] = byte(i)
-		m.add(id, i, uint32(i), uint32(i), uint32(i/2))
+		m.add(id, i, uint32(i), uint32(i), uint32(i/2), 0)
 	}
 
 	seen := make(map[int]struct{})
@@ -90,13 +90,13 @@ func TestIndexMapForeachWithID(t *testing.T) {
 
 	// Test insertion and retrieval of duplicates.
 	for i := 0; i < ndups; i++ {
-		m.add(id, i, 0, 0, 0)
+		m.add(id, i, 0, 0, 0, 0)
 	}
 
 	for i := 0; i < 100; i++ {
 		var otherid restic.ID
 		r.Read(otherid[:])
-		m.add(otherid, -1, 0, 0, 0)
+		m.add(otherid, -1, 0, 0, 0, 0)
 	}
 
 	n = 0
@@ -129,7 +129,7 @@ func TestHashedArrayTree(t *testing.T) {
 
 func BenchmarkIndexMapHash(b *testing.B) {
 	var m indexMap
-	m.add(restic.ID{}, 0, 0, 0, 0) // Trigger lazy initialization.
+	m.add(restic.ID{}, 0, 0, 0, 0, 0) // Trigger lazy initialization.
 
 	ids := make([]restic.ID, 128) // 4 KiB.
 	r := rand.New(rand.NewSource(time.Now().UnixNano()))
@@ -162,7 +162,7 @@ func TestIndexMapFirstIndex(t *testing.T) {
 		r.Read(id[:])
 		rtest.Equals(t, -1, m.firstIndex(id), "wrong firstIndex for nonexistent id")
 
-		m.add(id, 0, 0, 0, 0)
+		m.add(id, 0, 0, 0, 0, 0)
 		idx := m.firstIndex(id)
 		rtest.Equals(t, i, idx, "unexpected index for id")
 		fi[id] = idx
@@ -184,7 +184,7 @@ func TestIndexMapFirstIndexDuplicates(t *testing.T) {
 
 	r.Read(id[:])
 	for i := 1; i <= 10; i++ {
-		m.add(id, 0, 0, 0, 0)
+		m.add(id, 0, 0, 0, 0, 0)
 	}
 	idx := m.firstIndex(id)
 	rtest.Equals(t, 1, idx, "unexpected index for id")
@@ -4,10 +4,13 @@ import (
 	"context"
 	"fmt"
 	"iter"
+	"os"
 	"runtime"
+	"sort"
 	"sync"
 
 	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/repository/pack"
 	"github.com/restic/restic/internal/restic"
 	"golang.org/x/sync/errgroup"
@@ -18,6 +21,12 @@ type MasterIndex struct {
 	idx          []*Index
 	pendingBlobs map[restic.BlobHandle]uint
 	idxMutex     sync.RWMutex
+	// stored is closed and reset once a pack has been stored.
+	stored chan struct{}
+	// format is used to encode new index files.
+	format Format
+	// diskIndexFile is the location of the on-disk index, if used.
+	diskIndexFile string
 }
 
 // NewMasterIndex creates a new master index.
@@ -27,7 +36,28 @@ func NewMasterIndex() *MasterIndex {
 	return mi
 }
 
+// SetFormat sets the format used to save index files.
+func (mi *MasterIndex) SetFormat(format Format) {
+	mi.idxMutex.Lock()
+	defer mi.idxMutex.Unlock()
+
+	mi.format = format
+}
+
+// SetDiskIndexFile configures the master index to store all loaded index files
+// in a memory-mapped on-disk index in filename instead of in memory. The
+// on-disk index is updated when loading the index.
+func (mi *MasterIndex) SetDiskIndexFile(filename string) {
+	mi.idxMutex.Lock()
+	defer mi.idxMutex.Unlock()
+
+	mi.diskIndexFile = filename
+}
+
 func (mi *MasterIndex) clear() {
+	for _, idx := range mi.idx {
+		idx.closeDiskIndex()
+	}
 	// Always add an empty final index, such that MergeFinalIndexes can merge into this.
 	mi.idx = []*Index{NewIndex()}
 	mi.idx[0].Finalize()
@@ -159,6 +189,10 @@ func (mi *MasterIndex) storePack(id restic.ID, blobs pack.Blobs) {
 	for _, blob := range blobs {
 		delete(mi.pendingBlobs, restic.BlobHandle{Type: blob.Type, ID: blob.ID})
 	}
+	if mi.stored != nil {
+		close(mi.stored)
+		mi.stored = nil
+	}
 
 	for _, idx := range mi.idx {
 		if !idx.Final() {
@@ -172,6 +206,47 @@ func (mi *MasterIndex) storePack(id restic.ID, blobs pack.Blobs) {
 	mi.idx = append(mi.idx, newIdx)
 }
 
+// PendingBlobs returns the blobs which are currently pending.
+func (mi *MasterIndex) PendingBlobs() restic.BlobSet {
+	mi.idxMutex.RLock()
+	defer mi.idxMutex.RUnlock()
+
+	blobs := restic.NewBlobSet()
+	for bh := range mi.pendingBlobs {
+		blobs.Insert(bh)
+	}
+	return blobs
+}
+
+// WaitStored waits until none of the blobs is pending anymore, that is until
+// the packs containing them have been stored. Blobs are removed from the set
+// once they are no longer pending.
+func (mi *MasterIndex) WaitStored(ctx context.Context, blobs restic.BlobSet) error {
+	for {
+		mi.idxMutex.Lock()
+		for bh := range blobs {
+			if _, ok := mi.pendingBlobs[bh]; !ok {
+				blobs.Delete(bh)
+			}
+		}
+		if len(blobs) == 0 {
+			mi.idxMutex.Unlock()
+			return nil
+		}
+		if mi.stored == nil {
+			mi.stored = make(chan struct{})
+		}
+		stored := mi.stored
+		mi.idxMutex.Unlock()
+
+		select {
+		case <-stored:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
 // finalizeNotFinalIndexes finalizes all indexes that
 // have not yet been saved and returns that list
 func (mi *MasterIndex) finalizeNotFinalIndexes() []*Index {
@@ -248,19 +323,26 @@ func (mi *MasterIndex) MergeFinalIndexes() error {
 		return nil
 	}
 
+	// The first index is always final and the one to merge into. As an
+	// on-disk index cannot be modified, the second index is used instead.
+	first := 0
+	if mi.idx[0].disk != nil {
+		first = 1
+	}
+	target := mi.idx[first]
+
 	// preallocate space for all blob types
 	for typ := range restic.NumBlobTypes {
 		size := 0
-		for _, idx := range mi.idx {
+		for _, idx := range mi.idx[first:] {
 			size += int(idx.Len(typ))
 		}
 
-		mi.idx[0].Preallocate(typ, size)
+		target.Preallocate(typ, size)
 	}
 
-	// The first index is always final and the one to merge into
-	newIdx := mi.idx[:1]
-	for i := 1; i < len(mi.idx); i++ {
+	newIdx := mi.idx[:first+1]
+	for i := first + 1; i < len(mi.idx); i++ {
 		idx := mi.idx[i]
 		// clear reference in masterindex as it may become stale
 		mi.idx[i] = nil
@@ -269,7 +351,7 @@ func (mi *MasterIndex) MergeFinalIndexes() error {
 		if !idx.Final() || len(ids) == 0 {
 			newIdx = append(newIdx, idx)
 		} else {
-			err := mi.idx[0].merge(idx)
+			err := target.merge(idx)
 			if err != nil {
 				return fmt.Errorf("MergeFinalIndexes: %w", err)
 			}
@@ -286,6 +368,9 @@ func (mi *MasterIndex) Load(ctx context.Context, r restic.ListerLoaderUnpacked,
 	if err != nil {
 		return err
 	}
+	if mi.diskIndexFile != "" {
+		return mi.loadDiskIndex(ctx, indexList, r, p, cb)
+	}
 	loadedIDs, err := mi.prepareIncrementalLoad(ctx, indexList)
 	if err != nil {
 		return err
@@ -331,6 +416,74 @@ func (mi *MasterIndex) Load(ctx context.Context, r restic.ListerLoaderUnpacked,
 	return mi.MergeFinalIndexes()
 }
 
+// loadDiskIndex updates the on-disk index to contain all index files and uses
+// it instead of the index files loaded so far. If only new index files were
+// added to the repository, these are merged into the existing on-disk index.
+// Otherwise, or if cb is set, the on-disk index is rebuilt from all index files.
+func (mi *MasterIndex) loadDiskIndex(ctx context.Context, indexList restic.Lister, r restic.LoaderUnpacked, p restic.Counter, cb func(id restic.ID, idx *Index, err error) error) error {
+	indexFiles := restic.NewIDSet()
+	err := indexList.List(ctx, restic.IndexFile, func(id restic.ID, _ int64) error {
+		indexFiles.Insert(id)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// the current on-disk index must be closed before it can be replaced
+	mi.idxMutex.Lock()
+	mi.clear()
+	mi.idxMutex.Unlock()
+
+	base, err := openDiskIndex(mi.diskIndexFile)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			debug.Log("ignoring on-disk index: %v", err)
+		}
+		base = nil
+	}
+
+	newIDs := indexFiles
+	if base != nil {
+		baseIDs := restic.NewIDSet(base.ids...)
+		if cb != nil || len(baseIDs.Sub(indexFiles)) > 0 {
+			debug.Log("rebuilding on-disk index")
+			if err := base.Close(); err != nil {
+				return err
+			}
+			base = nil
+		} else {
+			newIDs = indexFiles.Sub(baseIDs)
+		}
+	}
+	p.SetMax(uint64(len(newIDs)))
+
+	d := base
+	var pendingDelete []*Index
+	if d == nil || len(newIDs) > 0 {
+		d, pendingDelete, err = buildDiskIndex(ctx, indexList, r, mi.diskIndexFile, base, newIDs, p, cb)
+		if err != nil {
+			return err
+		}
+	}
+
+	idx := NewIndex()
+	idx.disk = d
+	idx.ids = d.ids
+	idx.final = true
+
+	mi.idxMutex.Lock()
+	defer mi.idxMutex.Unlock()
+	// the second index is used by MergeFinalIndexes
+	mi.idx = append([]*Index{idx}, mi.idx...)
+	for _, pending := range pendingDelete {
+		if err := mi.idx[1].merge(pending); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (mi *MasterIndex) prepareIncrementalLoad(ctx context.Context, indexList restic.Lister) (restic.IDSet, error) {
 	mi.idxMutex.Lock()
 	// support incremental loading, while also ensuring that the result is identical to the result of a full load into a new MasterIndex
@@ -366,6 +519,8 @@ type MasterIndexRewriteOpts struct {
 	SaveProgress   restic.Counter
 	DeleteProgress func() restic.Counter
 	DeleteReport   func(id restic.ID, err error)
+	// RewriteAll also rewrites index files which are already up to date.
+	RewriteAll bool
 }
 
 // Rewrite removes packs whose ID is in excludePacks from all known indexes.
@@ -373,6 +528,10 @@ type MasterIndexRewriteOpts struct {
 // If oldIndexes is not nil, then only the indexes in this set are processed.
 // This is used by repair index to only rewrite and delete the old indexes.
 //
+// Packs pending deletion are kept unless their ID is in excludePacks or the
+// pack is contained in one of the processed indexes. They are collected in a
+// separate index file.
+//
 // Must not be called concurrently to any other MasterIndex operation.
 func (mi *MasterIndex) Rewrite(ctx context.Context, repo restic.Unpacked[restic.FileType], excludePacks restic.IDSet, oldIndexes restic.IDSet, extraObsolete restic.IDs, opts MasterIndexRewriteOpts) error {
 	for _, idx := range mi.idx {
@@ -473,9 +632,14 @@ func (mi *MasterIndex) Rewrite(ctx context.Context, repo restic.Unpacked[restic.
 		// a pack file could be split over multiple indexes.
 		packBlobsIDSet := restic.NewIDSet()
 		newIndex := NewIndex()
+		pendingDelete := make(map[restic.ID]PendingDeletePack)
+		// packs contained in the index are no longer pending deletion
+		indexedPacks := restic.NewIDSet()
 		for task := range rewriteCh {
+			indexedPacks.Merge(task.idx.Packs().Sub(excludePacks))
 			// always rewrite indexes that include a pack that must be removed or is a duplicate or that are not full
-			if len(task.idx.Packs().Intersect(excludePacks)) == 0 && Full(task.idx) && !Oversized(task.idx) {
+			// indexes with packs pending deletion are collected into a single index
+			if !opts.RewriteAll && len(task.idx.Packs().Intersect(excludePacks)) == 0 && !task.idx.hasPendingDelete() && Full(task.idx) && !Oversized(task.idx) {
 				// check that no pack index entry is a duplicate of an already processed one
 				idxPackBlobsIDSet := restic.NewIDSet()
 				for pbs := range task.idx.EachByPack(wgCtx, excludePacks) {
@@ -496,6 +660,12 @@ func (mi *MasterIndex) Rewrite(ctx context.Context, repo restic.Unpacked[restic.
 			}
 			obsolete.Merge(restic.NewIDSet(ids...))
 
+			for _, p := range task.idx.PendingDelete() {
+				if !excludePacks.Has(p.PackID) {
+					addPendingDelete(pendingDelete, p)
+				}
+			}
+
 			for pbs := range task.idx.EachByPack(wgCtx, excludePacks) {
 				// only filter pack blobs with matching packID and blobs
 				packBlobsID := PackBlobsHash(pbs)
@@ -523,6 +693,25 @@ func (mi *MasterIndex) Rewrite(ctx context.Context, repo restic.Unpacked[restic.
 		select {
 		case saveCh <- newIndex:
 		case <-wgCtx.Done():
+			return nil
+		}
+
+		packIDs := make(restic.IDs, 0, len(pendingDelete))
+		for id := range pendingDelete {
+			if !indexedPacks.Has(id) {
+				packIDs = append(packIDs, id)
+			}
+		}
+		if len(packIDs) > 0 {
+			pendingIndex := NewIndex()
+			sort.Sort(packIDs)
+			for _, id := range packIDs {
+				pendingIndex.AddPendingDelete(pendingDelete[id])
+			}
+			select {
+			case saveCh <- pendingIndex:
+			case <-wgCtx.Done():
+			}
 		}
 		return nil
 	})
@@ -535,10 +724,10 @@ func (mi *MasterIndex) Rewrite(ctx context.Context, repo restic.Unpacked[restic.
 	for idx := range saveCh {
 		savers.Go(func() error {
 			idx.Finalize()
-			if len(idx.packs) == 0 {
+			if len(idx.packs) == 0 && len(idx.pendingDelete) == 0 {
 				return nil
 			}
-			_, err := idx.SaveIndex(wgCtx, repo)
+			_, err := idx.SaveIndex(wgCtx, repo, mi.format)
 			return err
 		})
 	}
@@ -623,7 +812,7 @@ func (mi *MasterIndex) SaveFallback(ctx context.Context, repo restic.SaverRemove
 	for idx := range ch {
 		wg.Go(func() error {
 			idx.Finalize()
-			_, err := idx.SaveIndex(wgCtx, repo)
+			_, err := idx.SaveIndex(wgCtx, repo, mi.format)
 			return err
 		})
 	}
@@ -641,7 +830,7 @@ func (mi *MasterIndex) saveIndex(ctx context.Context, r restic.SaverUnpacked[res
 	for i, idx := range indexes {
 		debug.Log("Saving index %d", i)
 
-		sid, err := idx.SaveIndex(ctx, r)
+		sid, err := idx.SaveIndex(ctx, r, mi.format)
 		if err != nil {
 			return err
 		}
@@ -615,7 +615,7 @@ func TestRewriteOversizedIndex(t *testing.T) {
 	}
 	idx.Finalize()
 
-	_, err := idx.SaveIndex(context.Background(), unpacked)
+	_, err := idx.SaveIndex(context.Background(), unpacked, index.FormatJSON)
 	rtest.OK(t, err)
 
 	// construct master index for the oversized index
@@ -755,3 +755,40 @@ func TestRewriteFullPacks(t *testing.T) {
 	rtest.Equals(t, []*pack.PackedBlob{blobA}, mi2.Lookup(blobA.Handle()))
 	rtest.Equals(t, []*pack.PackedBlob{blobB}, mi2.Lookup(blobB.Handle()))
 }
+
+// TestRewritePendingDelete checks that Rewrite keeps packs pending deletion
+// unless they are excluded or contained in the index again.
+func TestRewritePendingDelete(t *testing.T) {
+	repo, unpacked, _ := repository.TestRepositoryWithVersion(t, restic.StableRepoVersion)
+	mi := index.NewMasterIndex()
+	mi.SetFormat(index.FormatBinary)
+
+	indexed := restic.NewRandomID()
+	blob := pack.Blob{BlobHandle: restic.NewRandomBlobHandle(), Length: uint(crypto.CiphertextLength(10))}
+	rtest.OK(t, mi.StorePack(context.TODO(), indexed, pack.Blobs{blob}, unpacked))
+	rtest.OK(t, mi.Flush(context.TODO(), unpacked))
+
+	marked := time.Unix(1700000000, 0).UTC()
+	pending := index.PendingDeletePack{PackID: restic.NewRandomID(), Marked: marked, Blobs: pack.Blobs{blob}}
+	unreferenced := index.PendingDeletePack{PackID: restic.NewRandomID(), Marked: marked}
+	excluded := index.PendingDeletePack{PackID: restic.NewRandomID(), Marked: marked}
+	_, err := index.SavePendingDelete(context.TODO(), unpacked, []index.PendingDeletePack{
+		pending, unreferenced, excluded, {PackID: indexed, Marked: marked},
+	})
+	rtest.OK(t, err)
+
+	mi = index.NewMasterIndex()
+	rtest.OK(t, mi.Load(context.TODO(), repo, restic.NoopCounter, nil))
+	rtest.Equals(t, 4, len(mi.PendingDelete()))
+	rtest.Equals(t, []*pack.PackedBlob{{Blob: blob, Pack: indexed}}, mi.Lookup(blob.BlobHandle))
+
+	rtest.OK(t, mi.Rewrite(context.TODO(), unpacked, restic.NewIDSet(excluded.PackID), nil, nil, index.MasterIndexRewriteOpts{}))
+
+	mi = index.NewMasterIndex()
+	rtest.OK(t, mi.Load(context.TODO(), repo, restic.NoopCounter, nil))
+	rtest.Equals(t, map[restic.ID]index.PendingDeletePack{
+		pending.PackID:      pending,
+		unreferenced.PackID: unreferenced,
+	}, mi.PendingDelete())
+	rtest.Equals(t, restic.NewIDSet(indexed), mi.Packs(nil))
+}
@@ -0,0 +1,19 @@
+//go:build !unix
+
+package index
+
+import (
+	"os"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+// mmapFile reads the file filename into memory, as memory-mapped files are
+// only supported on Unix systems.
+func mmapFile(filename string) ([]byte, func() error, error) {
+	buf, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	return buf, func() error { return nil }, nil
+}
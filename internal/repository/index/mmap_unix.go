@@ -0,0 +1,43 @@
+//go:build unix
+
+package index
+
+import (
+	"os"
+
+	"github.com/restic/restic/internal/errors"
+	"golang.org/x/sys/unix"
+)
+
+// mmapFile maps the file filename read-only into memory. The returned function
+// removes the mapping.
+func mmapFile(filename string) ([]byte, func() error, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	// the mapping stays valid after closing the file
+	defer func() {
+		_ = f.Close()
+	}()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	size := fi.Size()
+	if size == 0 {
+		return nil, func() error { return nil }, nil
+	}
+	if int64(int(size)) != size {
+		return nil, nil, errors.Errorf("file %v is too large to be mapped into memory", filename)
+	}
+
+	buf, err := unix.Mmap(int(f.Fd()), 0, int(size), unix.PROT_READ, unix.MAP_SHARED)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "mmap %v", filename)
+	}
+	return buf, func() error {
+		return errors.WithStack(unix.Munmap(buf))
+	}, nil
+}
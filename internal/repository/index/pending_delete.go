@@ -0,0 +1,139 @@
+package index
+
+import (
+	"context"
+	"time"
+
+	"github.com/restic/restic/internal/repository/pack"
+	"github.com/restic/restic/internal/restic"
+)
+
+// PendingDeletePack is a pack file which was removed from the index by prune,
+// but which is only deleted once no other process can still use it. The index
+// entries of the pack file are kept such that they can be restored if a
+// concurrent backup still referenced its blobs.
+//
+// Pack files pending deletion are stored in the "pending_delete" list of
+// index files, which is only supported by the JSON index format. They are
+// not included in the index lookups.
+type PendingDeletePack struct {
+	PackID restic.ID
+	// Marked is the time at which the pack file was removed from the index.
+	Marked time.Time
+	// Blobs contains the index entries of the pack file. It is empty for pack
+	// files which were not referenced by the index.
+	Blobs pack.Blobs
+}
+
+type pendingDeleteJSON struct {
+	ID     restic.ID  `json:"id"`
+	Marked time.Time  `json:"marked"`
+	Blobs  []blobJSON `json:"blobs,omitempty"`
+}
+
+// AddPendingDelete records that the pack file p is pending deletion.
+func (idx *Index) AddPendingDelete(p PendingDeletePack) {
+	idx.m.Lock()
+	defer idx.m.Unlock()
+
+	if idx.final {
+		panic("store new item in finalized index")
+	}
+	idx.pendingDelete = append(idx.pendingDelete, p)
+}
+
+// PendingDelete returns the pack files pending deletion recorded in the index.
+func (idx *Index) PendingDelete() []PendingDeletePack {
+	idx.m.RLock()
+	defer idx.m.RUnlock()
+
+	return append([]PendingDeletePack(nil), idx.pendingDelete...)
+}
+
+func (idx *Index) hasPendingDelete() bool {
+	idx.m.RLock()
+	defer idx.m.RUnlock()
+
+	return len(idx.pendingDelete) > 0
+}
+
+func (idx *Index) generatePendingDeleteList() []pendingDeleteJSON {
+	var list []pendingDeleteJSON
+	for _, p := range idx.pendingDelete {
+		entry := pendingDeleteJSON{ID: p.PackID, Marked: p.Marked}
+		for _, blob := range p.Blobs {
+			entry.Blobs = append(entry.Blobs, blobJSON{
+				ID:                 blob.ID,
+				Type:               blob.Type,
+				Offset:             blob.Offset,
+				Length:             blob.Length,
+				UncompressedLength: blob.UncompressedLength,
+				CompressionLevel:   blob.CompressionLevel,
+			})
+		}
+		list = append(list, entry)
+	}
+	return list
+}
+
+func decodePendingDeleteList(list []pendingDeleteJSON) []PendingDeletePack {
+	var packs []PendingDeletePack
+	for _, entry := range list {
+		p := PendingDeletePack{PackID: entry.ID, Marked: entry.Marked}
+		for _, blob := range entry.Blobs {
+			p.Blobs = append(p.Blobs, pack.Blob{
+				BlobHandle:         restic.BlobHandle{Type: blob.Type, ID: blob.ID},
+				Offset:             blob.Offset,
+				Length:             blob.Length,
+				UncompressedLength: blob.UncompressedLength,
+				CompressionLevel:   blob.CompressionLevel,
+			})
+		}
+		packs = append(packs, p)
+	}
+	return packs
+}
+
+// PendingDelete returns the pack files pending deletion recorded in all
+// loaded index files. If a pack file was marked several times, the latest mark
+// is returned.
+func (mi *MasterIndex) PendingDelete() map[restic.ID]PendingDeletePack {
+	mi.idxMutex.RLock()
+	defer mi.idxMutex.RUnlock()
+
+	packs := make(map[restic.ID]PendingDeletePack)
+	for _, idx := range mi.idx {
+		for _, p := range idx.PendingDelete() {
+			addPendingDelete(packs, p)
+		}
+	}
+	return packs
+}
+
+// addPendingDelete adds p to packs, keeping the latest mark of each pack file.
+func addPendingDelete(packs map[restic.ID]PendingDeletePack, p PendingDeletePack) {
+	if old, ok := packs[p.PackID]; ok {
+		if old.Marked.After(p.Marked) {
+			p.Marked = old.Marked
+		}
+		if len(p.Blobs) == 0 {
+			p.Blobs = old.Blobs
+		}
+	}
+	packs[p.PackID] = p
+}
+
+// SavePendingDelete saves a new index file which records that packs are
+// pending deletion and returns its ID. The index file contains no regular
+// index entries.
+func SavePendingDelete(ctx context.Context, repo restic.SaverUnpacked[restic.FileType], packs []PendingDeletePack) (restic.ID, error) {
+	if len(packs) == 0 {
+		return restic.ID{}, nil
+	}
+	idx := NewIndex()
+	for _, p := range packs {
+		idx.AddPendingDelete(p)
+	}
+	idx.Finalize()
+	return idx.SaveIndex(ctx, repo, FormatJSON)
+}
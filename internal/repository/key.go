@@ -111,14 +111,16 @@ func openKey(ctx context.Context, s *Repository, id restic.ID, password string)
 // searchKey tries to decrypt at most maxKeys keys in the backend with the
 // given password. If none could be found, ErrNoKeyFound is returned. When
 // maxKeys is reached, ErrMaxKeysReached is returned. When setting maxKeys to
-// zero, all keys in the repo are checked.
-func searchKey(ctx context.Context, s *Repository, password string, maxKeys int, keyHint string) (k *Key, err error) {
+// zero, all keys in the repo are checked. Keys in skip are ignored.
+func searchKey(ctx context.Context, s *Repository, password string, maxKeys int, keyHint string, skip restic.IDSet) (k *Key, err error) {
 	checked := 0
 
 	if len(keyHint) > 0 {
 		id, err := restic.Find(ctx, s, restic.KeyFile, keyHint)
 
-		if err == nil {
+		if err == nil && skip.Has(id) {
+			debug.Log("skipping hinted key %v", id)
+		} else if err == nil {
 			key, err := openKey(ctx, s, id, password)
 
 			if err == nil {
@@ -137,6 +139,9 @@ func searchKey(ctx context.Context, s *Repository, password string, maxKeys int,
 
 	// try at most maxKeys keys in repo
 	err = s.List(listCtx, restic.KeyFile, func(id restic.ID, _ int64) error {
+		if skip.Has(id) {
+			return nil
+		}
 		checked++
 		if maxKeys > 0 && checked > maxKeys {
 			return ErrMaxKeysReached
@@ -54,7 +54,17 @@ func LockRepo(ctx context.Context, repo *Repository, exclusive bool, retryLock t
 	return lockerInst.Lock(ctx, repo, exclusive, retryLock, printRetry, logger)
 }
 
+// LockRepoDelayedPrune acquires the non-exclusive lock of a prune which delays
+// the deletion of pack files. At most one such lock can exist at a time.
+func LockRepoDelayedPrune(ctx context.Context, repo *Repository, retryLock time.Duration, printRetry func(msg string), logger func(format string, args ...interface{})) (Unlocker, context.Context, error) {
+	return lockerInst.lock(ctx, repo, Lock{DelayedPrune: true}, retryLock, printRetry, logger)
+}
+
 func (l *locker) Lock(ctx context.Context, r *Repository, exclusive bool, retryLock time.Duration, printRetry func(msg string), logger func(format string, args ...interface{})) (*unlocker, context.Context, error) {
+	return l.lock(ctx, r, Lock{Exclusive: exclusive}, retryLock, printRetry, logger)
+}
+
+func (l *locker) lock(ctx context.Context, r *Repository, kind Lock, retryLock time.Duration, printRetry func(msg string), logger func(format string, args ...interface{})) (*unlocker, context.Context, error) {
 	var lock *lockHandle
 	var err error
 
@@ -66,7 +76,7 @@ func (l *locker) Lock(ctx context.Context, r *Repository, exclusive bool, retryL
 
 retryLoop:
 	for {
-		lock, err = newLock(ctx, repo, exclusive)
+		lock, err = newLockOfKind(ctx, repo, kind)
 		if err != nil && IsAlreadyLocked(err) {
 
 			if !retryMessagePrinted {
@@ -83,7 +93,7 @@ retryLoop:
 			case <-retryTimeout:
 				debug.Log("repo already locked, timeout expired")
 				// Last lock attempt
-				lock, err = newLock(ctx, repo, exclusive)
+				lock, err = newLockOfKind(ctx, repo, kind)
 				break retryLoop
 			case <-retrySleepCh:
 				retrySleep = minDuration(retrySleep*2, l.retrySleepMax)
@@ -99,7 +109,7 @@ retryLoop:
 	if err != nil {
 		return nil, ctx, fmt.Errorf("unable to create lock in backend: %w", err)
 	}
-	debug.Log("create lock %p (exclusive %v)", lock, exclusive)
+	debug.Log("create lock %p (exclusive %v, delayed prune %v)", lock, kind.Exclusive, kind.DelayedPrune)
 
 	ctx, cancel := context.WithCancel(ctx)
 	unlocker := &unlocker{
@@ -295,6 +305,30 @@ func RemoveStaleLocks(ctx context.Context, repo *Repository) (uint, error) {
 	return processed, err
 }
 
+// OldestLockCreated returns the time at which the oldest lock in the
+// repository was created. Stale locks are ignored. Locks which cannot be loaded
+// or were created by restic versions which do not record the creation time
+// are treated as the oldest possible locks. If there are no locks, the current
+// time is returned.
+func OldestLockCreated(ctx context.Context, repo *Repository) (time.Time, error) {
+	oldest := time.Now()
+	err := forAllLocks(ctx, repo, nil, func(id restic.ID, lock *lockHandle, err error) error {
+		if err != nil {
+			debug.Log("lock %v cannot be loaded: %v", id, err)
+			oldest = time.Time{}
+			return nil
+		}
+		if lock.stale() {
+			return nil
+		}
+		if lock.Created.Before(oldest) {
+			oldest = lock.Created
+		}
+		return nil
+	})
+	return oldest, err
+}
+
 // RemoveAllLocks removes all locks forcefully.
 func RemoveAllLocks(ctx context.Context, repo *Repository) (uint, error) {
 	var processed uint32
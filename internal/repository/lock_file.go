@@ -21,17 +21,25 @@ const unlockCancelDelay = 1 * time.Minute
 // Lock is the in-repository representation of a repository lock file.
 // There are two types of locks: exclusive and non-exclusive. There may be many
 // different non-exclusive locks, but at most one exclusive lock, which can
-// only be acquired while no non-exclusive lock is held.
+// only be acquired while no non-exclusive lock is held. Non-exclusive locks of
+// a prune with delayed deletion also exclude each other.
 //
 // A lock must be refreshed regularly to not be considered stale.
 type Lock struct {
-	Time      time.Time `json:"time"`
+	Time time.Time `json:"time"`
+	// Created is the time the lock was first created. Unlike Time, it is not
+	// updated when the lock is refreshed. Locks created by older restic
+	// versions do not contain it.
+	Created   time.Time `json:"created"`
 	Exclusive bool      `json:"exclusive"`
-	Hostname  string    `json:"hostname"`
-	Username  string    `json:"username"`
-	PID       int       `json:"pid"`
-	UID       uint32    `json:"uid,omitempty"`
-	GID       uint32    `json:"gid,omitempty"`
+	// DelayedPrune is set for the non-exclusive lock of a prune which delays
+	// the deletion of pack files.
+	DelayedPrune bool   `json:"delayed_prune,omitempty"`
+	Hostname     string `json:"hostname"`
+	Username     string `json:"username"`
+	PID          int    `json:"pid"`
+	UID          uint32 `json:"uid,omitempty"`
+	GID          uint32 `json:"gid,omitempty"`
 }
 
 // lockHandle is a reference to a lock file in the repository.
@@ -50,6 +58,8 @@ func (e *alreadyLockedError) Error() string {
 	s := ""
 	if e.otherLock.Exclusive {
 		s = "exclusively "
+	} else if e.otherLock.DelayedPrune {
+		s = "for prune with delayed deletion "
 	}
 	return fmt.Sprintf("repository is already locked %sby %v", s, e.otherLock)
 }
@@ -100,11 +110,20 @@ func TestSetLockTimeout(t testing.TB, d time.Duration) {
 // that satisfies IsAlreadyLocked. If the new lock is exclusive, then other
 // non-exclusive locks also result in an IsAlreadyLocked error.
 func newLock(ctx context.Context, repo restic.Unpacked[restic.FileType], exclusive bool) (*lockHandle, error) {
+	return newLockOfKind(ctx, repo, Lock{Exclusive: exclusive})
+}
+
+// newLockOfKind works like newLock, but uses the Exclusive and DelayedPrune
+// fields of kind.
+func newLockOfKind(ctx context.Context, repo restic.Unpacked[restic.FileType], kind Lock) (*lockHandle, error) {
+	now := time.Now()
 	lock := &lockHandle{
 		Lock: Lock{
-			Time:      time.Now(),
-			PID:       os.Getpid(),
-			Exclusive: exclusive,
+			Time:         now,
+			Created:      now,
+			PID:          os.Getpid(),
+			Exclusive:    kind.Exclusive,
+			DelayedPrune: kind.DelayedPrune,
 		},
 		repo: repo,
 	}
@@ -183,7 +202,7 @@ func (l *lockHandle) checkForOtherLocks(ctx context.Context) error {
 				return err
 			}
 
-			if l.Exclusive || lock.Exclusive {
+			if l.Exclusive || lock.Exclusive || (l.DelayedPrune && lock.DelayedPrune) {
 				return &alreadyLockedError{otherLock: lock}
 			}
 
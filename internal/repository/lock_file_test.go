@@ -119,6 +119,25 @@ func TestExclusiveLockOnLockedRepo(t *testing.T) {
 	rtest.OK(t, elock.unlock(context.TODO()))
 }
 
+func TestDelayedPruneLock(t *testing.T) {
+	repo := TestRepository(t)
+	TestSetLockTimeout(t, 5*time.Millisecond)
+
+	plock, err := newLockOfKind(context.TODO(), &internalRepository{repo}, Lock{DelayedPrune: true})
+	rtest.OK(t, err)
+
+	// other non-exclusive locks are not affected
+	lock, err := newLock(context.TODO(), &internalRepository{repo}, false)
+	rtest.OK(t, err)
+
+	_, err = newLockOfKind(context.TODO(), &internalRepository{repo}, Lock{DelayedPrune: true})
+	rtest.Assert(t, IsAlreadyLocked(err),
+		"create second delayed prune lock didn't return the correct error, got %v", err)
+
+	rtest.OK(t, lock.unlock(context.TODO()))
+	rtest.OK(t, plock.unlock(context.TODO()))
+}
+
 var staleLockTests = []struct {
 	timestamp        time.Time
 	stale            bool
@@ -217,6 +236,8 @@ func testLockRefresh(t *testing.T, refresh func(lock *lockHandle) error) {
 	rtest.OK(t, err)
 	rtest.Assert(t, lock2.Time.After(time0),
 		"expected a later timestamp after lock refresh")
+	rtest.Assert(t, lock2.Created.Equal(time0),
+		"expected unchanged creation time after lock refresh")
 	rtest.OK(t, lock.unlock(context.TODO()))
 }
 
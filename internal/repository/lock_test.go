@@ -354,6 +354,34 @@ func TestLockWithStaleLock(t *testing.T) {
 	rtest.OK(t, removeLock(repo, id2))
 }
 
+func TestOldestLockCreated(t *testing.T) {
+	repo := TestRepository(t)
+
+	before := time.Now()
+	oldest, err := OldestLockCreated(context.TODO(), repo)
+	rtest.OK(t, err)
+	rtest.Assert(t, !oldest.Before(before), "unexpected time %v without locks", oldest)
+
+	lock, err := newLock(context.TODO(), &internalRepository{repo}, false)
+	rtest.OK(t, err)
+	// stale locks are ignored
+	_, err = createFakeLock(repo, time.Now().Add(-time.Minute), os.Getpid()+500000)
+	rtest.OK(t, err)
+	oldest, err = OldestLockCreated(context.TODO(), repo)
+	rtest.OK(t, err)
+	rtest.Assert(t, oldest.Equal(lock.Created), "expected %v, got %v", lock.Created, oldest)
+
+	// locks of older restic versions have no creation time
+	id, err := createFakeLock(repo, time.Now(), os.Getpid())
+	rtest.OK(t, err)
+	oldest, err = OldestLockCreated(context.TODO(), repo)
+	rtest.OK(t, err)
+	rtest.Assert(t, oldest.IsZero(), "expected zero time, got %v", oldest)
+
+	rtest.OK(t, removeLock(repo, id))
+	rtest.OK(t, lock.unlock(context.TODO()))
+}
+
 func TestRemoveAllLocks(t *testing.T) {
 	repo := TestRepository(t)
 
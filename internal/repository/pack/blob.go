@@ -13,6 +13,10 @@ type Blob struct {
 	Length             uint
 	Offset             uint
 	UncompressedLength uint
+	// CompressionLevel is the zstd level used to compress the blob. It is only
+	// stored in the index and is zero if the blob is not compressed or the
+	// level is unknown.
+	CompressionLevel uint8
 }
 
 func (b Blob) String() string {
@@ -267,12 +267,28 @@ func (r *Repository) savePacker(ctx context.Context, t restic.BlobType, p *packe
 
 	debug.Log("saved as %v", h)
 
+	if r.cfg.ParityRedundancy > 0 {
+		err = r.saveParity(ctx, id, p.tmpfile, rrd.Length())
+		if err != nil {
+			return err
+		}
+	}
+
 	err = p.tmpfile.Close()
 	if err != nil {
 		return errors.Wrap(err, "close tempfile")
 	}
 
+	// the compression level is not part of the pack header, but recorded in the index
+	blobs := p.Packer.Blobs()
+	level := r.compressionLevel()
+	for i := range blobs {
+		if blobs[i].IsCompressed() {
+			blobs[i].CompressionLevel = level
+		}
+	}
+
 	// update blobs in the index
-	debug.Log("  updating blobs %v to pack %v", p.Packer.Blobs(), id)
-	return r.idx.StorePack(ctx, id, p.Packer.Blobs(), &internalRepository{r})
+	debug.Log("  updating blobs %v to pack %v", blobs, id)
+	return r.idx.StorePack(ctx, id, blobs, &internalRepository{r})
 }
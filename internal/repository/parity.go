@@ -0,0 +1,205 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"io"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository/pack"
+	"github.com/restic/restic/internal/repository/parity"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/ui/progress"
+)
+
+// RequestedParityRedundancy returns the parity redundancy specified in the
+// options, which can differ from the value stored in the config.
+func (r *Repository) RequestedParityRedundancy() uint {
+	return r.opts.ParityRedundancy
+}
+
+// UpdateParityRedundancy stores the parity redundancy specified in the options
+// in the config. Only pack files written afterwards use the new value. The
+// original config file is restored if saving the new config fails.
+func UpdateParityRedundancy(ctx context.Context, repo *Repository) error {
+	cfg := repo.Config()
+	cfg.ParityRedundancy = repo.opts.ParityRedundancy
+	err := replaceConfigWithBackup(ctx, repo, cfg, "restic-migrate-parity-redundancy-")
+	if err != nil {
+		return err
+	}
+	repo.setConfig(cfg)
+	return nil
+}
+
+// saveParity computes the recovery data for the pack file id of the given size
+// and stores it as a parity file.
+func (r *Repository) saveParity(ctx context.Context, id restic.ID, rd io.ReaderAt, size int64) error {
+	buf, err := parity.Encode(rd, size, id, r.cfg.ParityRedundancy)
+	if err != nil {
+		return err
+	}
+
+	h := backend.Handle{Type: restic.ParityFile, Name: id.String()}
+	err = r.be.Save(ctx, h, backend.NewByteReader(buf, r.be.Hasher()))
+	if err != nil {
+		debug.Log("Save(%v) error: %v", h, err)
+		return err
+	}
+	debug.Log("saved parity for %v with %d bytes", id, len(buf))
+	return nil
+}
+
+// listParityFiles returns the pack files for which a parity file exists.
+func listParityFiles(ctx context.Context, repo *Repository) (restic.IDSet, error) {
+	ids := restic.NewIDSet()
+	err := repo.List(ctx, restic.ParityFile, func(id restic.ID, _ int64) error {
+		ids.Insert(id)
+		return nil
+	})
+	return ids, err
+}
+
+// removeParityFiles removes the parity files of the given pack files, if they exist.
+func removeParityFiles(ctx context.Context, repo *Repository, packs restic.IDSet, printer progress.Printer) error {
+	parityFiles, err := listParityFiles(ctx, repo)
+	if err != nil {
+		return err
+	}
+	obsolete := parityFiles.Intersect(packs)
+	if len(obsolete) == 0 {
+		return nil
+	}
+	printer.P("removing %d parity files", len(obsolete))
+	return deleteFiles(ctx, true, &internalRepository{repo}, obsolete, restic.ParityFile, printer)
+}
+
+// ReconstructPacks repairs the damaged pack files ids using their parity
+// files. It returns the pack files which could not be reconstructed. Pack
+// files which are not damaged are left untouched.
+//
+// If the backend can atomically replace files, the damaged pack files are
+// replaced by the reconstructed ones. Otherwise, the blobs of the reconstructed
+// pack files are stored in new pack files and the damaged ones are only
+// removed afterwards.
+func ReconstructPacks(ctx context.Context, repo *Repository, ids restic.IDSet, printer progress.Printer) (restic.IDSet, error) {
+	printer.P("reconstructing damaged pack files using parity data")
+	bar := printer.NewCounter("pack files")
+	bar.SetMax(uint64(len(ids)))
+	defer bar.Done()
+
+	replace := repo.be.Properties().HasAtomicReplace
+	failed := restic.NewIDSet()
+	reuploaded := restic.NewIDSet()
+	err := repo.WithBlobUploader(ctx, func(ctx context.Context, uploader restic.BlobSaverWithAsync) error {
+		for id := range ids {
+			err := reconstructPack(ctx, repo, id, replace, uploader, printer)
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			switch {
+			case errors.Is(err, errPackNotDamaged):
+				printer.P("pack %v is not damaged", id)
+			case err != nil:
+				printer.E("unable to reconstruct pack %v: %v", id, err)
+				failed.Insert(id)
+			case !replace:
+				reuploaded.Insert(id)
+			}
+			bar.Add(1)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	bar.Done()
+
+	if len(reuploaded) != 0 {
+		// the blobs are now stored in new pack files
+		err = rewriteIndexFiles(ctx, repo, reuploaded, nil, nil, printer)
+		if err != nil {
+			return nil, err
+		}
+		printer.P("removing reconstructed pack files")
+		// if we fail to delete the damaged pack files, then prune will remove them later on
+		_ = deleteFiles(ctx, true, &internalRepository{repo}, reuploaded, restic.PackFile, printer)
+		_ = removeParityFiles(ctx, repo, reuploaded, printer)
+	}
+	return failed, nil
+}
+
+// errPackNotDamaged is returned by reconstructPack for intact pack files, which
+// are left untouched.
+var errPackNotDamaged = errors.New("pack is not damaged")
+
+// reconstructPack reconstructs the pack file id. If replace is set, the pack
+// file is replaced, otherwise its blobs are stored again using uploader.
+func reconstructPack(ctx context.Context, repo *Repository, id restic.ID, replace bool, uploader restic.BlobSaver, printer progress.Printer) error {
+	if repo.cache != nil {
+		// the cache may still contain an intact copy of a damaged tree pack
+		_ = repo.cache.Forget(backend.Handle{Type: restic.PackFile, Name: id.String()})
+	}
+	buf, err := repo.LoadRaw(ctx, restic.PackFile, id)
+	if err == nil {
+		return errPackNotDamaged
+	}
+	if !errors.Is(err, restic.ErrInvalidData) && !repo.be.IsNotExist(err) {
+		return err
+	}
+
+	par, err := loadRaw(ctx, repo.be, backend.Handle{Type: restic.ParityFile, Name: id.String()})
+	if repo.be.IsNotExist(err) {
+		return errors.New("no parity file found")
+	}
+	if err != nil {
+		return err
+	}
+
+	data, damaged, err := parity.Reconstruct(id, buf, par)
+	if err != nil {
+		return err
+	}
+
+	var blobs pack.Blobs
+	for pbs := range repo.idx.ListPacks(ctx, restic.NewIDSet(id)) {
+		blobs = pbs.Blobs
+	}
+
+	if replace {
+		// tree packs are stored like all other metadata
+		isMetadata := len(blobs) > 0 && blobs[0].Type.IsMetadata()
+		h := backend.Handle{Type: restic.PackFile, Name: id.String(), IsMetadata: isMetadata}
+		// the damaged pack file is only replaced once the upload is complete
+		err = repo.be.Save(ctx, h, backend.NewByteReader(data, repo.be.Hasher()))
+		if err != nil {
+			return err
+		}
+		printer.P("reconstructed pack %v, repaired %d damaged shards", id, damaged)
+		return nil
+	}
+
+	if len(blobs) == 0 {
+		return errors.New("pack is not contained in the index")
+	}
+	loadReconstructed := func(_ context.Context, _ backend.Handle, length int, offset int64, fn func(rd io.Reader) error) error {
+		if offset+int64(length) > int64(len(data)) {
+			return io.ErrUnexpectedEOF
+		}
+		return fn(bytes.NewReader(data[offset : offset+int64(length)]))
+	}
+	err = streamPack(ctx, loadReconstructed, repo.LoadBlob, repo.getZstdDecoder(), repo.key, repo.fallbackKey(), id, blobs, func(blob restic.BlobHandle, buf []byte, err error) error {
+		if err != nil {
+			return err
+		}
+		_, _, _, err = uploader.SaveBlob(ctx, blob.Type, buf, blob.ID, true)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	printer.P("reconstructed pack %v, repaired %d damaged shards, stored its blobs in new pack files", id, damaged)
+	return nil
+}
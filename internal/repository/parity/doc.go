@@ -0,0 +1,8 @@
+// Package parity implements Reed-Solomon recovery data for pack files.
+//
+// A pack file is split into data shards of equal size, for which a number of
+// parity shards is computed. Each shard is protected by a checksum, such that
+// damaged shards can be identified and treated as erasures. A damaged pack file
+// can be reconstructed as long as the number of damaged shards does not exceed
+// the number of intact parity shards.
+package parity
@@ -0,0 +1,115 @@
+package parity
+
+// Arithmetic in GF(2^8) using the reducing polynomial x^8+x^4+x^3+x^2+1 (0x11d)
+// and the generator 2.
+
+var (
+	expTable [510]byte
+	logTable [256]byte
+	// mulTable contains the product of all pairs of field elements.
+	mulTable [256][256]byte
+)
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		expTable[i] = byte(x)
+		expTable[i+255] = byte(x)
+		logTable[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+
+	for a := 1; a < 256; a++ {
+		for b := 1; b < 256; b++ {
+			mulTable[a][b] = expTable[int(logTable[a])+int(logTable[b])]
+		}
+	}
+}
+
+func gfMul(a, b byte) byte {
+	return mulTable[a][b]
+}
+
+// gfInv returns the multiplicative inverse of a, which must not be zero.
+func gfInv(a byte) byte {
+	if a == 0 {
+		panic("inverse of zero")
+	}
+	return expTable[255-int(logTable[a])]
+}
+
+// mulAdd adds c*src to dst.
+func mulAdd(dst, src []byte, c byte) {
+	if c == 0 {
+		return
+	}
+	t := &mulTable[c]
+	dst = dst[:len(src)]
+	for i, v := range src {
+		dst[i] ^= t[v]
+	}
+}
+
+// parityMatrix returns the Cauchy matrix used to compute the parity shards.
+// Together with the identity matrix for the data shards, every square matrix
+// built from dataShards of its rows is invertible.
+func parityMatrix(dataShards, parityShards int) [][]byte {
+	m := make([][]byte, parityShards)
+	for i := range m {
+		m[i] = make([]byte, dataShards)
+		for j := range m[i] {
+			// all x_i = dataShards+i and y_j = j are distinct, thus x_i+y_j is never zero
+			m[i][j] = gfInv(byte(dataShards+i) ^ byte(j))
+		}
+	}
+	return m
+}
+
+// invertMatrix inverts the square matrix m using Gauss-Jordan elimination.
+// It returns false if m is singular.
+func invertMatrix(m [][]byte) ([][]byte, bool) {
+	n := len(m)
+	work := make([][]byte, n)
+	inv := make([][]byte, n)
+	for i := range m {
+		work[i] = append([]byte(nil), m[i]...)
+		inv[i] = make([]byte, n)
+		inv[i][i] = 1
+	}
+
+	for col := 0; col < n; col++ {
+		pivot := -1
+		for row := col; row < n; row++ {
+			if work[row][col] != 0 {
+				pivot = row
+				break
+			}
+		}
+		if pivot < 0 {
+			return nil, false
+		}
+		work[col], work[pivot] = work[pivot], work[col]
+		inv[col], inv[pivot] = inv[pivot], inv[col]
+
+		if c := work[col][col]; c != 1 {
+			f := gfInv(c)
+			for k := 0; k < n; k++ {
+				work[col][k] = gfMul(work[col][k], f)
+				inv[col][k] = gfMul(inv[col][k], f)
+			}
+		}
+
+		for row := 0; row < n; row++ {
+			if row == col || work[row][col] == 0 {
+				continue
+			}
+			f := work[row][col]
+			mulAdd(work[row], work[col], f)
+			mulAdd(inv[row], inv[col], f)
+		}
+	}
+	return inv, true
+}
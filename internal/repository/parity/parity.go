@@ -0,0 +1,288 @@
+package parity
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// A parity file has the following structure, all integers are little endian:
+//
+//	magic         [4]byte "RPAR"
+//	version       uint8
+//	reserved      [3]byte
+//	pack ID       [32]byte
+//	pack size     uint64
+//	shard size    uint32
+//	data shards   uint16
+//	parity shards uint16
+//	checksums     [data shards + parity shards][32]byte
+//	header hash   [32]byte, SHA-256 of all fields above
+//	parity data   [parity shards][shard size]byte
+//
+// The last data shard is padded with zeros to the shard size. The checksums
+// are the SHA-256 hashes of the (padded) data shards followed by those of the
+// parity shards.
+
+const (
+	// MaxRedundancy is the maximum redundancy in percent of the pack size.
+	MaxRedundancy = 100
+
+	version         = 1
+	headerSize      = 4 + 4 + 32 + 8 + 4 + 2 + 2
+	maxShards       = 255
+	minShardSize    = 4096
+	encodeChunkSize = 64 * 1024
+)
+
+var magic = []byte("RPAR")
+
+// ErrInvalidParity is returned if a parity file is damaged or does not belong
+// to the pack file.
+var ErrInvalidParity = errors.New("invalid parity file")
+
+// ErrTooManyDamaged is returned if more shards of a pack file are damaged
+// than can be reconstructed.
+var ErrTooManyDamaged = errors.New("too many damaged shards")
+
+type header struct {
+	packID       restic.ID
+	packSize     int64
+	shardSize    int
+	dataShards   int
+	parityShards int
+	checksums    []restic.ID
+}
+
+// shardLayout returns the number and size of the shards for a pack file of
+// the given size. There is always at least one parity shard.
+func shardLayout(size int64, redundancy uint) (dataShards, parityShards, shardSize int) {
+	parityFor := func(data int) int {
+		return max((data*int(redundancy)+99)/100, 1)
+	}
+
+	dataShards = maxShards * 100 / (100 + int(redundancy))
+	if blocks := (size + minShardSize - 1) / minShardSize; blocks < int64(dataShards) {
+		dataShards = max(int(blocks), 1)
+	}
+	for dataShards > 1 && dataShards+parityFor(dataShards) > maxShards {
+		dataShards--
+	}
+	parityShards = parityFor(dataShards)
+	shardSize = max(int((size+int64(dataShards)-1)/int64(dataShards)), 1)
+	return dataShards, parityShards, shardSize
+}
+
+// Size returns the size of the parity file for a pack file of the given size.
+func Size(size int64, redundancy uint) int64 {
+	dataShards, parityShards, shardSize := shardLayout(size, redundancy)
+	return headerSize + int64(dataShards+parityShards+1)*sha256.Size + int64(parityShards)*int64(shardSize)
+}
+
+// Encode computes the parity file for the pack file id of the given size,
+// which is read from rd. The redundancy is specified in percent of the pack
+// size and must be between 1 and MaxRedundancy.
+func Encode(rd io.ReaderAt, size int64, id restic.ID, redundancy uint) ([]byte, error) {
+	if redundancy == 0 || redundancy > MaxRedundancy {
+		return nil, errors.Errorf("invalid redundancy %d%%", redundancy)
+	}
+
+	dataShards, parityShards, shardSize := shardLayout(size, redundancy)
+	matrix := parityMatrix(dataShards, parityShards)
+
+	parity := make([][]byte, parityShards)
+	for i := range parity {
+		parity[i] = make([]byte, shardSize)
+	}
+
+	checksums := make([]restic.ID, 0, dataShards+parityShards)
+	buf := make([]byte, min(shardSize, encodeChunkSize))
+	for j := 0; j < dataShards; j++ {
+		h := sha256.New()
+		for off := 0; off < shardSize; off += len(buf) {
+			chunk := buf[:min(len(buf), shardSize-off)]
+			if err := readShard(rd, size, int64(j)*int64(shardSize)+int64(off), chunk); err != nil {
+				return nil, err
+			}
+			_, _ = h.Write(chunk)
+			for i := range parity {
+				mulAdd(parity[i][off:], chunk, matrix[i][j])
+			}
+		}
+		checksums = append(checksums, restic.IDFromHash(h.Sum(nil)))
+	}
+	for _, p := range parity {
+		checksums = append(checksums, restic.Hash(p))
+	}
+
+	hdr := header{
+		packID:       id,
+		packSize:     size,
+		shardSize:    shardSize,
+		dataShards:   dataShards,
+		parityShards: parityShards,
+		checksums:    checksums,
+	}
+	out := make([]byte, 0, Size(size, redundancy))
+	out = hdr.append(out)
+	for _, p := range parity {
+		out = append(out, p...)
+	}
+	return out, nil
+}
+
+// readShard fills buf with the data at offset off of the pack file, data
+// beyond the end of the pack file is set to zero.
+func readShard(rd io.ReaderAt, size int64, off int64, buf []byte) error {
+	n := 0
+	if off < size {
+		n = int(min(int64(len(buf)), size-off))
+		if _, err := rd.ReadAt(buf[:n], off); err != nil {
+			return errors.Wrap(err, "ReadAt")
+		}
+	}
+	clear(buf[n:])
+	return nil
+}
+
+func (h *header) append(buf []byte) []byte {
+	start := len(buf)
+	buf = append(buf, magic...)
+	buf = append(buf, version, 0, 0, 0)
+	buf = append(buf, h.packID[:]...)
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(h.packSize))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(h.shardSize))
+	buf = binary.LittleEndian.AppendUint16(buf, uint16(h.dataShards))
+	buf = binary.LittleEndian.AppendUint16(buf, uint16(h.parityShards))
+	for _, c := range h.checksums {
+		buf = append(buf, c[:]...)
+	}
+	sum := sha256.Sum256(buf[start:])
+	return append(buf, sum[:]...)
+}
+
+// decodeHeader parses and verifies the header of a parity file and returns
+// the header along with the parity data.
+func decodeHeader(buf []byte) (*header, []byte, error) {
+	if len(buf) < headerSize || !bytes.Equal(buf[:4], magic) {
+		return nil, nil, errors.Wrap(ErrInvalidParity, "header not found")
+	}
+	if buf[4] != version {
+		return nil, nil, errors.Wrapf(ErrInvalidParity, "unsupported version %d", buf[4])
+	}
+
+	h := &header{}
+	copy(h.packID[:], buf[8:40])
+	h.packSize = int64(binary.LittleEndian.Uint64(buf[40:]))
+	h.shardSize = int(binary.LittleEndian.Uint32(buf[48:]))
+	h.dataShards = int(binary.LittleEndian.Uint16(buf[52:]))
+	h.parityShards = int(binary.LittleEndian.Uint16(buf[54:]))
+
+	shards := h.dataShards + h.parityShards
+	if h.dataShards == 0 || h.parityShards == 0 || shards > maxShards || h.shardSize == 0 ||
+		h.packSize < 0 || h.packSize > int64(h.dataShards)*int64(h.shardSize) {
+		return nil, nil, errors.Wrap(ErrInvalidParity, "invalid shard layout")
+	}
+	end := headerSize + shards*sha256.Size
+	if len(buf) < end+sha256.Size {
+		return nil, nil, errors.Wrap(ErrInvalidParity, "header truncated")
+	}
+	if sha256.Sum256(buf[:end]) != [32]byte(buf[end:end+sha256.Size]) {
+		return nil, nil, errors.Wrap(ErrInvalidParity, "header checksum mismatch")
+	}
+	for i := 0; i < shards; i++ {
+		var c restic.ID
+		copy(c[:], buf[headerSize+i*sha256.Size:])
+		h.checksums = append(h.checksums, c)
+	}
+	return h, buf[end+sha256.Size:], nil
+}
+
+// Reconstruct repairs the damaged pack file id using the parity file par. The
+// pack file may be truncated or contain trailing garbage. It returns the
+// reconstructed pack file and the number of data shards which were damaged.
+// The reconstructed pack file is verified against the pack ID.
+func Reconstruct(id restic.ID, pack []byte, par []byte) ([]byte, int, error) {
+	h, parity, err := decodeHeader(par)
+	if err != nil {
+		return nil, 0, err
+	}
+	if h.packID != id {
+		return nil, 0, errors.Wrapf(ErrInvalidParity, "parity file belongs to pack %v", h.packID.Str())
+	}
+
+	shards := make([][]byte, h.dataShards+h.parityShards)
+	var damaged []int
+	intact := make([]int, 0, h.dataShards)
+	for j := 0; j < h.dataShards; j++ {
+		shard := make([]byte, h.shardSize)
+		_ = readShard(bytes.NewReader(pack), min(int64(len(pack)), h.packSize), int64(j)*int64(h.shardSize), shard)
+		if restic.Hash(shard) != h.checksums[j] {
+			damaged = append(damaged, j)
+			continue
+		}
+		shards[j] = shard
+		intact = append(intact, j)
+	}
+	if len(damaged) > 0 {
+		for i := 0; i < h.parityShards && len(intact) < h.dataShards; i++ {
+			start := i * h.shardSize
+			if start+h.shardSize > len(parity) {
+				break
+			}
+			shard := parity[start : start+h.shardSize]
+			if restic.Hash(shard) != h.checksums[h.dataShards+i] {
+				continue
+			}
+			shards[h.dataShards+i] = shard
+			intact = append(intact, h.dataShards+i)
+		}
+		if len(intact) < h.dataShards {
+			return nil, len(damaged), fmt.Errorf("%w: %d of %d data shards are damaged, but only %d intact parity shards are available",
+				ErrTooManyDamaged, len(damaged), h.dataShards, len(intact)+len(damaged)-h.dataShards)
+		}
+		reconstructShards(h, shards, intact, damaged)
+	}
+
+	out := make([]byte, 0, h.dataShards*h.shardSize)
+	for _, shard := range shards[:h.dataShards] {
+		out = append(out, shard...)
+	}
+	out = out[:h.packSize]
+	if restic.Hash(out) != id {
+		return nil, len(damaged), errors.Errorf("reconstructed pack %v does not match its ID", id.Str())
+	}
+	return out, len(damaged), nil
+}
+
+// reconstructShards recomputes the damaged data shards from the intact shards.
+func reconstructShards(h *header, shards [][]byte, intact []int, damaged []int) {
+	matrix := parityMatrix(h.dataShards, h.parityShards)
+	rows := make([][]byte, h.dataShards)
+	for k, idx := range intact {
+		if idx < h.dataShards {
+			rows[k] = make([]byte, h.dataShards)
+			rows[k][idx] = 1
+		} else {
+			rows[k] = matrix[idx-h.dataShards]
+		}
+	}
+	inv, ok := invertMatrix(rows)
+	if !ok {
+		// cannot happen for a Cauchy matrix
+		panic("decoding matrix is singular")
+	}
+
+	for _, j := range damaged {
+		shard := make([]byte, h.shardSize)
+		for k, idx := range intact {
+			mulAdd(shard, shards[idx], inv[j][k])
+		}
+		shards[j] = shard
+	}
+}
@@ -0,0 +1,137 @@
+package parity
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func randomPack(t testing.TB, size int) ([]byte, restic.ID) {
+	buf := make([]byte, size)
+	_, err := rand.New(rand.NewSource(int64(size))).Read(buf)
+	rtest.OK(t, err)
+	return buf, restic.Hash(buf)
+}
+
+func encode(t testing.TB, pack []byte, id restic.ID, redundancy uint) []byte {
+	par, err := Encode(bytes.NewReader(pack), int64(len(pack)), id, redundancy)
+	rtest.OK(t, err)
+	rtest.Equals(t, Size(int64(len(pack)), redundancy), int64(len(par)))
+	return par
+}
+
+func TestShardLayout(t *testing.T) {
+	for _, redundancy := range []uint{1, 5, 10, 33, 50, 100} {
+		for _, size := range []int64{1, 4095, 4097, 1 << 20, 16 << 20, 128 << 20} {
+			data, parity, shardSize := shardLayout(size, redundancy)
+			rtest.Assert(t, data+parity <= maxShards, "too many shards %d+%d for size %d", data, parity, size)
+			rtest.Assert(t, parity >= 1, "no parity shard for size %d", size)
+			rtest.Assert(t, int64(data)*int64(shardSize) >= size, "shards %d*%d too small for size %d", data, shardSize, size)
+			rtest.Assert(t, uint(parity*100) >= uint(data)*redundancy, "redundancy %d%% not reached: %d/%d", redundancy, parity, data)
+		}
+	}
+}
+
+func TestInvertMatrix(t *testing.T) {
+	m := parityMatrix(5, 5)
+	inv, ok := invertMatrix(m)
+	rtest.Assert(t, ok, "Cauchy matrix is singular")
+	for i := range m {
+		for j := range m {
+			var v byte
+			for k := range m {
+				v ^= gfMul(m[i][k], inv[k][j])
+			}
+			expected := byte(0)
+			if i == j {
+				expected = 1
+			}
+			rtest.Equals(t, expected, v)
+		}
+	}
+}
+
+func TestReconstruct(t *testing.T) {
+	for _, size := range []int{100, 20000, 1<<20 + 17} {
+		pack, id := randomPack(t, size)
+		par := encode(t, pack, id, 10)
+		h, _, err := decodeHeader(par)
+		rtest.OK(t, err)
+
+		// intact pack files are returned as is
+		buf, damaged, err := Reconstruct(id, pack, par)
+		rtest.OK(t, err)
+		rtest.Equals(t, 0, damaged)
+		rtest.Assert(t, bytes.Equal(pack, buf), "intact pack was modified")
+
+		// damage as many shards as there are parity shards
+		broken := append([]byte(nil), pack...)
+		n := min(h.parityShards, h.dataShards)
+		for i := 0; i < n; i++ {
+			pos := (i*h.dataShards/n)*h.shardSize + 3
+			if pos < len(broken) {
+				broken[pos] ^= 0x40
+			}
+		}
+		rtest.Assert(t, restic.Hash(broken) != id, "pack was not damaged")
+		buf, damaged, err = Reconstruct(id, broken, par)
+		rtest.OK(t, err)
+		rtest.Assert(t, damaged > 0, "no damaged shards found")
+		rtest.Assert(t, bytes.Equal(pack, buf), "reconstructed pack does not match")
+
+		// truncated pack files with trailing garbage
+		cut := len(pack) - h.shardSize/2
+		broken = append(pack[:cut:cut], 1, 2, 3)
+		buf, _, err = Reconstruct(id, broken, par)
+		rtest.OK(t, err)
+		rtest.Assert(t, bytes.Equal(pack, buf), "reconstructed pack does not match")
+	}
+}
+
+func TestReconstructDamagedParity(t *testing.T) {
+	pack, id := randomPack(t, 1<<20)
+	par := encode(t, pack, id, 10)
+	h, parity, err := decodeHeader(par)
+	rtest.OK(t, err)
+
+	broken := append([]byte(nil), pack...)
+	broken[10] ^= 1
+	// damage the first parity shard, the remaining ones are still sufficient
+	parity[5] ^= 1
+	buf, damaged, err := Reconstruct(id, broken, par)
+	rtest.OK(t, err)
+	rtest.Equals(t, 1, damaged)
+	rtest.Assert(t, bytes.Equal(pack, buf), "reconstructed pack does not match")
+
+	// damage more data shards than intact parity shards exist
+	for j := 0; j < h.parityShards; j++ {
+		broken[(j+1)*h.shardSize] ^= 1
+	}
+	_, _, err = Reconstruct(id, broken, par)
+	rtest.Assert(t, errors.Is(err, ErrTooManyDamaged), "unexpected error %v", err)
+
+	// damaged header
+	par[60] ^= 1
+	_, _, err = Reconstruct(id, pack, par)
+	rtest.Assert(t, errors.Is(err, ErrInvalidParity), "unexpected error %v", err)
+
+	// parity file of a different pack
+	other, otherID := randomPack(t, 1000)
+	_, _, err = Reconstruct(id, pack, encode(t, other, otherID, 10))
+	rtest.Assert(t, errors.Is(err, ErrInvalidParity), "unexpected error %v", err)
+}
+
+func BenchmarkEncode(b *testing.B) {
+	pack, id := randomPack(b, 16<<20)
+	b.SetBytes(int64(len(pack)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_, err := Encode(bytes.NewReader(pack), int64(len(pack)), id, 10)
+		rtest.OK(b, err)
+	}
+}
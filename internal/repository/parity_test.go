@@ -0,0 +1,136 @@
+package repository_test
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+	"github.com/restic/restic/internal/ui/progress"
+)
+
+// atomicReplaceBackend replaces existing files on Save like backends which
+// support atomic replacement.
+type atomicReplaceBackend struct {
+	backend.Backend
+}
+
+func (be *atomicReplaceBackend) Save(ctx context.Context, h backend.Handle, rd backend.RewindReader) error {
+	err := be.Backend.Remove(ctx, h)
+	if err != nil && !be.Backend.IsNotExist(err) {
+		return err
+	}
+	return be.Backend.Save(ctx, h, rd)
+}
+
+func (be *atomicReplaceBackend) Properties() backend.Properties {
+	props := be.Backend.Properties()
+	props.HasAtomicReplace = true
+	return props
+}
+
+func TestReconstructPacks(t *testing.T) {
+	t.Run("replace", func(t *testing.T) {
+		testReconstructPacks(t, true)
+	})
+	t.Run("reupload", func(t *testing.T) {
+		testReconstructPacks(t, false)
+	})
+}
+
+func testReconstructPacks(t *testing.T, atomicReplace bool) {
+	seed := time.Now().UnixNano()
+	random := rand.New(rand.NewSource(seed))
+	t.Logf("rand initialized with seed %d", seed)
+
+	be := repository.TestBackend(t)
+	if atomicReplace {
+		be = &atomicReplaceBackend{be}
+	}
+	repo, be := repository.TestRepositoryWithBackend(t, be, 0, repository.Options{ParityRedundancy: 10})
+	for i := 0; i < 3; i++ {
+		createRandomBlobs(t, random, repo, 5, 1, false)
+	}
+	packs := listPacks(t, repo)
+	rtest.Equals(t, packs, listFiles(t, repo, restic.ParityFile))
+
+	ids := packs.List()
+	damaged, intact, noParity := ids[0], ids[1], ids[2]
+	// damage a single byte and truncate a pack file, both can be reconstructed
+	replaceFile(t, be, backend.Handle{Type: backend.PackFile, Name: damaged.String()}, func(buf []byte) []byte {
+		buf[len(buf)/2] ^= 0xff
+		return buf[:len(buf)-100]
+	})
+	replaceFile(t, be, backend.Handle{Type: backend.PackFile, Name: noParity.String()}, func(buf []byte) []byte {
+		buf[0] ^= 0xff
+		return buf
+	})
+	rtest.OK(t, be.Remove(context.TODO(), backend.Handle{Type: backend.ParityFile, Name: noParity.String()}))
+
+	failed, err := repository.ReconstructPacks(context.TODO(), repo, restic.NewIDSet(damaged, intact, noParity), progress.NewNoopPrinter())
+	rtest.OK(t, err)
+	rtest.Equals(t, restic.NewIDSet(noParity), failed)
+
+	_, err = repo.LoadRaw(context.TODO(), restic.PackFile, intact)
+	rtest.OK(t, err)
+	if atomicReplace {
+		// the damaged pack file is replaced by the reconstructed one
+		_, err = repo.LoadRaw(context.TODO(), restic.PackFile, damaged)
+		rtest.OK(t, err)
+		rtest.Equals(t, packs, listPacks(t, repo))
+		return
+	}
+
+	// the blobs of the damaged pack file are stored in new pack files
+	after := listPacks(t, repo)
+	rtest.Assert(t, !after.Has(damaged), "damaged pack %v was not removed", damaged)
+	rtest.Assert(t, after.Has(intact) && after.Has(noParity), "unexpected packs %v", after)
+	rtest.Assert(t, len(after.Sub(packs)) > 0, "no new pack files were created")
+	rtest.Equals(t, after.Sub(restic.NewIDSet(noParity)), listFiles(t, repo, restic.ParityFile))
+
+	repo = repository.TestOpenBackend(t, be)
+	rtest.OK(t, repo.LoadIndex(context.TODO(), restic.NoopTerminalCounterFactory))
+	rtest.OK(t, repo.ListBlobs(context.TODO(), func(blob restic.PackBlob) {
+		if blob.PackID() == noParity {
+			return
+		}
+		_, err := repo.LoadBlob(context.TODO(), blob.Handle(), nil)
+		rtest.OK(t, err)
+	}))
+}
+
+func TestPruneParity(t *testing.T) {
+	seed := time.Now().UnixNano()
+	random := rand.New(rand.NewSource(seed))
+	t.Logf("rand initialized with seed %d", seed)
+
+	repo, be := repository.TestRepositoryWithBackend(t, nil, 0, repository.Options{ParityRedundancy: 10})
+	createRandomBlobs(t, random, repo, 5, 0.5, true)
+	createRandomBlobs(t, random, repo, 5, 0.5, true)
+	keep, _ := selectBlobs(t, random, repo, 0.5)
+
+	orphan := restic.NewRandomID()
+	rtest.OK(t, be.Save(context.TODO(), backend.Handle{Type: backend.ParityFile, Name: orphan.String()}, backend.NewByteReader([]byte("orphan"), be.Hasher())))
+
+	opts := repository.PruneOptions{
+		MaxRepackBytes: math.MaxUint64,
+		MaxUnusedBytes: func(used uint64) (unused uint64) { return 0 },
+	}
+	plan, err := repository.PlanPrune(context.TODO(), opts, repo, func(ctx context.Context, repo restic.Repository, usedBlobs restic.FindBlobSet) error {
+		for blob := range keep {
+			usedBlobs.Insert(blob)
+		}
+		return nil
+	}, progress.NewNoopPrinter())
+	rtest.OK(t, err)
+	rtest.OK(t, plan.Execute(context.TODO(), progress.NewNoopPrinter()))
+
+	// only the parity files of the remaining packs are kept, including those of repacked blobs
+	repo = repository.TestOpenBackend(t, be)
+	rtest.Equals(t, listPacks(t, repo), listFiles(t, repo, restic.ParityFile))
+}
@@ -7,7 +7,9 @@ import (
 	"math"
 	"slices"
 	"sort"
+	"time"
 
+	"github.com/restic/restic/internal/backend"
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/repository/index"
@@ -31,6 +33,18 @@ type PruneOptions struct {
 
 	RepackCacheableOnly bool
 	RepackUncompressed  bool
+
+	// CostModel, if set, selects the data packs to repack such that the
+	// expected costs are minimized. MaxUnusedBytes is ignored in this case.
+	CostModel *CostModel
+
+	// DelayDelete only marks obsolete pack files as pending deletion instead of
+	// deleting them. This allows running prune concurrently to other commands.
+	DelayDelete bool
+	// DeletePendingBefore is the time before which pack files must have been
+	// marked as pending deletion to be deleted if DelayDelete is set. It must
+	// be earlier than the creation time of all locks in the repository.
+	DeletePendingBefore time.Time
 }
 
 type PruneStats struct {
@@ -70,7 +84,10 @@ type PruneStats struct {
 		Repack      uint `json:"repack"`
 		Remove      uint `json:"remove"`
 		RemoveTotal uint `json:"remove_total"`
+		Pending     uint `json:"pending"`
+		Resurrect   uint `json:"resurrect"`
 	} `json:"packfiles"`
+	Cost *PruneCostEstimate `json:"cost,omitempty"`
 }
 
 type PrunePlan struct {
@@ -79,6 +96,14 @@ type PrunePlan struct {
 	keepBlobs        *index.AssociatedSet[uint8] // blobs to keep during repacking
 	removePacks      restic.IDSet                // packs to remove
 	ignorePacks      restic.IDSet                // packs to ignore when rebuilding the index
+	deletePending    restic.IDSet                // packs pending deletion to remove now
+	keepPending      restic.IDSet                // packs pending deletion to remove later
+	dropPending      restic.IDSet                // missing packs pending deletion
+	resurrectPacks   restic.IDSet                // packs pending deletion that are used again
+	parityFiles      restic.IDSet                // packs with a parity file
+	orphanParity     restic.IDSet                // parity files without a pack
+
+	pending map[restic.ID]index.PendingDeletePack
 
 	repo  *Repository
 	stats PruneStats
@@ -120,6 +145,11 @@ func PlanPrune(ctx context.Context, opts PruneOptions, repo *Repository, getUsed
 	if opts.SmallPackBytes > uint64(repo.PackSize()) {
 		return nil, fmt.Errorf("repack-smaller-than exceeds repository packsize")
 	}
+	if opts.UnsafeRecovery && opts.DelayDelete {
+		return nil, fmt.Errorf("delayed deletion cannot be used to recover a repository")
+	}
+
+	pending := loadPendingDelete(repo)
 
 	usedBlobs := index.NewAssociatedSet[uint8](repo.idx)
 	err := getUsedBlobs(ctx, repo, usedBlobs)
@@ -128,13 +158,25 @@ func PlanPrune(ctx context.Context, opts PruneOptions, repo *Repository, getUsed
 	}
 
 	printer.P("searching used packs...\n")
-	keepBlobs, indexPack, err := packInfoFromIndex(ctx, repo, usedBlobs, &stats, printer)
+	pendingPacks := restic.NewIDSet()
+	for id, p := range pending {
+		if len(p.Blobs) > 0 {
+			pendingPacks.Insert(id)
+		}
+	}
+	keepBlobs, indexPack, err := packInfoFromIndex(ctx, repo, usedBlobs, pendingPacks, &stats, printer)
+	if err != nil {
+		return nil, err
+	}
+
+	// parity files are uploaded after their pack file and must therefore be listed first
+	parityFiles, err := listParityFiles(ctx, repo)
 	if err != nil {
 		return nil, err
 	}
 
 	printer.P("collecting packs for deletion and repacking\n")
-	plan, err := decidePackAction(ctx, opts, repo, indexPack, &stats, printer)
+	plan, err := decidePackAction(ctx, opts, repo, indexPack, pending, parityFiles, &stats, printer)
 	if err != nil {
 		return nil, err
 	}
@@ -144,7 +186,8 @@ func PlanPrune(ctx context.Context, opts PruneOptions, repo *Repository, getUsed
 		// already contained in kept packs, so delete them from keepBlobs
 		err := repo.ListBlobs(ctx, func(blob restic.PackBlob) {
 			packID := blob.PackID()
-			if plan.removePacks.Has(packID) || plan.repackPacks.Has(packID) {
+			if plan.removePacks.Has(packID) || plan.repackPacks.Has(packID) ||
+				plan.deletePending.Has(packID) || plan.keepPending.Has(packID) {
 				return
 			}
 			keepBlobs.Delete(blob.Handle())
@@ -168,15 +211,47 @@ func PlanPrune(ctx context.Context, opts PruneOptions, repo *Repository, getUsed
 	stats.Size.RemainUnused = stats.Size.Duplicate + stats.Size.Unused - stats.Size.Remove - stats.Size.Repackrm
 	stats.Packs.Total = stats.Packs.Used + stats.Packs.PartlyUsed + stats.Packs.Unused + stats.Packs.Unref
 	stats.Packs.RemoveTotal = stats.Packs.Unref + stats.Packs.Remove
+	if opts.CostModel != nil {
+		est := opts.CostModel.Estimate(stats)
+		stats.Cost = &est
+	}
 
 	plan.repo = repo
 	plan.stats = stats
 	plan.opts = opts
+	plan.pending = pending
 
 	return &plan, nil
 }
 
-func packInfoFromIndex(ctx context.Context, idx restic.ListBlobser, usedBlobs *index.AssociatedSet[uint8], stats *PruneStats, printer progress.Printer) (*index.AssociatedSet[uint8], map[restic.ID]packInfo, error) {
+// loadPendingDelete returns the pack files pending deletion which are not
+// contained in the index. The index entries of these pack files are added to
+// the in-memory index. This allows restoring pack files whose blobs are used
+// again, for example by a backup which ran concurrently to an earlier prune.
+func loadPendingDelete(repo *Repository) map[restic.ID]index.PendingDeletePack {
+	pending := repo.idx.PendingDelete()
+	if len(pending) == 0 {
+		return pending
+	}
+
+	indexed := repo.idx.Packs(nil)
+	idx := index.NewIndex()
+	for id, p := range pending {
+		if indexed.Has(id) {
+			// pack was added to the index again, e.g. by repair index
+			delete(pending, id)
+			continue
+		}
+		if len(p.Blobs) > 0 {
+			idx.StorePack(id, p.Blobs)
+		}
+	}
+	idx.Finalize()
+	repo.idx.Insert(idx)
+	return pending
+}
+
+func packInfoFromIndex(ctx context.Context, idx restic.ListBlobser, usedBlobs *index.AssociatedSet[uint8], pendingPacks restic.IDSet, stats *PruneStats, printer progress.Printer) (*index.AssociatedSet[uint8], map[restic.ID]packInfo, error) {
 	// iterate over all blobs in index to find out which blobs are duplicates
 	// The counter in usedBlobs describes how many instances of the blob exist in the repository index
 	// Thus 0 == blob is missing, 1 == blob exists once, >= 2 == duplicates exist
@@ -288,6 +363,7 @@ func packInfoFromIndex(ctx context.Context, idx restic.ListBlobser, usedBlobs *i
 	// - if a pack only consists of duplicates (which by definition are used blobs), mark it as "used". This
 	//   ensures that already rewritten packs are kept.
 	// - if there are no used blobs in a pack, possibly mark duplicates as "unused"
+	// - only use duplicates in packs pending deletion if no other copy remains
 	if hasDuplicates {
 		// iterate again over all blobs in index (this is pretty cheap, all in-mem)
 		err = idx.ListBlobs(ctx, func(blob restic.PackBlob) {
@@ -302,8 +378,9 @@ func packInfoFromIndex(ctx context.Context, idx restic.ListBlobser, usedBlobs *i
 
 			ip := indexPack[packID]
 			size := uint64(blob.CiphertextLength())
+			isPending := pendingPacks.Has(packID)
 			switch {
-			case ip.usedBlobs > 0, ip.duplicateBlobs == ip.unusedBlobs, count == 0:
+			case !isPending && (ip.usedBlobs > 0 || ip.duplicateBlobs == ip.unusedBlobs), count == 0:
 				// other used blobs in pack, only duplicate blobs or "last" occurrence ->  transition to used
 				// a pack file created by an interrupted prune run will consist of only duplicate blobs
 				// thus select such already repacked pack files
@@ -384,10 +461,19 @@ func calculateTargetPacksize(opts PruneOptions, indexPack map[restic.ID]packInfo
 	return targetPackSize
 }
 
-func decidePackAction(ctx context.Context, opts PruneOptions, repo *Repository, indexPack map[restic.ID]packInfo, stats *PruneStats, printer progress.Printer) (PrunePlan, error) {
+func decidePackAction(ctx context.Context, opts PruneOptions, repo *Repository, indexPack map[restic.ID]packInfo, pending map[restic.ID]index.PendingDeletePack, parityFiles restic.IDSet, stats *PruneStats, printer progress.Printer) (PrunePlan, error) {
 	removePacksFirst := restic.NewIDSet()
 	removePacks := restic.NewIDSet()
 	repackPacks := restic.NewIDSet()
+	deletePending := restic.NewIDSet()
+	keepPending := restic.NewIDSet()
+	packsWithParity := restic.NewIDSet()
+	var unrefPending, unusedPending uint
+
+	// without delayed deletion no other process can use packs pending deletion
+	isDue := func(p index.PendingDeletePack) bool {
+		return !opts.DelayDelete || p.Marked.Before(opts.DeletePendingBefore)
+	}
 
 	var repackCandidates []packInfoWithID
 	var repackSmallCandidates []packInfoWithID
@@ -398,10 +484,32 @@ func decidePackAction(ctx context.Context, opts PruneOptions, repo *Repository,
 	bar := printer.NewCounter("packs processed")
 	bar.SetMax(uint64(len(indexPack)))
 	err := repo.List(ctx, restic.PackFile, func(id restic.ID, packSize int64) error {
+		if parityFiles.Has(id) {
+			packsWithParity.Insert(id)
+		}
 		p, ok := indexPack[id]
+		pp, isPending := pending[id]
 		if !ok {
-			// Pack was not referenced in index and is not used  => immediately remove!
-			printer.V("will remove pack %v as it is unused and not indexed", id.Str())
+			if isPending {
+				// Pack was not referenced in index when it was marked
+				if isDue(pp) {
+					printer.V("will remove unreferenced pack %v pending deletion", id.Str())
+					deletePending.Insert(id)
+					stats.Size.Unref += uint64(packSize)
+					unrefPending++
+				} else {
+					keepPending.Insert(id)
+					stats.Packs.Pending++
+				}
+				return nil
+			}
+			if opts.DelayDelete {
+				// Pack may belong to a concurrent backup which has not yet written its index
+				printer.V("will mark pack %v for deletion as it is unused and not indexed", id.Str())
+			} else {
+				// Pack was not referenced in index and is not used  => immediately remove!
+				printer.V("will remove pack %v as it is unused and not indexed", id.Str())
+			}
 			removePacksFirst.Insert(id)
 			stats.Size.Unref += uint64(packSize)
 			return nil
@@ -416,6 +524,22 @@ func decidePackAction(ctx context.Context, opts PruneOptions, repo *Repository,
 			return ErrSizeNotMatching
 		}
 
+		if isPending && p.usedBlobs == 0 {
+			stats.Packs.Unused++
+			if isDue(pp) {
+				deletePending.Insert(id)
+				unusedPending++
+				stats.Blobs.Remove += p.unusedBlobs
+				stats.Size.Remove += p.unusedSize
+			} else {
+				keepPending.Insert(id)
+				stats.Packs.Pending++
+			}
+			delete(indexPack, id)
+			bar.Add(1)
+			return nil
+		}
+
 		// statistics
 		switch {
 		case p.usedBlobs == 0:
@@ -475,8 +599,13 @@ func decidePackAction(ctx context.Context, opts PruneOptions, repo *Repository,
 
 	// missing packs that are not needed can be ignored
 	ignorePacks := restic.NewIDSet()
+	dropPending := restic.NewIDSet()
 	for id, p := range indexPack {
-		if p.usedBlobs == 0 {
+		if _, ok := pending[id]; ok && p.usedBlobs == 0 {
+			// pack pending deletion was already removed
+			dropPending.Insert(id)
+			delete(indexPack, id)
+		} else if p.usedBlobs == 0 {
 			ignorePacks.Insert(id)
 			stats.Blobs.Remove += p.unusedBlobs
 			stats.Size.Remove += p.unusedSize
@@ -525,6 +654,9 @@ func decidePackAction(ctx context.Context, opts PruneOptions, repo *Repository,
 		case pj.unusedSize+pj.usedSize < uint64(targetPackSize) && pi.unusedSize+pi.usedSize >= uint64(targetPackSize):
 			return false
 		}
+		if opts.CostModel != nil {
+			return opts.CostModel.RepackSavings(pi.usedSize, pi.unusedSize) > opts.CostModel.RepackSavings(pj.usedSize, pj.unusedSize)
+		}
 		return pi.unusedSize*pj.usedSize > pj.unusedSize*pi.usedSize
 	})
 
@@ -557,6 +689,14 @@ func decidePackAction(ctx context.Context, opts PruneOptions, repo *Repository,
 			// repacking non-data packs / uncompressed-trees is only limited by repackSize
 			repack(p.ID, p.packInfo)
 
+		case opts.CostModel != nil:
+			// only repack data packs if this is expected to save costs
+			if opts.CostModel.RepackSavings(p.usedSize, p.unusedSize) > 0 {
+				repack(p.ID, p.packInfo)
+			} else {
+				stats.Packs.Keep++
+			}
+
 		case reachedUnusedSizeAfter && packIsLargeEnough:
 			// for all other packs stop repacking if tolerated unused size is reached.
 			stats.Packs.Keep++
@@ -566,9 +706,23 @@ func decidePackAction(ctx context.Context, opts PruneOptions, repo *Repository,
 		}
 	}
 
-	stats.Packs.Unref = uint(len(removePacksFirst))
+	resurrectPacks := restic.NewIDSet()
+	for id, p := range pending {
+		switch {
+		case deletePending.Has(id), keepPending.Has(id), dropPending.Has(id):
+		case len(p.Blobs) == 0:
+			// unreferenced pack pending deletion was already removed
+			dropPending.Insert(id)
+		default:
+			printer.V("will restore pack %v pending deletion as it is used again", id.Str())
+			resurrectPacks.Insert(id)
+		}
+	}
+
+	stats.Packs.Unref = uint(len(removePacksFirst)) + unrefPending
 	stats.Packs.Repack = uint(len(repackPacks))
-	stats.Packs.Remove = uint(len(removePacks))
+	stats.Packs.Remove = uint(len(removePacks)) + unusedPending
+	stats.Packs.Resurrect = uint(len(resurrectPacks))
 
 	if repo.Config().Version < 2 {
 		// compression not supported for repository format version 1
@@ -576,9 +730,15 @@ func decidePackAction(ctx context.Context, opts PruneOptions, repo *Repository,
 	}
 
 	return PrunePlan{removePacksFirst: removePacksFirst,
-		removePacks: removePacks,
-		repackPacks: repackPacks,
-		ignorePacks: ignorePacks,
+		removePacks:    removePacks,
+		repackPacks:    repackPacks,
+		ignorePacks:    ignorePacks,
+		deletePending:  deletePending,
+		keepPending:    keepPending,
+		dropPending:    dropPending,
+		resurrectPacks: resurrectPacks,
+		parityFiles:    parityFiles,
+		orphanParity:   parityFiles.Sub(packsWithParity),
 	}, nil
 }
 
@@ -589,17 +749,34 @@ func (plan *PrunePlan) Stats() PruneStats {
 // Execute does the actual pruning:
 // - remove unreferenced packs first
 // - repack given pack files while keeping the given blobs
+// - restore packs pending deletion which are used again
 // - rebuild the index while ignoring all files that will be deleted
-// - delete the files
+// - delete the files and the parity files of deleted packs
+// If DelayDelete is set, unreferenced, repacked and unused packs are not deleted
+// but only marked as pending deletion. Packs marked by an earlier prune run are
+// deleted once they are no longer used and were marked before DeletePendingBefore.
 // plan.removePacks and plan.ignorePacks are modified in this function.
 func (plan *PrunePlan) Execute(ctx context.Context, printer progress.Printer) error {
+	delayDelete := plan.opts.DelayDelete
 	if plan.opts.DryRun {
 		printer.V("Repeated prune dry-runs can report slightly different amounts of data to keep or repack. This is expected behavior.\n\n")
+		if delayDelete {
+			printer.V("Packs would have been marked for deletion instead of removing them.\n\n")
+		}
 		if len(plan.removePacksFirst) > 0 {
 			printer.V("Would have removed the following unreferenced packs:\n%v\n\n", plan.removePacksFirst)
 		}
 		printer.V("Would have repacked and removed the following packs:\n%v\n\n", plan.repackPacks)
 		printer.V("Would have removed the following no longer used packs:\n%v\n\n", plan.removePacks)
+		if len(plan.deletePending) > 0 {
+			printer.V("Would have removed the following packs pending deletion:\n%v\n\n", plan.deletePending)
+		}
+		if len(plan.resurrectPacks) > 0 {
+			printer.V("Would have restored the following packs pending deletion:\n%v\n\n", plan.resurrectPacks)
+		}
+		if len(plan.orphanParity) > 0 {
+			printer.V("Would have removed the following parity files without pack:\n%v\n\n", plan.orphanParity)
+		}
 		// Always quit here if DryRun was set!
 		return nil
 	}
@@ -608,10 +785,15 @@ func (plan *PrunePlan) Execute(ctx context.Context, printer progress.Printer) er
 	// make sure the plan can only be used once
 	plan.repo = nil
 
-	// unreferenced packs can be safely deleted first
-	if len(plan.removePacksFirst) != 0 {
+	// parity files of deleted packs are removed after the packs
+	removeParity := plan.orphanParity
+
+	// unreferenced packs can be safely deleted first, unless they may belong to
+	// a concurrent backup
+	if len(plan.removePacksFirst) != 0 && !delayDelete {
 		printer.P("deleting unreferenced packs\n")
 		_ = deleteFiles(ctx, true, &internalRepository{repo}, plan.removePacksFirst, restic.PackFile, printer)
+		removeParity.Merge(plan.parityFiles.Intersect(plan.removePacksFirst))
 		// forget unused data
 		plan.removePacksFirst = nil
 	}
@@ -646,11 +828,58 @@ func (plan *PrunePlan) Execute(ctx context.Context, printer progress.Printer) er
 		plan.keepBlobs = nil
 	}
 
+	// packs pending deletion which are used again must be added to the index
+	restorePacks := plan.resurrectPacks.Sub(plan.removePacks)
+	if len(restorePacks) != 0 {
+		printer.P("restoring %d packs pending deletion\n", len(restorePacks))
+		for id := range restorePacks {
+			err := repo.idx.StorePack(ctx, id, plan.pending[id].Blobs, &internalRepository{repo})
+			if err != nil {
+				return errors.Fatalf("%s", err)
+			}
+		}
+		if err := repo.idx.Flush(ctx, &internalRepository{repo}); err != nil {
+			return errors.Fatalf("%s", err)
+		}
+	}
+
+	// the index entries of packs must be collected before rebuilding the index
+	var marks []index.PendingDeletePack
+	if delayDelete {
+		for pbs := range repo.idx.ListPacks(ctx, plan.removePacks) {
+			marks = append(marks, index.PendingDeletePack{PackID: pbs.PackID, Blobs: pbs.Blobs})
+		}
+		for id := range plan.removePacksFirst {
+			marks = append(marks, index.PendingDeletePack{PackID: id})
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+
+	// the packs must be marked before they are removed from the index, as they
+	// would otherwise never be deleted if prune is interrupted
+	var preliminaryMarks restic.ID
+	if len(marks) != 0 {
+		printer.P("marking %d packs for deletion\n", len(marks))
+		now := time.Now()
+		for i := range marks {
+			marks[i].Marked = now
+		}
+		var err error
+		preliminaryMarks, err = index.SavePendingDelete(ctx, &internalRepository{repo}, marks)
+		if err != nil {
+			return errors.Fatalf("%s", err)
+		}
+	}
+
 	if len(plan.ignorePacks) == 0 {
 		plan.ignorePacks = plan.removePacks
 	} else {
 		plan.ignorePacks.Merge(plan.removePacks)
 	}
+	plan.ignorePacks.Merge(plan.deletePending)
+	plan.ignorePacks.Merge(plan.dropPending)
 
 	if plan.opts.UnsafeRecovery {
 		printer.P("deleting index files\n")
@@ -659,16 +888,42 @@ func (plan *PrunePlan) Execute(ctx context.Context, printer progress.Printer) er
 		if err != nil {
 			return errors.Fatalf("%s", err)
 		}
-	} else if len(plan.ignorePacks) != 0 {
+	} else if len(plan.ignorePacks) != 0 || len(restorePacks) != 0 {
 		err := rewriteIndexFiles(ctx, repo, plan.ignorePacks, nil, nil, printer)
 		if err != nil {
 			return errors.Fatalf("%s", err)
 		}
 	}
 
-	if len(plan.removePacks) != 0 {
-		printer.P("removing %d old packs", len(plan.removePacks))
-		_ = deleteFiles(ctx, true, &internalRepository{repo}, plan.removePacks, restic.PackFile, printer)
+	if len(marks) != 0 {
+		// the mark time must be taken after the old index files were removed.
+		// Only processes which started before loaded an index containing the
+		// packs. The latest mark of a pack takes precedence.
+		now := time.Now()
+		for i := range marks {
+			marks[i].Marked = now
+		}
+		_, err := index.SavePendingDelete(ctx, &internalRepository{repo}, marks)
+		if err != nil {
+			return errors.Fatalf("%s", err)
+		}
+		if err := (&internalRepository{repo}).RemoveUnpacked(ctx, restic.IndexFile, preliminaryMarks); err != nil {
+			printer.E("unable to remove index %v: %v", preliminaryMarks.Str(), err)
+		}
+	}
+
+	removePacks := plan.deletePending
+	if !delayDelete {
+		removePacks.Merge(plan.removePacks)
+	}
+	if len(removePacks) != 0 {
+		printer.P("removing %d old packs", len(removePacks))
+		_ = deleteFiles(ctx, true, &internalRepository{repo}, removePacks, restic.PackFile, printer)
+		removeParity.Merge(plan.parityFiles.Intersect(removePacks))
+	}
+	if len(removeParity) != 0 {
+		printer.P("removing %d parity files", len(removeParity))
+		_ = deleteFiles(ctx, true, &internalRepository{repo}, removeParity, restic.ParityFile, printer)
 	}
 	if ctx.Err() != nil {
 		return ctx.Err()
@@ -681,6 +936,13 @@ func (plan *PrunePlan) Execute(ctx context.Context, printer progress.Printer) er
 		}
 	}
 
+	if compacter := backend.AsBackend[backend.Compacter](repo.be); compacter != nil {
+		printer.P("compacting repository storage\n")
+		if err := compacter.Compact(ctx); err != nil {
+			return errors.Fatalf("%s", err)
+		}
+	}
+
 	// drop outdated in-memory index
 	repo.clearIndex()
 
@@ -0,0 +1,161 @@
+package repository
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// CostModel describes the prices of a storage provider. It is used by prune
+// to decide which pack files are worth repacking. Prices are given per GiB,
+// that is 2^30 bytes, which most providers use for billing.
+type CostModel struct {
+	// Name of the preset the model is based on, empty for custom models.
+	Name string
+	// StoragePerGiBMonth is the price to store 1 GiB for one month.
+	StoragePerGiBMonth float64
+	// EgressPerGiB is the price to download 1 GiB, including retrieval fees.
+	EgressPerGiB float64
+	// PerRequest is the price of a single upload, download or delete request.
+	PerRequest float64
+	// MinStorageDays is the minimum duration for which newly uploaded data
+	// is billed, even if it is deleted earlier. It is only applied to the data
+	// uploaded by repacking. The age of existing pack files is unknown, thus
+	// the early deletion fees for deleting or repacking young pack files are
+	// ignored.
+	MinStorageDays float64
+	// HorizonMonths is the time span over which the costs are compared.
+	HorizonMonths float64
+}
+
+// defaultCostHorizonMonths is the horizon used unless specified otherwise.
+const defaultCostHorizonMonths = 12
+
+// costModelPresets contains approximate list prices in USD. They are only a
+// starting point, actual prices depend on the region and change over time.
+var costModelPresets = map[string]CostModel{
+	"s3":            {StoragePerGiBMonth: 0.023, EgressPerGiB: 0.09, PerRequest: 0.000005},
+	"s3-ia":         {StoragePerGiBMonth: 0.0125, EgressPerGiB: 0.10, PerRequest: 0.00001, MinStorageDays: 30},
+	"s3-glacier-ir": {StoragePerGiBMonth: 0.004, EgressPerGiB: 0.12, PerRequest: 0.00002, MinStorageDays: 90},
+	"b2":            {StoragePerGiBMonth: 0.006, EgressPerGiB: 0.01, PerRequest: 0.000004},
+	"wasabi":        {StoragePerGiBMonth: 0.0070, MinStorageDays: 90},
+	"gcs":           {StoragePerGiBMonth: 0.020, EgressPerGiB: 0.12, PerRequest: 0.000005},
+	"gcs-nearline":  {StoragePerGiBMonth: 0.010, EgressPerGiB: 0.13, PerRequest: 0.00001, MinStorageDays: 30},
+	"azure-cool":    {StoragePerGiBMonth: 0.010, EgressPerGiB: 0.097, PerRequest: 0.00001, MinStorageDays: 30},
+}
+
+// CostModelPresets returns the names of all available cost model presets.
+func CostModelPresets() []string {
+	var names []string
+	for name := range costModelPresets {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// ParseCostModel parses a cost model description. It consists of an optional
+// preset name, followed by comma separated key=value pairs which override the
+// prices of the preset. Valid keys are storage, egress, request, min-days and
+// horizon, for example "b2,egress=0,horizon=6".
+func ParseCostModel(s string) (CostModel, error) {
+	model := CostModel{HorizonMonths: defaultCostHorizonMonths}
+	for i, item := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(item), "=")
+		if !ok {
+			preset, found := costModelPresets[key]
+			if i != 0 || !found {
+				return CostModel{}, fmt.Errorf("unknown cost model preset %q, available presets: %v", key, strings.Join(CostModelPresets(), ", "))
+			}
+			preset.Name = key
+			preset.HorizonMonths = model.HorizonMonths
+			model = preset
+			continue
+		}
+
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil || v < 0 {
+			return CostModel{}, fmt.Errorf("invalid value %q for cost model key %q", value, key)
+		}
+		switch key {
+		case "storage":
+			model.StoragePerGiBMonth = v
+		case "egress":
+			model.EgressPerGiB = v
+		case "request":
+			model.PerRequest = v
+		case "min-days":
+			model.MinStorageDays = v
+		case "horizon":
+			if v == 0 {
+				return CostModel{}, fmt.Errorf("cost model horizon must be larger than zero")
+			}
+			model.HorizonMonths = v
+		default:
+			return CostModel{}, fmt.Errorf("unknown cost model key %q", key)
+		}
+	}
+	return model, nil
+}
+
+func (m CostModel) String() string {
+	name := m.Name
+	if name == "" {
+		name = "custom"
+	}
+	return fmt.Sprintf("%v (storage %v/GiB-month, egress %v/GiB, request %v, minimum storage %v days)",
+		name, m.StoragePerGiBMonth, m.EgressPerGiB, m.PerRequest, m.MinStorageDays)
+}
+
+func toGiB(bytes uint64) float64 {
+	return float64(bytes) / (1 << 30)
+}
+
+// billedMonths returns the number of months newly uploaded data is billed for
+// within the horizon. Early deletion fees of existing pack files are not
+// included, see MinStorageDays.
+func (m CostModel) billedMonths() float64 {
+	return max(m.HorizonMonths, m.MinStorageDays/30)
+}
+
+// repackRequests is the number of requests to repack a pack file: download it,
+// upload the used blobs and delete it.
+const repackRequests = 3
+
+// RepackSavings returns the expected savings over the horizon if a pack file
+// containing used and unused bytes is repacked instead of kept. A negative
+// value means that repacking is more expensive than keeping the pack file.
+func (m CostModel) RepackSavings(used, unused uint64) float64 {
+	keep := m.StoragePerGiBMonth * toGiB(used+unused) * m.HorizonMonths
+	repack := m.EgressPerGiB*toGiB(used+unused) + repackRequests*m.PerRequest +
+		m.StoragePerGiBMonth*toGiB(used)*m.billedMonths()
+	return keep - repack
+}
+
+// PruneCostEstimate compares the expected costs with and without running
+// prune over the horizon of the cost model.
+type PruneCostEstimate struct {
+	HorizonMonths float64 `json:"horizon_months"`
+	// WithoutPrune is the storage cost if nothing is removed.
+	WithoutPrune float64 `json:"without_prune"`
+	// Prune is the one-time cost for downloads and requests of prune.
+	Prune float64 `json:"prune"`
+	// WithPrune is the storage cost of the remaining data plus the cost of prune.
+	WithPrune float64 `json:"with_prune"`
+	Savings   float64 `json:"savings"`
+}
+
+// Estimate calculates the expected costs of the prune run described by stats.
+func (m CostModel) Estimate(stats PruneStats) PruneCostEstimate {
+	// repacked blobs are uploaded again and billed for the minimum storage duration
+	repacked := stats.Size.Repack - stats.Size.Repackrm
+	requests := float64(repackRequests*stats.Packs.Repack + stats.Packs.RemoveTotal)
+
+	est := PruneCostEstimate{HorizonMonths: m.HorizonMonths}
+	est.WithoutPrune = m.StoragePerGiBMonth * toGiB(stats.Size.Total) * m.HorizonMonths
+	est.Prune = m.EgressPerGiB*toGiB(stats.Size.Repack) + requests*m.PerRequest
+	est.WithPrune = m.StoragePerGiBMonth*(toGiB(stats.Size.Remain-repacked)*m.HorizonMonths+toGiB(repacked)*m.billedMonths()) + est.Prune
+	est.Savings = est.WithoutPrune - est.WithPrune
+	return est
+}
@@ -0,0 +1,56 @@
+package repository
+
+import (
+	"math"
+	"testing"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestParseCostModel(t *testing.T) {
+	for _, test := range []struct {
+		input string
+		model CostModel
+		err   bool
+	}{
+		{input: "b2", model: CostModel{Name: "b2", StoragePerGiBMonth: 0.006, EgressPerGiB: 0.01, PerRequest: 0.000004, HorizonMonths: 12}},
+		{input: "wasabi,horizon=6", model: CostModel{Name: "wasabi", StoragePerGiBMonth: 0.007, MinStorageDays: 90, HorizonMonths: 6}},
+		{input: "s3, egress=0", model: CostModel{Name: "s3", StoragePerGiBMonth: 0.023, PerRequest: 0.000005, HorizonMonths: 12}},
+		{input: "storage=0.01,egress=0.02,request=0.001,min-days=30", model: CostModel{StoragePerGiBMonth: 0.01, EgressPerGiB: 0.02, PerRequest: 0.001, MinStorageDays: 30, HorizonMonths: 12}},
+		{input: "", err: true},
+		{input: "unknown", err: true},
+		{input: "storage=0.01,b2", err: true},
+		{input: "b2,egress=-1", err: true},
+		{input: "b2,horizon=0", err: true},
+		{input: "b2,foo=1", err: true},
+	} {
+		t.Run(test.input, func(t *testing.T) {
+			model, err := ParseCostModel(test.input)
+			if test.err {
+				rtest.Assert(t, err != nil, "missing error for %q", test.input)
+				return
+			}
+			rtest.OK(t, err)
+			rtest.Equals(t, test.model, model)
+		})
+	}
+}
+
+func TestCostModelRepackSavings(t *testing.T) {
+	const gib = 1 << 30
+	model := CostModel{StoragePerGiBMonth: 0.01, EgressPerGiB: 0.05, HorizonMonths: 12}
+
+	// keep: 0.12, repack: 0.05 + 0.06
+	rtest.Assert(t, math.Abs(model.RepackSavings(gib/2, gib/2)-0.01) < 1e-9, "unexpected savings %v", model.RepackSavings(gib/2, gib/2))
+	// mostly used packs are not worth the egress costs
+	rtest.Assert(t, model.RepackSavings(gib*9/10, gib/10) < 0, "repacking mostly used pack saves costs")
+
+	// a minimum storage duration longer than the horizon makes repacking more expensive
+	model.HorizonMonths = 1
+	model.MinStorageDays = 90
+	rtest.Assert(t, model.RepackSavings(gib/2, gib/2) < 0, "repacking saves costs despite minimum storage duration")
+
+	// without egress costs, repacking unused data always pays off
+	model = CostModel{StoragePerGiBMonth: 0.01, HorizonMonths: 1}
+	rtest.Assert(t, model.RepackSavings(gib-1, 1) > 0, "repacking without egress costs does not save costs")
+}
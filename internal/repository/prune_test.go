@@ -91,6 +91,15 @@ func TestPrune(t *testing.T) {
 				RepackCacheableOnly: true,
 			},
 		},
+		{
+			name: "costmodel",
+			opts: repository.PruneOptions{
+				MaxRepackBytes: math.MaxUint64,
+				MaxUnusedBytes: func(used uint64) (unused uint64) { return 0 },
+				CostModel:      &repository.CostModel{StoragePerGiBMonth: 0.01, HorizonMonths: 12},
+			},
+			errOnUnused: true,
+		},
 		{
 			name: "small",
 			opts: repository.PruneOptions{
@@ -191,3 +200,79 @@ func TestPruneSmall(t *testing.T) {
 	rtest.Equals(t, lenPackfilesBefore > lenPackfilesAfter, true,
 		fmt.Sprintf("the number packfiles before %d and after repack %d", lenPackfilesBefore, lenPackfilesAfter))
 }
+
+func countPackFiles(t *testing.T, repo restic.Lister) int {
+	count := 0
+	rtest.OK(t, repo.List(context.TODO(), restic.PackFile, func(restic.ID, int64) error {
+		count++
+		return nil
+	}))
+	return count
+}
+
+func planDelayedPrune(t *testing.T, repo *repository.Repository, keep restic.BlobSet, deleteBefore time.Time) *repository.PrunePlan {
+	rtest.OK(t, repo.LoadIndex(context.TODO(), restic.NoopTerminalCounterFactory))
+	opts := repository.PruneOptions{
+		MaxRepackBytes:      math.MaxUint64,
+		MaxUnusedBytes:      func(used uint64) (unused uint64) { return 0 },
+		DelayDelete:         true,
+		DeletePendingBefore: deleteBefore,
+	}
+	plan, err := repository.PlanPrune(context.TODO(), opts, repo, func(ctx context.Context, repo restic.Repository, usedBlobs restic.FindBlobSet) error {
+		for blob := range keep {
+			usedBlobs.Insert(blob)
+		}
+		return nil
+	}, progress.NewNoopPrinter())
+	rtest.OK(t, err)
+	rtest.OK(t, plan.Execute(context.TODO(), progress.NewNoopPrinter()))
+	return plan
+}
+
+func TestPruneDelayDelete(t *testing.T) {
+	seed := time.Now().UnixNano()
+	random := rand.New(rand.NewSource(seed))
+	t.Logf("rand initialized with seed %d", seed)
+
+	repo, _, be := repository.TestRepositoryWithVersion(t, 0)
+	createRandomBlobs(t, random, repo, 4, 0.5, true)
+	createRandomBlobs(t, random, repo, 5, 0.5, true)
+	keep, unused := selectBlobs(t, random, repo, 0.5)
+	packsBefore := countPackFiles(t, repo)
+
+	// the first run only marks the obsolete packs
+	start := time.Now()
+	stats := planDelayedPrune(t, repository.TestOpenBackend(t, be), keep, start).Stats()
+	marked := stats.Packs.Remove + stats.Packs.Repack
+	rtest.Assert(t, marked > 0, "no packs marked for deletion")
+	repo = repository.TestOpenBackend(t, be)
+	repository.TestCheckRepo(t, repo)
+	rtest.Equals(t, keep, listBlobs(repo))
+	packsMarked := countPackFiles(t, repo)
+	rtest.Assert(t, packsMarked >= packsBefore, "packs were deleted")
+
+	// packs are kept while a lock older than the mark exists
+	stats = planDelayedPrune(t, repository.TestOpenBackend(t, be), keep, start).Stats()
+	rtest.Equals(t, marked, stats.Packs.Pending)
+	rtest.Equals(t, uint(0), stats.Packs.Remove+stats.Packs.Repack)
+
+	// a blob which is used again is restored, the remaining packs are deleted
+	var resurrect restic.BlobHandle
+	for bh := range unused {
+		resurrect = bh
+		break
+	}
+	keep.Insert(resurrect)
+	stats = planDelayedPrune(t, repository.TestOpenBackend(t, be), keep, time.Now()).Stats()
+	rtest.Equals(t, uint(1), stats.Packs.Resurrect)
+	rtest.Equals(t, uint(0), stats.Packs.Pending)
+
+	repo = repository.TestOpenBackend(t, be)
+	repository.TestCheckRepo(t, repo)
+	rtest.Equals(t, keep, listBlobs(repo))
+	// the restored pack is either kept or repacked into a new pack
+	rtest.Equals(t, marked-1, stats.Packs.Remove)
+	rtest.Equals(t, packsMarked-int(marked-1)+int(stats.Packs.Repack), countPackFiles(t, repo))
+	_, err := repo.LoadBlob(context.TODO(), resurrect, nil)
+	rtest.OK(t, err)
+}
@@ -0,0 +1,340 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository/crypto"
+	"github.com/restic/restic/internal/repository/pack"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/ui/progress"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// RecompressOptions collects all options for recompressing a repository.
+type RecompressOptions struct {
+	// MinSavings is the minimum size reduction of the blobs of a pack file in
+	// percent which is necessary to rewrite the pack file.
+	MinSavings float64
+	// MaxRepackBytes limits the size of the pack files which are examined
+	// in a single run. Zero means no limit.
+	MaxRepackBytes uint64
+}
+
+// RecompressStats collects the statistics of a recompress run.
+type RecompressStats struct {
+	// Packs and PackSize count the pack files which contain blobs compressed
+	// at a lower level than the target level.
+	Packs    uint
+	PackSize uint64
+	// Selected and SelectedSize count the pack files examined in this run.
+	Selected     uint
+	SelectedSize uint64
+
+	// the following fields are only set by Execute
+	Rewritten uint
+	Unchanged uint
+	OldSize   uint64 // size of the blobs of the rewritten packs before
+	NewSize   uint64 // size of the blobs of the rewritten packs afterwards
+}
+
+// RecompressPlan contains the pack files which should be recompressed.
+type RecompressPlan struct {
+	repo  *Repository
+	opts  RecompressOptions
+	level uint8
+	packs restic.IDSet
+
+	stats RecompressStats
+}
+
+// Stats returns the statistics of the plan, which include the results once
+// Execute has completed.
+func (plan *RecompressPlan) Stats() RecompressStats {
+	return plan.stats
+}
+
+// PlanRecompress selects the pack files containing blobs which were compressed
+// at a lower level than the compression level configured for repo or whose
+// level is unknown. Pack files pending deletion are ignored. The repository
+// must be locked exclusively.
+func PlanRecompress(ctx context.Context, repo *Repository, opts RecompressOptions, printer progress.Printer) (*RecompressPlan, error) {
+	if repo.Config().Version < 2 {
+		return nil, errors.Fatal("compression requires at least repository format version 2")
+	}
+	if repo.opts.Compression == CompressionOff {
+		return nil, errors.Fatal("recompress cannot be used with compression mode off")
+	}
+	if repo.Connections() < 2 {
+		return nil, errors.Fatal("recompress requires a backend connection limit of at least two")
+	}
+	if opts.MinSavings < 0 || opts.MinSavings > 100 {
+		return nil, errors.Fatalf("invalid minimum savings %v%%, must be between 0 and 100", opts.MinSavings)
+	}
+
+	printer.P("loading indexes...\n")
+	err := repo.LoadIndex(ctx, printer)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &RecompressPlan{
+		repo:  repo,
+		opts:  opts,
+		level: repo.compressionLevel(),
+		packs: restic.NewIDSet(),
+	}
+
+	printer.P("searching packs\n")
+	candidates := restic.NewIDSet()
+	for pb := range repo.idx.Values() {
+		if pb.Blob.IsCompressed() && pb.Blob.CompressionLevel < plan.level {
+			candidates.Insert(pb.Pack)
+		}
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	for id := range loadPendingDelete(repo) {
+		candidates.Delete(id)
+	}
+
+	packSize, err := pack.Size(ctx, repo, false)
+	if err != nil {
+		return nil, err
+	}
+
+	// select packs in a stable order, such that repeated runs limited by
+	// MaxRepackBytes continue where the last run stopped
+	ids := candidates.List()
+	sort.Sort(ids)
+	for _, id := range ids {
+		size := uint64(packSize[id])
+		plan.stats.Packs++
+		plan.stats.PackSize += size
+		if opts.MaxRepackBytes > 0 && plan.stats.SelectedSize+size > opts.MaxRepackBytes {
+			continue
+		}
+		plan.packs.Insert(id)
+		plan.stats.Selected++
+		plan.stats.SelectedSize += size
+	}
+	return plan, nil
+}
+
+// recompressResult is the outcome of recompressing the blobs of a pack file.
+type recompressResult struct {
+	packID    restic.ID
+	blobs     pack.Blobs
+	rewritten bool
+	oldSize   uint64
+	newSize   uint64
+}
+
+// Execute recompresses the blobs of the planned pack files at the target
+// level. A pack file is only rewritten if this reduces the size of its blobs
+// by at least MinSavings percent. The index entries of the remaining pack files
+// record the target level, such that they are skipped by later runs. Finally,
+// the index is rewritten and the old pack files are removed.
+func (plan *RecompressPlan) Execute(ctx context.Context, printer progress.Printer) error {
+	repo := plan.repo
+	// make sure the plan can only be used once
+	plan.repo = nil
+
+	if len(plan.packs) == 0 {
+		printer.P("no packs to recompress\n")
+		return nil
+	}
+
+	oldIndexes := repo.idx.IDs()
+
+	printer.P("recompressing %d packs\n", len(plan.packs))
+	bar := printer.NewCounter("packs processed")
+	var results []recompressResult
+	err := repo.WithBlobUploader(ctx, func(ctx context.Context, _ restic.BlobSaverWithAsync) error {
+		var err error
+		results, err = repo.recompressPacks(ctx, plan.packs, plan.opts.MinSavings, bar, printer)
+		return err
+	})
+	bar.Done()
+	if err != nil {
+		return errors.Fatalf("%s", err)
+	}
+
+	removePacks := restic.NewIDSet()
+	for _, res := range results {
+		if res.rewritten {
+			removePacks.Insert(res.packID)
+			plan.stats.Rewritten++
+			plan.stats.OldSize += res.oldSize
+			plan.stats.NewSize += res.newSize
+			continue
+		}
+
+		// the blobs are already compressed well enough at the target level
+		for i := range res.blobs {
+			if res.blobs[i].IsCompressed() && res.blobs[i].CompressionLevel < plan.level {
+				res.blobs[i].CompressionLevel = plan.level
+			}
+		}
+		err := repo.idx.StorePack(ctx, res.packID, res.blobs, &internalRepository{repo})
+		if err != nil {
+			return errors.Fatalf("%s", err)
+		}
+		plan.stats.Unchanged++
+	}
+	if err := repo.idx.Flush(ctx, &internalRepository{repo}); err != nil {
+		return errors.Fatalf("%s", err)
+	}
+
+	// only the old indexes must be rewritten, the new ones contain the
+	// recompressed packs and the updated index entries
+	err = rewriteIndexFiles(ctx, repo, plan.packs, oldIndexes, nil, printer)
+	if err != nil {
+		return errors.Fatalf("%s", err)
+	}
+
+	if len(removePacks) != 0 {
+		printer.P("removing %d old packs\n", len(removePacks))
+		_ = deleteFiles(ctx, true, &internalRepository{repo}, removePacks, restic.PackFile, printer)
+		_ = removeParityFiles(ctx, repo, removePacks, printer)
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	// drop outdated in-memory index
+	repo.clearIndex()
+
+	printer.P("done\n")
+	return nil
+}
+
+// recompressPacks loads the blobs of the given packs and compresses them again
+// using the compression level of the repository. If the size of the blobs of a
+// pack is reduced by at least minSavings percent, they are saved into new
+// packs. The blobs must only be saved within WithBlobUploader.
+func (r *Repository) recompressPacks(ctx context.Context, packs restic.IDSet, minSavings float64, p restic.Counter, printer progress.Printer) ([]recompressResult, error) {
+	p.SetMax(uint64(len(packs)))
+
+	if backend.WarmupEnabled() {
+		job, err := r.StartWarmup(ctx, packs)
+		if err != nil {
+			return nil, err
+		}
+		if job.HandleCount() != 0 {
+			printer.P("warming up %d packs from cold storage, this may take a while...", job.HandleCount())
+			if err := job.Wait(ctx); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	type encodedBlob struct {
+		pack.Blob
+		data []byte
+	}
+
+	wg, wgCtx := errgroup.WithContext(ctx)
+	var m sync.Mutex
+	var results []recompressResult
+
+	queue := r.listPacksFromIndex(wgCtx, packs)
+	worker := func() error {
+		for pbs := range queue {
+			// a pack contained in multiple indexes is listed with duplicate blobs
+			blobs := slices.Clone(pbs.Blobs)
+			blobs.Sort()
+			blobs = slices.CompactFunc(blobs, func(a, b pack.Blob) bool {
+				return a.BlobHandle == b.BlobHandle && a.Offset == b.Offset
+			})
+
+			// the blobs are passed to the callback in the order of their
+			// offsets, a blob stored several times in a pack is thus found by
+			// taking the copies in the same order
+			byHandle := make(map[restic.BlobHandle]pack.Blobs, len(blobs))
+			for _, b := range blobs {
+				byHandle[b.BlobHandle] = append(byHandle[b.BlobHandle], b)
+			}
+
+			res := recompressResult{packID: pbs.PackID, blobs: blobs}
+			encoded := make([]encodedBlob, 0, len(blobs))
+			err := r.loadBlobsFromPack(wgCtx, pbs.PackID, blobs, func(bh restic.BlobHandle, buf []byte, err error) error {
+				if err != nil {
+					return err
+				}
+				copies := byHandle[bh]
+				if len(copies) == 0 {
+					return errors.Errorf("unexpected blob %v in pack %v", bh, pbs.PackID.Str())
+				}
+				blob := copies[0]
+				byHandle[bh] = copies[1:]
+
+				e := encodedBlob{Blob: blob}
+				if blob.IsCompressed() {
+					e.data = r.getZstdEncoder().EncodeAll(buf, nil)
+				} else {
+					e.data = bytes.Clone(buf)
+				}
+				res.oldSize += uint64(blob.Length)
+				res.newSize += uint64(crypto.CiphertextLength(len(e.data)))
+				encoded = append(encoded, e)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			saved := float64(res.oldSize) - float64(res.newSize)
+			res.rewritten = res.newSize < res.oldSize && saved >= minSavings/100*float64(res.oldSize)
+			debug.Log("pack %v: %d bytes before, %d after recompression, rewrite %v", pbs.PackID, res.oldSize, res.newSize, res.rewritten)
+
+			if res.rewritten {
+				for _, e := range encoded {
+					uncompressedLength := 0
+					if e.IsCompressed() {
+						uncompressedLength = int(e.UncompressedLength)
+					}
+					if err := r.saveRecompressedBlob(wgCtx, e.Type, e.data, e.ID, uncompressedLength); err != nil {
+						return err
+					}
+				}
+			}
+
+			m.Lock()
+			results = append(results, res)
+			m.Unlock()
+			p.Add(1)
+		}
+		return wgCtx.Err()
+	}
+
+	// reduce by one to ensure that uploading is always possible
+	for i := 0; i < int(r.Connections()-1); i++ {
+		wg.Go(worker)
+	}
+	if err := wg.Wait(); err != nil {
+		return nil, err
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return results, nil
+}
+
+// saveRecompressedBlob stores a blob which was already compressed, possibly
+// duplicating an existing copy of the blob.
+func (r *Repository) saveRecompressedBlob(ctx context.Context, t restic.BlobType, data []byte, id restic.ID, uncompressedLength int) error {
+	r.checkpointMu.RLock()
+	defer r.checkpointMu.RUnlock()
+
+	_, err := r.saveEncoded(ctx, t, data, id, uncompressedLength)
+	return err
+}
@@ -0,0 +1,124 @@
+package repository_test
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/repository/pack"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+	"github.com/restic/restic/internal/ui/progress"
+)
+
+// createCompressibleBlobs saves blobs containing random words, which compress
+// better at higher compression levels.
+func createCompressibleBlobs(t *testing.T, random *rand.Rand, repo restic.Repository, blobs int) {
+	words := make([]string, 500)
+	for i := range words {
+		words[i] = fmt.Sprintf("%x", random.Int63())[:3+random.Intn(8)]
+	}
+
+	rtest.OK(t, repo.WithBlobUploader(context.TODO(), func(ctx context.Context, uploader restic.BlobSaverWithAsync) error {
+		for i := 0; i < blobs; i++ {
+			var sb strings.Builder
+			for sb.Len() < 64*1024 {
+				sb.WriteString(words[random.Intn(len(words))])
+				sb.WriteByte(' ')
+			}
+			tpe := restic.DataBlob
+			if i%4 == 0 {
+				tpe = restic.TreeBlob
+			}
+			_, _, _, err := uploader.SaveBlob(ctx, tpe, []byte(sb.String()), restic.ID{}, false)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+}
+
+func compressionLevels(t *testing.T, repo *repository.Repository) map[uint8]int {
+	levels := make(map[uint8]int)
+	rtest.OK(t, repo.ListBlobs(context.TODO(), func(pb restic.PackBlob) {
+		levels[pb.(*pack.PackedBlob).Blob.CompressionLevel]++
+	}))
+	return levels
+}
+
+func openWithCompression(t *testing.T, be backend.Backend, compression repository.CompressionMode) *repository.Repository {
+	repo, err := repository.New(be, repository.Options{Compression: compression})
+	rtest.OK(t, err)
+	rtest.OK(t, repo.SearchKey(context.TODO(), rtest.TestPassword, 10, ""))
+	return repo
+}
+
+func setupRecompressRepo(t *testing.T) (backend.Backend, restic.BlobSet) {
+	seed := time.Now().UnixNano()
+	random := rand.New(rand.NewSource(seed))
+	t.Logf("rand initialized with seed %d", seed)
+
+	repo, be := repository.TestRepositoryWithBackend(t, nil, 0, repository.Options{Compression: repository.CompressionFastest})
+	createCompressibleBlobs(t, random, repo, 40)
+	blobs := listBlobs(repo)
+	rtest.Equals(t, map[uint8]int{1: len(blobs)}, compressionLevels(t, repo))
+	return be, blobs
+}
+
+func recompress(t *testing.T, repo *repository.Repository, opts repository.RecompressOptions) repository.RecompressStats {
+	plan, err := repository.PlanRecompress(context.TODO(), repo, opts, progress.NewNoopPrinter())
+	rtest.OK(t, err)
+	rtest.OK(t, plan.Execute(context.TODO(), progress.NewNoopPrinter()))
+	return plan.Stats()
+}
+
+func TestRecompress(t *testing.T) {
+	be, blobs := setupRecompressRepo(t)
+	oldPacks := listFiles(t, repository.TestOpenBackend(t, be), restic.PackFile)
+
+	repo := openWithCompression(t, be, repository.CompressionMax)
+	stats := recompress(t, repo, repository.RecompressOptions{})
+	rtest.Equals(t, uint(len(oldPacks)), stats.Packs)
+	rtest.Equals(t, stats.Packs, stats.Rewritten+stats.Unchanged)
+	rtest.Assert(t, stats.Rewritten > 0, "no pack was rewritten")
+	rtest.Assert(t, stats.NewSize < stats.OldSize, "recompression did not reduce the size, %v >= %v", stats.NewSize, stats.OldSize)
+
+	repo = repository.TestOpenBackend(t, be)
+	repository.TestCheckRepo(t, repo)
+	rtest.Assert(t, listBlobs(repo).Equals(blobs), "unexpected blobs after recompress")
+	rtest.Equals(t, map[uint8]int{4: len(blobs)}, compressionLevels(t, repo))
+	rtest.Equals(t, int(stats.Unchanged), len(listFiles(t, repo, restic.PackFile).Intersect(oldPacks)))
+
+	// a second run has nothing to do
+	repo = openWithCompression(t, be, repository.CompressionMax)
+	stats = recompress(t, repo, repository.RecompressOptions{})
+	rtest.Equals(t, uint(0), stats.Packs)
+}
+
+func TestRecompressMinSavings(t *testing.T) {
+	be, blobs := setupRecompressRepo(t)
+	oldPacks := listFiles(t, repository.TestOpenBackend(t, be), restic.PackFile)
+
+	// no pack can be reduced to nothing, but the levels are recorded anyway
+	repo := openWithCompression(t, be, repository.CompressionBetter)
+	stats := recompress(t, repo, repository.RecompressOptions{MinSavings: 100})
+	rtest.Equals(t, uint(0), stats.Rewritten)
+	rtest.Equals(t, uint(len(oldPacks)), stats.Unchanged)
+
+	repo = repository.TestOpenBackend(t, be)
+	repository.TestCheckRepo(t, repo)
+	rtest.Assert(t, listFiles(t, repo, restic.PackFile).Equals(oldPacks), "packs were modified")
+	rtest.Equals(t, map[uint8]int{3: len(blobs)}, compressionLevels(t, repo))
+
+	// a higher level examines the packs again
+	repo = openWithCompression(t, be, repository.CompressionMax)
+	plan, err := repository.PlanRecompress(context.TODO(), repo, repository.RecompressOptions{}, progress.NewNoopPrinter())
+	rtest.OK(t, err)
+	rtest.Equals(t, uint(len(oldPacks)), plan.Stats().Packs)
+}
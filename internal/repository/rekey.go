@@ -0,0 +1,663 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository/crypto"
+	"github.com/restic/restic/internal/repository/pack"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/ui/progress"
+)
+
+// rekeyState describes a rekey which is in progress. It is stored in a rekey
+// file, which is encrypted using the new master key. The new master key itself
+// is only stored in the key file keyID, which is protected by the new password.
+type rekeyState struct {
+	id     restic.ID
+	keyID  restic.ID
+	oldKey *crypto.Key
+	newKey *crypto.Key
+}
+
+type rekeyJSON struct {
+	Created time.Time   `json:"created"`
+	OldKey  *crypto.Key `json:"old_key"`
+}
+
+// errRekeyOtherKey is returned by loadRekeyState if a rekey is pending which
+// cannot be decrypted using the current master key.
+var errRekeyOtherKey = errors.New("rekey pending for another master key")
+
+// fallbackKey returns the old master key while a rekey is in progress and nil
+// otherwise.
+func (r *Repository) fallbackKey() *crypto.Key {
+	if r.rekey == nil {
+		return nil
+	}
+	return r.rekey.oldKey
+}
+
+// RekeyPending returns true if a rekey of the repository was started but has
+// not completed yet.
+func (r *Repository) RekeyPending() bool {
+	return r.rekey != nil
+}
+
+// openWithFallback decrypts ciphertext using key. If that fails and fallback
+// is not nil, the ciphertext is decrypted using fallback instead.
+func openWithFallback(key, fallback *crypto.Key, dst, nonce, ciphertext []byte) ([]byte, error) {
+	plaintext, err := key.Open(dst, nonce, ciphertext, nil)
+	if fallback != nil && errors.Is(err, crypto.ErrUnauthenticated) {
+		// Open does not modify the ciphertext if the verification fails
+		plaintext, err = fallback.Open(dst, nonce, ciphertext, nil)
+		if err != nil {
+			// report the error of the primary key
+			return nil, crypto.ErrUnauthenticated
+		}
+	}
+	return plaintext, err
+}
+
+// listPackWithFallback is like pack.List, but falls back to the second master
+// key if the pack header cannot be decrypted using key.
+func listPackWithFallback(key, fallback *crypto.Key, rd io.ReaderAt, size int64) (pack.Blobs, uint32, error) {
+	entries, hdrSize, err := pack.List(key, rd, size)
+	if fallback != nil && errors.Is(err, crypto.ErrUnauthenticated) {
+		entries, hdrSize, err = pack.List(fallback, rd, size)
+		if err != nil {
+			return nil, 0, crypto.ErrUnauthenticated
+		}
+	}
+	return entries, hdrSize, err
+}
+
+// decryptUnpacked decrypts the unpacked file buf of type t using only key.
+func (r *Repository) decryptUnpacked(key *crypto.Key, t restic.FileType, buf []byte) ([]byte, error) {
+	nonce, ciphertext := buf[:key.NonceSize()], buf[key.NonceSize():]
+	plaintext, err := key.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, err
+	}
+	if t != restic.ConfigFile {
+		return r.decompressUnpacked(plaintext)
+	}
+	return plaintext, nil
+}
+
+// loadRekeyState returns the state of a pending rekey or nil if there is
+// none. The current master key must be the new master key of the rekey,
+// otherwise errRekeyOtherKey is returned.
+func loadRekeyState(ctx context.Context, r *Repository) (*rekeyState, error) {
+	var state *rekeyState
+	otherKey := false
+	err := r.List(ctx, restic.RekeyFile, func(id restic.ID, _ int64) error {
+		buf, err := r.LoadRaw(ctx, restic.RekeyFile, id)
+		if err != nil {
+			return errors.Wrapf(err, "loading rekey file %v", id.Str())
+		}
+		nonce, ciphertext := buf[:r.key.NonceSize()], buf[r.key.NonceSize():]
+		plaintext, err := r.key.Open(ciphertext[:0], nonce, ciphertext, nil)
+		if errors.Is(err, crypto.ErrUnauthenticated) {
+			debug.Log("rekey file %v is encrypted using another key", id)
+			otherKey = true
+			return nil
+		}
+		if err != nil {
+			return errors.Wrapf(err, "decrypting rekey file %v", id.Str())
+		}
+		// the config is not loaded yet, thus the repository version is unknown
+		if len(plaintext) > 0 && plaintext[0] == 2 {
+			plaintext, err = r.getZstdDecoder().DecodeAll(plaintext[1:], nil)
+			if err != nil {
+				return errors.Wrapf(err, "decompressing rekey file %v", id.Str())
+			}
+		}
+
+		var data rekeyJSON
+		if err := json.Unmarshal(plaintext, &data); err != nil {
+			return errors.Wrapf(err, "decoding rekey file %v", id.Str())
+		}
+		if data.OldKey == nil || !data.OldKey.Valid() {
+			return errors.Errorf("rekey file %v contains an invalid key", id.Str())
+		}
+		if state != nil {
+			return errors.New("found multiple pending rekeys")
+		}
+		state = &rekeyState{id: id, keyID: r.keyID, oldKey: data.OldKey, newKey: r.key}
+		return nil
+	})
+	if err == nil && state == nil && otherKey {
+		return nil, errRekeyOtherKey
+	}
+	return state, err
+}
+
+// startRekey generates a new master key and adds a key file for it, which is
+// only protected by the new password. Afterwards, a rekey file containing the
+// old master key is stored encrypted using the new master key. From then on,
+// the repository can only be accessed using the new password.
+func startRekey(ctx context.Context, r *Repository, opts RekeyOptions) (*rekeyState, error) {
+	key, err := AddKey(ctx, r, opts.Password, opts.Username, opts.Hostname, nil)
+	if err != nil {
+		return nil, errors.Fatalf("creating new key failed: %v", err)
+	}
+	removeKey := func() {
+		_ = r.be.Remove(ctx, backend.Handle{Type: restic.KeyFile, Name: key.ID().String()})
+	}
+	// verify the new key before using it to encrypt any data
+	check, err := openKey(ctx, r, key.ID(), opts.Password)
+	if err != nil || *check.master != *key.master {
+		removeKey()
+		return nil, errors.Fatalf("failed to access repository with new key: %v", err)
+	}
+
+	state := &rekeyState{keyID: key.ID(), oldKey: r.key, newKey: key.master}
+	state.id, err = restic.SaveJSONUnpacked(ctx, &internalRepository{r.withRekey(state)}, restic.RekeyFile, rekeyJSON{
+		Created: time.Now(),
+		OldKey:  r.key,
+	})
+	if err != nil {
+		removeKey()
+		return nil, errors.Wrap(err, "saving rekey file")
+	}
+	debug.Log("started rekey %v using new key %v", state.id, key.ID())
+	return state, nil
+}
+
+// withRekey returns a copy of the repository which encrypts data using the
+// new master key of state. The copy shares the index and backend of r.
+func (r *Repository) withRekey(state *rekeyState) *Repository {
+	return &Repository{
+		be:          r.be,
+		cfg:         r.cfg,
+		key:         state.newKey,
+		keyID:       state.keyID,
+		idx:         r.idx,
+		cache:       r.cache,
+		opts:        r.opts,
+		packerCount: r.packerCount,
+		dryRun:      r.dryRun,
+		rekey:       state,
+	}
+}
+
+// RekeyOptions collects all options for the rekey operation.
+type RekeyOptions struct {
+	// Password, Username and Hostname are used for the key file of the new
+	// master key. They are ignored when resuming a rekey, as the key file is
+	// created when starting it.
+	Password string
+	Username string
+	Hostname string
+}
+
+// RekeyStats lists the files which must be re-encrypted.
+type RekeyStats struct {
+	// Packs is the number of old packs, including those pending deletion and
+	// unindexed ones.
+	Packs        uint
+	PendingPacks uint
+	// UnindexedPacks is the number of old packs which are no longer
+	// referenced by the index, as an interrupted rekey failed to remove them.
+	UnindexedPacks uint
+	// RepackPacks is the number of old packs whose blobs must be re-encrypted.
+	RepackPacks  uint
+	PackSize     uint64
+	Indexes      uint
+	IndexSize    uint64
+	Snapshots    uint
+	SnapshotSize uint64
+	LogEntries   uint
+	LogEntrySize uint64
+}
+
+// TotalSize returns the number of bytes which must be re-encrypted.
+func (s RekeyStats) TotalSize() uint64 {
+	return s.PackSize + s.IndexSize + s.SnapshotSize + s.LogEntrySize
+}
+
+// RekeyPlan is the result of PlanRekey. It contains the files which are still
+// encrypted using the old master key.
+type RekeyPlan struct {
+	repo  *Repository
+	rekey *rekeyState
+
+	oldPacks     restic.IDSet // packs encrypted using the old master key
+	repackPacks  restic.IDSet // old packs containing blobs which must be re-encrypted
+	keepBlobs    restic.AssociatedBlobSet
+	oldSnapshots restic.IDSet
+	// snapshotLister is the memorized list of snapshots
+	snapshotLister restic.Lister
+	// newSnapshots maps the hash of the plaintext of already re-encrypted
+	// snapshots to their ID.
+	newSnapshots map[restic.ID]restic.ID
+	snapshotLog  bool
+
+	stats RekeyStats
+}
+
+// Stats returns the amount of data which must be re-encrypted.
+func (plan *RekeyPlan) Stats() RekeyStats {
+	return plan.stats
+}
+
+// Resume returns true if the plan continues an interrupted rekey.
+func (plan *RekeyPlan) Resume() bool {
+	return plan.rekey != nil
+}
+
+// PlanRekey determines which files must be re-encrypted. If a rekey was
+// interrupted, only those files which are still encrypted using the old master
+// key are planned. getUsedBlobs must add all blobs referenced by the snapshots
+// listed by snapshotLister, the rekey is refused if one of them is not indexed.
+// The repository must be locked exclusively.
+func PlanRekey(ctx context.Context, repo *Repository, getUsedBlobs func(ctx context.Context, snapshotLister restic.Lister, repo restic.Repository, usedBlobs restic.FindBlobSet) error, printer progress.Printer) (*RekeyPlan, error) {
+	if repo.Connections() < 2 {
+		return nil, errors.Fatal("rekey requires a backend connection limit of at least two")
+	}
+
+	plan := &RekeyPlan{
+		repo:         repo,
+		rekey:        repo.rekey,
+		newSnapshots: make(map[restic.ID]restic.ID),
+	}
+
+	// the snapshots must be listed before the snapshot log is verified
+	snapshotLister, err := restic.MemorizeList(ctx, repo, restic.SnapshotFile)
+	if err != nil {
+		return nil, err
+	}
+	printer.P("verifying snapshot log\n")
+	status, err := VerifySnapshotLog(ctx, repo, repo.Cache(), snapshotLister)
+	if err != nil {
+		return nil, err
+	}
+	if status.Active && !status.OK() {
+		return nil, errors.Fatal("the snapshot log reports missing snapshots or log entries, run `restic check` for details")
+	}
+	plan.snapshotLog = status.Active
+
+	printer.P("searching snapshots\n")
+	plan.snapshotLister = snapshotLister
+	err = plan.planSnapshots(ctx)
+	if err != nil {
+		return nil, err
+	}
+	err = repo.List(ctx, restic.SnapshotLogFile, func(_ restic.ID, size int64) error {
+		plan.stats.LogEntries++
+		plan.stats.LogEntrySize += uint64(size)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	printer.P("loading indexes...\n")
+	err = repo.List(ctx, restic.IndexFile, func(_ restic.ID, size int64) error {
+		plan.stats.Indexes++
+		plan.stats.IndexSize += uint64(size)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = repo.LoadIndex(ctx, printer)
+	if err != nil {
+		return nil, err
+	}
+
+	// old packs are deleted, thus all used blobs must have been indexed
+	usedBlobs := repo.NewAssociatedBlobSet()
+	err = getUsedBlobs(ctx, snapshotLister, repo, usedBlobs)
+	if err != nil {
+		return nil, err
+	}
+	missingBlobs := restic.NewBlobSet()
+	for bh := range usedBlobs.Keys() {
+		if _, ok := repo.LookupBlobSize(bh); !ok {
+			missingBlobs.Insert(bh)
+		}
+	}
+	if len(missingBlobs) != 0 {
+		printer.E("%v not found in the index\n\n"+
+			"Integrity check failed: Data seems to be missing.\n"+
+			"Will not start rekey to prevent (additional) data loss!\n"+
+			"Run `restic repair index` and `restic check` first.", missingBlobs)
+		return nil, ErrIndexIncomplete
+	}
+
+	printer.P("searching packs\n")
+	err = plan.planPacks(ctx, printer)
+	if err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+func (plan *RekeyPlan) planSnapshots(ctx context.Context) error {
+	repo := plan.repo
+	plan.oldSnapshots = restic.NewIDSet()
+	var m sync.Mutex
+	return restic.ParallelList(ctx, plan.snapshotLister, restic.SnapshotFile, repo.Connections(), func(ctx context.Context, id restic.ID, size int64) error {
+		if plan.rekey != nil {
+			buf, err := repo.LoadRaw(ctx, restic.SnapshotFile, id)
+			if err != nil {
+				return err
+			}
+			plaintext, err := repo.decryptUnpacked(plan.rekey.newKey, restic.SnapshotFile, buf)
+			if err == nil {
+				m.Lock()
+				plan.newSnapshots[restic.Hash(plaintext)] = id
+				m.Unlock()
+				return nil
+			} else if !errors.Is(err, crypto.ErrUnauthenticated) {
+				return errors.Wrapf(err, "loading snapshot %v", id.Str())
+			}
+		}
+
+		m.Lock()
+		defer m.Unlock()
+		plan.oldSnapshots.Insert(id)
+		plan.stats.Snapshots++
+		plan.stats.SnapshotSize += uint64(size)
+		return nil
+	})
+}
+
+func (plan *RekeyPlan) planPacks(ctx context.Context, printer progress.Printer) error {
+	repo := plan.repo
+	pending := loadPendingDelete(repo)
+	indexed := repo.idx.Packs(nil)
+	for id := range pending {
+		indexed.Insert(id)
+	}
+
+	oldKey := repo.key
+	if plan.rekey != nil {
+		oldKey = plan.rekey.oldKey
+	}
+
+	var m sync.Mutex
+	plan.oldPacks = restic.NewIDSet()
+	unindexed := restic.NewIDSet()
+	newPacks := restic.NewIDSet()
+	packSize := make(map[restic.ID]int64)
+	bar := printer.NewCounter("packs checked")
+	if plan.rekey != nil {
+		bar.SetMax(uint64(len(indexed)))
+	}
+	err := restic.ParallelList(ctx, repo, restic.PackFile, repo.Connections(), func(ctx context.Context, id restic.ID, size int64) error {
+		h := backend.Handle{Type: restic.PackFile, Name: id.String()}
+		if !indexed.Has(id) {
+			// unindexed packs still encrypted using the old master key are
+			// removed if an interrupted rekey failed to do so. Other
+			// unreferenced packs are left to prune.
+			_, _, err := pack.List(oldKey, backend.ReaderAt(ctx, repo.be, h), size)
+			if err != nil {
+				debug.Log("leaving unindexed pack %v: %v", id, err)
+				return nil
+			}
+			m.Lock()
+			defer m.Unlock()
+			unindexed.Insert(id)
+			return nil
+		}
+
+		isOld := true
+		if plan.rekey != nil {
+			_, _, err := pack.List(plan.rekey.newKey, backend.ReaderAt(ctx, repo.be, h), size)
+			if err == nil {
+				isOld = false
+			} else if !errors.Is(err, crypto.ErrUnauthenticated) {
+				return errors.Wrapf(err, "reading header of pack %v", id.Str())
+			}
+			bar.Add(1)
+		}
+
+		m.Lock()
+		defer m.Unlock()
+		packSize[id] = size
+		if isOld {
+			plan.oldPacks.Insert(id)
+		} else {
+			newPacks.Insert(id)
+		}
+		return nil
+	})
+	bar.Done()
+	if err != nil {
+		return err
+	}
+	if plan.rekey == nil && len(unindexed) != 0 {
+		// the blobs of these packs would be lost if the index is incomplete
+		return errors.Fatalf("%d packs are not referenced by the index, run `restic repair index` first", len(unindexed))
+	}
+
+	for id := range indexed {
+		if _, ok := packSize[id]; !ok {
+			if _, ok := pending[id]; ok {
+				// packs pending deletion may already be deleted
+				continue
+			}
+			return errors.Fatalf("pack %v is referenced by the index but missing, run `restic repair index` first", id.Str())
+		}
+	}
+
+	// blobs of packs pending deletion are re-encrypted as well, as they may
+	// still be used by snapshots created concurrently to an earlier prune
+	plan.keepBlobs = repo.NewAssociatedBlobSet()
+	err = repo.ListBlobs(ctx, func(pb restic.PackBlob) {
+		if plan.oldPacks.Has(pb.PackID()) {
+			plan.keepBlobs.Insert(pb.Handle())
+		}
+	})
+	if err != nil {
+		return err
+	}
+	err = repo.ListBlobs(ctx, func(pb restic.PackBlob) {
+		if newPacks.Has(pb.PackID()) {
+			plan.keepBlobs.Delete(pb.Handle())
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	plan.repackPacks = restic.NewIDSet()
+	err = repo.ListBlobs(ctx, func(pb restic.PackBlob) {
+		if plan.oldPacks.Has(pb.PackID()) && plan.keepBlobs.Has(pb.Handle()) {
+			plan.repackPacks.Insert(pb.PackID())
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	for id := range plan.oldPacks {
+		if _, ok := pending[id]; ok {
+			plan.stats.PendingPacks++
+		}
+		plan.stats.Packs++
+		if plan.repackPacks.Has(id) {
+			plan.stats.RepackPacks++
+			plan.stats.PackSize += uint64(packSize[id])
+		}
+	}
+	for id := range unindexed {
+		plan.oldPacks.Insert(id)
+		plan.stats.Packs++
+		plan.stats.UnindexedPacks++
+	}
+	return nil
+}
+
+// Execute re-encrypts all files using a new master key:
+// - add a key file for the new master key and a rekey file, unless resumed
+// - re-encrypt the blobs of all old packs and rewrite all indexes
+// - delete the old packs
+// - re-encrypt the snapshots and the snapshot log
+// - re-encrypt the config
+// - remove all other key files
+//
+// Each step only processes files which are still encrypted using the old
+// master key, such that an interrupted rekey can be resumed.
+func (plan *RekeyPlan) Execute(ctx context.Context, opts RekeyOptions, printer progress.Printer) error {
+	state := plan.rekey
+	if state == nil {
+		printer.P("generating new master key\n")
+		var err error
+		state, err = startRekey(ctx, plan.repo, opts)
+		if err != nil {
+			return err
+		}
+	} else {
+		printer.P("resuming interrupted rekey\n")
+	}
+	repo := plan.repo.withRekey(state)
+	// make sure the plan can only be used once
+	plan.repo = nil
+
+	if len(plan.repackPacks) != 0 {
+		printer.P("re-encrypting %d packs\n", len(plan.repackPacks))
+		bar := printer.NewCounter("packs rewritten")
+		err := repo.WithBlobUploader(ctx, func(ctx context.Context, uploader restic.BlobSaverWithAsync) error {
+			return CopyBlobs(ctx, repo, repo, uploader, plan.repackPacks, plan.keepBlobs, bar, printer.P)
+		})
+		if err != nil {
+			return errors.Fatalf("%s", err)
+		}
+		if plan.keepBlobs.Len() != 0 {
+			return errors.Fatalf("internal error: %v blobs were not re-encrypted", plan.keepBlobs.Len())
+		}
+		// allow GC of the blob set
+		plan.keepBlobs = nil
+	}
+
+	printer.P("rebuilding index\n")
+	err := repo.idx.Rewrite(ctx, &internalRepository{repo}, plan.oldPacks, nil, nil, indexRewriteOpts(printer, true))
+	if err != nil {
+		return errors.Fatalf("%s", err)
+	}
+
+	if len(plan.oldPacks) != 0 {
+		printer.P("removing %d old packs\n", len(plan.oldPacks))
+		// the old packs are no longer indexed, a resumed rekey removes them
+		// after listing all unindexed packs
+		err = deleteFiles(ctx, false, &internalRepository{repo}, plan.oldPacks, restic.PackFile, printer)
+		if err != nil {
+			return errors.Fatalf("removing old packs failed: %v", err)
+		}
+		_ = removeParityFiles(ctx, repo, plan.oldPacks, printer)
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	err = plan.rekeySnapshots(ctx, repo, printer)
+	if err != nil {
+		return err
+	}
+
+	if plan.snapshotLog {
+		printer.P("rewriting snapshot log\n")
+		err = compactSnapshotLog(ctx, repo)
+		if err != nil {
+			return errors.Fatalf("%s", err)
+		}
+	}
+
+	printer.P("rewriting config\n")
+	err = replaceConfigWithBackup(ctx, repo, repo.Config(), "restic-rekey-")
+	if err != nil {
+		return errors.Fatalf("%s", err)
+	}
+
+	printer.P("removing old keys\n")
+	return finishRekey(ctx, repo)
+}
+
+// rekeySnapshots re-encrypts all old snapshots. Snapshots which were already
+// re-encrypted by an interrupted rekey are not saved again.
+func (plan *RekeyPlan) rekeySnapshots(ctx context.Context, repo *Repository, printer progress.Printer) error {
+	if len(plan.oldSnapshots) == 0 {
+		return nil
+	}
+	printer.P("re-encrypting %d snapshots\n", len(plan.oldSnapshots))
+	bar := printer.NewCounter("snapshots rewritten")
+	bar.SetMax(uint64(len(plan.oldSnapshots)))
+	defer bar.Done()
+
+	var m sync.Mutex
+	return restic.ParallelList(ctx, plan.snapshotLister, restic.SnapshotFile, repo.Connections(), func(ctx context.Context, id restic.ID, _ int64) error {
+		if !plan.oldSnapshots.Has(id) {
+			return nil
+		}
+		plaintext, err := repo.LoadUnpacked(ctx, restic.SnapshotFile, id)
+		if err != nil {
+			return errors.Wrapf(err, "loading snapshot %v", id.Str())
+		}
+
+		hash := restic.Hash(plaintext)
+		m.Lock()
+		newID, ok := plan.newSnapshots[hash]
+		m.Unlock()
+		if !ok {
+			newID, err = repo.saveUnpacked(ctx, restic.SnapshotFile, plaintext)
+			if err != nil {
+				return errors.Wrapf(err, "saving snapshot %v", id.Str())
+			}
+			m.Lock()
+			plan.newSnapshots[hash] = newID
+			m.Unlock()
+		}
+
+		if err := repo.appendSnapshotLog(ctx, restic.IDs{newID}, restic.IDs{id}); err != nil {
+			return err
+		}
+		if err := repo.removeUnpacked(ctx, restic.SnapshotFile, id); err != nil {
+			return errors.Wrapf(err, "removing snapshot %v", id.Str())
+		}
+		debug.Log("re-encrypted snapshot %v as %v", id, newID)
+		bar.Add(1)
+		return nil
+	})
+}
+
+// finishRekey removes all key files except the one of the new master key as
+// well as the rekey files.
+func finishRekey(ctx context.Context, repo *Repository) error {
+	keyID := repo.rekey.keyID
+	oldKeys := restic.NewIDSet()
+	err := repo.List(ctx, restic.KeyFile, func(id restic.ID, _ int64) error {
+		if id != keyID {
+			oldKeys.Insert(id)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for id := range oldKeys {
+		err := repo.be.Remove(ctx, backend.Handle{Type: restic.KeyFile, Name: id.String()})
+		if err != nil {
+			return errors.Fatalf("removing old key %v failed: %v", id.Str(), err)
+		}
+	}
+
+	err = repo.List(ctx, restic.RekeyFile, func(id restic.ID, _ int64) error {
+		return repo.removeUnpacked(ctx, restic.RekeyFile, id)
+	})
+	if err != nil {
+		return errors.Fatalf("removing rekey file failed: %v", err)
+	}
+
+	debug.Log("rekey completed, new key %v", keyID)
+	return nil
+}
@@ -0,0 +1,218 @@
+package repository_test
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/repository/crypto"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+	"github.com/restic/restic/internal/ui/progress"
+)
+
+// failSnapshotBackend fails to save snapshots while failSnapshots is set and
+// to remove packs while failPackRemove is set.
+type failSnapshotBackend struct {
+	backend.Backend
+	failSnapshots  bool
+	failPackRemove bool
+}
+
+func (be *failSnapshotBackend) Save(ctx context.Context, h backend.Handle, rd backend.RewindReader) error {
+	if be.failSnapshots && h.Type == backend.SnapshotFile {
+		return errors.New("failure induced for testing")
+	}
+	return be.Backend.Save(ctx, h, rd)
+}
+
+func (be *failSnapshotBackend) Remove(ctx context.Context, h backend.Handle) error {
+	if be.failPackRemove && h.Type == backend.PackFile {
+		return errors.New("failure induced for testing")
+	}
+	return be.Backend.Remove(ctx, h)
+}
+
+func openRekeyRepo(t *testing.T, be backend.Backend, password string) (*repository.Repository, error) {
+	repo, err := repository.New(be, repository.Options{})
+	rtest.OK(t, err)
+	return repo, repo.SearchKey(context.TODO(), password, 10, "")
+}
+
+func setupRekeyRepo(t *testing.T) (*repository.Repository, *failSnapshotBackend, restic.BlobSet) {
+	seed := time.Now().UnixNano()
+	random := rand.New(rand.NewSource(seed))
+	t.Logf("rand initialized with seed %d", seed)
+
+	be := &failSnapshotBackend{Backend: repository.TestBackend(t)}
+	repo, _ := repository.TestRepositoryWithBackend(t, be, 0, repository.Options{})
+	createRandomBlobs(t, random, repo, 10, 0.5, true)
+	createRandomBlobs(t, random, repo, 10, 0.5, true)
+
+	saveTestSnapshot(t, repo, "first")
+	_, err := repository.RepairSnapshotLog(context.TODO(), repo, verifySnapshotLog(t, repo, nil))
+	rtest.OK(t, err)
+	saveTestSnapshot(t, repo, "second")
+
+	return repo, be, listBlobs(repo)
+}
+
+// planRekey plans a rekey for which all indexed blobs are used.
+func planRekey(repo *repository.Repository) (*repository.RekeyPlan, error) {
+	return repository.PlanRekey(context.TODO(), repo, func(ctx context.Context, _ restic.Lister, repo restic.Repository, usedBlobs restic.FindBlobSet) error {
+		return repo.ListBlobs(ctx, func(pb restic.PackBlob) {
+			usedBlobs.Insert(pb.Handle())
+		})
+	}, progress.NewNoopPrinter())
+}
+
+func rekey(t *testing.T, repo *repository.Repository, password string) error {
+	plan, err := planRekey(repo)
+	rtest.OK(t, err)
+	return plan.Execute(context.TODO(), repository.RekeyOptions{Password: password}, progress.NewNoopPrinter())
+}
+
+func checkRekeyedRepo(t *testing.T, be backend.Backend, password string, oldKey crypto.Key, blobs restic.BlobSet) {
+	repo, err := openRekeyRepo(t, be, password)
+	rtest.OK(t, err)
+	rtest.Assert(t, !repo.RekeyPending(), "rekey still pending")
+	rtest.Assert(t, *repo.Key() != oldKey, "master key was not replaced")
+	rtest.Equals(t, 1, len(listFiles(t, repo, restic.KeyFile)))
+	rtest.Equals(t, 0, len(listFiles(t, repo, restic.RekeyFile)))
+
+	repository.TestCheckRepo(t, repo)
+	rtest.Assert(t, listBlobs(repo).Equals(blobs), "unexpected blobs after rekey")
+	indexed := restic.NewIDSet()
+	rtest.OK(t, repo.ListBlobs(context.TODO(), func(pb restic.PackBlob) {
+		indexed.Insert(pb.PackID())
+	}))
+	rtest.Assert(t, listFiles(t, repo, restic.PackFile).Equals(indexed), "unindexed packs left after rekey")
+
+	snapshots := make(map[string]struct{})
+	for id := range listFiles(t, repo, restic.SnapshotFile) {
+		buf, err := repo.LoadUnpacked(context.TODO(), restic.SnapshotFile, id)
+		rtest.OK(t, err)
+		snapshots[string(buf)] = struct{}{}
+	}
+	rtest.Equals(t, map[string]struct{}{"first": {}, "second": {}}, snapshots)
+
+	status := verifySnapshotLog(t, repo, nil)
+	rtest.Assert(t, status.Active && status.OK(), "unexpected snapshot log status %+v", status)
+	rtest.Equals(t, 0, len(status.UnrecordedSnapshots))
+	rtest.Equals(t, 1, len(listFiles(t, repo, restic.SnapshotLogFile)))
+}
+
+func TestRekey(t *testing.T) {
+	repo, be, blobs := setupRekeyRepo(t)
+	oldKey := *repo.Key()
+
+	plan, err := planRekey(repo)
+	rtest.OK(t, err)
+	rtest.Assert(t, !plan.Resume(), "unexpected pending rekey")
+	stats := plan.Stats()
+	rtest.Equals(t, uint(2), stats.Snapshots)
+	rtest.Equals(t, uint(len(listFiles(t, repo, restic.PackFile))), stats.Packs)
+	rtest.Assert(t, stats.TotalSize() > 0, "missing size estimate")
+
+	rtest.OK(t, plan.Execute(context.TODO(), repository.RekeyOptions{Password: rtest.TestPassword}, progress.NewNoopPrinter()))
+	checkRekeyedRepo(t, be, rtest.TestPassword, oldKey, blobs)
+}
+
+func TestRekeyResume(t *testing.T) {
+	repo, be, blobs := setupRekeyRepo(t)
+	oldKey := *repo.Key()
+
+	// interrupt the rekey after the packs were re-encrypted
+	be.failSnapshots = true
+	err := rekey(t, repo, "new password")
+	rtest.Assert(t, err != nil, "rekey did not fail")
+	be.failSnapshots = false
+
+	// the old password no longer grants access to the repository
+	_, err = openRekeyRepo(t, be, rtest.TestPassword)
+	rtest.Assert(t, errors.Is(err, repository.ErrNoKeyFound), "unexpected error %v", err)
+
+	// the repository remains usable with the new password
+	repo, err = openRekeyRepo(t, be, "new password")
+	rtest.OK(t, err)
+	rtest.Assert(t, repo.RekeyPending(), "rekey not pending")
+	repository.TestCheckRepo(t, repo)
+	rtest.Assert(t, listBlobs(repo).Equals(blobs), "unexpected blobs during rekey")
+
+	plan, err := planRekey(repo)
+	rtest.OK(t, err)
+	rtest.Assert(t, plan.Resume(), "rekey not resumed")
+	rtest.Equals(t, uint(0), plan.Stats().Packs)
+	rtest.Equals(t, uint(2), plan.Stats().Snapshots)
+
+	// the password is only used when starting the rekey
+	rtest.OK(t, plan.Execute(context.TODO(), repository.RekeyOptions{}, progress.NewNoopPrinter()))
+	checkRekeyedRepo(t, be, "new password", oldKey, blobs)
+}
+
+func TestRekeySamePassword(t *testing.T) {
+	repo, be, blobs := setupRekeyRepo(t)
+	oldKey := *repo.Key()
+
+	be.failSnapshots = true
+	err := rekey(t, repo, rtest.TestPassword)
+	rtest.Assert(t, err != nil, "rekey did not fail")
+	be.failSnapshots = false
+
+	// the key file of the old master key must be skipped
+	repo, err = openRekeyRepo(t, be, rtest.TestPassword)
+	rtest.OK(t, err)
+	rtest.Assert(t, repo.RekeyPending(), "rekey not pending")
+
+	rtest.OK(t, rekey(t, repo, ""))
+	checkRekeyedRepo(t, be, rtest.TestPassword, oldKey, blobs)
+}
+
+func TestRekeyRemovePacksFailed(t *testing.T) {
+	repo, be, blobs := setupRekeyRepo(t)
+	oldKey := *repo.Key()
+	oldPacks := listFiles(t, repo, restic.PackFile)
+
+	be.failPackRemove = true
+	err := rekey(t, repo, rtest.TestPassword)
+	rtest.Assert(t, err != nil, "rekey did not fail")
+	be.failPackRemove = false
+
+	repo = repository.TestOpenBackend(t, be)
+	rtest.Assert(t, repo.RekeyPending(), "rekey not pending")
+	plan, err := planRekey(repo)
+	rtest.OK(t, err)
+	rtest.Equals(t, uint(len(oldPacks)), plan.Stats().UnindexedPacks)
+	rtest.Equals(t, uint(0), plan.Stats().RepackPacks)
+
+	rtest.OK(t, plan.Execute(context.TODO(), repository.RekeyOptions{}, progress.NewNoopPrinter()))
+	checkRekeyedRepo(t, be, rtest.TestPassword, oldKey, blobs)
+	rtest.Equals(t, 0, len(listFiles(t, repo, restic.PackFile).Intersect(oldPacks)))
+}
+
+func TestRekeyUnindexedPacks(t *testing.T) {
+	repo, be, _ := setupRekeyRepo(t)
+	packs := listFiles(t, repo, restic.PackFile)
+	for id := range listFiles(t, repo, restic.IndexFile) {
+		rtest.OK(t, be.Remove(context.TODO(), backend.Handle{Type: backend.IndexFile, Name: id.String()}))
+	}
+
+	// the packs may contain the only copy of used blobs
+	repo = repository.TestOpenBackend(t, be)
+	_, err := planRekey(repo)
+	rtest.Assert(t, err != nil && errors.IsFatal(err), "unexpected error %v", err)
+	rtest.Assert(t, listFiles(t, repo, restic.PackFile).Equals(packs), "packs were removed")
+}
+
+func TestRekeyMissingBlobs(t *testing.T) {
+	repo, _, _ := setupRekeyRepo(t)
+	_, err := repository.PlanRekey(context.TODO(), repo, func(_ context.Context, _ restic.Lister, _ restic.Repository, usedBlobs restic.FindBlobSet) error {
+		usedBlobs.Insert(restic.BlobHandle{Type: restic.DataBlob, ID: restic.NewRandomID()})
+		return nil
+	}, progress.NewNoopPrinter())
+	rtest.Equals(t, repository.ErrIndexIncomplete, err)
+}
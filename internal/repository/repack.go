@@ -4,9 +4,9 @@ import (
 	"context"
 	"sync"
 
+	"github.com/restic/restic/internal/backend"
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/errors"
-	"github.com/restic/restic/internal/feature"
 	"github.com/restic/restic/internal/repository/index"
 	"github.com/restic/restic/internal/repository/pack"
 	"github.com/restic/restic/internal/restic"
@@ -67,7 +67,7 @@ func repack(
 
 	wg, wgCtx := errgroup.WithContext(ctx)
 
-	if feature.Flag.Enabled(feature.S3Restore) {
+	if backend.WarmupEnabled() {
 		job, err := repo.StartWarmup(ctx, packs)
 		if err != nil {
 			return err
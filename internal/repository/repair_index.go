@@ -106,9 +106,14 @@ func RepairIndex(ctx context.Context, repo *Repository, opts RepairIndexOptions,
 func rewriteIndexFiles(ctx context.Context, repo *Repository, removePacks restic.IDSet, oldIndexes restic.IDSet, extraObsolete restic.IDs, printer progress.Printer) error {
 	printer.P("rebuilding index\n")
 
-	bar := printer.NewCounter("indexes processed")
-	return repo.idx.Rewrite(ctx, &internalRepository{repo}, removePacks, oldIndexes, extraObsolete, index.MasterIndexRewriteOpts{
-		SaveProgress: bar,
+	return repo.idx.Rewrite(ctx, &internalRepository{repo}, removePacks, oldIndexes, extraObsolete, indexRewriteOpts(printer, false))
+}
+
+// indexRewriteOpts returns the options to rewrite the index while reporting
+// the progress using printer.
+func indexRewriteOpts(printer progress.Printer, rewriteAll bool) index.MasterIndexRewriteOpts {
+	return index.MasterIndexRewriteOpts{
+		SaveProgress: printer.NewCounter("indexes processed"),
 		DeleteProgress: func() restic.Counter {
 			return printer.NewCounter("old indexes deleted")
 		},
@@ -119,5 +124,6 @@ func rewriteIndexFiles(ctx context.Context, repo *Repository, removePacks restic
 				printer.VV("removed index %v\n", id.String())
 			}
 		},
-	})
+		RewriteAll: rewriteAll,
+	}
 }
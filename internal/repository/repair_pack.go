@@ -68,6 +68,7 @@ func RepairPacks(ctx context.Context, repo *Repository, ids restic.IDSet, printe
 	bar = printer.NewCounter("files deleted")
 	_ = restic.ParallelRemove(ctx, &internalRepository{repo}, ids, restic.PackFile, nil, bar)
 	bar.Done()
+	_ = removeParityFiles(ctx, repo, ids, printer)
 
 	return nil
 }
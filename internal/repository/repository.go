@@ -14,11 +14,13 @@ import (
 	"github.com/restic/restic/internal/backend"
 	"github.com/restic/restic/internal/backend/cache"
 	"github.com/restic/restic/internal/backend/dryrun"
+	"github.com/restic/restic/internal/backend/tiered"
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/repository/crypto"
 	"github.com/restic/restic/internal/repository/index"
 	"github.com/restic/restic/internal/repository/pack"
+	"github.com/restic/restic/internal/repository/parity"
 	"github.com/restic/restic/internal/restic"
 
 	"golang.org/x/sync/errgroup"
@@ -46,11 +48,25 @@ type Repository struct {
 	treePM      *packerManager
 	dataPM      *packerManager
 	packerCount int
+	// checkpointMu is held for reading while a blob is added to a packer,
+	// see checkpoint.
+	checkpointMu sync.RWMutex
 
 	allocEnc sync.Once
 	allocDec sync.Once
 	enc      *zstd.Encoder
 	dec      *zstd.Decoder
+
+	warmupStateMu sync.Mutex
+
+	snapshotLog snapshotLog
+	dryRun      bool
+	// warnf reports non-fatal errors.
+	warnf func(msg string, args ...interface{})
+
+	// rekey holds both master keys while a rekey is in progress. Data is
+	// encrypted using key, but can be decrypted using either master key.
+	rekey *rekeyState
 }
 
 // internalRepository allows using SaveUnpacked and RemoveUnpacked with all FileTypes
@@ -62,6 +78,11 @@ type Options struct {
 	Compression   CompressionMode
 	PackSize      uint
 	NoExtraVerify bool
+	IndexMode     IndexMode
+	// ParityRedundancy is the size of the recovery data for new pack files in
+	// percent of the pack size. It is only stored in the config by Init and
+	// UpdateParityRedundancy, afterwards the value from the config is used.
+	ParityRedundancy uint
 }
 
 // CompressionMode configures if data should be compressed.
@@ -119,11 +140,58 @@ func (c *CompressionMode) Type() string {
 	return "mode"
 }
 
+// IndexMode configures where the index is kept after loading it.
+type IndexMode uint
+
+// Constants for the different index modes.
+const (
+	IndexModeMemory  IndexMode = 0
+	IndexModeMmap    IndexMode = 1
+	IndexModeInvalid IndexMode = 2
+)
+
+// Set implements the method needed for pflag command flag parsing.
+func (m *IndexMode) Set(s string) error {
+	switch s {
+	case "memory":
+		*m = IndexModeMemory
+	case "mmap":
+		*m = IndexModeMmap
+	default:
+		*m = IndexModeInvalid
+		return fmt.Errorf("invalid index mode %q, must be one of (memory|mmap)", s)
+	}
+
+	return nil
+}
+
+func (m *IndexMode) String() string {
+	switch *m {
+	case IndexModeMemory:
+		return "memory"
+	case IndexModeMmap:
+		return "mmap"
+	default:
+		return "invalid"
+	}
+}
+
+func (m *IndexMode) Type() string {
+	return "mode"
+}
+
+// diskIndexStateFile is the name of the cache state file which contains the
+// on-disk index used by IndexModeMmap.
+const diskIndexStateFile = "index.bin"
+
 // New returns a new repository with backend be.
 func New(be backend.Backend, opts Options) (*Repository, error) {
 	if opts.Compression == CompressionInvalid {
 		return nil, errors.New("invalid compression mode")
 	}
+	if opts.IndexMode == IndexModeInvalid {
+		return nil, errors.New("invalid index mode")
+	}
 
 	if opts.PackSize == 0 {
 		opts.PackSize = DefaultPackSize
@@ -133,12 +201,16 @@ func New(be backend.Backend, opts Options) (*Repository, error) {
 	} else if opts.PackSize < MinPackSize {
 		return nil, fmt.Errorf("pack size smaller than minimum of %v MiB", MinPackSize/1024/1024)
 	}
+	if opts.ParityRedundancy > parity.MaxRedundancy {
+		return nil, fmt.Errorf("parity redundancy larger than limit of %v%%", parity.MaxRedundancy)
+	}
 
 	repo := &Repository{
 		be:          be,
 		opts:        opts,
 		idx:         index.NewMasterIndex(),
 		packerCount: defaultPackerCount,
+		warnf:       debug.Log,
 	}
 
 	return repo, nil
@@ -147,6 +219,7 @@ func New(be backend.Backend, opts Options) (*Repository, error) {
 // setConfig assigns the given config and updates the repository parameters accordingly
 func (r *Repository) setConfig(cfg restic.Config) {
 	r.cfg = cfg
+	r.idx.SetFormat(index.FormatForVersion(cfg.Version))
 }
 
 // Config returns the repository configuration.
@@ -173,9 +246,16 @@ func (r *Repository) Cache() *cache.Cache {
 	return r.cache
 }
 
+// SetWarnf sets the function which reports non-fatal errors, for example if a
+// saved snapshot cannot be recorded in the snapshot log.
+func (r *Repository) SetWarnf(warnf func(msg string, args ...interface{})) {
+	r.warnf = warnf
+}
+
 // SetDryRun sets the repo backend into dry-run mode.
 func (r *Repository) SetDryRun() {
 	r.be = dryrun.New(r.be)
+	r.dryRun = true
 }
 
 func (r *Repository) Checker() *Checker {
@@ -196,7 +276,7 @@ func (r *Repository) LoadUnpacked(ctx context.Context, t restic.FileType, id res
 	}
 
 	nonce, ciphertext := buf[:r.key.NonceSize()], buf[r.key.NonceSize():]
-	plaintext, err := r.key.Open(ciphertext[:0], nonce, ciphertext, nil)
+	plaintext, err := openWithFallback(r.key, r.fallbackKey(), ciphertext[:0], nonce, ciphertext)
 	if err != nil {
 		return nil, err
 	}
@@ -287,7 +367,7 @@ func (r *Repository) loadBlob(ctx context.Context, blobs []*pack.PackedBlob, buf
 			continue
 		}
 
-		it := newPackBlobIterator(blob.PackID(), newByteReader(buf), blob.Blob.Offset, pack.Blobs{blob.Blob}, r.key, r.getZstdDecoder())
+		it := newPackBlobIterator(blob.PackID(), newByteReader(buf), blob.Blob.Offset, pack.Blobs{blob.Blob}, r.key, r.fallbackKey(), r.getZstdDecoder())
 		pbv, err := it.Next()
 
 		if err == nil {
@@ -316,24 +396,31 @@ func (r *Repository) loadBlob(ctx context.Context, blobs []*pack.PackedBlob, buf
 	return nil, errors.Errorf("loading %v from %v packs failed", blobs[0].Handle(), len(blobs))
 }
 
-func (r *Repository) getZstdEncoder() *zstd.Encoder {
-	r.allocEnc.Do(func() {
+// zstdLevel returns the zstd level used for the compression mode.
+func zstdLevel(mode CompressionMode) zstd.EncoderLevel {
+	switch mode {
+	case CompressionFastest:
+		return zstd.SpeedFastest
+	case CompressionBetter:
+		return zstd.SpeedBetterCompression
+	case CompressionMax:
+		return zstd.SpeedBestCompression
+	default:
+		return zstd.SpeedDefault
+	}
+}
 
-		var level zstd.EncoderLevel
-		switch r.opts.Compression {
-		case CompressionFastest:
-			level = zstd.SpeedFastest
-		case CompressionBetter:
-			level = zstd.SpeedBetterCompression
-		case CompressionMax:
-			level = zstd.SpeedBestCompression
-		default:
-			level = zstd.SpeedDefault
-		}
+// compressionLevel returns the zstd level used to compress blobs, which is
+// recorded in the index.
+func (r *Repository) compressionLevel() uint8 {
+	return uint8(zstdLevel(r.opts.Compression))
+}
 
+func (r *Repository) getZstdEncoder() *zstd.Encoder {
+	r.allocEnc.Do(func() {
 		opts := []zstd.EOption{
 			// Set the compression level configured.
-			zstd.WithEncoderLevel(level),
+			zstd.WithEncoderLevel(zstdLevel(r.opts.Compression)),
 			// Disable CRC, we have enough checks in place, makes the
 			// compressed data four bytes shorter.
 			zstd.WithEncoderCRC(false),
@@ -373,24 +460,25 @@ func (r *Repository) getZstdDecoder() *zstd.Decoder {
 // saveAndEncrypt encrypts data and stores it to the backend as type t. If data
 // is small enough, it will be packed together with other small blobs. The
 // caller must ensure that the id matches the data. Returned is the size data
-// occupies in the repo (compressed or not, including the encryption overhead).
-func (r *Repository) saveAndEncrypt(ctx context.Context, t restic.BlobType, data []byte, id restic.ID) (size int, err error) {
+// occupies in the repo (compressed or not, including the encryption overhead)
+// and whether compression was skipped for incompressible data.
+func (r *Repository) saveAndEncrypt(ctx context.Context, t restic.BlobType, data []byte, id restic.ID, hint restic.CompressionHint) (size int, skippedCompression bool, err error) {
 	debug.Log("save id %v (%v, %d bytes)", id, t, len(data))
 
 	uncompressedLength := 0
-	if r.cfg.Version > 1 {
-		// we have a repo v2, so compression is available. if the user opts to
-		// not compress, we won't compress any data, but everything else is
-		// compressed.
-		// uncompressedLength != 0 is used to indicate compressed data. Thus, a zero-sized blob
-		// cannot be compressed. This special case is only relevant for tests, normal operation does not
-		// generate zero-sized blobs.
-		if len(data) > 0 && (r.opts.Compression != CompressionOff || t != restic.DataBlob) {
-			uncompressedLength = len(data)
-			data = r.getZstdEncoder().EncodeAll(data, nil)
-		}
+	compress, skippedCompression := r.compressBlob(t, data, hint)
+	if compress {
+		uncompressedLength = len(data)
+		data = r.getZstdEncoder().EncodeAll(data, nil)
 	}
 
+	size, err = r.saveEncoded(ctx, t, data, id, uncompressedLength)
+	return size, skippedCompression, err
+}
+
+// saveEncoded encrypts the already compressed data and stores it like
+// saveAndEncrypt. For uncompressed data, uncompressedLength must be zero.
+func (r *Repository) saveEncoded(ctx context.Context, t restic.BlobType, data []byte, id restic.ID, uncompressedLength int) (size int, err error) {
 	nonce := crypto.NewRandomNonce()
 
 	ciphertext := make([]byte, 0, crypto.CiphertextLength(len(data)))
@@ -479,9 +567,18 @@ func (r *Repository) decompressUnpacked(p []byte) ([]byte, error) {
 }
 
 // SaveUnpacked encrypts data and stores it in the backend. Returned is the
-// storage hash.
+// storage hash. Saved snapshots are recorded in the snapshot log.
 func (r *Repository) SaveUnpacked(ctx context.Context, t restic.WriteableFileType, buf []byte) (id restic.ID, err error) {
-	return r.saveUnpacked(ctx, t.ToFileType(), buf)
+	id, err = r.saveUnpacked(ctx, t.ToFileType(), buf)
+	if err != nil || t != restic.WriteableSnapshotFile {
+		return id, err
+	}
+	// the snapshot exists even if it cannot be recorded, `check` then reports
+	// it as unrecorded
+	if err := r.appendSnapshotLog(ctx, restic.IDs{id}, nil); err != nil {
+		r.warnf("unable to record snapshot %v in the snapshot log: %v", id.Str(), err)
+	}
+	return id, nil
 }
 
 func (r *internalRepository) SaveUnpacked(ctx context.Context, t restic.FileType, buf []byte) (id restic.ID, err error) {
@@ -549,7 +646,15 @@ func (r *Repository) verifyUnpacked(buf []byte, t restic.FileType, expected []by
 	return nil
 }
 
+// RemoveUnpacked removes a file from the backend. The removal of snapshots is
+// recorded in the snapshot log before the snapshot is removed, unless it was
+// already recorded by RecordSnapshotRemoval.
 func (r *Repository) RemoveUnpacked(ctx context.Context, t restic.WriteableFileType, id restic.ID) error {
+	if t == restic.WriteableSnapshotFile && !r.removalRecorded(id) {
+		if err := r.appendSnapshotLog(ctx, nil, restic.IDs{id}); err != nil {
+			return err
+		}
+	}
 	return r.removeUnpacked(ctx, t.ToFileType(), id)
 }
 
@@ -614,12 +719,57 @@ type blobSaverRepo struct {
 	repo *Repository
 }
 
+// SaveBlob compresses all blobs if compression is enabled. Detecting
+// incompressible data is left to callers of SaveBlobAsyncWithHint, otherwise
+// e.g. prune would repeatedly repack the blobs stored uncompressed.
 func (r *blobSaverRepo) SaveBlob(ctx context.Context, t restic.BlobType, buf []byte, id restic.ID, storeDuplicate bool) (newID restic.ID, known bool, size int, err error) {
-	return r.repo.saveBlob(ctx, t, buf, id, storeDuplicate)
+	newID, known, size, _, err = r.repo.saveBlob(ctx, t, buf, id, storeDuplicate, restic.CompressionHintForce)
+	return newID, known, size, err
 }
 
 func (r *blobSaverRepo) SaveBlobAsync(ctx context.Context, t restic.BlobType, buf []byte, id restic.ID, storeDuplicate bool, cb func(newID restic.ID, known bool, size int, err error)) {
-	r.repo.saveBlobAsync(ctx, t, buf, id, storeDuplicate, cb)
+	r.repo.saveBlobAsync(ctx, t, buf, id, storeDuplicate, restic.CompressionHintForce, func(newID restic.ID, known bool, size int, _ bool, err error) {
+		cb(newID, known, size, err)
+	})
+}
+
+func (r *blobSaverRepo) SaveBlobAsyncWithHint(ctx context.Context, t restic.BlobType, buf []byte, id restic.ID, storeDuplicate bool, hint restic.CompressionHint, cb func(newID restic.ID, known bool, size int, skippedCompression bool, err error)) {
+	r.repo.saveBlobAsync(ctx, t, buf, id, storeDuplicate, hint, cb)
+}
+
+func (r *blobSaverRepo) Checkpoint(ctx context.Context) error {
+	return r.repo.checkpoint(ctx)
+}
+
+// checkpoint uploads all blobs saved so far and stores them in the index,
+// while blobs can still be saved concurrently.
+func (r *Repository) checkpoint(ctx context.Context) error {
+	var pending restic.BlobSet
+	err := func() error {
+		// wait until all running saveBlob calls have added their blob to a
+		// packer. Afterwards, all pending blobs are contained in packers
+		// which are either flushed below or already queued for upload.
+		r.checkpointMu.Lock()
+		defer r.checkpointMu.Unlock()
+
+		if err := r.treePM.Flush(ctx); err != nil {
+			return err
+		}
+		if err := r.dataPM.Flush(ctx); err != nil {
+			return err
+		}
+		pending = r.idx.PendingBlobs()
+		return nil
+	}()
+	if err != nil {
+		return err
+	}
+
+	debug.Log("waiting for %d pending blobs", len(pending))
+	if err := r.idx.WaitStored(ctx, pending); err != nil {
+		return err
+	}
+	return r.idx.Flush(ctx, &internalRepository{r})
 }
 
 // Flush saves all remaining packs and the index
@@ -671,6 +821,12 @@ func (r *Repository) Connections() uint {
 	return r.be.Properties().Connections
 }
 
+// IsTiered returns true if data pack files are stored in a separate cold
+// backend, see tiered.Backend.
+func (r *Repository) IsTiered() bool {
+	return backend.AsBackend[*tiered.Backend](r.be) != nil
+}
+
 func (r *Repository) LookupBlob(bh restic.BlobHandle) []restic.PackBlob {
 	entries := r.idx.Lookup(bh)
 	out := make([]restic.PackBlob, len(entries))
@@ -704,6 +860,7 @@ func (r *Repository) listPacksFromIndex(ctx context.Context, packs restic.IDSet)
 
 func (r *Repository) clearIndex() {
 	r.idx = index.NewMasterIndex()
+	r.idx.SetFormat(index.FormatForVersion(r.cfg.Version))
 }
 
 // LoadIndex loads all index files from the backend in parallel and stores them
@@ -715,6 +872,13 @@ func (r *Repository) LoadIndex(ctx context.Context, p restic.TerminalCounterFact
 func (r *Repository) loadIndexWithCallback(ctx context.Context, p restic.TerminalCounterFactory, cb func(id restic.ID, idx *index.Index, err error) error) error {
 	debug.Log("Loading index")
 
+	if r.opts.IndexMode == IndexModeMmap {
+		if r.cache == nil {
+			return errors.New("index mode mmap requires a local cache")
+		}
+		r.idx.SetDiskIndexFile(r.cache.StateFilename(diskIndexStateFile))
+	}
+
 	bar := p.NewCounterTerminalOnly("index files loaded")
 
 	err := r.idx.Load(ctx, r, bar, cb)
@@ -858,20 +1022,50 @@ func (r *Repository) prepareCache() error {
 // SearchKey finds a key with the supplied password, afterwards the config is
 // read and parsed. It tries at most maxKeys key files in the repo.
 func (r *Repository) SearchKey(ctx context.Context, password string, maxKeys int, keyHint string) error {
-	key, err := searchKey(ctx, r, password, maxKeys, keyHint)
-	if err != nil {
-		return err
-	}
-
 	oldKey := r.key
 	oldKeyID := r.keyID
+	oldRekey := r.rekey
+	restore := func() {
+		r.key = oldKey
+		r.keyID = oldKeyID
+		r.rekey = oldRekey
+	}
+
+	// while a rekey is in progress, only the key file of the new master key
+	// grants access to the repository. Keys of the old master key are skipped.
+	skip := restic.NewIDSet()
+	var key *Key
+	for {
+		var err error
+		key, err = searchKey(ctx, r, password, maxKeys, keyHint, skip)
+		if errors.Is(err, ErrNoKeyFound) && len(skip) > 0 {
+			restore()
+			return fmt.Errorf("%w: a rekey is in progress, use the new password to access the repository", err)
+		}
+		if err != nil {
+			restore()
+			return err
+		}
+
+		r.key = key.master
+		r.keyID = key.ID()
+		r.rekey = nil
+		rekey, err := loadRekeyState(ctx, r)
+		if errors.Is(err, errRekeyOtherKey) {
+			skip.Insert(key.ID())
+			continue
+		}
+		if err != nil {
+			restore()
+			return fmt.Errorf("pending rekey cannot be loaded: %w", err)
+		}
+		r.rekey = rekey
+		break
+	}
 
-	r.key = key.master
-	r.keyID = key.ID()
 	cfg, err := restic.LoadConfig(ctx, r)
 	if err != nil {
-		r.key = oldKey
-		r.keyID = oldKeyID
+		restore()
 
 		if err == crypto.ErrUnauthenticated {
 			return fmt.Errorf("config or key %v is damaged: %w", key.ID(), err)
@@ -894,6 +1088,10 @@ func (r *Repository) Init(ctx context.Context, version uint, password string, ch
 		return fmt.Errorf("repository version %v too low", version)
 	}
 
+	if r.IsTiered() && version < restic.MinTieredRepoVersion {
+		return fmt.Errorf("a cold repository requires at least repository version %v", restic.MinTieredRepoVersion)
+	}
+
 	_, err := r.be.Stat(ctx, backend.Handle{Type: restic.ConfigFile})
 	if err != nil && !r.be.IsNotExist(err) {
 		return err
@@ -926,6 +1124,8 @@ func (r *Repository) Init(ctx context.Context, version uint, password string, ch
 	if chunkerPolynomial != nil {
 		cfg.ChunkerPolynomial = *chunkerPolynomial
 	}
+	cfg.Tiered = r.IsTiered()
+	cfg.ParityRedundancy = r.opts.ParityRedundancy
 
 	return r.init(ctx, password, cfg)
 }
@@ -956,21 +1156,33 @@ func (r *Repository) KeyID() restic.ID {
 
 // List runs fn for all files of type t in the repo.
 func (r *Repository) List(ctx context.Context, t restic.FileType, fn func(restic.ID, int64) error) error {
-	return r.be.List(ctx, t, func(fi backend.FileInfo) error {
+	var listed restic.IDSet
+	if t == restic.SnapshotFile {
+		listed = restic.NewIDSet()
+	}
+	err := r.be.List(ctx, t, func(fi backend.FileInfo) error {
 		id, err := restic.ParseID(fi.Name)
 		if err != nil {
 			debug.Log("unable to parse %v as an ID", fi.Name)
 			return nil
 		}
+		if listed != nil {
+			listed.Insert(id)
+		}
 		return fn(id, fi.Size)
 	})
+	if err == nil && listed != nil {
+		// the snapshots are compared with the snapshot log without listing them again
+		r.compareSnapshotLog(listed)
+	}
+	return err
 }
 
 // listPack returns blob entries from the pack file header including offsets.
 func (r *Repository) listPack(ctx context.Context, id restic.ID, size int64) (pack.Blobs, error) {
 	h := backend.Handle{Type: restic.PackFile, Name: id.String()}
 
-	entries, _, err := pack.List(r.Key(), backend.ReaderAt(ctx, r.be, h), size)
+	entries, _, err := listPackWithFallback(r.key, r.fallbackKey(), backend.ReaderAt(ctx, r.be, h), size)
 	if err != nil {
 		if r.cache != nil {
 			// ignore error as there is not much we can do here
@@ -978,7 +1190,7 @@ func (r *Repository) listPack(ctx context.Context, id restic.ID, size int64) (pa
 		}
 
 		// retry on error
-		entries, _, err = pack.List(r.Key(), backend.ReaderAt(ctx, r.be, h), size)
+		entries, _, err = listPackWithFallback(r.key, r.fallbackKey(), backend.ReaderAt(ctx, r.be, h), size)
 	}
 	return pack.Blobs(entries), err
 }
@@ -1014,10 +1226,11 @@ func (r *Repository) Close() error {
 // Also returns if the blob was already known before.
 // If the blob was not known before, it returns the number of bytes the blob
 // occupies in the repo (compressed or not, including encryption overhead).
-func (r *Repository) saveBlob(ctx context.Context, t restic.BlobType, buf []byte, id restic.ID, storeDuplicate bool) (newID restic.ID, known bool, size int, err error) {
+// hint controls the compression of data blobs.
+func (r *Repository) saveBlob(ctx context.Context, t restic.BlobType, buf []byte, id restic.ID, storeDuplicate bool, hint restic.CompressionHint) (newID restic.ID, known bool, size int, skippedCompression bool, err error) {
 
 	if int64(len(buf)) > math.MaxUint32 {
-		return restic.ID{}, false, 0, fmt.Errorf("blob is larger than 4GB")
+		return restic.ID{}, false, 0, false, fmt.Errorf("blob is larger than 4GB")
 	}
 
 	// compute plaintext hash if not already set
@@ -1034,26 +1247,29 @@ func (r *Repository) saveBlob(ctx context.Context, t restic.BlobType, buf []byte
 		newID = id
 	}
 
+	r.checkpointMu.RLock()
+	defer r.checkpointMu.RUnlock()
+
 	// first try to add to pending blobs; if not successful, this blob is already known
 	known = !r.idx.AddPending(restic.BlobHandle{ID: newID, Type: t}, uint(len(buf)))
 
 	// only save when needed or explicitly told
 	if !known || storeDuplicate {
-		size, err = r.saveAndEncrypt(ctx, t, buf, newID)
+		size, skippedCompression, err = r.saveAndEncrypt(ctx, t, buf, newID, hint)
 	}
 
-	return newID, known, size, err
+	return newID, known, size, skippedCompression, err
 }
 
-func (r *Repository) saveBlobAsync(ctx context.Context, t restic.BlobType, buf []byte, id restic.ID, storeDuplicate bool, cb func(newID restic.ID, known bool, size int, err error)) {
+func (r *Repository) saveBlobAsync(ctx context.Context, t restic.BlobType, buf []byte, id restic.ID, storeDuplicate bool, hint restic.CompressionHint, cb func(newID restic.ID, known bool, size int, skippedCompression bool, err error)) {
 	r.mainWg.Go(func() error {
 		if ctx.Err() != nil {
 			// fail fast if the context is cancelled
-			cb(restic.ID{}, false, 0, ctx.Err())
+			cb(restic.ID{}, false, 0, false, ctx.Err())
 			return ctx.Err()
 		}
-		newID, known, size, err := r.saveBlob(ctx, t, buf, id, storeDuplicate)
-		cb(newID, known, size, err)
+		newID, known, size, skippedCompression, err := r.saveBlob(ctx, t, buf, id, storeDuplicate, hint)
+		cb(newID, known, size, skippedCompression, err)
 		return err
 	})
 }
@@ -1096,10 +1312,10 @@ func (r *Repository) blobsInPack(packID restic.ID, handles []restic.BlobHandle)
 }
 
 func (r *Repository) loadBlobsFromPack(ctx context.Context, packID restic.ID, blobs pack.Blobs, handleBlobFn func(blob restic.BlobHandle, buf []byte, err error) error) error {
-	return streamPack(ctx, r.be.Load, r.LoadBlob, r.getZstdDecoder(), r.key, packID, blobs, handleBlobFn)
+	return streamPack(ctx, r.be.Load, r.LoadBlob, r.getZstdDecoder(), r.key, r.fallbackKey(), packID, blobs, handleBlobFn)
 }
 
-func streamPack(ctx context.Context, beLoad backendLoadFn, loadBlobFn loadBlobFn, dec *zstd.Decoder, key *crypto.Key, packID restic.ID, blobs pack.Blobs, handleBlobFn func(blob restic.BlobHandle, buf []byte, err error) error) error {
+func streamPack(ctx context.Context, beLoad backendLoadFn, loadBlobFn loadBlobFn, dec *zstd.Decoder, key, fallback *crypto.Key, packID restic.ID, blobs pack.Blobs, handleBlobFn func(blob restic.BlobHandle, buf []byte, err error) error) error {
 	if len(blobs) == 0 {
 		// nothing to do
 		return nil
@@ -1130,7 +1346,7 @@ func streamPack(ctx context.Context, beLoad backendLoadFn, loadBlobFn loadBlobFn
 
 		if split {
 			// load everything up to the skipped file section
-			err := streamPackPart(ctx, beLoad, loadBlobFn, dec, key, packID, blobs[lowerIdx:i], handleBlobFn)
+			err := streamPackPart(ctx, beLoad, loadBlobFn, dec, key, fallback, packID, blobs[lowerIdx:i], handleBlobFn)
 			if err != nil {
 				return err
 			}
@@ -1139,10 +1355,10 @@ func streamPack(ctx context.Context, beLoad backendLoadFn, loadBlobFn loadBlobFn
 		lastPos = blobs[i].Offset + blobs[i].Length
 	}
 	// load remainder
-	return streamPackPart(ctx, beLoad, loadBlobFn, dec, key, packID, blobs[lowerIdx:], handleBlobFn)
+	return streamPackPart(ctx, beLoad, loadBlobFn, dec, key, fallback, packID, blobs[lowerIdx:], handleBlobFn)
 }
 
-func streamPackPart(ctx context.Context, beLoad backendLoadFn, loadBlobFn loadBlobFn, dec *zstd.Decoder, key *crypto.Key, packID restic.ID, blobs pack.Blobs, handleBlobFn func(blob restic.BlobHandle, buf []byte, err error) error) error {
+func streamPackPart(ctx context.Context, beLoad backendLoadFn, loadBlobFn loadBlobFn, dec *zstd.Decoder, key, fallback *crypto.Key, packID restic.ID, blobs pack.Blobs, handleBlobFn func(blob restic.BlobHandle, buf []byte, err error) error) error {
 	h := backend.Handle{Type: restic.PackFile, Name: packID.String(), IsMetadata: blobs[0].Type.IsMetadata()}
 
 	dataStart := blobs[0].Offset
@@ -1174,7 +1390,7 @@ func streamPackPart(ctx context.Context, beLoad backendLoadFn, loadBlobFn loadBl
 		return errors.Wrap(err, "StreamPack")
 	}
 
-	it := newPackBlobIterator(packID, newByteReader(data), dataStart, blobs, key, dec)
+	it := newPackBlobIterator(packID, newByteReader(data), dataStart, blobs, key, fallback, dec)
 
 	for {
 		if ctx.Err() != nil {
@@ -1252,9 +1468,10 @@ type packBlobIterator struct {
 	rd            discardReader
 	currentOffset uint
 
-	blobs pack.Blobs
-	key   *crypto.Key
-	dec   *zstd.Decoder
+	blobs    pack.Blobs
+	key      *crypto.Key
+	fallback *crypto.Key
+	dec      *zstd.Decoder
 
 	decode []byte
 }
@@ -1268,13 +1485,14 @@ type packBlobValue struct {
 var errPackEOF = errors.New("reached EOF of pack file")
 
 func newPackBlobIterator(packID restic.ID, rd discardReader, currentOffset uint,
-	blobs pack.Blobs, key *crypto.Key, dec *zstd.Decoder) *packBlobIterator {
+	blobs pack.Blobs, key, fallback *crypto.Key, dec *zstd.Decoder) *packBlobIterator {
 	return &packBlobIterator{
 		packID:        packID,
 		rd:            rd,
 		currentOffset: currentOffset,
 		blobs:         blobs,
 		key:           key,
+		fallback:      fallback,
 		dec:           dec,
 	}
 }
@@ -1318,6 +1536,13 @@ func (b *packBlobIterator) Next() (packBlobValue, error) {
 	// decryption errors are likely permanent, give the caller a chance to skip them
 	nonce, ciphertext := buf[:b.key.NonceSize()], buf[b.key.NonceSize():]
 	plaintext, err := b.key.Open(ciphertext[:0], nonce, ciphertext, nil)
+	if errors.Is(err, crypto.ErrUnauthenticated) && b.fallback != nil {
+		plaintext, err = b.fallback.Open(ciphertext[:0], nonce, ciphertext, nil)
+		if err == nil {
+			// all blobs of a pack file are encrypted using the same key
+			b.key, b.fallback = b.fallback, b.key
+		}
+	}
 	if err != nil {
 		err = fmt.Errorf("decrypting blob %v from pack %v failed: %w", h, b.packID.String(), err)
 	}
@@ -441,6 +441,26 @@ func testRepositoryIncrementalIndex(t *testing.T, version uint) {
 
 }
 
+func TestRepositoryIndexFormat(t *testing.T) {
+	repository.TestAllVersions(t, testRepositoryIndexFormat)
+}
+
+func testRepositoryIndexFormat(t *testing.T, version uint) {
+	repo, _, _ := repository.TestRepositoryWithVersion(t, version)
+	saveRandomDataBlobs(t, repo, 10, 1<<15)
+
+	found := false
+	rtest.OK(t, repo.List(context.TODO(), restic.IndexFile, func(id restic.ID, _ int64) error {
+		buf, err := repo.LoadUnpacked(context.TODO(), restic.IndexFile, id)
+		rtest.OK(t, err)
+		// version 3 repositories use the binary index format
+		rtest.Equals(t, version >= 3, index.IsBinaryIndex(buf))
+		found = true
+		return nil
+	}))
+	rtest.Assert(t, found, "no index file found")
+}
+
 func TestInvalidCompression(t *testing.T) {
 	var comp repository.CompressionMode
 	err := comp.Set("nope")
@@ -449,6 +469,48 @@ func TestInvalidCompression(t *testing.T) {
 	rtest.Assert(t, err != nil, "missing error")
 }
 
+func TestInvalidIndexMode(t *testing.T) {
+	var mode repository.IndexMode
+	err := mode.Set("nope")
+	rtest.Assert(t, err != nil, "missing error")
+	_, err = repository.New(nil, repository.Options{IndexMode: mode})
+	rtest.Assert(t, err != nil, "missing error")
+}
+
+func TestRepositoryIndexModeMmap(t *testing.T) {
+	repo, be := repository.TestRepositoryWithBackend(t, nil, 0, repository.Options{})
+	saveRandomDataBlobs(t, repo, 20, 1<<15)
+	var blobs []restic.PackBlob
+	rtest.OK(t, repo.ListBlobs(context.TODO(), func(pb restic.PackBlob) {
+		blobs = append(blobs, pb)
+	}))
+
+	mmapRepo, err := repository.New(be, repository.Options{IndexMode: repository.IndexModeMmap})
+	rtest.OK(t, err)
+	rtest.OK(t, mmapRepo.SearchKey(context.TODO(), rtest.TestPassword, 1, ""))
+	err = mmapRepo.LoadIndex(context.TODO(), restic.NoopTerminalCounterFactory)
+	rtest.Assert(t, err != nil && strings.Contains(err.Error(), "requires a local cache"), "unexpected error %v", err)
+
+	mmapRepo.UseCache(cache.TestNewCache(t), t.Logf)
+	rtest.OK(t, mmapRepo.LoadIndex(context.TODO(), restic.NoopTerminalCounterFactory))
+	for _, pb := range blobs {
+		rtest.Equals(t, []restic.PackBlob{pb}, mmapRepo.LookupBlob(pb.Handle()))
+		size, found := mmapRepo.LookupBlobSize(pb.Handle())
+		rtest.Assert(t, found, "blob %v not found", pb.Handle())
+		rtest.Equals(t, pb.PlaintextLength(), size)
+	}
+
+	// blobs saved afterwards are found as well
+	saveRandomDataBlobs(t, mmapRepo, 5, 1<<15)
+	rtest.OK(t, mmapRepo.LoadIndex(context.TODO(), restic.NoopTerminalCounterFactory))
+	count := 0
+	rtest.OK(t, mmapRepo.ListBlobs(context.TODO(), func(restic.PackBlob) {
+		count++
+	}))
+	rtest.Equals(t, len(blobs)+5, count)
+	repository.TestCheckRepo(t, mmapRepo)
+}
+
 func TestListPack(t *testing.T) {
 	be := mem.New()
 	repo, _ := repository.TestRepositoryWithBackend(t, &damageOnceBackend{Backend: be}, restic.StableRepoVersion, repository.Options{})
@@ -572,3 +634,34 @@ func TestSaveBlobAsyncErrorHandling(t *testing.T) {
 	rtest.Assert(t, errors.Is(err, context.Canceled), "expected context canceled error, got %v", err)
 	rtest.Assert(t, callbackCalled.Load(), "callback was not called")
 }
+
+func TestCheckpoint(t *testing.T) {
+	repo, _, be := repository.TestRepositoryWithVersion(t, 2)
+	ctx := context.Background()
+
+	dataBlob := []byte("data blob before checkpoint")
+	treeBlob := []byte(`{"nodes":[]}`)
+	rtest.OK(t, repo.WithBlobUploader(ctx, func(ctx context.Context, uploader restic.BlobSaverWithAsync) error {
+		dataID, _, _, err := uploader.SaveBlob(ctx, restic.DataBlob, dataBlob, restic.ID{}, false)
+		rtest.OK(t, err)
+		treeID, _, _, err := uploader.SaveBlob(ctx, restic.TreeBlob, treeBlob, restic.ID{}, false)
+		rtest.OK(t, err)
+
+		checkpointer, ok := uploader.(restic.BlobCheckpointer)
+		rtest.Assert(t, ok, "uploader does not support checkpoints")
+		rtest.OK(t, checkpointer.Checkpoint(ctx))
+
+		// the blobs must be accessible via a second repository instance
+		repo2 := repository.TestOpenBackend(t, be)
+		rtest.OK(t, repo2.LoadIndex(ctx, restic.NoopTerminalCounterFactory))
+		for _, bh := range []restic.BlobHandle{{Type: restic.DataBlob, ID: dataID}, {Type: restic.TreeBlob, ID: treeID}} {
+			_, err := repo2.LoadBlob(ctx, bh, nil)
+			rtest.OK(t, err)
+		}
+
+		// saving blobs must continue to work
+		_, _, _, err = uploader.SaveBlob(ctx, restic.DataBlob, []byte("data blob after checkpoint"), restic.ID{}, false)
+		return err
+	}))
+	repository.TestCheckRepo(t, repo)
+}
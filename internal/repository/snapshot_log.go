@@ -0,0 +1,643 @@
+package repository
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/restic/restic/internal/backend/cache"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/ui/progress"
+	"golang.org/x/sync/errgroup"
+)
+
+// snapshotLogStateFile is the name of the cache state file which stores the
+// heads of the snapshot log last seen by this client.
+const snapshotLogStateFile = "snapshot-log.json"
+
+// SnapshotLogEntry records which snapshots were added to or removed from the
+// repository. The entries form a hash chain: each entry references the heads
+// of the log at the time it was written. As the entries are stored encrypted,
+// they cannot be forged without the repository key.
+type SnapshotLogEntry struct {
+	Parents  restic.IDs `json:"parents"`
+	Time     time.Time  `json:"time"`
+	Hostname string     `json:"hostname,omitempty"`
+	Added    restic.IDs `json:"added,omitempty"`
+	Removed  restic.IDs `json:"removed,omitempty"`
+	// AcceptedMissing lists missing log entries which were accepted by
+	// `repair snapshot-log` and are no longer reported.
+	AcceptedMissing restic.IDs `json:"accepted_missing,omitempty"`
+}
+
+// snapshotLog holds the entries of the snapshot log known to this repository
+// instance. The log is listed at most once, entries written or removed
+// afterwards are tracked directly.
+type snapshotLog struct {
+	m sync.Mutex
+	// ids is nil until the log was listed.
+	ids restic.IDSet
+	// entries is nil until the log was loaded.
+	entries map[restic.ID]*SnapshotLogEntry
+	// recordedRemovals are snapshots whose removal was already recorded by
+	// RecordSnapshotRemoval.
+	recordedRemovals restic.IDSet
+	// expected are the snapshots recorded by the log, which are compared with
+	// the snapshots once they are listed. It is nil if no comparison is
+	// pending.
+	expected restic.IDSet
+}
+
+type snapshotLogStateJSON struct {
+	Heads     restic.IDs `json:"heads"`
+	Entries   restic.IDs `json:"entries,omitempty"`
+	Snapshots restic.IDs `json:"snapshots,omitempty"`
+}
+
+// snapshotLogState is the state of the snapshot log last seen by this client.
+// If entries is not nil, it contains all entries of the log, none of which was
+// missing, and snapshots are the snapshots recorded by them. As long as the
+// log contains exactly these entries, it need not be loaded.
+type snapshotLogState struct {
+	heads     restic.IDSet
+	entries   restic.IDSet
+	snapshots restic.IDSet
+}
+
+// newSnapshotLogState returns the state for the complete log entries.
+func newSnapshotLogState(entries map[restic.ID]*SnapshotLogEntry) *snapshotLogState {
+	state := &snapshotLogState{
+		heads:     snapshotLogHeads(entries),
+		entries:   restic.NewIDSet(),
+		snapshots: replaySnapshotLog(entries),
+	}
+	for id := range entries {
+		state.entries.Insert(id)
+	}
+	return state
+}
+
+// loadSnapshotLogState returns the state of the snapshot log remembered in the
+// cache c. If c is nil or contains no state, a state without heads is returned.
+func loadSnapshotLogState(c *cache.Cache) (*snapshotLogState, error) {
+	state := &snapshotLogState{heads: restic.NewIDSet()}
+	if c == nil {
+		return state, nil
+	}
+
+	buf, err := c.LoadState(snapshotLogStateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var stateJSON snapshotLogStateJSON
+	if err := json.Unmarshal(buf, &stateJSON); err != nil {
+		return nil, errors.Wrap(err, "decoding snapshot log state")
+	}
+	state.heads = restic.NewIDSet(stateJSON.Heads...)
+	if stateJSON.Entries != nil {
+		state.entries = restic.NewIDSet(stateJSON.Entries...)
+		state.snapshots = restic.NewIDSet(stateJSON.Snapshots...)
+	}
+	return state, nil
+}
+
+func saveSnapshotLogState(c *cache.Cache, state *snapshotLogState) error {
+	if c == nil {
+		return nil
+	}
+	stateJSON := snapshotLogStateJSON{Heads: state.heads.List()}
+	if state.entries != nil {
+		stateJSON.Entries = state.entries.List()
+		stateJSON.Snapshots = state.snapshots.List()
+	}
+	buf, err := json.Marshal(stateJSON)
+	if err != nil {
+		return err
+	}
+	return c.SaveState(snapshotLogStateFile, buf)
+}
+
+// listSnapshotLog returns the IDs of all entries of the snapshot log. The
+// caller must hold r.snapshotLog.m and must not modify the returned set.
+func (r *Repository) listSnapshotLog(ctx context.Context) (restic.IDSet, error) {
+	if r.snapshotLog.ids != nil {
+		return r.snapshotLog.ids, nil
+	}
+
+	ids := restic.NewIDSet()
+	err := r.List(ctx, restic.SnapshotLogFile, func(id restic.ID, _ int64) error {
+		ids.Insert(id)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	r.snapshotLog.ids = ids
+	return ids, nil
+}
+
+// loadSnapshotLog returns all entries of the snapshot log. The caller must hold
+// r.snapshotLog.m and must not modify the returned map.
+func (r *Repository) loadSnapshotLog(ctx context.Context) (map[restic.ID]*SnapshotLogEntry, error) {
+	if r.snapshotLog.entries != nil {
+		return r.snapshotLog.entries, nil
+	}
+	ids, err := r.listSnapshotLog(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var m sync.Mutex
+	entries := make(map[restic.ID]*SnapshotLogEntry)
+	wg, wgCtx := errgroup.WithContext(ctx)
+	wg.SetLimit(int(r.Connections()))
+	for id := range ids {
+		wg.Go(func() error {
+			entry := &SnapshotLogEntry{}
+			err := restic.LoadJSONUnpacked(wgCtx, r, restic.SnapshotLogFile, id, entry)
+			if err != nil {
+				return errors.Wrapf(err, "loading snapshot log entry %v", id.Str())
+			}
+			m.Lock()
+			defer m.Unlock()
+			entries[id] = entry
+			return nil
+		})
+	}
+	if err := wg.Wait(); err != nil {
+		return nil, err
+	}
+	r.snapshotLog.entries = entries
+	return entries, nil
+}
+
+// snapshotLogHeads returns the entries which are not referenced by any other entry.
+func snapshotLogHeads(entries map[restic.ID]*SnapshotLogEntry) restic.IDSet {
+	heads := restic.NewIDSet()
+	for id := range entries {
+		heads.Insert(id)
+	}
+	for _, entry := range entries {
+		for _, parent := range entry.Parents {
+			heads.Delete(parent)
+		}
+	}
+	return heads
+}
+
+// snapshotLogParents returns the parents for the next log entry. These are the
+// heads of the log and remembered heads which no longer exist. The latter are
+// still referenced such that the log permanently shows that they are missing.
+func snapshotLogParents(entries map[restic.ID]*SnapshotLogEntry, remembered restic.IDSet) restic.IDSet {
+	parents := snapshotLogHeads(entries)
+	for id := range remembered {
+		if _, ok := entries[id]; !ok {
+			parents.Insert(id)
+		}
+	}
+	return parents
+}
+
+// replaySnapshotLog returns the snapshots which should exist according to the
+// log. Entries are applied after their parents, concurrently written entries
+// in the order of their timestamps.
+func replaySnapshotLog(entries map[restic.ID]*SnapshotLogEntry) restic.IDSet {
+	pending := make(map[restic.ID]int, len(entries))
+	children := make(map[restic.ID]restic.IDs)
+	var ready restic.IDs
+	for id, entry := range entries {
+		for _, parent := range entry.Parents {
+			if _, ok := entries[parent]; ok {
+				pending[id]++
+				children[parent] = append(children[parent], id)
+			}
+		}
+		if pending[id] == 0 {
+			ready = append(ready, id)
+		}
+	}
+
+	snapshots := restic.NewIDSet()
+	for len(ready) > 0 {
+		slices.SortFunc(ready, func(a, b restic.ID) int {
+			if c := entries[a].Time.Compare(entries[b].Time); c != 0 {
+				return c
+			}
+			return cmp.Compare(a.String(), b.String())
+		})
+		id := ready[0]
+		ready = ready[1:]
+
+		for _, sn := range entries[id].Added {
+			snapshots.Insert(sn)
+		}
+		for _, sn := range entries[id].Removed {
+			snapshots.Delete(sn)
+		}
+		for _, child := range children[id] {
+			pending[child]--
+			if pending[child] == 0 {
+				ready = append(ready, child)
+			}
+		}
+	}
+	return snapshots
+}
+
+// SnapshotLogStatus is the result of verifying the snapshot log.
+type SnapshotLogStatus struct {
+	// Active is false if the repository does not use a snapshot log.
+	Active bool
+	// Heads are the newest entries of the log.
+	Heads restic.IDs
+	// MissingEntries are log entries which are referenced by other entries or
+	// were seen by this client before, but no longer exist. This indicates
+	// that the repository was rolled back to an older state.
+	MissingEntries restic.IDs
+	// MissingSnapshots were recorded as added in the log, but no longer exist.
+	MissingSnapshots restic.IDs
+	// UnrecordedSnapshots exist in the repository but are not recorded in the
+	// log, for example because they were created by an older restic version.
+	UnrecordedSnapshots restic.IDs
+
+	// state is remembered by RememberSnapshotLog.
+	state *snapshotLogState
+}
+
+// OK returns true if neither log entries nor snapshots are missing.
+func (s *SnapshotLogStatus) OK() bool {
+	return len(s.MissingEntries) == 0 && len(s.MissingSnapshots) == 0
+}
+
+// VerifySnapshotLog compares the snapshot log with the heads of the log
+// remembered in the cache c, which may be nil. If snapshots is not nil, the log
+// is also compared with the listed snapshots. To not report concurrently added
+// or removed snapshots, the repository should be locked exclusively.
+func VerifySnapshotLog(ctx context.Context, repo *Repository, c *cache.Cache, snapshots restic.Lister) (*SnapshotLogStatus, error) {
+	remembered, err := loadSnapshotLogState(c)
+	if err != nil {
+		return nil, err
+	}
+
+	repo.snapshotLog.m.Lock()
+	entries, err := repo.loadSnapshotLog(ctx)
+	if err != nil {
+		repo.snapshotLog.m.Unlock()
+		return nil, err
+	}
+	state := newSnapshotLogState(entries)
+	status := &SnapshotLogStatus{
+		Active:         len(entries) > 0 || len(remembered.heads) > 0,
+		Heads:          state.heads.List(),
+		MissingEntries: missingSnapshotLogEntries(entries, remembered.heads).List(),
+		state:          state,
+	}
+	// listing the snapshots of the repository requires the lock
+	repo.snapshotLog.m.Unlock()
+
+	if snapshots != nil {
+		existing := restic.NewIDSet()
+		err = snapshots.List(ctx, restic.SnapshotFile, func(id restic.ID, _ int64) error {
+			existing.Insert(id)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		status.MissingSnapshots = state.snapshots.Sub(existing).List()
+		status.UnrecordedSnapshots = existing.Sub(state.snapshots).List()
+	}
+
+	debug.Log("snapshot log with %d entries, %d heads, %d missing entries, %d missing and %d unrecorded snapshots",
+		len(state.entries), len(status.Heads), len(status.MissingEntries), len(status.MissingSnapshots), len(status.UnrecordedSnapshots))
+	return status, nil
+}
+
+// missingSnapshotLogEntries returns the entries which are referenced as parent
+// or were remembered, but do not exist and were not accepted as missing.
+func missingSnapshotLogEntries(entries map[restic.ID]*SnapshotLogEntry, remembered restic.IDSet) restic.IDSet {
+	missing := remembered.Clone()
+	accepted := restic.NewIDSet()
+	for _, entry := range entries {
+		for _, parent := range entry.Parents {
+			missing.Insert(parent)
+		}
+		for _, id := range entry.AcceptedMissing {
+			accepted.Insert(id)
+		}
+	}
+	for id := range missing {
+		if _, ok := entries[id]; ok || accepted.Has(id) {
+			missing.Delete(id)
+		}
+	}
+	return missing
+}
+
+// CheckSnapshotLog verifies the snapshot log like VerifySnapshotLog without
+// listing the snapshots. The log entries are only loaded if the log was
+// modified since the state remembered in the cache c was saved. Once the
+// snapshots are listed, missing snapshots are reported using the function set
+// by SetWarnf.
+func CheckSnapshotLog(ctx context.Context, repo *Repository, c *cache.Cache) (*SnapshotLogStatus, error) {
+	remembered, err := loadSnapshotLogState(c)
+	if err != nil {
+		return nil, err
+	}
+
+	repo.snapshotLog.m.Lock()
+	ids, err := repo.listSnapshotLog(ctx)
+	repo.snapshotLog.m.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	var status *SnapshotLogStatus
+	if remembered.entries != nil && ids.Equals(remembered.entries) {
+		status = &SnapshotLogStatus{
+			Active: true,
+			Heads:  remembered.heads.List(),
+			state:  remembered,
+		}
+	} else {
+		status, err = VerifySnapshotLog(ctx, repo, c, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if status.Active {
+		repo.snapshotLog.m.Lock()
+		repo.snapshotLog.expected = status.state.snapshots.Clone()
+		repo.snapshotLog.m.Unlock()
+	}
+	return status, nil
+}
+
+// compareSnapshotLog reports the snapshots which are recorded by the log, but
+// missing in the listed snapshots. The comparison is only done once.
+func (r *Repository) compareSnapshotLog(listed restic.IDSet) {
+	r.snapshotLog.m.Lock()
+	expected := r.snapshotLog.expected
+	r.snapshotLog.expected = nil
+	r.snapshotLog.m.Unlock()
+	if expected == nil {
+		return
+	}
+
+	missing := expected.Sub(listed)
+	if len(missing) > 0 {
+		debug.Log("snapshots %v recorded in the snapshot log are missing", missing)
+		r.warnf("Warning: %d snapshots recorded in the snapshot log are missing, they may have been deleted without using restic. Run `restic check` for details.", len(missing))
+	}
+}
+
+// RememberSnapshotLog stores the state of the snapshot log in the cache c,
+// unless log entries are missing. Otherwise, the missing entries would no
+// longer be detected.
+func RememberSnapshotLog(c *cache.Cache, status *SnapshotLogStatus) error {
+	if !status.Active || len(status.MissingEntries) > 0 {
+		return nil
+	}
+	return saveSnapshotLogState(c, status.state)
+}
+
+// RepairSnapshotLog accepts the current state of the repository as reported by
+// VerifySnapshotLog. It writes a log entry which records missing and
+// unrecorded snapshots and accepts missing log entries. If the repository does
+// not use a snapshot log yet, this starts the log with all existing snapshots.
+func RepairSnapshotLog(ctx context.Context, repo *Repository, status *SnapshotLogStatus) (restic.ID, error) {
+	repo.snapshotLog.m.Lock()
+	defer repo.snapshotLog.m.Unlock()
+
+	entry := &SnapshotLogEntry{
+		Parents:         status.Heads,
+		Added:           status.UnrecordedSnapshots,
+		Removed:         status.MissingSnapshots,
+		AcceptedMissing: status.MissingEntries,
+	}
+	return repo.writeSnapshotLogEntry(ctx, entry)
+}
+
+// RecordSnapshotRemoval records the removal of the snapshots ids in a single
+// entry of the snapshot log, if the repository uses one. Afterwards,
+// RemoveUnpacked does not record the removal of these snapshots again.
+func (r *Repository) RecordSnapshotRemoval(ctx context.Context, ids restic.IDSet) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	if err := r.appendSnapshotLog(ctx, nil, ids.List()); err != nil {
+		return err
+	}
+
+	r.snapshotLog.m.Lock()
+	defer r.snapshotLog.m.Unlock()
+	if r.snapshotLog.recordedRemovals == nil {
+		r.snapshotLog.recordedRemovals = restic.NewIDSet()
+	}
+	r.snapshotLog.recordedRemovals.Merge(ids)
+	return nil
+}
+
+// removalRecorded returns true if the removal of snapshot id was recorded by
+// RecordSnapshotRemoval.
+func (r *Repository) removalRecorded(id restic.ID) bool {
+	r.snapshotLog.m.Lock()
+	defer r.snapshotLog.m.Unlock()
+	if !r.snapshotLog.recordedRemovals.Has(id) {
+		return false
+	}
+	r.snapshotLog.recordedRemovals.Delete(id)
+	return true
+}
+
+// appendSnapshotLog records added and removed snapshots in the snapshot log,
+// if the repository uses one.
+func (r *Repository) appendSnapshotLog(ctx context.Context, added, removed restic.IDs) error {
+	r.snapshotLog.m.Lock()
+	defer r.snapshotLog.m.Unlock()
+
+	remembered, err := loadSnapshotLogState(r.cache)
+	if err != nil {
+		return err
+	}
+	if r.snapshotLog.expected != nil {
+		r.snapshotLog.expected.Merge(restic.NewIDSet(added...))
+		r.snapshotLog.expected = r.snapshotLog.expected.Sub(restic.NewIDSet(removed...))
+	}
+	if r.snapshotLog.entries == nil && remembered.entries != nil &&
+		(r.snapshotLog.ids == nil || r.snapshotLog.ids.Equals(remembered.entries)) {
+		// Avoid listing or loading the log if it was not seen to change since
+		// the heads were remembered. Entries written concurrently by other
+		// clients remain heads until the log is loaded to write the next entry.
+		return r.appendSnapshotLogEntry(ctx, remembered, added, removed)
+	}
+
+	entries, err := r.loadSnapshotLog(ctx)
+	if err != nil {
+		return err
+	}
+	parents := snapshotLogParents(entries, remembered.heads)
+	if len(parents) == 0 {
+		// the repository does not use a snapshot log
+		return nil
+	}
+
+	entry := &SnapshotLogEntry{
+		Parents: parents.List(),
+		Added:   added,
+		Removed: removed,
+	}
+	_, err = r.writeSnapshotLogEntry(ctx, entry)
+	return err
+}
+
+// appendSnapshotLogEntry writes an entry whose parents are the heads of the
+// unchanged log described by state, without loading the log. The caller must
+// hold r.snapshotLog.m.
+func (r *Repository) appendSnapshotLogEntry(ctx context.Context, state *snapshotLogState, added, removed restic.IDs) error {
+	entry := &SnapshotLogEntry{
+		Parents: state.heads.List(),
+		Added:   added,
+		Removed: removed,
+	}
+	id, err := r.saveSnapshotLogEntry(ctx, entry)
+	if err != nil || r.dryRun {
+		return err
+	}
+
+	state = &snapshotLogState{
+		heads:     restic.NewIDSet(id),
+		entries:   state.entries.Clone(),
+		snapshots: state.snapshots.Clone(),
+	}
+	state.entries.Insert(id)
+	for _, sn := range added {
+		state.snapshots.Insert(sn)
+	}
+	for _, sn := range removed {
+		state.snapshots.Delete(sn)
+	}
+	return saveSnapshotLogState(r.cache, state)
+}
+
+// writeSnapshotLogEntry saves entry, which becomes the head of the log. The
+// caller must hold r.snapshotLog.m.
+func (r *Repository) writeSnapshotLogEntry(ctx context.Context, entry *SnapshotLogEntry) (restic.ID, error) {
+	id, err := r.saveSnapshotLogEntry(ctx, entry)
+	if err != nil {
+		return restic.ID{}, err
+	}
+	if r.snapshotLog.entries != nil {
+		r.snapshotLog.entries[id] = entry
+	}
+	if r.dryRun {
+		return id, nil
+	}
+
+	if r.snapshotLog.entries == nil {
+		return id, saveSnapshotLogState(r.cache, &snapshotLogState{heads: restic.NewIDSet(id)})
+	}
+	state := newSnapshotLogState(r.snapshotLog.entries)
+	if len(missingSnapshotLogEntries(r.snapshotLog.entries, restic.NewIDSet())) > 0 {
+		// the log must be loaded again to report the missing entries
+		state.entries, state.snapshots = nil, nil
+	}
+	return id, saveSnapshotLogState(r.cache, state)
+}
+
+// saveSnapshotLogEntry stores entry in the repository.
+func (r *Repository) saveSnapshotLogEntry(ctx context.Context, entry *SnapshotLogEntry) (restic.ID, error) {
+	entry.Time = time.Now()
+	entry.Hostname, _ = os.Hostname()
+
+	id, err := restic.SaveJSONUnpacked(ctx, &internalRepository{r}, restic.SnapshotLogFile, entry)
+	if err != nil {
+		return restic.ID{}, errors.Wrap(err, "saving snapshot log entry")
+	}
+	debug.Log("saved snapshot log entry %v", id)
+	if r.snapshotLog.ids != nil && !r.dryRun {
+		r.snapshotLog.ids.Insert(id)
+	}
+	return id, nil
+}
+
+// CompactSnapshotLog replaces the entries of the snapshot log by a single
+// entry, unless the log has at most one entry or entries are missing. The
+// repository must be locked exclusively.
+func CompactSnapshotLog(ctx context.Context, repo *Repository, printer progress.Printer) error {
+	status, err := VerifySnapshotLog(ctx, repo, repo.Cache(), nil)
+	if err != nil {
+		return err
+	}
+	if len(status.MissingEntries) > 0 {
+		// the entries referencing the missing ones are the only evidence
+		printer.E("not compacting the snapshot log, as %d entries are missing", len(status.MissingEntries))
+		return nil
+	}
+	if len(status.state.entries) <= 1 {
+		return nil
+	}
+
+	printer.P("compacting snapshot log with %d entries", len(status.state.entries))
+	return compactSnapshotLog(ctx, repo)
+}
+
+// compactSnapshotLog replaces the snapshot log with a single entry, which
+// records all existing snapshots and accepts the removed entries as missing.
+// Clients which remembered one of the removed entries thus do not report it.
+// Entries accepted by the removed entries are not accepted again, such that
+// the size of the log remains bounded.
+func compactSnapshotLog(ctx context.Context, repo *Repository) error {
+	repo.snapshotLog.m.Lock()
+	defer repo.snapshotLog.m.Unlock()
+
+	entries, err := repo.loadSnapshotLog(ctx)
+	if err != nil {
+		return err
+	}
+
+	existing := replaySnapshotLog(entries)
+	mentioned := restic.NewIDSet()
+	oldEntries := restic.NewIDSet()
+	for id, entry := range entries {
+		oldEntries.Insert(id)
+		for _, sn := range entry.Added {
+			mentioned.Insert(sn)
+		}
+	}
+
+	// the log is replayed in the order of the timestamps, thus the new entry
+	// is applied last even if some old entries cannot be removed
+	entry := &SnapshotLogEntry{
+		Added:           existing.List(),
+		Removed:         mentioned.Sub(existing).List(),
+		AcceptedMissing: oldEntries.List(),
+	}
+	if _, err := repo.writeSnapshotLogEntry(ctx, entry); err != nil {
+		return err
+	}
+
+	for id := range oldEntries {
+		if err := repo.removeUnpacked(ctx, restic.SnapshotLogFile, id); err != nil {
+			return errors.Wrapf(err, "removing snapshot log entry %v", id.Str())
+		}
+		delete(repo.snapshotLog.entries, id)
+		delete(repo.snapshotLog.ids, id)
+	}
+	if repo.dryRun {
+		return nil
+	}
+	return saveSnapshotLogState(repo.cache, newSnapshotLogState(repo.snapshotLog.entries))
+}
@@ -0,0 +1,264 @@
+package repository_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/backend/cache"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+	"github.com/restic/restic/internal/ui/progress"
+)
+
+func saveTestSnapshot(t *testing.T, repo *repository.Repository, content string) restic.ID {
+	id, err := repo.SaveUnpacked(context.TODO(), restic.WriteableSnapshotFile, []byte(content))
+	rtest.OK(t, err)
+	return id
+}
+
+func verifySnapshotLog(t *testing.T, repo *repository.Repository, c *cache.Cache) *repository.SnapshotLogStatus {
+	status, err := repository.VerifySnapshotLog(context.TODO(), repo, c, repo)
+	rtest.OK(t, err)
+	return status
+}
+
+func TestSnapshotLog(t *testing.T) {
+	repo, be := repository.TestRepositoryWithBackend(t, nil, 0, repository.Options{})
+	first := saveTestSnapshot(t, repo, "first")
+
+	// without a log, snapshots are not recorded
+	rtest.Equals(t, 0, len(listFiles(t, repo, restic.SnapshotLogFile)))
+	status := verifySnapshotLog(t, repo, nil)
+	rtest.Assert(t, !status.Active, "unexpected active snapshot log")
+	rtest.Equals(t, restic.IDs{first}, status.UnrecordedSnapshots)
+
+	_, err := repository.RepairSnapshotLog(context.TODO(), repo, status)
+	rtest.OK(t, err)
+
+	repo = repository.TestOpenBackend(t, be)
+	second := saveTestSnapshot(t, repo, "second")
+	rtest.OK(t, repo.RemoveUnpacked(context.TODO(), restic.WriteableSnapshotFile, first))
+	rtest.Equals(t, 3, len(listFiles(t, repo, restic.SnapshotLogFile)))
+
+	status = verifySnapshotLog(t, repo, nil)
+	rtest.Assert(t, status.Active && status.OK(), "unexpected status %+v", status)
+	rtest.Equals(t, 0, len(status.UnrecordedSnapshots))
+	rtest.Equals(t, 1, len(status.Heads))
+
+	// snapshots removed without recording them in the log are detected
+	rtest.OK(t, be.Remove(context.TODO(), backend.Handle{Type: backend.SnapshotFile, Name: second.String()}))
+	status = verifySnapshotLog(t, repo, nil)
+	rtest.Equals(t, restic.IDs{second}, status.MissingSnapshots)
+	rtest.Equals(t, 0, len(status.MissingEntries))
+
+	_, err = repository.RepairSnapshotLog(context.TODO(), repo, status)
+	rtest.OK(t, err)
+	status = verifySnapshotLog(t, repo, nil)
+	rtest.Assert(t, status.OK(), "unexpected status after repair %+v", status)
+}
+
+func TestSnapshotLogRollback(t *testing.T) {
+	repo, be := repository.TestRepositoryWithBackend(t, nil, 0, repository.Options{})
+	c, err := cache.New(repo.Config().ID, t.TempDir())
+	rtest.OK(t, err)
+	repo.UseCache(c, t.Logf)
+
+	_, err = repository.RepairSnapshotLog(context.TODO(), repo, verifySnapshotLog(t, repo, c))
+	rtest.OK(t, err)
+	before := listFiles(t, repo, restic.SnapshotLogFile)
+	sn := saveTestSnapshot(t, repo, "snapshot")
+	latest := listFiles(t, repo, restic.SnapshotLogFile).Sub(before).List()[0]
+
+	// roll back the repository to the state before the snapshot was created
+	for _, h := range []backend.Handle{
+		{Type: backend.SnapshotFile, Name: sn.String()},
+		{Type: backend.SnapshotLogFile, Name: latest.String()},
+	} {
+		rtest.OK(t, be.Remove(context.TODO(), h))
+	}
+
+	// only the state remembered in the cache reveals the rollback
+	repo = repository.TestOpenBackend(t, be)
+	status := verifySnapshotLog(t, repo, nil)
+	rtest.Assert(t, status.OK(), "unexpected status without cache %+v", status)
+	status = verifySnapshotLog(t, repo, c)
+	rtest.Equals(t, restic.IDs{latest}, status.MissingEntries)
+
+	// the missing entry remains referenced by new entries
+	repo.UseCache(c, t.Logf)
+	saveTestSnapshot(t, repo, "other")
+	repo = repository.TestOpenBackend(t, be)
+	status = verifySnapshotLog(t, repo, nil)
+	rtest.Equals(t, restic.IDs{latest}, status.MissingEntries)
+
+	_, err = repository.RepairSnapshotLog(context.TODO(), repo, verifySnapshotLog(t, repo, c))
+	rtest.OK(t, err)
+	status = verifySnapshotLog(t, repo, c)
+	rtest.Assert(t, status.OK(), "unexpected status after repair %+v", status)
+}
+
+func TestSnapshotLogRecordRemoval(t *testing.T) {
+	repo, _ := repository.TestRepositoryWithBackend(t, nil, 0, repository.Options{})
+	_, err := repository.RepairSnapshotLog(context.TODO(), repo, verifySnapshotLog(t, repo, nil))
+	rtest.OK(t, err)
+
+	ids := restic.NewIDSet()
+	for _, content := range []string{"first", "second", "third"} {
+		ids.Insert(saveTestSnapshot(t, repo, content))
+	}
+	entries := len(listFiles(t, repo, restic.SnapshotLogFile))
+
+	// the removals are recorded in a single entry
+	rtest.OK(t, repo.RecordSnapshotRemoval(context.TODO(), ids))
+	for id := range ids {
+		rtest.OK(t, repo.RemoveUnpacked(context.TODO(), restic.WriteableSnapshotFile, id))
+	}
+	rtest.Equals(t, entries+1, len(listFiles(t, repo, restic.SnapshotLogFile)))
+
+	status := verifySnapshotLog(t, repo, nil)
+	rtest.Assert(t, status.OK(), "unexpected status %+v", status)
+	rtest.Equals(t, 0, len(status.UnrecordedSnapshots))
+}
+
+func TestSnapshotLogCompact(t *testing.T) {
+	repo, be := repository.TestRepositoryWithBackend(t, nil, 0, repository.Options{})
+	c, err := cache.New(repo.Config().ID, t.TempDir())
+	rtest.OK(t, err)
+	repo.UseCache(c, t.Logf)
+
+	_, err = repository.RepairSnapshotLog(context.TODO(), repo, verifySnapshotLog(t, repo, c))
+	rtest.OK(t, err)
+	first := saveTestSnapshot(t, repo, "first")
+	saveTestSnapshot(t, repo, "second")
+	rtest.OK(t, repo.RemoveUnpacked(context.TODO(), restic.WriteableSnapshotFile, first))
+
+	// another client remembers the current heads of the log
+	other, err := cache.New(repo.Config().ID, t.TempDir())
+	rtest.OK(t, err)
+	rtest.OK(t, repository.RememberSnapshotLog(other, verifySnapshotLog(t, repo, other)))
+
+	repo = repository.TestOpenBackend(t, be)
+	repo.UseCache(c, t.Logf)
+	rtest.OK(t, repository.CompactSnapshotLog(context.TODO(), repo, progress.NewNoopPrinter()))
+	rtest.Equals(t, 1, len(listFiles(t, repo, restic.SnapshotLogFile)))
+
+	for _, c := range []*cache.Cache{c, other} {
+		repo = repository.TestOpenBackend(t, be)
+		status := verifySnapshotLog(t, repo, c)
+		rtest.Assert(t, status.OK(), "unexpected status %+v", status)
+		rtest.Equals(t, 0, len(status.UnrecordedSnapshots))
+	}
+
+	// the log remains intact after compacting it again
+	repo = repository.TestOpenBackend(t, be)
+	rtest.OK(t, repository.RememberSnapshotLog(other, verifySnapshotLog(t, repo, other)))
+	saveTestSnapshot(t, repo, "third")
+	compacted := listFiles(t, repo, restic.SnapshotLogFile)
+	rtest.OK(t, repository.CompactSnapshotLog(context.TODO(), repo, progress.NewNoopPrinter()))
+	rtest.Equals(t, 1, len(listFiles(t, repo, restic.SnapshotLogFile)))
+	status := verifySnapshotLog(t, repository.TestOpenBackend(t, be), other)
+	rtest.Assert(t, status.OK(), "unexpected status %+v", status)
+	rtest.Equals(t, 0, len(status.UnrecordedSnapshots))
+
+	// only the entries removed by the last compaction remain accepted
+	var entry repository.SnapshotLogEntry
+	rtest.OK(t, restic.LoadJSONUnpacked(context.TODO(), repo, restic.SnapshotLogFile, listFiles(t, repo, restic.SnapshotLogFile).List()[0], &entry))
+	rtest.Equals(t, compacted, restic.NewIDSet(entry.AcceptedMissing...))
+}
+
+// noListSnapshotLogBackend fails to list snapshot log entries.
+type noListSnapshotLogBackend struct {
+	backend.Backend
+}
+
+func (be *noListSnapshotLogBackend) List(ctx context.Context, t backend.FileType, fn func(backend.FileInfo) error) error {
+	if t == backend.SnapshotLogFile {
+		return errors.New("snapshot log not available")
+	}
+	return be.Backend.List(ctx, t, fn)
+}
+
+func TestSnapshotLogAppendRemembered(t *testing.T) {
+	repo, be := repository.TestRepositoryWithBackend(t, nil, 0, repository.Options{})
+	c, err := cache.New(repo.Config().ID, t.TempDir())
+	rtest.OK(t, err)
+	repo.UseCache(c, t.Logf)
+	_, err = repository.RepairSnapshotLog(context.TODO(), repo, verifySnapshotLog(t, repo, c))
+	rtest.OK(t, err)
+	saveTestSnapshot(t, repo, "first")
+
+	// the heads remembered in the cache are used without listing the log
+	repo = repository.TestOpenBackend(t, &noListSnapshotLogBackend{be})
+	repo.UseCache(c, t.Logf)
+	var warnings []string
+	repo.SetWarnf(func(msg string, args ...interface{}) {
+		warnings = append(warnings, fmt.Sprintf(msg, args...))
+	})
+	saveTestSnapshot(t, repo, "second")
+	saveTestSnapshot(t, repo, "third")
+	rtest.Equals(t, 0, len(warnings))
+
+	repo = repository.TestOpenBackend(t, be)
+	rtest.Equals(t, 4, len(listFiles(t, repo, restic.SnapshotLogFile)))
+	status := verifySnapshotLog(t, repo, c)
+	rtest.Assert(t, status.OK(), "unexpected status %+v", status)
+	rtest.Equals(t, 1, len(status.Heads))
+	rtest.Equals(t, 0, len(status.UnrecordedSnapshots))
+}
+
+// failSnapshotLogBackend fails to load and save snapshot log entries.
+type failSnapshotLogBackend struct {
+	backend.Backend
+}
+
+func (be *failSnapshotLogBackend) Load(ctx context.Context, h backend.Handle, length int, offset int64, fn func(rd io.Reader) error) error {
+	if h.Type == backend.SnapshotLogFile {
+		return errors.New("snapshot log not available")
+	}
+	return be.Backend.Load(ctx, h, length, offset, fn)
+}
+
+func (be *failSnapshotLogBackend) Save(ctx context.Context, h backend.Handle, rd backend.RewindReader) error {
+	if h.Type == backend.SnapshotLogFile {
+		return errors.New("snapshot log not available")
+	}
+	return be.Backend.Save(ctx, h, rd)
+}
+
+func TestCheckSnapshotLog(t *testing.T) {
+	repo, be := repository.TestRepositoryWithBackend(t, nil, 0, repository.Options{})
+	c, err := cache.New(repo.Config().ID, t.TempDir())
+	rtest.OK(t, err)
+	repo.UseCache(c, t.Logf)
+	_, err = repository.RepairSnapshotLog(context.TODO(), repo, verifySnapshotLog(t, repo, c))
+	rtest.OK(t, err)
+	first := saveTestSnapshot(t, repo, "first")
+	saveTestSnapshot(t, repo, "second")
+
+	// the unchanged log is not loaded again
+	repo = repository.TestOpenBackend(t, &failSnapshotLogBackend{be})
+	var warnings []string
+	repo.SetWarnf(func(msg string, args ...interface{}) {
+		warnings = append(warnings, fmt.Sprintf(msg, args...))
+	})
+	status, err := repository.CheckSnapshotLog(context.TODO(), repo, c)
+	rtest.OK(t, err)
+	rtest.Assert(t, status.Active && status.OK(), "unexpected status %+v", status)
+
+	// missing snapshots are reported once the snapshots are listed
+	rtest.OK(t, be.Remove(context.TODO(), backend.Handle{Type: backend.SnapshotFile, Name: first.String()}))
+	listFiles(t, repo, restic.SnapshotFile)
+	rtest.Equals(t, 1, len(warnings))
+	listFiles(t, repo, restic.SnapshotFile)
+	rtest.Equals(t, 1, len(warnings))
+
+	// a snapshot which cannot be recorded in the log is still saved
+	id := saveTestSnapshot(t, repo, "third")
+	rtest.Equals(t, 2, len(warnings))
+	rtest.Assert(t, listFiles(t, repo, restic.SnapshotFile).Has(id), "snapshot %v is missing", id)
+}
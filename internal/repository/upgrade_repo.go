@@ -8,16 +8,17 @@ import (
 
 	"github.com/restic/restic/internal/backend"
 	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/ui/progress"
 )
 
-type upgradeRepoV2Error struct {
+type upgradeRepoError struct {
 	UploadNewConfigError   error
 	ReuploadOldConfigError error
 
 	BackupFilePath string
 }
 
-func (err *upgradeRepoV2Error) Error() string {
+func (err *upgradeRepoError) Error() string {
 	if err.ReuploadOldConfigError != nil {
 		return fmt.Sprintf("error uploading config (%v), re-uploading old config filed failed as well (%v), but there is a backup of the config file in %v", err.UploadNewConfigError, err.ReuploadOldConfigError, err.BackupFilePath)
 	}
@@ -25,12 +26,14 @@ func (err *upgradeRepoV2Error) Error() string {
 	return fmt.Sprintf("error uploading config (%v), re-uploaded old config was successful, there is a backup of the config file in %v", err.UploadNewConfigError, err.BackupFilePath)
 }
 
-func (err *upgradeRepoV2Error) Unwrap() error {
+func (err *upgradeRepoError) Unwrap() error {
 	// consider the original upload error as the primary cause
 	return err.UploadNewConfigError
 }
 
-func upgradeRepository(ctx context.Context, repo *Repository) error {
+// replaceConfig overwrites the config file of the repository with cfg, which
+// is encrypted using the current master key.
+func replaceConfig(ctx context.Context, repo *Repository, cfg restic.Config) error {
 	h := backend.Handle{Type: backend.ConfigFile}
 
 	if !repo.be.Properties().HasAtomicReplace {
@@ -41,10 +44,6 @@ func upgradeRepository(ctx context.Context, repo *Repository) error {
 		}
 	}
 
-	// upgrade config
-	cfg := repo.Config()
-	cfg.Version = 2
-
 	err := restic.SaveConfig(ctx, &internalRepository{repo}, cfg)
 	if err != nil {
 		return fmt.Errorf("save new config file failed: %w", err)
@@ -57,8 +56,52 @@ func UpgradeRepo(ctx context.Context, repo *Repository) error {
 	if repo.Config().Version != 1 {
 		return fmt.Errorf("repository has version %v, only upgrades from version 1 are supported", repo.Config().Version)
 	}
+	return upgradeRepoVersion(ctx, repo, 2)
+}
+
+// UpgradeRepoV3 upgrades a repository from version 2 to version 3 and
+// rewrites all index files using the binary index format.
+func UpgradeRepoV3(ctx context.Context, repo *Repository, printer progress.Printer) error {
+	if repo.Config().Version != 2 {
+		return fmt.Errorf("repository has version %v, only upgrades from version 2 are supported", repo.Config().Version)
+	}
+
+	printer.P("loading indexes...\n")
+	err := repo.LoadIndex(ctx, restic.NoopTerminalCounterFactory)
+	if err != nil {
+		return err
+	}
+
+	err = upgradeRepoVersion(ctx, repo, 3)
+	if err != nil {
+		return err
+	}
+	// new index files must use the binary format
+	cfg := repo.Config()
+	cfg.Version = 3
+	repo.setConfig(cfg)
+
+	// the rewrite also removes the old index files, index files which were not
+	// rewritten due to an error remain readable
+	err = rewriteIndexFiles(ctx, repo, restic.NewIDSet(), nil, nil, printer)
+	// drop outdated in-memory index
+	repo.clearIndex()
+	return err
+}
+
+// upgradeRepoVersion sets the version in the config of the repository. The
+// original config file is restored if saving the new config fails.
+func upgradeRepoVersion(ctx context.Context, repo *Repository, version uint) error {
+	cfg := repo.Config()
+	cfg.Version = version
+	return replaceConfigWithBackup(ctx, repo, cfg, fmt.Sprintf("restic-migrate-upgrade-repo-v%d-", version))
+}
 
-	tempdir, err := os.MkdirTemp("", "restic-migrate-upgrade-repo-v2-")
+// replaceConfigWithBackup replaces the config file like replaceConfig. The
+// original config file is stored in a temporary directory whose name starts
+// with tempPrefix, and is restored if saving the new config fails.
+func replaceConfigWithBackup(ctx context.Context, repo *Repository, cfg restic.Config, tempPrefix string) error {
+	tempdir, err := os.MkdirTemp("", tempPrefix)
 	if err != nil {
 		return fmt.Errorf("create temp dir failed: %w", err)
 	}
@@ -77,12 +120,12 @@ func UpgradeRepo(ctx context.Context, repo *Repository) error {
 		return fmt.Errorf("write config file backup to %v failed: %w", tempdir, err)
 	}
 
-	// run the upgrade
-	err = upgradeRepository(ctx, repo)
+	// replace the config
+	err = replaceConfig(ctx, repo, cfg)
 	if err != nil {
 
 		// build an error we can return to the caller
-		repoError := &upgradeRepoV2Error{
+		repoError := &upgradeRepoError{
 			UploadNewConfigError: err,
 			BackupFilePath:       backupFileName,
 		}
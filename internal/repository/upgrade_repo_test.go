@@ -2,6 +2,7 @@ package repository
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"path/filepath"
 	"sync"
@@ -9,7 +10,10 @@ import (
 
 	"github.com/restic/restic/internal/backend"
 	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository/index"
+	"github.com/restic/restic/internal/restic"
 	rtest "github.com/restic/restic/internal/test"
+	"github.com/restic/restic/internal/ui/progress"
 )
 
 func TestUpgradeRepoV2(t *testing.T) {
@@ -65,7 +69,7 @@ func TestUpgradeRepoV2Failure(t *testing.T) {
 		t.Fatal("expected error returned from Apply(), got nil")
 	}
 
-	upgradeErr := err.(*upgradeRepoV2Error)
+	upgradeErr := err.(*upgradeRepoError)
 	if upgradeErr.UploadNewConfigError == nil {
 		t.Fatal("expected upload error, got nil")
 	}
@@ -80,3 +84,52 @@ func TestUpgradeRepoV2Failure(t *testing.T) {
 	rtest.OK(t, os.Remove(upgradeErr.BackupFilePath))
 	rtest.OK(t, os.Remove(filepath.Dir(upgradeErr.BackupFilePath)))
 }
+
+func TestUpgradeRepoV3(t *testing.T) {
+	repo, _, _ := TestRepositoryWithVersion(t, 2)
+
+	var blobs restic.IDs
+	rtest.OK(t, repo.WithBlobUploader(context.TODO(), func(ctx context.Context, uploader restic.BlobSaverWithAsync) error {
+		for i := 0; i < 10; i++ {
+			id, _, _, err := uploader.SaveBlob(ctx, restic.DataBlob, []byte(fmt.Sprintf("blob %d", i)), restic.ID{}, false)
+			rtest.OK(t, err)
+			blobs = append(blobs, id)
+		}
+		return nil
+	}))
+
+	listIndexFormats := func() (binary, json int) {
+		rtest.OK(t, repo.List(context.TODO(), restic.IndexFile, func(id restic.ID, _ int64) error {
+			buf, err := repo.LoadUnpacked(context.TODO(), restic.IndexFile, id)
+			rtest.OK(t, err)
+			if index.IsBinaryIndex(buf) {
+				binary++
+			} else {
+				json++
+			}
+			return nil
+		}))
+		return binary, json
+	}
+	binary, json := listIndexFormats()
+	rtest.Assert(t, binary == 0 && json > 0, "unexpected index files before upgrade: %d binary, %d json", binary, json)
+
+	rtest.OK(t, UpgradeRepoV3(context.TODO(), repo, progress.NewNoopPrinter()))
+
+	cfg, err := restic.LoadConfig(context.TODO(), repo)
+	rtest.OK(t, err)
+	rtest.Equals(t, uint(3), cfg.Version)
+	rtest.Equals(t, uint(3), repo.Config().Version)
+
+	binary, json = listIndexFormats()
+	rtest.Assert(t, binary > 0 && json == 0, "unexpected index files after upgrade: %d binary, %d json", binary, json)
+
+	rtest.OK(t, repo.LoadIndex(context.TODO(), restic.NoopTerminalCounterFactory))
+	for _, id := range blobs {
+		_, found := repo.LookupBlobSize(restic.BlobHandle{Type: restic.DataBlob, ID: id})
+		rtest.Assert(t, found, "blob %v missing from index", id.Str())
+	}
+
+	err = UpgradeRepoV3(context.TODO(), repo, progress.NewNoopPrinter())
+	rtest.Assert(t, err != nil, "upgrading a version 3 repository should fail")
+}
@@ -0,0 +1,164 @@
+package repository
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/restic/restic/internal/backend/cache"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// verificationStateFile is the name of the cache state file which records when
+// pack files were last verified.
+const verificationStateFile = "verified.json"
+
+// VerificationLedger records when each pack file was last read and verified
+// successfully by check. The ledger is stored in the local cache.
+type VerificationLedger struct {
+	c *cache.Cache
+
+	m        sync.Mutex
+	verified map[restic.ID]time.Time
+}
+
+type verificationLedgerJSON struct {
+	// Packs maps pack IDs to the unix time of their last verification.
+	Packs map[string]int64 `json:"packs"`
+}
+
+// LoadVerificationLedger returns the verification ledger stored in the cache c.
+// If the cache contains no ledger yet, an empty ledger is returned.
+func LoadVerificationLedger(c *cache.Cache) (*VerificationLedger, error) {
+	l := &VerificationLedger{
+		c:        c,
+		verified: make(map[restic.ID]time.Time),
+	}
+
+	buf, err := c.LoadState(verificationStateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var raw verificationLedgerJSON
+	if err := json.Unmarshal(buf, &raw); err != nil {
+		return nil, errors.Wrap(err, "decoding verification ledger")
+	}
+	for s, sec := range raw.Packs {
+		id, err := restic.ParseID(s)
+		if err != nil {
+			debug.Log("ignoring invalid pack ID %q in verification ledger", s)
+			continue
+		}
+		l.verified[id] = time.Unix(sec, 0)
+	}
+	return l, nil
+}
+
+// Save writes the ledger to the cache.
+func (l *VerificationLedger) Save() error {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	raw := verificationLedgerJSON{Packs: make(map[string]int64, len(l.verified))}
+	for id, t := range l.verified {
+		raw.Packs[id.String()] = t.Unix()
+	}
+	buf, err := json.Marshal(raw)
+	if err != nil {
+		return err
+	}
+	return l.c.SaveState(verificationStateFile, buf)
+}
+
+// Record marks the pack file id as verified at time t.
+func (l *VerificationLedger) Record(id restic.ID, t time.Time) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	l.verified[id] = t
+}
+
+// LastVerified returns the time at which the pack file id was last verified.
+func (l *VerificationLedger) LastVerified(id restic.ID) (time.Time, bool) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	t, ok := l.verified[id]
+	return t, ok
+}
+
+// Retain removes all pack files from the ledger which are not contained in packs.
+func (l *VerificationLedger) Retain(packs map[restic.ID]int64) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	for id := range l.verified {
+		if _, ok := packs[id]; !ok {
+			delete(l.verified, id)
+		}
+	}
+}
+
+// Stale returns the pack files which were never verified or were last
+// verified before cutoff.
+func (l *VerificationLedger) Stale(packs map[restic.ID]int64, cutoff time.Time) map[restic.ID]int64 {
+	l.m.Lock()
+	defer l.m.Unlock()
+	stale := make(map[restic.ID]int64)
+	for id, size := range packs {
+		t, ok := l.verified[id]
+		if !ok || t.Before(cutoff) {
+			stale[id] = size
+		}
+	}
+	return stale
+}
+
+// VerificationCoverage summarizes how long ago a set of pack files was verified.
+type VerificationCoverage struct {
+	// Packs is the number of pack files.
+	Packs int
+	// Unverified is the number of pack files which were never verified.
+	Unverified int
+	// Age contains the age of the last verification of the verified pack
+	// files at the percentiles passed to Coverage.
+	Age []time.Duration
+}
+
+// Coverage returns the age of the last verification of packs at the given
+// percentiles, relative to now. Pack files which were never verified are only
+// counted as unverified.
+func (l *VerificationLedger) Coverage(packs map[restic.ID]int64, now time.Time, percentiles []float64) VerificationCoverage {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	cov := VerificationCoverage{Packs: len(packs)}
+	ages := make([]time.Duration, 0, len(packs))
+	for id := range packs {
+		t, ok := l.verified[id]
+		if !ok {
+			cov.Unverified++
+			continue
+		}
+		ages = append(ages, max(now.Sub(t), 0))
+	}
+	if len(ages) == 0 {
+		return cov
+	}
+
+	slices.Sort(ages)
+	cov.Age = make([]time.Duration, 0, len(percentiles))
+	for _, p := range percentiles {
+		// nearest-rank method
+		rank := int(math.Ceil(p / 100 * float64(len(ages))))
+		rank = min(max(rank, 1), len(ages))
+		cov.Age = append(cov.Age, ages[rank-1])
+	}
+	return cov
+}
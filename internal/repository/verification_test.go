@@ -0,0 +1,53 @@
+package repository
+
+import (
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/backend/cache"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestVerificationLedger(t *testing.T) {
+	c := cache.TestNewCache(t)
+	ledger, err := LoadVerificationLedger(c)
+	rtest.OK(t, err)
+
+	now := time.Unix(1700000000, 0)
+	packs := make(map[restic.ID]int64)
+	var ids restic.IDs
+	for i := 0; i < 10; i++ {
+		id := restic.NewRandomID()
+		ids = append(ids, id)
+		packs[id] = int64(i)
+		// the last two packs are never verified
+		if i < 8 {
+			ledger.Record(id, now.Add(-time.Duration(i)*24*time.Hour))
+		}
+	}
+	removed := restic.NewRandomID()
+	ledger.Record(removed, now)
+
+	ledger.Retain(packs)
+	_, ok := ledger.LastVerified(removed)
+	rtest.Assert(t, !ok, "removed pack still contained in ledger")
+	rtest.OK(t, ledger.Save())
+
+	ledger, err = LoadVerificationLedger(c)
+	rtest.OK(t, err)
+	last, ok := ledger.LastVerified(ids[3])
+	rtest.Assert(t, ok, "pack missing from loaded ledger")
+	rtest.Equals(t, now.Add(-3*24*time.Hour).Unix(), last.Unix())
+
+	stale := ledger.Stale(packs, now.Add(-5*24*time.Hour-time.Hour))
+	rtest.Equals(t, map[restic.ID]int64{ids[6]: 6, ids[7]: 7, ids[8]: 8, ids[9]: 9}, stale)
+
+	cov := ledger.Coverage(packs, now, []float64{50, 90, 100})
+	rtest.Equals(t, 10, cov.Packs)
+	rtest.Equals(t, 2, cov.Unverified)
+	rtest.Equals(t, []time.Duration{3 * 24 * time.Hour, 7 * 24 * time.Hour, 7 * 24 * time.Hour}, cov.Age)
+
+	cov = ledger.Coverage(map[restic.ID]int64{ids[9]: 9}, now, []float64{50})
+	rtest.Equals(t, VerificationCoverage{Packs: 1, Unverified: 1}, cov)
+}
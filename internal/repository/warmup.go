@@ -2,11 +2,37 @@ package repository
 
 import (
 	"context"
+	"encoding/json"
+	"os"
+	"time"
 
 	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/restic"
 )
 
+// warmupStateFile is the name of the cache state file which records pending
+// warmup requests.
+const warmupStateFile = "warmup.json"
+
+// WarmupState records the pack files for which a warmup was requested but
+// which have not yet been confirmed to be warm.
+type WarmupState struct {
+	// Requested is the time at which the oldest pending warmup was requested.
+	Requested time.Time
+	// ReadyBy is the time by which all pending pack files are expected to be warm.
+	ReadyBy time.Time
+	// Packs contains the IDs of the pack files which are warming up.
+	Packs restic.IDSet
+}
+
+type warmupStateJSON struct {
+	Requested time.Time  `json:"requested"`
+	ReadyBy   time.Time  `json:"ready_by"`
+	Packs     restic.IDs `json:"packs"`
+}
+
 type warmupJob struct {
 	repo             *Repository
 	handlesWarmingUp []backend.Handle
@@ -17,23 +43,180 @@ func (job *warmupJob) HandleCount() int {
 	return len(job.handlesWarmingUp)
 }
 
+// Packs returns the IDs of the pack files that are currently warming up.
+func (job *warmupJob) Packs() restic.IDSet {
+	packs := restic.NewIDSet()
+	for _, h := range job.handlesWarmingUp {
+		id, err := restic.ParseID(h.Name)
+		if err == nil {
+			packs.Insert(id)
+		}
+	}
+	return packs
+}
+
 // Wait waits for all handles to be warm.
 func (job *warmupJob) Wait(ctx context.Context) error {
-	return job.repo.be.WarmupWait(ctx, job.handlesWarmingUp)
+	err := job.repo.be.WarmupWait(ctx, job.handlesWarmingUp)
+	if err != nil {
+		return err
+	}
+
+	err = job.repo.updateWarmupState(func(state *WarmupState) {
+		for id := range job.Packs() {
+			state.Packs.Delete(id)
+		}
+	})
+	if err != nil {
+		debug.Log("updating warmup state failed: %v", err)
+	}
+	return nil
 }
 
 // StartWarmup creates a new warmup job, requesting the backend to warmup the specified packs.
+// Packs which are warming up are recorded in the local cache, see LoadWarmupState.
+// Packs for which an earlier warmup request is still expected to be in progress
+// are not requested again, the job only waits for them.
 func (r *Repository) StartWarmup(ctx context.Context, packs restic.IDSet) (restic.WarmupJob, error) {
+	pending := r.pendingWarmups(packs)
+
 	handles := make([]backend.Handle, 0, len(packs))
 	for pack := range packs {
+		if pending.Has(pack) {
+			continue
+		}
 		handles = append(
 			handles,
 			backend.Handle{Type: restic.PackFile, Name: pack.String()},
 		)
 	}
 	handlesWarmingUp, err := r.be.Warmup(ctx, handles)
+	if err != nil {
+		return &warmupJob{repo: r, handlesWarmingUp: handlesWarmingUp}, err
+	}
+
+	if len(handlesWarmingUp) > 0 {
+		now := time.Now()
+		readyBy := now.Add(r.WarmupEstimate())
+		serr := r.updateWarmupState(func(state *WarmupState) {
+			if state.Requested.IsZero() {
+				state.Requested = now
+			}
+			if readyBy.After(state.ReadyBy) {
+				state.ReadyBy = readyBy
+			}
+			for _, h := range handlesWarmingUp {
+				id, err := restic.ParseID(h.Name)
+				if err == nil {
+					state.Packs.Insert(id)
+				}
+			}
+		})
+		if serr != nil {
+			debug.Log("updating warmup state failed: %v", serr)
+		}
+	}
+
+	for pack := range pending {
+		handlesWarmingUp = append(handlesWarmingUp, backend.Handle{Type: restic.PackFile, Name: pack.String()})
+	}
+	debug.Log("%d packs warming up, %d of them requested earlier", len(handlesWarmingUp), len(pending))
+
 	return &warmupJob{
 		repo:             r,
 		handlesWarmingUp: handlesWarmingUp,
-	}, err
+	}, nil
+}
+
+// pendingWarmups returns the packs for which a warmup was requested earlier
+// and which are not yet expected to be warm according to the warmup state.
+func (r *Repository) pendingWarmups(packs restic.IDSet) restic.IDSet {
+	pending := restic.NewIDSet()
+	state, err := r.LoadWarmupState()
+	if err != nil {
+		debug.Log("ignoring invalid warmup state: %v", err)
+		return pending
+	}
+	// once the estimated time has passed, the pack may have become cold
+	// again or the request may have failed, thus request it again
+	if state == nil || !time.Now().Before(state.ReadyBy) {
+		return pending
+	}
+	for pack := range packs {
+		if state.Packs.Has(pack) {
+			pending.Insert(pack)
+		}
+	}
+	return pending
+}
+
+// WarmupEstimate returns an upper bound for the time the backend needs to warm
+// up pack files. Zero is returned if the backend cannot provide an estimate.
+func (r *Repository) WarmupEstimate() time.Duration {
+	be := backend.AsBackend[backend.WarmupEstimator](r.be)
+	if be == nil {
+		return 0
+	}
+	return be.WarmupEstimate()
+}
+
+// LoadWarmupState returns the pending warmup requests recorded in the local
+// cache. If there are none or no cache is used, nil is returned.
+func (r *Repository) LoadWarmupState() (*WarmupState, error) {
+	if r.cache == nil {
+		return nil, nil
+	}
+
+	buf, err := r.cache.LoadState(warmupStateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var raw warmupStateJSON
+	if err := json.Unmarshal(buf, &raw); err != nil {
+		return nil, errors.Wrap(err, "decoding warmup state")
+	}
+	return &WarmupState{
+		Requested: raw.Requested,
+		ReadyBy:   raw.ReadyBy,
+		Packs:     restic.NewIDSet(raw.Packs...),
+	}, nil
+}
+
+// updateWarmupState modifies the warmup state using fn. The state file is
+// removed once no pack file is warming up anymore.
+func (r *Repository) updateWarmupState(fn func(state *WarmupState)) error {
+	if r.cache == nil {
+		return nil
+	}
+
+	r.warmupStateMu.Lock()
+	defer r.warmupStateMu.Unlock()
+
+	state, err := r.LoadWarmupState()
+	if err != nil {
+		debug.Log("discarding invalid warmup state: %v", err)
+		state = nil
+	}
+	if state == nil {
+		state = &WarmupState{Packs: restic.NewIDSet()}
+	}
+
+	fn(state)
+
+	if len(state.Packs) == 0 {
+		return r.cache.RemoveState(warmupStateFile)
+	}
+	buf, err := json.Marshal(warmupStateJSON{
+		Requested: state.Requested,
+		ReadyBy:   state.ReadyBy,
+		Packs:     state.Packs.List(),
+	})
+	if err != nil {
+		return err
+	}
+	return r.cache.SaveState(warmupStateFile, buf)
 }
@@ -3,10 +3,13 @@ package repository
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/backend/cache"
 	"github.com/restic/restic/internal/backend/mock"
 	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
 )
 
 func TestWarmupRepository(t *testing.T) {
@@ -71,3 +74,79 @@ func TestWarmupRepository(t *testing.T) {
 		t.Fatalf("expected warmupWait to be called with %d handles, got %d", 1, len(warmupWaitCalls[0]))
 	}
 }
+
+func TestWarmupState(t *testing.T) {
+	be := mock.NewBackend()
+	be.WarmupFn = func(ctx context.Context, handles []backend.Handle) ([]backend.Handle, error) {
+		return handles, nil
+	}
+	be.WarmupWaitFn = func(ctx context.Context, handles []backend.Handle) error {
+		return nil
+	}
+
+	repo, _ := New(be, Options{})
+	repo.UseCache(cache.TestNewCache(t), t.Logf)
+
+	id1, _ := restic.ParseID("1111111111111111111111111111111111111111111111111111111111111111")
+	id2, _ := restic.ParseID("2222222222222222222222222222222222222222222222222222222222222222")
+
+	state, err := repo.LoadWarmupState()
+	rtest.OK(t, err)
+	rtest.Assert(t, state == nil, "unexpected warmup state %v", state)
+
+	job1, err := repo.StartWarmup(context.TODO(), restic.NewIDSet(id1))
+	rtest.OK(t, err)
+	job2, err := repo.StartWarmup(context.TODO(), restic.NewIDSet(id2))
+	rtest.OK(t, err)
+
+	state, err = repo.LoadWarmupState()
+	rtest.OK(t, err)
+	rtest.Equals(t, restic.NewIDSet(id1, id2), state.Packs)
+	rtest.Assert(t, !state.Requested.IsZero(), "missing request timestamp")
+
+	rtest.OK(t, job1.Wait(context.TODO()))
+	state, err = repo.LoadWarmupState()
+	rtest.OK(t, err)
+	rtest.Equals(t, restic.NewIDSet(id2), state.Packs)
+
+	// the state is removed once all pack files are warm
+	rtest.OK(t, job2.Wait(context.TODO()))
+	state, err = repo.LoadWarmupState()
+	rtest.OK(t, err)
+	rtest.Assert(t, state == nil, "unexpected warmup state %v", state)
+}
+
+func TestWarmupSkipsPendingPacks(t *testing.T) {
+	var warmupCalls [][]backend.Handle
+	be := mock.NewBackend()
+	be.WarmupFn = func(ctx context.Context, handles []backend.Handle) ([]backend.Handle, error) {
+		warmupCalls = append(warmupCalls, handles)
+		return handles, nil
+	}
+
+	repo, _ := New(be, Options{})
+	repo.UseCache(cache.TestNewCache(t), t.Logf)
+
+	id1, _ := restic.ParseID("1111111111111111111111111111111111111111111111111111111111111111")
+	id2, _ := restic.ParseID("2222222222222222222222222222222222222222222222222222222222222222")
+
+	// simulate a warmup requested by an earlier run
+	rtest.OK(t, repo.updateWarmupState(func(state *WarmupState) {
+		state.Requested = time.Now()
+		state.ReadyBy = time.Now().Add(time.Hour)
+		state.Packs.Insert(id1)
+	}))
+
+	job, err := repo.StartWarmup(context.TODO(), restic.NewIDSet(id1, id2))
+	rtest.OK(t, err)
+	rtest.Equals(t, []backend.Handle{{Type: restic.PackFile, Name: id2.String()}}, warmupCalls[0])
+	rtest.Equals(t, restic.NewIDSet(id1, id2), job.Packs())
+
+	// the packs are requested again once they are expected to be warm
+	rtest.OK(t, repo.updateWarmupState(func(state *WarmupState) {
+		state.ReadyBy = time.Now().Add(-time.Hour)
+	}))
+	_, err = repo.StartWarmup(context.TODO(), restic.NewIDSet(id1, id2))
+	rtest.OK(t, err)
+	rtest.Equals(t, 2, len(warmupCalls[1]))
+}
@@ -17,15 +17,27 @@ type Config struct {
 	Version           uint        `json:"version"`
 	ID                string      `json:"id"`
 	ChunkerPolynomial chunker.Pol `json:"chunker_polynomial"`
+	// Tiered is set if data pack files are stored in a separate cold
+	// repository, which must be specified to access the repository. Requires
+	// at least MinTieredRepoVersion.
+	Tiered bool `json:"tiered,omitempty"`
+	// ParityRedundancy is the size of the recovery data stored for new pack
+	// files in percent of the pack size. Zero disables recovery data.
+	ParityRedundancy uint `json:"parity_redundancy,omitempty"`
 }
 
 const MinRepoVersion = 1
-const MaxRepoVersion = 2
+const MaxRepoVersion = 3
 
 // StableRepoVersion is the version that is written to the config when a repository
 // is newly created with Init().
 const StableRepoVersion = 2
 
+// MinTieredRepoVersion is the lowest repository version which can store data
+// pack files in a cold repository. Clients which are unaware of tiered storage
+// reject such repositories instead of ignoring the cold repository.
+const MinTieredRepoVersion = 3
+
 // CreateConfig creates a config file with a randomly selected polynomial and
 // ID.
 func CreateConfig(version uint) (Config, error) {
@@ -72,6 +84,9 @@ func LoadConfig(ctx context.Context, r LoaderUnpacked) (Config, error) {
 	if cfg.Version < MinRepoVersion || cfg.Version > MaxRepoVersion {
 		return Config{}, errors.Errorf("unsupported repository version %v", cfg.Version)
 	}
+	if cfg.Tiered && cfg.Version < MinTieredRepoVersion {
+		return Config{}, errors.Errorf("tiered storage is not supported by repository version %v", cfg.Version)
+	}
 
 	if checkPolynomial {
 		if !cfg.ChunkerPolynomial.Irreducible() {
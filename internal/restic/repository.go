@@ -66,12 +66,15 @@ type FileType = backend.FileType
 // in the `WriteableFileType` subset can be modified via the Repository interface.
 // All other filetypes are considered internal datastructures of the Repository.
 const (
-	PackFile     = backend.PackFile
-	KeyFile      = backend.KeyFile
-	LockFile     = backend.LockFile
-	SnapshotFile = backend.SnapshotFile
-	IndexFile    = backend.IndexFile
-	ConfigFile   = backend.ConfigFile
+	PackFile        = backend.PackFile
+	KeyFile         = backend.KeyFile
+	LockFile        = backend.LockFile
+	SnapshotFile    = backend.SnapshotFile
+	IndexFile       = backend.IndexFile
+	ConfigFile      = backend.ConfigFile
+	ParityFile      = backend.ParityFile
+	SnapshotLogFile = backend.SnapshotLogFile
+	RekeyFile       = backend.RekeyFile
 )
 
 // WriteableFileType defines the different data types that can be modified via SaveUnpacked or RemoveUnpacked.
@@ -160,6 +163,37 @@ type BlobSaverWithAsync interface {
 	BlobSaverAsync
 }
 
+// CompressionHint overrides the detection of incompressible data blobs.
+type CompressionHint uint8
+
+const (
+	// CompressionHintAuto stores data blobs uncompressed if they appear to be
+	// incompressible.
+	CompressionHintAuto CompressionHint = iota
+	// CompressionHintSkip stores data blobs uncompressed.
+	CompressionHintSkip
+	// CompressionHintForce compresses data blobs without checking whether
+	// they are compressible.
+	CompressionHintForce
+)
+
+// BlobSaverWithHint is implemented by blob savers which accept a compression
+// hint for data blobs.
+type BlobSaverWithHint interface {
+	// SaveBlobAsyncWithHint works like SaveBlobAsync. The callback additionally
+	// reports whether the blob was stored uncompressed although compression is
+	// enabled.
+	SaveBlobAsyncWithHint(ctx context.Context, tpe BlobType, buf []byte, id ID, storeDuplicate bool, hint CompressionHint, cb func(newID ID, known bool, sizeInRepo int, skippedCompression bool, err error))
+}
+
+// BlobCheckpointer is implemented by blob savers which can persist all blobs
+// saved so far while continuing to accept new blobs.
+type BlobCheckpointer interface {
+	// Checkpoint uploads all blobs that were saved before the call and adds
+	// them to the repository index.
+	Checkpoint(ctx context.Context) error
+}
+
 type BlobSaver interface {
 	// SaveBlob saves a blob to the repository. ctx must be derived from the context created by WithBlobUploader.
 	SaveBlob(ctx context.Context, tpe BlobType, buf []byte, id ID, storeDuplicate bool) (newID ID, known bool, sizeInRepo int, err error)
@@ -181,6 +215,8 @@ type Loader interface {
 type WarmupJob interface {
 	// HandleCount returns the number of handles that are currently warming up.
 	HandleCount() int
+	// Packs returns the IDs of the pack files that are currently warming up.
+	Packs() IDSet
 	// Wait waits for all handles to be warm.
 	Wait(ctx context.Context) error
 }
@@ -19,3 +19,20 @@ func ZeroPrefixLen(p []byte) (n int) {
 
 	return n
 }
+
+// ZeroSuffixLen returns the length of the longest all-zero suffix of p.
+func ZeroSuffixLen(p []byte) (n int) {
+	var zeros [1024]byte
+
+	for len(p) >= len(zeros) && bytes.Equal(p[len(p)-len(zeros):], zeros[:]) {
+		p = p[:len(p)-len(zeros)]
+		n += len(zeros)
+	}
+
+	for len(p) > 0 && p[len(p)-1] == 0 {
+		p = p[:len(p)-1]
+		n++
+	}
+
+	return n
+}
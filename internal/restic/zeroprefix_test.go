@@ -24,6 +24,23 @@ func TestZeroPrefixLen(t *testing.T) {
 	}
 }
 
+func TestZeroSuffixLen(t *testing.T) {
+	var buf [2048]byte
+
+	// test zero suffixes of various lengths
+	for i := 0; i < len(buf); i++ {
+		buf[i] = 42
+		skipped := restic.ZeroSuffixLen(buf[:])
+		test.Equals(t, len(buf)-i-1, skipped)
+	}
+	// test buffers of various sizes
+	for i := 0; i < len(buf); i++ {
+		skipped := restic.ZeroSuffixLen(buf[:i])
+		test.Equals(t, 0, skipped)
+	}
+	test.Equals(t, 100, restic.ZeroSuffixLen(make([]byte, 100)))
+}
+
 func BenchmarkZeroPrefixLen(b *testing.B) {
 	var (
 		buf        [4<<20 + 37]byte
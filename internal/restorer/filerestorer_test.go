@@ -86,6 +86,10 @@ func (job *TestWarmupJob) HandleCount() int {
 	return job.handlesCount
 }
 
+func (job *TestWarmupJob) Packs() restic.IDSet {
+	return restic.NewIDSet()
+}
+
 func (job *TestWarmupJob) Wait(_ context.Context) error {
 	job.waitCalled = true
 	return nil
@@ -202,7 +206,7 @@ func newTestRepo(content []TestFile) *TestRepo {
 }
 
 func restoreAndVerify(t *testing.T, tempdir string, content []TestFile, files map[string]bool, sparse bool) {
-	defer feature.TestSetFlag(t, feature.Flag, feature.S3Restore, true)()
+	defer feature.TestSetFlag(t, feature.Flag, feature.ColdStorageRestore, true)()
 
 	t.Helper()
 	repo := newTestRepo(content)
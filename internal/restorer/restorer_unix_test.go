@@ -3,6 +3,7 @@
 package restorer
 
 import (
+	"bytes"
 	"context"
 	"io/fs"
 	"os"
@@ -11,6 +12,8 @@ import (
 	"testing"
 	"time"
 
+	"github.com/restic/restic/internal/archiver"
+	resticfs "github.com/restic/restic/internal/fs"
 	"github.com/restic/restic/internal/repository"
 	rtest "github.com/restic/restic/internal/test"
 	"github.com/restic/restic/internal/ui/progress"
@@ -142,3 +145,44 @@ func TestRestorePermissions(t *testing.T) {
 		rtest.Equals(t, fs.FileMode(0o600), fi.Mode().Perm(), "unexpected permissions")
 	}
 }
+
+func TestRestorerSparseHoles(t *testing.T) {
+	repo := repository.TestRepository(t)
+
+	// data, a large hole and data again
+	srcdir := rtest.TempDir(t)
+	data := rtest.Random(23, 100*1000)
+	const hole = 16 * 1024 * 1024
+	f, err := os.Create(filepath.Join(srcdir, "sparse"))
+	rtest.OK(t, err)
+	_, err = f.Write(data)
+	rtest.OK(t, err)
+	_, err = f.WriteAt(data, int64(len(data)+hole))
+	rtest.OK(t, err)
+	rtest.OK(t, f.Close())
+
+	back := rtest.Chdir(t, srcdir)
+	defer back()
+	arch := archiver.New(repo, resticfs.NewLocal(), archiver.Options{})
+	sn, _, _, err := arch.Snapshot(context.TODO(), []string{"sparse"}, archiver.SnapshotOptions{Time: time.Now()})
+	rtest.OK(t, err)
+
+	res := NewRestorer(repo, sn, Options{Sparse: true})
+	tempdir := rtest.TempDir(t)
+	_, err = res.RestoreTo(context.TODO(), tempdir)
+	rtest.OK(t, err)
+
+	filename := filepath.Join(tempdir, "sparse")
+	content, err := os.ReadFile(filename)
+	rtest.OK(t, err)
+	want := append(append(bytes.Clone(data), make([]byte, hole)...), data...)
+	rtest.Assert(t, bytes.Equal(want, content), "restored content differs")
+
+	// st.Blocks is the size in 512-byte blocks. Whether holes are created
+	// depends on the filesystem, only check that they are not filled.
+	blocks := getBlockCount(t, filename)
+	t.Logf("restored %d bytes as %d blocks", len(content), blocks)
+	if blocks > 0 && getBlockCount(t, filepath.Join(srcdir, "sparse")) < int64(len(content))/512/2 {
+		rtest.Assert(t, blocks < int64(len(content))/512/2, "hole was not restored, %d blocks allocated", blocks)
+	}
+}
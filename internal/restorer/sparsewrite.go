@@ -13,11 +13,14 @@ func (f *partialFile) WriteAt(p []byte, offset int64) (n int, err error) {
 
 	n = len(p)
 
-	// Skip the longest all-zero prefix of p.
-	// If it's long enough, we can punch a hole in the file.
+	// Skip the longest all-zero prefix and suffix of p.
+	// If they're long enough, we can punch a hole in the file.
 	skipped := restic.ZeroPrefixLen(p)
 	p = p[skipped:]
 	offset += int64(skipped)
+	trailing := restic.ZeroSuffixLen(p)
+	p = p[:len(p)-trailing]
+	skipped += trailing
 
 	switch {
 	case len(p) == 0:
@@ -86,6 +86,26 @@ func (b *jsonProgress) Error(item string, err error) error {
 	return nil
 }
 
+// FileChanged reports files which were modified while reading them.
+func (b *jsonProgress) FileChanged(item string, attempt uint, action archiver.FileChangeMode) {
+	b.error(fileChangedUpdate{
+		MessageType: "file_changed",
+		Item:        item,
+		Attempt:     attempt,
+		Action:      action.String(),
+	})
+}
+
+// HookOutput reports a line of output of a backup hook.
+func (b *jsonProgress) HookOutput(hook, stream, line string) {
+	b.print(hookOutput{
+		MessageType: "hook_output",
+		Hook:        hook,
+		Stream:      stream,
+		Line:        line,
+	})
+}
+
 // CompleteItem is the status callback function for the archiver when a
 // file/dir has been saved successfully.
 func (b *jsonProgress) CompleteItem(messageType, item string, s archiver.ItemStats, d time.Duration) {
@@ -181,6 +201,7 @@ func (b *jsonProgress) Finish(snapshotID restic.ID, summary *archiver.Summary, d
 		TreeBlobs:           summary.ItemStats.TreeBlobs,
 		DataAdded:           summary.ItemStats.DataSize + summary.ItemStats.TreeSize,
 		DataAddedPacked:     summary.ItemStats.DataSizeInRepo + summary.ItemStats.TreeSizeInRepo,
+		DataNotCompressed:   summary.CompressionSkippedBytes,
 		TotalFilesProcessed: summary.Files.New + summary.Files.Changed + summary.Files.Unchanged,
 		TotalBytesProcessed: summary.ProcessedBytes,
 		BackupStart:         summary.BackupStart,
@@ -219,6 +240,20 @@ type errorUpdate struct {
 	Item        string      `json:"item"`
 }
 
+type fileChangedUpdate struct {
+	MessageType string `json:"message_type"` // "file_changed"
+	Item        string `json:"item"`
+	Attempt     uint   `json:"attempt"`
+	Action      string `json:"action"`
+}
+
+type hookOutput struct {
+	MessageType string `json:"message_type"` // "hook_output"
+	Hook        string `json:"hook"`
+	Stream      string `json:"stream"`
+	Line        string `json:"line"`
+}
+
 type verboseUpdate struct {
 	MessageType        string  `json:"message_type"` // "verbose_status"
 	Action             string  `json:"action"`
@@ -243,6 +278,7 @@ type summaryOutput struct {
 	TreeBlobs           int       `json:"tree_blobs"`
 	DataAdded           uint64    `json:"data_added"`
 	DataAddedPacked     uint64    `json:"data_added_packed"`
+	DataNotCompressed   uint64    `json:"data_not_compressed,omitempty"`
 	TotalFilesProcessed uint      `json:"total_files_processed"`
 	TotalBytesProcessed uint64    `json:"total_bytes_processed"`
 	TotalDuration       float64   `json:"total_duration"` // in seconds
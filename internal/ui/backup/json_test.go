@@ -3,6 +3,7 @@ package backup
 import (
 	"testing"
 
+	"github.com/restic/restic/internal/archiver"
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/test"
 	"github.com/restic/restic/internal/ui"
@@ -25,3 +26,15 @@ func TestJSONScannerError(t *testing.T) {
 	test.Equals(t, printer.ScannerError("/path", errors.New("error \"message\"")), nil)
 	test.Equals(t, []string{"{\"message_type\":\"error\",\"error\":{\"message\":\"error \\\"message\\\"\"},\"during\":\"scan\",\"item\":\"/path\"}\n"}, term.Errors)
 }
+
+func TestJSONFileChanged(t *testing.T) {
+	term, printer := createJSONProgress()
+	printer.FileChanged("/path", 2, archiver.FileChangeWarn)
+	test.Equals(t, []string{"{\"message_type\":\"file_changed\",\"item\":\"/path\",\"attempt\":2,\"action\":\"warn\"}\n"}, term.Errors)
+}
+
+func TestJSONHookOutput(t *testing.T) {
+	term, printer := createJSONProgress()
+	printer.HookOutput("pre-hook", "stdout", "flushed \"db\"")
+	test.Equals(t, []string{"{\"message_type\":\"hook_output\",\"hook\":\"pre-hook\",\"stream\":\"stdout\",\"line\":\"flushed \\\"db\\\"\"}\n"}, term.Output)
+}
@@ -16,6 +16,8 @@ type ProgressPrinter interface {
 	Update(total, processed Counter, errors uint, currentFiles map[string]struct{}, start time.Time, secs uint64)
 	Error(item string, err error) error
 	ScannerError(item string, err error) error
+	FileChanged(item string, attempt uint, action archiver.FileChangeMode)
+	HookOutput(hook, stream, line string)
 	CompleteItem(messageType string, item string, s archiver.ItemStats, d time.Duration)
 	ReportTotal(start time.Time, s archiver.ScanStats)
 	Finish(snapshotID restic.ID, summary *archiver.Summary, dryRun bool)
@@ -94,6 +96,11 @@ func (p *Progress) Error(item string, err error) error {
 	return p.printer.Error(item, err)
 }
 
+// FileChanged is called when a file was modified while reading it.
+func (p *Progress) FileChanged(item string, attempt uint, action archiver.FileChangeMode) {
+	p.printer.FileChanged(item, attempt, action)
+}
+
 // StartFile is called when a file is being processed by a worker.
 func (p *Progress) StartFile(filename string) {
 	p.mu.Lock()
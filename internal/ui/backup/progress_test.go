@@ -20,8 +20,10 @@ type mockPrinter struct {
 
 func (p *mockPrinter) Update(_, _ Counter, _ uint, _ map[string]struct{}, _ time.Time, _ uint64) {
 }
-func (p *mockPrinter) Error(_ string, err error) error        { return err }
-func (p *mockPrinter) ScannerError(_ string, err error) error { return err }
+func (p *mockPrinter) Error(_ string, err error) error                         { return err }
+func (p *mockPrinter) ScannerError(_ string, err error) error                  { return err }
+func (p *mockPrinter) FileChanged(_ string, _ uint, _ archiver.FileChangeMode) {}
+func (p *mockPrinter) HookOutput(_, _, _ string)                               {}
 
 func (p *mockPrinter) CompleteItem(messageType string, _ string, _ archiver.ItemStats, _ time.Duration) {
 	p.Lock()
@@ -87,6 +87,29 @@ func (b *textProgress) Error(_ string, err error) error {
 	return nil
 }
 
+// FileChanged prints a warning for files which were modified while reading
+// them. Retries are only printed in verbose mode, failures are reported as
+// errors.
+func (b *textProgress) FileChanged(item string, attempt uint, action archiver.FileChangeMode) {
+	switch action {
+	case archiver.FileChangeRetry:
+		if b.verbosity >= 2 {
+			b.E("%v was modified while reading it, reading it again (attempt %d)\n", item, attempt+1)
+		}
+	case archiver.FileChangeWarn:
+		b.E("warning: %v was modified while reading it, the stored copy may be inconsistent\n", item)
+	}
+}
+
+// HookOutput prints a line of output of a backup hook.
+func (b *textProgress) HookOutput(hook, stream, line string) {
+	if stream == "stderr" {
+		b.E("%v: %v\n", hook, line)
+	} else {
+		b.P("%v: %v\n", hook, line)
+	}
+}
+
 // CompleteItem is the status callback function for the archiver when a
 // file/dir has been saved successfully.
 func (b *textProgress) CompleteItem(messageType, item string, s archiver.ItemStats, d time.Duration) {
@@ -143,6 +166,12 @@ func (b *textProgress) Finish(id restic.ID, summary *archiver.Summary, dryRun bo
 	b.P("%s to the repository: %-5s (%-5s stored)\n", verb,
 		ui.FormatBytes(summary.ItemStats.DataSize+summary.ItemStats.TreeSize),
 		ui.FormatBytes(summary.ItemStats.DataSizeInRepo+summary.ItemStats.TreeSizeInRepo))
+	if summary.IncompressibleBytes > 0 {
+		b.P("Stored uncompressed:  %-5s (incompressible data)\n", ui.FormatBytes(summary.IncompressibleBytes))
+	}
+	if excluded := summary.CompressionSkippedBytes - summary.IncompressibleBytes; excluded > 0 {
+		b.P("Stored uncompressed:  %-5s (excluded by file extension)\n", ui.FormatBytes(excluded))
+	}
 	b.P("\n")
 	b.P("processed %v files, %v in %s",
 		summary.Files.New+summary.Files.Changed+summary.Files.Unchanged,